@@ -0,0 +1,227 @@
+// fakesmtp 是一个只用于本地测试的 SMTP 服务器：接收邮件后打印到终端 (可选保存为 .eml 文件)，不会转发。
+// 配合 mail.driver=smtp 测试邮箱验证、找回密码以及发件箱的重试逻辑。
+//
+// 用法:
+//
+//	go run ./cmd/fakesmtp -addr :2525 -dir /tmp/fakesmtp
+//	ADV_MAIL_DRIVER=smtp ADV_MAIL_SMTP_HOST=localhost ADV_MAIL_SMTP_PORT=2525 go run .
+//
+//	# 要求 AUTH PLAIN 认证 (对应 mail.smtp_username / mail.smtp_password)
+//	go run ./cmd/fakesmtp -user mailer -pass secret
+//	# 模拟发送失败: temp 返回 451 (服务会重试)，perm 返回 554 (邮件直接标记为 Failed)
+//	go run ./cmd/fakesmtp -fail temp
+//
+// 不支持 STARTTLS，net/smtp 只允许在 localhost 上用明文发送密码，所以带认证测试时 smtp_host 要写 localhost。
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type server struct {
+	dir      string
+	user     string
+	pass     string
+	fail     string
+	received atomic.Int64
+}
+
+func main() {
+	addr := flag.String("addr", ":2525", "监听地址")
+	dir := flag.String("dir", "", "保存 .eml 文件的目录，为空则只打印")
+	user := flag.String("user", "", "要求 AUTH PLAIN 认证的用户名，为空则不要求认证")
+	pass := flag.String("pass", "", "AUTH PLAIN 密码")
+	fail := flag.String("fail", "", "模拟发送失败: temp (451) | perm (554)")
+	flag.Parse()
+
+	if *fail != "" && *fail != "temp" && *fail != "perm" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0o755); err != nil {
+			log.Fatalf("创建目录 %s 失败: %v", *dir, err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("监听 %s 失败: %v", *addr, err)
+	}
+	log.Printf("fakesmtp 已启动，监听 %s", ln.Addr())
+
+	s := &server{dir: *dir, user: *user, pass: *pass, fail: *fail}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("接受连接失败: %v", err)
+		}
+		go s.serve(conn)
+	}
+}
+
+// session 一个 SMTP 连接的状态
+type session struct {
+	authed bool
+	from   string
+	rcpts  []string
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) bool {
+		return tp.PrintfLine(format, args...) == nil
+	}
+
+	var sess session
+	reply("220 fakesmtp ESMTP ready")
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-fakesmtp")
+			reply("250-8BITMIME")
+			reply("250 AUTH PLAIN")
+		case "HELO":
+			reply("250 fakesmtp")
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			if !strings.EqualFold(mech, "PLAIN") {
+				reply("504 5.5.4 只支持 AUTH PLAIN")
+				continue
+			}
+			if initial == "" {
+				reply("334 ")
+				if initial, err = tp.ReadLine(); err != nil {
+					return
+				}
+			}
+			if !s.checkPlain(initial) {
+				reply("535 5.7.8 认证失败")
+				continue
+			}
+			sess.authed = true
+			reply("235 2.7.0 认证成功")
+		case "MAIL":
+			if s.user != "" && !sess.authed {
+				reply("530 5.7.0 需要先认证")
+				continue
+			}
+			sess.from, sess.rcpts = addrArg(arg, "FROM:"), nil
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			if sess.from == "" {
+				reply("503 5.5.1 需要先发送 MAIL FROM")
+				continue
+			}
+			sess.rcpts = append(sess.rcpts, addrArg(arg, "TO:"))
+			reply("250 2.1.5 OK")
+		case "DATA":
+			if len(sess.rcpts) == 0 {
+				reply("503 5.5.1 需要先发送 RCPT TO")
+				continue
+			}
+			reply("354 以 <CRLF>.<CRLF> 结束")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			switch s.fail {
+			case "temp":
+				reply("451 4.3.0 模拟的临时错误")
+			case "perm":
+				reply("554 5.7.1 模拟的永久错误")
+			default:
+				s.deliver(sess.from, sess.rcpts, data)
+				reply("250 2.0.0 OK")
+			}
+			sess.from, sess.rcpts = "", nil
+		case "RSET":
+			sess.from, sess.rcpts = "", nil
+			reply("250 2.0.0 OK")
+		case "NOOP":
+			reply("250 2.0.0 OK")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 不支持的命令 %s", verb)
+		}
+	}
+}
+
+// checkPlain 校验 AUTH PLAIN 的凭证 (base64 编码的 "\x00用户名\x00密码")，没有配置 -user 时接受任何凭证
+func (s *server) checkPlain(encoded string) bool {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(string(raw), "\x00")
+	if len(parts) != 3 {
+		return false
+	}
+	return s.user == "" || (parts[1] == s.user && parts[2] == s.pass)
+}
+
+// addrArg 从 "FROM:<a@b.com> SIZE=123" 这样的参数中取出地址
+func addrArg(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	return strings.Trim(addr, "<>")
+}
+
+// deliver 打印邮件 (解码主题和 quoted-printable 正文)，并按需保存原始报文
+func (s *server) deliver(from string, rcpts []string, data []byte) {
+	n := s.received.Add(1)
+	fmt.Printf("===== 邮件 #%d  %s -> %s =====\n", n, from, strings.Join(rcpts, ", "))
+
+	msg, err := netmail.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		fmt.Printf("(无法解析邮件: %v)\n%s\n", err, data)
+	} else {
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil {
+			subject = msg.Header.Get("Subject")
+		}
+		fmt.Printf("Subject: %s\n\n", subject)
+		var body io.Reader = msg.Body
+		if strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+			body = quotedprintable.NewReader(body)
+		}
+		text, _ := io.ReadAll(body)
+		fmt.Printf("%s\n", strings.ReplaceAll(string(text), "\r\n", "\n"))
+	}
+
+	if s.dir != "" {
+		name := filepath.Join(s.dir, fmt.Sprintf("%s-%d.eml", time.Now().UTC().Format("20060102T150405Z"), n))
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			log.Printf("保存邮件失败: %v", err)
+		} else {
+			log.Printf("邮件已保存到 %s", name)
+		}
+	}
+}
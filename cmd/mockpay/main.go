@@ -0,0 +1,84 @@
+// mockpay 模拟支付渠道，向服务发送带 HMAC 签名的支付回调，用于在本地测试完整的充值流程。
+//
+// 用法:
+//
+//	curl -X POST localhost:8080/recharge -H "Authorization: Bearer $TOKEN" -d '{"amount": 10}'
+//	# 响应中的 recharge_id 为 12，金额 1000 分
+//	go run ./cmd/mockpay -recharge 12 -amount 1000
+//	go run ./cmd/mockpay -recharge 13 -amount 500 -status failed
+//
+// 签名密钥默认读取 ADV_PAYMENT_WEBHOOK_SECRET，未设置时使用开发环境的默认密钥。
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"advertisement/internal/config"
+	"advertisement/internal/payment"
+)
+
+func main() {
+	secretDefault := os.Getenv("ADV_PAYMENT_WEBHOOK_SECRET")
+	if secretDefault == "" {
+		secretDefault = config.DefaultWebhookSecret
+	}
+
+	url := flag.String("url", "http://localhost:8080/payments/webhook", "回调地址")
+	secret := flag.String("secret", secretDefault, "回调签名密钥 (payment.webhook_secret)")
+	rechargeID := flag.Int64("recharge", 0, "充值记录 ID (POST /recharge 响应中的 recharge_id)")
+	amount := flag.Int64("amount", 0, "支付金额，单位：分")
+	status := flag.String("status", string(payment.StatusSucceeded), "支付结果: succeeded | failed")
+	eventID := flag.String("event", "", "事件 ID，默认为 evt_<支付单号>_<status>")
+	txID := flag.String("tx", "", "支付渠道交易流水号，默认由 mock 渠道生成")
+	skew := flag.Duration("skew", 0, "签名时间戳偏移 (例如 -10m，用于测试过期签名)")
+	flag.Parse()
+
+	if *rechargeID <= 0 || *amount <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ref := payment.MockProviderRef(*rechargeID)
+	event := payment.Event{
+		ID:            *eventID,
+		ProviderRef:   ref,
+		RechargeID:    *rechargeID,
+		Amount:        *amount,
+		Status:        payment.Status(*status),
+		TransactionID: *txID,
+	}
+	if event.ID == "" {
+		event.ID = fmt.Sprintf("evt_%s_%s", ref, *status)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Fatalf("mockpay: 编码回调事件失败: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(payload))
+	if err != nil {
+		log.Fatalf("mockpay: 创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(payment.SignatureHeader, payment.SignPayload([]byte(*secret), time.Now().Add(*skew), payload))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("mockpay: 发送回调失败: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	fmt.Printf("%s -> %s\n%s\n", payload, resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode >= 300 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// --- API Key ---
+// 格式: adv_<prefix>_<secret>，prefix 是 8 位十六进制 (可以在界面上展示，用于查找)，
+// secret 是 256 位随机数。数据库只保存完整 Key 的 SHA-256。
+
+const apiKeyPrefix = "adv_"
+
+// API Key 的权限范围 (scopes)，不指定时不限制
+const (
+	ScopeRead  = "read"  // 只能调用 GET / HEAD 接口
+	ScopeWrite = "write" // 可以调用所有接口 (包含 read)
+)
+
+// ValidScope 判断 scope 是否受支持
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// GenerateAPIKey 生成一个新的 API Key，返回交给用户的明文 (只展示一次)、前缀和哈希
+func GenerateAPIKey() (key string, prefix string, hash string, err error) {
+	if prefix, err = randomHex(4); err != nil {
+		return "", "", "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", errors.New("auth: 无法生成 API Key")
+	}
+	key = apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(b)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// ParseAPIKeyPrefix 从 API Key 明文中取出前缀，格式不正确时返回 false
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 8 || secret == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(prefix); err != nil {
+		return "", false
+	}
+	return prefix, true
+}
+
+// HashAPIKey 计算 API Key 的 SHA-256 (十六进制)
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyMatches 以常量时间比较 API Key 明文和保存的哈希
+func APIKeyMatches(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) == 1
+}
+
+// Allows 判断 Claims 的权限范围是否允许该 HTTP 方法。
+// 通过 JWT 登录或没有限制 scopes 的 API Key 不受限制。
+func (c *Claims) Allows(method string) bool {
+	if len(c.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range c.Scopes {
+		if scope == ScopeWrite {
+			return true
+		}
+		if scope == ScopeRead && (method == http.MethodGet || method == http.MethodHead) {
+			return true
+		}
+	}
+	return false
+}
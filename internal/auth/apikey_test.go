@@ -0,0 +1,97 @@
+package auth_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"advertisement/internal/auth"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "adv_"+prefix+"_") {
+		t.Errorf("key %q does not start with adv_%s_", key, prefix)
+	}
+	if got, ok := auth.ParseAPIKeyPrefix(key); !ok || got != prefix {
+		t.Errorf("ParseAPIKeyPrefix() = %q, %v, want %q", got, ok, prefix)
+	}
+	if hash != auth.HashAPIKey(key) || !auth.APIKeyMatches(key, hash) {
+		t.Error("generated hash does not match the key")
+	}
+	if auth.APIKeyMatches(key+"x", hash) || auth.APIKeyMatches(key, auth.HashAPIKey("other")) {
+		t.Error("APIKeyMatches accepted a different key")
+	}
+	other, otherPrefix, _, _ := auth.GenerateAPIKey()
+	if other == key || otherPrefix == prefix {
+		t.Error("two generated keys are equal")
+	}
+}
+
+func TestParseAPIKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key    string
+		prefix string
+		ok     bool
+	}{
+		{"adv_0123abcd_c2VjcmV0", "0123abcd", true},
+		{"adv_0123abcd_with_underscore", "0123abcd", true},
+		{"adv_0123abcd_", "", false},
+		{"adv_0123abcd", "", false},
+		{"adv_0123abc_secret", "", false},
+		{"adv_0123abcde_secret", "", false},
+		{"adv_0123abcz_secret", "", false},
+		{"ADV_0123abcd_secret", "", false},
+		{"key_0123abcd_secret", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			prefix, ok := auth.ParseAPIKeyPrefix(tt.key)
+			if prefix != tt.prefix || ok != tt.ok {
+				t.Errorf("ParseAPIKeyPrefix(%q) = %q, %v, want %q, %v", tt.key, prefix, ok, tt.prefix, tt.ok)
+			}
+		})
+	}
+}
+
+func TestClaimsAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		method string
+		want   bool
+	}{
+		{"no scopes GET", nil, http.MethodGet, true},
+		{"no scopes POST", nil, http.MethodPost, true},
+		{"read GET", []string{auth.ScopeRead}, http.MethodGet, true},
+		{"read HEAD", []string{auth.ScopeRead}, http.MethodHead, true},
+		{"read POST", []string{auth.ScopeRead}, http.MethodPost, false},
+		{"read DELETE", []string{auth.ScopeRead}, http.MethodDelete, false},
+		{"read PATCH", []string{auth.ScopeRead}, http.MethodPatch, false},
+		{"write POST", []string{auth.ScopeWrite}, http.MethodPost, true},
+		{"write GET", []string{auth.ScopeWrite}, http.MethodGet, true},
+		{"read and write DELETE", []string{auth.ScopeRead, auth.ScopeWrite}, http.MethodDelete, true},
+		{"unknown scope", []string{"admin"}, http.MethodGet, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &auth.Claims{Scopes: tt.scopes}
+			if got := c.Allows(tt.method); got != tt.want {
+				t.Errorf("Allows(%s) with scopes %v = %v, want %v", tt.method, tt.scopes, got, tt.want)
+			}
+		})
+	}
+
+	for _, scope := range []string{auth.ScopeRead, auth.ScopeWrite} {
+		if !auth.ValidScope(scope) {
+			t.Errorf("ValidScope(%q) = false", scope)
+		}
+	}
+	if auth.ValidScope("admin") || auth.ValidScope("") {
+		t.Error("ValidScope accepted an unknown scope")
+	}
+}
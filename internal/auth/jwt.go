@@ -1,40 +1,190 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time" // 需要 time
 
 	"github.com/golang-jwt/jwt/v5" // 需要 jwt
+
+	"advertisement/internal/config"
 )
 
-// !!! 重要的安全提示 !!!
-// 在真实应用中，绝不要把密钥硬编码在代码里！
-// 应该从环境变量或安全配置中读取。
-var JwtKey = []byte("my_super_secret_signing_key_123!@#")
+// JwtKey 是 HS256 签名密钥，由 Configure 从配置 (jwt.secret / ADV_JWT_SECRET) 设置，
+// 不再硬编码在代码里。只在 jwt.algorithm=HS256 时使用。
+var JwtKey []byte
+
+// algorithm 是签发访问令牌使用的算法 (jwt.algorithm)
+var algorithm = AlgHS256
+
+// keyRing 是 RS256 / EdDSA 使用的密钥环 (jwt.keys_dir)，HS256 时为 nil
+var keyRing *KeyRing
+
+// tokenTTL 是访问令牌有效期，由 Configure 从配置 (jwt.ttl) 设置
+var tokenTTL = 15 * time.Minute
+
+// refreshTTL 是刷新令牌有效期，由 Configure 从配置 (jwt.refresh_ttl) 设置
+var refreshTTL = 30 * 24 * time.Hour
+
+// Configure 使用配置初始化签名密钥和有效期，必须在签发或校验 Token 之前调用。
+// RS256 / EdDSA 会从 jwt.keys_dir 加载密钥环，目录中没有私钥时返回 ErrNoSigningKey。
+func Configure(cfg config.JWTConfig) error {
+	JwtKey = []byte(cfg.Secret)
+	if cfg.TTL.Duration > 0 {
+		tokenTTL = cfg.TTL.Duration
+	}
+	if cfg.RefreshTTL.Duration > 0 {
+		refreshTTL = cfg.RefreshTTL.Duration
+	}
+
+	algorithm = cfg.Algorithm
+	keyRing = nil
+	if algorithm == AlgHS256 {
+		return nil
+	}
+	ring, err := LoadKeyRing(cfg.KeysDir, cfg.Algorithm, cfg.ActiveKID, cfg.RotationOverlap.Duration)
+	if err != nil {
+		return err
+	}
+	keyRing = ring
+	return nil
+}
+
+// ReloadKeys 重新读取密钥目录 (轮换密钥后不需要重启服务)，HS256 时什么也不做
+func ReloadKeys() error {
+	if keyRing == nil {
+		return nil
+	}
+	return keyRing.Reload(time.Now())
+}
+
+// JWKS 返回当前可用于校验访问令牌的公钥集合；HS256 时为空 (对称密钥不能公开)
+func JWKS() JWKSet {
+	if keyRing == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+	return keyRing.JWKS()
+}
+
+// RefreshTTL 返回刷新令牌的有效期
+func RefreshTTL() time.Duration {
+	return refreshTTL
+}
 
 type Claims struct {
 	Username string `json:"username"`
 	UserID   int    `json:"user_id"`
 	Role     string `json:"role"` // <-- 新增 Role 字段
+	// Enrollment 受限令牌：账号必须启用两步验证但尚未绑定，只能访问两步验证绑定相关接口
+	Enrollment bool `json:"enroll,omitempty"`
 	jwt.RegisteredClaims
+
+	// 以下字段只在通过 API Key 认证时由 AuthMiddleware 设置，不写入 JWT
+	APIKeyID int64    `json:"-"` // 非 0 表示本次请求使用 API Key 认证
+	Scopes   []string `json:"-"` // API Key 的权限范围，空表示不限制
 }
 
-// GenerateJWT 生成一个新的 JWT 字符串
-func GenerateJWT(userID int, username string, role string) (string, time.Time, error) { // <-- 添加 role 参数 {
-    // 设置过期时间 (例如，1 小时)
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateJWT 生成一个新的访问令牌，返回 token 字符串、jti (用于吊销) 和过期时间。
+// enrollmentOnly 为 true 时签发只能用于绑定两步验证的受限令牌。
+func GenerateJWT(userID int, username string, role string, enrollmentOnly bool) (string, string, time.Time, error) { // <-- 添加 role 参数 {
+	if keyRing == nil && len(JwtKey) == 0 {
+		return "", "", time.Time{}, errors.New("auth: JWT 签名密钥未配置")
+	}
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+    // 设置过期时间 (由配置 jwt.ttl 决定)
+	expirationTime := time.Now().Add(tokenTTL)
 
 	claims := &Claims{
 		Username: username,
 		UserID:   userID,
 		Role:     role, // <-- 将 role 加入 Claims
+		Enrollment: enrollmentOnly,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti, // 吊销列表按 jti 记录
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   username, // 可以用 UserID 的字符串形式或 Username
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKey) // 使用导出的 JwtKey
+	token := jwt.NewWithClaims(signingMethod(algorithm), claims)
+	var tokenString string
+	if keyRing != nil {
+		// 非对称签名：在 header 中写入 kid，校验方按 kid 从 JWKS 中找公钥
+		key := keyRing.SigningKey()
+		token.Header["kid"] = key.ID
+		tokenString, err = token.SignedString(key.signer)
+	} else {
+		tokenString, err = token.SignedString(JwtKey) // 使用导出的 JwtKey
+	}
+
+	return tokenString, jti, expirationTime, err // 同时返回 token 字符串、jti、过期时间和错误
+}
+
+// ParseJWT 校验访问令牌的签名和有效期并解析 Claims。
+// 只接受 jwt.algorithm 配置的算法；非对称算法按 header 中的 kid 从密钥环中选择公钥。
+func ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if keyRing == nil {
+			return JwtKey, nil
+		}
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("auth: Token 缺少 kid")
+		}
+		return keyRing.PublicKey(kid)
+	}, jwt.WithValidMethods([]string{signingMethod(algorithm).Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("auth: 无效的 Token: %w", err)
+	}
+	return claims, nil
+}
+
+// NewSessionID 生成一个登录会话 ID (同一次登录轮换出的刷新令牌共享该 ID)
+func NewSessionID() (string, error) {
+	return randomHex(16)
+}
+
+// NewRefreshToken 生成一个随机的刷新令牌，返回交给客户端的明文和存入数据库的哈希。
+// 数据库只保存哈希，泄露数据库也无法直接使用刷新令牌。
+func NewRefreshToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.New("auth: 无法生成刷新令牌")
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken 计算刷新令牌的 SHA-256 (十六进制)。
+// 刷新令牌本身是 256 位随机数，不需要加盐或慢哈希。
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("auth: 无法生成随机 ID")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewUserToken 生成邮箱验证 / 重置密码使用的一次性令牌，生成和存储方式与刷新令牌相同 (数据库只保存哈希)
+func NewUserToken() (token string, hash string, err error) {
+	return NewRefreshToken()
+}
 
-	return tokenString, expirationTime, err // 同时返回 token 字符串、过期时间和错误
+// HashUserToken 计算一次性令牌的 SHA-256 (十六进制)
+func HashUserToken(token string) string {
+	return HashRefreshToken(token)
 }
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// --- 签名算法 ---
+const (
+	AlgHS256 = "HS256" // 对称密钥 (jwt.secret)，只适合单个服务自己校验
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA" // Ed25519
+)
+
+// ErrNoSigningKey 密钥目录中没有可用于签名的私钥
+var ErrNoSigningKey = errors.New("auth: 密钥目录中没有可用于签名的私钥")
+
+// Key 是密钥环中的一个密钥。kid 取自文件名 (<kid>.pem)。
+type Key struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time // 文件修改时间，用于计算轮换的重叠窗口
+
+	signer crypto.Signer    // 私钥；只有公钥文件时为 nil (只能校验)
+	public crypto.PublicKey // 公钥
+}
+
+// KeyRing 从目录加载的一组非对称密钥：
+//   - 当前签名密钥 (active)：jwt.active_kid 指定，未指定时使用最新的私钥
+//   - 比当前密钥新的密钥：提前发布到 JWKS，方便其他服务预先缓存，之后再切换为签名密钥
+//   - 比当前密钥旧的密钥：在当前密钥启用后的重叠窗口 (jwt.rotation_overlap) 内仍可校验，之后不再接受
+type KeyRing struct {
+	dir       string
+	alg       string
+	activeKID string
+	overlap   time.Duration
+
+	mu     sync.RWMutex
+	active *Key
+	keys   map[string]*Key // 当前可用于校验的密钥 (包括 active)
+}
+
+// LoadKeyRing 加载 dir 中的所有 <kid>.pem 文件 (PKCS#8 私钥或 PKIX 公钥)
+func LoadKeyRing(dir, alg, activeKID string, overlap time.Duration) (*KeyRing, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("auth: 密钥环不支持算法 %q", alg)
+	}
+	ring := &KeyRing{dir: dir, alg: alg, activeKID: activeKID, overlap: overlap}
+	if err := ring.Reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Reload 重新读取密钥目录，用于不重启服务完成轮换。失败时保留原来的密钥。
+func (k *KeyRing) Reload(now time.Time) error {
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w (%s 不存在)", ErrNoSigningKey, k.dir)
+		}
+		return fmt.Errorf("auth: 读取密钥目录 %s 失败: %w", k.dir, err)
+	}
+
+	var all []*Key
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+		key, err := loadKeyFile(filepath.Join(k.dir, entry.Name()), k.alg)
+		if err != nil {
+			return err
+		}
+		all = append(all, key)
+	}
+
+	// 确定签名密钥
+	var active *Key
+	for _, key := range all {
+		if key.signer == nil {
+			continue
+		}
+		if k.activeKID != "" {
+			if key.ID == k.activeKID {
+				active = key
+			}
+		} else if active == nil || key.CreatedAt.After(active.CreatedAt) ||
+			(key.CreatedAt.Equal(active.CreatedAt) && key.ID > active.ID) {
+			active = key
+		}
+	}
+	if active == nil {
+		if k.activeKID != "" {
+			return fmt.Errorf("%w (找不到 jwt.active_kid=%s 的私钥)", ErrNoSigningKey, k.activeKID)
+		}
+		return fmt.Errorf("%w (%s)", ErrNoSigningKey, k.dir)
+	}
+
+	// 旧密钥只在重叠窗口内保留
+	keys := make(map[string]*Key, len(all))
+	retireAt := active.CreatedAt.Add(k.overlap)
+	for _, key := range all {
+		if key != active && key.CreatedAt.Before(active.CreatedAt) && !now.Before(retireAt) {
+			continue
+		}
+		keys[key.ID] = key
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = active
+	k.keys = keys
+	return nil
+}
+
+// SigningKey 返回当前用于签名的密钥
+func (k *KeyRing) SigningKey() *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// PublicKey 按 kid 返回校验用的公钥，kid 未知或已过重叠窗口时返回错误
+func (k *KeyRing) PublicKey(kid string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("auth: 未知的密钥 kid=%q", kid)
+	}
+	return key.public, nil
+}
+
+// JWKS 返回所有可用于校验的公钥 (按 kid 排序)
+func (k *KeyRing) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := JWKSet{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// signingMethod 返回算法对应的 jwt 签名方法
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func loadKeyFile(path, alg string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: 读取密钥文件 %s 失败: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: 读取密钥文件 %s 失败: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("auth: 密钥文件 %s 不是 PEM 格式", path)
+	}
+
+	key := &Key{
+		ID:        strings.TrimSuffix(filepath.Base(path), ".pem"),
+		Algorithm: alg,
+		CreatedAt: info.ModTime(),
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("auth: 解析私钥 %s 失败: %w", path, err)
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("auth: 私钥 %s 不支持签名", path)
+		}
+		key.signer = signer
+		key.public = signer.Public()
+	case "PUBLIC KEY":
+		if key.public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("auth: 解析公钥 %s 失败: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("auth: 密钥文件 %s 的 PEM 类型 %q 不受支持 (需要 PKCS#8 私钥或 PKIX 公钥)", path, block.Type)
+	}
+
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if alg != AlgRS256 {
+			return nil, fmt.Errorf("auth: 密钥 %s 是 RSA 密钥，与 jwt.algorithm=%s 不匹配", path, alg)
+		}
+	case ed25519.PublicKey:
+		if alg != AlgEdDSA {
+			return nil, fmt.Errorf("auth: 密钥 %s 是 Ed25519 密钥，与 jwt.algorithm=%s 不匹配", path, alg)
+		}
+	default:
+		return nil, fmt.Errorf("auth: 密钥 %s 的类型 %T 不受支持", path, key.public)
+	}
+	return key, nil
+}
+
+// GenerateKeyFile 在 dir 中生成一个新的私钥文件，kid 为当前 UTC 时间 (按字典序即按时间排序)，返回 kid
+func GenerateKeyFile(dir, alg string) (string, error) {
+	var priv crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("auth: 不能为算法 %q 生成密钥", alg)
+	}
+	if err != nil {
+		return "", fmt.Errorf("auth: 生成密钥失败: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("auth: 编码私钥失败: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("auth: 创建密钥目录 %s 失败: %w", dir, err)
+	}
+	kid := time.Now().UTC().Format("20060102T150405Z")
+	path := filepath.Join(dir, kid+".pem")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("auth: 创建密钥文件失败: %w", err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return "", fmt.Errorf("auth: 写入密钥文件 %s 失败: %w", path, err)
+	}
+	return kid, nil
+}
+
+// --- JWKS (RFC 7517) ---
+
+// JWKSet 是 GET /.well-known/jwks.json 的响应
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK 一个公钥。RSA 使用 n / e，Ed25519 (OKP) 使用 crv / x。
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+func (key *Key) jwk() JWK {
+	jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
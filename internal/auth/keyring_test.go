@@ -0,0 +1,317 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/config"
+)
+
+// writeKey 在 dir 中写入 <kid>.pem 并把修改时间设为 created，publicOnly 时只写公钥
+func writeKey(t *testing.T, dir, kid, alg string, created time.Time, publicOnly bool) crypto.Signer {
+	t.Helper()
+	var priv crypto.Signer
+	var err error
+	switch alg {
+	case auth.AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case auth.AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY"}
+	if publicOnly {
+		block.Type = "PUBLIC KEY"
+		block.Bytes, err = x509.MarshalPKIXPublicKey(priv.Public())
+	} else {
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(priv)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, kid+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, created, created); err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// kids 返回 JWKS 中的 kid (已按 kid 排序)
+func kids(set auth.JWKSet) []string {
+	var ids []string
+	for _, key := range set.Keys {
+		ids = append(ids, key.KeyID)
+	}
+	return ids
+}
+
+func TestKeyRingSelection(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	writeKey(t, dir, "old", auth.AlgEdDSA, now.Add(-48*time.Hour), false)
+	writeKey(t, dir, "current", auth.AlgEdDSA, now.Add(-time.Hour), false)
+	// 只有公钥的新密钥：提前发布，但不能用于签名
+	writeKey(t, dir, "next", auth.AlgEdDSA, now, true)
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a key"), 0o600)
+
+	tests := []struct {
+		name       string
+		activeKID  string
+		overlap    time.Duration
+		wantActive string
+		wantKIDs   []string
+		wantErr    error
+	}{
+		{"newest private key", "", 24 * time.Hour, "current", []string{"current", "next", "old"}, nil},
+		{"old key retired after overlap", "", 30 * time.Minute, "current", []string{"current", "next"}, nil},
+		{"active kid pins an older key", "old", time.Nanosecond, "old", []string{"current", "next", "old"}, nil},
+		{"active kid without private key", "next", time.Hour, "", nil, auth.ErrNoSigningKey},
+		{"unknown active kid", "missing", time.Hour, "", nil, auth.ErrNoSigningKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := auth.LoadKeyRing(dir, auth.AlgEdDSA, tt.activeKID, tt.overlap)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoadKeyRing() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := ring.SigningKey().ID; got != tt.wantActive {
+				t.Errorf("SigningKey() = %s, want %s", got, tt.wantActive)
+			}
+			if got := kids(ring.JWKS()); !slices.Equal(got, tt.wantKIDs) {
+				t.Errorf("JWKS kids = %v, want %v", got, tt.wantKIDs)
+			}
+			for _, kid := range tt.wantKIDs {
+				if _, err := ring.PublicKey(kid); err != nil {
+					t.Errorf("PublicKey(%s) = %v", kid, err)
+				}
+			}
+			if _, err := ring.PublicKey("missing"); err == nil {
+				t.Error("PublicKey(missing) succeeded")
+			}
+		})
+	}
+}
+
+func TestKeyRingLoadErrors(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		setup func(dir string)
+		alg   string
+		want  error // 为 nil 时只要求返回错误
+	}{
+		{"missing dir", func(dir string) { os.Remove(dir) }, auth.AlgEdDSA, auth.ErrNoSigningKey},
+		{"empty dir", func(string) {}, auth.AlgEdDSA, auth.ErrNoSigningKey},
+		{"only public keys", func(dir string) { writeKey(t, dir, "pub", auth.AlgEdDSA, now, true) }, auth.AlgEdDSA, auth.ErrNoSigningKey},
+		{"rsa key in EdDSA ring", func(dir string) { writeKey(t, dir, "rsa", auth.AlgRS256, now, false) }, auth.AlgEdDSA, nil},
+		{"ed25519 key in RS256 ring", func(dir string) { writeKey(t, dir, "ed", auth.AlgEdDSA, now, false) }, auth.AlgRS256, nil},
+		{"not PEM", func(dir string) { os.WriteFile(filepath.Join(dir, "bad.pem"), []byte("garbage"), 0o600) }, auth.AlgEdDSA, nil},
+		{"HS256", func(dir string) { writeKey(t, dir, "ed", auth.AlgEdDSA, now, false) }, auth.AlgHS256, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "keys")
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(dir)
+			_, err := auth.LoadKeyRing(dir, tt.alg, "", time.Hour)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("LoadKeyRing() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRingReload(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	writeKey(t, dir, "a", auth.AlgEdDSA, now.Add(-2*time.Hour), false)
+	ring, err := auth.LoadKeyRing(dir, auth.AlgEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 轮换：新密钥成为签名密钥，旧密钥在重叠窗口内仍可校验，窗口结束后移除
+	writeKey(t, dir, "b", auth.AlgEdDSA, now.Add(-time.Minute), false)
+	if err := ring.Reload(now); err != nil {
+		t.Fatal(err)
+	}
+	if ring.SigningKey().ID != "b" || !slices.Equal(kids(ring.JWKS()), []string{"a", "b"}) {
+		t.Errorf("after rotation: active %s, kids %v", ring.SigningKey().ID, kids(ring.JWKS()))
+	}
+	if err := ring.Reload(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(kids(ring.JWKS()), []string{"b"}) {
+		t.Errorf("after overlap: kids %v, want [b]", kids(ring.JWKS()))
+	}
+
+	// 加载失败时保留原来的密钥
+	os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("garbage"), 0o600)
+	if err := ring.Reload(now); err == nil {
+		t.Fatal("Reload() with a broken key file succeeded")
+	}
+	if ring.SigningKey().ID != "b" {
+		t.Errorf("active key after failed reload = %s, want b", ring.SigningKey().ID)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	edDir, rsaDir := t.TempDir(), t.TempDir()
+	ed := writeKey(t, edDir, "ed", auth.AlgEdDSA, now, false)
+	writeKey(t, rsaDir, "rsa", auth.AlgRS256, now, false)
+
+	edRing, err := auth.LoadKeyRing(edDir, auth.AlgEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := auth.JWK{
+		KeyType: "OKP", KeyID: "ed", Use: "sig", Algorithm: auth.AlgEdDSA, Curve: "Ed25519",
+		X: base64.RawURLEncoding.EncodeToString(ed.Public().(ed25519.PublicKey)),
+	}
+	if keys := edRing.JWKS().Keys; len(keys) != 1 || keys[0] != want {
+		t.Errorf("EdDSA JWKS = %+v, want [%+v]", keys, want)
+	}
+
+	rsaRing, err := auth.LoadKeyRing(rsaDir, auth.AlgRS256, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := rsaRing.JWKS().Keys
+	if len(keys) != 1 || keys[0].KeyType != "RSA" || keys[0].KeyID != "rsa" || keys[0].Algorithm != auth.AlgRS256 ||
+		keys[0].E != "AQAB" || keys[0].N == "" || keys[0].X != "" {
+		t.Errorf("RS256 JWKS = %+v", keys)
+	}
+}
+
+// hs256 返回使用默认密钥的 HS256 配置
+func hs256() config.JWTConfig {
+	cfg := config.Default().JWT
+	cfg.Algorithm = auth.AlgHS256
+	return cfg
+}
+
+// configure 设置 auth 包的全局配置，测试结束后恢复为 HS256
+func configure(t *testing.T, cfg config.JWTConfig) {
+	t.Helper()
+	if err := auth.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auth.Configure(hs256()) })
+}
+
+func TestJWTKeySelection(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	writeKey(t, dir, "old", auth.AlgEdDSA, now.Add(-2*time.Hour), false)
+	writeKey(t, dir, "new", auth.AlgEdDSA, now.Add(-time.Hour), false)
+	jwtConfig := func(activeKID string, overlap time.Duration) config.JWTConfig {
+		cfg := config.Default().JWT
+		cfg.Algorithm, cfg.KeysDir, cfg.ActiveKID = auth.AlgEdDSA, dir, activeKID
+		cfg.RotationOverlap.Duration = overlap
+		return cfg
+	}
+	kidOf := func(token string) string {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kid, _ := parsed.Header["kid"].(string)
+		return kid
+	}
+
+	// 轮换前用旧密钥签发的令牌
+	configure(t, jwtConfig("old", time.Hour))
+	oldToken, _, _, err := auth.GenerateJWT(1, "alice", "advertiser", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := kidOf(oldToken); kid != "old" {
+		t.Fatalf("kid = %q, want old", kid)
+	}
+
+	configure(t, jwtConfig("", 2*time.Hour))
+	newToken, jti, _, err := auth.GenerateJWT(1, "alice", "advertiser", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := kidOf(newToken); kid != "new" {
+		t.Errorf("kid = %q, want new", kid)
+	}
+	claims, err := auth.ParseJWT(newToken)
+	if err != nil || claims.UserID != 1 || claims.Role != "advertiser" || claims.ID != jti {
+		t.Errorf("ParseJWT() = %+v, %v", claims, err)
+	}
+	if _, err := auth.ParseJWT(oldToken); err != nil {
+		t.Errorf("token of the old key within the overlap: %v", err)
+	}
+
+	// 重叠窗口已结束：旧密钥签发的令牌不再接受
+	configure(t, jwtConfig("", 30*time.Minute))
+	if _, err := auth.ParseJWT(oldToken); err == nil {
+		t.Error("token of a retired key accepted")
+	}
+
+	// 其他令牌
+	hsToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{UserID: 1}).SignedString([]byte(config.DefaultJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noKID := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &auth.Claims{UserID: 1})
+	_, foreign, _ := ed25519.GenerateKey(rand.Reader)
+	noKIDToken, _ := noKID.SignedString(foreign)
+	unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &auth.Claims{UserID: 1})
+	unknown.Header["kid"] = "unknown"
+	unknownToken, _ := unknown.SignedString(foreign)
+	forged := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &auth.Claims{UserID: 1})
+	forged.Header["kid"] = "new"
+	forgedToken, _ := forged.SignedString(foreign)
+	for name, token := range map[string]string{
+		"HS256 token":         hsToken,
+		"missing kid":         noKIDToken,
+		"unknown kid":         unknownToken,
+		"signed by other key": forgedToken,
+		"tampered":            newToken[:len(newToken)-2] + "AA",
+		"not a token":         "abc",
+	} {
+		if _, err := auth.ParseJWT(token); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+
+	// 切换回 HS256 后只接受 HS256 令牌
+	configure(t, hs256())
+	if _, err := auth.ParseJWT(hsToken); err != nil {
+		t.Errorf("HS256 token rejected: %v", err)
+	}
+	if _, err := auth.ParseJWT(newToken); err == nil {
+		t.Error("EdDSA token accepted with HS256 configured")
+	}
+	if len(auth.JWKS().Keys) != 0 {
+		t.Errorf("HS256 publishes keys: %+v", auth.JWKS())
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import "slices"
+
+// --- 权限 ---
+// 路由按权限控制 (middleware.RequirePermission)，角色只是权限的集合
+const (
+	PermAdsReview        = "ads:review"        // 审核广告创意
+	PermCampaignsReview  = "campaigns:review"  // 审核广告活动
+	PermCampaignsRead    = "campaigns:read"    // 查看任意广告活动的状态历史
+	PermInvoicesProcess  = "invoices:process"  // 处理发票请求
+	PermLedgerRead       = "ledger:read"       // 核对余额与账本
+	PermLedgerWrite      = "ledger:write"      // 手工记账 (调账 / 赠送额度 / 退款)
+	PermUsersRead        = "users:read"        // 查看用户列表和用户详情
+	PermUsersManage      = "users:manage"      // 分配角色、停用账号、管理两步验证和登录锁定
+	PermAuditRead        = "audit:read"        // 查看审计日志
+	PermPlacementsManage = "placements:manage" // 创建、修改和停用广告位
+)
+
+// --- 角色 ---
+// 每个用户只有一个角色 (users.role)
+const (
+	RoleUser       = "user" // 广告主，没有后台权限
+	RoleReviewer   = "reviewer"
+	RoleFinance    = "finance"
+	RoleSupport    = "support"
+	RoleSuperAdmin = "superadmin"
+)
+
+// rolePermissions 角色 -> 权限。superadmin 拥有所有权限。
+var rolePermissions = map[string][]string{
+	RoleUser:     {},
+	RoleReviewer: {PermAdsReview, PermCampaignsReview, PermCampaignsRead},
+	RoleFinance:  {PermInvoicesProcess, PermLedgerRead, PermLedgerWrite},
+	RoleSupport:  {PermCampaignsRead, PermLedgerRead, PermUsersRead},
+	RoleSuperAdmin: {
+		PermAdsReview, PermCampaignsReview, PermCampaignsRead,
+		PermInvoicesProcess, PermLedgerRead, PermLedgerWrite, PermUsersRead, PermUsersManage,
+		PermAuditRead, PermPlacementsManage,
+	},
+}
+
+// Roles 返回所有角色 (按权限从少到多的固定顺序)
+func Roles() []string {
+	return []string{RoleUser, RoleReviewer, RoleFinance, RoleSupport, RoleSuperAdmin}
+}
+
+// ValidRole 检查角色是否存在
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions 返回角色拥有的权限 (未知角色返回空切片)
+func RolePermissions(role string) []string {
+	return slices.Clone(rolePermissions[role])
+}
+
+// HasPermission 检查角色是否拥有权限
+func HasPermission(role, perm string) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+// --- 组织角色 ---
+// 成员在组织内的角色 (organization_members.role)，与上面的平台角色相互独立
+const (
+	OrgRoleOwner   = "owner"   // 管理成员和邀请，以及 manager 的所有操作
+	OrgRoleManager = "manager" // 管理组织的广告创意、广告活动、充值和发票
+	OrgRoleViewer  = "viewer"  // 只读
+)
+
+// OrgRoles 返回所有组织角色 (按权限从多到少)
+func OrgRoles() []string {
+	return []string{OrgRoleOwner, OrgRoleManager, OrgRoleViewer}
+}
+
+// ValidOrgRole 检查组织角色是否存在
+func ValidOrgRole(role string) bool {
+	return slices.Contains(OrgRoles(), role)
+}
+
+// CanWriteOrg 检查组织角色能否修改组织的资源
+func CanWriteOrg(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleManager
+}
@@ -0,0 +1,54 @@
+package auth_test
+
+import (
+	"slices"
+	"testing"
+
+	"advertisement/internal/auth"
+)
+
+func TestRolePermissions(t *testing.T) {
+	perms := []string{
+		auth.PermAdsReview, auth.PermCampaignsReview, auth.PermCampaignsRead,
+		auth.PermInvoicesProcess, auth.PermLedgerRead, auth.PermLedgerWrite, auth.PermUsersRead, auth.PermUsersManage, auth.PermAuditRead, auth.PermPlacementsManage,
+	}
+	// 每个角色拥有的权限，不在列表中的权限必须被拒绝
+	tests := []struct {
+		role string
+		want []string
+	}{
+		{auth.RoleUser, nil},
+		{auth.RoleReviewer, []string{auth.PermAdsReview, auth.PermCampaignsReview, auth.PermCampaignsRead}},
+		{auth.RoleFinance, []string{auth.PermInvoicesProcess, auth.PermLedgerRead, auth.PermLedgerWrite}},
+		{auth.RoleSupport, []string{auth.PermCampaignsRead, auth.PermLedgerRead, auth.PermUsersRead}},
+		{auth.RoleSuperAdmin, perms},
+		{"admin", nil}, // 旧的 admin 角色已迁移为 superadmin，不再有权限
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			for _, perm := range perms {
+				if got, want := auth.HasPermission(tt.role, perm), slices.Contains(tt.want, perm); got != want {
+					t.Errorf("HasPermission(%q, %q) = %v, want %v", tt.role, perm, got, want)
+				}
+			}
+			got := auth.RolePermissions(tt.role)
+			slices.Sort(got)
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			if len(got) != len(want) || !slices.Equal(got, want) {
+				t.Errorf("RolePermissions(%q) = %v, want %v", tt.role, got, want)
+			}
+			if valid := auth.ValidRole(tt.role); valid != slices.Contains(auth.Roles(), tt.role) {
+				t.Errorf("ValidRole(%q) = %v", tt.role, valid)
+			}
+		})
+	}
+
+	// 修改返回值不影响角色定义
+	perm := auth.RolePermissions(auth.RoleReviewer)
+	perm[0] = auth.PermUsersManage
+	if auth.HasPermission(auth.RoleReviewer, auth.PermUsersManage) {
+		t.Error("RolePermissions returned the internal slice")
+	}
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- 两步验证 (TOTP, RFC 6238) ---
+// 与 Google Authenticator 等验证器兼容：HMAC-SHA1，6 位数字，30 秒一个时间步。
+// 校验时允许前后各一个时间步的时钟偏差；每个时间步只能使用一次 (users.totp_last_step 防重放)。
+
+const (
+	totpDigits = 6
+	totpPeriod = 30 // 秒
+	totpSkew   = 1  // 允许的时间步偏差
+)
+
+// RecoveryCodeCount 启用两步验证或重新生成时发放的恢复码数量
+const RecoveryCodeCount = 10
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RequiresTwoFactor 判断账号是否必须启用两步验证：账号设置了 two_factor_required，
+// 或者拥有任何后台权限 (reviewer / finance / support / superadmin 强制启用)
+func RequiresTwoFactor(role string, required bool) bool {
+	return required || len(rolePermissions[role]) > 0
+}
+
+// GenerateTOTPSecret 生成 160 位随机的 TOTP 密钥 (Base32，不带填充)
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("auth: 无法生成 TOTP 密钥")
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPProvisioningURI 返回 otpauth:// 地址，前端把它渲染成二维码供验证器扫描
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPStep 返回 t 所在的时间步
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// ValidateTOTP 校验验证码，成功时返回匹配的时间步 (调用方需要确认它大于上次使用的时间步，防止重放)
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return 0, false
+	}
+	current := TOTPStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPCode 返回 now 时刻的验证码 (用于测试和命令行工具)
+func TOTPCode(secret string, now time.Time) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("auth: 无效的 TOTP 密钥: %w", err)
+	}
+	return totpCode(key, TOTPStep(now)), nil
+}
+
+// totpCode 按 RFC 4226 计算 HOTP(key, step)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// --- 恢复码 ---
+
+// GenerateRecoveryCodes 生成 n 个恢复码 (格式 xxxxx-xxxxx)，返回交给用户的明文 (只展示一次) 和存入数据库的哈希
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	alphabet := base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.New("auth: 无法生成恢复码")
+		}
+		s := alphabet.EncodeToString(b)[:10]
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode 计算恢复码的 SHA-256 (十六进制)，忽略大小写、空格和连字符
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeTOTPCode 判断用户输入的是验证码 (6 位数字) 还是恢复码
+func LooksLikeTOTPCode(code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
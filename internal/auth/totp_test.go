@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"advertisement/internal/auth"
+)
+
+// rfcSecret RFC 6238 附录 B 的 SHA1 测试密钥 "12345678901234567890" (Base32)
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 附录 B 的 8 位验证码取后 6 位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := auth.TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil || code != tt.want {
+			t.Errorf("TOTPCode(%d) = %q, %v, want %q", tt.unix, code, err, tt.want)
+		}
+	}
+	if _, err := auth.TOTPCode("not base32!", time.Now()); err == nil {
+		t.Error("TOTPCode() with an invalid secret succeeded")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := auth.TOTPStep(now)
+	codeAt := func(offset time.Duration) string {
+		t.Helper()
+		code, err := auth.TOTPCode(rfcSecret, now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, codeAt(0), step, true},
+		// 允许前后各一个时间步的时钟偏差，返回实际匹配的时间步
+		{"previous step", rfcSecret, codeAt(-30 * time.Second), step - 1, true},
+		{"next step", rfcSecret, codeAt(30 * time.Second), step + 1, true},
+		{"two steps behind", rfcSecret, codeAt(-60 * time.Second), 0, false},
+		{"two steps ahead", rfcSecret, codeAt(60 * time.Second), 0, false},
+		{"surrounding spaces", rfcSecret, " " + codeAt(0) + " ", step, true},
+		{"lowercase secret with padding", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", codeAt(0), step, true},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"too short", rfcSecret, codeAt(0)[:5], 0, false},
+		{"invalid secret", "not base32!", codeAt(0), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := auth.ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || got != tt.wantStep {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", got, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 160 位密钥 -> 32 个 Base32 字符
+	if !regexp.MustCompile(`^[A-Z2-7]{32}$`).MatchString(secret) {
+		t.Fatalf("secret = %q", secret)
+	}
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := auth.ValidateTOTP(secret, code, time.Now()); !ok {
+		t.Fatal("generated secret does not validate its own code")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != auth.RecoveryCodeCount || len(hashes) != auth.RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), auth.RecoveryCodeCount)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q does not match xxxxx-xxxxx", code)
+		}
+		if hashes[i] != auth.HashRecoveryCode(code) {
+			t.Errorf("hash of %q does not match HashRecoveryCode", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+		// 恢复码不能被当成验证码
+		if auth.LooksLikeTOTPCode(code) {
+			t.Errorf("LooksLikeTOTPCode(%q) = true", code)
+		}
+	}
+
+	// 用户输入时忽略大小写、空格和连字符
+	want := auth.HashRecoveryCode("abcde-fghij")
+	for _, input := range []string{"abcdefghij", "ABCDE-FGHIJ", " abcde fghij ", "abcde-fghij\n"} {
+		if got := auth.HashRecoveryCode(input); got != want {
+			t.Errorf("HashRecoveryCode(%q) differs from HashRecoveryCode(\"abcde-fghij\")", input)
+		}
+	}
+	if auth.HashRecoveryCode("abcde-fghik") == want {
+		t.Error("different codes have the same hash")
+	}
+}
+
+func TestLooksLikeTOTPCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"123456", true},
+		{" 123456 ", true},
+		{"12345", false},
+		{"1234567", false},
+		{"12345a", false},
+		{"abcde-fghij", false},
+	}
+	for _, tt := range tests {
+		if got := auth.LooksLikeTOTPCode(tt.code); got != tt.want {
+			t.Errorf("LooksLikeTOTPCode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,680 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"advertisement/internal/money"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// --- 运行模式 ---
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// DefaultJWTSecret 仅用于本地开发的默认签名密钥。
+// production 模式下如果仍在使用它，Validate 会拒绝启动。
+const DefaultJWTSecret = "my_super_secret_signing_key_123!@#"
+
+// DefaultWebhookSecret 仅用于本地 mock 支付渠道的回调签名密钥 (cmd/mockpay 默认也使用它)
+const DefaultWebhookSecret = "mock_webhook_secret_change_me"
+
+// DefaultClickTokenSecret 仅用于本地开发的点击凭证签名密钥，production 模式下不能使用
+const DefaultClickTokenSecret = "click_token_secret_change_me"
+
+// Config 是整个服务的配置。
+// 加载顺序: 默认值 (Default) -> 配置文件 (YAML / TOML) -> 环境变量 (env 标签) -> Validate。
+type Config struct {
+	Env           string              `yaml:"env" toml:"env" env:"ADV_ENV"`
+	Server        ServerConfig        `yaml:"server" toml:"server"`
+	Database      DatabaseConfig      `yaml:"database" toml:"database"`
+	JWT           JWTConfig           `yaml:"jwt" toml:"jwt"`
+	CORS          CORSConfig          `yaml:"cors" toml:"cors"`
+	Scheduler     SchedulerConfig     `yaml:"scheduler" toml:"scheduler"`
+	Serving       ServingConfig       `yaml:"serving" toml:"serving"`
+	Targeting     TargetingConfig     `yaml:"targeting" toml:"targeting"`
+	Frequency     FrequencyConfig     `yaml:"frequency" toml:"frequency"`
+	Payment       PaymentConfig       `yaml:"payment" toml:"payment"`
+	Money         MoneyConfig         `yaml:"money" toml:"money"`
+	Mail          MailConfig          `yaml:"mail" toml:"mail"`
+	TwoFactor     TwoFactorConfig     `yaml:"two_factor" toml:"two_factor"`
+	LoginThrottle LoginThrottleConfig `yaml:"login_throttle" toml:"login_throttle"`
+}
+
+// ServerConfig HTTP 服务相关配置
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr" env:"ADV_SERVER_ADDR"` // 监听地址，例如 ":8080"
+	// TrustProxyHeaders 部署在反向代理之后时开启：客户端 IP 取 X-Forwarded-For 的最后一个地址 (由代理追加)，
+	// 并沿用代理传来的 X-Request-ID。
+	// 直接对外时必须关闭，否则客户端可以伪造 IP 绕过按 IP 的登录限流
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers" toml:"trust_proxy_headers" env:"ADV_SERVER_TRUST_PROXY_HEADERS"`
+}
+
+// DatabaseConfig 数据存储相关配置
+type DatabaseConfig struct {
+	Driver          string   `yaml:"driver" toml:"driver" env:"ADV_DB_DRIVER"` // mysql | sqlite | memory
+	DSN             string   `yaml:"dsn" toml:"dsn" env:"ADV_DB_DSN"`          // MySQL DSN
+	SQLitePath      string   `yaml:"sqlite_path" toml:"sqlite_path" env:"ADV_DB_SQLITE_PATH"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns" env:"ADV_DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns" env:"ADV_DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"ADV_DB_CONN_MAX_LIFETIME"` // 0 表示不限制
+}
+
+// JWTConfig 访问令牌相关配置
+type JWTConfig struct {
+	Algorithm       string   `yaml:"algorithm" toml:"algorithm" env:"ADV_JWT_ALGORITHM"`                      // EdDSA | RS256 | HS256
+	Secret          string   `yaml:"secret" toml:"secret" env:"ADV_JWT_SECRET"`                               // 只用于 HS256
+	KeysDir         string   `yaml:"keys_dir" toml:"keys_dir" env:"ADV_JWT_KEYS_DIR"`                         // RS256 / EdDSA 的密钥目录 (<kid>.pem)
+	ActiveKID       string   `yaml:"active_kid" toml:"active_kid" env:"ADV_JWT_ACTIVE_KID"`                   // 签名使用的 kid，为空时使用最新的私钥
+	RotationOverlap Duration `yaml:"rotation_overlap" toml:"rotation_overlap" env:"ADV_JWT_ROTATION_OVERLAP"` // 轮换后旧密钥仍可校验的时间
+	TTL             Duration `yaml:"ttl" toml:"ttl" env:"ADV_JWT_TTL"`                                        // 访问令牌 (Access Token) 有效期
+	RefreshTTL      Duration `yaml:"refresh_ttl" toml:"refresh_ttl" env:"ADV_JWT_REFRESH_TTL"`                // 刷新令牌 (Refresh Token) 有效期
+}
+
+// CORSConfig 跨域相关配置
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins" env:"ADV_CORS_ALLOWED_ORIGINS"` // 环境变量用逗号分隔
+	Debug          bool     `yaml:"debug" toml:"debug" env:"ADV_CORS_DEBUG"`                               // 输出每个跨域请求的调试日志，默认关闭
+}
+
+// SchedulerConfig 后台调度器相关配置
+type SchedulerConfig struct {
+	Interval Duration `yaml:"interval" toml:"interval" env:"ADV_SCHEDULER_INTERVAL"` // 广告活动状态检查间隔
+}
+
+// ServingConfig 广告投放相关配置
+type ServingConfig struct {
+	// RefreshInterval 投放索引定期重新加载的间隔。本实例上的状态变化会立即触发刷新，
+	// 这个间隔决定其他实例的变化、预算按天重置等最晚多久生效
+	RefreshInterval Duration `yaml:"refresh_interval" toml:"refresh_interval" env:"ADV_SERVING_REFRESH_INTERVAL"`
+	// Selector 选择广告的策略：auction 按 eCPM 竞价、第二价格成交；random 在符合条件的广告中均匀随机选择，按出价计费
+	Selector string `yaml:"selector" toml:"selector" env:"ADV_SERVING_SELECTOR"`
+	// ReservePrice 每千次展示的最低成交价，广告位底价低于它时以它为准，0 表示不限
+	ReservePrice string `yaml:"reserve_price" toml:"reserve_price" env:"ADV_SERVING_RESERVE_PRICE"`
+	// DefaultCTR CPC 活动的先验点击率，用于把 CPC 出价换算为 eCPM (出价 × 预估点击率 × 1000)
+	DefaultCTR float64 `yaml:"default_ctr" toml:"default_ctr" env:"ADV_SERVING_DEFAULT_CTR"`
+	// CTRPriorImpressions 先验点击率相当于多少次展示：活动的展示次数远少于它时预估点击率接近 default_ctr，
+	// 远多于它时接近活动自己的历史点击率
+	CTRPriorImpressions int `yaml:"ctr_prior_impressions" toml:"ctr_prior_impressions" env:"ADV_SERVING_CTR_PRIOR_IMPRESSIONS"`
+	// ClickTokenSecret 点击凭证 (GET /get-ad 签发，点击时校验) 的 HMAC 签名密钥，多个实例必须相同
+	ClickTokenSecret string `yaml:"click_token_secret" toml:"click_token_secret" env:"ADV_SERVING_CLICK_TOKEN_SECRET"`
+	// ClickTokenTTL 点击凭证的有效期，过期后点击仍然跳转，但不计费
+	ClickTokenTTL Duration `yaml:"click_token_ttl" toml:"click_token_ttl" env:"ADV_SERVING_CLICK_TOKEN_TTL"`
+	// EventWorkers 在后台记录展示事件并扣费的 worker 数，GET /get-ad 不等待数据库；0 表示每个请求同步记录
+	EventWorkers int `yaml:"event_workers" toml:"event_workers" env:"ADV_SERVING_EVENT_WORKERS"`
+	// EventQueueSize 等待后台记录的展示事件队列容量，队列满时 GET /get-ad 同步记录
+	EventQueueSize int `yaml:"event_queue_size" toml:"event_queue_size" env:"ADV_SERVING_EVENT_QUEUE_SIZE"`
+	// EventPendingLimit 每个活动在后台队列中最多等待记录的展示事件数，超出时同步记录。
+	// 预算或余额用完后、后台发现之前已返回的展示不计费，这个值限制了每个活动的这部分免费展示
+	EventPendingLimit int `yaml:"event_pending_limit" toml:"event_pending_limit" env:"ADV_SERVING_EVENT_PENDING_LIMIT"`
+}
+
+// TargetingConfig 广告活动定向相关配置
+type TargetingConfig struct {
+	// GeoIPDB MaxMind 格式 (.mmdb) 的 GeoIP 数据库文件，例如 GeoLite2-Country / GeoLite2-City。
+	// 为空时无法判断访问者的国家和地区，广告活动不能按国家或地区定向
+	GeoIPDB string `yaml:"geoip_db" toml:"geoip_db" env:"ADV_TARGETING_GEOIP_DB"`
+	// Timezone 投放时段 (星期几、几点) 使用的时区 (IANA 名称)
+	Timezone string `yaml:"timezone" toml:"timezone" env:"ADV_TARGETING_TIMEZONE"`
+}
+
+// FrequencyConfig 按访问者的频次控制相关配置。展示记录保存在进程内存中，不在多个实例之间共享
+type FrequencyConfig struct {
+	// MaxWindow 活动频次上限允许的最长时间窗口，也是展示记录保留的时长
+	MaxWindow Duration `yaml:"max_window" toml:"max_window" env:"ADV_FREQUENCY_MAX_WINDOW"`
+	// MaxViewers 内存中最多保存的访问者数，超出时淘汰最久没有展示的访问者
+	MaxViewers int `yaml:"max_viewers" toml:"max_viewers" env:"ADV_FREQUENCY_MAX_VIEWERS"`
+	// CookieName GET /get-ad 设置的访问者 ID Cookie 名称
+	CookieName string `yaml:"cookie_name" toml:"cookie_name" env:"ADV_FREQUENCY_COOKIE_NAME"`
+	// CookieMaxAge 访问者 ID Cookie 的有效期
+	CookieMaxAge Duration `yaml:"cookie_max_age" toml:"cookie_max_age" env:"ADV_FREQUENCY_COOKIE_MAX_AGE"`
+	// CookieSecure 为 true 时 Cookie 带 Secure 和 SameSite=None (广告位嵌在其他站点时需要，要求 HTTPS)
+	CookieSecure bool `yaml:"cookie_secure" toml:"cookie_secure" env:"ADV_FREQUENCY_COOKIE_SECURE"`
+}
+
+// PaymentConfig 支付渠道相关配置
+type PaymentConfig struct {
+	Provider         string   `yaml:"provider" toml:"provider" env:"ADV_PAYMENT_PROVIDER"`                            // 目前只支持 mock
+	WebhookSecret    string   `yaml:"webhook_secret" toml:"webhook_secret" env:"ADV_PAYMENT_WEBHOOK_SECRET"`          // 回调签名密钥
+	WebhookTolerance Duration `yaml:"webhook_tolerance" toml:"webhook_tolerance" env:"ADV_PAYMENT_WEBHOOK_TOLERANCE"` // 回调时间戳允许的偏差
+
+	// AllowMockInProduction 允许 production 模式使用 mock 渠道：没有接入真实支付网关时，由财务核对线下转账后
+	// 用 cmd/mockpay 发送签名回调入账。此时 webhook_secret 必须修改，持有密钥即可给任意充值记录入账
+	AllowMockInProduction bool `yaml:"allow_mock_in_production" toml:"allow_mock_in_production" env:"ADV_PAYMENT_ALLOW_MOCK_IN_PRODUCTION"`
+}
+
+// MoneyConfig 货币和用户输入金额的范围 (金额写成 "10.50" 这样的字符串，避免浮点误差)
+type MoneyConfig struct {
+	Currency  string `yaml:"currency" toml:"currency" env:"ADV_MONEY_CURRENCY"`       // ISO 4217 货币代码
+	MinAmount string `yaml:"min_amount" toml:"min_amount" env:"ADV_MONEY_MIN_AMOUNT"` // 单笔金额下限
+	MaxAmount string `yaml:"max_amount" toml:"max_amount" env:"ADV_MONEY_MAX_AMOUNT"` // 单笔金额上限，留空表示不限制
+}
+
+// MailConfig 邮件相关配置 (邮箱验证、找回密码)。
+// 邮件先写入发件箱 (email_outbox 表)，再由后台投递器通过 Mailer 发送，失败按指数退避重试。
+type MailConfig struct {
+	Driver           string   `yaml:"driver" toml:"driver" env:"ADV_MAIL_DRIVER"`                                     // file | smtp
+	From             string   `yaml:"from" toml:"from" env:"ADV_MAIL_FROM"`                                           // 发件人地址
+	Dir              string   `yaml:"dir" toml:"dir" env:"ADV_MAIL_DIR"`                                              // driver=file 时 .eml 文件的保存目录
+	SMTPHost         string   `yaml:"smtp_host" toml:"smtp_host" env:"ADV_MAIL_SMTP_HOST"`                            // driver=smtp
+	SMTPPort         int      `yaml:"smtp_port" toml:"smtp_port" env:"ADV_MAIL_SMTP_PORT"`                            // driver=smtp
+	SMTPUsername     string   `yaml:"smtp_username" toml:"smtp_username" env:"ADV_MAIL_SMTP_USERNAME"`                // 为空时不认证
+	SMTPPassword     string   `yaml:"smtp_password" toml:"smtp_password" env:"ADV_MAIL_SMTP_PASSWORD"`                // AUTH PLAIN 密码
+	Interval         Duration `yaml:"interval" toml:"interval" env:"ADV_MAIL_INTERVAL"`                               // 发件箱轮询间隔
+	MaxAttempts      int      `yaml:"max_attempts" toml:"max_attempts" env:"ADV_MAIL_MAX_ATTEMPTS"`                   // 超过后邮件标记为 Failed
+	LinkBaseURL      string   `yaml:"link_base_url" toml:"link_base_url" env:"ADV_MAIL_LINK_BASE_URL"`                // 邮件中链接指向的前端地址
+	VerificationTTL  Duration `yaml:"verification_ttl" toml:"verification_ttl" env:"ADV_MAIL_VERIFICATION_TTL"`       // 邮箱验证链接有效期
+	PasswordResetTTL Duration `yaml:"password_reset_ttl" toml:"password_reset_ttl" env:"ADV_MAIL_PASSWORD_RESET_TTL"` // 重置密码链接有效期
+}
+
+// TwoFactorConfig 两步验证 (TOTP) 相关配置
+type TwoFactorConfig struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer" env:"ADV_TWO_FACTOR_ISSUER"`                      // 验证器中显示的服务名称
+	ChallengeTTL Duration `yaml:"challenge_ttl" toml:"challenge_ttl" env:"ADV_TWO_FACTOR_CHALLENGE_TTL"` // 密码校验通过后输入验证码的时限
+	MaxAttempts  int      `yaml:"max_attempts" toml:"max_attempts" env:"ADV_TWO_FACTOR_MAX_ATTEMPTS"`    // 验证码错误次数上限，超过后需要重新输入密码
+}
+
+// LoginThrottleConfig 登录限流：按用户名和客户端 IP 分别统计连续失败次数，
+// 超过免费次数后按指数退避 (base_delay 起每次翻倍，最多 max_delay)，达到阈值后锁定一段时间
+type LoginThrottleConfig struct {
+	Backend              string   `yaml:"backend" toml:"backend" env:"ADV_LOGIN_THROTTLE_BACKEND"`                                              // database | memory
+	Window               Duration `yaml:"window" toml:"window" env:"ADV_LOGIN_THROTTLE_WINDOW"`                                                 // 最后一次失败后计数保留多久
+	BaseDelay            Duration `yaml:"base_delay" toml:"base_delay" env:"ADV_LOGIN_THROTTLE_BASE_DELAY"`                                     // 第一次退避的等待时间
+	MaxDelay             Duration `yaml:"max_delay" toml:"max_delay" env:"ADV_LOGIN_THROTTLE_MAX_DELAY"`                                        // 退避等待时间上限
+	UserFreeAttempts     int      `yaml:"user_free_attempts" toml:"user_free_attempts" env:"ADV_LOGIN_THROTTLE_USER_FREE_ATTEMPTS"`             // 同一用户名前 N 次失败不退避
+	UserLockoutThreshold int      `yaml:"user_lockout_threshold" toml:"user_lockout_threshold" env:"ADV_LOGIN_THROTTLE_USER_LOCKOUT_THRESHOLD"` // 0 表示不锁定
+	UserLockoutDuration  Duration `yaml:"user_lockout_duration" toml:"user_lockout_duration" env:"ADV_LOGIN_THROTTLE_USER_LOCKOUT_DURATION"`
+	IPFreeAttempts       int      `yaml:"ip_free_attempts" toml:"ip_free_attempts" env:"ADV_LOGIN_THROTTLE_IP_FREE_ATTEMPTS"`             // 同一 IP 前 N 次失败不退避
+	IPLockoutThreshold   int      `yaml:"ip_lockout_threshold" toml:"ip_lockout_threshold" env:"ADV_LOGIN_THROTTLE_IP_LOCKOUT_THRESHOLD"` // 0 表示不锁定
+	IPLockoutDuration    Duration `yaml:"ip_lockout_duration" toml:"ip_lockout_duration" env:"ADV_LOGIN_THROTTLE_IP_LOCKOUT_DURATION"`
+}
+
+// Duration 包装 time.Duration，使其可以在 YAML / TOML / 环境变量中写成 "24h"、"30s" 这样的字符串
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText 实现 encoding.TextUnmarshaler (yaml.v3 和 BurntSushi/toml 都支持)
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(strings.TrimSpace(string(text)))
+	if err != nil {
+		return fmt.Errorf("无效的时长 %q: %w", string(text), err)
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Default 返回开发环境下的默认配置 (与之前硬编码在 main.go / auth 中的值一致)
+func Default() Config {
+	return Config{
+		Env: EnvDevelopment,
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Database: DatabaseConfig{
+			Driver:       "mysql",
+			DSN:          "root:123456@tcp(127.0.0.1:3306)/advertisement?charset=utf8mb4&parseTime=True&loc=Local",
+			SQLitePath:   "advertisement.db",
+			MaxOpenConns: 10,
+			MaxIdleConns: 5,
+		},
+		JWT: JWTConfig{
+			Algorithm:       "EdDSA",
+			Secret:          DefaultJWTSecret,
+			KeysDir:         "keys",
+			RotationOverlap: Duration{time.Hour},
+			TTL:             Duration{15 * time.Minute},
+			RefreshTTL:      Duration{30 * 24 * time.Hour},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"http://localhost:5173"},
+		},
+		Scheduler: SchedulerConfig{
+			Interval: Duration{time.Minute},
+		},
+		Serving: ServingConfig{
+			RefreshInterval:     Duration{30 * time.Second},
+			Selector:            "auction",
+			ReservePrice:        "0",
+			DefaultCTR:          0.01,
+			CTRPriorImpressions: 1000,
+			ClickTokenSecret:    DefaultClickTokenSecret,
+			ClickTokenTTL:       Duration{24 * time.Hour},
+			EventWorkers:        4,
+			EventQueueSize:      10000,
+			EventPendingLimit:   50,
+		},
+		Targeting: TargetingConfig{
+			Timezone: "Asia/Shanghai",
+		},
+		Frequency: FrequencyConfig{
+			MaxWindow:    Duration{30 * 24 * time.Hour},
+			MaxViewers:   1000000,
+			CookieName:   "adv_vid",
+			CookieMaxAge: Duration{365 * 24 * time.Hour},
+		},
+		Payment: PaymentConfig{
+			Provider:         "mock",
+			WebhookSecret:    DefaultWebhookSecret,
+			WebhookTolerance: Duration{5 * time.Minute},
+		},
+		Money: MoneyConfig{
+			Currency:  "CNY",
+			MinAmount: "0.01",
+			MaxAmount: "1000000.00",
+		},
+		Mail: MailConfig{
+			Driver:           "file",
+			From:             "no-reply@advertisement.local",
+			Dir:              "mail",
+			SMTPPort:         25,
+			Interval:         Duration{5 * time.Second},
+			MaxAttempts:      8,
+			LinkBaseURL:      "http://localhost:5173",
+			VerificationTTL:  Duration{48 * time.Hour},
+			PasswordResetTTL: Duration{time.Hour},
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:       "Advertisement",
+			ChallengeTTL: Duration{5 * time.Minute},
+			MaxAttempts:  5,
+		},
+		LoginThrottle: LoginThrottleConfig{
+			Backend:              "database",
+			Window:               Duration{time.Hour},
+			BaseDelay:            Duration{time.Second},
+			MaxDelay:             Duration{5 * time.Minute},
+			UserFreeAttempts:     3,
+			UserLockoutThreshold: 10,
+			UserLockoutDuration:  Duration{15 * time.Minute},
+			IPFreeAttempts:       20,
+			IPLockoutThreshold:   100,
+			IPLockoutDuration:    Duration{time.Hour},
+		},
+	}
+}
+
+// Load 读取配置文件 (可选) 并应用环境变量覆盖，最后校验。
+// path 为空时只使用默认值和环境变量；文件扩展名决定格式: .yaml / .yml / .toml
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile 根据扩展名解析 YAML 或 TOML 文件，文件中未出现的字段保留默认值
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: 读取配置文件 %s 失败: %w", path, err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("config: 解析 YAML 配置 %s 失败: %w", path, err)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), cfg); err != nil {
+			return fmt.Errorf("config: 解析 TOML 配置 %s 失败: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config: 不支持的配置文件格式 %s (应为 .yaml / .yml / .toml)", path)
+	}
+	return nil
+}
+
+// applyEnv 递归遍历结构体，按 env 标签用环境变量覆盖字段
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		sf := t.Field(i)
+
+		key := sf.Tag.Get("env")
+		if key == "" {
+			if field.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(Duration{}) {
+				if err := applyEnv(field, lookup); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("config: 环境变量 %s 的值无效: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// setField 把字符串形式的环境变量值写入对应类型的字段
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(Duration{}) {
+		var d Duration
+		if err := d.UnmarshalText([]byte(raw)); err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持的类型 %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持的类型 %s", field.Type())
+	}
+	return nil
+}
+
+// IsProduction 是否为生产模式
+func (c *Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+// Validate 校验配置，返回所有问题合并后的错误
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		fail("env 只能是 %s 或 %s，当前为 %q", EnvDevelopment, EnvProduction, c.Env)
+	}
+
+	if strings.TrimSpace(c.Server.Addr) == "" {
+		fail("server.addr 不能为空")
+	}
+
+	switch c.Database.Driver {
+	case "mysql":
+		if strings.TrimSpace(c.Database.DSN) == "" {
+			fail("database.dsn 不能为空 (driver=mysql)")
+		}
+	case "sqlite":
+		if strings.TrimSpace(c.Database.SQLitePath) == "" {
+			fail("database.sqlite_path 不能为空 (driver=sqlite)")
+		}
+	case "memory":
+		if c.IsProduction() {
+			fail("production 模式下不能使用 memory 存储")
+		}
+	default:
+		fail("database.driver 只能是 mysql、sqlite 或 memory，当前为 %q", c.Database.Driver)
+	}
+	if c.Database.MaxOpenConns <= 0 {
+		fail("database.max_open_conns 必须大于 0")
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		fail("database.max_idle_conns 必须在 0 到 max_open_conns 之间")
+	}
+	if c.Database.ConnMaxLifetime.Duration < 0 {
+		fail("database.conn_max_lifetime 不能为负数")
+	}
+
+	switch c.JWT.Algorithm {
+	case "HS256":
+		if c.JWT.Secret == "" {
+			fail("jwt.secret 不能为空 (algorithm=HS256)")
+		}
+		if c.IsProduction() {
+			if c.JWT.Secret == DefaultJWTSecret {
+				fail("production 模式下不能使用默认的 jwt.secret，请通过配置文件或 ADV_JWT_SECRET 设置")
+			} else if len(c.JWT.Secret) < 32 {
+				fail("production 模式下 jwt.secret 至少需要 32 个字节")
+			}
+		}
+	case "RS256", "EdDSA":
+		if c.JWT.KeysDir == "" {
+			fail("jwt.keys_dir 不能为空 (algorithm=%s)", c.JWT.Algorithm)
+		}
+		// 旧密钥至少要在一个访问令牌有效期内继续可用，否则轮换时已签发的令牌会立即失效
+		if c.JWT.RotationOverlap.Duration < c.JWT.TTL.Duration {
+			fail("jwt.rotation_overlap 不能小于 jwt.ttl")
+		}
+	default:
+		fail("jwt.algorithm 只能是 EdDSA、RS256 或 HS256，当前为 %q", c.JWT.Algorithm)
+	}
+	if c.JWT.TTL.Duration <= 0 {
+		fail("jwt.ttl 必须大于 0")
+	}
+	if c.JWT.RefreshTTL.Duration <= c.JWT.TTL.Duration {
+		fail("jwt.refresh_ttl 必须大于 jwt.ttl")
+	}
+
+	if c.Scheduler.Interval.Duration <= 0 {
+		fail("scheduler.interval 必须大于 0")
+	}
+	if c.Serving.RefreshInterval.Duration <= 0 {
+		fail("serving.refresh_interval 必须大于 0")
+	}
+	if c.Serving.Selector != "auction" && c.Serving.Selector != "random" {
+		fail("serving.selector 只能是 auction 或 random，当前为 %q", c.Serving.Selector)
+	}
+	if reserve, err := money.ParseAmount(c.Serving.ReservePrice); err != nil || reserve < 0 {
+		fail("serving.reserve_price 必须是不小于 0、最多两位小数的金额，当前为 %q", c.Serving.ReservePrice)
+	}
+	if c.Serving.DefaultCTR <= 0 || c.Serving.DefaultCTR >= 1 {
+		fail("serving.default_ctr 必须在 0 和 1 之间，当前为 %v", c.Serving.DefaultCTR)
+	}
+	if c.Serving.CTRPriorImpressions < 0 {
+		fail("serving.ctr_prior_impressions 不能为负数，当前为 %d", c.Serving.CTRPriorImpressions)
+	}
+	if _, err := time.LoadLocation(c.Targeting.Timezone); err != nil || c.Targeting.Timezone == "" {
+		fail("targeting.timezone 不是有效的时区名称，当前为 %q", c.Targeting.Timezone)
+	}
+	if c.Frequency.MaxWindow.Duration < time.Minute {
+		fail("frequency.max_window 不能小于 1m")
+	}
+	if c.Frequency.MaxViewers <= 0 {
+		fail("frequency.max_viewers 必须大于 0")
+	}
+	if !validCookieName(c.Frequency.CookieName) {
+		fail("frequency.cookie_name 只能包含字母、数字、\"-\" 和 \"_\"，当前为 %q", c.Frequency.CookieName)
+	}
+	if c.Frequency.CookieMaxAge.Duration < time.Second {
+		fail("frequency.cookie_max_age 必须大于 0")
+	}
+
+	if c.Serving.ClickTokenSecret == "" {
+		fail("serving.click_token_secret 不能为空")
+	} else if c.IsProduction() {
+		if c.Serving.ClickTokenSecret == DefaultClickTokenSecret {
+			fail("production 模式下不能使用默认的 serving.click_token_secret，请通过配置文件或 ADV_SERVING_CLICK_TOKEN_SECRET 设置")
+		} else if len(c.Serving.ClickTokenSecret) < 32 {
+			fail("production 模式下 serving.click_token_secret 至少需要 32 个字节")
+		}
+	}
+	if c.Serving.ClickTokenTTL.Duration < time.Minute {
+		fail("serving.click_token_ttl 不能小于 1m")
+	}
+	if c.Serving.EventWorkers < 0 {
+		fail("serving.event_workers 不能为负数，当前为 %d", c.Serving.EventWorkers)
+	}
+	if c.Serving.EventWorkers > 0 && c.Serving.EventQueueSize <= 0 {
+		fail("serving.event_queue_size 必须大于 0，当前为 %d", c.Serving.EventQueueSize)
+	}
+	if c.Serving.EventWorkers > 0 && c.Serving.EventPendingLimit <= 0 {
+		fail("serving.event_pending_limit 必须大于 0，当前为 %d", c.Serving.EventPendingLimit)
+	}
+
+	switch c.Payment.Provider {
+	case "mock":
+		if c.IsProduction() {
+			if !c.Payment.AllowMockInProduction {
+				fail("production 模式下使用 mock 支付渠道需要设置 payment.allow_mock_in_production")
+			} else if c.Payment.WebhookSecret == DefaultWebhookSecret {
+				fail("production 模式下不能使用默认的 payment.webhook_secret，请通过配置文件或 ADV_PAYMENT_WEBHOOK_SECRET 设置")
+			} else if len(c.Payment.WebhookSecret) < 32 {
+				fail("production 模式下 payment.webhook_secret 至少需要 32 个字节")
+			}
+		}
+	default:
+		fail("payment.provider 只能是 mock，当前为 %q", c.Payment.Provider)
+	}
+	if c.Payment.WebhookSecret == "" {
+		fail("payment.webhook_secret 不能为空")
+	}
+	if c.Payment.WebhookTolerance.Duration <= 0 {
+		fail("payment.webhook_tolerance 必须大于 0")
+	}
+
+	if len(strings.TrimSpace(c.Money.Currency)) != 3 {
+		fail("money.currency 必须是 3 位货币代码，当前为 %q", c.Money.Currency)
+	}
+	minAmount, err := money.ParseAmount(c.Money.MinAmount)
+	if err != nil || minAmount <= 0 {
+		fail("money.min_amount 必须是大于 0、最多两位小数的金额，当前为 %q", c.Money.MinAmount)
+	}
+	if strings.TrimSpace(c.Money.MaxAmount) != "" {
+		maxAmount, err := money.ParseAmount(c.Money.MaxAmount)
+		if err != nil || maxAmount < minAmount {
+			fail("money.max_amount 必须是不小于 min_amount、最多两位小数的金额，当前为 %q", c.Money.MaxAmount)
+		}
+	}
+
+	switch c.Mail.Driver {
+	case "file":
+		if strings.TrimSpace(c.Mail.Dir) == "" {
+			fail("mail.dir 不能为空 (driver=file)")
+		}
+	case "smtp":
+		if strings.TrimSpace(c.Mail.SMTPHost) == "" {
+			fail("mail.smtp_host 不能为空 (driver=smtp)")
+		}
+		if c.Mail.SMTPPort <= 0 || c.Mail.SMTPPort > 65535 {
+			fail("mail.smtp_port 必须在 1 到 65535 之间，当前为 %d", c.Mail.SMTPPort)
+		}
+	default:
+		fail("mail.driver 只能是 file 或 smtp，当前为 %q", c.Mail.Driver)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		fail("mail.from 不是有效的邮件地址: %q", c.Mail.From)
+	}
+	if c.Mail.Interval.Duration <= 0 {
+		fail("mail.interval 必须大于 0")
+	}
+	if c.Mail.MaxAttempts <= 0 {
+		fail("mail.max_attempts 必须大于 0")
+	}
+	if u, err := url.Parse(c.Mail.LinkBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("mail.link_base_url 必须是 http(s) 地址，当前为 %q", c.Mail.LinkBaseURL)
+	}
+	if c.Mail.VerificationTTL.Duration <= 0 {
+		fail("mail.verification_ttl 必须大于 0")
+	}
+	if c.Mail.PasswordResetTTL.Duration <= 0 {
+		fail("mail.password_reset_ttl 必须大于 0")
+	}
+	if strings.TrimSpace(c.TwoFactor.Issuer) == "" || strings.Contains(c.TwoFactor.Issuer, ":") {
+		fail("two_factor.issuer 不能为空且不能包含冒号，当前为 %q", c.TwoFactor.Issuer)
+	}
+	if c.TwoFactor.ChallengeTTL.Duration <= 0 {
+		fail("two_factor.challenge_ttl 必须大于 0")
+	}
+	if c.TwoFactor.MaxAttempts <= 0 {
+		fail("two_factor.max_attempts 必须大于 0")
+	}
+	lt := c.LoginThrottle
+	if lt.Backend != "database" && lt.Backend != "memory" {
+		fail("login_throttle.backend 只能是 database 或 memory，当前为 %q", lt.Backend)
+	}
+	if lt.Window.Duration <= 0 || lt.BaseDelay.Duration <= 0 || lt.MaxDelay.Duration < lt.BaseDelay.Duration {
+		fail("login_throttle.window 和 base_delay 必须大于 0，max_delay 不能小于 base_delay")
+	}
+	if lt.UserFreeAttempts < 0 || lt.IPFreeAttempts < 0 {
+		fail("login_throttle.user_free_attempts / ip_free_attempts 不能小于 0")
+	}
+	if lt.UserLockoutThreshold < 0 || (lt.UserLockoutThreshold > 0 && lt.UserLockoutDuration.Duration <= 0) {
+		fail("login_throttle.user_lockout_threshold 不能小于 0，开启锁定时 user_lockout_duration 必须大于 0")
+	}
+	if lt.IPLockoutThreshold < 0 || (lt.IPLockoutThreshold > 0 && lt.IPLockoutDuration.Duration <= 0) {
+		fail("login_throttle.ip_lockout_threshold 不能小于 0，开启锁定时 ip_lockout_duration 必须大于 0")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			// 我们开启了 AllowCredentials，浏览器不接受 "*" 与凭证同时出现
+			fail("cors.allowed_origins 不能包含 \"*\" (服务允许携带认证信息)")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validCookieName Cookie 名称只允许字母、数字、"-" 和 "_"
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,266 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"advertisement/internal/config"
+)
+
+// writeFile 在临时目录中写入配置文件，返回路径
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	cfg := config.Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Default().Validate() = %v", err)
+	}
+	if cfg.CORS.Debug {
+		t.Error("cors.debug is enabled by default")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+server:
+  addr: ":9090"
+database:
+  driver: sqlite
+  sqlite_path: file.db
+jwt:
+  ttl: 45m
+cors:
+  allowed_origins: [https://a.example.com]
+`)
+	tomlFile := writeFile(t, "config.toml", `
+[server]
+addr = ":9090"
+
+[database]
+driver = "sqlite"
+sqlite_path = "file.db"
+
+[jwt]
+ttl = "45m"
+
+[cors]
+allowed_origins = ["https://a.example.com"]
+`)
+
+	for _, path := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			// 只有配置文件: 文件中的值覆盖默认值，文件中没有的字段保留默认值
+			cfg, err := config.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Addr != ":9090" || cfg.Database.Driver != "sqlite" || cfg.Database.SQLitePath != "file.db" {
+				t.Errorf("file values not applied: %+v %+v", cfg.Server, cfg.Database)
+			}
+			if cfg.JWT.TTL.Duration != 45*time.Minute {
+				t.Errorf("jwt.ttl = %v, want 45m", cfg.JWT.TTL)
+			}
+			if got := cfg.CORS.AllowedOrigins; len(got) != 1 || got[0] != "https://a.example.com" {
+				t.Errorf("cors.allowed_origins = %v", got)
+			}
+			if def := config.Default(); cfg.Database.MaxOpenConns != def.Database.MaxOpenConns || cfg.JWT.Secret != def.JWT.Secret {
+				t.Errorf("fields missing from the file lost their defaults: %+v", cfg)
+			}
+
+			// 环境变量覆盖配置文件
+			t.Setenv("ADV_SERVER_ADDR", ":7070")
+			t.Setenv("ADV_DB_MAX_OPEN_CONNS", "20")
+			t.Setenv("ADV_JWT_TTL", "30m")
+			t.Setenv("ADV_CORS_ALLOWED_ORIGINS", "https://b.example.com, https://c.example.com,")
+			t.Setenv("ADV_CORS_DEBUG", "true")
+			cfg, err = config.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Addr != ":7070" || cfg.Database.MaxOpenConns != 20 || cfg.JWT.TTL.Duration != 30*time.Minute || !cfg.CORS.Debug {
+				t.Errorf("env values not applied: %+v %+v %+v %+v", cfg.Server, cfg.Database, cfg.JWT, cfg.CORS)
+			}
+			if got := cfg.CORS.AllowedOrigins; len(got) != 2 || got[0] != "https://b.example.com" || got[1] != "https://c.example.com" {
+				t.Errorf("cors.allowed_origins = %q", got)
+			}
+			if cfg.Database.SQLitePath != "file.db" {
+				t.Errorf("database.sqlite_path = %q, want the file value", cfg.Database.SQLitePath)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // 文件名，为空时不使用配置文件
+		content string
+		env     map[string]string
+		want    string // 错误信息中应包含的内容
+	}{
+		{name: "missing file", file: "-", want: "读取配置文件"},
+		{name: "unknown extension", file: "config.json", content: "{}", want: "不支持的配置文件格式"},
+		{name: "invalid yaml", file: "config.yaml", content: "server: [", want: "解析 YAML"},
+		{name: "invalid toml", file: "config.toml", content: "server =", want: "解析 TOML"},
+		{name: "invalid duration in file", file: "config.yaml", content: "jwt:\n  ttl: soon\n", want: "无效的时长"},
+		{name: "invalid int env", env: map[string]string{"ADV_DB_MAX_OPEN_CONNS": "ten"}, want: "ADV_DB_MAX_OPEN_CONNS"},
+		{name: "invalid bool env", env: map[string]string{"ADV_CORS_DEBUG": "maybe"}, want: "ADV_CORS_DEBUG"},
+		{name: "invalid duration env", env: map[string]string{"ADV_JWT_TTL": "1 day"}, want: "ADV_JWT_TTL"},
+		// 环境变量覆盖后仍然要通过校验
+		{name: "validation after env", env: map[string]string{"ADV_DB_DRIVER": "oracle"}, want: "database.driver"},
+		{name: "validation after file", file: "config.yaml", content: "env: staging\n", want: "env 只能是"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := ""
+			switch tt.file {
+			case "":
+			case "-":
+				path = filepath.Join(t.TempDir(), "missing.yaml")
+			default:
+				path = writeFile(t, tt.file, tt.content)
+			}
+			_, err := config.Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const strongSecret = "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name   string
+		modify func(c *config.Config)
+		want   string // 为空表示应该通过校验
+	}{
+		{name: "default", modify: func(c *config.Config) {}},
+		{name: "production with strong secret", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Secret = strongSecret
+			c.Serving.ClickTokenSecret = strongSecret
+			c.Payment.AllowMockInProduction = true
+			c.Payment.WebhookSecret = strongSecret
+		}},
+		{name: "unknown env", modify: func(c *config.Config) { c.Env = "staging" }, want: "env 只能是"},
+		{name: "empty addr", modify: func(c *config.Config) { c.Server.Addr = " " }, want: "server.addr"},
+		{name: "unknown driver", modify: func(c *config.Config) { c.Database.Driver = "postgres" }, want: "database.driver"},
+		{name: "mysql without dsn", modify: func(c *config.Config) { c.Database.DSN = "" }, want: "database.dsn"},
+		{name: "sqlite without path", modify: func(c *config.Config) {
+			c.Database.Driver = "sqlite"
+			c.Database.SQLitePath = ""
+		}, want: "database.sqlite_path"},
+		{name: "memory in production", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Secret = strongSecret
+			c.Serving.ClickTokenSecret = strongSecret
+			c.Database.Driver = "memory"
+		}, want: "memory"},
+		{name: "no open conns", modify: func(c *config.Config) { c.Database.MaxOpenConns = 0 }, want: "max_open_conns"},
+		{name: "idle above open", modify: func(c *config.Config) { c.Database.MaxIdleConns = 11 }, want: "max_idle_conns"},
+		{name: "negative lifetime", modify: func(c *config.Config) { c.Database.ConnMaxLifetime.Duration = -time.Second }, want: "conn_max_lifetime"},
+		{name: "empty secret", modify: func(c *config.Config) {
+			c.JWT.Algorithm = "HS256"
+			c.JWT.Secret = ""
+		}, want: "jwt.secret"},
+		{name: "empty secret with EdDSA", modify: func(c *config.Config) { c.JWT.Secret = "" }},
+		{name: "unknown algorithm", modify: func(c *config.Config) { c.JWT.Algorithm = "none" }, want: "jwt.algorithm"},
+		{name: "no keys dir", modify: func(c *config.Config) { c.JWT.KeysDir = "" }, want: "jwt.keys_dir"},
+		{name: "overlap below ttl", modify: func(c *config.Config) { c.JWT.RotationOverlap.Duration = time.Minute }, want: "jwt.rotation_overlap"},
+		{name: "zero ttl", modify: func(c *config.Config) { c.JWT.TTL.Duration = 0 }, want: "jwt.ttl"},
+		{name: "default secret in production", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Algorithm = "HS256"
+		}, want: "默认的 jwt.secret"},
+		{name: "short secret in production", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Algorithm = "HS256"
+			c.JWT.Secret = "short"
+		}, want: "至少需要 32 个字节"},
+		{name: "empty click token secret", modify: func(c *config.Config) { c.Serving.ClickTokenSecret = "" }, want: "serving.click_token_secret"},
+		{name: "default click token secret in production", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Secret = strongSecret
+		}, want: "默认的 serving.click_token_secret"},
+		{name: "short click token ttl", modify: func(c *config.Config) { c.Serving.ClickTokenTTL.Duration = time.Second }, want: "serving.click_token_ttl"},
+		{name: "negative event workers", modify: func(c *config.Config) { c.Serving.EventWorkers = -1 }, want: "serving.event_workers"},
+		{name: "empty event queue", modify: func(c *config.Config) { c.Serving.EventQueueSize = 0 }, want: "serving.event_queue_size"},
+		{name: "zero event pending limit", modify: func(c *config.Config) { c.Serving.EventPendingLimit = 0 }, want: "serving.event_pending_limit"},
+		{name: "sync events ignore queue settings", modify: func(c *config.Config) {
+			c.Serving.EventWorkers, c.Serving.EventQueueSize, c.Serving.EventPendingLimit = 0, 0, 0
+		}},
+		{name: "unknown payment provider", modify: func(c *config.Config) { c.Payment.Provider = "stripe" }, want: "payment.provider"},
+		{name: "mock payments in production", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Secret = strongSecret
+			c.Serving.ClickTokenSecret = strongSecret
+		}, want: "payment.allow_mock_in_production"},
+		{name: "default webhook secret in production", modify: func(c *config.Config) {
+			c.Env = config.EnvProduction
+			c.JWT.Secret = strongSecret
+			c.Serving.ClickTokenSecret = strongSecret
+			c.Payment.AllowMockInProduction = true
+		}, want: "默认的 payment.webhook_secret"},
+		{name: "empty webhook secret", modify: func(c *config.Config) { c.Payment.WebhookSecret = "" }, want: "payment.webhook_secret"},
+		{name: "zero webhook tolerance", modify: func(c *config.Config) { c.Payment.WebhookTolerance.Duration = 0 }, want: "payment.webhook_tolerance"},
+		{name: "invalid currency", modify: func(c *config.Config) { c.Money.Currency = "RMB1" }, want: "money.currency"},
+		{name: "zero min amount", modify: func(c *config.Config) { c.Money.MinAmount = "0" }, want: "money.min_amount"},
+		{name: "min amount with three decimals", modify: func(c *config.Config) { c.Money.MinAmount = "0.001" }, want: "money.min_amount"},
+		{name: "max amount below min", modify: func(c *config.Config) {
+			c.Money.MinAmount = "10"
+			c.Money.MaxAmount = "5"
+		}, want: "money.max_amount"},
+		{name: "no max amount", modify: func(c *config.Config) { c.Money.MaxAmount = "" }},
+		{name: "memory login throttle", modify: func(c *config.Config) { c.LoginThrottle.Backend = "memory" }},
+		{name: "unknown login throttle backend", modify: func(c *config.Config) { c.LoginThrottle.Backend = "redis" }, want: "login_throttle.backend"},
+		{name: "max delay below base delay", modify: func(c *config.Config) {
+			c.LoginThrottle.BaseDelay = config.Duration{Duration: time.Minute}
+			c.LoginThrottle.MaxDelay = config.Duration{Duration: time.Second}
+		}, want: "login_throttle.window"},
+		{name: "lockout without duration", modify: func(c *config.Config) { c.LoginThrottle.UserLockoutDuration = config.Duration{} }, want: "login_throttle.user_lockout_threshold"},
+		{name: "ip lockout disabled", modify: func(c *config.Config) {
+			c.LoginThrottle.IPLockoutThreshold = 0
+			c.LoginThrottle.IPLockoutDuration = config.Duration{}
+		}},
+		{name: "wildcard origin", modify: func(c *config.Config) { c.CORS.AllowedOrigins = []string{"*"} }, want: "cors.allowed_origins"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	// 所有问题合并在一个错误中返回
+	cfg := config.Default()
+	cfg.Server.Addr = ""
+	cfg.JWT.TTL.Duration = 0
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "server.addr") || !strings.Contains(err.Error(), "jwt.ttl") {
+		t.Errorf("Validate() = %v, want both problems reported", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Package frequency 实现按访问者的频次控制：广告活动可以设置 "每个访问者 24 小时内最多 3 次" 这样的上限
+// (models.FrequencyCap)，GET /get-ad 选择广告时跳过已达到上限的活动。
+//
+// 访问者由 GET /get-ad 设置的第一方 Cookie 或 device_id 参数识别 (见 Capper.Identify)。
+// 展示记录保存在 Store 中，按滑动窗口计数：任意连续的 window 时长内的展示次数不超过上限。
+package frequency
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// 每个活动最多设置的上限条数，以及单个上限的展示次数范围
+const (
+	maxCapsPerCampaign = 5
+	maxCapImpressions  = 1000
+	minCapWindow       = time.Minute
+)
+
+// Impression 一次展示记录
+type Impression struct {
+	CampaignID int
+	At         time.Time
+}
+
+// History 一个访问者的展示记录，按时间升序
+type History []Impression
+
+// Count 返回 campaignID 在 since 之后 (不含) 的展示次数
+func (h History) Count(campaignID int, since time.Time) int {
+	n := 0
+	for i := len(h) - 1; i >= 0 && h[i].At.After(since); i-- {
+		if h[i].CampaignID == campaignID {
+			n++
+		}
+	}
+	return n
+}
+
+// Store 保存访问者的展示记录。实现需要至少保留 retain 时长 (创建时指定) 内的记录，可以安全地并发使用
+type Store interface {
+	// History 返回 viewer 在 since 之后的展示记录，没有记录时返回空
+	History(ctx context.Context, viewer string, since time.Time) (History, error)
+	// Record 记录 viewer 的一次展示
+	Record(ctx context.Context, viewer string, imp Impression) error
+}
+
+// Allowed 检查按 history 计算，再展示一次活动是否仍在所有上限之内
+func Allowed(caps models.FrequencyCaps, history History, campaignID int, now time.Time) bool {
+	for _, c := range caps {
+		if history.Count(campaignID, now.Add(-c.Window)) >= c.Impressions {
+			return false
+		}
+	}
+	return true
+}
+
+// Capper 频次控制：识别访问者、读取和记录展示、校验活动的频次上限。可以安全地并发使用
+type Capper struct {
+	store     Store
+	maxWindow time.Duration
+	cookie    CookieOptions
+}
+
+// New 创建 Capper。maxWindow 是频次上限允许的最长时间窗口，store 需要保留这么久的记录
+func New(store Store, maxWindow time.Duration, cookie CookieOptions) *Capper {
+	return &Capper{store: store, maxWindow: maxWindow, cookie: cookie}
+}
+
+// History 读取访问者在最长时间窗口内的展示记录
+func (c *Capper) History(ctx context.Context, viewer string, now time.Time) (History, error) {
+	return c.store.History(ctx, viewer, now.Add(-c.maxWindow))
+}
+
+// Record 记录一次展示
+func (c *Capper) Record(ctx context.Context, viewer string, campaignID int, at time.Time) error {
+	return c.store.Record(ctx, viewer, Impression{CampaignID: campaignID, At: at})
+}
+
+// Normalize 校验频次上限并按时间窗口排序。返回的错误信息可以直接展示给用户
+func (c *Capper) Normalize(caps models.FrequencyCaps) (models.FrequencyCaps, error) {
+	if len(caps) == 0 {
+		return nil, nil
+	}
+	if len(caps) > maxCapsPerCampaign {
+		return nil, fmt.Errorf("最多设置 %d 条", maxCapsPerCampaign)
+	}
+	out := slices.Clone(caps)
+	for i, cp := range out {
+		if cp.Impressions < 1 || cp.Impressions > maxCapImpressions {
+			return nil, fmt.Errorf("第 %d 条: impressions 必须在 1 到 %d 之间", i+1, maxCapImpressions)
+		}
+		if cp.Window < minCapWindow || cp.Window > c.maxWindow || cp.Window%time.Second != 0 {
+			return nil, fmt.Errorf("第 %d 条: window 必须是 %s 到 %s 之间的整秒数", i+1, minCapWindow, c.maxWindow)
+		}
+	}
+	slices.SortFunc(out, func(a, b models.FrequencyCap) int {
+		return cmp.Compare(a.Window, b.Window)
+	})
+	for i := 1; i < len(out); i++ {
+		if out[i].Window == out[i-1].Window {
+			return nil, fmt.Errorf("window %s 重复", out[i].Window)
+		}
+	}
+	return out, nil
+}
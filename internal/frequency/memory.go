@@ -0,0 +1,202 @@
+package frequency
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"log"
+	"sync"
+	"time"
+)
+
+// 内存存储的分片数、后台清理间隔和每个访问者最多保留的展示记录数 (超出时丢弃最早的记录)
+const (
+	memoryShards         = 64
+	sweepInterval        = time.Minute
+	maxImpressionsPerKey = 1000
+)
+
+// MemoryStore 进程内的 Store 实现 (默认)。不写数据库，记录不在多个实例之间共享，重启后清零。
+//
+// 访问者按标识的哈希分散到多个分片，每个分片有自己的锁，并发请求很少互相等待。
+// 分片内的访问者按最近一次展示的时间排成链表 (最近的在前)，最久没有展示的访问者也是最早过期的：
+// 达到容量时淘汰链表末尾的访问者，后台清理 (Run) 从末尾移除已过期的访问者，都不需要遍历整个分片
+type MemoryStore struct {
+	seed        maphash.Seed
+	shards      []memoryShard
+	retain      time.Duration
+	maxPerShard int // 每个分片最多保存的访问者数，0 表示不限
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	viewers map[string]*list.Element // 值为 *viewerRecord
+	lru     list.List                // 按最近一次展示的时间排序，Front 最近
+}
+
+type viewerRecord struct {
+	viewer  string
+	history History
+}
+
+// latest 最近一次展示的时间，没有记录时为零值
+func (r *viewerRecord) latest() time.Time {
+	if len(r.history) == 0 {
+		return time.Time{}
+	}
+	return r.history[len(r.history)-1].At
+}
+
+// NewMemoryStore 创建 MemoryStore。retain 为记录保留的时长；maxViewers 为最多保存的访问者数 (按分片平均分配，0 表示不限)，
+// 超出时淘汰最久没有展示的访问者。需要调用 Run 定期清理过期的访问者
+func NewMemoryStore(retain time.Duration, maxViewers int) *MemoryStore {
+	n := memoryShards
+	if maxViewers > 0 && maxViewers < n {
+		n = maxViewers
+	}
+	s := &MemoryStore{seed: maphash.MakeSeed(), shards: make([]memoryShard, n), retain: retain}
+	if maxViewers > 0 {
+		s.maxPerShard = (maxViewers + n - 1) / n
+	}
+	for i := range s.shards {
+		s.shards[i].viewers = make(map[string]*list.Element)
+	}
+	return s
+}
+
+func (s *MemoryStore) shard(viewer string) *memoryShard {
+	return &s.shards[maphash.String(s.seed, viewer)%uint64(len(s.shards))]
+}
+
+func (s *MemoryStore) History(ctx context.Context, viewer string, since time.Time) (History, error) {
+	sh := s.shard(viewer)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.viewers[viewer]
+	if !ok {
+		return nil, nil
+	}
+	h := e.Value.(*viewerRecord).history
+	i := len(h)
+	for i > 0 && h[i-1].At.After(since) {
+		i--
+	}
+	if i == len(h) {
+		return nil, nil
+	}
+	return append(History(nil), h[i:]...), nil
+}
+
+func (s *MemoryStore) Record(ctx context.Context, viewer string, imp Impression) error {
+	sh := s.shard(viewer)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.viewers[viewer]
+	if !ok {
+		if s.maxPerShard > 0 && len(sh.viewers) >= s.maxPerShard {
+			// 淘汰最久没有展示的访问者 (已过期的会先被淘汰)
+			sh.remove(sh.lru.Back())
+		}
+		e = sh.lru.PushFront(&viewerRecord{viewer: viewer})
+		sh.viewers[viewer] = e
+	}
+
+	rec := e.Value.(*viewerRecord)
+	h := s.pruned(rec.history, imp.At)
+	// 记录通常按时间顺序到达；并发请求可能稍有乱序，插入到正确的位置
+	i := len(h)
+	for i > 0 && h[i-1].At.After(imp.At) {
+		i--
+	}
+	h = append(h, Impression{})
+	copy(h[i+1:], h[i:])
+	h[i] = imp
+	if len(h) > maxImpressionsPerKey {
+		h = append(History(nil), h[len(h)-maxImpressionsPerKey:]...)
+	}
+	rec.history = h
+	sh.reorder(e)
+	return nil
+}
+
+// reorder 把访问者移到链表中按最近一次展示时间排序的位置。记录通常按时间顺序到达，只需要与链表开头的几个比较。
+// 调用时需要持有 sh.mu
+func (sh *memoryShard) reorder(e *list.Element) {
+	latest := e.Value.(*viewerRecord).latest()
+	for f := sh.lru.Front(); f != nil; f = f.Next() {
+		if f != e && !f.Value.(*viewerRecord).latest().After(latest) {
+			sh.lru.MoveBefore(e, f)
+			return
+		}
+	}
+	sh.lru.MoveToBack(e)
+}
+
+// Len 返回当前保存的访问者数
+func (s *MemoryStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += len(sh.viewers)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// Run 每隔 sweepInterval 清理一次过期的访问者，直到 ctx 被取消。应在单独的 goroutine 中调用。
+func (s *MemoryStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := s.Sweep(now); n > 0 {
+				log.Printf("frequency: 清理了 %d 个过期的访问者", n)
+			}
+		}
+	}
+}
+
+// Sweep 移除 now 时所有记录都已超过保留时长的访问者，返回移除的数量。
+// 每个分片从链表末尾开始，遇到第一个未过期的访问者就停止，开销与移除的数量成正比
+func (s *MemoryStore) Sweep(now time.Time) int {
+	cutoff := now.Add(-s.retain)
+	removed := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for e := sh.lru.Back(); e != nil; e = sh.lru.Back() {
+			if e.Value.(*viewerRecord).latest().After(cutoff) {
+				break
+			}
+			sh.remove(e)
+			removed++
+		}
+		sh.mu.Unlock()
+	}
+	return removed
+}
+
+// remove 从分片中移除访问者，调用时需要持有 sh.mu
+func (sh *memoryShard) remove(e *list.Element) {
+	delete(sh.viewers, e.Value.(*viewerRecord).viewer)
+	sh.lru.Remove(e)
+}
+
+// pruned 去掉 h 中超过保留时长的记录
+func (s *MemoryStore) pruned(h History, now time.Time) History {
+	cutoff := now.Add(-s.retain)
+	i := 0
+	for i < len(h) && !h[i].At.After(cutoff) {
+		i++
+	}
+	if i == 0 {
+		return h
+	}
+	return append(History(nil), h[i:]...)
+}
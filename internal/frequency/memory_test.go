@@ -0,0 +1,115 @@
+package frequency_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"advertisement/internal/frequency"
+)
+
+func record(t *testing.T, s *frequency.MemoryStore, viewer string, at time.Time) {
+	t.Helper()
+	if err := s.Record(context.Background(), viewer, frequency.Impression{CampaignID: 1, At: at}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func seen(t *testing.T, s *frequency.MemoryStore, viewer string, since time.Time) int {
+	t.Helper()
+	h, err := s.History(context.Background(), viewer, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(h)
+}
+
+func TestMemoryStoreEvictsLeastRecentlySeen(t *testing.T) {
+	// 容量为 1 时只有一个分片，新访问者淘汰最久没有展示的访问者
+	now := time.Now()
+	s := frequency.NewMemoryStore(time.Hour, 1)
+	record(t, s, "a", now)
+	record(t, s, "b", now.Add(time.Second))
+	if got := seen(t, s, "a", now.Add(-time.Hour)); got != 0 {
+		t.Errorf("evicted viewer has %d impressions, want 0", got)
+	}
+	if got := seen(t, s, "b", now.Add(-time.Hour)); got != 1 {
+		t.Errorf("new viewer has %d impressions, want 1", got)
+	}
+}
+
+func TestMemoryStoreCapacity(t *testing.T) {
+	now := time.Now()
+	const maxViewers = 1000
+	s := frequency.NewMemoryStore(time.Hour, maxViewers)
+	for i := 0; i < 10*maxViewers; i++ {
+		record(t, s, fmt.Sprintf("v%d", i), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	// 容量按分片平均分配 (向上取整)
+	if n := s.Len(); n > maxViewers+64 {
+		t.Errorf("Len = %d, want at most %d", n, maxViewers+64)
+	}
+	// 最近的访问者保留，最早的被淘汰
+	last := fmt.Sprintf("v%d", 10*maxViewers-1)
+	if got := seen(t, s, last, now.Add(-time.Hour)); got != 1 {
+		t.Errorf("most recent viewer has %d impressions, want 1", got)
+	}
+	if got := seen(t, s, "v0", now.Add(-time.Hour)); got != 0 {
+		t.Errorf("oldest viewer has %d impressions, want 0", got)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Now()
+	s := frequency.NewMemoryStore(time.Hour, 0)
+	for i := 0; i < 100; i++ {
+		record(t, s, fmt.Sprintf("old%d", i), now.Add(-2*time.Hour))
+		record(t, s, fmt.Sprintf("new%d", i), now.Add(-time.Minute))
+	}
+	// 再次展示的访问者不会被清理
+	record(t, s, "old0", now)
+
+	if n := s.Sweep(now); n != 99 {
+		t.Errorf("Sweep removed %d viewers, want 99", n)
+	}
+	if n := s.Len(); n != 101 {
+		t.Errorf("Len = %d, want 101", n)
+	}
+	if got := seen(t, s, "old0", now.Add(-time.Hour)); got != 1 {
+		t.Errorf("old0 has %d recent impressions, want 1", got)
+	}
+	if n := s.Sweep(now); n != 0 {
+		t.Errorf("second Sweep removed %d viewers, want 0", n)
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	now := time.Now()
+	s := frequency.NewMemoryStore(time.Hour, 500)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				viewer := fmt.Sprintf("v%d", (g*1000+i)%2000)
+				at := now.Add(time.Duration(i) * time.Millisecond)
+				if err := s.Record(context.Background(), viewer, frequency.Impression{CampaignID: g, At: at}); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.History(context.Background(), viewer, now); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s.Sweep(now.Add(2 * time.Hour))
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len after sweeping everything = %d, want 0", n)
+	}
+}
@@ -0,0 +1,95 @@
+package frequency
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// DeviceIDParam 客户端 (App、服务端对接) 传递设备 ID 的查询参数
+const DeviceIDParam = "device_id"
+
+// maxDeviceIDLength 设备 ID 的最大长度
+const maxDeviceIDLength = 128
+
+// CookieOptions 识别访问者的第一方 Cookie
+type CookieOptions struct {
+	Name   string
+	MaxAge time.Duration
+	// Secure 为 true 时 Cookie 带 Secure 和 SameSite=None，广告位嵌在其他站点 (跨站请求) 时也能带上 Cookie，要求 HTTPS；
+	// 为 false 时为 SameSite=Lax
+	Secure bool
+}
+
+// Identify 返回发起请求的访问者标识：优先使用 device_id 参数，否则使用 Cookie；
+// 没有有效的 Cookie 时生成新的访问者 ID 并通过 Set-Cookie 返回。生成失败时返回空字符串 (不做频次控制)
+func (c *Capper) Identify(w http.ResponseWriter, r *http.Request) string {
+	if id := c.Lookup(r); id != "" {
+		return id
+	}
+
+	id := newViewerID()
+	if id == "" {
+		return ""
+	}
+	sameSite := http.SameSiteLaxMode
+	if c.cookie.Secure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookie.Name,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(c.cookie.MaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   c.cookie.Secure,
+		SameSite: sameSite,
+	})
+	return "c:" + id
+}
+
+// Lookup 与 Identify 相同，但没有有效的 device_id 参数或 Cookie 时返回空字符串，不设置 Cookie
+func (c *Capper) Lookup(r *http.Request) string {
+	if id := r.URL.Query().Get(DeviceIDParam); validDeviceID(id) {
+		return "d:" + id
+	}
+	if cookie, err := r.Cookie(c.cookie.Name); err == nil && validViewerID(cookie.Value) {
+		return "c:" + cookie.Value
+	}
+	return ""
+}
+
+// newViewerID 生成 32 个十六进制字符的随机 ID
+func newViewerID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validViewerID 只接受 newViewerID 生成的格式
+func validViewerID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// validDeviceID 只接受字母、数字和 "-_.:" 组成的 ID (如 IDFA、Android ID、UUID)
+func validDeviceID(id string) bool {
+	if id == "" || len(id) > maxDeviceIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
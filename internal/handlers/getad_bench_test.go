@@ -0,0 +1,146 @@
+package handlers_test
+
+// GET /get-ad 处理一个请求的完整开销 (识别访问者、选择广告、记录展示并扣费、签发点击凭证、写响应)，
+// 比较展示事件的两种记录方式：
+//   - Sync:  每个请求同步调用 ChargeAdEvent (serving.event_workers = 0)
+//   - Async: 交给 serving.Recorder 在后台记录，请求不等待数据库
+//
+// ns/op 是请求的耗时；Async 的 ns/op-drained 还包括等待后台记录完所有事件的时间，即扣费的实际吞吐。
+//
+// 运行: go test ./internal/handlers -run '^$' -bench GetAd -benchmem
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"advertisement/internal/config"
+	"advertisement/internal/frequency"
+	"advertisement/internal/handlers"
+	"advertisement/internal/serving"
+	"advertisement/internal/store"
+	"advertisement/internal/targeting"
+)
+
+// newServingStore 创建一个迁移到最新版本的 SQLite 数据库 (见 newSQLiteDB)，并写入 n 个可投放的 CPM 广告活动 (出价 1.00 元 / 千次展示)
+func newServingStore(tb testing.TB, n int) store.Store {
+	tb.Helper()
+	ctx := tb.Context()
+	db := newSQLiteDB(tb)
+	now := time.Now()
+	res, err := db.ExecContext(ctx, "INSERT INTO users (username, password_hash, created_at) VALUES ('bench', 'x', ?)", now)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	userID, _ := res.LastInsertId()
+	res, err = db.ExecContext(ctx, "INSERT INTO organizations (name, balance, created_at) VALUES ('bench', 100000000, ?)", now)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	orgID, _ := res.LastInsertId()
+
+	start, end := now.AddDate(0, 0, -1), now.AddDate(1, 0, 0)
+	for i := 0; i < n; i++ {
+		res, err := db.ExecContext(ctx, `INSERT INTO advertisements (title, image_url, target_url, user_id, organization_id, status)
+            VALUES (?, 'https://example.com/ad.png', 'https://example.com', ?, ?, 'Approved')`, fmt.Sprintf("ad %d", i), userID, orgID)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		adID, _ := res.LastInsertId()
+		if _, err := db.ExecContext(ctx, `INSERT INTO ad_campaigns (advertisement_id, user_id, organization_id, start_date, end_date, status, bid_amount)
+            VALUES (?, ?, ?, ?, ?, 'Active', 100)`, adID, userID, orgID, start, end); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return store.NewSQLiteStore(db)
+}
+
+// newGetAdHandler 创建只用于 GET /get-ad 的 Handler，events 为 nil 时同步记录展示
+func newGetAdHandler(tb testing.TB, s store.Store, events func(*serving.Index) *serving.Recorder) *handlers.Handler {
+	tb.Helper()
+	ix := serving.NewIndex(s, serving.Options{RefreshInterval: time.Minute})
+	if err := ix.Refresh(tb.Context()); err != nil {
+		tb.Fatal(err)
+	}
+	capper := frequency.New(frequency.NewMemoryStore(time.Hour, 0), time.Hour, frequency.CookieOptions{Name: "adv_vid"})
+	var rec *serving.Recorder
+	if events != nil {
+		rec = events(ix)
+	}
+	return handlers.NewHandler(s, nil, config.MailConfig{}, config.TwoFactorConfig{}, nil, ix,
+		targeting.New(nil, time.UTC), capper, serving.NewClickSigner("bench", time.Hour), rec)
+}
+
+func getAd(h *handlers.Handler, i int) int {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/get-ad?device_id=d%d", i%1000), nil)
+	w := httptest.NewRecorder()
+	h.GetAdHandler(w, req)
+	return w.Code
+}
+
+func BenchmarkGetAdHandler(b *testing.B) {
+	for _, n := range []int{10, 1000} {
+		s := newServingStore(b, n)
+
+		b.Run(fmt.Sprintf("Sync/campaigns=%d", n), func(b *testing.B) {
+			h := newGetAdHandler(b, s, nil)
+			for i := 0; i < b.N; i++ {
+				if code := getAd(h, i); code != http.StatusOK {
+					b.Fatalf("status = %d", code)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("SyncParallel/campaigns=%d", n), func(b *testing.B) {
+			h := newGetAdHandler(b, s, nil)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if code := getAd(h, i); code != http.StatusOK {
+						b.Errorf("status = %d", code)
+						return
+					}
+				}
+			})
+		})
+
+		// 队列容量和每个活动的等待上限都为 b.N，请求不会改为同步记录
+		async := func(b *testing.B) (*handlers.Handler, *serving.Recorder) {
+			var rec *serving.Recorder
+			h := newGetAdHandler(b, s, func(ix *serving.Index) *serving.Recorder {
+				rec = serving.NewRecorder(s, ix, 4, b.N, b.N)
+				return rec
+			})
+			return h, rec
+		}
+		b.Run(fmt.Sprintf("Async/campaigns=%d", n), func(b *testing.B) {
+			h, rec := async(b)
+			start := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if code := getAd(h, i); code != http.StatusOK {
+					b.Fatalf("status = %d", code)
+				}
+			}
+			b.StopTimer()
+			rec.Close()
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N), "ns/op-drained")
+		})
+		b.Run(fmt.Sprintf("AsyncParallel/campaigns=%d", n), func(b *testing.B) {
+			h, rec := async(b)
+			start := time.Now()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if code := getAd(h, i); code != http.StatusOK {
+						b.Errorf("status = %d", code)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			rec.Close()
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N), "ns/op-drained")
+		})
+	}
+}
@@ -2,24 +2,39 @@ package handlers
 
 import (
 	//"database/sql" 不再需要
+	"context"
 	"errors"
 	"encoding/json"
 	"fmt"
+	"io"
 	// "errors" // GetAdHandler 里的 sql.ErrNoRows 不用 errors.Is
 	"time"
 	"log"
 	"math" // 需要 math 包处理金额转换
 	"math/rand" // 用于模拟支付
 	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"slices"
 	"strings"
 	"strconv" // 需要导入 strconv 来转换 URL 参数中的 ID
+	"unicode/utf8"
 
 	// "github.com/golang-jwt/jwt/v5" // 不再直接用 jwt
 	"golang.org/x/crypto/bcrypt"
 
 	// --- 导入内部包 ---
 	"advertisement/internal/store"	
+	"advertisement/internal/config"
+	"advertisement/internal/frequency"
+	"advertisement/internal/mail"
 	"advertisement/internal/models"
+	"advertisement/internal/ledger"
+	"advertisement/internal/money"
+	"advertisement/internal/payment"
+	"advertisement/internal/serving"
+	"advertisement/internal/targeting"
+	"advertisement/internal/throttle"
 	"advertisement/internal/auth"      // 替换 "your_module_name"
 	"advertisement/internal/middleware" // 替换 "your_module_name"
 	"advertisement/internal/webutil"   // 替换 "your_module_name"
@@ -30,15 +45,24 @@ const DateFormat = "2006-01-02" // 定义日期格式常量
 
 // --- 修改 Handler 结构体，依赖 Store 接口 ---
 type Handler struct {
-	Store store.Store // 不再是 *sql.Store，而是 Store 接口
+	Store     store.Store            // 不再是 *sql.Store，而是 Store 接口
+	Payments  payment.Provider       // 充值使用的支付渠道
+	Mail      config.MailConfig      // 邮箱验证 / 找回密码邮件的链接地址和有效期
+	TwoFactor config.TwoFactorConfig // 两步验证的 issuer、登录挑战有效期和错误次数上限
+	Throttle  *throttle.Throttler    // 登录限流 (按用户名和客户端 IP)
+	Serving   *serving.Index         // 投放索引 (GET /get-ad 从中选择广告)
+	Targeting *targeting.Targeter    // 识别访问者、校验活动的定向条件
+	Frequency *frequency.Capper      // 按访问者的频次控制
+	Clicks    *serving.ClickSigner   // 签发和校验点击凭证 (GET /get-ad -> GET /ads/click)
+	Events    *serving.Recorder      // 在后台记录展示事件并扣费，为 nil 时 GET /get-ad 同步记录
 }
 
 // --- 别忘了在 NewHandler 中初始化 rand ---
 
-func NewHandler(s store.Store) *Handler {
+func NewHandler(s store.Store, p payment.Provider, mailCfg config.MailConfig, twoFactorCfg config.TwoFactorConfig, t *throttle.Throttler, ix *serving.Index, tg *targeting.Targeter, fc *frequency.Capper, cs *serving.ClickSigner, ev *serving.Recorder) *Handler {
 	// rand.Seed(time.Now().UnixNano()) // 初始化随机数种子
 	rand.New(rand.NewSource(time.Now().UnixNano()))
-	return &Handler{Store: s}
+	return &Handler{Store: s, Payments: p, Mail: mailCfg, TwoFactor: twoFactorCfg, Throttle: t, Serving: ix, Targeting: tg, Frequency: fc, Clicks: cs, Events: ev}
 }
 
 // --- 新增：定义提交广告请求的结构体 ---
@@ -47,6 +71,9 @@ type SubmitAdRequest struct {
 	Title     string `json:"title"`
 	ImageURL  string `json:"image_url"`
 	TargetURL string `json:"target_url"`
+	Width     int    `json:"width"`  // 创意尺寸 (像素)，投放时只匹配尺寸相同的广告位
+	Height    int    `json:"height"`
+	Format    string `json:"format"` // image (默认) / html5 / video
 }
 
 // --- 新增：定义审核广告请求的结构体 ---
@@ -71,25 +98,46 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
         webutil.RespondWithError(w, http.StatusBadRequest, "用户名和密码不能为空") // 使用 webutil
         return
     }
+    email, ok := normalizeEmail(creds.Email)
+    if !ok {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请提供有效的邮箱地址")
+        return
+    }
+    // 注册按客户端 IP 限流：用户名 / 邮箱重复计为一次失败，防止批量探测已注册的账号
+    if !h.checkLoginThrottle(w, r, "") {
+        return
+    }
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
     if err != nil {
         log.Printf("密码哈希失败: %v", err)
         webutil.RespondWithError(w, http.StatusInternalServerError, "服务器内部错误") // 使用 webutil
         return
     }
-	// --- 调用 Store 创建用户 ---
-	err = h.Store.CreateUser(r.Context(), creds.Username, string(hashedPassword))
+	// --- 调用 Store 创建用户 (邮箱未验证，验证前不能登录) ---
+	user := &models.User{Username: creds.Username, PasswordHash: string(hashedPassword), Email: &email}
+	err = h.Store.CreateUser(r.Context(), user)
 	if err != nil {
 		if errors.Is(err, store.ErrDuplicateUser) { // 检查是否是用户名重复错误
+			h.recordLoginFailure(r, "")
 			webutil.RespondWithError(w, http.StatusConflict, "用户名已存在")
+		} else if errors.Is(err, store.ErrDuplicateEmail) {
+			h.recordLoginFailure(r, "")
+			webutil.RespondWithError(w, http.StatusConflict, "邮箱已被注册")
 		} else {
 			log.Printf("调用 Store 创建用户失败: %v", err) // 记录包装后的错误
 			webutil.RespondWithError(w, http.StatusInternalServerError, "注册失败，请稍后重试")
 		}
 		return
 	}
+    // 验证邮件发送失败不影响注册，用户可以通过 POST /email/verify/resend 重新获取
+    if err := h.sendAccountEmail(r.Context(), user, models.TokenPurposeVerifyEmail); err != nil {
+        log.Printf("为用户 %d 生成验证邮件失败: %v", user.ID, err)
+    }
     log.Printf("用户注册成功: %s", creds.Username)
-    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{Message: "注册成功"}) // 使用 webutil
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{
+        Message: "注册成功，请查收验证邮件完成邮箱验证",
+        Data:    map[string]interface{}{"id": user.ID, "username": user.Username, "email": user.Email},
+    }) // 使用 webutil
 }
 
 
@@ -109,11 +157,18 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
     if creds.Username == "" || creds.Password == "" {
         webutil.RespondWithError(w, http.StatusBadRequest, "用户名和密码不能为空") // 使用 webutil
         return
+    }
+    // --- 登录限流：处于退避或锁定期时不校验密码，直接返回 429 ---
+    if !h.checkLoginThrottle(w, r, creds.Username) {
+        return
     }
 	// --- 调用 Store 获取用户 ---
 	user, err := h.Store.GetUserByUsername(r.Context(), creds.Username)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) { // 检查是否是用户未找到错误
+			// 不存在的用户名同样计数，避免通过限流行为区分用户名是否存在
+			h.recordLoginFailure(r, creds.Username)
+			h.auditLogin(r, models.AuditLoginFailed, nil, creds.Username, map[string]string{"reason": "unknown_user"})
 			webutil.RespondWithError(w, http.StatusUnauthorized, "用户名或密码错误")
 		} else {
 			log.Printf("调用 Store 获取用户失败: %v", err)
@@ -125,30 +180,144 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password))
 	if err != nil {
 		// 密码不匹配也返回通用错误信息
+		h.recordLoginFailure(r, creds.Username)
+		h.auditLogin(r, models.AuditLoginFailed, user, creds.Username, map[string]string{"reason": "wrong_password"})
 		webutil.RespondWithError(w, http.StatusUnauthorized, "用户名或密码错误")
 		return
 	}
+	// 已停用的账号在密码正确时才提示，避免泄露账号状态
+	if user.SuspendedAt != nil {
+		webutil.RespondWithError(w, http.StatusForbidden, accountSuspendedMessage)
+		return
+	}
+	// 新注册的用户必须先验证邮箱 (没有邮箱的旧用户不受影响)
+	if user.Email != nil && user.EmailVerifiedAt == nil {
+		webutil.RespondWithError(w, http.StatusForbidden, "邮箱尚未验证，请先打开验证邮件中的链接")
+		return
+	}
+
+    // --- 已启用两步验证：密码正确只换来登录挑战，凭 mfa_token + 验证码在 POST /login/2fa 换取令牌 ---
+    if user.TOTPEnabledAt != nil {
+        mfaToken, mfaHash, err := auth.NewUserToken()
+        if err != nil {
+            log.Printf("为用户 %s 生成登录挑战失败: %v", user.Username, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+            return
+        }
+        now := time.Now()
+        challenge := &models.LoginChallenge{
+            UserID:    user.ID,
+            TokenHash: mfaHash,
+            CreatedAt: now,
+            ExpiresAt: now.Add(h.TwoFactor.ChallengeTTL.Duration),
+        }
+        if err := h.Store.CreateLoginChallenge(r.Context(), challenge); err != nil {
+            log.Printf("为用户 %s 保存登录挑战失败: %v", user.Username, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+            return
+        }
+        webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+            Message: "请输入两步验证码",
+            Data: map[string]interface{}{
+                "mfa_required":   true,
+                "mfa_token":      mfaToken,
+                "mfa_expires_at": challenge.ExpiresAt,
+            },
+        })
+        return
+    }
 
-    // --- 修改：传递 user.Role 给 GenerateJWT ---
-    tokenString, _, err := auth.GenerateJWT(user.ID, user.Username, user.Role) // 传递 Role
+    // --- 签发短期访问令牌 + 刷新令牌 (新的登录会话) ---
+    h.respondLoginSuccess(w, r, user)
+}
+
+// respondLoginSuccess 开始新的登录会话并返回令牌。
+// 必须启用两步验证但尚未绑定的账号只拿到受限令牌 (只能用于绑定两步验证)
+func (h *Handler) respondLoginSuccess(w http.ResponseWriter, r *http.Request, user *models.User) {
+    tokens, err := h.issueTokens(r, user, "")
     if err != nil {
-        log.Printf("生成 JWT 失败: %v", err)
+        log.Printf("为用户 %s 签发令牌失败: %v", user.Username, err)
         webutil.RespondWithError(w, http.StatusInternalServerError, "登录时无法生成凭证")
         return
     }
 
+    if err := h.Throttle.Succeed(r.Context(), user.Username); err != nil {
+        log.Printf("清除用户 %s 的登录失败记录失败: %v", user.Username, err)
+    }
+
+    enrollmentRequired := needsTwoFactorEnrollment(user)
+    message := "登录成功"
+    if enrollmentRequired {
+        message = "登录成功，请先启用两步验证"
+    }
     log.Printf("用户登录成功: %s (Role: %s), 生成 Token", user.Username, user.Role) // 日志可以加上角色
+    h.auditLogin(r, models.AuditLogin, user, user.Username, map[string]bool{
+        "two_factor":                     user.TOTPEnabledAt != nil,
+        "two_factor_enrollment_required": enrollmentRequired,
+    })
     webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
-        Message: "登录成功",
+        Message: message,
         Data:    map[string]interface{} {
-            "token": tokenString,
+            "token": tokens.Token,
+            "expires_at": tokens.ExpiresAt,
+            "refresh_token": tokens.RefreshToken,
+            "refresh_expires_at": tokens.RefreshExpiresAt,
             "id": user.ID,
             "username": user.Username,
             "role": user.Role,
+            "default_organization_id": user.DefaultOrganizationID,
+            "two_factor_enrollment_required": enrollmentRequired,
         },
     })
 }
 
+// needsTwoFactorEnrollment 账号必须启用两步验证 (账号设置或后台角色) 但还没有绑定验证器
+func needsTwoFactorEnrollment(user *models.User) bool {
+    return auth.RequiresTwoFactor(user.Role, user.TwoFactorRequired) && user.TOTPEnabledAt == nil
+}
+
+// issueTokens 为用户签发访问令牌和刷新令牌。
+// rotateFrom 为空时开始新的登录会话；否则轮换 rotateFrom (旧刷新令牌的哈希)，错误来自 Store.RotateRefreshToken
+func (h *Handler) issueTokens(r *http.Request, user *models.User, rotateFrom string) (*models.TokenResponse, error) {
+    accessToken, jti, accessExpiresAt, err := auth.GenerateJWT(user.ID, user.Username, user.Role, needsTwoFactorEnrollment(user))
+    if err != nil {
+        return nil, err
+    }
+    refreshToken, refreshHash, err := auth.NewRefreshToken()
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    rt := &models.RefreshToken{
+        UserID:          user.ID,
+        TokenHash:       refreshHash,
+        AccessJTI:       jti,
+        AccessExpiresAt: accessExpiresAt,
+        ExpiresAt:       now.Add(auth.RefreshTTL()),
+        CreatedAt:       now,
+    }
+    if rotateFrom == "" {
+        if rt.SessionID, err = auth.NewSessionID(); err != nil {
+            return nil, err
+        }
+        err = h.Store.CreateRefreshToken(r.Context(), rt)
+    } else {
+        err = h.Store.RotateRefreshToken(r.Context(), rotateFrom, rt, now)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &models.TokenResponse{
+        Token:            accessToken,
+        ExpiresAt:        accessExpiresAt,
+        RefreshToken:     refreshToken,
+        RefreshExpiresAt: rt.ExpiresAt,
+    }, nil
+}
+
+// maxServeAttempts 一次 GET /get-ad 最多尝试计费的活动数 (快照中的活动可能已不能投放)
+const maxServeAttempts = 3
+
 // GetAdHandler 处理获取广告的请求 (给广告位调用)
 func (h *Handler) GetAdHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
@@ -156,64 +325,133 @@ func (h *Handler) GetAdHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // 1. 选择一个当前有效的广告活动
-    // 在 store 层增加 GetActiveRandomCampaign 方法 (需要实现)
-    // 或者简化：先随机选一个 Approved 的活动，再获取其广告
-    campaign, err := h.Store.GetRandomActiveCampaign(r.Context()) // <--- 需要在 Store 实现此方法
-    if err != nil {
-        if errors.Is(err, store.ErrNotFound) {
-            // 没有可投放的广告是正常情况
-            log.Println("没有找到可投放的广告活动")
-            webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "没有可用的广告"}) // 返回 200 但内容为空
+    // 0. 可选的广告位 (?placement=ID)：只返回尺寸、格式匹配且出价不低于底价的创意
+    //    广告位也在投放索引的快照里，不需要查询数据库
+    var placement *models.Placement
+    var placementID *int
+    if idStr := r.URL.Query().Get("placement"); idStr != "" {
+        id, err := strconv.Atoi(idStr)
+        if err != nil || id <= 0 {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的广告位 ID")
             return
         }
-        log.Printf("选择活动失败: %v", err)
-        webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告时出错")
-        return
+        p, ok := h.Serving.Placement(id)
+        if !ok {
+            webutil.RespondWithError(w, http.StatusNotFound, "广告位不存在")
+            return
+        }
+        if p.Status != models.PlacementStatusActive {
+            webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "没有可用的广告"})
+            return
+        }
+        placement, placementID = p, &p.ID
     }
 
-    // 2. 获取该活动关联的广告创意信息
-    ad, err := h.Store.GetAdvertisementByID(r.Context(), campaign.AdvertisementID)
-    if err != nil {
-        // 如果广告被删除但活动还在，可能出现此情况
-        log.Printf("获取活动 %d 关联的广告 %d 失败: %v", campaign.ID, campaign.AdvertisementID, err)
-        webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告详情时出错")
-        return
+    // 0.1 识别访问者 (国家/地区、设备、操作系统、语言、当地时间)，只选择定向条件匹配的活动
+    viewer := h.Targeting.Viewer(r, middleware.ClientIPFromRequest(r), time.Now())
+
+    // 0.2 访问者标识 (device_id 参数或 Cookie，没有时设置新的 Cookie) 和最近的展示记录，跳过已达到频次上限的活动
+    //     读取失败时不阻止投放，只是这次不做频次控制
+    viewerID := h.Frequency.Identify(w, r)
+    var seen frequency.History
+    if viewerID != "" {
+        var err error
+        if seen, err = h.Frequency.History(r.Context(), viewerID, time.Now()); err != nil {
+            log.Printf("读取访问者展示记录失败: %v", err)
+        }
     }
 
-    // 3. --- 记录 Impression 事件 ---
-    impressionEvent := models.AdEvent{
-        EventType:       "Impression",
-        AdvertisementID: ad.ID,
-        CampaignID:      campaign.ID,
-        UserID:          campaign.UserID, // 活动创建者的 ID
-        EventTimestamp:  time.Now(),
-    }
-    logErr := h.Store.LogAdEvent(r.Context(), impressionEvent)
-    if logErr != nil {
-        // 记录失败不应阻止广告返回，但需要记录日志
-        log.Printf("!!! 记录 Impression 事件失败 (但广告已返回): campaign %d, ad %d: %v", campaign.ID, ad.ID, logErr)
-    } else {
-         log.Printf("记录 Impression: campaign %d, ad %d", campaign.ID, ad.ID)
-    }
+    // 1. 从投放索引 (内存快照) 中选择广告，活动和创意信息都在快照里，不需要查询数据库
+    //    符合条件的活动按 eCPM 竞价 (serving.selector)，成交价为第二高出价与底价中较高者
+    //    快照可能略有过期：计费时被拒绝的活动从快照中移除，再重新竞价，最多尝试 maxServeAttempts 次 (只有同步记录时)
+    for attempt := 0; attempt < maxServeAttempts; attempt++ {
+        now := time.Now()
+        sel, ok := h.Serving.Pick(serving.Request{Now: now, Placement: placement, Viewer: viewer, Seen: seen})
+        if !ok {
+            break
+        }
+        ad := sel.Ad
+        clearingPrice := money.New(sel.Price)
+
+        // 2. --- 记录 Impression 事件 (带成交价) 并扣费 (CPM 按成交价) ---
+        //    通常交给后台 (serving.Recorder) 记录，不等待数据库；计费被拒绝时由后台把活动从快照中移除。
+        //    后台队列已满、活动等待记录的展示已达上限 (或没有启用) 时同步记录，被拒绝的活动这次就不展示，重新竞价
+        impressionEvent := models.AdEvent{
+            EventType:       "Impression",
+            AdvertisementID: ad.AdvertisementID,
+            CampaignID:      ad.CampaignID,
+            UserID:          ad.UserID, // 活动创建者的 ID
+            EventTimestamp:  now,
+            PlacementID:     placementID,
+            ClearingPrice:   &clearingPrice,
+        }
+        if h.Events == nil || !h.Events.Enqueue(impressionEvent) {
+            logErr := h.Store.ChargeAdEvent(r.Context(), &impressionEvent)
+            if serving.Rejected(logErr) {
+                // 快照生成之后活动的预算、余额或状态发生了变化，这次不展示该活动
+                log.Printf("活动 %d 已不能投放，从投放索引中移除: %v", ad.CampaignID, logErr)
+                h.Serving.Exclude(ad.CampaignID)
+                continue
+            }
+            if logErr != nil {
+                // 记录失败不应阻止广告返回，但需要记录日志
+                log.Printf("!!! 记录 Impression 事件失败 (但广告已返回): campaign %d, ad %d: %v", ad.CampaignID, ad.AdvertisementID, logErr)
+            }
+        }
+        if viewerID != "" {
+            if err := h.Frequency.Record(r.Context(), viewerID, ad.CampaignID, now); err != nil {
+                log.Printf("记录访问者展示失败: campaign %d: %v", ad.CampaignID, err)
+            }
+        }
+
+        // 3. 签发点击凭证：点击跟踪链接只有带上它才计费 (绑定活动、创意、广告位和访问者，只能计费一次)
+        //    CPC 活动的凭证带上每次点击的成交价 (第二价格按预估点击率换算)，点击时按它计费
+        clickURL := ""
+        claims := serving.ClickClaims{CampaignID: ad.CampaignID, AdvertisementID: ad.AdvertisementID, ClickPrice: sel.ClickPrice}
+        if placementID != nil {
+            claims.PlacementID = *placementID
+        }
+        if token, err := h.Clicks.Issue(claims, viewerID, now); err != nil {
+            log.Printf("签发点击凭证失败: campaign %d: %v", ad.CampaignID, err)
+        } else {
+            query := url.Values{"token": {token}}
+            if placementID != nil {
+                query.Set("placement", strconv.Itoa(*placementID))
+            }
+            clickURL = fmt.Sprintf("/ads/click/%d/%d?%s", ad.CampaignID, ad.AdvertisementID, query.Encode())
+        }
 
+        // 4. 准备并返回广告数据给广告位
+        adResponse := struct {
+            CampaignID      int    `json:"campaign_id"` // 传递 CampaignID 可能对后续点击追踪有用
+            AdvertisementID int    `json:"advertisement_id"`
+            Title           string `json:"title"`
+            ImageURL        string `json:"image_url"`
+            TargetURL       string `json:"target_url"` // 点击后跳转的地址
+            Width           int    `json:"width"`
+            Height          int    `json:"height"`
+            Format          string `json:"format"`
+            PlacementID     *int   `json:"placement_id,omitempty"`
+            ClickURL        string `json:"click_url"` // 点击跟踪链接 (相对路径，带点击凭证和广告位)
+        }{
+            CampaignID:      ad.CampaignID,
+            AdvertisementID: ad.AdvertisementID,
+            Title:           ad.Title,
+            ImageURL:        ad.ImageURL,
+            TargetURL:       ad.TargetURL,
+            Width:           ad.Width,
+            Height:          ad.Height,
+            Format:          ad.Format,
+            PlacementID:     placementID,
+            ClickURL:        clickURL,
+        }
 
-    // 4. 准备并返回广告数据给广告位
-    adResponse := struct {
-        CampaignID      int    `json:"campaign_id"` // 传递 CampaignID 可能对后续点击追踪有用
-        AdvertisementID int    `json:"advertisement_id"`
-        Title           string `json:"title"`
-        ImageURL        string `json:"image_url"`
-        TargetURL       string `json:"target_url"` // 点击后跳转的地址
-    }{
-        CampaignID:      campaign.ID,
-        AdvertisementID: ad.ID,
-        Title:           ad.Title,
-        ImageURL:        ad.ImageURL,
-        TargetURL:       ad.TargetURL,
+        webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: adResponse})
+        return
     }
 
-    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: adResponse})
+    // 没有可投放的广告是正常情况
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "没有可用的广告"}) // 返回 200 但内容为空
 }
 
 // --- 新增：SubmitAdHandler 方法处理广告提交 ---
@@ -233,6 +471,10 @@ func (h *Handler) SubmitAdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := userClaims.UserID // 获取用户 ID
+	member, ok := currentOrganization(w, r)
+	if !ok {
+		return
+	}
 
 	// 3. 解码请求体
 	var req SubmitAdRequest
@@ -258,14 +500,30 @@ func (h *Handler) SubmitAdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// 在真实应用中可能需要更复杂的验证, 比如 URL 格式检查
+	if msg := validateAdSize(req.Width, req.Height); msg != "" {
+		webutil.RespondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(req.Format))
+	if format == "" {
+		format = models.AdFormatImage
+	}
+	if !models.ValidAdFormat(format) {
+		webutil.RespondWithError(w, http.StatusBadRequest, "无效的创意格式，应为 "+strings.Join(models.AdFormats(), ", ")+" 之一")
+		return
+	}
 
 	// --- 创建 Advertisement 模型对象 ---
 	ad := &models.Advertisement{
 		Title:     req.Title,
 		ImageURL:  req.ImageURL,
 		TargetURL: req.TargetURL,
-		UserID:    userID,      // 从 Token 获取
+		OrganizationID: member.OrganizationID, // 广告属于当前组织
+		UserID:    userID,      // 从 Token 获取 (提交的成员)
 		Status:    "Pending", // 设置初始状态
+		Width:     req.Width,
+		Height:    req.Height,
+		Format:    format,
 	}
 
 	// --- 调用 Store 创建广告 ---
@@ -276,7 +534,7 @@ func (h *Handler) SubmitAdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("用户 %d 成功提交广告, 新广告 ID: %d", userID, newAdID)
+	log.Printf("用户 %d 为组织 %d 成功提交广告, 新广告 ID: %d", userID, member.OrganizationID, newAdID)
 	responsePayload := webutil.Response{
 		Message: "广告提交成功，等待审核",
 		Data:    map[string]int64{"new_ad_id": newAdID},
@@ -300,18 +558,23 @@ func (h *Handler) GetUserAdsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userID := userClaims.UserID
+	member, ok := currentOrganization(w, r)
+	if !ok {
+		return
+	}
+	orgID := member.OrganizationID
 
-	// --- 调用 Store 获取用户广告列表 ---
-	userAds, err := h.Store.GetAdvertisementsByUserID(r.Context(), userID)
+	// --- 调用 Store 获取组织的广告列表 ---
+	userAds, err := h.Store.GetAdvertisementsByOrganization(r.Context(), orgID)
 	if err != nil {
 		// 这里假设 Store 层已经记录了具体错误，Handler 只需返回通用错误
-		log.Printf("调用 Store 获取用户 %d 的广告失败: %v", userID, err)
+		log.Printf("调用 Store 获取组织 %d 的广告失败 (用户 %d): %v", orgID, userID, err)
 		webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告列表失败")
 		return
 	}
-	// 注意: Store 返回 nil 错误和空切片表示用户没有广告，这是正常情况
+	// 注意: Store 返回 nil 错误和空切片表示组织没有广告，这是正常情况
 
-	log.Printf("成功获取用户 %d 的 %d 条广告", userID, len(userAds))
+	log.Printf("成功获取组织 %d 的 %d 条广告", orgID, len(userAds))
 	webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: userAds}) // 直接返回从 Store 获取的切片
 }
 
@@ -397,7 +660,7 @@ func (h *Handler) ReviewAdHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
     // (可选但推荐): 调用 Store 检查广告是否存在，可以提前返回 404
-    _, err = h.Store.GetAdvertisementByID(r.Context(), adID)
+    ad, err := h.Store.GetAdvertisementByID(r.Context(), adID)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
             webutil.RespondWithError(w, http.StatusNotFound, "找不到指定的广告")
@@ -438,6 +701,8 @@ func (h *Handler) ReviewAdHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 6. 返回成功响应
 	log.Printf("管理员成功将广告 %d 状态更新为 %s", adID, newStatus)
+	h.audit(r, models.AuditAdReview, models.AuditTargetAdvertisement, adID,
+		map[string]string{"status": ad.Status}, map[string]string{"status": newStatus})
 	webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
 		Message: fmt.Sprintf("广告 %d 状态已更新为 %s", adID, newStatus),
 	})
@@ -458,11 +723,18 @@ func (h *Handler) RequestCampaignHandler(w http.ResponseWriter, r *http.Request)
         return
     }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok {
+        return
+    }
+    orgID := member.OrganizationID
 
     // 2. 解码请求体
     var reqData models.CampaignRequestData
     if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
-        webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，需要 advertisement_id, start_date (YYYY-MM-DD), end_date (YYYY-MM-DD)")
+        if !respondMoneyError(w, "金额", err) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，需要 advertisement_id, start_date (YYYY-MM-DD), end_date (YYYY-MM-DD)")
+        }
         return
     }
     defer r.Body.Close()
@@ -495,8 +767,55 @@ func (h *Handler) RequestCampaignHandler(w http.ResponseWriter, r *http.Request)
     //     return
     // }
 
+    // 4.1 验证计费方式、出价和预算 (金额在解码时已精确转换为分)
+    pricingModel := strings.ToUpper(strings.TrimSpace(reqData.PricingModel))
+    if pricingModel == "" {
+        pricingModel = models.PricingCPM
+    }
+    if pricingModel != models.PricingCPM && pricingModel != models.PricingCPC {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的计费方式，只能是 CPM 或 CPC")
+        return
+    }
+    if reqData.BidAmount.Minor <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "出价 bid_amount 必须大于 0")
+        return
+    }
+    if err := reqData.BidAmount.CheckRange(); err != nil {
+        respondMoneyError(w, "出价 bid_amount", err)
+        return
+    }
+    if reqData.TotalBudget.Minor <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "总预算 total_budget 必须大于 0")
+        return
+    }
+    if err := reqData.TotalBudget.CheckRange(); err != nil {
+        respondMoneyError(w, "总预算 total_budget", err)
+        return
+    }
+    if reqData.DailyBudget.Minor < 0 || reqData.DailyBudget.Minor > reqData.TotalBudget.Minor {
+        webutil.RespondWithError(w, http.StatusBadRequest, "每日预算 daily_budget 不能为负数，也不能超过总预算")
+        return
+    }
+    if !reqData.DailyBudget.IsZero() {
+        if err := reqData.DailyBudget.CheckRange(); err != nil {
+            respondMoneyError(w, "每日预算 daily_budget", err)
+            return
+        }
+    }
+
+    // 4.2 验证定向条件 (国家/地区、设备、操作系统、语言、投放时段)，统一为规范形式后保存
+    if err := h.Targeting.Normalize(&reqData.Targeting); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "定向条件无效: "+err.Error())
+        return
+    }
+    // 4.3 验证频次上限 (每个访问者在时间窗口内最多看到的次数)
+    frequencyCaps, err := h.Frequency.Normalize(reqData.FrequencyCaps)
+    if err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "频次上限无效: "+err.Error())
+        return
+    }
 
-    // 5. 验证广告创意是否存在、是否已批准、是否属于当前用户
+    // 5. 验证广告创意是否存在、是否已批准、是否属于当前组织
     adCreative, err := h.Store.GetAdvertisementByID(r.Context(), reqData.AdvertisementID)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
@@ -507,8 +826,8 @@ func (h *Handler) RequestCampaignHandler(w http.ResponseWriter, r *http.Request)
         }
         return
     }
-    if adCreative.UserID != userID {
-        webutil.RespondWithError(w, http.StatusForbidden, "不能为不属于自己的广告创意请求活动")
+    if adCreative.OrganizationID != orgID {
+        webutil.RespondWithError(w, http.StatusForbidden, "不能为不属于当前组织的广告创意请求活动")
         return
     }
     if adCreative.Status != "Approved" {
@@ -519,10 +838,19 @@ func (h *Handler) RequestCampaignHandler(w http.ResponseWriter, r *http.Request)
     // 6. 创建 AdCampaign 对象
     campaign := &models.AdCampaign{
         AdvertisementID: reqData.AdvertisementID,
+        OrganizationID: orgID,
         UserID:         userID,
         StartDate:      startDate,
         EndDate:        endDate,
         Status:         "Pending", // 新请求默认为 Pending
+        CampaignBudget: models.CampaignBudget{
+            PricingModel: pricingModel,
+            BidAmount:    reqData.BidAmount,
+            TotalBudget:  reqData.TotalBudget,
+            DailyBudget:  reqData.DailyBudget,
+        },
+        Targeting:      reqData.Targeting,
+        FrequencyCaps:  frequencyCaps,
     }
 
     // 7. 调用 Store 创建活动请求
@@ -572,17 +900,19 @@ func (h *Handler) ReviewCampaignHandler(w http.ResponseWriter, r *http.Request)
         return
     }
 
-    // (可选) 检查活动是否存在且状态为 Pending
-    // campaign, err := h.Store.GetAdCampaignByID(r.Context(), campaignID)
-    // if err != nil { ... handle ErrNotFound ... }
-    // if campaign.Status != "Pending" { ... handle already reviewed ... }
-
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil {
+        webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息")
+        return
+    }
 
-    // 4. 调用 Store 更新活动状态
-    err = h.Store.UpdateAdCampaignStatus(r.Context(), campaignID, newStatus)
+    // 4. 调用 Store 更新活动状态 (Store 会校验只有 Pending 的活动可以被审核，并记录状态历史)
+    err = h.Store.UpdateAdCampaignStatus(r.Context(), campaignID, newStatus, adminClaims.UserID)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
             webutil.RespondWithError(w, http.StatusNotFound, "找不到要审核的广告活动")
+        } else if errors.Is(err, store.ErrInvalidTransition) {
+            webutil.RespondWithError(w, http.StatusConflict, "该广告活动当前状态不允许审核")
         } else {
             log.Printf("更新广告活动 %d 状态失败: %v", campaignID, err)
             webutil.RespondWithError(w, http.StatusInternalServerError, "更新活动状态失败")
@@ -592,11 +922,36 @@ func (h *Handler) ReviewCampaignHandler(w http.ResponseWriter, r *http.Request)
 
     // 5. 返回成功响应
     log.Printf("管理员成功将广告活动 %d 状态更新为 %s", campaignID, newStatus)
+    // Store 只允许审核 Pending 的活动
+    h.audit(r, models.AuditCampaignReview, models.AuditTargetCampaign, campaignID,
+        map[string]string{"status": models.CampaignStatusPending}, map[string]string{"status": newStatus})
+    // 活动状态、账号状态或余额变化后刷新投放索引 (下同)
+    h.Serving.Invalidate()
     webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
         Message: fmt.Sprintf("广告活动 %d 状态已更新为 %s", campaignID, newStatus),
     })
 }
 
+// --- respondMoneyError 将金额解析 / 校验错误转换为 400 响应；不是金额错误时返回 false ---
+func respondMoneyError(w http.ResponseWriter, field string, err error) bool {
+    switch {
+    case errors.Is(err, money.ErrTooManyDecimals):
+        webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s最多只能有两位小数", field))
+    case errors.Is(err, money.ErrInvalidFormat):
+        webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s格式无效，应为形如 \"10.50\" 的金额", field))
+    case errors.Is(err, money.ErrBelowMinimum):
+        webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s不能低于 %s", field, money.Minimum()))
+    case errors.Is(err, money.ErrAboveMaximum):
+        max, _ := money.Maximum()
+        webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s不能超过 %s", field, max))
+    case errors.Is(err, money.ErrCurrencyMismatch):
+        webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s的币种必须为 %s", field, money.Currency()))
+    default:
+        return false
+    }
+    return true
+}
+
 // --- RechargeHandler 处理充值请求 ---
 func (h *Handler) RechargeHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
@@ -611,86 +966,82 @@ func (h *Handler) RechargeHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok {
+        return
+    }
+    orgID := member.OrganizationID
 
     // 2. 解码请求体
     var req models.RechargeRequest
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，应为 {'amount': 100.50}")
+        if !respondMoneyError(w, "充值金额", err) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，应为 {'amount': \"100.50\"}")
+        }
         return
     }
     defer r.Body.Close()
 
-    // 3. 验证金额
-    if req.Amount <= 0 {
+    // 3. 验证金额 (解码时已精确转换为分，不经过 float64)
+    if req.Amount.Minor <= 0 {
         webutil.RespondWithError(w, http.StatusBadRequest, "充值金额必须大于 0")
         return
     }
-    // 将用户输入的金额（元）转换为分的整数
-    // 注意：直接乘法可能因浮点数不精确导致错误，推荐先转为字符串处理或使用特定库
-    // 简单处理：乘以 100 并四舍五入到最近的整数分
-    amountInCents := int64(math.Round(req.Amount * 100))
-    if amountInCents <= 0 {
-        // 再次检查，防止极小的正浮点数四舍五入后变成 0
-         webutil.RespondWithError(w, http.StatusBadRequest, "无效的充值金额（处理后为 0 分）")
-         return
+    if err := req.Amount.CheckRange(); err != nil {
+        respondMoneyError(w, "充值金额", err)
+        return
     }
+    amountInCents := req.Amount.Minor
 
 
-    // 4. 创建初始充值记录 (Pending)
-    paymentMethod := "Simulated" // 当前只支持模拟支付
-    rechargeRecordID, err := h.Store.CreateRechargeTransaction(r.Context(), userID, amountInCents, paymentMethod)
+    // 4. 创建初始充值记录 (Pending)，支付方式为当前的支付渠道
+    paymentMethod := h.Payments.Name()
+    rechargeRecordID, err := h.Store.CreateRechargeTransaction(r.Context(), orgID, userID, amountInCents, paymentMethod)
     if err != nil {
-        log.Printf("创建充值记录失败 (用户 %d): %v", userID, err)
+        log.Printf("创建充值记录失败 (组织 %d, 用户 %d): %v", orgID, userID, err)
         webutil.RespondWithError(w, http.StatusInternalServerError, "处理充值请求失败（无法创建记录）")
         return
     }
-    log.Printf("用户 %d 发起充值 %d 分，创建记录 ID: %d", userID, amountInCents, rechargeRecordID)
-
-
-    // --- 5. 模拟支付过程 ---
-    // 简单模拟：80% 成功率
-    paymentSuccess := rand.Float32() < 0.80 // 0.0 <= f < 1.0
-
-    if paymentSuccess {
-        // 模拟支付成功
-        simulatedTxID := fmt.Sprintf("SIM_TX_%d_%d", time.Now().UnixNano(), rechargeRecordID)
-        log.Printf("模拟支付成功 (记录 ID: %d), TxID: %s", rechargeRecordID, simulatedTxID)
-
-        // 调用 Store 原子性地更新余额和交易状态
-        err = h.Store.ProcessSuccessfulRecharge(r.Context(), userID, amountInCents, rechargeRecordID, simulatedTxID)
-        if err != nil {
-            log.Printf("处理成功充值失败 (用户 %d, 记录 %d): %v", userID, rechargeRecordID, err)
-            // 此时数据库可能处于不一致状态（记录可能是 Pending 但支付已模拟成功）
-            // 最好尝试将记录标记为需要人工处理的状态，但这里简化处理
-            // 仍然尝试将记录标记为 Failed，虽然不完全准确
-            _ = h.Store.UpdateRechargeTransactionStatus(r.Context(), rechargeRecordID, "Failed", "PROCESSING_ERROR") // 忽略错误
-            webutil.RespondWithError(w, http.StatusInternalServerError, "充值处理失败（更新账户时出错）")
-            return
-        }
-
-        log.Printf("用户 %d 充值 %d 分成功 (记录 ID: %d)", userID, amountInCents, rechargeRecordID)
-        webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
-            Message: fmt.Sprintf("充值 %.2f 元成功", req.Amount),
-            Data:    map[string]string{"transaction_id": simulatedTxID},
-        })
-
-    } else {
-        // 模拟支付失败
-        simulatedTxID := fmt.Sprintf("SIM_FAIL_%d_%d", time.Now().UnixNano(), rechargeRecordID)
-        log.Printf("模拟支付失败 (记录 ID: %d), FailID: %s", rechargeRecordID, simulatedTxID)
-
-        // 更新交易记录状态为 Failed
-        err = h.Store.UpdateRechargeTransactionStatus(r.Context(), rechargeRecordID, "Failed", simulatedTxID)
-        if err != nil {
+    log.Printf("用户 %d 为组织 %d 发起充值 %d 分，创建记录 ID: %d", userID, orgID, amountInCents, rechargeRecordID)
+
+    // 5. 向支付渠道创建支付意图，记录保持 Pending，直到 POST /payments/webhook 回调确认
+    intent, err := h.Payments.CreateIntent(r.Context(), payment.IntentRequest{
+        RechargeID: rechargeRecordID,
+        UserID:     userID,
+        Amount:     amountInCents,
+        Currency:   money.Currency(),
+    })
+    if err != nil {
+        log.Printf("创建支付意图失败 (记录 %d): %v", rechargeRecordID, err)
+        if err := h.Store.FailPendingRecharge(r.Context(), rechargeRecordID, ""); err != nil {
             log.Printf("更新充值记录为失败状态失败 (记录 %d): %v", rechargeRecordID, err)
-            // 即使更新状态失败，也应告知用户支付失败
+        } else {
+            h.audit(r, models.AuditRechargeFailed, models.AuditTargetRecharge, rechargeRecordID,
+                map[string]string{"status": "Pending"},
+                map[string]interface{}{"status": "Failed", "organization_id": orgID, "amount": req.Amount, "reason": "provider_unavailable"})
         }
-
-        webutil.RespondWithError(w, http.StatusBadRequest, "充值失败（模拟支付未通过）") // 返回 400 表示客户端操作导致的问题（支付失败）
+        webutil.RespondWithError(w, http.StatusBadGateway, "充值失败（支付渠道暂不可用）")
+        return
+    }
+    if err := h.Store.SetRechargeProviderRef(r.Context(), rechargeRecordID, intent.ProviderRef); err != nil {
+        log.Printf("保存支付单号失败 (记录 %d, 支付单号 %s): %v", rechargeRecordID, intent.ProviderRef, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "处理充值请求失败（无法保存支付单号）")
+        return
     }
+
+    log.Printf("充值记录 %d 已创建支付意图 %s，等待支付回调", rechargeRecordID, intent.ProviderRef)
+    webutil.RespondWithJSON(w, http.StatusAccepted, webutil.Response{
+        Message: fmt.Sprintf("已创建 %s 元的充值订单，请完成支付", req.Amount),
+        Data: models.RechargeIntentResponse{
+            RechargeID:  rechargeRecordID,
+            Amount:      req.Amount,
+            Status:      "Pending",
+            ProviderRef: intent.ProviderRef,
+            PaymentURL:  intent.PaymentURL,
+        },
+    })
 }
 
-// --- GetBalanceHandler 获取用户当前余额 ---
 func (h *Handler) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
         webutil.RespondWithError(w, http.StatusMethodNotAllowed, "仅支持 GET 方法")
@@ -704,26 +1055,27 @@ func (h *Handler) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok {
+        return
+    }
+    orgID := member.OrganizationID
 
-    // 2. 调用 Store 获取余额 (单位：分)
-    balanceInCents, err := h.Store.GetUserBalance(r.Context(), userID)
+    // 2. 调用 Store 获取组织余额 (单位：分)
+    balanceInCents, err := h.Store.GetOrganizationBalance(r.Context(), orgID)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
-             webutil.RespondWithError(w, http.StatusNotFound, "找不到用户信息")
+             webutil.RespondWithError(w, http.StatusNotFound, "找不到组织信息")
         } else {
-            log.Printf("获取用户 %d 余额失败: %v", userID, err)
+            log.Printf("获取组织 %d 余额失败 (用户 %d): %v", orgID, userID, err)
             webutil.RespondWithError(w, http.StatusInternalServerError, "获取余额失败")
         }
         return
     }
 
-    // 3. 将余额从分转换为元 (float64)
-    balanceInYuan := float64(balanceInCents) / 100.0
-
-    // 4. 返回响应
+    // 3. 返回响应 (money.Money 统一序列化为 {"amount": "10.50", "currency": "CNY"})
     response := models.BalanceResponse{
-        Balance: balanceInYuan,
-        Currency: "CNY", // 或从配置读取
+        Balance: money.New(balanceInCents),
     }
     webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: response})
 }
@@ -742,6 +1094,11 @@ func (h *Handler) GetRechargeHistoryHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	userID := userClaims.UserID
+	member, ok := currentOrganization(w, r)
+	if !ok {
+		return
+	}
+	orgID := member.OrganizationID
 
 	// 2. 解析查询参数并构建过滤器
 	filters := models.RechargeHistoryFilters{}
@@ -777,23 +1134,21 @@ func (h *Handler) GetRechargeHistoryHandler(w http.ResponseWriter, r *http.Reque
     }
 
 
-	// 解析金额范围 (用户输入的是元，需转换为分)
+	// 解析金额范围 (用户输入的是元，精确转换为分)
 	if minAmountStr := query.Get("min_amount"); minAmountStr != "" {
-		minAmountYuan, err := strconv.ParseFloat(minAmountStr, 64)
-		if err != nil || minAmountYuan < 0 {
+		minAmountCents, err := money.ParseAmount(minAmountStr)
+		if err != nil || minAmountCents < 0 {
 			webutil.RespondWithError(w, http.StatusBadRequest, "无效的最小金额格式或值")
 			return
 		}
-		minAmountCents := int64(math.Round(minAmountYuan * 100))
 		filters.MinAmount = &minAmountCents
 	}
 	if maxAmountStr := query.Get("max_amount"); maxAmountStr != "" {
-		maxAmountYuan, err := strconv.ParseFloat(maxAmountStr, 64)
-		if err != nil || maxAmountYuan < 0 {
+		maxAmountCents, err := money.ParseAmount(maxAmountStr)
+		if err != nil || maxAmountCents < 0 {
 			webutil.RespondWithError(w, http.StatusBadRequest, "无效的最大金额格式或值")
 			return
 		}
-		maxAmountCents := int64(math.Round(maxAmountYuan * 100))
 		filters.MaxAmount = &maxAmountCents
 	}
     // 校验金额范围
@@ -815,9 +1170,9 @@ func (h *Handler) GetRechargeHistoryHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// 3. 调用 Store 获取带过滤的充值历史
-	history, err := h.Store.GetUserRechargeHistory(r.Context(), userID, filters) // 传递 filters
+	history, err := h.Store.GetRechargeHistoryByOrganization(r.Context(), orgID, filters) // 传递 filters
 	if err != nil {
-		log.Printf("获取用户 %d 充值历史失败 (带过滤): %v", userID, err)
+		log.Printf("获取组织 %d 充值历史失败 (带过滤, 用户 %d): %v", orgID, userID, err)
 		webutil.RespondWithError(w, http.StatusInternalServerError, "获取充值记录失败")
 		return
 	}
@@ -840,6 +1195,11 @@ func (h *Handler) GetUserCampaignsHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	userID := userClaims.UserID
+	member, ok := currentOrganization(w, r)
+	if !ok {
+		return
+	}
+	orgID := member.OrganizationID
 
 	// 2. 解析查询参数并构建过滤器 (类似 GetRechargeHistoryHandler)
 	filters := models.CampaignFilters{}
@@ -865,7 +1225,7 @@ func (h *Handler) GetUserCampaignsHandler(w http.ResponseWriter, r *http.Request
 	// 解析状态
 	if status := query.Get("status"); status != "" {
         // 可以添加状态验证
-        validStatuses := map[string]bool{"Pending": true, "Approved": true, "Rejected": true, "Cancelled": true, "Active": true, "Completed": true} // 可能的状态
+        validStatuses := map[string]bool{"Pending": true, "Approved": true, "Rejected": true, "Cancelled": true, "Active": true, "Paused": true, "Completed": true} // 可能的状态
         normalizedStatus := strings.Title(strings.ToLower(status))
         if !validStatuses[normalizedStatus] {
             webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("无效的状态值: %s", status)); return
@@ -874,9 +1234,9 @@ func (h *Handler) GetUserCampaignsHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// 3. 调用 Store 获取活动列表
-	campaigns, err := h.Store.GetAdCampaignsByUserID(r.Context(), userID, filters)
+	campaigns, err := h.Store.GetAdCampaignsByOrganization(r.Context(), orgID, filters)
 	if err != nil {
-		log.Printf("获取用户 %d 活动列表失败 (带过滤): %v", userID, err)
+		log.Printf("获取组织 %d 活动列表失败 (带过滤, 用户 %d): %v", orgID, userID, err)
 		webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告活动列表失败")
 		return
 	}
@@ -896,6 +1256,9 @@ func (h *Handler) GetUserCampaignDetailsHandler(w http.ResponseWriter, r *http.R
     userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
     if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
 
     // 2. 从 URL 获取活动 ID (Go 1.22+)
     campaignIDStr := r.PathValue("id")
@@ -905,13 +1268,13 @@ func (h *Handler) GetUserCampaignDetailsHandler(w http.ResponseWriter, r *http.R
         webutil.RespondWithError(w, http.StatusBadRequest, "无效的活动 ID"); return
     }
 
-    // 3. 调用 Store 获取详情 (已包含用户 ID 校验)
-    campaignDetails, err := h.Store.GetAdCampaignByIDAndUser(r.Context(), campaignID, userID)
+    // 3. 调用 Store 获取详情 (已包含组织 ID 校验)
+    campaignDetails, err := h.Store.GetAdCampaignByIDAndOrganization(r.Context(), campaignID, orgID)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
             webutil.RespondWithError(w, http.StatusNotFound, "找不到指定的广告活动或无权访问")
         } else {
-            log.Printf("获取用户 %d 的活动详情 %d 失败: %v", userID, campaignID, err)
+            log.Printf("获取组织 %d 的活动详情 %d 失败 (用户 %d): %v", orgID, campaignID, userID, err)
             webutil.RespondWithError(w, http.StatusInternalServerError, "获取活动详情失败")
         }
         return
@@ -933,6 +1296,9 @@ func (h *Handler) CancelCampaignHandler(w http.ResponseWriter, r *http.Request)
     userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
     if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
 
     // 2. 从 URL 获取活动 ID (Go 1.22+)
     campaignIDStr := r.PathValue("id")
@@ -945,12 +1311,15 @@ func (h *Handler) CancelCampaignHandler(w http.ResponseWriter, r *http.Request)
     // (可选) 检查请求体，如果需要传递额外参数（例如取消原因）
 
     // 3. 调用 Store 更新状态为 'Cancelled'
-    newStatus := "Cancelled"
-    err = h.Store.UpdateAdCampaignStatusByUser(r.Context(), campaignID, userID, newStatus)
+    newStatus := models.CampaignStatusCancelled
+    before := h.campaignStatusForAudit(r, campaignID, orgID)
+    err = h.Store.UpdateAdCampaignStatusByOrganization(r.Context(), campaignID, orgID, userID, newStatus)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
-            // Store 返回 ErrNotFound 表示活动不存在、不属于该用户或状态不允许取消
-            webutil.RespondWithError(w, http.StatusNotFound, "找不到要取消的活动，或该活动无法被取消")
+            // Store 返回 ErrNotFound 表示活动不存在或不属于该组织
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到要取消的活动")
+        } else if errors.Is(err, store.ErrInvalidTransition) {
+            webutil.RespondWithError(w, http.StatusConflict, "该活动当前状态无法被取消")
         } else {
             // 其他 Store 层错误 (如数据库连接问题)
             log.Printf("用户 %d 取消活动 %d 失败: %v", userID, campaignID, err)
@@ -961,11 +1330,175 @@ func (h *Handler) CancelCampaignHandler(w http.ResponseWriter, r *http.Request)
 
     // 4. 返回成功响应
     log.Printf("用户 %d 成功取消了活动 %d", userID, campaignID)
+    h.audit(r, models.AuditCampaignStatus, models.AuditTargetCampaign, campaignID, before, map[string]string{"status": newStatus})
+    h.Serving.Invalidate()
     webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
         Message: fmt.Sprintf("广告活动 %d 已成功取消", campaignID),
     })
 }
 
+// campaignStatusForAudit 返回活动当前的状态，作为审计日志中变更前的值；查询失败时返回 nil (由后续的状态更新报告错误)
+func (h *Handler) campaignStatusForAudit(r *http.Request, campaignID, orgID int) interface{} {
+    campaign, err := h.Store.GetAdCampaignByIDAndOrganization(r.Context(), campaignID, orgID)
+    if err != nil {
+        return nil
+    }
+    return map[string]string{"status": campaign.Status}
+}
+
+// PauseCampaignHandler 用户暂停自己的广告活动 (Approved / Active -> Paused)
+func (h *Handler) PauseCampaignHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
+
+    campaignID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || campaignID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的活动 ID"); return
+    }
+
+    before := h.campaignStatusForAudit(r, campaignID, orgID)
+    err = h.Store.UpdateAdCampaignStatusByOrganization(r.Context(), campaignID, orgID, userID, models.CampaignStatusPaused)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到要暂停的活动")
+        } else if errors.Is(err, store.ErrInvalidTransition) {
+            webutil.RespondWithError(w, http.StatusConflict, "该活动当前状态无法被暂停")
+        } else {
+            log.Printf("用户 %d 暂停活动 %d 失败: %v", userID, campaignID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "暂停活动时出错")
+        }
+        return
+    }
+
+    log.Printf("用户 %d 暂停了活动 %d", userID, campaignID)
+    h.audit(r, models.AuditCampaignStatus, models.AuditTargetCampaign, campaignID, before, map[string]string{"status": models.CampaignStatusPaused})
+    h.Serving.Invalidate()
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: fmt.Sprintf("广告活动 %d 已暂停", campaignID),
+    })
+}
+
+// ResumeCampaignHandler 用户恢复已暂停的广告活动
+// 已到开始日期的恢复为 Active，尚未开始的恢复为 Approved (由调度器在开始日期激活)
+func (h *Handler) ResumeCampaignHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
+
+    campaignID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || campaignID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的活动 ID"); return
+    }
+
+    campaign, err := h.Store.GetAdCampaignByIDAndOrganization(r.Context(), campaignID, orgID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到要恢复的活动")
+        } else {
+            log.Printf("获取组织 %d 的活动 %d 失败 (用户 %d): %v", orgID, campaignID, userID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "恢复活动时出错")
+        }
+        return
+    }
+
+    today := time.Now().Format(DateFormat)
+    if campaign.EndDate.Format(DateFormat) < today {
+        webutil.RespondWithError(w, http.StatusConflict, "该活动已过结束日期，无法恢复")
+        return
+    }
+    newStatus := models.CampaignStatusActive
+    if campaign.StartDate.Format(DateFormat) > today {
+        newStatus = models.CampaignStatusApproved
+    }
+
+    err = h.Store.UpdateAdCampaignStatusByOrganization(r.Context(), campaignID, orgID, userID, newStatus)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到要恢复的活动")
+        } else if errors.Is(err, store.ErrInvalidTransition) {
+            webutil.RespondWithError(w, http.StatusConflict, "只有已暂停的活动可以恢复")
+        } else {
+            log.Printf("用户 %d 恢复活动 %d 失败: %v", userID, campaignID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "恢复活动时出错")
+        }
+        return
+    }
+
+    log.Printf("用户 %d 恢复了活动 %d (状态: %s)", userID, campaignID, newStatus)
+    h.audit(r, models.AuditCampaignStatus, models.AuditTargetCampaign, campaignID,
+        map[string]string{"status": campaign.Status}, map[string]string{"status": newStatus})
+    h.Serving.Invalidate()
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: fmt.Sprintf("广告活动 %d 已恢复，当前状态为 %s", campaignID, newStatus),
+        Data:    map[string]string{"status": newStatus},
+    })
+}
+
+// GetUserCampaignHistoryHandler 获取用户自己广告活动的状态变更历史
+func (h *Handler) GetUserCampaignHistoryHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
+
+    campaignID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || campaignID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的活动 ID"); return
+    }
+
+    // 先确认活动属于当前组织
+    if _, err := h.Store.GetAdCampaignByIDAndOrganization(r.Context(), campaignID, orgID); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该广告活动")
+        } else {
+            log.Printf("获取组织 %d 的活动 %d 失败 (用户 %d): %v", orgID, campaignID, userID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "获取活动状态历史失败")
+        }
+        return
+    }
+    h.respondCampaignHistory(w, r, campaignID)
+}
+
+// AdminGetCampaignHistoryHandler 管理员获取任意广告活动的状态变更历史
+func (h *Handler) AdminGetCampaignHistoryHandler(w http.ResponseWriter, r *http.Request) {
+    campaignID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || campaignID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的活动 ID"); return
+    }
+    if _, err := h.Store.GetAdCampaignByID(r.Context(), campaignID); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该广告活动")
+        } else {
+            log.Printf("获取活动 %d 失败: %v", campaignID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "获取活动状态历史失败")
+        }
+        return
+    }
+    h.respondCampaignHistory(w, r, campaignID)
+}
+
+func (h *Handler) respondCampaignHistory(w http.ResponseWriter, r *http.Request, campaignID int) {
+    history, err := h.Store.GetCampaignStatusHistory(r.Context(), campaignID)
+    if err != nil {
+        log.Printf("获取活动 %d 状态历史失败: %v", campaignID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取活动状态历史失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "获取活动状态历史成功",
+        Data:    history,
+    })
+}
+
 // GetAdPerformanceHandler 获取广告效果汇总数据
 func (h *Handler) GetAdPerformanceHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
@@ -976,6 +1509,9 @@ func (h *Handler) GetAdPerformanceHandler(w http.ResponseWriter, r *http.Request
     userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
     if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
 
     // 2. 解析查询参数 (过滤条件)
     filters := models.AdPerformanceFilter{}
@@ -1022,9 +1558,9 @@ func (h *Handler) GetAdPerformanceHandler(w http.ResponseWriter, r *http.Request
     }
 
     // 3. 调用 Store 获取汇总数据
-    summaryData, err := h.Store.GetAdPerformanceSummary(r.Context(), userID, filters)
+    summaryData, err := h.Store.GetAdPerformanceSummary(r.Context(), orgID, filters)
     if err != nil {
-        log.Printf("获取用户 %d 广告效果失败: %v", userID, err)
+        log.Printf("获取组织 %d 广告效果失败 (用户 %d): %v", orgID, userID, err)
         webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告效果数据失败")
         return
     }
@@ -1043,7 +1579,7 @@ func (h *Handler) GetAdPerformanceHandler(w http.ResponseWriter, r *http.Request
     webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: summaryData})
 }
 
-// AdClickHandler 模拟处理广告点击并记录事件
+// AdClickHandler 处理广告点击：校验 GET /get-ad 签发的点击凭证，记录 Click 事件并扣费 (CPC)，然后重定向到目标地址
 func (h *Handler) AdClickHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet { // 通常点击是通过 GET 请求
         webutil.RespondWithError(w, http.StatusMethodNotAllowed, "仅支持 GET 方法"); return
@@ -1060,8 +1596,29 @@ func (h *Handler) AdClickHandler(w http.ResponseWriter, r *http.Request) {
     if errCamp != nil || errAd != nil || campaignID <= 0 || adID <= 0 {
         webutil.RespondWithError(w, http.StatusBadRequest, "无效的活动或广告 ID"); return
     }
+    // 可选的广告位 (?placement=ID，与 GET /get-ad 返回的 placement_id 一致)，记录到点击事件
+    var placementID *int
+    clickPlacement := 0
+    if idStr := r.URL.Query().Get("placement"); idStr != "" {
+        id, err := strconv.Atoi(idStr)
+        if err != nil || id <= 0 {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的广告位 ID"); return
+        }
+        placementID, clickPlacement = &id, id
+    }
+
+    // 2. 校验点击凭证 (?token=)：签名无效，或与链接中的活动、创意、广告位不一致时拒绝；
+    //    签名有效但已过期时仍然跳转，只是不计费
+    now := time.Now()
+    claims, tokenErr := h.Clicks.Verify(r.URL.Query().Get("token"), now)
+    if tokenErr != nil && !errors.Is(tokenErr, serving.ErrClickTokenExpired) {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的点击链接"); return
+    }
+    if claims.CampaignID != campaignID || claims.AdvertisementID != adID || claims.PlacementID != clickPlacement {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的点击链接"); return
+    }
 
-    // 2. (重要) 查询活动和广告信息，特别是 user_id 和 target_url
+    // 3. (重要) 查询活动和广告信息，特别是 user_id 和 target_url
     //    需要一个方法 GetCampaignAndAdInfo (或分开查)
     campaign, errCampGet := h.Store.GetAdCampaignByID(r.Context(), campaignID) // 需要 GetAdCampaignByID
     if errCampGet != nil { webutil.RespondWithError(w, http.StatusNotFound, "找不到活动"); return }
@@ -1079,23 +1636,40 @@ func (h *Handler) AdClickHandler(w http.ResponseWriter, r *http.Request) {
     }
 
 
-    // 3. 记录 Click 事件
-    clickEvent := models.AdEvent{
-        EventType:       "Click",
-        AdvertisementID: adID,
-        CampaignID:      campaignID,
-        UserID:          campaign.UserID, // 使用活动创建者的 ID
-        EventTimestamp:  time.Now(),
-    }
-    logErr := h.Store.LogAdEvent(r.Context(), clickEvent)
-    if logErr != nil {
-        // 记录失败也应尝试重定向，但需记录日志
-        log.Printf("!!! 记录 Click 事件失败 (但将尝试重定向): campaign %d, ad %d: %v", campaignID, adID, logErr)
-    } else {
-        log.Printf("记录 Click: campaign %d, ad %d", campaignID, adID)
+    // 4. 记录 Click 事件并扣费 (CPC)。凭证已过期、访问者与签发时不一致或凭证已经用过时不计费
+    switch {
+    case tokenErr != nil:
+        log.Printf("点击凭证已过期，不计费: campaign %d, ad %d", campaignID, adID)
+    case !claims.MatchesViewer(h.Frequency.Lookup(r)):
+        log.Printf("点击的访问者与点击凭证不一致，不计费: campaign %d, ad %d", campaignID, adID)
+    default:
+        nonce := claims.Nonce
+        clickEvent := models.AdEvent{
+            EventType:       "Click",
+            AdvertisementID: adID,
+            CampaignID:      campaignID,
+            UserID:          campaign.UserID, // 使用活动创建者的 ID
+            EventTimestamp:  now,
+            PlacementID:     placementID,
+            ClickToken:      &nonce,
+        }
+        if claims.ClickPrice > 0 {
+            // CPC 活动按展示时竞价得到的每次点击成交价计费 (不超过出价)
+            clickPrice := money.New(claims.ClickPrice)
+            clickEvent.ClearingPrice = &clickPrice
+        }
+        logErr := h.Store.ChargeAdEvent(r.Context(), &clickEvent)
+        if errors.Is(logErr, store.ErrDuplicateClick) {
+            log.Printf("点击凭证已经计费过，不再计费: campaign %d, ad %d", campaignID, adID)
+        } else if logErr != nil {
+            // 记录失败 (包括活动已不能投放、预算或余额已用完，此时点击不计费也不记录) 也应尝试重定向，但需记录日志
+            log.Printf("!!! 记录 Click 事件失败 (但将尝试重定向): campaign %d, ad %d: %v", campaignID, adID, logErr)
+        } else {
+            log.Printf("记录 Click: campaign %d, ad %d, 扣费 %d 分", campaignID, adID, clickEvent.Cost)
+        }
     }
 
-    // 4. 重定向到广告的目标 URL
+    // 5. 重定向到广告的目标 URL
     targetURL := ad.TargetURL // 从查询到的广告信息中获取
     if targetURL == "" {
         // 如果没有目标 URL，返回一个错误或默认页面
@@ -1119,6 +1693,9 @@ func (h *Handler) RequestInvoiceHandler(w http.ResponseWriter, r *http.Request)
 	userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
 	if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
 	userID := userClaims.UserID
+	member, ok := currentOrganization(w, r)
+	if !ok { return }
+	orgID := member.OrganizationID
 
 	// 2. 解码请求体
 	var payload models.InvoiceRequestPayload
@@ -1139,9 +1716,9 @@ func (h *Handler) RequestInvoiceHandler(w http.ResponseWriter, r *http.Request)
 
 	// 4. 计算开票金额 (查询指定日期范围内成功的充值总额)
     // 注意：这里我们信任 Store 层会正确处理日期范围的边界
-	totalAmountCents, err := h.Store.GetSuccessfulRechargeTotalInRange(r.Context(), userID, startDate, endDate)
+	totalAmountCents, err := h.Store.GetSuccessfulRechargeTotalInRange(r.Context(), orgID, startDate, endDate)
 	if err != nil {
-		log.Printf("计算用户 %d 开票金额失败 (%s to %s): %v", userID, payload.StartDate, payload.EndDate, err)
+		log.Printf("计算组织 %d 开票金额失败 (%s to %s): %v", orgID, payload.StartDate, payload.EndDate, err)
 		webutil.RespondWithError(w, http.StatusInternalServerError, "计算开票金额时出错")
 		return
 	}
@@ -1156,11 +1733,12 @@ func (h *Handler) RequestInvoiceHandler(w http.ResponseWriter, r *http.Request)
 
 	// 6. 创建发票请求记录
 	invoiceReq := models.InvoiceRequest{
+		OrganizationID:     orgID,
 		UserID:             userID,
 		Status:             "Pending", // 初始状态
 		InvoicePeriodStart: startDate,
 		InvoicePeriodEnd:   endDate,
-		TotalAmount:        totalAmountCents,
+		TotalAmount:        money.New(totalAmountCents),
 		BillingTitle:       payload.BillingTitle,
 		BillingAddress:     payload.BillingAddress,
 		RequestedAt:        time.Now(),
@@ -1173,13 +1751,13 @@ func (h *Handler) RequestInvoiceHandler(w http.ResponseWriter, r *http.Request)
 
 	newInvoiceID, err := h.Store.CreateInvoiceRequest(r.Context(), invoiceReq)
 	if err != nil {
-		log.Printf("创建用户 %d 的发票请求失败: %v", userID, err)
+		log.Printf("创建组织 %d 的发票请求失败 (用户 %d): %v", orgID, userID, err)
 		webutil.RespondWithError(w, http.StatusInternalServerError, "提交发票请求失败")
 		return
 	}
 
 	// 7. 返回成功响应
-    log.Printf("User %d submitted invoice request ID %d for period %s to %s", userID, newInvoiceID, payload.StartDate, payload.EndDate)
+    log.Printf("User %d submitted invoice request ID %d for organization %d, period %s to %s", userID, newInvoiceID, orgID, payload.StartDate, payload.EndDate)
 	webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{
 		Message: "发票请求已提交成功",
 		Data:    map[string]int64{"invoice_request_id": newInvoiceID},
@@ -1198,6 +1776,9 @@ func (h *Handler) GetUserInvoicesHandler(w http.ResponseWriter, r *http.Request)
 	userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
 	if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
 	userID := userClaims.UserID
+	member, ok := currentOrganization(w, r)
+	if !ok { return }
+	orgID := member.OrganizationID
 
 	// 2. 解析查询参数 (可选过滤)
     filters := models.InvoiceRequestFilter{}
@@ -1217,9 +1798,9 @@ func (h *Handler) GetUserInvoicesHandler(w http.ResponseWriter, r *http.Request)
 
 
 	// 3. 调用 Store 获取历史记录
-	invoices, err := h.Store.GetInvoiceRequestsByUserID(r.Context(), userID, filters)
+	invoices, err := h.Store.GetInvoiceRequestsByOrganization(r.Context(), orgID, filters)
 	if err != nil {
-		log.Printf("获取用户 %d 发票历史失败: %v", userID, err)
+		log.Printf("获取组织 %d 发票历史失败 (用户 %d): %v", orgID, userID, err)
 		webutil.RespondWithError(w, http.StatusInternalServerError, "获取发票历史记录失败")
 		return
 	}
@@ -1238,6 +1819,9 @@ func (h *Handler) GetUserInvoiceDetailsHandler(w http.ResponseWriter, r *http.Re
     userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
     if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
     userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
 
     // 2. 从 URL 获取发票请求 ID (Go 1.22+)
     invoiceIDStr := r.PathValue("id")
@@ -1247,12 +1831,12 @@ func (h *Handler) GetUserInvoiceDetailsHandler(w http.ResponseWriter, r *http.Re
     }
 
     // 3. 调用 Store 获取详情
-    invoiceDetails, err := h.Store.GetInvoiceRequestByIDAndUser(r.Context(), invoiceID, userID)
+    invoiceDetails, err := h.Store.GetInvoiceRequestByIDAndOrganization(r.Context(), invoiceID, orgID)
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
             webutil.RespondWithError(w, http.StatusNotFound, "找不到指定的发票请求或无权访问")
         } else {
-            log.Printf("获取用户 %d 的发票详情 %d 失败: %v", userID, invoiceID, err)
+            log.Printf("获取组织 %d 的发票详情 %d 失败 (用户 %d): %v", orgID, invoiceID, userID, err)
             webutil.RespondWithError(w, http.StatusInternalServerError, "获取发票详情失败")
         }
         return
@@ -1261,3 +1845,2291 @@ func (h *Handler) GetUserInvoiceDetailsHandler(w http.ResponseWriter, r *http.Re
     // 4. 返回响应
     webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: invoiceDetails})
 }
+
+// --- GetLedgerHandler 广告主分页查看当前组织的账本分录 ---
+func (h *Handler) GetLedgerHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
+
+    // 1. 解析分页和类型参数
+    query := r.URL.Query()
+    page, pageSize := 1, 20
+    filter := models.LedgerFilter{}
+    if pageStr := query.Get("page"); pageStr != "" {
+        p, err := strconv.Atoi(pageStr)
+        if err != nil || p <= 0 { webutil.RespondWithError(w, http.StatusBadRequest, "无效的页码"); return }
+        page = p
+    }
+    if sizeStr := query.Get("page_size"); sizeStr != "" {
+        size, err := strconv.Atoi(sizeStr)
+        if err != nil || size <= 0 || size > 100 { webutil.RespondWithError(w, http.StatusBadRequest, "page_size 应在 1 到 100 之间"); return }
+        pageSize = size
+    }
+    if kind := query.Get("kind"); kind != "" {
+        validKinds := map[string]bool{
+            ledger.KindRecharge: true, ledger.KindAdSpend: true, ledger.KindRefund: true,
+            ledger.KindPromoCredit: true, ledger.KindAdjustment: true, ledger.KindOpeningBalance: true,
+        }
+        if !validKinds[kind] { webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("无效的分录类型: %s", kind)); return }
+        filter.Kind = &kind
+    }
+
+    // 2. 多取一条判断是否还有下一页
+    filter.Offset = (page - 1) * pageSize
+    filter.Limit = pageSize + 1
+    entries, err := h.Store.GetLedgerEntriesByOrganization(r.Context(), orgID, filter)
+    if err != nil {
+        log.Printf("获取组织 %d 账本分录失败 (用户 %d): %v", orgID, userID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取账本记录失败")
+        return
+    }
+    hasMore := len(entries) > pageSize
+    if hasMore {
+        entries = entries[:pageSize]
+    }
+
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: models.LedgerPage{
+        Entries:  entries,
+        Page:     page,
+        PageSize: pageSize,
+        HasMore:  hasMore,
+    }})
+}
+
+// --- AdminPostLedgerEntryHandler 管理员手工记账 (调账 / 赠送额度 / 退款) ---
+func (h *Handler) AdminPostLedgerEntryHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    var req models.LedgerAdjustmentRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        if !respondMoneyError(w, "金额", err) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        }
+        return
+    }
+    defer r.Body.Close()
+
+    if req.OrganizationID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的组织 ID"); return
+    }
+    if strings.TrimSpace(req.Memo) == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "必须填写备注 (memo)"); return
+    }
+
+    // 只有 adjustment 允许负数 (由 ledger.ManualEntry 校验)，这里校验金额的绝对值范围
+    if err := req.Amount.CheckRange(); err != nil {
+        respondMoneyError(w, "金额", err)
+        return
+    }
+    amountInCents := req.Amount.Minor
+    entry, err := ledger.ManualEntry(req.Kind, req.OrganizationID, amountInCents, strings.TrimSpace(req.Memo), adminClaims.UserID)
+    if err != nil {
+        switch {
+        case errors.Is(err, ledger.ErrUnknownKind):
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的类型，只能是 adjustment、promo_credit 或 refund")
+        case errors.Is(err, ledger.ErrInvalidAmount):
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的金额")
+        default:
+            webutil.RespondWithError(w, http.StatusBadRequest, err.Error())
+        }
+        return
+    }
+
+    before := h.balanceForAudit(r, req.OrganizationID)
+    entryID, err := h.Store.PostLedgerEntry(r.Context(), entry)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该组织")
+        } else if errors.Is(err, store.ErrInsufficientBalance) {
+            webutil.RespondWithError(w, http.StatusConflict, "组织余额不足")
+        } else {
+            log.Printf("管理员 %d 为组织 %d 记账失败: %v", adminClaims.UserID, req.OrganizationID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "记账失败")
+        }
+        return
+    }
+
+    log.Printf("管理员 %d 为组织 %d 记账 %s %d 分 (分录 %d)", adminClaims.UserID, req.OrganizationID, req.Kind, amountInCents, entryID)
+    h.audit(r, models.AuditLedgerEntry, models.AuditTargetOrganization, req.OrganizationID, before,
+        h.ledgerChangeForAudit(r, req.OrganizationID, entryID, req.Kind, req.Amount, strings.TrimSpace(req.Memo)))
+    h.Serving.Invalidate()
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{
+        Message: "记账成功",
+        Data:    map[string]int64{"entry_id": entryID},
+    })
+}
+
+// balanceForAudit 返回组织当前的余额，作为审计日志中变更前的值；查询失败时返回 nil
+func (h *Handler) balanceForAudit(r *http.Request, orgID int) interface{} {
+    balance, err := h.Store.GetOrganizationBalance(r.Context(), orgID)
+    if err != nil {
+        return nil
+    }
+    return map[string]interface{}{"balance": money.New(balance)}
+}
+
+// ledgerChangeForAudit 审计日志中记账后的值：分录和记账后的余额
+func (h *Handler) ledgerChangeForAudit(r *http.Request, orgID int, entryID int64, kind string, amount money.Money, memo string) map[string]interface{} {
+    after := map[string]interface{}{"entry_id": entryID, "kind": kind, "amount": amount, "memo": memo}
+    if balance, err := h.Store.GetOrganizationBalance(r.Context(), orgID); err == nil {
+        after["balance"] = money.New(balance)
+    }
+    return after
+}
+
+// --- AdminReconcileBalancesHandler 核对 organizations.balance 与账本余额 ---
+func (h *Handler) AdminReconcileBalancesHandler(w http.ResponseWriter, r *http.Request) {
+    discrepancies, err := h.Store.ReconcileBalances(r.Context())
+    if err != nil {
+        log.Printf("核对余额失败: %v", err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "核对余额失败")
+        return
+    }
+    message := "所有组织余额与账本一致"
+    if len(discrepancies) > 0 {
+        message = fmt.Sprintf("%d 个组织余额与账本不一致", len(discrepancies))
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: message, Data: discrepancies})
+}
+
+// --- GetRechargeDetailsHandler 用户查看单笔充值记录，并主动查询支付渠道的状态 ---
+func (h *Handler) GetRechargeDetailsHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    userID := userClaims.UserID
+    member, ok := currentOrganization(w, r)
+    if !ok { return }
+    orgID := member.OrganizationID
+
+    rechargeID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil || rechargeID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的充值记录 ID"); return
+    }
+
+    rec, err := h.Store.GetRechargeTransactionByID(r.Context(), rechargeID)
+    if err != nil || rec.OrganizationID != orgID {
+        if err == nil || errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该充值记录")
+        } else {
+            log.Printf("用户 %d 获取充值记录 %d 失败: %v", userID, rechargeID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "获取充值记录失败")
+        }
+        return
+    }
+
+    // 只查询状态，不在这里入账：入账只通过签名回调完成
+    details := models.RechargeDetails{RechargeTransaction: *rec}
+    if rec.Status == "Pending" && rec.ProviderRef != nil && rec.PaymentMethod == h.Payments.Name() {
+        if status, err := h.Payments.QueryStatus(r.Context(), *rec.ProviderRef); err != nil {
+            log.Printf("查询支付单 %s 状态失败: %v", *rec.ProviderRef, err)
+        } else {
+            providerStatus := string(status)
+            details.ProviderStatus = &providerStatus
+        }
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: details})
+}
+
+// --- PaymentWebhookHandler 支付渠道回调：校验签名后为充值记录入账或标记失败 ---
+// 对已经处理过的记录直接返回 200，支付渠道重复投递同一事件时不会重复入账。
+func (h *Handler) PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+    payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+    if err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无法读取回调内容"); return
+    }
+    defer r.Body.Close()
+
+    // 1. 校验签名并解析事件
+    event, err := h.Payments.VerifyWebhook(payload, r.Header)
+    if err != nil {
+        log.Printf("支付回调校验失败: %v", err)
+        if errors.Is(err, payment.ErrInvalidSignature) || errors.Is(err, payment.ErrSignatureExpired) {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "回调签名无效")
+        } else {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的回调事件")
+        }
+        return
+    }
+
+    // 2. 核对充值记录：渠道、支付单号和金额都必须一致
+    rec, err := h.Store.GetRechargeTransactionByID(r.Context(), event.RechargeID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到对应的充值记录")
+        } else {
+            log.Printf("支付回调读取充值记录 %d 失败: %v", event.RechargeID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "处理回调失败")
+        }
+        return
+    }
+    // 支付单号在创建支付意图后保存；还没有支付单号的记录不可能已经被支付，不接受回调
+    if rec.PaymentMethod != h.Payments.Name() || rec.ProviderRef == nil || *rec.ProviderRef != event.ProviderRef {
+        log.Printf("支付回调 %s 与充值记录 %d 的支付单不匹配", event.ID, rec.ID)
+        webutil.RespondWithError(w, http.StatusBadRequest, "支付单号与充值记录不匹配"); return
+    }
+    if event.Amount != rec.Amount.Minor {
+        log.Printf("支付回调 %s 金额 %d 分与充值记录 %d 的金额 %d 分不一致", event.ID, event.Amount, rec.ID, rec.Amount.Minor)
+        webutil.RespondWithError(w, http.StatusBadRequest, "支付金额与充值记录不一致"); return
+    }
+    if rec.Status != "Pending" {
+        log.Printf("支付回调 %s: 充值记录 %d 已是 %s，忽略", event.ID, rec.ID, rec.Status)
+        webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "充值记录已处理"}); return
+    }
+
+    // 3. 入账或标记失败
+    switch event.Status {
+    case payment.StatusSucceeded:
+        err = h.Store.ProcessSuccessfulRecharge(r.Context(), rec, event.TransactionID)
+    case payment.StatusFailed:
+        err = h.Store.FailPendingRecharge(r.Context(), rec.ID, event.TransactionID)
+    }
+    if err != nil && !errors.Is(err, store.ErrRechargeNotPending) {
+        // 返回 5xx 让支付渠道稍后重试
+        log.Printf("支付回调 %s 处理充值记录 %d 失败: %v", event.ID, rec.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "处理回调失败")
+        return
+    }
+
+    log.Printf("支付回调 %s: 充值记录 %d (组织 %d, %d 分) 支付结果 %s", event.ID, rec.ID, rec.OrganizationID, rec.Amount.Minor, event.Status)
+    if err == nil {
+        // ErrRechargeNotPending 表示并发的回调已经处理过，由那次回调记录
+        action, status := models.AuditRechargeFailed, "Failed"
+        if event.Status == payment.StatusSucceeded {
+            action, status = models.AuditRechargeSucceeded, "Success"
+        }
+        h.audit(r, action, models.AuditTargetRecharge, rec.ID,
+            map[string]string{"status": rec.Status},
+            map[string]interface{}{"status": status, "organization_id": rec.OrganizationID, "amount": rec.Amount,
+                "transaction_id": event.TransactionID, "event_id": event.ID})
+        if event.Status == payment.StatusSucceeded {
+            // 余额从 0 变为正数时，该组织的活动重新可以投放
+            h.Serving.Invalidate()
+        }
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "回调处理成功"})
+}
+
+// --- RefreshTokenHandler 用刷新令牌换取新的访问令牌和刷新令牌 (旧刷新令牌随即失效) ---
+func (h *Handler) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+    var req models.RefreshTokenRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.RefreshToken) == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，需要 refresh_token")
+        return
+    }
+    defer r.Body.Close()
+
+    // 先查出令牌所属用户 (签发新访问令牌需要最新的用户名和角色)，轮换时 Store 会在事务中再次校验
+    tokenHash := auth.HashRefreshToken(req.RefreshToken)
+    existing, err := h.Store.GetRefreshTokenByHash(r.Context(), tokenHash)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "无效的刷新令牌")
+        } else {
+            log.Printf("查询刷新令牌失败: %v", err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "刷新令牌失败")
+        }
+        return
+    }
+    user, err := h.Store.GetUserByID(r.Context(), existing.UserID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "无效的刷新令牌")
+        } else {
+            log.Printf("刷新令牌时获取用户 %d 失败: %v", existing.UserID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "刷新令牌失败")
+        }
+        return
+    }
+    if user.SuspendedAt != nil {
+        // 停用时已吊销所有会话，这里兜底
+        webutil.RespondWithError(w, http.StatusForbidden, accountSuspendedMessage)
+        return
+    }
+
+    tokens, err := h.issueTokens(r, user, tokenHash)
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrRefreshTokenReused):
+            log.Printf("用户 %d 的刷新令牌被重复使用，会话已吊销", user.ID)
+            webutil.RespondWithError(w, http.StatusUnauthorized, "刷新令牌已失效，请重新登录")
+        case errors.Is(err, store.ErrRefreshTokenExpired):
+            webutil.RespondWithError(w, http.StatusUnauthorized, "刷新令牌已过期，请重新登录")
+        case errors.Is(err, store.ErrNotFound):
+            webutil.RespondWithError(w, http.StatusUnauthorized, "无效的刷新令牌")
+        default:
+            log.Printf("为用户 %d 轮换刷新令牌失败: %v", user.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "刷新令牌失败")
+        }
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "令牌已刷新", Data: tokens})
+}
+
+// --- LogoutHandler 登出当前会话：吊销当前访问令牌以及同一会话的刷新令牌 ---
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil {
+        webutil.RespondWithError(w, http.StatusUnauthorized, "无效的认证凭证或无法获取用户信息")
+        return
+    }
+    if userClaims.APIKeyID != 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "API Key 没有登录会话，请使用 DELETE /api-keys/{id} 吊销")
+        return
+    }
+
+    var expiresAt time.Time
+    if userClaims.ExpiresAt != nil {
+        expiresAt = userClaims.ExpiresAt.Time
+    }
+    if err := h.Store.RevokeSessionByAccessJTI(r.Context(), userClaims.UserID, userClaims.ID, expiresAt, time.Now()); err != nil {
+        log.Printf("用户 %d 登出失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "登出失败")
+        return
+    }
+    log.Printf("用户 %s (ID: %d) 已登出", userClaims.Username, userClaims.UserID)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "已登出"})
+}
+
+// --- LogoutAllHandler 登出所有会话：吊销该用户所有的刷新令牌和仍然有效的访问令牌 ---
+func (h *Handler) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil {
+        webutil.RespondWithError(w, http.StatusUnauthorized, "无效的认证凭证或无法获取用户信息")
+        return
+    }
+    if userClaims.APIKeyID != 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "API Key 没有登录会话，请使用 DELETE /api-keys/{id} 吊销")
+        return
+    }
+
+    now := time.Now()
+    var expiresAt time.Time
+    if userClaims.ExpiresAt != nil {
+        expiresAt = userClaims.ExpiresAt.Time
+    }
+    sessions, err := h.Store.RevokeAllSessions(r.Context(), userClaims.UserID, now)
+    if err != nil {
+        log.Printf("用户 %d 登出所有会话失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "登出失败")
+        return
+    }
+    // 当前访问令牌也要吊销 (它的会话可能已被清理，不在刷新令牌表中)
+    if err := h.Store.RevokeSessionByAccessJTI(r.Context(), userClaims.UserID, userClaims.ID, expiresAt, now); err != nil {
+        log.Printf("用户 %d 登出所有会话失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "登出失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "已登出所有会话",
+        Data:    map[string]int{"revoked_sessions": sessions},
+    })
+}
+
+// --- JWKSHandler 公开访问令牌的校验公钥 (RFC 7517)，其他服务可以离线校验广告主的访问令牌 ---
+func (h *Handler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+    // 按标准格式直接返回 {"keys": [...]}，不包在 webutil.Response 里；允许校验方缓存几分钟
+    w.Header().Set("Cache-Control", "public, max-age=300")
+    webutil.RespondWithJSON(w, http.StatusOK, auth.JWKS())
+}
+
+// --- CreateAPIKeyHandler 广告主创建 API Key (明文只在响应中返回一次) ---
+func (h *Handler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    // 不允许用 API Key 创建新的 API Key，避免泄露的 Key 自我延续
+    if userClaims.APIKeyID != 0 {
+        webutil.RespondWithError(w, http.StatusForbidden, "请登录后管理 API Key，不能使用 API Key 认证")
+        return
+    }
+
+    var req models.CreateAPIKeyRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，需要 name，可选 scopes 和 expires_at (RFC 3339)")
+        return
+    }
+    defer r.Body.Close()
+
+    req.Name = strings.TrimSpace(req.Name)
+    if req.Name == "" || len([]rune(req.Name)) > 100 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "API Key 名称不能为空，且不能超过 100 个字符")
+        return
+    }
+    scopes := []string{}
+    for _, scope := range req.Scopes {
+        if !auth.ValidScope(scope) {
+            webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("无效的 scope: %q (可选: %s, %s)", scope, auth.ScopeRead, auth.ScopeWrite))
+            return
+        }
+        if !slices.Contains(scopes, scope) {
+            scopes = append(scopes, scope)
+        }
+    }
+    now := time.Now()
+    if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+        webutil.RespondWithError(w, http.StatusBadRequest, "expires_at 必须晚于当前时间")
+        return
+    }
+
+    apiKey := models.APIKey{
+        UserID:    userClaims.UserID,
+        Name:      req.Name,
+        Scopes:    scopes,
+        ExpiresAt: req.ExpiresAt,
+        CreatedAt: now,
+    }
+    var plain string
+    var err error
+    // 前缀冲突的概率极低，重试几次即可
+    for attempt := 0; attempt < 3; attempt++ {
+        plain, apiKey.Prefix, apiKey.SecretHash, err = auth.GenerateAPIKey()
+        if err != nil {
+            break
+        }
+        if err = h.Store.CreateAPIKey(r.Context(), &apiKey); !errors.Is(err, store.ErrDuplicateAPIKeyPrefix) {
+            break
+        }
+    }
+    if err != nil {
+        log.Printf("用户 %d 创建 API Key 失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "创建 API Key 失败")
+        return
+    }
+
+    log.Printf("用户 %d 创建了 API Key %s (%s)", userClaims.UserID, apiKey.Prefix, apiKey.Name)
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{
+        Message: "API Key 已创建，请妥善保存，之后无法再次查看",
+        Data:    models.CreateAPIKeyResponse{Key: plain, APIKey: apiKey},
+    })
+}
+
+// --- ListAPIKeysHandler 广告主查看自己的 API Key (不包含密钥) ---
+func (h *Handler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    keys, err := h.Store.GetAPIKeysByUserID(r.Context(), userClaims.UserID)
+    if err != nil {
+        log.Printf("获取用户 %d 的 API Key 失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取 API Key 失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: keys})
+}
+
+// --- RevokeAPIKeyHandler 广告主吊销自己的 API Key，立即生效 ---
+func (h *Handler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    keyID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil || keyID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的 API Key ID")
+        return
+    }
+    if err := h.Store.RevokeAPIKey(r.Context(), keyID, userClaims.UserID, time.Now()); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该 API Key 或已被吊销")
+        } else {
+            log.Printf("用户 %d 吊销 API Key %d 失败: %v", userClaims.UserID, keyID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "吊销 API Key 失败")
+        }
+        return
+    }
+    log.Printf("用户 %d 吊销了 API Key %d", userClaims.UserID, keyID)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "API Key 已吊销"})
+}
+
+// --- AdminListRolesHandler 列出所有角色及其权限 ---
+func (h *Handler) AdminListRolesHandler(w http.ResponseWriter, r *http.Request) {
+    roles := make([]models.RoleInfo, 0, len(auth.Roles()))
+    for _, role := range auth.Roles() {
+        roles = append(roles, models.RoleInfo{Role: role, Permissions: auth.RolePermissions(role)})
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: roles})
+}
+
+// --- AdminAssignRoleHandler 给用户分配角色 ---
+// 角色写在访问令牌里，修改后吊销该用户的所有会话，让旧角色立即失效 (API Key 每次请求都从数据库读取角色)
+func (h *Handler) AdminAssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    userID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || userID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的用户 ID")
+        return
+    }
+    if userID == adminClaims.UserID {
+        // 避免唯一的 superadmin 把自己降级后无人能分配角色
+        webutil.RespondWithError(w, http.StatusBadRequest, "不能修改自己的角色")
+        return
+    }
+
+    var req models.AssignRoleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+    if !auth.ValidRole(req.Role) {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的角色，应为 "+strings.Join(auth.Roles(), ", ")+" 之一")
+        return
+    }
+
+    user, err := h.Store.GetUserByID(r.Context(), userID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该用户")
+        } else {
+            log.Printf("查询用户 %d 失败: %v", userID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "分配角色失败")
+        }
+        return
+    }
+    if user.Role != req.Role {
+        if err := h.Store.UpdateUserRole(r.Context(), userID, req.Role); err != nil {
+            if errors.Is(err, store.ErrNotFound) {
+                webutil.RespondWithError(w, http.StatusNotFound, "找不到该用户")
+            } else {
+                log.Printf("管理员 %d 修改用户 %d 的角色失败: %v", adminClaims.UserID, userID, err)
+                webutil.RespondWithError(w, http.StatusInternalServerError, "分配角色失败")
+            }
+            return
+        }
+        if _, err := h.Store.RevokeAllSessions(r.Context(), userID, time.Now()); err != nil {
+            // 角色已修改，旧令牌最迟在过期后失效
+            log.Printf("修改角色后吊销用户 %d 的会话失败: %v", userID, err)
+        }
+        log.Printf("管理员 %d 把用户 %d (%s) 的角色从 %s 改为 %s", adminClaims.UserID, userID, user.Username, user.Role, req.Role)
+        h.audit(r, models.AuditUserRole, models.AuditTargetUser, userID,
+            map[string]string{"role": user.Role}, map[string]string{"role": req.Role})
+    }
+
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "角色已更新，该用户需要重新登录",
+        Data:    models.RoleInfo{Role: req.Role, Permissions: auth.RolePermissions(req.Role)},
+    })
+}
+
+// --- AdminUpdateInvoiceStatusHandler 处理发票请求 (模拟开票) ---
+func (h *Handler) AdminUpdateInvoiceStatusHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    invoiceID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil || invoiceID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的发票请求 ID")
+        return
+    }
+
+    var req models.UpdateInvoiceStatusRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+
+    validStatuses := map[string]bool{"Processing": true, "Completed": true, "Failed": true}
+    if !validStatuses[req.Status] {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的状态值，应为 Processing, Completed 或 Failed")
+        return
+    }
+    if req.InvoiceNumber != nil && len(*req.InvoiceNumber) > 64 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "发票号码不能超过 64 个字符")
+        return
+    }
+    var processedAt *time.Time
+    if req.Status != "Processing" {
+        now := time.Now()
+        processedAt = &now
+    }
+
+    before, err := h.Store.GetInvoiceRequestByID(r.Context(), invoiceID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该发票请求")
+        } else {
+            log.Printf("查询发票请求 %d 失败: %v", invoiceID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "更新发票请求状态失败")
+        }
+        return
+    }
+    if err := h.Store.UpdateInvoiceRequestStatus(r.Context(), invoiceID, req.Status, req.InvoiceNumber, req.Notes, processedAt); err != nil {
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该发票请求")
+        case errors.Is(err, store.ErrInvoiceAlreadyProcessed):
+            webutil.RespondWithError(w, http.StatusConflict, "发票请求已处理完成，不能再修改")
+        default:
+            log.Printf("用户 %d 更新发票请求 %d 状态失败: %v", adminClaims.UserID, invoiceID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "更新发票请求状态失败")
+        }
+        return
+    }
+    log.Printf("用户 %d 把发票请求 %d 的状态更新为 %s", adminClaims.UserID, invoiceID, req.Status)
+    after := map[string]interface{}{"status": req.Status}
+    if req.InvoiceNumber != nil {
+        after["invoice_number"] = *req.InvoiceNumber
+    }
+    if req.Notes != nil {
+        after["notes"] = *req.Notes
+    }
+    h.audit(r, models.AuditInvoiceStatus, models.AuditTargetInvoice, invoiceID,
+        map[string]interface{}{"status": before.Status, "invoice_number": before.InvoiceNumber, "notes": before.Notes}, after)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "发票请求状态已更新"})
+}
+
+// --- 组织 ---
+
+// OrganizationInvitationTTL 组织邀请的有效期
+const OrganizationInvitationTTL = 7 * 24 * time.Hour
+
+// currentOrganization 返回 OrganizationMiddleware 放入 context 的当前组织成员，失败时已写入响应
+func currentOrganization(w http.ResponseWriter, r *http.Request) (*models.OrganizationMember, bool) {
+    member, ok := r.Context().Value(middleware.OrganizationContextKey).(*models.OrganizationMember)
+    if !ok || member == nil {
+        log.Println("错误：无法从 context 中获取当前组织 (路由缺少 OrganizationMiddleware?)")
+        webutil.RespondWithError(w, http.StatusInternalServerError, "无法确定当前组织")
+        return nil, false
+    }
+    return member, true
+}
+
+// organizationMember 解析路径中的组织 ID 并确认当前用户是该组织的成员，ownerOnly 时还要求是 owner。
+// 不是成员返回 404 (不暴露组织是否存在)，失败时已写入响应
+func (h *Handler) organizationMember(w http.ResponseWriter, r *http.Request, userID int, ownerOnly bool) (*models.OrganizationMember, bool) {
+    orgID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || orgID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的组织 ID")
+        return nil, false
+    }
+    member, err := h.Store.GetOrganizationMember(r.Context(), orgID, userID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该组织")
+        } else {
+            log.Printf("查询用户 %d 在组织 %d 的成员身份失败: %v", userID, orgID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证组织成员身份")
+        }
+        return nil, false
+    }
+    if ownerOnly && member.Role != auth.OrgRoleOwner {
+        webutil.RespondWithError(w, http.StatusForbidden, "只有组织 owner 可以执行该操作")
+        return nil, false
+    }
+    return member, true
+}
+
+// --- CreateOrganizationHandler 创建组织，创建者成为 owner ---
+func (h *Handler) CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    var req models.CreateOrganizationRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+    req.Name = strings.TrimSpace(req.Name)
+    if req.Name == "" || len(req.Name) > 100 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "组织名称不能为空，且不能超过 100 个字符")
+        return
+    }
+
+    org := models.Organization{Name: req.Name, CreatedAt: time.Now()}
+    if err := h.Store.CreateOrganization(r.Context(), &org, userClaims.UserID); err != nil {
+        log.Printf("用户 %d 创建组织失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "创建组织失败")
+        return
+    }
+    log.Printf("用户 %d 创建了组织 %d (%s)", userClaims.UserID, org.ID, org.Name)
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{
+        Message: "组织已创建",
+        Data:    models.OrganizationMembership{Organization: org, Role: auth.OrgRoleOwner},
+    })
+}
+
+// --- ListOrganizationsHandler 列出当前用户所属的组织 ---
+func (h *Handler) ListOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    orgs, err := h.Store.GetOrganizationsByUserID(r.Context(), userClaims.UserID)
+    if err != nil {
+        log.Printf("获取用户 %d 的组织失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取组织列表失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: orgs})
+}
+
+// --- ListOrganizationMembersHandler 列出组织成员 (任意成员可查看) ---
+func (h *Handler) ListOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    member, ok := h.organizationMember(w, r, userClaims.UserID, false)
+    if !ok { return }
+
+    members, err := h.Store.GetOrganizationMembers(r.Context(), member.OrganizationID)
+    if err != nil {
+        log.Printf("获取组织 %d 的成员失败: %v", member.OrganizationID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取成员列表失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: members})
+}
+
+// --- UpdateOrganizationMemberHandler owner 修改成员的组织角色 ---
+// 组织角色每次请求都从数据库读取，修改后立即生效
+func (h *Handler) UpdateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    member, ok := h.organizationMember(w, r, userClaims.UserID, true)
+    if !ok { return }
+
+    targetID, err := strconv.Atoi(r.PathValue("user_id"))
+    if err != nil || targetID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的用户 ID")
+        return
+    }
+    var req models.UpdateMemberRoleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+    if !auth.ValidOrgRole(req.Role) {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的组织角色，应为 "+strings.Join(auth.OrgRoles(), ", ")+" 之一")
+        return
+    }
+
+    if err := h.Store.UpdateOrganizationMemberRole(r.Context(), member.OrganizationID, targetID, req.Role); err != nil {
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+            webutil.RespondWithError(w, http.StatusNotFound, "该用户不是组织成员")
+        case errors.Is(err, store.ErrLastOwner):
+            webutil.RespondWithError(w, http.StatusConflict, "组织至少需要一个 owner")
+        default:
+            log.Printf("用户 %d 修改组织 %d 成员 %d 的角色失败: %v", userClaims.UserID, member.OrganizationID, targetID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "修改成员角色失败")
+        }
+        return
+    }
+    log.Printf("用户 %d 把组织 %d 成员 %d 的角色改为 %s", userClaims.UserID, member.OrganizationID, targetID, req.Role)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "成员角色已更新"})
+}
+
+// --- RemoveOrganizationMemberHandler owner 移除成员，或成员自己退出组织 ---
+func (h *Handler) RemoveOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    targetID, err := strconv.Atoi(r.PathValue("user_id"))
+    if err != nil || targetID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的用户 ID")
+        return
+    }
+    member, ok := h.organizationMember(w, r, userClaims.UserID, targetID != userClaims.UserID)
+    if !ok { return }
+
+    if err := h.Store.RemoveOrganizationMember(r.Context(), member.OrganizationID, targetID); err != nil {
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+            webutil.RespondWithError(w, http.StatusNotFound, "该用户不是组织成员")
+        case errors.Is(err, store.ErrLastOwner):
+            webutil.RespondWithError(w, http.StatusConflict, "不能移除组织的最后一个 owner")
+        default:
+            log.Printf("用户 %d 从组织 %d 移除成员 %d 失败: %v", userClaims.UserID, member.OrganizationID, targetID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "移除成员失败")
+        }
+        return
+    }
+    log.Printf("用户 %d 从组织 %d 移除了成员 %d", userClaims.UserID, member.OrganizationID, targetID)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "成员已移除"})
+}
+
+// --- InviteOrganizationMemberHandler owner 按用户名邀请用户加入组织 ---
+func (h *Handler) InviteOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    member, ok := h.organizationMember(w, r, userClaims.UserID, true)
+    if !ok { return }
+
+    var req models.InviteMemberRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+    if strings.TrimSpace(req.Username) == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "用户名不能为空")
+        return
+    }
+    if !auth.ValidOrgRole(req.Role) {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的组织角色，应为 "+strings.Join(auth.OrgRoles(), ", ")+" 之一")
+        return
+    }
+
+    invitee, err := h.Store.GetUserByUsername(r.Context(), strings.TrimSpace(req.Username))
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该用户")
+        } else {
+            log.Printf("查询用户 %s 失败: %v", req.Username, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "创建邀请失败")
+        }
+        return
+    }
+
+    now := time.Now()
+    inv := models.OrganizationInvitation{
+        OrganizationID: member.OrganizationID,
+        UserID:         invitee.ID,
+        Username:       invitee.Username,
+        Role:           req.Role,
+        InvitedBy:      userClaims.UserID,
+        CreatedAt:      now,
+        ExpiresAt:      now.Add(OrganizationInvitationTTL),
+    }
+    if err := h.Store.CreateOrganizationInvitation(r.Context(), &inv); err != nil {
+        if errors.Is(err, store.ErrAlreadyMember) {
+            webutil.RespondWithError(w, http.StatusConflict, "该用户已经是组织成员")
+        } else {
+            log.Printf("用户 %d 邀请用户 %d 加入组织 %d 失败: %v", userClaims.UserID, invitee.ID, member.OrganizationID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "创建邀请失败")
+        }
+        return
+    }
+    log.Printf("用户 %d 邀请用户 %d 以 %s 加入组织 %d (邀请 %d)", userClaims.UserID, invitee.ID, inv.Role, member.OrganizationID, inv.ID)
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{
+        Message: "邀请已发送，对方接受后加入组织",
+        Data:    inv,
+    })
+}
+
+// --- ListOrganizationInvitationsHandler owner 查看组织待处理的邀请 ---
+func (h *Handler) ListOrganizationInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    member, ok := h.organizationMember(w, r, userClaims.UserID, true)
+    if !ok { return }
+
+    invitations, err := h.Store.GetOrganizationInvitations(r.Context(), member.OrganizationID)
+    if err != nil {
+        log.Printf("获取组织 %d 的邀请失败: %v", member.OrganizationID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取邀请列表失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: invitations})
+}
+
+// --- RevokeOrganizationInvitationHandler owner 撤销待处理的邀请 ---
+func (h *Handler) RevokeOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+    member, ok := h.organizationMember(w, r, userClaims.UserID, true)
+    if !ok { return }
+
+    invitationID, err := strconv.ParseInt(r.PathValue("invitation_id"), 10, 64)
+    if err != nil || invitationID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的邀请 ID")
+        return
+    }
+    if err := h.Store.RevokeInvitation(r.Context(), invitationID, member.OrganizationID, time.Now()); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该邀请或邀请已处理")
+        } else {
+            log.Printf("用户 %d 撤销组织 %d 的邀请 %d 失败: %v", userClaims.UserID, member.OrganizationID, invitationID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "撤销邀请失败")
+        }
+        return
+    }
+    log.Printf("用户 %d 撤销了组织 %d 的邀请 %d", userClaims.UserID, member.OrganizationID, invitationID)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "邀请已撤销"})
+}
+
+// --- ListMyInvitationsHandler 查看发给自己的待处理邀请 ---
+func (h *Handler) ListMyInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    invitations, err := h.Store.GetInvitationsByUserID(r.Context(), userClaims.UserID, time.Now())
+    if err != nil {
+        log.Printf("获取用户 %d 的邀请失败: %v", userClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取邀请列表失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: invitations})
+}
+
+// --- AcceptInvitationHandler 接受邀请并加入组织 ---
+func (h *Handler) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+    h.respondToInvitation(w, r, true)
+}
+
+// --- DeclineInvitationHandler 拒绝邀请 ---
+func (h *Handler) DeclineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+    h.respondToInvitation(w, r, false)
+}
+
+func (h *Handler) respondToInvitation(w http.ResponseWriter, r *http.Request, accept bool) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    invitationID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+    if err != nil || invitationID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的邀请 ID")
+        return
+    }
+    inv, err := h.Store.RespondToInvitation(r.Context(), invitationID, userClaims.UserID, accept, time.Now())
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该邀请或邀请已处理")
+        case errors.Is(err, store.ErrInvitationExpired):
+            webutil.RespondWithError(w, http.StatusGone, "邀请已过期")
+        case errors.Is(err, store.ErrAlreadyMember):
+            webutil.RespondWithError(w, http.StatusConflict, "你已经是该组织的成员")
+        default:
+            log.Printf("用户 %d 处理邀请 %d 失败: %v", userClaims.UserID, invitationID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "处理邀请失败")
+        }
+        return
+    }
+
+    message := "已拒绝邀请"
+    if accept {
+        message = fmt.Sprintf("已加入组织 %s", inv.OrganizationName)
+    }
+    log.Printf("用户 %d 处理了组织 %d 的邀请 %d: %s", userClaims.UserID, inv.OrganizationID, inv.ID, inv.Status)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: message, Data: inv})
+}
+
+// --- 邮箱验证 / 找回密码 ---
+// 邮件写入发件箱后由 mail.Dispatcher 发送，链接指向前端页面 (mail.link_base_url)，前端再调用下面的接口
+
+// normalizeEmail 校验邮箱格式 (只接受纯地址，不接受 "名字 <地址>") 并统一为小写
+func normalizeEmail(s string) (string, bool) {
+    s = strings.TrimSpace(s)
+    addr, err := netmail.ParseAddress(s)
+    if err != nil || addr.Address != s || len(s) > 255 {
+        return "", false
+    }
+    return strings.ToLower(s), true
+}
+
+// sendAccountEmail 为用户生成一次性令牌 (作废同用途的旧令牌)，并把带链接的邮件写入发件箱
+func (h *Handler) sendAccountEmail(ctx context.Context, user *models.User, purpose string) error {
+    if user.Email == nil {
+        return fmt.Errorf("用户 %d 没有邮箱", user.ID)
+    }
+    token, tokenHash, err := auth.NewUserToken()
+    if err != nil {
+        return err
+    }
+    var ttl time.Duration
+    var subject, body string
+    switch purpose {
+    case models.TokenPurposeVerifyEmail:
+        ttl = h.Mail.VerificationTTL.Duration
+        subject, body = mail.VerificationEmail(user.Username, h.accountLink("/verify-email", token), ttl)
+    case models.TokenPurposeResetPassword:
+        ttl = h.Mail.PasswordResetTTL.Duration
+        subject, body = mail.PasswordResetEmail(user.Username, h.accountLink("/reset-password", token), ttl)
+    default:
+        return fmt.Errorf("未知的令牌用途 %q", purpose)
+    }
+
+    now := time.Now()
+    t := &models.UserToken{
+        UserID:    user.ID,
+        Purpose:   purpose,
+        TokenHash: tokenHash,
+        Email:     *user.Email,
+        CreatedAt: now,
+        ExpiresAt: now.Add(ttl),
+    }
+    msg := &models.OutboxMessage{Recipient: *user.Email, Subject: subject, Body: body, CreatedAt: now}
+    if err := h.Store.CreateUserToken(ctx, t, msg); err != nil {
+        return err
+    }
+    log.Printf("已为用户 %d 生成 %s 邮件 (发件箱 %d)", user.ID, purpose, msg.ID)
+    return nil
+}
+
+// accountLink 拼接前端页面的链接，例如 http://localhost:5173/verify-email?token=xxx
+func (h *Handler) accountLink(path, token string) string {
+    return strings.TrimRight(h.Mail.LinkBaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// respondTokenError 处理 VerifyEmail / ResetPassword 返回的令牌错误
+func respondTokenError(w http.ResponseWriter, err error, action string) {
+    switch {
+    case errors.Is(err, store.ErrNotFound):
+        webutil.RespondWithError(w, http.StatusBadRequest, "链接无效或已被使用")
+    case errors.Is(err, store.ErrTokenExpired):
+        webutil.RespondWithError(w, http.StatusGone, "链接已过期，请重新获取")
+    default:
+        log.Printf("%s失败: %v", action, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, action+"失败，请稍后重试")
+    }
+}
+
+// --- VerifyEmailHandler 使用验证邮件中的令牌验证邮箱 (公开) ---
+func (h *Handler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+    var req models.VerifyEmailRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请提供 token")
+        return
+    }
+    defer r.Body.Close()
+
+    user, err := h.Store.VerifyEmail(r.Context(), auth.HashUserToken(req.Token), time.Now())
+    if err != nil {
+        respondTokenError(w, err, "验证邮箱")
+        return
+    }
+    log.Printf("用户 %d 的邮箱已验证", user.ID)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "邮箱验证成功，现在可以登录了",
+        Data: map[string]interface{}{
+            "id":                user.ID,
+            "username":          user.Username,
+            "email":             user.Email,
+            "email_verified_at": user.EmailVerifiedAt,
+        },
+    })
+}
+
+// --- ResendVerificationHandler 重新发送验证邮件 (公开) ---
+// 不论邮箱是否存在、是否已验证都返回 202，避免被用来探测已注册的邮箱
+func (h *Handler) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+    email, ok := h.decodeEmailRequest(w, r)
+    if !ok {
+        return
+    }
+    user, err := h.Store.GetUserByEmail(r.Context(), email)
+    switch {
+    case err == nil && user.EmailVerifiedAt == nil:
+        if err := h.sendAccountEmail(r.Context(), user, models.TokenPurposeVerifyEmail); err != nil {
+            log.Printf("为用户 %d 重新生成验证邮件失败: %v", user.ID, err)
+        }
+    case err != nil && !errors.Is(err, store.ErrNotFound):
+        log.Printf("按邮箱查找用户失败: %v", err)
+    }
+    webutil.RespondWithJSON(w, http.StatusAccepted, webutil.Response{Message: "如果该邮箱已注册且尚未验证，验证邮件将很快送达"})
+}
+
+// --- ForgotPasswordHandler 发送重置密码邮件 (公开) ---
+// 与 ResendVerificationHandler 一样总是返回 202
+func (h *Handler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+    email, ok := h.decodeEmailRequest(w, r)
+    if !ok {
+        return
+    }
+    user, err := h.Store.GetUserByEmail(r.Context(), email)
+    switch {
+    case err == nil:
+        if err := h.sendAccountEmail(r.Context(), user, models.TokenPurposeResetPassword); err != nil {
+            log.Printf("为用户 %d 生成重置密码邮件失败: %v", user.ID, err)
+        }
+    case !errors.Is(err, store.ErrNotFound):
+        log.Printf("按邮箱查找用户失败: %v", err)
+    }
+    webutil.RespondWithJSON(w, http.StatusAccepted, webutil.Response{Message: "如果该邮箱已注册，重置密码邮件将很快送达"})
+}
+
+// decodeEmailRequest 解析 {"email": "..."} 请求体，失败时已写入响应
+func (h *Handler) decodeEmailRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+    var req models.EmailRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return "", false
+    }
+    defer r.Body.Close()
+    email, ok := normalizeEmail(req.Email)
+    if !ok {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请提供有效的邮箱地址")
+        return "", false
+    }
+    return email, true
+}
+
+// --- ResetPasswordHandler 使用重置密码邮件中的令牌设置新密码 (公开) ---
+// 成功后吊销用户所有的登录会话
+func (h *Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+    var req models.ResetPasswordRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+    if req.Token == "" || req.Password == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "token 和新密码不能为空")
+        return
+    }
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+    if err != nil {
+        log.Printf("密码哈希失败: %v", err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "服务器内部错误")
+        return
+    }
+
+    now := time.Now()
+    user, err := h.Store.ResetPassword(r.Context(), auth.HashUserToken(req.Token), string(hashedPassword), now)
+    if err != nil {
+        respondTokenError(w, err, "重置密码")
+        return
+    }
+    sessions, err := h.Store.RevokeAllSessions(r.Context(), user.ID, now)
+    if err != nil {
+        // 密码已经修改，旧会话的访问令牌很快过期，这里只记录日志
+        log.Printf("重置密码后吊销用户 %d 的会话失败: %v", user.ID, err)
+    }
+    log.Printf("用户 %d 已重置密码，吊销了 %d 个会话", user.ID, sessions)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "密码已重置，请使用新密码重新登录"})
+}
+
+// --- 两步验证 (TOTP) ---
+// 绑定流程：POST /2fa/totp/setup 生成密钥和二维码地址 -> 用验证器扫描 -> POST /2fa/totp/enable 提交验证码确认，
+// 成功后返回一次性展示的恢复码。后台角色和设置了 two_factor_required 的账号必须绑定，
+// 绑定之前登录只能拿到受限令牌 (只能访问 /2fa、setup、enable 和 /logout)。
+
+// twoFactorUser 获取当前登录用户；两步验证只能在登录会话中管理，不接受 API Key。失败时已写入响应
+func (h *Handler) twoFactorUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+    userClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || userClaims == nil {
+        webutil.RespondWithError(w, http.StatusUnauthorized, "无效的认证凭证或无法获取用户信息")
+        return nil, false
+    }
+    if userClaims.APIKeyID != 0 {
+        webutil.RespondWithError(w, http.StatusForbidden, "两步验证只能在登录会话中管理，不能使用 API Key")
+        return nil, false
+    }
+    user, err := h.Store.GetUserByID(r.Context(), userClaims.UserID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "用户不存在")
+        } else {
+            log.Printf("获取用户 %d 失败: %v", userClaims.UserID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "获取用户信息失败")
+        }
+        return nil, false
+    }
+    return user, true
+}
+
+// twoFactorStatus 汇总用户的两步验证状态
+func (h *Handler) twoFactorStatus(ctx context.Context, user *models.User) (*models.TwoFactorStatus, error) {
+    status := &models.TwoFactorStatus{
+        Enabled:        user.TOTPEnabledAt != nil,
+        EnabledAt:      user.TOTPEnabledAt,
+        Required:       auth.RequiresTwoFactor(user.Role, user.TwoFactorRequired),
+        RequiredByRole: auth.RequiresTwoFactor(user.Role, false),
+    }
+    if status.Enabled {
+        n, err := h.Store.CountUnusedRecoveryCodes(ctx, user.ID)
+        if err != nil {
+            return nil, err
+        }
+        status.RecoveryCodesRemaining = n
+    }
+    return status, nil
+}
+
+// respondTwoFactorStatus 返回用户的两步验证状态
+func (h *Handler) respondTwoFactorStatus(w http.ResponseWriter, r *http.Request, user *models.User, message string) {
+    status, err := h.twoFactorStatus(r.Context(), user)
+    if err != nil {
+        log.Printf("获取用户 %d 的两步验证状态失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取两步验证状态失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: message, Data: status})
+}
+
+// checkTwoFactorCode 校验已启用两步验证的用户提交的验证码；allowRecovery 为 true 时也接受恢复码 (使用后作废)。
+// 验证码所在的时间步只能使用一次
+func (h *Handler) checkTwoFactorCode(ctx context.Context, user *models.User, code string, allowRecovery bool) (bool, error) {
+    if auth.LooksLikeTOTPCode(code) {
+        step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+        if !ok {
+            return false, nil
+        }
+        if err := h.Store.UseTOTPStep(ctx, user.ID, step); err != nil {
+            if errors.Is(err, store.ErrOTPReused) {
+                return false, nil
+            }
+            return false, err
+        }
+        return true, nil
+    }
+    if !allowRecovery || strings.TrimSpace(code) == "" {
+        return false, nil
+    }
+    if err := h.Store.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(code), time.Now()); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            return false, nil
+        }
+        return false, err
+    }
+    log.Printf("用户 %d 使用了一个恢复码", user.ID)
+    return true, nil
+}
+
+// decodeTwoFactorCode 解析 {"code": "..."} 请求体，失败时已写入响应
+func decodeTwoFactorCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+    var req models.TwoFactorCodeRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请提供验证码 code")
+        return "", false
+    }
+    defer r.Body.Close()
+    return req.Code, true
+}
+
+// --- TwoFactorLoginHandler 两步登录第二步：用登录挑战 mfa_token 和验证码 (或恢复码) 换取令牌 (公开) ---
+func (h *Handler) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+    var req models.TwoFactorLoginRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || strings.TrimSpace(req.Code) == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请提供 mfa_token 和验证码 code")
+        return
+    }
+    defer r.Body.Close()
+
+    now := time.Now()
+    challenge, err := h.Store.GetLoginChallenge(r.Context(), auth.HashUserToken(req.MFAToken))
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "登录已失效，请重新输入密码")
+        } else {
+            log.Printf("查询登录挑战失败: %v", err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+        }
+        return
+    }
+    if challenge.UsedAt != nil || !now.Before(challenge.ExpiresAt) {
+        webutil.RespondWithError(w, http.StatusUnauthorized, "登录已失效，请重新输入密码")
+        return
+    }
+    user, err := h.Store.GetUserByID(r.Context(), challenge.UserID)
+    if err != nil {
+        log.Printf("两步登录时获取用户 %d 失败: %v", challenge.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+        return
+    }
+    if user.TOTPEnabledAt == nil {
+        // 挑战签发后两步验证被管理员重置
+        webutil.RespondWithError(w, http.StatusUnauthorized, "登录已失效，请重新输入密码")
+        return
+    }
+    if user.SuspendedAt != nil {
+        webutil.RespondWithError(w, http.StatusForbidden, accountSuspendedMessage)
+        return
+    }
+    if !h.checkLoginThrottle(w, r, user.Username) {
+        return
+    }
+
+    valid, err := h.checkTwoFactorCode(r.Context(), user, req.Code, true)
+    if err != nil {
+        log.Printf("校验用户 %d 的两步验证码失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+        return
+    }
+    if !valid {
+        // 验证码错误也计入登录失败，防止拿到密码后通过不断重新登录来穷举验证码
+        h.recordLoginFailure(r, user.Username)
+        attempts, err := h.Store.FailLoginChallenge(r.Context(), challenge.ID, h.TwoFactor.MaxAttempts, now)
+        if err != nil {
+            log.Printf("记录登录挑战 %d 的失败次数失败: %v", challenge.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+            return
+        }
+        log.Printf("用户 %s 两步验证码错误 (第 %d 次)", user.Username, attempts)
+        h.auditLogin(r, models.AuditLoginFailed, user, user.Username, map[string]interface{}{"reason": "wrong_2fa_code", "attempts": attempts})
+        if attempts >= h.TwoFactor.MaxAttempts {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "验证码错误次数过多，请重新输入密码")
+            return
+        }
+        webutil.RespondWithError(w, http.StatusUnauthorized, fmt.Sprintf("验证码错误，还可以尝试 %d 次", h.TwoFactor.MaxAttempts-attempts))
+        return
+    }
+    if err := h.Store.CompleteLoginChallenge(r.Context(), challenge.ID, now); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusUnauthorized, "登录已失效，请重新输入密码")
+        } else {
+            log.Printf("完成登录挑战 %d 失败: %v", challenge.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "登录失败，请稍后重试")
+        }
+        return
+    }
+
+    h.respondLoginSuccess(w, r, user)
+}
+
+// --- GetTwoFactorStatusHandler 查看自己的两步验证状态 ---
+func (h *Handler) GetTwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.twoFactorUser(w, r)
+    if !ok {
+        return
+    }
+    h.respondTwoFactorStatus(w, r, user, "")
+}
+
+// --- SetupTOTPHandler 生成待确认的 TOTP 密钥，返回密钥和用于生成二维码的 otpauth:// 地址 ---
+// 重复调用会生成新的密钥 (之前未确认的密钥作废)
+func (h *Handler) SetupTOTPHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.twoFactorUser(w, r)
+    if !ok {
+        return
+    }
+    if user.TOTPEnabledAt != nil {
+        webutil.RespondWithError(w, http.StatusConflict, "已经启用了两步验证，如需更换验证器请先关闭")
+        return
+    }
+    secret, err := auth.GenerateTOTPSecret()
+    if err != nil {
+        log.Printf("为用户 %d 生成 TOTP 密钥失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "生成密钥失败")
+        return
+    }
+    if err := h.Store.SetTOTPSecret(r.Context(), user.ID, secret); err != nil {
+        if errors.Is(err, store.ErrTOTPAlreadyEnabled) {
+            webutil.RespondWithError(w, http.StatusConflict, "已经启用了两步验证，如需更换验证器请先关闭")
+        } else {
+            log.Printf("保存用户 %d 的 TOTP 密钥失败: %v", user.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "生成密钥失败")
+        }
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "请用验证器扫描二维码，然后提交验证码完成绑定",
+        Data: map[string]string{
+            "secret":           secret,
+            "provisioning_uri": auth.TOTPProvisioningURI(h.TwoFactor.Issuer, user.Username, secret),
+        },
+    })
+}
+
+// --- EnableTOTPHandler 提交验证器上的验证码确认绑定，返回恢复码 (只展示一次) ---
+// 启用后吊销该用户的所有会话 (包括受限令牌)，并签发新的完整令牌
+func (h *Handler) EnableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.twoFactorUser(w, r)
+    if !ok {
+        return
+    }
+    code, ok := decodeTwoFactorCode(w, r)
+    if !ok {
+        return
+    }
+    if user.TOTPEnabledAt != nil {
+        webutil.RespondWithError(w, http.StatusConflict, "已经启用了两步验证")
+        return
+    }
+    if user.TOTPSecret == "" {
+        webutil.RespondWithError(w, http.StatusConflict, "请先调用 POST /2fa/totp/setup 生成密钥")
+        return
+    }
+    now := time.Now()
+    step, valid := auth.ValidateTOTP(user.TOTPSecret, code, now)
+    if !valid {
+        webutil.RespondWithError(w, http.StatusBadRequest, "验证码错误，请确认验证器的时间准确")
+        return
+    }
+    codes, hashes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+    if err != nil {
+        log.Printf("为用户 %d 生成恢复码失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "启用两步验证失败")
+        return
+    }
+    if err := h.Store.EnableTOTP(r.Context(), user.ID, step, hashes, now); err != nil {
+        switch {
+        case errors.Is(err, store.ErrTOTPAlreadyEnabled):
+            webutil.RespondWithError(w, http.StatusConflict, "已经启用了两步验证")
+        case errors.Is(err, store.ErrNotFound):
+            webutil.RespondWithError(w, http.StatusConflict, "请先调用 POST /2fa/totp/setup 生成密钥")
+        default:
+            log.Printf("启用用户 %d 的两步验证失败: %v", user.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "启用两步验证失败")
+        }
+        return
+    }
+    log.Printf("用户 %s (ID: %d) 启用了两步验证", user.Username, user.ID)
+
+    // 之前的会话没有经过两步验证，全部吊销后签发新的令牌
+    if _, err := h.Store.RevokeAllSessions(r.Context(), user.ID, now); err != nil {
+        log.Printf("启用两步验证后吊销用户 %d 的会话失败: %v", user.ID, err)
+    }
+    enabledAt := now
+    user.TOTPEnabledAt = &enabledAt
+    tokens, err := h.issueTokens(r, user, "")
+    if err != nil {
+        log.Printf("启用两步验证后为用户 %d 签发令牌失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "两步验证已启用，但无法签发新令牌，请重新登录")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "两步验证已启用，请妥善保存恢复码 (只显示这一次)；其他会话已登出",
+        Data: map[string]interface{}{
+            "enabled_at":         enabledAt,
+            "recovery_codes":     codes,
+            "token":              tokens.Token,
+            "expires_at":         tokens.ExpiresAt,
+            "refresh_token":      tokens.RefreshToken,
+            "refresh_expires_at": tokens.RefreshExpiresAt,
+        },
+    })
+}
+
+// --- DisableTOTPHandler 关闭两步验证 (需要验证码或恢复码)；必须启用两步验证的账号不能关闭 ---
+func (h *Handler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.twoFactorUser(w, r)
+    if !ok {
+        return
+    }
+    code, ok := decodeTwoFactorCode(w, r)
+    if !ok {
+        return
+    }
+    if user.TOTPEnabledAt == nil {
+        webutil.RespondWithError(w, http.StatusConflict, "尚未启用两步验证")
+        return
+    }
+    if auth.RequiresTwoFactor(user.Role, user.TwoFactorRequired) {
+        webutil.RespondWithError(w, http.StatusForbidden, "该账号必须使用两步验证，不能关闭")
+        return
+    }
+    valid, err := h.checkTwoFactorCode(r.Context(), user, code, true)
+    if err != nil {
+        log.Printf("校验用户 %d 的两步验证码失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "关闭两步验证失败")
+        return
+    }
+    if !valid {
+        webutil.RespondWithError(w, http.StatusBadRequest, "验证码错误")
+        return
+    }
+    if err := h.Store.DisableTOTP(r.Context(), user.ID); err != nil {
+        log.Printf("关闭用户 %d 的两步验证失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "关闭两步验证失败")
+        return
+    }
+    log.Printf("用户 %s (ID: %d) 关闭了两步验证", user.Username, user.ID)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "两步验证已关闭"})
+}
+
+// --- RegenerateRecoveryCodesHandler 重新生成恢复码 (需要验证码或恢复码)，旧的恢复码全部作废 ---
+func (h *Handler) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.twoFactorUser(w, r)
+    if !ok {
+        return
+    }
+    code, ok := decodeTwoFactorCode(w, r)
+    if !ok {
+        return
+    }
+    if user.TOTPEnabledAt == nil {
+        webutil.RespondWithError(w, http.StatusConflict, "尚未启用两步验证")
+        return
+    }
+    valid, err := h.checkTwoFactorCode(r.Context(), user, code, true)
+    if err != nil {
+        log.Printf("校验用户 %d 的两步验证码失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "生成恢复码失败")
+        return
+    }
+    if !valid {
+        webutil.RespondWithError(w, http.StatusBadRequest, "验证码错误")
+        return
+    }
+    codes, hashes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+    if err == nil {
+        err = h.Store.ReplaceRecoveryCodes(r.Context(), user.ID, hashes, time.Now())
+    }
+    if err != nil {
+        log.Printf("为用户 %d 重新生成恢复码失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "生成恢复码失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "已生成新的恢复码 (只显示这一次)，旧的恢复码已作废",
+        Data:    map[string][]string{"recovery_codes": codes},
+    })
+}
+
+// --- UpdateTwoFactorSettingsHandler 设置自己的账号是否必须使用两步验证 ---
+// 开启前必须已经绑定验证器；后台角色始终必须使用两步验证，与此设置无关
+func (h *Handler) UpdateTwoFactorSettingsHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.twoFactorUser(w, r)
+    if !ok {
+        return
+    }
+    var req models.TwoFactorSettingRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Required == nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，需要 required")
+        return
+    }
+    defer r.Body.Close()
+    if *req.Required && user.TOTPEnabledAt == nil {
+        webutil.RespondWithError(w, http.StatusConflict, "请先启用两步验证")
+        return
+    }
+    if err := h.Store.SetTwoFactorRequired(r.Context(), user.ID, *req.Required); err != nil {
+        log.Printf("修改用户 %d 的两步验证设置失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "修改设置失败")
+        return
+    }
+    user.TwoFactorRequired = *req.Required
+    h.respondTwoFactorStatus(w, r, user, "设置已更新")
+}
+
+// --- AdminSetTwoFactorRequiredHandler 管理员要求 (或取消要求) 用户必须使用两步验证 ---
+// 要求一个尚未绑定的用户启用时吊销该用户的所有会话，下次登录只能拿到受限令牌
+func (h *Handler) AdminSetTwoFactorRequiredHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+    var req models.TwoFactorSettingRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Required == nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误，需要 required")
+        return
+    }
+    defer r.Body.Close()
+
+    if user.TwoFactorRequired != *req.Required {
+        if err := h.Store.SetTwoFactorRequired(r.Context(), user.ID, *req.Required); err != nil {
+            log.Printf("管理员 %d 修改用户 %d 的两步验证设置失败: %v", adminClaims.UserID, user.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "修改设置失败")
+            return
+        }
+        user.TwoFactorRequired = *req.Required
+        if needsTwoFactorEnrollment(user) {
+            if _, err := h.Store.RevokeAllSessions(r.Context(), user.ID, time.Now()); err != nil {
+                log.Printf("要求两步验证后吊销用户 %d 的会话失败: %v", user.ID, err)
+            }
+        }
+        log.Printf("管理员 %d 把用户 %d (%s) 的 two_factor_required 改为 %t", adminClaims.UserID, user.ID, user.Username, *req.Required)
+        h.audit(r, models.AuditUserTwoFactor, models.AuditTargetUser, user.ID,
+            map[string]bool{"two_factor_required": !*req.Required}, map[string]bool{"two_factor_required": *req.Required})
+    }
+    h.respondTwoFactorStatus(w, r, user, "设置已更新")
+}
+
+// --- AdminResetTwoFactorHandler 管理员重置用户的两步验证 (用户丢失验证器且没有恢复码时) ---
+// 清除密钥和恢复码并吊销所有会话；必须使用两步验证的用户下次登录后需要重新绑定
+func (h *Handler) AdminResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+    if err := h.Store.DisableTOTP(r.Context(), user.ID); err != nil {
+        log.Printf("管理员 %d 重置用户 %d 的两步验证失败: %v", adminClaims.UserID, user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "重置两步验证失败")
+        return
+    }
+    if _, err := h.Store.RevokeAllSessions(r.Context(), user.ID, time.Now()); err != nil {
+        log.Printf("重置两步验证后吊销用户 %d 的会话失败: %v", user.ID, err)
+    }
+    log.Printf("管理员 %d 重置了用户 %d (%s) 的两步验证", adminClaims.UserID, user.ID, user.Username)
+    h.audit(r, models.AuditUserTwoFactor, models.AuditTargetUser, user.ID,
+        map[string]bool{"totp_enabled": user.TOTPEnabledAt != nil}, map[string]bool{"totp_enabled": false})
+    user.TOTPEnabledAt = nil
+    user.TOTPSecret = ""
+    h.respondTwoFactorStatus(w, r, user, "两步验证已重置，该用户需要重新登录")
+}
+
+// adminTargetUser 解析路径中的用户 ID 并查找用户，失败时已写入响应
+func (h *Handler) adminTargetUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+    userID, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || userID <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的用户 ID")
+        return nil, false
+    }
+    user, err := h.Store.GetUserByID(r.Context(), userID)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该用户")
+        } else {
+            log.Printf("查询用户 %d 失败: %v", userID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "查询用户失败")
+        }
+        return nil, false
+    }
+    return user, true
+}
+
+// --- 登录限流 ---
+// 登录、两步登录和注册按用户名 / 客户端 IP 统计连续失败次数 (见 internal/throttle)
+
+// checkLoginThrottle 检查用户名和客户端 IP (username 为空时只检查 IP) 是否处于退避或锁定期，
+// 被限流时写入 429 响应 (带 Retry-After) 并返回 false。计数器不可用时只记录日志，不阻止登录
+func (h *Handler) checkLoginThrottle(w http.ResponseWriter, r *http.Request, username string) bool {
+    decision, err := h.Throttle.Check(r.Context(), username, middleware.ClientIPFromRequest(r), time.Now())
+    if err != nil {
+        log.Printf("登录限流检查失败: %v", err)
+        return true
+    }
+    if decision.Allowed {
+        return true
+    }
+    seconds := int(math.Ceil(decision.RetryAfter.Seconds()))
+    w.Header().Set("Retry-After", strconv.Itoa(seconds))
+    if decision.Locked {
+        webutil.RespondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("登录失败次数过多，已被临时锁定，请 %d 分钟后重试", (seconds+59)/60))
+    } else {
+        webutil.RespondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("尝试过于频繁，请 %d 秒后重试", seconds))
+    }
+    return false
+}
+
+// recordLoginFailure 记录一次失败 (username 为空时只记录 IP)
+func (h *Handler) recordLoginFailure(r *http.Request, username string) {
+    if _, err := h.Throttle.Fail(r.Context(), username, middleware.ClientIPFromRequest(r), time.Now()); err != nil {
+        log.Printf("记录登录失败次数失败: %v", err)
+    }
+}
+
+// --- AdminUnlockUserHandler 管理员解锁因连续登录失败被锁定的账号 (同时清除失败次数) ---
+// 只清除用户名维度的记录，按 IP 的限流不受影响
+func (h *Handler) AdminUnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+    previous, err := h.Throttle.Status(r.Context(), user.Username, time.Now())
+    if err == nil {
+        err = h.Throttle.Unlock(r.Context(), user.Username)
+    }
+    if err != nil {
+        log.Printf("管理员 %d 解锁用户 %d 失败: %v", adminClaims.UserID, user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "解锁失败")
+        return
+    }
+    log.Printf("管理员 %d 解锁了用户 %d (%s) 的登录限制", adminClaims.UserID, user.ID, user.Username)
+    h.audit(r, models.AuditUserUnlock, models.AuditTargetUser, user.ID, previous, nil)
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{
+        Message: "账号已解锁",
+        Data:    map[string]interface{}{"id": user.ID, "username": user.Username, "previous": previous},
+    })
+}
+
+// --- 后台用户管理 ---
+// 列表和详情需要 users:read，停用 / 恢复需要 users:manage，调整余额需要 ledger:write
+
+// accountSuspendedMessage 与 middleware 中停用账号的提示一致
+const accountSuspendedMessage = "账号已被停用，请联系管理员"
+
+// maxSuspensionReasonLength 与 users.suspension_reason 列的长度一致
+const maxSuspensionReasonLength = 255
+
+// --- AdminListUsersHandler 按用户名 / 邮箱、角色和状态分页查询用户 ---
+func (h *Handler) AdminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    page, pageSize := 1, 20
+    filter := models.AdminUserFilter{Query: query.Get("q")}
+    if pageStr := query.Get("page"); pageStr != "" {
+        p, err := strconv.Atoi(pageStr)
+        if err != nil || p <= 0 { webutil.RespondWithError(w, http.StatusBadRequest, "无效的页码"); return }
+        page = p
+    }
+    if sizeStr := query.Get("page_size"); sizeStr != "" {
+        size, err := strconv.Atoi(sizeStr)
+        if err != nil || size <= 0 || size > 100 { webutil.RespondWithError(w, http.StatusBadRequest, "page_size 应在 1 到 100 之间"); return }
+        pageSize = size
+    }
+    if role := query.Get("role"); role != "" {
+        if !auth.ValidRole(role) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的角色，应为 "+strings.Join(auth.Roles(), ", ")+" 之一")
+            return
+        }
+        filter.Role = &role
+    }
+    if status := query.Get("status"); status != "" {
+        if status != models.UserStatusActive && status != models.UserStatusSuspended {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的状态，应为 active 或 suspended")
+            return
+        }
+        filter.Status = &status
+    }
+
+    // 多取一条判断是否还有下一页
+    filter.Offset = (page - 1) * pageSize
+    filter.Limit = pageSize + 1
+    users, err := h.Store.ListUsers(r.Context(), filter)
+    if err != nil {
+        log.Printf("查询用户列表失败: %v", err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取用户列表失败")
+        return
+    }
+    hasMore := len(users) > pageSize
+    if hasMore {
+        users = users[:pageSize]
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: models.AdminUserPage{
+        Users:    users,
+        Page:     page,
+        PageSize: pageSize,
+        HasMore:  hasMore,
+    }})
+}
+
+// --- AdminGetUserHandler 查看用户详情：所属组织 (含余额)，以及该用户创建的广告创意、广告活动和充值记录 ---
+func (h *Handler) AdminGetUserHandler(w http.ResponseWriter, r *http.Request) {
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+
+    details := models.AdminUserDetails{User: *user}
+    var err error
+    if details.Organizations, err = h.Store.GetOrganizationsByUserID(r.Context(), user.ID); err == nil {
+        if details.Advertisements, err = h.Store.GetAdvertisementsByUserID(r.Context(), user.ID); err == nil {
+            if details.Campaigns, err = h.Store.GetAdCampaignsByUserID(r.Context(), user.ID); err == nil {
+                details.Recharges, err = h.Store.GetRechargesByUserID(r.Context(), user.ID)
+            }
+        }
+    }
+    if err != nil {
+        log.Printf("查询用户 %d 的详情失败: %v", user.ID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取用户详情失败")
+        return
+    }
+    // 空列表返回 [] 而不是 null
+    if details.Advertisements == nil { details.Advertisements = []models.Advertisement{} }
+    if details.Campaigns == nil { details.Campaigns = []models.CampaignWithAdDetails{} }
+    if details.Recharges == nil { details.Recharges = []models.RechargeTransaction{} }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: details})
+}
+
+// --- AdminSuspendUserHandler 停用账号 ---
+// 停用后吊销该用户的所有会话 (API Key 在认证时检查账号状态)，该用户创建的广告活动不再投放
+func (h *Handler) AdminSuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    var req models.SuspendUserRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        return
+    }
+    defer r.Body.Close()
+    reason := strings.TrimSpace(req.Reason)
+    if reason == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "必须填写停用原因 (reason)")
+        return
+    }
+    if utf8.RuneCountInString(reason) > maxSuspensionReasonLength {
+        webutil.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("停用原因不能超过 %d 个字符", maxSuspensionReasonLength))
+        return
+    }
+
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+    if user.ID == adminClaims.UserID {
+        webutil.RespondWithError(w, http.StatusBadRequest, "不能停用自己的账号")
+        return
+    }
+    if user.SuspendedAt != nil {
+        webutil.RespondWithError(w, http.StatusConflict, "该账号已处于停用状态")
+        return
+    }
+
+    now := time.Now()
+    if err := h.Store.SetUserSuspension(r.Context(), user.ID, &now, &reason); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该用户")
+        } else {
+            log.Printf("管理员 %d 停用用户 %d 失败: %v", adminClaims.UserID, user.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "停用账号失败")
+        }
+        return
+    }
+    if _, err := h.Store.RevokeAllSessions(r.Context(), user.ID, now); err != nil {
+        // AuthMiddleware 会检查账号状态，吊销失败不影响停用的效果
+        log.Printf("停用后吊销用户 %d 的会话失败: %v", user.ID, err)
+    }
+    log.Printf("管理员 %d 停用了用户 %d (%s)，原因: %s", adminClaims.UserID, user.ID, user.Username, reason)
+    h.audit(r, models.AuditUserSuspend, models.AuditTargetUser, user.ID,
+        map[string]string{"status": models.UserStatusActive},
+        map[string]string{"status": models.UserStatusSuspended, "reason": reason})
+    h.Serving.Invalidate()
+
+    user.SuspendedAt = &now
+    user.SuspensionReason = &reason
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "账号已停用", Data: user})
+}
+
+// --- AdminUnsuspendUserHandler 恢复已停用的账号 ---
+func (h *Handler) AdminUnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+    if user.SuspendedAt == nil {
+        webutil.RespondWithError(w, http.StatusConflict, "该账号未被停用")
+        return
+    }
+    if err := h.Store.SetUserSuspension(r.Context(), user.ID, nil, nil); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该用户")
+        } else {
+            log.Printf("管理员 %d 恢复用户 %d 失败: %v", adminClaims.UserID, user.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "恢复账号失败")
+        }
+        return
+    }
+    log.Printf("管理员 %d 恢复了用户 %d (%s) 的账号", adminClaims.UserID, user.ID, user.Username)
+    h.audit(r, models.AuditUserUnsuspend, models.AuditTargetUser, user.ID,
+        map[string]interface{}{"status": models.UserStatusSuspended, "reason": user.SuspensionReason},
+        map[string]string{"status": models.UserStatusActive})
+    h.Serving.Invalidate()
+
+    user.SuspendedAt = nil
+    user.SuspensionReason = nil
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "账号已恢复", Data: user})
+}
+
+// --- AdminAdjustUserBalanceHandler 手工调整用户所在组织的余额 ---
+// 以 adjustment 分录记账 (原因写入备注，分录关联该用户)，默认调整用户的默认组织
+func (h *Handler) AdminAdjustUserBalanceHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    var req models.BalanceAdjustmentRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        if !respondMoneyError(w, "金额", err) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "无效的请求体")
+        }
+        return
+    }
+    defer r.Body.Close()
+    reason := strings.TrimSpace(req.Reason)
+    if reason == "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, "必须填写调整原因 (reason)")
+        return
+    }
+    if req.Amount.IsZero() {
+        webutil.RespondWithError(w, http.StatusBadRequest, "调整金额不能为 0")
+        return
+    }
+    if err := req.Amount.CheckRange(); err != nil {
+        respondMoneyError(w, "金额", err)
+        return
+    }
+
+    user, ok := h.adminTargetUser(w, r)
+    if !ok {
+        return
+    }
+    var orgID int
+    switch {
+    case req.OrganizationID != nil:
+        orgID = *req.OrganizationID
+    case user.DefaultOrganizationID != nil:
+        orgID = *user.DefaultOrganizationID
+    default:
+        webutil.RespondWithError(w, http.StatusBadRequest, "该用户没有默认组织，请指定 organization_id")
+        return
+    }
+    if _, err := h.Store.GetOrganizationMember(r.Context(), orgID, user.ID); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "该用户不是该组织的成员")
+        } else {
+            log.Printf("查询用户 %d 在组织 %d 的成员信息失败: %v", user.ID, orgID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "调整余额失败")
+        }
+        return
+    }
+
+    entry, err := ledger.ManualEntry(ledger.KindAdjustment, orgID, req.Amount.Minor, reason, adminClaims.UserID)
+    if err != nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的金额")
+        return
+    }
+    entry.UserID = user.ID
+    before := h.balanceForAudit(r, orgID)
+    entryID, err := h.Store.PostLedgerEntry(r.Context(), entry)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该组织")
+        } else if errors.Is(err, store.ErrInsufficientBalance) {
+            webutil.RespondWithError(w, http.StatusConflict, "组织余额不足")
+        } else {
+            log.Printf("管理员 %d 调整用户 %d (组织 %d) 的余额失败: %v", adminClaims.UserID, user.ID, orgID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "调整余额失败")
+        }
+        return
+    }
+    log.Printf("管理员 %d 调整了用户 %d (组织 %d) 的余额 %d 分 (分录 %d)，原因: %s",
+        adminClaims.UserID, user.ID, orgID, req.Amount.Minor, entryID, reason)
+
+    after := h.ledgerChangeForAudit(r, orgID, entryID, ledger.KindAdjustment, req.Amount, reason)
+    after["user_id"] = user.ID
+    h.audit(r, models.AuditBalanceAdjust, models.AuditTargetOrganization, orgID, before, after)
+    h.Serving.Invalidate()
+
+    data := map[string]interface{}{"entry_id": entryID, "organization_id": orgID}
+    if balance, ok := after["balance"]; ok {
+        data["balance"] = balance
+    } else {
+        log.Printf("调整后获取组织 %d 余额失败", orgID)
+    }
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{Message: "余额已调整", Data: data})
+}
+
+// --- 广告位 ---
+// 管理广告位需要 placements:manage；登录用户可以查看启用的广告位 (提交创意时按广告位的尺寸和格式制作)
+
+// maxAdDimension 广告位和创意尺寸 (宽、高) 的上限，单位像素
+const maxAdDimension = 4096
+
+// maxPlacementNameLength 与 placements.name 列的长度一致
+const maxPlacementNameLength = 100
+
+// validateAdSize 校验创意或广告位的尺寸，返回错误提示 (为空表示有效)
+func validateAdSize(width, height int) string {
+    if width <= 0 || height <= 0 || width > maxAdDimension || height > maxAdDimension {
+        return fmt.Sprintf("宽度 width 和高度 height 应在 1 到 %d 像素之间", maxAdDimension)
+    }
+    return ""
+}
+
+// buildPlacement 校验广告位请求体并写入 p，返回错误提示 (为空表示有效)
+func buildPlacement(req *models.PlacementRequest, p *models.Placement) string {
+    name := strings.TrimSpace(req.Name)
+    if name == "" || utf8.RuneCountInString(name) > maxPlacementNameLength {
+        return fmt.Sprintf("广告位名称不能为空，且不能超过 %d 个字符", maxPlacementNameLength)
+    }
+    if msg := validateAdSize(req.Width, req.Height); msg != "" {
+        return msg
+    }
+    if len(req.Formats) == 0 {
+        return "formats 至少包含一种创意格式"
+    }
+    formats := []string{}
+    for _, f := range req.Formats {
+        f = strings.ToLower(strings.TrimSpace(f))
+        if !models.ValidAdFormat(f) {
+            return "无效的创意格式 " + f + "，应为 " + strings.Join(models.AdFormats(), ", ") + " 之一"
+        }
+        if !slices.Contains(formats, f) {
+            formats = append(formats, f)
+        }
+    }
+    if req.FloorPrice.Minor < 0 {
+        return "底价 floor_price 不能为负数"
+    }
+    status := req.Status
+    if status == "" {
+        status = models.PlacementStatusActive
+    }
+    if status != models.PlacementStatusActive && status != models.PlacementStatusDisabled {
+        return "无效的状态，应为 Active 或 Disabled"
+    }
+
+    p.Name = name
+    p.Width, p.Height = req.Width, req.Height
+    p.Formats = formats
+    p.FloorPrice = req.FloorPrice
+    p.Status = status
+    return ""
+}
+
+// decodePlacementRequest 解码并校验广告位请求体，失败时已写入响应
+func decodePlacementRequest(w http.ResponseWriter, r *http.Request, p *models.Placement) bool {
+    var req models.PlacementRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        if !respondMoneyError(w, "底价 floor_price", err) {
+            webutil.RespondWithError(w, http.StatusBadRequest, "请求体格式错误")
+        }
+        return false
+    }
+    defer r.Body.Close()
+    if req.FloorPrice.Minor > 0 {
+        if err := req.FloorPrice.CheckRange(); err != nil {
+            respondMoneyError(w, "底价 floor_price", err)
+            return false
+        }
+    }
+    if msg := buildPlacement(&req, p); msg != "" {
+        webutil.RespondWithError(w, http.StatusBadRequest, msg)
+        return false
+    }
+    return true
+}
+
+// adminTargetPlacement 读取路径中的广告位，失败时已写入响应
+func (h *Handler) adminTargetPlacement(w http.ResponseWriter, r *http.Request) (*models.Placement, bool) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil || id <= 0 {
+        webutil.RespondWithError(w, http.StatusBadRequest, "无效的广告位 ID")
+        return nil, false
+    }
+    p, err := h.Store.GetPlacementByID(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该广告位")
+        } else {
+            log.Printf("查询广告位 %d 失败: %v", id, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告位失败")
+        }
+        return nil, false
+    }
+    return p, true
+}
+
+// --- ListPlacementsHandler 查看启用的广告位 (登录用户) ---
+func (h *Handler) ListPlacementsHandler(w http.ResponseWriter, r *http.Request) {
+    placements, err := h.Store.ListPlacements(r.Context())
+    if err != nil {
+        log.Printf("查询广告位列表失败: %v", err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告位列表失败")
+        return
+    }
+    active := []models.Placement{}
+    for _, p := range placements {
+        if p.Status == models.PlacementStatusActive {
+            active = append(active, p)
+        }
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: active})
+}
+
+// --- AdminListPlacementsHandler 查看所有广告位 (包括已停用的) ---
+func (h *Handler) AdminListPlacementsHandler(w http.ResponseWriter, r *http.Request) {
+    placements, err := h.Store.ListPlacements(r.Context())
+    if err != nil {
+        log.Printf("查询广告位列表失败: %v", err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取广告位列表失败")
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: placements})
+}
+
+// --- AdminGetPlacementHandler 查看单个广告位 ---
+func (h *Handler) AdminGetPlacementHandler(w http.ResponseWriter, r *http.Request) {
+    p, ok := h.adminTargetPlacement(w, r)
+    if !ok {
+        return
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: p})
+}
+
+// --- AdminCreatePlacementHandler 创建广告位 ---
+func (h *Handler) AdminCreatePlacementHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    p := &models.Placement{CreatedBy: &adminClaims.UserID}
+    if !decodePlacementRequest(w, r, p) {
+        return
+    }
+    if err := h.Store.CreatePlacement(r.Context(), p); err != nil {
+        log.Printf("管理员 %d 创建广告位失败: %v", adminClaims.UserID, err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "创建广告位失败")
+        return
+    }
+    log.Printf("管理员 %d 创建了广告位 %d (%s, %dx%d)", adminClaims.UserID, p.ID, p.Name, p.Width, p.Height)
+    h.audit(r, models.AuditPlacementCreate, models.AuditTargetPlacement, p.ID, nil, p)
+    h.Serving.Invalidate()
+    webutil.RespondWithJSON(w, http.StatusCreated, webutil.Response{Message: "广告位已创建", Data: p})
+}
+
+// --- AdminUpdatePlacementHandler 修改广告位 (整体替换名称、尺寸、格式、底价和状态)，status 为 Disabled 时停用 ---
+func (h *Handler) AdminUpdatePlacementHandler(w http.ResponseWriter, r *http.Request) {
+    adminClaims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims)
+    if !ok || adminClaims == nil { webutil.RespondWithError(w, http.StatusUnauthorized, "无效的用户信息"); return }
+
+    before, ok := h.adminTargetPlacement(w, r)
+    if !ok {
+        return
+    }
+    p := &models.Placement{ID: before.ID}
+    if !decodePlacementRequest(w, r, p) {
+        return
+    }
+    if err := h.Store.UpdatePlacement(r.Context(), p); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            webutil.RespondWithError(w, http.StatusNotFound, "找不到该广告位")
+        } else {
+            log.Printf("管理员 %d 修改广告位 %d 失败: %v", adminClaims.UserID, p.ID, err)
+            webutil.RespondWithError(w, http.StatusInternalServerError, "修改广告位失败")
+        }
+        return
+    }
+    log.Printf("管理员 %d 修改了广告位 %d (%s, %dx%d, %s)", adminClaims.UserID, p.ID, p.Name, p.Width, p.Height, p.Status)
+    h.audit(r, models.AuditPlacementUpdate, models.AuditTargetPlacement, p.ID, before, p)
+    h.Serving.Invalidate()
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Message: "广告位已更新", Data: p})
+}
+
+// --- 审计日志 ---
+// 后台审核、状态变更、余额变动、发票处理和登录在成功后追加一条审计记录 (audit_log 只能追加)。
+// 写入失败只记录日志，不影响已经完成的操作
+
+// audit 以当前登录用户为操作者记录审计日志；没有登录信息时 (支付回调) 记为系统操作
+func (h *Handler) audit(r *http.Request, action, targetType string, targetID interface{}, before, after interface{}) {
+    entry := &models.AuditEntry{}
+    if claims, ok := r.Context().Value(middleware.UserContextKey).(*auth.Claims); ok && claims != nil {
+        actorID := claims.UserID
+        entry.ActorID = &actorID
+        entry.ActorUsername = claims.Username
+        entry.ActorRole = claims.Role
+    }
+    h.appendAudit(r, entry, action, targetType, targetID, before, after)
+}
+
+// maxAuditUsernameLength 与 audit_log.actor_username 列的长度一致
+const maxAuditUsernameLength = 64
+
+// auditLogin 记录登录结果。登录时 context 中还没有登录信息，操作者取自登录的用户；
+// user 为 nil (用户名不存在) 时只记录尝试的用户名
+func (h *Handler) auditLogin(r *http.Request, action string, user *models.User, username string, after interface{}) {
+    // 尝试的用户名可能很长，截断到 audit_log.actor_username 的长度
+    if utf8.RuneCountInString(username) > maxAuditUsernameLength {
+        username = string([]rune(username)[:maxAuditUsernameLength])
+    }
+    entry := &models.AuditEntry{ActorUsername: username}
+    var targetID interface{} = ""
+    if user != nil {
+        actorID := user.ID
+        entry.ActorID = &actorID
+        entry.ActorUsername = user.Username
+        entry.ActorRole = user.Role
+        targetID = user.ID
+    }
+    h.appendAudit(r, entry, action, models.AuditTargetUser, targetID, nil, after)
+}
+
+func (h *Handler) appendAudit(r *http.Request, entry *models.AuditEntry, action, targetType string, targetID interface{}, before, after interface{}) {
+    entry.Action = action
+    entry.TargetType = targetType
+    entry.TargetID = fmt.Sprint(targetID)
+    entry.IP = middleware.ClientIPFromRequest(r)
+    entry.RequestID = middleware.RequestIDFromContext(r.Context())
+    var err error
+    if entry.Before, err = marshalAuditValue(before); err == nil {
+        entry.After, err = marshalAuditValue(after)
+    }
+    if err != nil {
+        log.Printf("序列化审计日志 %s (%s %s) 失败: %v", action, targetType, entry.TargetID, err)
+        return
+    }
+    // 客户端断开连接不应导致审计记录丢失
+    if err := h.Store.AppendAuditEntry(context.WithoutCancel(r.Context()), entry); err != nil {
+        log.Printf("写入审计日志 %s (%s %s) 失败: %v", action, targetType, entry.TargetID, err)
+    }
+}
+
+// marshalAuditValue 把变更前 / 后的值序列化为 JSON，nil 表示没有该值
+func marshalAuditValue(v interface{}) (json.RawMessage, error) {
+    if v == nil {
+        return nil, nil
+    }
+    return json.Marshal(v)
+}
+
+// parseAuditTime 解析审计日志查询的时间参数，支持 RFC 3339 时间和 YYYY-MM-DD 日期 (dateOnly 为 true)
+func parseAuditTime(s string) (t time.Time, dateOnly bool, err error) {
+    if t, err = time.Parse(time.RFC3339, s); err == nil {
+        return t, false, nil
+    }
+    t, err = time.Parse(DateFormat, s)
+    return t, true, err
+}
+
+// --- AdminListAuditHandler 按操作者、操作类型、对象、请求 ID 和时间范围分页查询审计日志 (新的在前) ---
+func (h *Handler) AdminListAuditHandler(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    page, pageSize := 1, 20
+    var filter models.AuditFilter
+    if pageStr := query.Get("page"); pageStr != "" {
+        p, err := strconv.Atoi(pageStr)
+        if err != nil || p <= 0 { webutil.RespondWithError(w, http.StatusBadRequest, "无效的页码"); return }
+        page = p
+    }
+    if sizeStr := query.Get("page_size"); sizeStr != "" {
+        size, err := strconv.Atoi(sizeStr)
+        if err != nil || size <= 0 || size > 100 { webutil.RespondWithError(w, http.StatusBadRequest, "page_size 应在 1 到 100 之间"); return }
+        pageSize = size
+    }
+    if actorStr := query.Get("actor_id"); actorStr != "" {
+        actorID, err := strconv.Atoi(actorStr)
+        if err != nil || actorID <= 0 { webutil.RespondWithError(w, http.StatusBadRequest, "无效的 actor_id"); return }
+        filter.ActorID = &actorID
+    }
+    for param, field := range map[string]**string{
+        "action":      &filter.Action,
+        "target_type": &filter.TargetType,
+        "target_id":   &filter.TargetID,
+        "request_id":  &filter.RequestID,
+    } {
+        if v := strings.TrimSpace(query.Get(param)); v != "" {
+            *field = &v
+        }
+    }
+    if filter.TargetID != nil && filter.TargetType == nil {
+        webutil.RespondWithError(w, http.StatusBadRequest, "按 target_id 查询时必须同时指定 target_type")
+        return
+    }
+    if fromStr := query.Get("from"); fromStr != "" {
+        from, _, err := parseAuditTime(fromStr)
+        if err != nil { webutil.RespondWithError(w, http.StatusBadRequest, "无效的 from，应为 YYYY-MM-DD 或 RFC 3339 时间"); return }
+        filter.Since = &from
+    }
+    if toStr := query.Get("to"); toStr != "" {
+        to, dateOnly, err := parseAuditTime(toStr)
+        if err != nil { webutil.RespondWithError(w, http.StatusBadRequest, "无效的 to，应为 YYYY-MM-DD 或 RFC 3339 时间"); return }
+        // 只给日期时包含当天的所有记录
+        if dateOnly {
+            to = to.AddDate(0, 0, 1)
+        }
+        filter.Until = &to
+    }
+    if filter.Since != nil && filter.Until != nil && !filter.Since.Before(*filter.Until) {
+        webutil.RespondWithError(w, http.StatusBadRequest, "from 必须早于 to")
+        return
+    }
+
+    // 多取一条判断是否还有下一页
+    filter.Offset = (page - 1) * pageSize
+    filter.Limit = pageSize + 1
+    entries, err := h.Store.ListAuditEntries(r.Context(), filter)
+    if err != nil {
+        log.Printf("查询审计日志失败: %v", err)
+        webutil.RespondWithError(w, http.StatusInternalServerError, "获取审计日志失败")
+        return
+    }
+    hasMore := len(entries) > pageSize
+    if hasMore {
+        entries = entries[:pageSize]
+    }
+    webutil.RespondWithJSON(w, http.StatusOK, webutil.Response{Data: models.AuditPage{
+        Entries:  entries,
+        Page:     page,
+        PageSize: pageSize,
+        HasMore:  hasMore,
+    }})
+}
@@ -0,0 +1,1168 @@
+package handlers_test
+
+// 通过 httptest 调用完整的 HTTP 接口 (路由、认证中间件、Handler)，每个用例分别在 MemStore 和
+// 迁移到最新版本的 SQLite 上运行，两种 Store 实现对外的行为必须一致。
+//
+// 运行: go test ./internal/handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/config"
+	"advertisement/internal/frequency"
+	"advertisement/internal/handlers"
+	"advertisement/internal/middleware"
+	"advertisement/internal/migrate"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+	"advertisement/internal/payment"
+	"advertisement/internal/serving"
+	"advertisement/internal/store"
+	"advertisement/internal/targeting"
+	"advertisement/internal/throttle"
+)
+
+// testWebhookSecret mock 支付渠道的回调签名密钥
+const testWebhookSecret = "test_webhook_secret"
+
+func TestMain(m *testing.M) {
+	auth.Configure(config.JWTConfig{Secret: "test_jwt_secret"})
+	log.SetOutput(io.Discard) // Handler 和 Store 每个请求都会写日志
+	os.Exit(m.Run())
+}
+
+// newSQLiteDB 创建一个迁移到最新版本的空 SQLite 数据库
+func newSQLiteDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	db, err := store.OpenSQLite(filepath.Join(tb.TempDir(), "handlers.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db, "sqlite")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := migrator.Up(tb.Context()); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// stores 每个用例都在这些 Store 实现上运行
+var stores = []struct {
+	name string
+	open func(tb testing.TB) store.Store
+}{
+	{"MemStore", func(tb testing.TB) store.Store { return store.NewMemStore() }},
+	{"SQLite", func(tb testing.TB) store.Store { return store.NewSQLiteStore(newSQLiteDB(tb)) }},
+}
+
+func forEachStore(t *testing.T, fn func(t *testing.T, api *testAPI)) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			fn(t, newTestAPI(t, s.open(t)))
+		})
+	}
+}
+
+// testAPI 在 httptest.Server 上运行的接口，路由和中间件与 main.go 一致 (只包含测试用到的接口)
+type testAPI struct {
+	t      *testing.T
+	store  store.Store
+	index  *serving.Index
+	server *httptest.Server
+	client *http.Client
+}
+
+func newTestAPI(t *testing.T, s store.Store) *testAPI {
+	t.Helper()
+	ix := serving.NewIndex(s, serving.Options{RefreshInterval: time.Minute})
+	if err := ix.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	capper := frequency.New(frequency.NewMemoryStore(time.Hour, 0), config.Default().Frequency.MaxWindow.Duration, frequency.CookieOptions{Name: "adv_vid"})
+	h := handlers.NewHandler(s, payment.NewMockProvider(testWebhookSecret, time.Minute), config.Default().Mail, config.Default().TwoFactor,
+		throttle.New(s, config.Default().LoginThrottle), ix, targeting.New(nil, time.UTC), capper,
+		serving.NewClickSigner("test_click_secret", time.Hour), nil)
+	authHandler := middleware.AuthMiddleware(s)
+	requirePermission := func(perm string, next http.HandlerFunc) http.Handler {
+		return authHandler(middleware.RequirePermission(perm)(next))
+	}
+	enrollmentHandler := middleware.EnrollmentAuthMiddleware(s)
+	organization := middleware.OrganizationMiddleware(s)
+	orgHandler := func(next http.HandlerFunc) http.Handler { return authHandler(organization(next)) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register", h.RegisterHandler)
+	mux.HandleFunc("POST /login", h.LoginHandler)
+	mux.HandleFunc("POST /login/2fa", h.TwoFactorLoginHandler)
+	mux.HandleFunc("POST /token/refresh", h.RefreshTokenHandler)
+	mux.HandleFunc("POST /email/verify", h.VerifyEmailHandler)
+	mux.HandleFunc("POST /email/verify/resend", h.ResendVerificationHandler)
+	mux.HandleFunc("POST /password/forgot", h.ForgotPasswordHandler)
+	mux.HandleFunc("POST /password/reset", h.ResetPasswordHandler)
+	mux.Handle("POST /logout", authHandler(http.HandlerFunc(h.LogoutHandler)))
+	mux.Handle("POST /logout/all", authHandler(http.HandlerFunc(h.LogoutAllHandler)))
+	mux.Handle("GET /2fa", enrollmentHandler(http.HandlerFunc(h.GetTwoFactorStatusHandler)))
+	mux.Handle("POST /2fa/totp/setup", enrollmentHandler(http.HandlerFunc(h.SetupTOTPHandler)))
+	mux.Handle("POST /2fa/totp/enable", enrollmentHandler(http.HandlerFunc(h.EnableTOTPHandler)))
+	mux.Handle("POST /api-keys", authHandler(http.HandlerFunc(h.CreateAPIKeyHandler)))
+	mux.Handle("GET /api-keys", authHandler(http.HandlerFunc(h.ListAPIKeysHandler)))
+	mux.Handle("DELETE /api-keys/{id}", authHandler(http.HandlerFunc(h.RevokeAPIKeyHandler)))
+	mux.Handle("GET /admin/ads/pending", requirePermission(auth.PermAdsReview, h.AdminGetPendingAdsHandler))
+	mux.Handle("GET /admin/ledger/reconcile", requirePermission(auth.PermLedgerRead, h.AdminReconcileBalancesHandler))
+	mux.Handle("GET /admin/roles", requirePermission(auth.PermUsersManage, h.AdminListRolesHandler))
+	mux.Handle("POST /admin/users/{id}/unlock", requirePermission(auth.PermUsersManage, h.AdminUnlockUserHandler))
+	mux.Handle("PUT /admin/users/{id}/role", requirePermission(auth.PermUsersManage, h.AdminAssignRoleHandler))
+	mux.HandleFunc("GET /get-ad", h.GetAdHandler)
+	mux.HandleFunc("GET /ads/click/{campaign_id}/{advertisement_id}", h.AdClickHandler)
+	mux.HandleFunc("POST /payments/webhook", h.PaymentWebhookHandler)
+	mux.Handle("POST /recharge", orgHandler(h.RechargeHandler))
+	mux.Handle("GET /recharges", orgHandler(h.GetRechargeHistoryHandler))
+	mux.Handle("GET /recharges/{id}", orgHandler(h.GetRechargeDetailsHandler))
+	mux.Handle("POST /ads", orgHandler(h.SubmitAdHandler))
+	mux.Handle("POST /campaigns", orgHandler(h.RequestCampaignHandler))
+	mux.Handle("GET /my-campaigns/{id}", orgHandler(h.GetUserCampaignDetailsHandler))
+	mux.Handle("GET /my-performance", orgHandler(h.GetAdPerformanceHandler))
+
+	srv := httptest.NewServer(middleware.ClientIP(false)(mux))
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &testAPI{t: t, store: s, index: ix, server: srv, client: client}
+}
+
+// with 返回在子测试 t 中使用的副本
+func (a *testAPI) with(t *testing.T) *testAPI {
+	c := *a
+	c.t = t
+	return &c
+}
+
+// response 接口的 JSON 响应 (webutil.Response)，Data 留给调用方解析
+type response struct {
+	status  int
+	header  http.Header
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// decode 把 Data 解析到 v
+func (r *response) decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		t.Fatalf("decode %s: %v", r.Data, err)
+	}
+}
+
+// do 发送请求，token 不为空时带上 Authorization 头；body 为 []byte 时原样发送，否则编码为 JSON
+func (a *testAPI) do(method, path, token string, body any, header ...string) *response {
+	a.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		payload, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, a.server.URL+path, reader)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	r := &response{status: resp.StatusCode, header: resp.Header}
+	if len(raw) > 0 && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(raw, r); err != nil {
+			a.t.Fatalf("%s %s: invalid JSON %q: %v", method, path, raw, err)
+		}
+	}
+	return r
+}
+
+// expect 检查状态码
+func (a *testAPI) expect(r *response, status int) *response {
+	a.t.Helper()
+	if r.status != status {
+		a.t.Fatalf("status = %d, want %d (error %q, message %q)", r.status, status, r.Error, r.Message)
+	}
+	return r
+}
+
+var mailTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// mailToken 从发件箱领取待发送的邮件，返回最近一封发给 recipient 的邮件中的令牌
+func (a *testAPI) mailToken(recipient string) string {
+	a.t.Helper()
+	messages, err := a.store.ClaimOutboxMessages(a.t.Context(), time.Now(), time.Hour, 100)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	token := ""
+	for _, m := range messages {
+		if match := mailTokenPattern.FindStringSubmatch(m.Body); m.Recipient == recipient && match != nil {
+			token = match[1]
+		}
+	}
+	if token == "" {
+		a.t.Fatalf("no email with a token for %s", recipient)
+	}
+	return token
+}
+
+// signUp 注册并登录，返回访问令牌和用户 ID
+func (a *testAPI) signUp(username string) (token string, userID int) {
+	a.t.Helper()
+	a.expect(a.do("POST", "/register", "", map[string]string{
+		"username": username, "password": "pw123456", "email": username + "@example.com",
+	}), http.StatusCreated)
+	a.expect(a.do("POST", "/email/verify", "", map[string]string{"token": a.mailToken(username + "@example.com")}), http.StatusOK)
+	tokens, userID := a.login(username)
+	return tokens.Token, userID
+}
+
+// login 用 signUp 的密码登录，开始一个新的会话
+func (a *testAPI) login(username string) (tokens models.TokenResponse, userID int) {
+	a.t.Helper()
+	r := a.expect(a.do("POST", "/login", "", map[string]string{"username": username, "password": "pw123456"}), http.StatusOK)
+	var login struct {
+		models.TokenResponse
+		ID int `json:"id"`
+	}
+	r.decode(a.t, &login)
+	return login.TokenResponse, login.ID
+}
+
+// balance 返回用户个人组织 (注册时创建的默认组织) 的余额
+func (a *testAPI) balance(userID int) (int64, error) {
+	user, err := a.store.GetUserByID(a.t.Context(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return a.store.GetOrganizationBalance(a.t.Context(), *user.DefaultOrganizationID)
+}
+
+// enrollTwoFactor 绑定验证器 (验证码取 at 时刻)，返回启用后签发的新令牌、TOTP 密钥和恢复码
+func (a *testAPI) enrollTwoFactor(token string, at time.Time) (tokens models.TokenResponse, secret string, recoveryCodes []string) {
+	a.t.Helper()
+	r := a.expect(a.do("POST", "/2fa/totp/setup", token, nil), http.StatusOK)
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	r.decode(a.t, &setup)
+	r = a.expect(a.do("POST", "/2fa/totp/enable", token, map[string]string{"code": a.totpCode(setup.Secret, at)}), http.StatusOK)
+	var enabled struct {
+		models.TokenResponse
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	r.decode(a.t, &enabled)
+	return enabled.TokenResponse, setup.Secret, enabled.RecoveryCodes
+}
+
+// totpCode 返回 at 时刻的验证码
+func (a *testAPI) totpCode(secret string, at time.Time) string {
+	a.t.Helper()
+	code, err := auth.TOTPCode(secret, at)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return code
+}
+
+// mfaChallenge 用密码登录已启用两步验证的账号，返回登录挑战 mfa_token
+func (a *testAPI) mfaChallenge(username string) string {
+	a.t.Helper()
+	r := a.expect(a.do("POST", "/login", "", map[string]string{"username": username, "password": "pw123456"}), http.StatusOK)
+	var challenge struct {
+		Token       string `json:"token"`
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+	r.decode(a.t, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" || challenge.Token != "" {
+		a.t.Fatalf("login of %s returned %+v, want only an mfa challenge", username, challenge)
+	}
+	return challenge.MFAToken
+}
+
+// loginWithCode 完成两步登录，code 为验证码或恢复码
+func (a *testAPI) loginWithCode(username, code string) models.TokenResponse {
+	a.t.Helper()
+	r := a.expect(a.do("POST", "/login/2fa", "", map[string]string{"mfa_token": a.mfaChallenge(username), "code": code}), http.StatusOK)
+	var tokens models.TokenResponse
+	r.decode(a.t, &tokens)
+	return tokens
+}
+
+// refresh 用刷新令牌换取新的令牌对，期望状态码为 status
+func (a *testAPI) refresh(refreshToken string, status int) models.TokenResponse {
+	a.t.Helper()
+	r := a.expect(a.do("POST", "/token/refresh", "", map[string]string{"refresh_token": refreshToken}), status)
+	var tokens models.TokenResponse
+	if status == http.StatusOK {
+		r.decode(a.t, &tokens)
+	}
+	return tokens
+}
+
+// webhookEvent 返回充值记录 rechargeID 的 mock 渠道回调事件
+func webhookEvent(rechargeID int64, amount int64, status payment.Status) payment.Event {
+	return payment.Event{
+		ID: fmt.Sprintf("evt_%d", rechargeID), ProviderRef: payment.MockProviderRef(rechargeID), RechargeID: rechargeID,
+		Amount: amount, Status: status,
+	}
+}
+
+// webhook 用 secret 签名 event 并发送到 POST /payments/webhook
+func (a *testAPI) webhook(event payment.Event, secret string, at time.Time) *response {
+	a.t.Helper()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return a.do("POST", "/payments/webhook", "", payload, payment.SignatureHeader, payment.SignPayload([]byte(secret), at, payload))
+}
+
+// recharge 发起充值 (如 "10.50")，status 不为空时再像支付渠道那样发送签名回调，返回充值记录 ID
+func (a *testAPI) recharge(token string, amount string, status payment.Status) int64 {
+	a.t.Helper()
+	r := a.expect(a.do("POST", "/recharge", token, map[string]string{"amount": amount}), http.StatusAccepted)
+	var intent models.RechargeIntentResponse
+	r.decode(a.t, &intent)
+	if status != "" {
+		event := webhookEvent(intent.RechargeID, intent.Amount.Minor, status)
+		a.expect(a.webhook(event, testWebhookSecret, time.Now()), http.StatusOK)
+	}
+	return intent.RechargeID
+}
+
+// activeCampaign 提交创意和活动，并像审核员和调度器那样把它们置为 Approved / Active，返回活动和创意 ID
+func (a *testAPI) activeCampaign(token string, userID int, pricing string, bid string) (campaignID, adID int) {
+	a.t.Helper()
+	ctx := a.t.Context()
+	r := a.expect(a.do("POST", "/ads", token, map[string]any{
+		"title": "ad", "image_url": "https://example.com/ad.png", "target_url": "https://example.com/landing", "width": 300, "height": 250,
+	}), http.StatusCreated)
+	var ad struct {
+		ID int `json:"new_ad_id"`
+	}
+	r.decode(a.t, &ad)
+	if err := a.store.UpdateAdvertisementStatus(ctx, ad.ID, "Approved"); err != nil {
+		a.t.Fatal(err)
+	}
+
+	today := time.Now().Format(handlers.DateFormat)
+	r = a.expect(a.do("POST", "/campaigns", token, map[string]any{
+		"advertisement_id": ad.ID, "start_date": today, "end_date": time.Now().AddDate(0, 1, 0).Format(handlers.DateFormat),
+		"pricing_model": pricing, "bid_amount": bid, "total_budget": "10.00",
+	}), http.StatusCreated)
+	var campaign struct {
+		ID int `json:"campaign_id"`
+	}
+	r.decode(a.t, &campaign)
+	for _, status := range []string{models.CampaignStatusApproved, models.CampaignStatusActive} {
+		if err := a.store.UpdateAdCampaignStatus(ctx, campaign.ID, status, userID); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	if err := a.index.Refresh(ctx); err != nil {
+		a.t.Fatal(err)
+	}
+	return campaign.ID, ad.ID
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, _ := api.signUp("alice")
+		if token == "" {
+			t.Fatal("empty token")
+		}
+
+		tests := []struct {
+			name   string
+			path   string
+			body   map[string]string
+			status int
+		}{
+			// ErrDuplicateUser
+			{"duplicate username", "/register", map[string]string{"username": "alice", "password": "pw123456", "email": "other@example.com"}, http.StatusConflict},
+			// 邮箱统一为小写后比较
+			{"duplicate email", "/register", map[string]string{"username": "bob", "password": "pw123456", "email": "Alice@Example.com"}, http.StatusConflict},
+			{"missing password", "/register", map[string]string{"username": "bob", "email": "bob@example.com"}, http.StatusBadRequest},
+			{"missing email", "/register", map[string]string{"username": "bob", "password": "pw123456"}, http.StatusBadRequest},
+			{"invalid email", "/register", map[string]string{"username": "bob", "password": "pw123456", "email": "bob"}, http.StatusBadRequest},
+			// 不存在的用户名 (ErrNotFound) 与密码错误的响应相同
+			{"unknown user", "/login", map[string]string{"username": "nobody", "password": "pw123456"}, http.StatusUnauthorized},
+			{"wrong password", "/login", map[string]string{"username": "alice", "password": "wrong-password"}, http.StatusUnauthorized},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				api := api.with(t)
+				api.expect(api.do("POST", tt.path, "", tt.body), tt.status)
+			})
+		}
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		credentials := map[string]string{"username": "carol", "password": "pw123456"}
+		api.expect(api.do("POST", "/register", "", map[string]string{
+			"username": "carol", "password": "pw123456", "email": "carol@example.com",
+		}), http.StatusCreated)
+		first := api.mailToken("carol@example.com")
+		api.expect(api.do("POST", "/login", "", credentials), http.StatusForbidden)
+
+		// 重新发送会作废之前的令牌；不存在的邮箱同样返回 202
+		api.expect(api.do("POST", "/email/verify/resend", "", map[string]string{"email": "carol@example.com"}), http.StatusAccepted)
+		api.expect(api.do("POST", "/email/verify/resend", "", map[string]string{"email": "nobody@example.com"}), http.StatusAccepted)
+		second := api.mailToken("carol@example.com")
+		api.expect(api.do("POST", "/email/verify", "", map[string]string{"token": first}), http.StatusBadRequest)
+		api.expect(api.do("POST", "/email/verify", "", map[string]string{"token": second}), http.StatusOK)
+		api.expect(api.do("POST", "/email/verify", "", map[string]string{"token": second}), http.StatusBadRequest)
+		api.expect(api.do("POST", "/login", "", credentials), http.StatusOK)
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		api.signUp("dave")
+		tokens, _ := api.login("dave")
+		api.expect(api.do("POST", "/password/forgot", "", map[string]string{"email": "dave@example.com"}), http.StatusAccepted)
+		api.expect(api.do("POST", "/password/forgot", "", map[string]string{"email": "nobody@example.com"}), http.StatusAccepted)
+		api.expect(api.do("POST", "/password/forgot", "", map[string]string{"email": "not-an-email"}), http.StatusBadRequest)
+		reset := api.mailToken("dave@example.com")
+
+		api.expect(api.do("POST", "/password/reset", "", map[string]string{"token": reset}), http.StatusBadRequest)
+		api.expect(api.do("POST", "/password/reset", "", map[string]string{"token": "unknown", "password": "new-pw-123"}), http.StatusBadRequest)
+		api.expect(api.do("POST", "/password/reset", "", map[string]string{"token": reset, "password": "new-pw-123"}), http.StatusOK)
+		api.expect(api.do("POST", "/password/reset", "", map[string]string{"token": reset, "password": "other-pw-123"}), http.StatusBadRequest)
+
+		// 旧密码不能再登录，重置前的会话全部吊销
+		api.expect(api.do("POST", "/login", "", map[string]string{"username": "dave", "password": "pw123456"}), http.StatusUnauthorized)
+		api.expect(api.do("POST", "/login", "", map[string]string{"username": "dave", "password": "new-pw-123"}), http.StatusOK)
+		api.refresh(tokens.RefreshToken, http.StatusUnauthorized)
+	})
+}
+
+func TestNotFound(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		alice, _ := api.signUp("alice")
+		bob, bobID := api.signUp("bob")
+		bobCampaign, _ := api.activeCampaign(bob, bobID, models.PricingCPM, "1.00")
+		bobRecharge := api.recharge(bob, "10.00", "")
+
+		tests := []struct {
+			name string
+			path string
+		}{
+			{"missing recharge", "/recharges/999999"},
+			{"recharge of another user", fmt.Sprintf("/recharges/%d", bobRecharge)},
+			{"missing campaign", "/my-campaigns/999999"},
+			{"campaign of another user", fmt.Sprintf("/my-campaigns/%d", bobCampaign)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				api := api.with(t)
+				api.expect(api.do("GET", tt.path, alice, nil), http.StatusNotFound)
+			})
+		}
+	})
+}
+
+func TestPlacement(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		ctx := t.Context()
+		token, userID := api.signUp("alice")
+		api.recharge(token, "50.00", payment.StatusSucceeded)
+		campaignID, adID := api.activeCampaign(token, userID, models.PricingCPM, "2.00") // 300x250 image
+
+		placement := func(width, height int, floor int64, status string) int {
+			t.Helper()
+			p := &models.Placement{Name: fmt.Sprintf("%dx%d", width, height), Width: width, Height: height,
+				Formats: []string{"image"}, FloorPrice: money.New(floor), Status: status}
+			if err := api.store.CreatePlacement(ctx, p); err != nil {
+				t.Fatal(err)
+			}
+			return p.ID
+		}
+		fits := placement(300, 250, 100, models.PlacementStatusActive)
+		highFloor := placement(300, 250, 500, models.PlacementStatusActive)
+		otherSize := placement(728, 90, 0, models.PlacementStatusActive)
+		disabled := placement(300, 250, 0, models.PlacementStatusDisabled)
+		if err := api.index.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		type adResponse struct {
+			CampaignID  int    `json:"campaign_id"`
+			PlacementID *int   `json:"placement_id"`
+			ClickURL    string `json:"click_url"`
+		}
+		tests := []struct {
+			name   string
+			query  string
+			status int
+			wantAd bool
+		}{
+			{"no placement", "", http.StatusOK, true},
+			{"matching placement", fmt.Sprintf("?placement=%d", fits), http.StatusOK, true},
+			{"bid below floor", fmt.Sprintf("?placement=%d", highFloor), http.StatusOK, false},
+			{"other size", fmt.Sprintf("?placement=%d", otherSize), http.StatusOK, false},
+			{"disabled placement", fmt.Sprintf("?placement=%d", disabled), http.StatusOK, false},
+			{"missing placement", "?placement=999999", http.StatusNotFound, false},
+			{"invalid placement", "?placement=abc", http.StatusBadRequest, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				api := api.with(t)
+				r := api.expect(api.do("GET", "/get-ad"+tt.query, "", nil), tt.status)
+				if tt.status != http.StatusOK {
+					return
+				}
+				if !tt.wantAd {
+					if r.Message != "没有可用的广告" {
+						t.Fatalf("get-ad returned %s, message %q, want no ad", r.Data, r.Message)
+					}
+					return
+				}
+				var ad adResponse
+				r.decode(t, &ad)
+				if ad.CampaignID != campaignID {
+					t.Fatalf("get-ad returned campaign %d, want %d", ad.CampaignID, campaignID)
+				}
+			})
+		}
+
+		// 点击链接带上广告位，凭证绑定广告位：去掉或换成其他广告位都是无效的点击
+		r := api.expect(api.do("GET", fmt.Sprintf("/get-ad?placement=%d", fits), "", nil), http.StatusOK)
+		var ad adResponse
+		r.decode(t, &ad)
+		if ad.PlacementID == nil || *ad.PlacementID != fits || !strings.Contains(ad.ClickURL, fmt.Sprintf("placement=%d", fits)) {
+			t.Fatalf("get-ad returned placement %v, click_url %q", ad.PlacementID, ad.ClickURL)
+		}
+		clickToken := ad.ClickURL[strings.Index(ad.ClickURL, "token="):]
+		api.expect(api.do("GET", fmt.Sprintf("/ads/click/%d/%d?%s", campaignID, adID, clickToken), "", nil), http.StatusBadRequest)
+		api.expect(api.do("GET", fmt.Sprintf("/ads/click/%d/%d?placement=%d&%s", campaignID, adID, highFloor, clickToken), "", nil), http.StatusBadRequest)
+		api.expect(api.do("GET", ad.ClickURL, "", nil), http.StatusFound)
+	})
+}
+
+func TestPerformanceSummary(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, userID := api.signUp("alice")
+		api.recharge(token, "50.00", payment.StatusSucceeded)
+		campaignID, adID := api.activeCampaign(token, userID, models.PricingCPC, "0.50")
+
+		// 展示：签发点击凭证 (绑定访问者)；点击：按凭证计费一次，重复点击或其他访问者的点击只跳转
+		r := api.expect(api.do("GET", "/get-ad?device_id=dev1", "", nil), http.StatusOK)
+		var ad struct {
+			CampaignID      int    `json:"campaign_id"`
+			AdvertisementID int    `json:"advertisement_id"`
+			ClickURL        string `json:"click_url"`
+		}
+		r.decode(t, &ad)
+		if ad.CampaignID != campaignID || ad.AdvertisementID != adID || ad.ClickURL == "" {
+			t.Fatalf("get-ad returned campaign %d, ad %d, click_url %q", ad.CampaignID, ad.AdvertisementID, ad.ClickURL)
+		}
+		api.expect(api.do("GET", ad.ClickURL+"&device_id=dev2", "", nil), http.StatusFound)
+		for i := 0; i < 2; i++ {
+			r = api.expect(api.do("GET", ad.ClickURL+"&device_id=dev1", "", nil), http.StatusFound)
+			if loc := r.header.Get("Location"); loc != "https://example.com/landing" {
+				t.Fatalf("Location = %q", loc)
+			}
+		}
+		api.expect(api.do("GET", fmt.Sprintf("/ads/click/%d/%d", campaignID, adID), "", nil), http.StatusBadRequest)
+		if balance, err := api.balance(userID); err != nil || balance != 4950 {
+			t.Fatalf("balance = %d, %v, want 4950 (one click charged)", balance, err)
+		}
+
+		today := time.Now().Format(handlers.DateFormat)
+		tests := []struct {
+			name   string
+			query  string
+			status int
+			want   []models.AdPerformanceSummary
+		}{
+			{name: "default range", status: http.StatusOK, want: []models.AdPerformanceSummary{
+				{CampaignID: campaignID, AdvertisementID: adID, Impressions: 1, Clicks: 1, CTR: 100},
+			}},
+			{name: "today", query: "start_date=" + today + "&end_date=" + today, status: http.StatusOK, want: []models.AdPerformanceSummary{
+				{CampaignID: campaignID, AdvertisementID: adID, Impressions: 1, Clicks: 1, CTR: 100},
+			}},
+			{name: "campaign", query: fmt.Sprintf("campaign_id=%d", campaignID), status: http.StatusOK, want: []models.AdPerformanceSummary{
+				{CampaignID: campaignID, AdvertisementID: adID, Impressions: 1, Clicks: 1, CTR: 100},
+			}},
+			{name: "other campaign", query: fmt.Sprintf("campaign_id=%d", campaignID+1000), status: http.StatusOK},
+			{name: "future", query: "start_date=" + time.Now().AddDate(0, 0, 2).Format(handlers.DateFormat), status: http.StatusOK},
+			{name: "invalid campaign", query: "campaign_id=abc", status: http.StatusBadRequest},
+			{name: "end before start", query: "start_date=2024-02-01&end_date=2024-01-01", status: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				api := api.with(t)
+				r := api.expect(api.do("GET", "/my-performance?"+tt.query, token, nil), tt.status)
+				if tt.status != http.StatusOK {
+					return
+				}
+				var got []models.AdPerformanceSummary
+				if len(r.Data) > 0 && string(r.Data) != "null" {
+					r.decode(t, &got)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("got %d rows, want %d: %+v", len(got), len(tt.want), got)
+				}
+				for i, w := range tt.want {
+					g := got[i]
+					if g.CampaignID != w.CampaignID || g.AdvertisementID != w.AdvertisementID ||
+						g.Impressions != w.Impressions || g.Clicks != w.Clicks || g.CTR != w.CTR {
+						t.Errorf("row %d = %+v, want %+v", i, g, w)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestRechargeHistoryFilters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, _ := api.signUp("alice")
+		api.recharge(token, "50.00", payment.StatusSucceeded)
+		api.recharge(token, "120.00", payment.StatusFailed)
+		api.recharge(token, "300.00", "")
+		// 其他用户的充值不出现在结果中
+		other, _ := api.signUp("bob")
+		api.recharge(other, "80.00", payment.StatusSucceeded)
+
+		today := time.Now().UTC()
+		tests := []struct {
+			query  string
+			status int
+			want   []int64 // 金额 (分)，从小到大
+		}{
+			{query: "", status: http.StatusOK, want: []int64{5000, 12000, 30000}},
+			{query: "status=Success", status: http.StatusOK, want: []int64{5000}},
+			{query: "status=failed", status: http.StatusOK, want: []int64{12000}},
+			{query: "status=Pending", status: http.StatusOK, want: []int64{30000}},
+			{query: "min_amount=100", status: http.StatusOK, want: []int64{12000, 30000}},
+			{query: "max_amount=100", status: http.StatusOK, want: []int64{5000}},
+			{query: "min_amount=100&max_amount=200", status: http.StatusOK, want: []int64{12000}},
+			{query: "min_amount=50&max_amount=50", status: http.StatusOK, want: []int64{5000}},
+			{query: "status=Success&min_amount=100", status: http.StatusOK, want: []int64{}},
+			{query: "start_date=" + today.Format(handlers.DateFormat), status: http.StatusOK, want: []int64{5000, 12000, 30000}},
+			{query: "start_date=" + today.AddDate(0, 0, 1).Format(handlers.DateFormat), status: http.StatusOK, want: []int64{}},
+			{query: "end_date=" + today.AddDate(0, 0, -1).Format(handlers.DateFormat), status: http.StatusOK, want: []int64{}},
+			{query: "status=unknown", status: http.StatusBadRequest},
+			{query: "min_amount=200&max_amount=100", status: http.StatusBadRequest},
+			{query: "start_date=2024-13-01", status: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.query, func(t *testing.T) {
+				api := api.with(t)
+				r := api.expect(api.do("GET", "/recharges?"+tt.query, token, nil), tt.status)
+				if tt.status != http.StatusOK {
+					return
+				}
+				var history []models.RechargeTransaction
+				if len(r.Data) > 0 && string(r.Data) != "null" {
+					r.decode(t, &history)
+				}
+				got := []int64{}
+				for _, rt := range history {
+					got = append(got, rt.Amount.Minor)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("amounts = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, userID := api.signUp("alice")
+		pending := api.recharge(token, "10.00", "")
+		failed := api.recharge(token, "20.00", payment.StatusFailed)
+
+		tests := []struct {
+			name   string
+			event  payment.Event
+			secret string
+			at     time.Time
+			status int
+		}{
+			{"wrong secret", webhookEvent(pending, 1000, payment.StatusSucceeded), "other_secret", time.Now(), http.StatusUnauthorized},
+			{"stale timestamp", webhookEvent(pending, 1000, payment.StatusSucceeded), testWebhookSecret, time.Now().Add(-2 * time.Minute), http.StatusUnauthorized},
+			{"future timestamp", webhookEvent(pending, 1000, payment.StatusSucceeded), testWebhookSecret, time.Now().Add(2 * time.Minute), http.StatusUnauthorized},
+			{"unknown recharge", webhookEvent(999999, 1000, payment.StatusSucceeded), testWebhookSecret, time.Now(), http.StatusNotFound},
+			{"amount mismatch", webhookEvent(pending, 999, payment.StatusSucceeded), testWebhookSecret, time.Now(), http.StatusBadRequest},
+			{"unsupported status", webhookEvent(pending, 1000, payment.StatusPending), testWebhookSecret, time.Now(), http.StatusBadRequest},
+			// 已经失败的充值不会因为之后的回调入账
+			{"already failed", webhookEvent(failed, 2000, payment.StatusSucceeded), testWebhookSecret, time.Now(), http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				api := api.with(t)
+				api.expect(api.webhook(tt.event, tt.secret, tt.at), tt.status)
+			})
+		}
+
+		// 篡改签名后的内容
+		payload, _ := json.Marshal(webhookEvent(pending, 1000, payment.StatusSucceeded))
+		signature := payment.SignPayload([]byte(testWebhookSecret), time.Now(), payload)
+		tampered := bytes.Replace(payload, []byte(`"amount":1000`), []byte(`"amount":100000`), 1)
+		api.expect(api.do("POST", "/payments/webhook", "", tampered, payment.SignatureHeader, signature), http.StatusUnauthorized)
+		api.expect(api.do("POST", "/payments/webhook", "", payload), http.StatusUnauthorized)
+
+		balance := func() int64 {
+			t.Helper()
+			b, err := api.balance(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		if b := balance(); b != 0 {
+			t.Fatalf("balance = %d before any successful webhook, want 0", b)
+		}
+
+		// 支付渠道重复发送同一个事件 (同一个事件 ID) 只入账一次
+		event := webhookEvent(pending, 1000, payment.StatusSucceeded)
+		for i := 0; i < 3; i++ {
+			api.expect(api.webhook(event, testWebhookSecret, time.Now()), http.StatusOK)
+		}
+		if b := balance(); b != 1000 {
+			t.Fatalf("balance = %d after repeated webhooks, want 1000", b)
+		}
+		var details struct {
+			Status string `json:"status"`
+		}
+		api.expect(api.do("GET", fmt.Sprintf("/recharges/%d", pending), token, nil), http.StatusOK).decode(t, &details)
+		if details.Status != "Success" {
+			t.Errorf("recharge status = %q, want Success", details.Status)
+		}
+	})
+}
+
+func TestRechargeAmount(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, _ := api.signUp("alice")
+		tests := []struct {
+			body   string
+			status int
+			want   string // 响应中的金额
+		}{
+			{`{"amount": "10.50"}`, http.StatusAccepted, "10.50"},
+			{`{"amount": 0.1}`, http.StatusAccepted, "0.10"},
+			{`{"amount": {"amount": "3", "currency": "CNY"}}`, http.StatusAccepted, "3.00"},
+			{`{"amount": "1000000.00"}`, http.StatusAccepted, "1000000.00"},
+			{`{"amount": "10.505"}`, http.StatusBadRequest, ""},
+			{`{"amount": "5."}`, http.StatusBadRequest, ""},
+			{`{"amount": "0"}`, http.StatusBadRequest, ""},
+			{`{"amount": "-10"}`, http.StatusBadRequest, ""},
+			{`{"amount": "1000000.01"}`, http.StatusBadRequest, ""},
+			{`{"amount": "99999999999999999999"}`, http.StatusBadRequest, ""},
+			{`{"amount": {"amount": "3", "currency": "USD"}}`, http.StatusBadRequest, ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.body, func(t *testing.T) {
+				api := api.with(t)
+				r := api.expect(api.do("POST", "/recharge", token, []byte(tt.body)), tt.status)
+				if tt.status != http.StatusAccepted {
+					return
+				}
+				var intent models.RechargeIntentResponse
+				r.decode(t, &intent)
+				if intent.Amount.String() != tt.want {
+					t.Errorf("amount = %s, want %s", intent.Amount, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestRefreshTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		api.signUp("alice")
+		// authorized 检查访问令牌能否访问需要认证的接口
+		authorized := func(token string, want bool) {
+			t.Helper()
+			status := http.StatusUnauthorized
+			if want {
+				status = http.StatusOK
+			}
+			api.expect(api.do("GET", "/recharges", token, nil), status)
+		}
+
+		t.Run("rotation and reuse detection", func(t *testing.T) {
+			api := api.with(t)
+			first, _ := api.login("alice")
+			other, _ := api.login("alice")
+			second := api.refresh(first.RefreshToken, http.StatusOK)
+			if second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+				t.Fatal("refresh returned the same tokens")
+			}
+			third := api.refresh(second.RefreshToken, http.StatusOK)
+			authorized(third.Token, true)
+
+			// 已轮换的刷新令牌被再次使用：整个会话被吊销，包括会话中仍然有效的访问令牌
+			api.refresh(first.RefreshToken, http.StatusUnauthorized)
+			api.refresh(third.RefreshToken, http.StatusUnauthorized)
+			authorized(first.Token, false)
+			authorized(second.Token, false)
+			authorized(third.Token, false)
+
+			// 同一用户的其他会话不受影响
+			authorized(other.Token, true)
+			api.refresh(other.RefreshToken, http.StatusOK)
+		})
+
+		t.Run("logout", func(t *testing.T) {
+			api := api.with(t)
+			session, _ := api.login("alice")
+			other, _ := api.login("alice")
+			api.expect(api.do("POST", "/logout", session.Token, nil), http.StatusOK)
+			authorized(session.Token, false)
+			api.refresh(session.RefreshToken, http.StatusUnauthorized)
+			authorized(other.Token, true)
+		})
+
+		t.Run("logout all", func(t *testing.T) {
+			api := api.with(t)
+			first, _ := api.login("alice")
+			second, _ := api.login("alice")
+			rotated := api.refresh(second.RefreshToken, http.StatusOK)
+			bob, _ := api.signUp("bob")
+
+			var result struct {
+				RevokedSessions int `json:"revoked_sessions"`
+			}
+			api.expect(api.do("POST", "/logout/all", first.Token, nil), http.StatusOK).decode(t, &result)
+			// 之前子测试中仍然有效的会话也会被吊销，这里只检查至少包含这两个
+			if result.RevokedSessions < 2 {
+				t.Errorf("revoked_sessions = %d, want at least 2", result.RevokedSessions)
+			}
+			authorized(first.Token, false)
+			authorized(rotated.Token, false)
+			api.refresh(first.RefreshToken, http.StatusUnauthorized)
+			api.refresh(rotated.RefreshToken, http.StatusUnauthorized)
+			authorized(bob, true)
+		})
+
+		t.Run("rejected refresh tokens", func(t *testing.T) {
+			api := api.with(t)
+			api.refresh("not-a-refresh-token", http.StatusUnauthorized)
+			api.expect(api.do("POST", "/token/refresh", "", map[string]string{}), http.StatusBadRequest)
+
+			// 过期的刷新令牌
+			_, userID := api.login("alice")
+			token, hash, err := auth.NewRefreshToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			past := time.Now().Add(-time.Hour)
+			expired := &models.RefreshToken{
+				UserID: userID, TokenHash: hash, SessionID: "expired-session", AccessJTI: "expired-jti",
+				AccessExpiresAt: past, ExpiresAt: past, CreatedAt: past.Add(-time.Hour),
+			}
+			if err := api.store.CreateRefreshToken(t.Context(), expired); err != nil {
+				t.Fatal(err)
+			}
+			api.refresh(token, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, userID := api.signUp("alice")
+		bob, _ := api.signUp("bob")
+		create := func(body map[string]any) models.CreateAPIKeyResponse {
+			t.Helper()
+			var created models.CreateAPIKeyResponse
+			api.expect(api.do("POST", "/api-keys", token, body), http.StatusCreated).decode(t, &created)
+			return created
+		}
+		// withKey 用 Authorization: ApiKey <key> 调用接口
+		withKey := func(method, path, key string, body any, status int) *response {
+			t.Helper()
+			return api.expect(api.do(method, path, "", body, "Authorization", "ApiKey "+key), status)
+		}
+		rechargeBody := map[string]string{"amount": "10.00"}
+
+		full := create(map[string]any{"name": "ci"})
+		readOnly := create(map[string]any{"name": "dashboard", "scopes": []string{"read", "read"}})
+		writer := create(map[string]any{"name": "billing", "scopes": []string{"write"}})
+		if !slices.Equal(readOnly.APIKey.Scopes, []string{"read"}) {
+			t.Errorf("scopes = %v, want [read]", readOnly.APIKey.Scopes)
+		}
+
+		t.Run("scopes", func(t *testing.T) {
+			withKey("GET", "/recharges", full.Key, nil, http.StatusOK)
+			withKey("POST", "/recharge", full.Key, rechargeBody, http.StatusAccepted)
+			withKey("GET", "/recharges", readOnly.Key, nil, http.StatusOK)
+			withKey("POST", "/recharge", readOnly.Key, rechargeBody, http.StatusForbidden)
+			withKey("GET", "/recharges", writer.Key, nil, http.StatusOK)
+			withKey("POST", "/recharge", writer.Key, rechargeBody, http.StatusAccepted)
+			// API Key 不能管理 API Key
+			withKey("POST", "/api-keys", full.Key, map[string]any{"name": "copy"}, http.StatusForbidden)
+		})
+
+		t.Run("invalid keys", func(t *testing.T) {
+			prefix, _ := auth.ParseAPIKeyPrefix(full.Key)
+			for name, key := range map[string]string{
+				"wrong secret":   "adv_" + prefix + "_wrong",
+				"unknown prefix": "adv_00000000_secret",
+				"malformed":      "not-an-api-key",
+			} {
+				t.Run(name, func(t *testing.T) {
+					withKey("GET", "/recharges", key, nil, http.StatusUnauthorized)
+				})
+			}
+			api.expect(api.do("GET", "/recharges", "", nil, "Authorization", "Basic "+full.Key), http.StatusUnauthorized)
+		})
+
+		t.Run("expired", func(t *testing.T) {
+			soon := create(map[string]any{"name": "soon", "expires_at": time.Now().Add(time.Hour)})
+			withKey("GET", "/recharges", soon.Key, nil, http.StatusOK)
+			key, prefix, hash, err := auth.GenerateAPIKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			past := time.Now().Add(-time.Minute)
+			if err := api.store.CreateAPIKey(t.Context(), &models.APIKey{
+				UserID: userID, Name: "expired", Prefix: prefix, SecretHash: hash, Scopes: []string{}, ExpiresAt: &past, CreatedAt: past.Add(-time.Hour),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			withKey("GET", "/recharges", key, nil, http.StatusUnauthorized)
+		})
+
+		t.Run("rejected requests", func(t *testing.T) {
+			for _, body := range []map[string]any{
+				{"name": " "},
+				{"name": strings.Repeat("k", 101)},
+				{"name": "admin", "scopes": []string{"admin"}},
+				{"name": "past", "expires_at": time.Now().Add(-time.Hour)},
+			} {
+				api.expect(api.do("POST", "/api-keys", token, body), http.StatusBadRequest)
+			}
+		})
+
+		t.Run("list and revoke", func(t *testing.T) {
+			r := api.expect(api.do("GET", "/api-keys", token, nil), http.StatusOK)
+			if strings.Contains(string(r.Data), full.Key) {
+				t.Error("list exposes the plain key")
+			}
+			var keys []models.APIKey
+			r.decode(t, &keys)
+			if len(keys) < 3 {
+				t.Errorf("listed %d keys, want at least 3", len(keys))
+			}
+			for _, k := range keys {
+				if k.ID == readOnly.APIKey.ID && k.LastUsedAt == nil {
+					t.Error("last_used_at of a used key is not set")
+				}
+			}
+
+			path := fmt.Sprintf("/api-keys/%d", full.APIKey.ID)
+			// 其他用户不能吊销
+			api.expect(api.do("DELETE", path, bob, nil), http.StatusNotFound)
+			withKey("GET", "/recharges", full.Key, nil, http.StatusOK)
+
+			api.expect(api.do("DELETE", path, token, nil), http.StatusOK)
+			withKey("GET", "/recharges", full.Key, nil, http.StatusUnauthorized)
+			api.expect(api.do("DELETE", path, token, nil), http.StatusNotFound)
+			api.expect(api.do("DELETE", "/api-keys/abc", token, nil), http.StatusBadRequest)
+		})
+	})
+}
+
+func TestRoles(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		_, adminID := api.signUp("root")
+		if err := api.store.UpdateUserRole(t.Context(), adminID, auth.RoleSuperAdmin); err != nil {
+			t.Fatal(err)
+		}
+		// 角色写在访问令牌中，修改后重新登录；后台角色必须先启用两步验证，之前只拿到受限令牌
+		enrollment, _ := api.login("root")
+		api.expect(api.do("GET", "/admin/roles", enrollment.Token, nil), http.StatusForbidden)
+		admin, _, _ := api.enrollTwoFactor(enrollment.Token, time.Now())
+		bob, bobID := api.signUp("bob")
+
+		// 广告主没有任何后台权限
+		for _, path := range []string{"/admin/ads/pending", "/admin/ledger/reconcile", "/admin/roles"} {
+			api.expect(api.do("GET", path, bob, nil), http.StatusForbidden)
+			api.expect(api.do("GET", path, admin.Token, nil), http.StatusOK)
+		}
+
+		assign := func(userID int, role string, status int) {
+			t.Helper()
+			api.expect(api.do("PUT", fmt.Sprintf("/admin/users/%d/role", userID), admin.Token, map[string]string{"role": role}), status)
+		}
+		assign(bobID, auth.RoleReviewer, http.StatusOK)
+		// 修改角色后旧令牌被吊销，重新登录后按新角色授权
+		api.expect(api.do("GET", "/admin/ads/pending", bob, nil), http.StatusUnauthorized)
+		enrollment, _ = api.login("bob")
+		reviewer, _, recoveryCodes := api.enrollTwoFactor(enrollment.Token, time.Now())
+		api.expect(api.do("GET", "/admin/ads/pending", reviewer.Token, nil), http.StatusOK)
+		api.expect(api.do("GET", "/admin/ledger/reconcile", reviewer.Token, nil), http.StatusForbidden)
+		api.expect(api.do("PUT", fmt.Sprintf("/admin/users/%d/role", adminID), reviewer.Token, map[string]string{"role": auth.RoleUser}), http.StatusForbidden)
+
+		assign(bobID, auth.RoleFinance, http.StatusOK)
+		finance := api.loginWithCode("bob", recoveryCodes[0])
+		api.expect(api.do("GET", "/admin/ledger/reconcile", finance.Token, nil), http.StatusOK)
+		api.expect(api.do("GET", "/admin/ads/pending", finance.Token, nil), http.StatusForbidden)
+
+		assign(bobID, "admin", http.StatusBadRequest)
+		assign(adminID, auth.RoleUser, http.StatusBadRequest) // 不能修改自己的角色
+		assign(999999, auth.RoleReviewer, http.StatusNotFound)
+	})
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		token, _ := api.signUp("carol")
+		before, _ := api.login("carol")
+		api.expect(api.do("POST", "/2fa/totp/setup", token, nil), http.StatusOK)
+		api.expect(api.do("POST", "/2fa/totp/enable", token, map[string]string{"code": "aaaaa-aaaaa"}), http.StatusBadRequest)
+		// 验证码都按 at 计算，与服务端的时间相差不超过一个时间步
+		at := time.Now()
+		_, secret, recoveryCodes := api.enrollTwoFactor(token, at)
+		if len(recoveryCodes) != auth.RecoveryCodeCount {
+			t.Fatalf("%d recovery codes, want %d", len(recoveryCodes), auth.RecoveryCodeCount)
+		}
+		// 启用前的会话全部吊销
+		api.refresh(before.RefreshToken, http.StatusUnauthorized)
+
+		secondStep := func(mfaToken, code string) *response {
+			t.Helper()
+			return api.do("POST", "/login/2fa", "", map[string]string{"mfa_token": mfaToken, "code": code})
+		}
+
+		t.Run("totp", func(t *testing.T) {
+			api := api.with(t)
+			mfaToken := api.mfaChallenge("carol")
+			// 启用时已经用过 at 所在的时间步
+			api.expect(secondStep(mfaToken, api.totpCode(secret, at)), http.StatusUnauthorized)
+			// 允许一个时间步的时钟偏差
+			next := api.totpCode(secret, at.Add(30*time.Second))
+			api.expect(secondStep(mfaToken, next), http.StatusOK)
+			// 挑战只能完成一次，同一时间步的验证码也不能在新的挑战中重放
+			api.expect(secondStep(mfaToken, next), http.StatusUnauthorized)
+			api.expect(secondStep(api.mfaChallenge("carol"), next), http.StatusUnauthorized)
+			api.expect(secondStep("unknown", next), http.StatusUnauthorized)
+		})
+
+		t.Run("recovery codes", func(t *testing.T) {
+			api := api.with(t)
+			tokens := api.loginWithCode("carol", recoveryCodes[0])
+			api.expect(secondStep(api.mfaChallenge("carol"), recoveryCodes[0]), http.StatusUnauthorized)
+			// 输入时忽略大小写和连字符
+			api.loginWithCode("carol", strings.ToUpper(strings.ReplaceAll(recoveryCodes[1], "-", "")))
+
+			r := api.expect(api.do("GET", "/2fa", tokens.Token, nil), http.StatusOK)
+			var status models.TwoFactorStatus
+			r.decode(t, &status)
+			if !status.Enabled || status.RecoveryCodesRemaining != auth.RecoveryCodeCount-2 {
+				t.Fatalf("status = %+v, want enabled with %d recovery codes", status, auth.RecoveryCodeCount-2)
+			}
+		})
+
+		t.Run("too many attempts", func(t *testing.T) {
+			api := api.with(t)
+			mfaToken := api.mfaChallenge("carol")
+			wrong := api.totpCode(secret, at.Add(time.Hour))
+			// 两步验证码错误也计入登录限流 (超过免费次数后退避)，每次清除后才能测到挑战自己的次数上限
+			resetThrottle := func() {
+				t.Helper()
+				if err := api.store.ResetLoginAttempts(t.Context(), throttle.UserKey("carol")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < config.Default().TwoFactor.MaxAttempts; i++ {
+				resetThrottle()
+				api.expect(secondStep(mfaToken, wrong), http.StatusUnauthorized)
+			}
+			// 挑战已作废，正确的恢复码也不能使用，且不会被消耗
+			resetThrottle()
+			api.expect(secondStep(mfaToken, recoveryCodes[2]), http.StatusUnauthorized)
+			resetThrottle()
+			api.loginWithCode("carol", recoveryCodes[2])
+		})
+	})
+}
+
+func TestLoginThrottle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, api *testAPI) {
+		_, adminID := api.signUp("root")
+		if err := api.store.UpdateUserRole(t.Context(), adminID, auth.RoleSuperAdmin); err != nil {
+			t.Fatal(err)
+		}
+		enrollment, _ := api.login("root")
+		admin, _, _ := api.enrollTwoFactor(enrollment.Token, time.Now())
+		_, aliceID := api.signUp("alice")
+
+		wrong := map[string]string{"username": "alice", "password": "wrong-password"}
+		right := map[string]string{"username": "alice", "password": "pw123456"}
+		// 默认前 3 次失败不退避
+		for i := 0; i < config.Default().LoginThrottle.UserFreeAttempts; i++ {
+			api.expect(api.do("POST", "/login", "", wrong), http.StatusUnauthorized)
+		}
+		// 登录成功清除失败次数
+		api.expect(api.do("POST", "/login", "", right), http.StatusOK)
+		for i := 0; i <= config.Default().LoginThrottle.UserFreeAttempts; i++ {
+			api.expect(api.do("POST", "/login", "", wrong), http.StatusUnauthorized)
+		}
+		// 退避期间即使密码正确也被拒绝，用户名不区分大小写
+		r := api.expect(api.do("POST", "/login", "", map[string]string{"username": "ALICE", "password": "pw123456"}), http.StatusTooManyRequests)
+		if got := r.header.Get("Retry-After"); got != "1" {
+			t.Fatalf("Retry-After = %q, want 1", got)
+		}
+		// 其他账号不受影响
+		api.login("root")
+
+		api.expect(api.do("POST", fmt.Sprintf("/admin/users/%d/unlock", aliceID), "", nil), http.StatusUnauthorized)
+		api.expect(api.do("POST", fmt.Sprintf("/admin/users/%d/unlock", aliceID), admin.Token, nil), http.StatusOK)
+		api.expect(api.do("POST", "/admin/users/999999/unlock", admin.Token, nil), http.StatusNotFound)
+		api.expect(api.do("POST", "/login", "", right), http.StatusOK)
+	})
+}
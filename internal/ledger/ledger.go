@@ -0,0 +1,201 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// --- 复式记账 ---
+// 每一笔资金变动是一条分录 (Entry)，由若干过账 (Posting) 组成。
+// Posting.Amount 为正表示借方 (debit)，为负表示贷方 (credit)，同一分录所有过账之和必须为 0。
+//
+// 账户余额方向 (从平台视角)：
+//   - 资产 / 费用类账户 (platform:cash、platform:promotions ...) 借方为正
+//   - 负债 / 收入类账户 (org:<id>:wallet、platform:ad_revenue ...) 贷方为正
+//
+// 组织的 organizations.balance 就是其钱包账户 (负债) 的贷方余额，即 -SUM(postings.amount)。
+
+// 分录类型
+const (
+	KindRecharge       = "recharge"        // 充值: 借 platform:cash，贷 组织钱包
+	KindAdSpend        = "ad_spend"        // 广告消耗: 借 组织钱包，贷 platform:ad_revenue
+	KindRefund         = "refund"          // 退款 (退回原支付渠道): 借 组织钱包，贷 platform:cash
+	KindPromoCredit    = "promo_credit"    // 赠送额度: 借 platform:promotions，贷 组织钱包
+	KindAdjustment     = "adjustment"      // 管理员手工调账: 组织钱包 <-> platform:adjustments
+	KindOpeningBalance = "opening_balance" // 启用账本前已存在的余额 (迁移时生成)
+)
+
+// 平台账户编码
+const (
+	AccountPlatformCash = "platform:cash"        // 资产: 通过支付渠道收到的资金
+	AccountAdRevenue    = "platform:ad_revenue"  // 收入: 广告消耗
+	AccountPromotions   = "platform:promotions"  // 费用: 赠送给广告主的额度
+	AccountAdjustments  = "platform:adjustments" // 权益: 手工调账和期初余额的对方科目
+)
+
+// 账户类型
+const (
+	TypeAsset     = "asset"
+	TypeLiability = "liability"
+	TypeRevenue   = "revenue"
+	TypeExpense   = "expense"
+	TypeEquity    = "equity"
+)
+
+var (
+	ErrUnbalanced     = errors.New("ledger: entry is not balanced")
+	ErrInvalidEntry   = errors.New("ledger: invalid entry")
+	ErrInvalidAmount  = errors.New("ledger: amount must be positive")
+	ErrUnknownKind    = errors.New("ledger: unknown entry kind")
+	ErrUnknownAccount = errors.New("ledger: unknown account")
+)
+
+// WalletAccount 返回组织钱包账户的编码
+func WalletAccount(orgID int) string {
+	return fmt.Sprintf("org:%d:wallet", orgID)
+}
+
+// AccountType 返回账户编码对应的账户类型
+func AccountType(code string) (string, error) {
+	switch code {
+	case AccountPlatformCash:
+		return TypeAsset, nil
+	case AccountAdRevenue:
+		return TypeRevenue, nil
+	case AccountPromotions:
+		return TypeExpense, nil
+	case AccountAdjustments:
+		return TypeEquity, nil
+	}
+	if strings.HasPrefix(code, "org:") && strings.HasSuffix(code, ":wallet") {
+		return TypeLiability, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownAccount, code)
+}
+
+// Posting 是分录中的一行
+type Posting struct {
+	Account string // 账户编码
+	Amount  int64  // 单位：分；正数借方，负数贷方
+}
+
+// Entry 是一条待过账的分录
+type Entry struct {
+	Kind           string
+	OrganizationID int    // 钱包所属的组织 (用于 GET /ledger 查询)
+	UserID         int    // 关联的成员 (例如发起充值的用户)，0 表示无
+	Reference      string // 业务引用，例如 "recharge:12"，同一引用只能过账一次；为空表示无引用
+	Memo           string
+	CreatedBy      int // 操作人 ID，0 表示系统
+	Postings       []Posting
+}
+
+// Validate 检查分录是否借贷平衡
+func (e Entry) Validate() error {
+	if e.Kind == "" {
+		return fmt.Errorf("%w: missing kind", ErrInvalidEntry)
+	}
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("%w: at least two postings are required", ErrInvalidEntry)
+	}
+	var sum int64
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return fmt.Errorf("%w: zero posting on %s", ErrInvalidEntry, p.Account)
+		}
+		if _, err := AccountType(p.Account); err != nil {
+			return err
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: postings sum to %d", ErrUnbalanced, sum)
+	}
+	return nil
+}
+
+// WalletDelta 返回分录对 OrganizationID 钱包余额的影响 (贷方增加余额)
+func (e Entry) WalletDelta() int64 {
+	wallet := WalletAccount(e.OrganizationID)
+	var delta int64
+	for _, p := range e.Postings {
+		if p.Account == wallet {
+			delta -= p.Amount
+		}
+	}
+	return delta
+}
+
+// transfer 构造 "借 debit、贷 credit" 的两行分录
+func transfer(kind string, orgID int, amount int64, debit, credit string) (Entry, error) {
+	if amount <= 0 {
+		return Entry{}, ErrInvalidAmount
+	}
+	return Entry{
+		Kind:           kind,
+		OrganizationID: orgID,
+		Postings: []Posting{
+			{Account: debit, Amount: amount},
+			{Account: credit, Amount: -amount},
+		},
+	}, nil
+}
+
+// Recharge 充值入账，userID 是发起充值的成员
+func Recharge(orgID, userID int, amount int64, rechargeID int64) (Entry, error) {
+	e, err := transfer(KindRecharge, orgID, amount, AccountPlatformCash, WalletAccount(orgID))
+	e.UserID = userID
+	e.Reference = fmt.Sprintf("recharge:%d", rechargeID)
+	return e, err
+}
+
+// AdSpend 一次展示 / 点击的广告消耗
+func AdSpend(orgID int, amount int64, eventID int64) (Entry, error) {
+	e, err := transfer(KindAdSpend, orgID, amount, WalletAccount(orgID), AccountAdRevenue)
+	e.Reference = fmt.Sprintf("ad_event:%d", eventID)
+	return e, err
+}
+
+// Refund 把余额退回原支付渠道
+func Refund(orgID int, amount int64) (Entry, error) {
+	return transfer(KindRefund, orgID, amount, WalletAccount(orgID), AccountPlatformCash)
+}
+
+// PromoCredit 赠送额度
+func PromoCredit(orgID int, amount int64) (Entry, error) {
+	return transfer(KindPromoCredit, orgID, amount, AccountPromotions, WalletAccount(orgID))
+}
+
+// Adjustment 手工调账，amount 为正增加余额，为负减少余额
+func Adjustment(orgID int, amount int64) (Entry, error) {
+	if amount < 0 {
+		return transfer(KindAdjustment, orgID, -amount, WalletAccount(orgID), AccountAdjustments)
+	}
+	return transfer(KindAdjustment, orgID, amount, AccountAdjustments, WalletAccount(orgID))
+}
+
+// ManualEntry 根据管理员请求的类型构造分录 (refund / promo_credit / adjustment)
+func ManualEntry(kind string, orgID int, amount int64, memo string, createdBy int) (Entry, error) {
+	var e Entry
+	var err error
+	switch kind {
+	case KindRefund:
+		e, err = Refund(orgID, amount)
+	case KindPromoCredit:
+		e, err = PromoCredit(orgID, amount)
+	case KindAdjustment:
+		if amount == 0 {
+			return Entry{}, ErrInvalidAmount
+		}
+		e, err = Adjustment(orgID, amount)
+	default:
+		return Entry{}, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Memo = memo
+	e.CreatedBy = createdBy
+	return e, nil
+}
@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"advertisement/internal/store"
+)
+
+const (
+	// dispatchBatchSize 每轮最多领取的邮件数
+	dispatchBatchSize = 20
+	// dispatchLease 领取后其他实例不会再领取这批邮件的时间，需要覆盖一整批邮件的发送时间
+	dispatchLease = dispatchBatchSize * smtpTimeout
+	// retryBaseDelay / retryMaxDelay 指数退避: 第 n 次失败后等待 retryBaseDelay * 2^(n-1)，最多 retryMaxDelay
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = time.Hour
+)
+
+// Dispatcher 定期从发件箱领取到期的邮件并通过 Mailer 发送：
+//   - 发送成功 -> Sent
+//   - 临时错误 -> 按指数退避安排下次重试
+//   - 永久错误 (IsPermanent) 或达到最大尝试次数 -> Failed
+//
+// 邮件至少发送一次：进程在发送后、标记 Sent 前退出时，租约到期后会再发送一次。
+type Dispatcher struct {
+	store       store.Store
+	mailer      Mailer
+	interval    time.Duration
+	maxAttempts int
+}
+
+// NewDispatcher 创建投递器，interval 为轮询间隔，maxAttempts 为每封邮件最多尝试的次数
+func NewDispatcher(s store.Store, m Mailer, interval time.Duration, maxAttempts int) *Dispatcher {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	return &Dispatcher{store: s, mailer: m, interval: interval, maxAttempts: maxAttempts}
+}
+
+// Run 启动时先执行一次，之后按间隔执行，直到 ctx 被取消。应在单独的 goroutine 中调用。
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("mail: 邮件投递器已启动 (%s)，间隔 %s", d.mailer.Name(), d.interval)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("mail: 投递失败: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("mail: 邮件投递器已停止")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce 以 now 为当前时间领取一批到期的邮件并发送，返回发送成功的数量
+func (d *Dispatcher) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	messages, err := d.store.ClaimOutboxMessages(ctx, now, dispatchLease, dispatchBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range messages {
+		sendCtx, cancel := context.WithTimeout(ctx, smtpTimeout)
+		err := d.mailer.Send(sendCtx, Message{ID: m.ID, To: m.Recipient, Subject: m.Subject, Body: m.Body})
+		cancel()
+
+		if err == nil {
+			if err := d.store.MarkOutboxMessageSent(ctx, m.ID, time.Now()); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return sent, err
+			}
+			log.Printf("mail: 邮件 %d 已发送给 %s (第 %d 次尝试)", m.ID, m.Recipient, m.Attempts)
+			sent++
+			continue
+		}
+		if ctx.Err() != nil {
+			// 正在退出，租约到期后会重新发送
+			return sent, ctx.Err()
+		}
+
+		var retryAt *time.Time
+		if !IsPermanent(err) && m.Attempts < d.maxAttempts {
+			t := now.Add(retryDelay(m.Attempts))
+			retryAt = &t
+			log.Printf("mail: 邮件 %d 发送失败 (第 %d 次)，%s 后重试: %v", m.ID, m.Attempts, retryDelay(m.Attempts), err)
+		} else {
+			log.Printf("mail: 邮件 %d 发送失败 (第 %d 次)，不再重试: %v", m.ID, m.Attempts, err)
+		}
+		if err := d.store.MarkOutboxMessageFailed(ctx, m.ID, err.Error(), retryAt); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// retryDelay 第 attempts 次失败后的等待时间
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- 邮件发送 ---
+// 业务代码不直接发送邮件：邮件先和业务数据在同一个事务中写入发件箱 (email_outbox)，
+// 再由 Dispatcher 定期领取并通过 Mailer 发送，失败按指数退避重试。
+//
+// Mailer 的实现:
+//   - FileMailer: 把邮件写成 .eml 文件 (默认，本地开发直接用邮件客户端打开)
+//   - SMTPMailer: 通过 SMTP 发送 (本地可配合 cmd/fakesmtp 测试)
+
+// Message 一封待发送的纯文本邮件
+type Message struct {
+	ID      int64 // 发件箱中的 ID，用于生成 Message-ID 和文件名
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 是邮件发送渠道的抽象
+type Mailer interface {
+	// Name 渠道名称，用于日志
+	Name() string
+	// Send 发送一封邮件。返回的错误如果被 IsPermanent 判定为永久错误，Dispatcher 不再重试
+	Send(ctx context.Context, msg Message) error
+}
+
+// maxLineLength RFC 5322 规定的单行最大长度 (不含 CRLF)
+const maxLineLength = 998
+
+// Format 把邮件格式化为 RFC 5322 报文：CRLF 换行，UTF-8 主题用 RFC 2047 编码。
+// 正文直接以 8bit 发送 (.eml 文件中的链接保持完整可复制)，有超长行时改用 quoted-printable
+func Format(from string, msg Message, now time.Time) ([]byte, error) {
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	encoding := "8bit"
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > maxLineLength {
+			encoding = "quoted-printable"
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", msg.To},
+		{"Subject", mime.BEncoding.Encode("UTF-8", msg.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", messageID(from, msg.ID, now)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=UTF-8"},
+		{"Content-Transfer-Encoding", encoding},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+
+	if encoding == "8bit" {
+		buf.WriteString(body)
+	} else {
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(body)); err != nil {
+			return nil, fmt.Errorf("mail: 编码邮件正文失败: %w", err)
+		}
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("mail: 编码邮件正文失败: %w", err)
+		}
+	}
+	if !strings.HasSuffix(body, "\r\n") {
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// messageID 生成 Message-ID，域名取发件人地址的域名
+func messageID(from string, id int64, now time.Time) string {
+	domain := "localhost"
+	if addr, err := netmail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	return fmt.Sprintf("<outbox-%d.%d@%s>", id, now.UnixNano(), domain)
+}
+
+// --- FileMailer ---
+
+// FileMailer 把每封邮件写成 dir 下的一个 .eml 文件，不会真正发出邮件
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer 创建 FileMailer，目录不存在时自动创建
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mail: 创建邮件目录 %s 失败: %w", dir, err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Name() string { return "file" }
+
+// Send 先写临时文件再重命名，读取目录的程序不会看到写了一半的邮件
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := Format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.eml", now.UTC().Format("20060102T150405.000000000Z"), msg.ID)
+	tmp, err := os.CreateTemp(m.dir, ".tmp-*.eml")
+	if err != nil {
+		return fmt.Errorf("mail: 创建邮件文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // 重命名成功后这里什么也不做
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("mail: 写入邮件文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("mail: 写入邮件文件失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(m.dir, name)); err != nil {
+		return fmt.Errorf("mail: 保存邮件文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// smtpTimeout 单封邮件的发送超时 (ctx 没有设置截止时间时使用)
+const smtpTimeout = 30 * time.Second
+
+// SMTPMailer 通过 SMTP 发送邮件。
+// 服务器支持 STARTTLS 时自动升级为 TLS；配置了用户名时使用 AUTH PLAIN
+// (net/smtp 只允许在 TLS 连接或 localhost 上发送明文密码)。
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer 创建 SMTPMailer，from 是发件人 (可以带显示名，例如 "广告平台 <no-reply@example.com>")
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{host: host, port: port, username: username, password: password, from: from}
+}
+
+func (m *SMTPMailer) Name() string { return "smtp" }
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	sender, err := netmail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("mail: 无效的发件人 %q: %w", m.from, err)
+	}
+	rcpt, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return permanentError{fmt.Errorf("mail: 无效的收件人 %q: %w", msg.To, err)}
+	}
+	data, err := Format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("mail: 连接 SMTP 服务器 %s 失败: %w", addr, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mail: SMTP 握手失败: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return fmt.Errorf("mail: STARTTLS 失败: %w", err)
+		}
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("mail: SMTP 认证失败: %w", err)
+		}
+	}
+	if err := c.Mail(sender.Address); err != nil {
+		return fmt.Errorf("mail: MAIL FROM 失败: %w", err)
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return rejected(fmt.Errorf("mail: RCPT TO 失败: %w", err))
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mail: DATA 失败: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("mail: 写入邮件内容失败: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return rejected(fmt.Errorf("mail: 服务器拒绝了邮件: %w", err))
+	}
+	return c.Quit()
+}
+
+// permanentError 标记不需要重试的错误 (例如收件人地址无效)
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// rejected 服务器用 5xx 拒绝了收件人或邮件内容时标记为永久错误。
+// 连接、认证等阶段的 5xx 通常是配置问题，修好后应该能发出去，仍按临时错误重试
+func rejected(err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return permanentError{err}
+	}
+	return err
+}
+
+// IsPermanent 判断发送错误是否为永久错误 (收件人地址无效、服务器拒收)。
+// 其他错误 (连接失败、认证失败、4xx 等) 都是临时错误，Dispatcher 会稍后重试
+func IsPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// --- 邮件模板 ---
+// 只发送纯文本邮件，链接由调用方根据 mail.link_base_url 拼接
+
+// VerificationEmail 注册 / 重发验证邮件的主题和正文
+func VerificationEmail(username, link string, ttl time.Duration) (subject, body string) {
+	subject = "请验证你的邮箱"
+	body = fmt.Sprintf(`%s，你好：
+
+感谢注册广告平台。请打开下面的链接完成邮箱验证：
+
+%s
+
+链接在 %s 内有效，只能使用一次。如果这不是你本人的操作，请忽略这封邮件。
+`, username, link, formatTTL(ttl))
+	return subject, body
+}
+
+// PasswordResetEmail 找回密码邮件的主题和正文
+func PasswordResetEmail(username, link string, ttl time.Duration) (subject, body string) {
+	subject = "重置你的密码"
+	body = fmt.Sprintf(`%s，你好：
+
+我们收到了重置你账号密码的请求。请打开下面的链接设置新密码：
+
+%s
+
+链接在 %s 内有效，只能使用一次；重置后所有已登录的会话都会退出。
+如果这不是你本人的操作，请忽略这封邮件，你的密码不会改变。
+`, username, link, formatTTL(ttl))
+	return subject, body
+}
+
+// formatTTL 把有效期写成 "48 小时" / "30 分钟" 这样的中文
+func formatTTL(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%d 小时", int(d/time.Hour))
+	}
+	if d >= time.Minute && d%time.Minute == 0 {
+		return fmt.Sprintf("%d 分钟", int(d/time.Minute))
+	}
+	return d.String()
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/store"
+	"advertisement/internal/webutil"
+)
+
+// apiKeyTouchInterval 控制 last_used_at 的更新频率，避免每个请求都写数据库
+const apiKeyTouchInterval = time.Minute
+
+// authenticateAPIKey 校验 Authorization: ApiKey <key>，成功时返回与 JWT 相同的 Claims。
+// 失败时已写入响应并返回 false。
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, s AuthStore, key string) (*auth.Claims, bool) {
+	prefix, ok := auth.ParseAPIKeyPrefix(key)
+	if !ok {
+		webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 API Key")
+		return nil, false
+	}
+	apiKey, err := s.GetAPIKeyByPrefix(r.Context(), prefix)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 API Key")
+		} else {
+			log.Printf("查询 API Key %s 失败: %v", prefix, err)
+			webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证 API Key")
+		}
+		return nil, false
+	}
+	if !auth.APIKeyMatches(key, apiKey.SecretHash) {
+		webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 API Key")
+		return nil, false
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil {
+		webutil.RespondWithError(w, http.StatusUnauthorized, "API Key 已被吊销")
+		return nil, false
+	}
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now) {
+		webutil.RespondWithError(w, http.StatusUnauthorized, "API Key 已过期")
+		return nil, false
+	}
+
+	// 用户名和角色以数据库为准 (角色变更后立即生效)
+	user, err := s.GetUserByID(r.Context(), apiKey.UserID)
+	if err != nil {
+		log.Printf("获取 API Key %s 的用户 %d 失败: %v", prefix, apiKey.UserID, err)
+		webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 API Key")
+		return nil, false
+	}
+	if rejectSuspended(w, user) {
+		return nil, false
+	}
+	// 必须启用两步验证的账号在绑定之前不能使用 API Key
+	if auth.RequiresTwoFactor(user.Role, user.TwoFactorRequired) && user.TOTPEnabledAt == nil {
+		webutil.RespondWithError(w, http.StatusForbidden, "账号必须先启用两步验证")
+		return nil, false
+	}
+
+	claims := &auth.Claims{
+		Username: user.Username,
+		UserID:   user.ID,
+		Role:     user.Role,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}
+	if !claims.Allows(r.Method) {
+		webutil.RespondWithError(w, http.StatusForbidden, "API Key 的权限范围不允许该操作")
+		return nil, false
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err := s.TouchAPIKey(r.Context(), apiKey.ID, now); err != nil {
+			// 只影响 last_used_at 的准确性，不拒绝请求
+			log.Printf("更新 API Key %s 最后使用时间失败: %v", prefix, err)
+		}
+	}
+	return claims, true
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIPContextKey 是在 context 中存储客户端 IP 的键
+const clientIPContextKey contextKey = "client_ip"
+
+// ClientIP 确定客户端 IP 并存入 context (登录限流等按 IP 统计的功能使用)。
+// trustProxyHeaders 为 true 时 (部署在反向代理之后，见 server.trust_proxy_headers) 取 X-Forwarded-For
+// 的最后一个地址，即代理看到的对端地址；没有该头时使用 X-Real-IP；否则使用连接的对端地址
+func ClientIP(trustProxyHeaders bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			if trustProxyHeaders {
+				if forwarded := lastForwardedFor(r.Header.Values("X-Forwarded-For")); forwarded != "" {
+					ip = forwarded
+				} else if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+					ip = realIP.String()
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPContextKey, ip)))
+		})
+	}
+}
+
+// ClientIPFromRequest 返回 ClientIP 中间件确定的客户端 IP；没有经过该中间件时使用连接的对端地址
+func ClientIPFromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP 返回连接的对端 IP (去掉端口)
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lastForwardedFor 返回 X-Forwarded-For 中最后一个有效的 IP (可能有多个头，每个头是逗号分隔的列表)
+func lastForwardedFor(values []string) string {
+	for i := len(values) - 1; i >= 0; i-- {
+		parts := strings.Split(values[i], ",")
+		for j := len(parts) - 1; j >= 0; j-- {
+			if ip := net.ParseIP(strings.TrimSpace(parts[j])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	return ""
+}
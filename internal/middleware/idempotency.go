@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/models"
+	"advertisement/internal/webutil"
+)
+
+// IdempotencyKeyHeader 客户端提供幂等键的请求头
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader 重放的响应带有的响应头 (值为 "true")
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// IdempotencyTTL 幂等键的有效期，过期后同一个键会被当作新请求处理
+const IdempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodyBytes 带幂等键的请求体上限：请求体要整体读入内存计算指纹
+const maxIdempotentBodyBytes = 64 << 10
+
+// IdempotencyStore 是 Idempotency 中间件需要的存储操作 (store.Store 已实现)
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+}
+
+// Idempotency 为涉及资金的 POST 接口提供幂等性 (必须放在 AuthMiddleware 之后，按用户隔离幂等键)：
+//   - 没有 Idempotency-Key 请求头时直接放行
+//   - 同一用户、同一个键、相同请求 (方法 + 路径 + 请求体) 重放第一次的响应，并带上 Idempotent-Replayed: true
+//   - 同一个键用于不同的请求返回 422，第一次请求仍在处理中返回 409
+//   - 请求体超过 64KB 返回 413
+//   - 第一次请求返回 5xx 时释放该键，允许客户端重试
+func Idempotency(s IdempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				webutil.RespondWithError(w, http.StatusBadRequest, "Idempotency-Key 过长 (最多 255 个字符)")
+				return
+			}
+
+			userClaims, ok := r.Context().Value(UserContextKey).(*auth.Claims)
+			if !ok || userClaims == nil {
+				log.Println("错误：Idempotency 中间件无法从 context 获取有效的用户信息")
+				webutil.RespondWithError(w, http.StatusInternalServerError, "无法处理幂等请求")
+				return
+			}
+
+			// 读取请求体计算指纹，再放回去给后续 handler 使用
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					webutil.RespondWithError(w, http.StatusRequestEntityTooLarge, "请求体过大")
+					return
+				}
+				webutil.RespondWithError(w, http.StatusBadRequest, "无法读取请求体")
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+			// 同一个键用于不同组织也算不同的请求 (Org 中间件在 Idempotency 之前执行)
+			if member, ok := r.Context().Value(OrganizationContextKey).(*models.OrganizationMember); ok {
+				io.WriteString(hash, "org "+strconv.Itoa(member.OrganizationID)+"\n")
+			}
+			hash.Write(body)
+			now := time.Now()
+			rec := models.IdempotencyRecord{
+				UserID:      userClaims.UserID,
+				Key:         key,
+				RequestHash: hex.EncodeToString(hash.Sum(nil)),
+				CreatedAt:   now,
+			}
+
+			existing, err := s.ReserveIdempotencyKey(r.Context(), rec, now.Add(-IdempotencyTTL))
+			if err != nil {
+				log.Printf("用户 %d 占用幂等键失败: %v", rec.UserID, err)
+				webutil.RespondWithError(w, http.StatusInternalServerError, "无法处理幂等请求")
+				return
+			}
+			if existing != nil {
+				switch {
+				case existing.RequestHash != rec.RequestHash:
+					webutil.RespondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key 已被用于不同的请求")
+				case existing.StatusCode == 0:
+					webutil.RespondWithError(w, http.StatusConflict, "使用相同 Idempotency-Key 的请求正在处理中，请稍后重试")
+				default:
+					log.Printf("用户 %d 重复请求 %s %s (Idempotency-Key: %s)，重放原响应", rec.UserID, r.Method, r.URL.Path, key)
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set(IdempotentReplayedHeader, "true")
+					w.WriteHeader(existing.StatusCode)
+					w.Write(existing.ResponseBody)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// 请求结束后再保存结果，不受客户端断开连接影响
+			ctx := context.WithoutCancel(r.Context())
+			if recorder.status >= http.StatusInternalServerError {
+				if err := s.ReleaseIdempotencyKey(ctx, rec.UserID, key); err != nil {
+					log.Printf("释放用户 %d 的幂等键失败: %v", rec.UserID, err)
+				}
+				return
+			}
+			if err := s.CompleteIdempotencyKey(ctx, rec.UserID, key, recorder.status, recorder.body.Bytes()); err != nil {
+				log.Printf("保存用户 %d 的幂等响应失败: %v", rec.UserID, err)
+			}
+		})
+	}
+}
+
+// responseRecorder 在写出响应的同时记录状态码和响应体
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rw *responseRecorder) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,203 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/middleware"
+	"advertisement/internal/migrate"
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+)
+
+// stores 幂等中间件在这些 Store 实现上的行为必须一致，每个 Store 中都有 ID 为 1 和 2 的用户
+var stores = []struct {
+	name string
+	open func(t *testing.T) middleware.IdempotencyStore
+}{
+	{"SQLite", func(t *testing.T) middleware.IdempotencyStore {
+		t.Helper()
+		db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		migrator, err := migrate.New(db, "sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return withUsers(t, store.NewSQLiteStore(db))
+	}},
+	{"MemStore", func(t *testing.T) middleware.IdempotencyStore { return withUsers(t, store.NewMemStore()) }},
+}
+
+// withUsers 创建幂等键所属的两个用户 (ID 为 1 和 2)
+func withUsers(t *testing.T, s store.Store) store.Store {
+	t.Helper()
+	for _, name := range []string{"alice", "bob"} {
+		if err := s.CreateUser(context.Background(), &models.User{Username: name, PasswordHash: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// idempotentServer 用 Idempotency 包装 next，并像 AuthMiddleware 那样把用户放进 context
+func idempotentServer(s middleware.IdempotencyStore, userID int, next http.HandlerFunc) http.Handler {
+	h := middleware.Idempotency(s)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := &auth.Claims{UserID: userID, Username: "alice", Role: "advertiser"}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, claims)))
+	})
+}
+
+func post(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/recharge", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(middleware.IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			testIdempotency(t, s.open)
+		})
+	}
+}
+
+func testIdempotency(t *testing.T, open func(t *testing.T) middleware.IdempotencyStore) {
+	t.Run("replay", func(t *testing.T) {
+		var calls atomic.Int32
+		h := idempotentServer(open(t), 1, func(w http.ResponseWriter, r *http.Request) {
+			n := calls.Add(1)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"call":` + string(rune('0'+n)) + `}`))
+		})
+		first := post(h, "k1", `{"amount":10}`)
+		second := post(h, "k1", `{"amount":10}`)
+		if calls.Load() != 1 {
+			t.Fatalf("handler called %d times, want 1", calls.Load())
+		}
+		if second.Code != http.StatusAccepted || second.Body.String() != first.Body.String() {
+			t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+		}
+		if first.Header().Get(middleware.IdempotentReplayedHeader) != "" || second.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+			t.Errorf("%s = %q, %q, want \"\", \"true\"", middleware.IdempotentReplayedHeader,
+				first.Header().Get(middleware.IdempotentReplayedHeader), second.Header().Get(middleware.IdempotentReplayedHeader))
+		}
+		// 没有幂等键的请求每次都执行
+		post(h, "", `{"amount":10}`)
+		post(h, "", `{"amount":10}`)
+		if calls.Load() != 3 {
+			t.Errorf("handler called %d times, want 3", calls.Load())
+		}
+	})
+
+	t.Run("keys are scoped to the user", func(t *testing.T) {
+		s := open(t)
+		var calls atomic.Int32
+		handler := func(w http.ResponseWriter, r *http.Request) { calls.Add(1) }
+		post(idempotentServer(s, 1, handler), "k1", `{}`)
+		post(idempotentServer(s, 2, handler), "k1", `{}`)
+		if calls.Load() != 2 {
+			t.Errorf("handler called %d times, want 2", calls.Load())
+		}
+	})
+
+	t.Run("key reused with a different body", func(t *testing.T) {
+		var calls atomic.Int32
+		h := idempotentServer(open(t), 1, func(w http.ResponseWriter, r *http.Request) { calls.Add(1) })
+		post(h, "k1", `{"amount":10}`)
+		if w := post(h, "k1", `{"amount":1000}`); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("handler called %d times, want 1", calls.Load())
+		}
+	})
+
+	t.Run("concurrent request in flight", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		h := idempotentServer(open(t), 1, func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusCreated)
+		})
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- post(h, "k1", `{}`) }()
+		select {
+		case <-started:
+		case w := <-done:
+			t.Fatalf("first request finished with %d before reaching the handler", w.Code)
+		}
+		if w := post(h, "k1", `{}`); w.Code != http.StatusConflict {
+			t.Errorf("in-flight status = %d, want %d", w.Code, http.StatusConflict)
+		}
+		close(release)
+		if w := <-done; w.Code != http.StatusCreated {
+			t.Errorf("first status = %d, want %d", w.Code, http.StatusCreated)
+		}
+		if w := post(h, "k1", `{}`); w.Code != http.StatusCreated || w.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+			t.Errorf("after completion status = %d, replayed = %q", w.Code, w.Header().Get(middleware.IdempotentReplayedHeader))
+		}
+	})
+
+	t.Run("released on 5xx", func(t *testing.T) {
+		var calls atomic.Int32
+		h := idempotentServer(open(t), 1, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		})
+		if w := post(h, "k1", `{}`); w.Code != http.StatusInternalServerError {
+			t.Fatalf("first status = %d", w.Code)
+		}
+		if w := post(h, "k1", `{}`); w.Code != http.StatusAccepted || w.Header().Get(middleware.IdempotentReplayedHeader) != "" {
+			t.Errorf("retry status = %d, replayed = %q, want a fresh %d", w.Code, w.Header().Get(middleware.IdempotentReplayedHeader), http.StatusAccepted)
+		}
+		if calls.Load() != 2 {
+			t.Errorf("handler called %d times, want 2", calls.Load())
+		}
+	})
+
+	t.Run("4xx is replayed", func(t *testing.T) {
+		var calls atomic.Int32
+		h := idempotentServer(open(t), 1, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		})
+		post(h, "k1", `{}`)
+		if w := post(h, "k1", `{}`); w.Code != http.StatusBadRequest || calls.Load() != 1 {
+			t.Errorf("status = %d after %d calls, want a replayed %d", w.Code, calls.Load(), http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejected requests", func(t *testing.T) {
+		var calls atomic.Int32
+		h := idempotentServer(open(t), 1, func(w http.ResponseWriter, r *http.Request) { calls.Add(1) })
+		if w := post(h, strings.Repeat("k", 256), `{}`); w.Code != http.StatusBadRequest {
+			t.Errorf("long key status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if w := post(h, "k1", `{"memo":"`+strings.Repeat("x", 64<<10)+`"}`); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("large body status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+		}
+		if calls.Load() != 0 {
+			t.Errorf("handler called %d times, want 0", calls.Load())
+		}
+	})
+}
@@ -3,16 +3,17 @@ package middleware
 import (
 	"context"
 	"errors" // 需要导入 errors 包来处理 jwt v5 的错误
-	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	//"time" // 可能需要 time 来处理 jwt.ErrTokenNotValidYet
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
 	// --- 导入内部包 ---
 	"advertisement/internal/auth"   // 替换 "your_module_name"
+	"advertisement/internal/models"
+	"advertisement/internal/store"
 	"advertisement/internal/webutil" // 替换 "your_module_name"
 )
 
@@ -22,80 +23,156 @@ type contextKey string
 // UserContextKey 是用于在 context 中存储用户 Claims 的键
 const UserContextKey contextKey = "user"
 
-// AuthMiddleware 是检查 JWT 的认证中间件
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			webutil.RespondWithError(w, http.StatusUnauthorized, "缺少认证 Token") // 使用 webutil
-			return
-		}
+// AuthStore 是 AuthMiddleware 需要的存储接口 (store.Store 实现了它)
+type AuthStore interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	TouchAPIKey(ctx context.Context, keyID int64, now time.Time) error
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			webutil.RespondWithError(w, http.StatusUnauthorized, "认证 Token 格式错误 (应为 'Bearer <token>')") // 使用 webutil
-			return
-		}
-		tokenString := parts[1]
+// AuthMiddleware 返回认证中间件，支持两种凭证：
+//   - Authorization: Bearer <jwt>：校验签名和有效期，并按 jti 检查吊销列表 (登出后访问令牌立即失效)
+//   - Authorization: ApiKey <key>：校验 API Key 的哈希、有效期和吊销状态，并检查 scopes
+//
+// 两种方式都把 *auth.Claims 存入 context，后续 handler 不需要区分。
+// 必须启用两步验证但尚未绑定的账号只能拿到受限令牌，这里一律拒绝 (403)；已停用的账号同样返回 403。
+func AuthMiddleware(s AuthStore) func(http.Handler) http.Handler {
+	return authMiddleware(s, false)
+}
+
+// EnrollmentAuthMiddleware 与 AuthMiddleware 相同，但也接受只能用于绑定两步验证的受限令牌，
+// 只用于两步验证绑定相关的接口和登出
+func EnrollmentAuthMiddleware(s AuthStore) func(http.Handler) http.Handler {
+	return authMiddleware(s, true)
+}
 
-		claims := &auth.Claims{} // 使用 auth.Claims
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("非预期的签名算法: %v", token.Header["alg"])
+func authMiddleware(s AuthStore, allowEnrollment bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				webutil.RespondWithError(w, http.StatusUnauthorized, "缺少认证 Token") // 使用 webutil
+				return
 			}
-			return auth.JwtKey, nil // 使用 auth.JwtKey
-		})
 
-		if err != nil {
-			// 使用 errors.Is 来检查包装过的错误 (jwt v5 推荐)
-			if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
-   				// 在 v5 中，过期和尚未生效的错误可能都归类到 ErrTokenNotValidYet 或 ErrTokenExpired
-   				webutil.RespondWithError(w, http.StatusUnauthorized, "Token 已过期或无效")
-			} else if errors.Is(err, jwt.ErrSignatureInvalid) {
-                webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 Token 签名")
-            } else {
-				log.Printf("Token 解析错误: %v", err)
-				webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 Token") // 不要透露过多错误细节
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 {
+				webutil.RespondWithError(w, http.StatusUnauthorized, "认证 Token 格式错误 (应为 'Bearer <token>' 或 'ApiKey <key>')") // 使用 webutil
+				return
 			}
-			return
-		}
 
-		if !token.Valid {
-			webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 Token") // 使用 webutil
-			return
-		}
+			var claims *auth.Claims
+			var ok bool
+			switch strings.ToLower(parts[0]) {
+			case "bearer":
+				claims, ok = authenticateJWT(w, r, s, parts[1])
+			case "apikey":
+				claims, ok = authenticateAPIKey(w, r, s, parts[1])
+			default:
+				webutil.RespondWithError(w, http.StatusUnauthorized, "认证 Token 格式错误 (应为 'Bearer <token>' 或 'ApiKey <key>')")
+				return
+			}
+			if !ok {
+				return
+			}
+			if claims.Enrollment && !allowEnrollment {
+				webutil.RespondWithError(w, http.StatusForbidden, "账号必须先启用两步验证")
+				return
+			}
 
-		// Token 有效，将 Claims 存入 context
-		// 注意这里用的是包内定义的 contextKey 类型和导出的 UserContextKey 常量
-		ctx := context.WithValue(r.Context(), UserContextKey, claims)
+			// 凭证有效，将 Claims 存入 context
+			// 注意这里用的是包内定义的 contextKey 类型和导出的 UserContextKey 常量
+			ctx := context.WithValue(r.Context(), UserContextKey, claims)
 
-		// 使用带有新 context 的请求副本调用下一个 handler
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			// 使用带有新 context 的请求副本调用下一个 handler
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
-// --- 新增：AdminMiddleware 检查用户是否为管理员 ---
-func AdminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 假设此中间件在 AuthMiddleware 之后执行
-		// 从 Context 获取用户信息
-		userClaims, ok := r.Context().Value(UserContextKey).(*auth.Claims)
-		if !ok || userClaims == nil {
-			// 如果 AuthMiddleware 没有设置 Claims，或者类型不匹配，这是服务器内部错误
-			log.Println("错误：AdminMiddleware 无法从 context 获取有效的用户信息")
-			webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证用户权限")
-			return
+// authenticateJWT 校验访问令牌，失败时已写入响应并返回 false
+func authenticateJWT(w http.ResponseWriter, r *http.Request, s AuthStore, tokenString string) (*auth.Claims, bool) {
+	// 签名算法、kid 和有效期都由 auth.ParseJWT 校验 (HS256 或 RS256 / EdDSA 密钥环)
+	claims, err := auth.ParseJWT(tokenString)
+	if err != nil {
+		// 使用 errors.Is 来检查包装过的错误 (jwt v5 推荐)
+		if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
+			// 在 v5 中，过期和尚未生效的错误可能都归类到 ErrTokenNotValidYet 或 ErrTokenExpired
+			webutil.RespondWithError(w, http.StatusUnauthorized, "Token 已过期或无效")
+		} else if errors.Is(err, jwt.ErrSignatureInvalid) {
+			webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 Token 签名")
+		} else {
+			log.Printf("Token 解析错误: %v", err)
+			webutil.RespondWithError(w, http.StatusUnauthorized, "无效的 Token") // 不要透露过多错误细节
 		}
+		return nil, false
+	}
+
+	// 没有 jti 的旧 Token 无法吊销，要求重新登录
+	if claims.ID == "" {
+		webutil.RespondWithError(w, http.StatusUnauthorized, "Token 已失效，请重新登录")
+		return nil, false
+	}
+	revoked, err := s.IsTokenRevoked(r.Context(), claims.ID)
+	if err != nil {
+		log.Printf("检查 Token 吊销状态失败 (jti %s): %v", claims.ID, err)
+		webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证 Token")
+		return nil, false
+	}
+	if revoked {
+		webutil.RespondWithError(w, http.StatusUnauthorized, "Token 已被吊销，请重新登录")
+		return nil, false
+	}
 
-		// 检查用户角色
-		if userClaims.Role != "admin" {
-			log.Printf("权限不足：用户 %s (ID: %d, Role: %s) 尝试访问管理员接口", userClaims.Username, userClaims.UserID, userClaims.Role)
-			webutil.RespondWithError(w, http.StatusForbidden, "权限不足，需要管理员权限") // 返回 403 Forbidden
-			return
+	// 账号停用后立即生效，不等访问令牌过期
+	user, err := s.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			webutil.RespondWithError(w, http.StatusUnauthorized, "用户不存在，请重新登录")
+		} else {
+			log.Printf("认证时获取用户 %d 失败: %v", claims.UserID, err)
+			webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证 Token")
 		}
+		return nil, false
+	}
+	if rejectSuspended(w, user) {
+		return nil, false
+	}
+	return claims, true
+}
+
+// rejectSuspended 账号已停用时返回 403 并返回 true
+func rejectSuspended(w http.ResponseWriter, user *models.User) bool {
+	if user.SuspendedAt == nil {
+		return false
+	}
+	webutil.RespondWithError(w, http.StatusForbidden, "账号已被停用，请联系管理员")
+	return true
+}
 
-		// 用户是管理员，调用下一个 handler
-		log.Printf("管理员访问: %s (ID: %d)", userClaims.Username, userClaims.UserID)
-		next.ServeHTTP(w, r)
-	})
+// --- RequirePermission 检查用户角色是否拥有指定权限 ---
+// 必须在 AuthMiddleware 之后执行 (Auth 负责把 Claims 放入 context)
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userClaims, ok := r.Context().Value(UserContextKey).(*auth.Claims)
+			if !ok || userClaims == nil {
+				// 如果 AuthMiddleware 没有设置 Claims，或者类型不匹配，这是服务器内部错误
+				log.Println("错误：RequirePermission 无法从 context 获取有效的用户信息")
+				webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证用户权限")
+				return
+			}
+
+			if !auth.HasPermission(userClaims.Role, perm) {
+				log.Printf("权限不足：用户 %s (ID: %d, Role: %s) 尝试访问需要 %s 权限的接口 %s %s",
+					userClaims.Username, userClaims.UserID, userClaims.Role, perm, r.Method, r.URL.Path)
+				webutil.RespondWithError(w, http.StatusForbidden, "权限不足，需要 "+perm+" 权限")
+				return
+			}
+
+			log.Printf("后台访问: %s (ID: %d, Role: %s) %s %s", userClaims.Username, userClaims.UserID, userClaims.Role, r.Method, r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	}
 }
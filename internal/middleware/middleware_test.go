@@ -0,0 +1,47 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/middleware"
+)
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *auth.Claims // nil 表示 context 中没有用户 (没有经过 AuthMiddleware)
+		perm   string
+		status int
+	}{
+		{"superadmin", &auth.Claims{Role: auth.RoleSuperAdmin}, auth.PermUsersManage, http.StatusOK},
+		{"reviewer reviews ads", &auth.Claims{Role: auth.RoleReviewer}, auth.PermAdsReview, http.StatusOK},
+		{"reviewer cannot touch the ledger", &auth.Claims{Role: auth.RoleReviewer}, auth.PermLedgerWrite, http.StatusForbidden},
+		{"finance writes the ledger", &auth.Claims{Role: auth.RoleFinance}, auth.PermLedgerWrite, http.StatusOK},
+		{"support reads the ledger", &auth.Claims{Role: auth.RoleSupport}, auth.PermLedgerRead, http.StatusOK},
+		{"support cannot write the ledger", &auth.Claims{Role: auth.RoleSupport}, auth.PermLedgerWrite, http.StatusForbidden},
+		{"advertiser", &auth.Claims{Role: auth.RoleUser}, auth.PermCampaignsRead, http.StatusForbidden},
+		{"legacy admin role", &auth.Claims{Role: "admin"}, auth.PermAdsReview, http.StatusForbidden},
+		{"no user in context", nil, auth.PermAdsReview, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := middleware.RequirePermission(tt.perm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			r := httptest.NewRequest("GET", "/admin", nil)
+			if tt.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, tt.claims))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status || called != (tt.status == http.StatusOK) {
+				t.Errorf("status = %d, handler called = %v, want %d", w.Code, called, tt.status)
+			}
+		})
+	}
+}
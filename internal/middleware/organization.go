@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+	"advertisement/internal/webutil"
+)
+
+// OrganizationHeader 客户端选择当前组织的请求头，不提供时使用用户的默认组织
+const OrganizationHeader = "X-Organization-ID"
+
+// OrganizationContextKey 是用于在 context 中存储当前组织成员 (*models.OrganizationMember) 的键
+const OrganizationContextKey contextKey = "organization"
+
+// OrganizationStore 是 OrganizationMiddleware 需要的存储接口 (store.Store 实现了它)
+type OrganizationStore interface {
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	GetOrganizationMember(ctx context.Context, orgID int, userID int) (*models.OrganizationMember, error)
+}
+
+// OrganizationMiddleware 确定请求作用的组织并检查成员身份 (必须放在 AuthMiddleware 之后)：
+//   - 组织来自 X-Organization-ID 请求头，没有时使用用户的默认组织
+//   - 不是该组织成员返回 403 (不区分组织不存在)
+//   - viewer 只能执行 GET / HEAD 请求
+//
+// 成员信息 (组织 ID 和组织角色) 存入 context，handler 按组织读写资源。
+func OrganizationMiddleware(s OrganizationStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userClaims, ok := r.Context().Value(UserContextKey).(*auth.Claims)
+			if !ok || userClaims == nil {
+				log.Println("错误：OrganizationMiddleware 无法从 context 获取有效的用户信息")
+				webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证组织成员身份")
+				return
+			}
+
+			var orgID int
+			if header := r.Header.Get(OrganizationHeader); header != "" {
+				id, err := strconv.Atoi(header)
+				if err != nil || id <= 0 {
+					webutil.RespondWithError(w, http.StatusBadRequest, "无效的 "+OrganizationHeader)
+					return
+				}
+				orgID = id
+			} else {
+				user, err := s.GetUserByID(r.Context(), userClaims.UserID)
+				if err != nil {
+					log.Printf("获取用户 %d 的默认组织失败: %v", userClaims.UserID, err)
+					webutil.RespondWithError(w, http.StatusInternalServerError, "无法确定当前组织")
+					return
+				}
+				if user.DefaultOrganizationID == nil {
+					webutil.RespondWithError(w, http.StatusBadRequest, "没有默认组织，请通过 "+OrganizationHeader+" 指定组织")
+					return
+				}
+				orgID = *user.DefaultOrganizationID
+			}
+
+			member, err := s.GetOrganizationMember(r.Context(), orgID, userClaims.UserID)
+			if err != nil {
+				if errors.Is(err, store.ErrNotFound) {
+					webutil.RespondWithError(w, http.StatusForbidden, "不是该组织的成员")
+				} else {
+					log.Printf("查询用户 %d 在组织 %d 的成员身份失败: %v", userClaims.UserID, orgID, err)
+					webutil.RespondWithError(w, http.StatusInternalServerError, "无法验证组织成员身份")
+				}
+				return
+			}
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && !auth.CanWriteOrg(member.Role) {
+				webutil.RespondWithError(w, http.StatusForbidden, "组织角色 "+member.Role+" 只有只读权限")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), OrganizationContextKey, member)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader 是请求 ID 的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 与 audit_log.request_id 列的长度一致
+const maxRequestIDLength = 64
+
+// requestIDContextKey 是在 context 中存储请求 ID 的键
+const requestIDContextKey contextKey = "request_id"
+
+// RequestID 为每个请求确定一个请求 ID，存入 context 并通过 X-Request-ID 响应头返回 (审计日志按它关联请求)。
+// trustIncoming 为 true 时 (部署在反向代理之后，见 server.trust_proxy_headers) 沿用请求中格式有效的 X-Request-ID，
+// 否则总是生成新的 ID
+func RequestID(trustIncoming bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := ""
+			if trustIncoming {
+				if incoming := r.Header.Get(RequestIDHeader); validRequestID(incoming) {
+					id = incoming
+				}
+			}
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
+		})
+	}
+}
+
+// RequestIDFromContext 返回 RequestID 中间件确定的请求 ID，没有经过该中间件时返回空字符串
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// newRequestID 生成 32 个十六进制字符的随机 ID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID 只接受字母、数字和 "-_.:" 组成的 ID，避免把任意内容写进日志和审计记录
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,365 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFS 把 migrations 目录下的 SQL 文件编译进二进制，
+// 这样任何环境都可以直接用 `migrate up` 建出和 store.go 一致的表结构，不依赖外部文件。
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+// 文件命名规则: <version>_<name>.up.sql / <version>_<name>.down.sql
+// 例如 0001_create_core_tables.up.sql
+const (
+	upSuffix   = ".up.sql"
+	downSuffix = ".down.sql"
+)
+
+// Migration 代表一个版本的迁移 (一对 up/down 脚本)
+type Migration struct {
+	Version int64
+	Name    string
+	UpSQL   string
+	DownSQL string
+}
+
+// Status 代表某个迁移在当前数据库中的状态
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// Migrator 负责在指定数据库上执行迁移
+type Migrator struct {
+	db         *sql.DB
+	dialect    string // 对应 migrations/<dialect> 子目录，例如 "mysql"
+	migrations []Migration
+}
+
+// New 创建 Migrator 并加载对应方言的全部迁移脚本
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Load 从内嵌文件系统中读取某个方言的迁移脚本，按版本号升序返回
+func Load(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: 不支持的数据库方言 %q: %w", dialect, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fileName := entry.Name()
+		var direction string
+		var base string
+		switch {
+		case strings.HasSuffix(fileName, upSuffix):
+			direction, base = "up", strings.TrimSuffix(fileName, upSuffix)
+		case strings.HasSuffix(fileName, downSuffix):
+			direction, base = "down", strings.TrimSuffix(fileName, downSuffix)
+		default:
+			continue // 忽略其他文件
+		}
+
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migrate: 文件名格式错误 %s (应为 <version>_<name>.up.sql)", fileName)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrate: 文件名中的版本号无效 %s", fileName)
+		}
+
+		content, err := fs.ReadFile(migrationFS, path.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: 读取 %s 失败: %w", fileName, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migrate: 版本 %d 存在多个名称 (%s, %s)", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.UpSQL == "" {
+			return nil, fmt.Errorf("migrate: 版本 %d (%s) 缺少 up 脚本", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ensureVersionTable 创建 schema_migrations 表 (如果不存在)
+// 这里的 SQL 同时兼容 MySQL 和 SQLite
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	query := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version    BIGINT       NOT NULL PRIMARY KEY,
+            name       VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP    NOT NULL
+        )
+    `
+	if _, err := m.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("migrate: 创建 schema_migrations 表失败: %w", err)
+	}
+	return nil
+}
+
+// appliedVersions 返回已执行的版本及执行时间
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("migrate: 查询 schema_migrations 失败: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("migrate: 读取 schema_migrations 失败: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("migrate: 遍历 schema_migrations 失败: %w", err)
+	}
+	return applied, nil
+}
+
+// Up 按版本顺序执行所有尚未执行的迁移，返回本次执行的数量
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		log.Printf("migrate: 执行 %04d_%s (up)", mig.Version, mig.Name)
+		err := m.run(ctx, mig.UpSQL, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				mig.Version, mig.Name, time.Now())
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("migrate: 版本 %d (%s) 执行失败: %w", mig.Version, mig.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down 回滚最近执行的 steps 个迁移 (steps <= 0 时按 1 处理)，返回实际回滚的数量
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.DownSQL == "" {
+			return count, fmt.Errorf("migrate: 版本 %d (%s) 没有 down 脚本，无法回滚", mig.Version, mig.Name)
+		}
+		log.Printf("migrate: 回滚 %04d_%s (down)", mig.Version, mig.Name)
+		err := m.run(ctx, mig.DownSQL, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("migrate: 版本 %d (%s) 回滚失败: %w", mig.Version, mig.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Status 返回所有已知迁移的执行状态
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if at, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			appliedAt := at
+			st.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// run 在一个事务中逐条执行脚本中的语句，最后执行 record (写入/删除版本记录)
+// 注意：MySQL 的 DDL 语句会隐式提交事务，所以对 MySQL 来说这里的事务
+// 只能保证版本记录和 DML 的原子性；脚本应尽量保持幂等或足够小。
+func (m *Migrator) run(ctx context.Context, script string, record func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range SplitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%w\n--- 出错的语句 ---\n%s", err, stmt)
+		}
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SplitStatements 把脚本按分号拆成单条语句
+// (go-sql-driver/mysql 默认不允许一次执行多条语句)。
+// 会跳过 "--" 行注释和 "/* */" 块注释，并忽略引号和注释内的分号。
+func SplitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	var quote rune // 当前所在的引号类型，0 表示不在引号内
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote != 0 {
+			current.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			current.WriteRune(r)
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			// 跳过到行尾
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			// 跳过到 "*/" (没有闭合时跳过剩余部分)
+			i += 2
+			for i < len(runes) && !(runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/') {
+				i++
+			}
+			i++
+			current.WriteRune(' ')
+		case r == ';':
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+// ErrUnknownCommand 表示 migrate 子命令无法识别
+var ErrUnknownCommand = errors.New("migrate: unknown command")
+
+// RunCommand 执行 `migrate up|down [n]|status` 子命令，供 main 调用
+func RunCommand(ctx context.Context, m *Migrator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: 需要 up | down [n] | status", ErrUnknownCommand)
+	}
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("migrate: 完成，本次执行 %d 个迁移", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v <= 0 {
+				return fmt.Errorf("migrate: 无效的回滚步数 %q", args[1])
+			}
+			steps = v
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("migrate: 完成，本次回滚 %d 个迁移", n)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-8s %-40s %-8s %s\n", "VERSION", "NAME", "APPLIED", "APPLIED_AT")
+		for _, st := range statuses {
+			appliedAt := "-"
+			if st.AppliedAt != nil {
+				appliedAt = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-8d %-40s %-8t %s\n", st.Version, st.Name, st.Applied, appliedAt)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+	return nil
+}
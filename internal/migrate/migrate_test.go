@@ -0,0 +1,180 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"advertisement/internal/migrate"
+	"advertisement/internal/store"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"empty", "", nil},
+		{"only comments", "-- a; b\n/* c; d */\n", nil},
+		{"single without semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"multiple", "CREATE TABLE a (id INT);\n\nCREATE TABLE b (id INT);\n", []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"}},
+		{"empty statements", ";;SELECT 1;;", []string{"SELECT 1"}},
+		{"single quotes", "INSERT INTO t VALUES ('a;b');SELECT 2", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 2"}},
+		{"doubled quote", "SELECT 'it''s; fine';", []string{"SELECT 'it''s; fine'"}},
+		{"double quotes and backticks", "SELECT \"x;y\", `c;d` FROM t;", []string{"SELECT \"x;y\", `c;d` FROM t"}},
+		{"dashes inside quotes", "SELECT '--;';SELECT 2", []string{"SELECT '--;'", "SELECT 2"}},
+		{"line comment", "SELECT 1; -- trailing; comment\nSELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"comment inside statement", "CREATE TABLE t (\n  id INT -- primary; key\n);", []string{"CREATE TABLE t (\n  id INT \n)"}},
+		{"block comment", "SELECT /* a; b */ 1;SELECT 2", []string{"SELECT   1", "SELECT 2"}},
+		{"multi-line block comment", "/*\n * header;\n */\nSELECT 1;", []string{"SELECT 1"}},
+		{"unterminated block comment", "SELECT 1; /* ;", []string{"SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := migrate.SplitStatements(tt.script); !slices.Equal(got, tt.want) {
+				t.Errorf("SplitStatements(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	byDialect := map[string][]migrate.Migration{}
+	for _, dialect := range []string{"mysql", "sqlite"} {
+		migrations, err := migrate.Load(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("%s: no migrations", dialect)
+		}
+		for i, m := range migrations {
+			if m.Version != int64(i+1) {
+				t.Errorf("%s: migration %d has version %d, want %d (versions must be consecutive)", dialect, i, m.Version, i+1)
+			}
+			if m.UpSQL == "" || m.DownSQL == "" {
+				t.Errorf("%s: migration %d (%s) is missing its up or down script", dialect, m.Version, m.Name)
+			}
+			if len(migrate.SplitStatements(m.UpSQL)) == 0 {
+				t.Errorf("%s: migration %d (%s) has no statements", dialect, m.Version, m.Name)
+			}
+		}
+		byDialect[dialect] = migrations
+	}
+
+	// 两种方言的迁移必须一一对应
+	mysql, sqlite := byDialect["mysql"], byDialect["sqlite"]
+	if len(mysql) != len(sqlite) {
+		t.Fatalf("mysql has %d migrations, sqlite has %d", len(mysql), len(sqlite))
+	}
+	for i := range mysql {
+		if mysql[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d is %q for mysql but %q for sqlite", mysql[i].Version, mysql[i].Name, sqlite[i].Name)
+		}
+	}
+
+	if _, err := migrate.Load("oracle"); err == nil {
+		t.Error("Load(oracle) succeeded, want error")
+	}
+}
+
+// schema 返回数据库中所有表和索引的定义 (不含 schema_migrations)
+func schema(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT type || ' ' || name || ': ' || COALESCE(sql, '') FROM sqlite_master
+        WHERE name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations' ORDER BY type, name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var objects []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func applied(t *testing.T, m *migrate.Migrator) int {
+	t.Helper()
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, st := range statuses {
+		if st.Applied {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUpDownSQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := migrate.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := migrate.Load("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(migrations)
+
+	if n, err := m.Up(ctx); err != nil || n != total {
+		t.Fatalf("Up = %d, %v; want %d", n, err, total)
+	}
+	want := schema(t, db)
+	if len(want) == 0 {
+		t.Fatal("Up created no tables")
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Fatalf("second Up = %d, %v; want 0", n, err)
+	}
+
+	// 逐个回滚再执行最近的迁移，表结构回到相同的状态
+	if n, err := m.Down(ctx, 1); err != nil || n != 1 {
+		t.Fatalf("Down(1) = %d, %v; want 1", n, err)
+	}
+	if got := applied(t, m); got != total-1 {
+		t.Errorf("%d migrations applied after Down(1), want %d", got, total-1)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 1 {
+		t.Fatalf("Up after Down(1) = %d, %v; want 1", n, err)
+	}
+	if got := schema(t, db); !slices.Equal(got, want) {
+		t.Errorf("schema after down/up differs:\n got %q\nwant %q", got, want)
+	}
+
+	// 全部回滚后只剩 schema_migrations，再全部执行
+	if n, err := m.Down(ctx, total+1); err != nil || n != total {
+		t.Fatalf("Down(all) = %d, %v; want %d", n, err, total)
+	}
+	if got := schema(t, db); len(got) != 0 {
+		t.Errorf("objects left after Down(all): %q", got)
+	}
+	if n, err := m.Up(ctx); err != nil || n != total {
+		t.Fatalf("Up after Down(all) = %d, %v; want %d", n, err, total)
+	}
+	if got := schema(t, db); !slices.Equal(got, want) {
+		t.Errorf("schema after up/down/up differs:\n got %q\nwant %q", got, want)
+	}
+	if got := applied(t, m); got != total {
+		t.Errorf("%d migrations applied, want %d", got, total)
+	}
+}
@@ -0,0 +1,83 @@
+-- 初始表结构：与 internal/store/store.go 中 DBStore 使用的列保持一致
+
+CREATE TABLE users (
+    id            INT AUTO_INCREMENT PRIMARY KEY,
+    username      VARCHAR(64)  NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    role          VARCHAR(20)  NOT NULL DEFAULT 'user',
+    balance       BIGINT       NOT NULL DEFAULT 0, -- 单位：分
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_users_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE advertisements (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    title      VARCHAR(255)  NOT NULL,
+    image_url  VARCHAR(1024) NOT NULL,
+    target_url VARCHAR(1024) NOT NULL,
+    user_id    INT           NOT NULL,
+    status     VARCHAR(20)   NOT NULL DEFAULT 'Pending', -- Pending, Approved, Rejected
+    created_at TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    KEY idx_advertisements_user (user_id),
+    KEY idx_advertisements_status (status),
+    CONSTRAINT fk_advertisements_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ad_campaigns (
+    id               INT AUTO_INCREMENT PRIMARY KEY,
+    advertisement_id INT         NOT NULL,
+    user_id          INT         NOT NULL,
+    start_date       DATE        NOT NULL,
+    end_date         DATE        NOT NULL,
+    status           VARCHAR(20) NOT NULL DEFAULT 'Pending',
+    created_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    KEY idx_ad_campaigns_user (user_id),
+    KEY idx_ad_campaigns_status_dates (status, start_date, end_date),
+    CONSTRAINT fk_ad_campaigns_advertisement FOREIGN KEY (advertisement_id) REFERENCES advertisements (id),
+    CONSTRAINT fk_ad_campaigns_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE recharge_transactions (
+    id             BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id        INT          NOT NULL,
+    amount         BIGINT       NOT NULL, -- 单位：分
+    status         VARCHAR(20)  NOT NULL DEFAULT 'Pending', -- Pending, Success, Failed
+    transaction_id VARCHAR(128) NULL,
+    payment_method VARCHAR(50)  NOT NULL,
+    created_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_recharge_transactions_user_created (user_id, created_at),
+    CONSTRAINT fk_recharge_transactions_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ad_events (
+    id               BIGINT AUTO_INCREMENT PRIMARY KEY,
+    event_type       VARCHAR(20) NOT NULL, -- Impression, Click
+    advertisement_id INT         NOT NULL,
+    campaign_id      INT         NOT NULL,
+    user_id          INT         NOT NULL, -- 活动创建者的 ID
+    event_timestamp  DATETIME(3) NOT NULL,
+    KEY idx_ad_events_user_time (user_id, event_timestamp),
+    KEY idx_ad_events_campaign (campaign_id, advertisement_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE invoice_requests (
+    id                   BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id              INT          NOT NULL,
+    status               VARCHAR(20)  NOT NULL DEFAULT 'Pending',
+    invoice_period_start DATE         NOT NULL,
+    invoice_period_end   DATE         NOT NULL,
+    total_amount         BIGINT       NOT NULL, -- 单位：分
+    billing_title        VARCHAR(255) NOT NULL,
+    tax_id               VARCHAR(64)  NULL,
+    billing_address      VARCHAR(512) NOT NULL,
+    invoice_number       VARCHAR(64)  NULL,
+    notes                TEXT         NULL,
+    requested_at         DATETIME     NOT NULL,
+    processed_at         DATETIME     NULL,
+    updated_at           TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    KEY idx_invoice_requests_user_requested (user_id, requested_at),
+    CONSTRAINT fk_invoice_requests_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,18 @@
+-- 广告活动状态变更历史
+-- Pending -> Approved -> Active -> Completed，另外还有 Paused / Cancelled / Rejected
+
+CREATE TABLE campaign_status_history (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    campaign_id INT          NOT NULL,
+    from_status VARCHAR(20)  NULL,     -- 创建活动时为 NULL
+    to_status   VARCHAR(20)  NOT NULL,
+    changed_by  INT          NULL,     -- 操作人，调度器等系统操作为 NULL
+    reason      VARCHAR(255) NOT NULL DEFAULT '',
+    changed_at  DATETIME(3)  NOT NULL,
+    KEY idx_campaign_status_history_campaign (campaign_id, changed_at),
+    CONSTRAINT fk_campaign_status_history_campaign FOREIGN KEY (campaign_id) REFERENCES ad_campaigns (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 为已存在的活动补一条初始记录
+INSERT INTO campaign_status_history (campaign_id, from_status, to_status, changed_by, reason, changed_at)
+SELECT id, NULL, status, NULL, 'backfill', created_at FROM ad_campaigns;
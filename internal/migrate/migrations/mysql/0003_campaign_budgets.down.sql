@@ -0,0 +1,14 @@
+ALTER TABLE ad_events
+    DROP INDEX uk_ad_events_click_token,
+    DROP COLUMN click_token,
+    DROP COLUMN cost;
+
+ALTER TABLE ad_campaigns
+    DROP COLUMN charge_remainder,
+    DROP COLUMN spent_today_date,
+    DROP COLUMN spent_today,
+    DROP COLUMN spent_total,
+    DROP COLUMN daily_budget,
+    DROP COLUMN total_budget,
+    DROP COLUMN bid_amount,
+    DROP COLUMN pricing_model;
@@ -0,0 +1,18 @@
+-- 广告活动出价、预算和消耗 (金额单位均为分)
+-- 已存在的活动 bid_amount = 0、预算 = 0 (不限)，即不扣费
+
+ALTER TABLE ad_campaigns
+    ADD COLUMN pricing_model    VARCHAR(10) NOT NULL DEFAULT 'CPM', -- CPM | CPC
+    ADD COLUMN bid_amount       BIGINT      NOT NULL DEFAULT 0,     -- CPM: 每千次展示价格；CPC: 每次点击价格
+    ADD COLUMN total_budget     BIGINT      NOT NULL DEFAULT 0,     -- 0 表示不限
+    ADD COLUMN daily_budget     BIGINT      NOT NULL DEFAULT 0,     -- 0 表示不限
+    ADD COLUMN spent_total      BIGINT      NOT NULL DEFAULT 0,
+    ADD COLUMN spent_today      BIGINT      NOT NULL DEFAULT 0,     -- 仅当 spent_today_date 为今天时有效
+    ADD COLUMN spent_today_date DATE        NULL,
+    ADD COLUMN charge_remainder BIGINT      NOT NULL DEFAULT 0;     -- CPM 未满 1 分的累计消耗，单位千分之一分
+
+-- click_token: Click 事件的点击凭证 (GET /get-ad 签发) 随机值，唯一索引保证同一个凭证最多计费一次
+ALTER TABLE ad_events
+    ADD COLUMN cost        BIGINT   NOT NULL DEFAULT 0, -- 本次事件实际扣费
+    ADD COLUMN click_token CHAR(32) NULL,
+    ADD UNIQUE KEY uk_ad_events_click_token (click_token);
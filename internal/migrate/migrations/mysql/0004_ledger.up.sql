@@ -0,0 +1,63 @@
+-- 复式记账账本：账户 / 分录 / 过账
+-- users.balance 保留为钱包账户余额的缓存值，可通过 GET /admin/ledger/reconcile 核对
+
+CREATE TABLE ledger_accounts (
+    id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    code       VARCHAR(64) NOT NULL,  -- platform:cash、user:<id>:wallet ...
+    type       VARCHAR(20) NOT NULL,  -- asset / liability / revenue / expense / equity
+    user_id    INT         NULL,      -- 钱包账户所属用户，平台账户为 NULL
+    created_at DATETIME(3) NOT NULL,
+    UNIQUE KEY uk_ledger_accounts_code (code),
+    KEY idx_ledger_accounts_user (user_id),
+    CONSTRAINT fk_ledger_accounts_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE journal_entries (
+    id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    kind       VARCHAR(32)  NOT NULL,
+    user_id    INT          NULL,      -- 关联的广告主
+    reference  VARCHAR(128) NULL,      -- 业务引用 (recharge:<id>、ad_event:<id>)，防止重复过账
+    memo       VARCHAR(255) NOT NULL DEFAULT '',
+    created_by INT          NULL,      -- 操作人，系统过账为 NULL
+    created_at DATETIME(3)  NOT NULL,
+    UNIQUE KEY uk_journal_entries_reference (reference),
+    KEY idx_journal_entries_user (user_id, id),
+    CONSTRAINT fk_journal_entries_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ledger_postings (
+    id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    entry_id   BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    amount     BIGINT NOT NULL,  -- 单位：分；正数借方，负数贷方
+    KEY idx_ledger_postings_entry (entry_id),
+    KEY idx_ledger_postings_account (account_id),
+    CONSTRAINT fk_ledger_postings_entry FOREIGN KEY (entry_id) REFERENCES journal_entries (id),
+    CONSTRAINT fk_ledger_postings_account FOREIGN KEY (account_id) REFERENCES ledger_accounts (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO ledger_accounts (code, type, user_id, created_at) VALUES
+    ('platform:cash', 'asset', NULL, NOW(3)),
+    ('platform:ad_revenue', 'revenue', NULL, NOW(3)),
+    ('platform:promotions', 'expense', NULL, NOW(3)),
+    ('platform:adjustments', 'equity', NULL, NOW(3));
+
+-- 已有余额转为期初余额分录: 借 platform:adjustments，贷 用户钱包
+INSERT INTO ledger_accounts (code, type, user_id, created_at)
+SELECT CONCAT('user:', id, ':wallet'), 'liability', id, NOW(3) FROM users;
+
+INSERT INTO journal_entries (kind, user_id, reference, memo, created_by, created_at)
+SELECT 'opening_balance', id, CONCAT('opening:', id), 'balance before ledger', NULL, NOW(3)
+FROM users WHERE balance <> 0;
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, -u.balance
+FROM users u
+JOIN journal_entries e ON e.reference = CONCAT('opening:', u.id)
+JOIN ledger_accounts a ON a.code = CONCAT('user:', u.id, ':wallet');
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, u.balance
+FROM users u
+JOIN journal_entries e ON e.reference = CONCAT('opening:', u.id)
+JOIN ledger_accounts a ON a.code = 'platform:adjustments';
@@ -0,0 +1,3 @@
+ALTER TABLE recharge_transactions
+    DROP INDEX uk_recharge_transactions_provider_ref,
+    DROP COLUMN provider_ref;
@@ -0,0 +1,4 @@
+-- 支付渠道的支付单号 (创建支付意图时写入，回调和主动查询时使用)
+ALTER TABLE recharge_transactions
+    ADD COLUMN provider_ref VARCHAR(128) NULL AFTER payment_method,
+    ADD UNIQUE KEY uk_recharge_transactions_provider_ref (payment_method, provider_ref);
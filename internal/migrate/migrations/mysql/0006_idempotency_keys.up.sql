@@ -0,0 +1,13 @@
+-- 幂等键：同一用户重复提交带相同 Idempotency-Key 的请求时重放第一次的响应
+CREATE TABLE idempotency_keys (
+    user_id       INT          NOT NULL,
+    idem_key      VARCHAR(255) NOT NULL,
+    request_hash  CHAR(64)     NOT NULL,  -- SHA-256(方法 + 路径 + 请求体)
+    status_code   INT          NULL,      -- NULL 表示原请求仍在处理中
+    response_body MEDIUMBLOB   NULL,
+    created_at    DATETIME(3)  NOT NULL,
+    completed_at  DATETIME(3)  NULL,
+    PRIMARY KEY (user_id, idem_key),
+    KEY idx_idempotency_keys_created (created_at),
+    CONSTRAINT fk_idempotency_keys_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,28 @@
+-- 刷新令牌：短期访问令牌过期后用刷新令牌换取新的令牌对，每次刷新都会轮换
+CREATE TABLE refresh_tokens (
+    id                BIGINT      NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id           INT         NOT NULL,
+    token_hash        CHAR(64)    NOT NULL,  -- SHA-256(令牌明文)，不保存明文
+    session_id        CHAR(32)    NOT NULL,  -- 同一次登录轮换出的令牌共享
+    access_jti        CHAR(32)    NOT NULL,  -- 一起签发的访问令牌
+    access_expires_at DATETIME(3) NOT NULL,
+    expires_at        DATETIME(3) NOT NULL,
+    created_at        DATETIME(3) NOT NULL,
+    revoked_at        DATETIME(3) NULL,
+    UNIQUE KEY uk_refresh_tokens_hash (token_hash),
+    KEY idx_refresh_tokens_user (user_id, revoked_at),
+    KEY idx_refresh_tokens_session (session_id),
+    KEY idx_refresh_tokens_access_jti (access_jti),
+    KEY idx_refresh_tokens_expires (expires_at),
+    CONSTRAINT fk_refresh_tokens_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 访问令牌吊销列表：按 jti 记录，过期后可以清理
+CREATE TABLE revoked_tokens (
+    jti        CHAR(32)    NOT NULL PRIMARY KEY,
+    user_id    INT         NOT NULL,
+    expires_at DATETIME(3) NOT NULL,  -- 访问令牌本身的过期时间，之后该记录不再需要
+    revoked_at DATETIME(3) NOT NULL,
+    KEY idx_revoked_tokens_expires (expires_at),
+    CONSTRAINT fk_revoked_tokens_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,16 @@
+-- API Key：脚本和集成使用 Authorization: ApiKey <key> 认证，不需要用户名密码
+CREATE TABLE api_keys (
+    id           BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id      INT          NOT NULL,
+    name         VARCHAR(100) NOT NULL,
+    prefix       CHAR(8)      NOT NULL,  -- 界面上展示、认证时用于查找
+    secret_hash  CHAR(64)     NOT NULL,  -- SHA-256(完整 Key)，不保存明文
+    scopes       VARCHAR(255) NOT NULL DEFAULT '',  -- 逗号分隔，空表示不限制
+    expires_at   DATETIME(3)  NULL,
+    last_used_at DATETIME(3)  NULL,
+    created_at   DATETIME(3)  NOT NULL,
+    revoked_at   DATETIME(3)  NULL,
+    UNIQUE KEY uk_api_keys_prefix (prefix),
+    KEY idx_api_keys_user (user_id),
+    CONSTRAINT fk_api_keys_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
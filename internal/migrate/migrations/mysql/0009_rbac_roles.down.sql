@@ -0,0 +1,3 @@
+-- 旧版本只认识 admin：superadmin 恢复为 admin，其他后台角色降为普通用户
+UPDATE users SET role = 'admin' WHERE role = 'superadmin';
+UPDATE users SET role = 'user' WHERE role IN ('reviewer', 'finance', 'support');
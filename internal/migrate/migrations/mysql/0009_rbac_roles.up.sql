@@ -0,0 +1,3 @@
+-- 细粒度角色：原来的 admin 拥有所有后台权限，对应新的 superadmin
+-- 其他角色 (reviewer / finance / support) 由 superadmin 通过 PUT /admin/users/{id}/role 分配
+UPDATE users SET role = 'superadmin' WHERE role = 'admin';
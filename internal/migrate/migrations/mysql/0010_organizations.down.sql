@@ -0,0 +1,53 @@
+-- 回滚到按用户归属：个人组织的数据回到其用户名下，共享组织的余额和钱包账户无法还原
+
+ALTER TABLE ledger_accounts
+    ADD COLUMN user_id INT NULL AFTER type,
+    ADD KEY idx_ledger_accounts_user (user_id),
+    ADD CONSTRAINT fk_ledger_accounts_user FOREIGN KEY (user_id) REFERENCES users (id);
+UPDATE ledger_accounts a
+JOIN users u ON u.default_organization_id = a.organization_id
+SET a.user_id = u.id, a.code = CONCAT('user:', u.id, ':wallet')
+WHERE a.type = 'liability';
+ALTER TABLE ledger_accounts
+    DROP FOREIGN KEY fk_ledger_accounts_org,
+    DROP KEY idx_ledger_accounts_org,
+    DROP COLUMN organization_id;
+
+ALTER TABLE journal_entries
+    DROP FOREIGN KEY fk_journal_entries_org,
+    DROP KEY idx_journal_entries_org,
+    DROP COLUMN organization_id;
+
+ALTER TABLE ad_events
+    DROP KEY idx_ad_events_org_time,
+    DROP COLUMN organization_id;
+
+ALTER TABLE invoice_requests
+    DROP FOREIGN KEY fk_invoice_requests_org,
+    DROP KEY idx_invoice_requests_org_requested,
+    DROP COLUMN organization_id;
+
+ALTER TABLE recharge_transactions
+    DROP FOREIGN KEY fk_recharge_transactions_org,
+    DROP KEY idx_recharge_transactions_org_created,
+    DROP COLUMN organization_id;
+
+ALTER TABLE ad_campaigns
+    DROP FOREIGN KEY fk_ad_campaigns_org,
+    DROP KEY idx_ad_campaigns_org,
+    DROP COLUMN organization_id;
+
+ALTER TABLE advertisements
+    DROP FOREIGN KEY fk_advertisements_org,
+    DROP KEY idx_advertisements_org,
+    DROP COLUMN organization_id;
+
+ALTER TABLE users ADD COLUMN balance BIGINT NOT NULL DEFAULT 0 AFTER role;
+UPDATE users u JOIN organizations o ON o.id = u.default_organization_id SET u.balance = o.balance;
+ALTER TABLE users
+    DROP FOREIGN KEY fk_users_default_organization,
+    DROP COLUMN default_organization_id;
+
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
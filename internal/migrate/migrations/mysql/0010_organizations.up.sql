@@ -0,0 +1,104 @@
+-- 组织：多个成员共享广告创意、广告活动、充值、发票和余额
+-- 资源表保留 user_id (创建者)，归属改为 organization_id；余额从 users.balance 移到 organizations.balance
+
+CREATE TABLE organizations (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    name       VARCHAR(100) NOT NULL,
+    balance    BIGINT       NOT NULL DEFAULT 0, -- 单位：分，钱包账户 org:<id>:wallet 的缓存值
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE organization_members (
+    organization_id INT         NOT NULL,
+    user_id         INT         NOT NULL,
+    role            VARCHAR(20) NOT NULL, -- owner / manager / viewer
+    created_at      DATETIME(3) NOT NULL,
+    PRIMARY KEY (organization_id, user_id),
+    KEY idx_organization_members_user (user_id),
+    CONSTRAINT fk_organization_members_org FOREIGN KEY (organization_id) REFERENCES organizations (id),
+    CONSTRAINT fk_organization_members_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE organization_invitations (
+    id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+    organization_id INT         NOT NULL,
+    user_id         INT         NOT NULL, -- 被邀请的用户
+    role            VARCHAR(20) NOT NULL,
+    invited_by      INT         NOT NULL,
+    status          VARCHAR(20) NOT NULL DEFAULT 'Pending', -- Pending / Accepted / Declined / Revoked
+    created_at      DATETIME(3) NOT NULL,
+    expires_at      DATETIME(3) NOT NULL,
+    responded_at    DATETIME(3) NULL,
+    KEY idx_organization_invitations_user (user_id, status),
+    KEY idx_organization_invitations_org (organization_id, status),
+    CONSTRAINT fk_organization_invitations_org FOREIGN KEY (organization_id) REFERENCES organizations (id),
+    CONSTRAINT fk_organization_invitations_user FOREIGN KEY (user_id) REFERENCES users (id),
+    CONSTRAINT fk_organization_invitations_inviter FOREIGN KEY (invited_by) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 每个已有用户一个个人组织 (ID 与用户 ID 相同，方便回填)，余额一并迁移
+INSERT INTO organizations (id, name, balance, created_at)
+SELECT id, username, balance, created_at FROM users;
+
+INSERT INTO organization_members (organization_id, user_id, role, created_at)
+SELECT id, id, 'owner', NOW(3) FROM users;
+
+-- 未指定 X-Organization-ID 时使用的组织 (注册时创建的个人组织)
+ALTER TABLE users
+    ADD COLUMN default_organization_id INT NULL,
+    ADD CONSTRAINT fk_users_default_organization FOREIGN KEY (default_organization_id) REFERENCES organizations (id);
+UPDATE users SET default_organization_id = id;
+ALTER TABLE users DROP COLUMN balance;
+
+-- 资源归属
+ALTER TABLE advertisements ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE advertisements SET organization_id = user_id;
+ALTER TABLE advertisements
+    MODIFY organization_id INT NOT NULL,
+    ADD KEY idx_advertisements_org (organization_id),
+    ADD CONSTRAINT fk_advertisements_org FOREIGN KEY (organization_id) REFERENCES organizations (id);
+
+ALTER TABLE ad_campaigns ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE ad_campaigns SET organization_id = user_id;
+ALTER TABLE ad_campaigns
+    MODIFY organization_id INT NOT NULL,
+    ADD KEY idx_ad_campaigns_org (organization_id),
+    ADD CONSTRAINT fk_ad_campaigns_org FOREIGN KEY (organization_id) REFERENCES organizations (id);
+
+ALTER TABLE recharge_transactions ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE recharge_transactions SET organization_id = user_id;
+ALTER TABLE recharge_transactions
+    MODIFY organization_id INT NOT NULL,
+    ADD KEY idx_recharge_transactions_org_created (organization_id, created_at),
+    ADD CONSTRAINT fk_recharge_transactions_org FOREIGN KEY (organization_id) REFERENCES organizations (id);
+
+ALTER TABLE invoice_requests ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE invoice_requests SET organization_id = user_id;
+ALTER TABLE invoice_requests
+    MODIFY organization_id INT NOT NULL,
+    ADD KEY idx_invoice_requests_org_requested (organization_id, requested_at),
+    ADD CONSTRAINT fk_invoice_requests_org FOREIGN KEY (organization_id) REFERENCES organizations (id);
+
+ALTER TABLE ad_events ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE ad_events SET organization_id = user_id;
+ALTER TABLE ad_events
+    MODIFY organization_id INT NOT NULL,
+    ADD KEY idx_ad_events_org_time (organization_id, event_timestamp);
+
+-- 账本：钱包账户归属组织 (user:<id>:wallet -> org:<id>:wallet)，分录的 user_id 保留为关联的成员
+ALTER TABLE journal_entries ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE journal_entries SET organization_id = user_id;
+ALTER TABLE journal_entries
+    ADD KEY idx_journal_entries_org (organization_id, id),
+    ADD CONSTRAINT fk_journal_entries_org FOREIGN KEY (organization_id) REFERENCES organizations (id);
+
+ALTER TABLE ledger_accounts ADD COLUMN organization_id INT NULL AFTER user_id;
+UPDATE ledger_accounts
+SET organization_id = user_id, code = CONCAT('org:', user_id, ':wallet')
+WHERE type = 'liability' AND user_id IS NOT NULL;
+ALTER TABLE ledger_accounts
+    DROP FOREIGN KEY fk_ledger_accounts_user,
+    DROP KEY idx_ledger_accounts_user,
+    DROP COLUMN user_id,
+    ADD KEY idx_ledger_accounts_org (organization_id),
+    ADD CONSTRAINT fk_ledger_accounts_org FOREIGN KEY (organization_id) REFERENCES organizations (id);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS user_tokens;
+ALTER TABLE users
+    DROP INDEX uk_users_email,
+    DROP COLUMN email_verified_at,
+    DROP COLUMN email;
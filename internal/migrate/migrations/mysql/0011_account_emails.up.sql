@@ -0,0 +1,37 @@
+-- 邮箱验证、找回密码和邮件发件箱
+-- 已有用户没有邮箱 (email 为 NULL)，不需要验证即可登录；新注册的用户必须先验证邮箱
+ALTER TABLE users
+    ADD COLUMN email VARCHAR(255) NULL AFTER username,
+    ADD COLUMN email_verified_at DATETIME(3) NULL AFTER email,
+    ADD UNIQUE KEY uk_users_email (email);
+
+-- 一次性令牌 (邮箱验证 / 重置密码)，只保存哈希
+CREATE TABLE user_tokens (
+    id         BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT          NOT NULL,
+    purpose    VARCHAR(20)  NOT NULL,  -- verify_email | reset_password
+    token_hash CHAR(64)     NOT NULL,  -- SHA-256(令牌)
+    email      VARCHAR(255) NOT NULL,  -- 令牌发往的邮箱，邮箱变化后令牌失效
+    created_at DATETIME(3)  NOT NULL,
+    expires_at DATETIME(3)  NOT NULL,
+    used_at    DATETIME(3)  NULL,
+    UNIQUE KEY uk_user_tokens_hash (token_hash),
+    KEY idx_user_tokens_user (user_id, purpose),
+    KEY idx_user_tokens_expires (expires_at),
+    CONSTRAINT fk_user_tokens_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 邮件发件箱：业务操作在同一个事务中写入邮件，由后台投递器通过 Mailer 发送并重试
+CREATE TABLE email_outbox (
+    id              BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    recipient       VARCHAR(255) NOT NULL,
+    subject         VARCHAR(255) NOT NULL,
+    body            TEXT         NOT NULL,
+    status          VARCHAR(20)  NOT NULL DEFAULT 'Pending',  -- Pending | Sent | Failed
+    attempts        INT          NOT NULL DEFAULT 0,
+    last_error      VARCHAR(1000) NULL,
+    created_at      DATETIME(3)  NOT NULL,
+    next_attempt_at DATETIME(3)  NOT NULL,
+    sent_at         DATETIME(3)  NULL,
+    KEY idx_email_outbox_due (status, next_attempt_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,32 @@
+-- 两步验证 (TOTP)：密钥、启用时间、防重放的最后使用时间步，以及账号级别的"必须启用两步验证"开关
+-- totp_secret 不为空但 totp_enabled_at 为空表示正在绑定 (还没有用验证码确认)
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64) NULL,
+    ADD COLUMN totp_enabled_at DATETIME(3) NULL,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN two_factor_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- 恢复码 (丢失验证器时代替验证码登录)，每个只能使用一次，只保存哈希
+CREATE TABLE recovery_codes (
+    id         BIGINT      NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT         NOT NULL,
+    code_hash  CHAR(64)    NOT NULL,  -- SHA-256(规范化后的恢复码)
+    created_at DATETIME(3) NOT NULL,
+    used_at    DATETIME(3) NULL,
+    UNIQUE KEY uk_recovery_codes_user_hash (user_id, code_hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 两步登录的挑战：密码正确后签发，凭它和验证码换取访问令牌
+CREATE TABLE login_challenges (
+    id         BIGINT      NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT         NOT NULL,
+    token_hash CHAR(64)    NOT NULL,  -- SHA-256(mfa_token)
+    attempts   INT         NOT NULL DEFAULT 0,  -- 验证码错误次数
+    created_at DATETIME(3) NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    used_at    DATETIME(3) NULL,
+    UNIQUE KEY uk_login_challenges_hash (token_hash),
+    KEY idx_login_challenges_expires (expires_at),
+    CONSTRAINT fk_login_challenges_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,10 @@
+-- 登录失败计数 (login_throttle.backend=database)：按 throttle_key 记录连续失败次数和锁定时间
+-- throttle_key 形如 "user:<用户名>" 或 "ip:<客户端 IP>"；expires_at 之后整行失效，由调度器清理
+CREATE TABLE login_attempts (
+    throttle_key    VARCHAR(191) NOT NULL PRIMARY KEY,
+    failures        INT          NOT NULL DEFAULT 0,
+    last_failure_at DATETIME(3)  NOT NULL,
+    locked_until    DATETIME(3)  NULL,
+    expires_at      DATETIME(3)  NOT NULL,
+    KEY idx_login_attempts_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
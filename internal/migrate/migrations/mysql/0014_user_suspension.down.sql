@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN suspension_reason,
+    DROP COLUMN suspended_at;
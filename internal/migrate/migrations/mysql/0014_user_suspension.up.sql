@@ -0,0 +1,5 @@
+-- 账号停用：停用后不能登录、已签发的凭证立即失效，创建的广告活动不再投放
+-- suspended_at 为空表示账号正常
+ALTER TABLE users
+    ADD COLUMN suspended_at DATETIME(3) NULL,
+    ADD COLUMN suspension_reason VARCHAR(255) NULL;
@@ -0,0 +1,20 @@
+-- 审计日志：后台审核、状态变更、余额变动、发票处理、登录等操作，只追加不修改
+-- before_value / after_value 为 JSON 文本；系统操作 (调度器、支付回调) 的 actor_id 为空
+CREATE TABLE audit_log (
+    id             BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    created_at     DATETIME(3)  NOT NULL,
+    actor_id       INT          NULL,
+    actor_username VARCHAR(64)  NOT NULL DEFAULT '', -- 登录失败时为尝试的用户名
+    actor_role     VARCHAR(20)  NOT NULL DEFAULT '',
+    action         VARCHAR(64)  NOT NULL,            -- 例如 ad.review、ledger.entry、auth.login
+    target_type    VARCHAR(32)  NOT NULL DEFAULT '',
+    target_id      VARCHAR(64)  NOT NULL DEFAULT '',
+    before_value   TEXT         NULL,
+    after_value    TEXT         NULL,
+    ip             VARCHAR(64)  NOT NULL DEFAULT '',
+    request_id     VARCHAR(64)  NOT NULL DEFAULT '',
+    KEY idx_audit_log_target (target_type, target_id, id),
+    KEY idx_audit_log_actor (actor_id, id),
+    KEY idx_audit_log_action (action, id),
+    KEY idx_audit_log_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
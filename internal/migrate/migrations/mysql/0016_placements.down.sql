@@ -0,0 +1,10 @@
+ALTER TABLE ad_events
+    DROP KEY idx_ad_events_placement_time,
+    DROP COLUMN placement_id;
+
+ALTER TABLE advertisements
+    DROP COLUMN format,
+    DROP COLUMN height,
+    DROP COLUMN width;
+
+DROP TABLE IF EXISTS placements;
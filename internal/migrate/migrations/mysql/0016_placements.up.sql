@@ -0,0 +1,26 @@
+-- 广告位：管理员定义的投放位置，限定尺寸、允许的创意格式和底价
+-- formats 为逗号分隔的格式列表 (image,html5,video)；floor_price 为每千次展示底价 (分)，0 表示不限
+CREATE TABLE placements (
+    id          INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name        VARCHAR(100) NOT NULL,
+    width       INT          NOT NULL,
+    height      INT          NOT NULL,
+    formats     VARCHAR(64)  NOT NULL,
+    floor_price BIGINT       NOT NULL DEFAULT 0,
+    status      VARCHAR(20)  NOT NULL DEFAULT 'Active', -- Active | Disabled
+    created_by  INT          NULL,
+    created_at  DATETIME(3)  NOT NULL,
+    updated_at  DATETIME(3)  NOT NULL,
+    KEY idx_placements_size (width, height)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 广告创意声明的尺寸和格式；已存在的创意尺寸为 0 (未声明)，不会投放到任何广告位
+ALTER TABLE advertisements
+    ADD COLUMN width  INT         NOT NULL DEFAULT 0,
+    ADD COLUMN height INT         NOT NULL DEFAULT 0,
+    ADD COLUMN format VARCHAR(20) NOT NULL DEFAULT 'image';
+
+-- 展示和点击发生的广告位，不带 placement 参数的请求为空
+ALTER TABLE ad_events
+    ADD COLUMN placement_id INT NULL,
+    ADD KEY idx_ad_events_placement_time (placement_id, event_timestamp);
@@ -0,0 +1,3 @@
+-- 广告活动定向条件：国家/地区、设备类型、操作系统、语言和投放时段，JSON 格式，NULL 表示不限
+ALTER TABLE ad_campaigns
+    ADD COLUMN targeting TEXT NULL AFTER daily_budget;
@@ -0,0 +1,6 @@
+ALTER TABLE ad_events
+    DROP COLUMN clearing_price;
+
+ALTER TABLE ad_campaigns
+    DROP COLUMN clicks,
+    DROP COLUMN impressions;
@@ -0,0 +1,11 @@
+-- 竞价投放：活动的展示 / 点击计数 (预估 CPC 活动的点击率) 和 Impression 事件的成交价
+ALTER TABLE ad_campaigns
+    ADD COLUMN impressions BIGINT NOT NULL DEFAULT 0, -- 计费成功的展示次数
+    ADD COLUMN clicks      BIGINT NOT NULL DEFAULT 0; -- 计费成功的点击次数
+
+UPDATE ad_campaigns camp
+SET impressions = (SELECT COUNT(*) FROM ad_events e WHERE e.campaign_id = camp.id AND e.event_type = 'Impression'),
+    clicks      = (SELECT COUNT(*) FROM ad_events e WHERE e.campaign_id = camp.id AND e.event_type = 'Click');
+
+ALTER TABLE ad_events
+    ADD COLUMN clearing_price BIGINT NULL; -- 竞价成交价 (分 / 千次展示)，只有 Impression 事件有
@@ -0,0 +1,2 @@
+ALTER TABLE ad_campaigns
+    DROP COLUMN frequency_caps;
@@ -0,0 +1,3 @@
+-- 广告活动频次上限：每个访问者在时间窗口内最多看到的次数，JSON 数组，NULL 表示不限
+ALTER TABLE ad_campaigns
+    ADD COLUMN frequency_caps TEXT NULL AFTER targeting;
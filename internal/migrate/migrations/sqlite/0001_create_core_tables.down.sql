@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS invoice_requests;
+DROP TABLE IF EXISTS ad_events;
+DROP TABLE IF EXISTS recharge_transactions;
+DROP TABLE IF EXISTS ad_campaigns;
+DROP TABLE IF EXISTS advertisements;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,78 @@
+-- 初始表结构 (SQLite 版本)，列与 mysql/0001_create_core_tables.up.sql 一一对应
+-- SQLite 没有 ON UPDATE CURRENT_TIMESTAMP，updated_at 由 DBStore 显式写入
+
+CREATE TABLE users (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    username      VARCHAR(64)  NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    role          VARCHAR(20)  NOT NULL DEFAULT 'user',
+    balance       BIGINT       NOT NULL DEFAULT 0, -- 单位：分
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE advertisements (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    title      VARCHAR(255)  NOT NULL,
+    image_url  VARCHAR(1024) NOT NULL,
+    target_url VARCHAR(1024) NOT NULL,
+    user_id    INTEGER       NOT NULL REFERENCES users (id),
+    status     VARCHAR(20)   NOT NULL DEFAULT 'Pending',
+    created_at TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_advertisements_user ON advertisements (user_id);
+CREATE INDEX idx_advertisements_status ON advertisements (status);
+
+CREATE TABLE ad_campaigns (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    advertisement_id INTEGER     NOT NULL REFERENCES advertisements (id),
+    user_id          INTEGER     NOT NULL REFERENCES users (id),
+    start_date       DATE        NOT NULL,
+    end_date         DATE        NOT NULL,
+    status           VARCHAR(20) NOT NULL DEFAULT 'Pending',
+    created_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_ad_campaigns_user ON ad_campaigns (user_id);
+CREATE INDEX idx_ad_campaigns_status_dates ON ad_campaigns (status, start_date, end_date);
+
+CREATE TABLE recharge_transactions (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id        INTEGER      NOT NULL REFERENCES users (id),
+    amount         BIGINT       NOT NULL, -- 单位：分
+    status         VARCHAR(20)  NOT NULL DEFAULT 'Pending',
+    transaction_id VARCHAR(128) NULL,
+    payment_method VARCHAR(50)  NOT NULL,
+    created_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_recharge_transactions_user_created ON recharge_transactions (user_id, created_at);
+
+CREATE TABLE ad_events (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type       VARCHAR(20) NOT NULL,
+    advertisement_id INTEGER     NOT NULL,
+    campaign_id      INTEGER     NOT NULL,
+    user_id          INTEGER     NOT NULL,
+    event_timestamp  DATETIME    NOT NULL
+);
+CREATE INDEX idx_ad_events_user_time ON ad_events (user_id, event_timestamp);
+CREATE INDEX idx_ad_events_campaign ON ad_events (campaign_id, advertisement_id);
+
+CREATE TABLE invoice_requests (
+    id                   INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id              INTEGER      NOT NULL REFERENCES users (id),
+    status               VARCHAR(20)  NOT NULL DEFAULT 'Pending',
+    invoice_period_start DATE         NOT NULL,
+    invoice_period_end   DATE         NOT NULL,
+    total_amount         BIGINT       NOT NULL, -- 单位：分
+    billing_title        VARCHAR(255) NOT NULL,
+    tax_id               VARCHAR(64)  NULL,
+    billing_address      VARCHAR(512) NOT NULL,
+    invoice_number       VARCHAR(64)  NULL,
+    notes                TEXT         NULL,
+    requested_at         DATETIME     NOT NULL,
+    processed_at         DATETIME     NULL,
+    updated_at           TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_invoice_requests_user_requested ON invoice_requests (user_id, requested_at);
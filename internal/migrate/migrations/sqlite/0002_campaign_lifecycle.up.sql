@@ -0,0 +1,16 @@
+-- 广告活动状态变更历史 (SQLite 版本)，列与 mysql/0002_campaign_lifecycle.up.sql 一一对应
+
+CREATE TABLE campaign_status_history (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    campaign_id INTEGER      NOT NULL REFERENCES ad_campaigns (id),
+    from_status VARCHAR(20)  NULL,
+    to_status   VARCHAR(20)  NOT NULL,
+    changed_by  INTEGER      NULL,
+    reason      VARCHAR(255) NOT NULL DEFAULT '',
+    changed_at  TIMESTAMP    NOT NULL
+);
+CREATE INDEX idx_campaign_status_history_campaign ON campaign_status_history (campaign_id, changed_at);
+
+-- 为已存在的活动补一条初始记录
+INSERT INTO campaign_status_history (campaign_id, from_status, to_status, changed_by, reason, changed_at)
+SELECT id, NULL, status, NULL, 'backfill', created_at FROM ad_campaigns;
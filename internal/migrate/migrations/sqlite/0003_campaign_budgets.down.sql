@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS uk_ad_events_click_token;
+ALTER TABLE ad_events DROP COLUMN click_token;
+ALTER TABLE ad_events DROP COLUMN cost;
+
+ALTER TABLE ad_campaigns DROP COLUMN charge_remainder;
+ALTER TABLE ad_campaigns DROP COLUMN spent_today_date;
+ALTER TABLE ad_campaigns DROP COLUMN spent_today;
+ALTER TABLE ad_campaigns DROP COLUMN spent_total;
+ALTER TABLE ad_campaigns DROP COLUMN daily_budget;
+ALTER TABLE ad_campaigns DROP COLUMN total_budget;
+ALTER TABLE ad_campaigns DROP COLUMN bid_amount;
+ALTER TABLE ad_campaigns DROP COLUMN pricing_model;
@@ -0,0 +1,15 @@
+-- 广告活动出价、预算和消耗 (SQLite 版本)，列与 mysql/0003_campaign_budgets.up.sql 一一对应
+-- SQLite 的 ALTER TABLE 每次只能添加一列
+
+ALTER TABLE ad_campaigns ADD COLUMN pricing_model VARCHAR(10) NOT NULL DEFAULT 'CPM';
+ALTER TABLE ad_campaigns ADD COLUMN bid_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_campaigns ADD COLUMN total_budget BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_campaigns ADD COLUMN daily_budget BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_campaigns ADD COLUMN spent_total BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_campaigns ADD COLUMN spent_today BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_campaigns ADD COLUMN spent_today_date DATE NULL;
+ALTER TABLE ad_campaigns ADD COLUMN charge_remainder BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE ad_events ADD COLUMN cost BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_events ADD COLUMN click_token CHAR(32) NULL;
+CREATE UNIQUE INDEX uk_ad_events_click_token ON ad_events (click_token);
@@ -0,0 +1,56 @@
+-- 复式记账账本 (SQLite 版本)，与 mysql/0004_ledger.up.sql 一一对应
+
+CREATE TABLE ledger_accounts (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    code       VARCHAR(64) NOT NULL UNIQUE,
+    type       VARCHAR(20) NOT NULL,
+    user_id    INTEGER     NULL REFERENCES users (id),
+    created_at TIMESTAMP   NOT NULL
+);
+CREATE INDEX idx_ledger_accounts_user ON ledger_accounts (user_id);
+
+CREATE TABLE journal_entries (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind       VARCHAR(32)  NOT NULL,
+    user_id    INTEGER      NULL REFERENCES users (id),
+    reference  VARCHAR(128) NULL UNIQUE,
+    memo       VARCHAR(255) NOT NULL DEFAULT '',
+    created_by INTEGER      NULL,
+    created_at TIMESTAMP    NOT NULL
+);
+CREATE INDEX idx_journal_entries_user ON journal_entries (user_id, id);
+
+CREATE TABLE ledger_postings (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id   INTEGER NOT NULL REFERENCES journal_entries (id),
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts (id),
+    amount     BIGINT  NOT NULL
+);
+CREATE INDEX idx_ledger_postings_entry ON ledger_postings (entry_id);
+CREATE INDEX idx_ledger_postings_account ON ledger_postings (account_id);
+
+INSERT INTO ledger_accounts (code, type, user_id, created_at) VALUES
+    ('platform:cash', 'asset', NULL, CURRENT_TIMESTAMP),
+    ('platform:ad_revenue', 'revenue', NULL, CURRENT_TIMESTAMP),
+    ('platform:promotions', 'expense', NULL, CURRENT_TIMESTAMP),
+    ('platform:adjustments', 'equity', NULL, CURRENT_TIMESTAMP);
+
+-- 已有余额转为期初余额分录: 借 platform:adjustments，贷 用户钱包
+INSERT INTO ledger_accounts (code, type, user_id, created_at)
+SELECT 'user:' || id || ':wallet', 'liability', id, CURRENT_TIMESTAMP FROM users;
+
+INSERT INTO journal_entries (kind, user_id, reference, memo, created_by, created_at)
+SELECT 'opening_balance', id, 'opening:' || id, 'balance before ledger', NULL, CURRENT_TIMESTAMP
+FROM users WHERE balance <> 0;
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, -u.balance
+FROM users u
+JOIN journal_entries e ON e.reference = 'opening:' || u.id
+JOIN ledger_accounts a ON a.code = 'user:' || u.id || ':wallet';
+
+INSERT INTO ledger_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, u.balance
+FROM users u
+JOIN journal_entries e ON e.reference = 'opening:' || u.id
+JOIN ledger_accounts a ON a.code = 'platform:adjustments';
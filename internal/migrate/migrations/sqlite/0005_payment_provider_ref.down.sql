@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uk_recharge_transactions_provider_ref;
+ALTER TABLE recharge_transactions DROP COLUMN provider_ref;
@@ -0,0 +1,3 @@
+-- 支付渠道的支付单号 (SQLite 版本)，与 mysql/0005_payment_provider_ref.up.sql 一一对应
+ALTER TABLE recharge_transactions ADD COLUMN provider_ref VARCHAR(128) NULL;
+CREATE UNIQUE INDEX uk_recharge_transactions_provider_ref ON recharge_transactions (payment_method, provider_ref);
@@ -0,0 +1,12 @@
+-- 幂等键 (SQLite 版本)，与 mysql/0006_idempotency_keys.up.sql 一一对应
+CREATE TABLE idempotency_keys (
+    user_id       INTEGER      NOT NULL REFERENCES users (id),
+    idem_key      VARCHAR(255) NOT NULL,
+    request_hash  CHAR(64)     NOT NULL,
+    status_code   INTEGER      NULL,
+    response_body BLOB         NULL,
+    created_at    TIMESTAMP    NOT NULL,
+    completed_at  TIMESTAMP    NULL,
+    PRIMARY KEY (user_id, idem_key)
+);
+CREATE INDEX idx_idempotency_keys_created ON idempotency_keys (created_at);
@@ -0,0 +1,24 @@
+-- 刷新令牌和访问令牌吊销列表 (SQLite 版本)，与 mysql/0007_refresh_tokens.up.sql 一一对应
+CREATE TABLE refresh_tokens (
+    id                INTEGER   PRIMARY KEY AUTOINCREMENT,
+    user_id           INTEGER   NOT NULL REFERENCES users (id),
+    token_hash        CHAR(64)  NOT NULL UNIQUE,
+    session_id        CHAR(32)  NOT NULL,
+    access_jti        CHAR(32)  NOT NULL,
+    access_expires_at TIMESTAMP NOT NULL,
+    expires_at        TIMESTAMP NOT NULL,
+    created_at        TIMESTAMP NOT NULL,
+    revoked_at        TIMESTAMP NULL
+);
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens (user_id, revoked_at);
+CREATE INDEX idx_refresh_tokens_session ON refresh_tokens (session_id);
+CREATE INDEX idx_refresh_tokens_access_jti ON refresh_tokens (access_jti);
+CREATE INDEX idx_refresh_tokens_expires ON refresh_tokens (expires_at);
+
+CREATE TABLE revoked_tokens (
+    jti        CHAR(32)  NOT NULL PRIMARY KEY,
+    user_id    INTEGER   NOT NULL REFERENCES users (id),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_revoked_tokens_expires ON revoked_tokens (expires_at);
@@ -0,0 +1,14 @@
+-- API Key (SQLite 版本)，与 mysql/0008_api_keys.up.sql 一一对应
+CREATE TABLE api_keys (
+    id           INTEGER      PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER      NOT NULL REFERENCES users (id),
+    name         VARCHAR(100) NOT NULL,
+    prefix       CHAR(8)      NOT NULL UNIQUE,
+    secret_hash  CHAR(64)     NOT NULL,
+    scopes       VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at   TIMESTAMP    NULL,
+    last_used_at TIMESTAMP    NULL,
+    created_at   TIMESTAMP    NOT NULL,
+    revoked_at   TIMESTAMP    NULL
+);
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
@@ -0,0 +1,2 @@
+UPDATE users SET role = 'admin' WHERE role = 'superadmin';
+UPDATE users SET role = 'user' WHERE role IN ('reviewer', 'finance', 'support');
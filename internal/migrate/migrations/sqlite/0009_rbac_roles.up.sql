@@ -0,0 +1,2 @@
+-- 细粒度角色 (SQLite 版本)，与 mysql/0009_rbac_roles.up.sql 一一对应
+UPDATE users SET role = 'superadmin' WHERE role = 'admin';
@@ -0,0 +1,36 @@
+-- 回滚到按用户归属 (SQLite 版本)：个人组织的数据回到其用户名下，共享组织的余额和钱包账户无法还原
+
+ALTER TABLE ledger_accounts ADD COLUMN user_id INTEGER NULL REFERENCES users (id);
+UPDATE ledger_accounts
+SET user_id = (SELECT u.id FROM users u WHERE u.default_organization_id = ledger_accounts.organization_id)
+WHERE type = 'liability';
+UPDATE ledger_accounts SET code = 'user:' || user_id || ':wallet' WHERE type = 'liability' AND user_id IS NOT NULL;
+CREATE INDEX idx_ledger_accounts_user ON ledger_accounts (user_id);
+DROP INDEX idx_ledger_accounts_org;
+ALTER TABLE ledger_accounts DROP COLUMN organization_id;
+
+DROP INDEX idx_journal_entries_org;
+ALTER TABLE journal_entries DROP COLUMN organization_id;
+
+DROP INDEX idx_ad_events_org_time;
+ALTER TABLE ad_events DROP COLUMN organization_id;
+
+DROP INDEX idx_invoice_requests_org_requested;
+ALTER TABLE invoice_requests DROP COLUMN organization_id;
+
+DROP INDEX idx_recharge_transactions_org_created;
+ALTER TABLE recharge_transactions DROP COLUMN organization_id;
+
+DROP INDEX idx_ad_campaigns_org;
+ALTER TABLE ad_campaigns DROP COLUMN organization_id;
+
+DROP INDEX idx_advertisements_org;
+ALTER TABLE advertisements DROP COLUMN organization_id;
+
+ALTER TABLE users ADD COLUMN balance BIGINT NOT NULL DEFAULT 0;
+UPDATE users SET balance = COALESCE((SELECT o.balance FROM organizations o WHERE o.id = users.default_organization_id), 0);
+ALTER TABLE users DROP COLUMN default_organization_id;
+
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,74 @@
+-- 组织 (SQLite 版本)，与 mysql/0010_organizations.up.sql 一一对应
+-- SQLite 不能修改已有列的约束，资源表的 organization_id 保持 NULL 约束，由应用保证总是写入
+
+CREATE TABLE organizations (
+    id         INTEGER      PRIMARY KEY AUTOINCREMENT,
+    name       VARCHAR(100) NOT NULL,
+    balance    BIGINT       NOT NULL DEFAULT 0,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE organization_members (
+    organization_id INTEGER     NOT NULL REFERENCES organizations (id),
+    user_id         INTEGER     NOT NULL REFERENCES users (id),
+    role            VARCHAR(20) NOT NULL,
+    created_at      TIMESTAMP   NOT NULL,
+    PRIMARY KEY (organization_id, user_id)
+);
+CREATE INDEX idx_organization_members_user ON organization_members (user_id);
+
+CREATE TABLE organization_invitations (
+    id              INTEGER     PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER     NOT NULL REFERENCES organizations (id),
+    user_id         INTEGER     NOT NULL REFERENCES users (id),
+    role            VARCHAR(20) NOT NULL,
+    invited_by      INTEGER     NOT NULL REFERENCES users (id),
+    status          VARCHAR(20) NOT NULL DEFAULT 'Pending',
+    created_at      TIMESTAMP   NOT NULL,
+    expires_at      TIMESTAMP   NOT NULL,
+    responded_at    TIMESTAMP   NULL
+);
+CREATE INDEX idx_organization_invitations_user ON organization_invitations (user_id, status);
+CREATE INDEX idx_organization_invitations_org ON organization_invitations (organization_id, status);
+
+INSERT INTO organizations (id, name, balance, created_at)
+SELECT id, username, balance, created_at FROM users;
+
+INSERT INTO organization_members (organization_id, user_id, role, created_at)
+SELECT id, id, 'owner', CURRENT_TIMESTAMP FROM users;
+
+ALTER TABLE users ADD COLUMN default_organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE users SET default_organization_id = id;
+ALTER TABLE users DROP COLUMN balance;
+
+ALTER TABLE advertisements ADD COLUMN organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE advertisements SET organization_id = user_id;
+CREATE INDEX idx_advertisements_org ON advertisements (organization_id);
+
+ALTER TABLE ad_campaigns ADD COLUMN organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE ad_campaigns SET organization_id = user_id;
+CREATE INDEX idx_ad_campaigns_org ON ad_campaigns (organization_id);
+
+ALTER TABLE recharge_transactions ADD COLUMN organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE recharge_transactions SET organization_id = user_id;
+CREATE INDEX idx_recharge_transactions_org_created ON recharge_transactions (organization_id, created_at);
+
+ALTER TABLE invoice_requests ADD COLUMN organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE invoice_requests SET organization_id = user_id;
+CREATE INDEX idx_invoice_requests_org_requested ON invoice_requests (organization_id, requested_at);
+
+ALTER TABLE ad_events ADD COLUMN organization_id INTEGER NULL;
+UPDATE ad_events SET organization_id = user_id;
+CREATE INDEX idx_ad_events_org_time ON ad_events (organization_id, event_timestamp);
+
+ALTER TABLE journal_entries ADD COLUMN organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE journal_entries SET organization_id = user_id;
+CREATE INDEX idx_journal_entries_org ON journal_entries (organization_id, id);
+
+ALTER TABLE ledger_accounts ADD COLUMN organization_id INTEGER NULL REFERENCES organizations (id);
+UPDATE ledger_accounts
+SET organization_id = user_id, code = 'org:' || user_id || ':wallet'
+WHERE type = 'liability' AND user_id IS NOT NULL;
+DROP INDEX idx_ledger_accounts_user;
+ALTER TABLE ledger_accounts DROP COLUMN user_id;
+CREATE INDEX idx_ledger_accounts_org ON ledger_accounts (organization_id);
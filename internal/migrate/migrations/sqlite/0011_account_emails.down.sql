@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS user_tokens;
+DROP INDEX IF EXISTS uk_users_email;
+ALTER TABLE users DROP COLUMN email_verified_at;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,31 @@
+-- 邮箱验证、找回密码和邮件发件箱 (SQLite 版本)，与 mysql/0011_account_emails.up.sql 一一对应
+ALTER TABLE users ADD COLUMN email VARCHAR(255) NULL;
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP NULL;
+CREATE UNIQUE INDEX uk_users_email ON users (email);
+
+CREATE TABLE user_tokens (
+    id         INTEGER      PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER      NOT NULL REFERENCES users (id),
+    purpose    VARCHAR(20)  NOT NULL,
+    token_hash CHAR(64)     NOT NULL UNIQUE,
+    email      VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP    NOT NULL,
+    expires_at TIMESTAMP    NOT NULL,
+    used_at    TIMESTAMP    NULL
+);
+CREATE INDEX idx_user_tokens_user ON user_tokens (user_id, purpose);
+CREATE INDEX idx_user_tokens_expires ON user_tokens (expires_at);
+
+CREATE TABLE email_outbox (
+    id              INTEGER       PRIMARY KEY AUTOINCREMENT,
+    recipient       VARCHAR(255)  NOT NULL,
+    subject         VARCHAR(255)  NOT NULL,
+    body            TEXT          NOT NULL,
+    status          VARCHAR(20)   NOT NULL DEFAULT 'Pending',
+    attempts        INTEGER       NOT NULL DEFAULT 0,
+    last_error      VARCHAR(1000) NULL,
+    created_at      TIMESTAMP     NOT NULL,
+    next_attempt_at TIMESTAMP     NOT NULL,
+    sent_at         TIMESTAMP     NULL
+);
+CREATE INDEX idx_email_outbox_due ON email_outbox (status, next_attempt_at);
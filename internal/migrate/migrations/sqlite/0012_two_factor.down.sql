@@ -0,0 +1,7 @@
+-- 两步验证回滚 (SQLite 版本)，与 mysql/0012_two_factor.down.sql 一一对应
+DROP TABLE IF EXISTS login_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN two_factor_required;
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_enabled_at;
+ALTER TABLE users DROP COLUMN totp_secret;
@@ -0,0 +1,25 @@
+-- 两步验证 (SQLite 版本)，与 mysql/0012_two_factor.up.sql 一一对应
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP NULL;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN two_factor_required BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER   NOT NULL REFERENCES users (id),
+    code_hash  CHAR(64)  NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP NULL,
+    UNIQUE (user_id, code_hash)
+);
+
+CREATE TABLE login_challenges (
+    id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER   NOT NULL REFERENCES users (id),
+    token_hash CHAR(64)  NOT NULL UNIQUE,
+    attempts   INTEGER   NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP NULL
+);
+CREATE INDEX idx_login_challenges_expires ON login_challenges (expires_at);
@@ -0,0 +1,2 @@
+-- 登录失败计数回滚 (SQLite 版本)，与 mysql/0013_login_attempts.down.sql 一一对应
+DROP TABLE IF EXISTS login_attempts;
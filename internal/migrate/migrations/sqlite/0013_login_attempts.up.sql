@@ -0,0 +1,9 @@
+-- 登录失败计数 (SQLite 版本)，与 mysql/0013_login_attempts.up.sql 一一对应
+CREATE TABLE login_attempts (
+    throttle_key    VARCHAR(191) NOT NULL PRIMARY KEY,
+    failures        INTEGER      NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP    NOT NULL,
+    locked_until    TIMESTAMP    NULL,
+    expires_at      TIMESTAMP    NOT NULL
+);
+CREATE INDEX idx_login_attempts_expires ON login_attempts (expires_at);
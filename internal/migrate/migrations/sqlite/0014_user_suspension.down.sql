@@ -0,0 +1,3 @@
+-- 账号停用回滚 (SQLite 版本)，与 mysql/0014_user_suspension.down.sql 一一对应
+ALTER TABLE users DROP COLUMN suspension_reason;
+ALTER TABLE users DROP COLUMN suspended_at;
@@ -0,0 +1,3 @@
+-- 账号停用 (SQLite 版本)，与 mysql/0014_user_suspension.up.sql 一一对应
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP NULL;
+ALTER TABLE users ADD COLUMN suspension_reason VARCHAR(255) NULL;
@@ -0,0 +1,2 @@
+-- 审计日志回滚 (SQLite 版本)，与 mysql/0015_audit_log.down.sql 一一对应
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,19 @@
+-- 审计日志 (SQLite 版本)，与 mysql/0015_audit_log.up.sql 一一对应
+CREATE TABLE audit_log (
+    id             INTEGER     PRIMARY KEY AUTOINCREMENT,
+    created_at     TIMESTAMP   NOT NULL,
+    actor_id       INTEGER     NULL,
+    actor_username VARCHAR(64) NOT NULL DEFAULT '',
+    actor_role     VARCHAR(20) NOT NULL DEFAULT '',
+    action         VARCHAR(64) NOT NULL,
+    target_type    VARCHAR(32) NOT NULL DEFAULT '',
+    target_id      VARCHAR(64) NOT NULL DEFAULT '',
+    before_value   TEXT        NULL,
+    after_value    TEXT        NULL,
+    ip             VARCHAR(64) NOT NULL DEFAULT '',
+    request_id     VARCHAR(64) NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_audit_log_target ON audit_log (target_type, target_id, id);
+CREATE INDEX idx_audit_log_actor ON audit_log (actor_id, id);
+CREATE INDEX idx_audit_log_action ON audit_log (action, id);
+CREATE INDEX idx_audit_log_created ON audit_log (created_at);
@@ -0,0 +1,9 @@
+-- 广告位回滚 (SQLite 版本)，与 mysql/0016_placements.down.sql 一一对应
+DROP INDEX idx_ad_events_placement_time;
+ALTER TABLE ad_events DROP COLUMN placement_id;
+
+ALTER TABLE advertisements DROP COLUMN format;
+ALTER TABLE advertisements DROP COLUMN height;
+ALTER TABLE advertisements DROP COLUMN width;
+
+DROP TABLE IF EXISTS placements;
@@ -0,0 +1,21 @@
+-- 广告位 (SQLite 版本)，与 mysql/0016_placements.up.sql 一一对应
+CREATE TABLE placements (
+    id          INTEGER      PRIMARY KEY AUTOINCREMENT,
+    name        VARCHAR(100) NOT NULL,
+    width       INTEGER      NOT NULL,
+    height      INTEGER      NOT NULL,
+    formats     VARCHAR(64)  NOT NULL,
+    floor_price BIGINT       NOT NULL DEFAULT 0,
+    status      VARCHAR(20)  NOT NULL DEFAULT 'Active',
+    created_by  INTEGER      NULL,
+    created_at  TIMESTAMP    NOT NULL,
+    updated_at  TIMESTAMP    NOT NULL
+);
+CREATE INDEX idx_placements_size ON placements (width, height);
+
+ALTER TABLE advertisements ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE advertisements ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE advertisements ADD COLUMN format VARCHAR(20) NOT NULL DEFAULT 'image';
+
+ALTER TABLE ad_events ADD COLUMN placement_id INTEGER NULL;
+CREATE INDEX idx_ad_events_placement_time ON ad_events (placement_id, event_timestamp);
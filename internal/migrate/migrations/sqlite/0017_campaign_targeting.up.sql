@@ -0,0 +1,2 @@
+-- 广告活动定向条件 (SQLite 版本)，与 mysql/0017_campaign_targeting.up.sql 一一对应
+ALTER TABLE ad_campaigns ADD COLUMN targeting TEXT NULL;
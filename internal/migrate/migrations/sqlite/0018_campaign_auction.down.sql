@@ -0,0 +1,5 @@
+-- 竞价投放回滚 (SQLite 版本)，与 mysql/0018_campaign_auction.down.sql 一一对应
+ALTER TABLE ad_events DROP COLUMN clearing_price;
+
+ALTER TABLE ad_campaigns DROP COLUMN clicks;
+ALTER TABLE ad_campaigns DROP COLUMN impressions;
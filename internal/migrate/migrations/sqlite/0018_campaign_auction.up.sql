@@ -0,0 +1,9 @@
+-- 竞价投放 (SQLite 版本)，与 mysql/0018_campaign_auction.up.sql 一一对应
+ALTER TABLE ad_campaigns ADD COLUMN impressions BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE ad_campaigns ADD COLUMN clicks BIGINT NOT NULL DEFAULT 0;
+
+UPDATE ad_campaigns
+SET impressions = (SELECT COUNT(*) FROM ad_events e WHERE e.campaign_id = ad_campaigns.id AND e.event_type = 'Impression'),
+    clicks      = (SELECT COUNT(*) FROM ad_events e WHERE e.campaign_id = ad_campaigns.id AND e.event_type = 'Click');
+
+ALTER TABLE ad_events ADD COLUMN clearing_price BIGINT NULL;
@@ -0,0 +1,2 @@
+-- 广告活动频次上限 (SQLite 版本)，与 mysql/0019_campaign_frequency_caps.up.sql 一一对应
+ALTER TABLE ad_campaigns ADD COLUMN frequency_caps TEXT NULL;
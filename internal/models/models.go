@@ -1,7 +1,13 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
+
+	"advertisement/internal/money"
 )
 
 // Advertisement 代表广告数据模型
@@ -11,13 +17,18 @@ type Advertisement struct {
 	ImageURL  string `json:"image_url"`
 	TargetURL string `json:"target_url"`
 	UserID    int    `json:"user_id"` // <-- 新增: 关联的用户 ID
+	OrganizationID int `json:"organization_id"` // 所属组织
 	Status    string `json:"status"`  // <-- 新增: 广告状态 (Pending, Approved, Rejected)
+	Width     int    `json:"width"`   // 声明的尺寸 (像素)，0 表示未声明 (旧数据)，不会投放到任何广告位
+	Height    int    `json:"height"`
+	Format    string `json:"format"`  // 创意格式 (image / html5 / video)
 }
 
 // UserCredentials 代表用户登录/注册时使用的凭证结构
 type UserCredentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"` // 注册时必填，登录时忽略
 }
 
 // --- 新增：User 代表从数据库获取的用户信息 ---
@@ -26,7 +37,16 @@ type User struct {
 	Username     string `json:"username"`
 	PasswordHash string `json:"-"`
 	Role         string `json:"role"` // <-- 新增 Role 字段
-	Balance		 int64  	`json:"balance"`
+	DefaultOrganizationID *int `json:"default_organization_id"` // 未指定 X-Organization-ID 时使用的组织
+	Email           *string    `json:"email"`             // 已统一为小写，旧用户可能没有邮箱
+	EmailVerifiedAt *time.Time `json:"email_verified_at"` // nil 表示邮箱未验证
+	TwoFactorRequired bool       `json:"two_factor_required"` // 账号设置：登录必须通过两步验证
+	TOTPEnabledAt     *time.Time `json:"totp_enabled_at"`     // nil 表示尚未绑定验证器
+	TOTPSecret        string     `json:"-"`                   // Base32 密钥；已生成但未启用时 TOTPEnabledAt 为 nil
+	TOTPLastStep      int64      `json:"-"`                   // 最近一次使用的时间步，防止验证码重放
+	SuspendedAt      *time.Time `json:"suspended_at"`      // nil 表示账号正常；停用后不能登录，创建的广告活动不再投放
+	SuspensionReason *string    `json:"suspension_reason"` // 停用原因
+	CreatedAt        time.Time  `json:"created_at"`
 }
 
 // AdCampaign 代表一个广告活动请求或实例
@@ -34,37 +54,82 @@ type AdCampaign struct {
 	ID             int       `json:"id"`
 	AdvertisementID int       `json:"advertisement_id"`
 	UserID         int       `json:"user_id"`
+	OrganizationID int       `json:"organization_id"`
 	StartDate      time.Time `json:"start_date"` // 使用 time.Time 处理日期
 	EndDate        time.Time `json:"end_date"`   // 使用 time.Time 处理日期
 	Status         string    `json:"status"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	CampaignBudget
+	Targeting      CampaignTargeting `json:"targeting"` // 定向条件，为空表示面向所有访问者
+	FrequencyCaps  FrequencyCaps     `json:"frequency_caps"` // 每个访问者的展示次数上限，为空表示不限
 
 	// 可以选择性地嵌入关联的 Advertisement 信息，如果 API 需要返回
 	// Advertisement *Advertisement `json:"advertisement,omitempty"`
 }
 
+// --- 广告活动计费方式 ---
+const (
+	PricingCPM = "CPM" // 按千次展示计费，每次展示扣 bid_amount / 1000
+	PricingCPC = "CPC" // 按点击计费，每次点击扣 bid_amount
+)
+
+// CampaignBudget 广告活动的出价、预算和消耗，嵌入到 AdCampaign / CampaignWithAdDetails 中
+type CampaignBudget struct {
+	PricingModel string      `json:"pricing_model"` // CPM | CPC
+	BidAmount    money.Money `json:"bid_amount"`    // CPM: 每千次展示价格；CPC: 每次点击价格
+	TotalBudget  money.Money `json:"total_budget"`  // 总预算，0 表示不限
+	DailyBudget  money.Money `json:"daily_budget"`  // 每日预算，0 表示不限
+	SpentTotal   money.Money `json:"spent_total"`   // 累计消耗
+	SpentToday   money.Money `json:"spent_today"`   // 今日消耗
+}
+
+// --- 广告活动生命周期状态 ---
+// Pending -> Approved -> Active -> Completed，另外还有 Paused、Cancelled、Rejected。
+// 允许的状态转换由 store 层强制校验 (见 store/lifecycle.go)。
+const (
+	CampaignStatusPending   = "Pending"   // 等待管理员审核
+	CampaignStatusApproved  = "Approved"  // 已批准，等待到达开始日期
+	CampaignStatusActive    = "Active"    // 投放中
+	CampaignStatusPaused    = "Paused"    // 用户暂停
+	CampaignStatusCompleted = "Completed" // 已过结束日期，正常结束
+	CampaignStatusCancelled = "Cancelled" // 用户取消
+	CampaignStatusRejected  = "Rejected"  // 管理员拒绝
+)
+
+// CampaignStatusChange 代表一次广告活动状态变更记录 (campaign_status_history 表)
+type CampaignStatusChange struct {
+	ID         int64     `json:"id"`
+	CampaignID int       `json:"campaign_id"`
+	FromStatus *string   `json:"from_status"` // 创建活动时为 nil
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  *int      `json:"changed_by"` // 操作人用户 ID，调度器等系统操作为 nil
+	Reason     string    `json:"reason"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
 // --- 新增：RechargeTransaction 代表充值记录 ---
 type RechargeTransaction struct {
 	ID             int64     `json:"id"`
-	UserID         int       `json:"user_id"`
-	Amount         int64     `json:"amount"`         // 单位：分
+	UserID         int       `json:"user_id"` // 发起充值的成员
+	OrganizationID int       `json:"organization_id"`
+	Amount         money.Money `json:"amount"`
 	Status         string    `json:"status"`
 	TransactionID  *string   `json:"transaction_id"` // 使用指针，因为可能为 NULL
 	PaymentMethod  string    `json:"payment_method"`
+	ProviderRef    *string   `json:"provider_ref"`   // 支付渠道的支付单号，创建支付意图后才有
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 // --- 新增：RechargeRequest 用于接收充值请求 ---
 type RechargeRequest struct {
-	Amount float64 `json:"amount"` // 用户输入金额，可能带小数（如 10.50 元）
+	Amount money.Money `json:"amount"` // 用户输入金额，例如 "10.50" 或 10.50 (按字面精确解析)
 }
 
 // --- 新增：BalanceResponse 用于返回余额 ---
 type BalanceResponse struct {
-    Balance float64 `json:"balance"` // 返回给用户的余额，单位是元
-    Currency string `json:"currency"` // e.g., "CNY"
+    Balance money.Money `json:"balance"` // {"amount": "10.50", "currency": "CNY"}
 }
 
 // --- 用于请求活动的数据结构 ---
@@ -73,6 +138,12 @@ type CampaignRequestData struct {
     AdvertisementID int    `json:"advertisement_id"`
     StartDate       string `json:"start_date"` // 接收 "YYYY-MM-DD" 格式字符串
     EndDate         string `json:"end_date"`   // 接收 "YYYY-MM-DD" 格式字符串
+    PricingModel    string  `json:"pricing_model"` // CPM (默认) 或 CPC
+    BidAmount       money.Money `json:"bid_amount"`    // 出价 (CPM 为每千次展示，CPC 为每次点击)
+    TotalBudget     money.Money `json:"total_budget"`  // 总预算
+    DailyBudget     money.Money `json:"daily_budget"`  // 每日预算，0 或不传表示不限
+    Targeting       CampaignTargeting `json:"targeting"` // 定向条件，可选
+    FrequencyCaps   FrequencyCaps     `json:"frequency_caps"` // 频次上限，可选
 }
 
 // --- 用于审核活动的数据结构 ---
@@ -103,11 +174,15 @@ type CampaignWithAdDetails struct {
     ID             int       `json:"id"`
     AdvertisementID int       `json:"advertisement_id"`
     UserID         int       `json:"user_id"` // 通常在用户自己的列表里可以省略
+    OrganizationID int       `json:"organization_id"`
     StartDate      time.Time `json:"start_date"`
     EndDate        time.Time `json:"end_date"`
     Status         string    `json:"status"`
     CreatedAt      time.Time `json:"created_at"`
     UpdatedAt      time.Time `json:"updated_at"`
+    CampaignBudget
+    Targeting      CampaignTargeting `json:"targeting"`
+    FrequencyCaps  FrequencyCaps     `json:"frequency_caps"`
 
     // 关联的广告信息 (可以只包含部分字段)
     AdTitle    string `json:"ad_title"`
@@ -123,7 +198,12 @@ type AdEvent struct {
     AdvertisementID int       `json:"advertisement_id"`
     CampaignID      int       `json:"campaign_id"`
     UserID          int       `json:"user_id"`
+    OrganizationID  int       `json:"organization_id"` // 被扣费的组织
     EventTimestamp  time.Time `json:"event_timestamp"`
+    Cost            int64     `json:"cost"` // 本次事件实际扣费 (分)
+    PlacementID     *int      `json:"placement_id"` // 发生的广告位，请求未指定广告位时为 nil
+    ClearingPrice   *money.Money `json:"clearing_price,omitempty"` // 竞价成交价：Impression 事件为每千次展示，CPC 活动的 Click 事件为每次点击
+    ClickToken      *string   `json:"-"` // 点击凭证的随机值，只有 Click 事件有，同一个凭证最多记录一次
 }
 
 // AdPerformanceFilter 用于查询广告效果的过滤条件
@@ -143,16 +223,18 @@ type AdPerformanceSummary struct {
     Impressions     int64   `json:"impressions"`
     Clicks          int64   `json:"clicks"`
     CTR             float64 `json:"ctr"` // Click-Through Rate (%)
+    Spend           money.Money `json:"spend"` // 消耗
 }
 
 // InvoiceRequest 对应数据库中的发票请求记录
 type InvoiceRequest struct {
 	ID                 int64      `json:"id"`
 	UserID             int        `json:"user_id"`
+	OrganizationID     int        `json:"organization_id"`
 	Status             string     `json:"status"`
 	InvoicePeriodStart time.Time  `json:"invoice_period_start"` // 使用 time.Time 更灵活
 	InvoicePeriodEnd   time.Time  `json:"invoice_period_end"`
-	TotalAmount        int64      `json:"total_amount"` // 单位：分
+	TotalAmount        money.Money `json:"total_amount"`
 	BillingTitle       string     `json:"billing_title"`
 	TaxID              *string    `json:"tax_id"` // 指针，允许为空
 	BillingAddress     string     `json:"billing_address"`
@@ -179,3 +261,660 @@ type InvoiceRequestFilter struct {
     StartDate *time.Time // 按请求日期过滤
     EndDate   *time.Time // 按请求日期过滤
 }
+
+// --- 账本 (复式记账) ---
+
+// LedgerEntry 是一条已过账的分录 (journal_entries 表)
+type LedgerEntry struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"` // recharge / ad_spend / refund / promo_credit / adjustment / opening_balance
+	OrganizationID *int       `json:"organization_id"` // 钱包所属的组织
+	UserID    *int            `json:"user_id"`         // 关联的成员
+	Reference *string         `json:"reference"`
+	Memo      string          `json:"memo"`
+	CreatedBy *int            `json:"created_by"` // 系统生成的分录为 nil
+	CreatedAt time.Time       `json:"created_at"`
+	Amount    money.Money     `json:"amount"` // 对该组织余额的影响，正数为增加
+	Postings  []LedgerPosting `json:"postings"`
+}
+
+// LedgerPosting 分录中的一行过账 (ledger_postings 表)
+type LedgerPosting struct {
+	Account string `json:"account"` // 账户编码，例如 org:1:wallet、platform:cash
+	Amount  money.Money `json:"amount"`  // 正数借方，负数贷方
+}
+
+// LedgerFilter 用于分页查询组织的账本分录
+type LedgerFilter struct {
+	Kind   *string
+	Limit  int
+	Offset int
+}
+
+// BalanceDiscrepancy 表示 organizations.balance 与账本计算出的余额不一致
+type BalanceDiscrepancy struct {
+	OrganizationID int  `json:"organization_id"`
+	CachedBalance money.Money `json:"cached_balance"` // organizations.balance
+	LedgerBalance money.Money `json:"ledger_balance"` // 钱包账户的贷方余额
+}
+
+// LedgerAdjustmentRequest 管理员手工记账请求
+type LedgerAdjustmentRequest struct {
+	OrganizationID int `json:"organization_id"`
+	Kind   string  `json:"kind"`   // adjustment | promo_credit | refund
+	Amount money.Money `json:"amount"` // adjustment 可以为负数 (扣减余额)
+	Memo   string  `json:"memo"`
+}
+
+// LedgerPage GET /ledger 的分页响应
+type LedgerPage struct {
+	Entries  []LedgerEntry `json:"entries"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+	HasMore  bool          `json:"has_more"`
+}
+
+// RechargeIntentResponse POST /recharge 的响应：充值记录保持 Pending，等待支付回调
+type RechargeIntentResponse struct {
+	RechargeID  int64       `json:"recharge_id"`
+	Amount      money.Money `json:"amount"`
+	Status      string      `json:"status"`
+	ProviderRef string `json:"provider_ref"` // 支付渠道的支付单号
+	PaymentURL  string `json:"payment_url"`  // 引导用户完成支付的地址
+}
+
+// RechargeDetails GET /recharges/{id} 的响应，附带支付渠道侧的状态
+type RechargeDetails struct {
+	RechargeTransaction
+	ProviderStatus *string `json:"provider_status"` // pending / succeeded / failed，查询失败时为 nil
+}
+
+// IdempotencyRecord 一个幂等键 (Idempotency-Key) 对应的请求和响应 (idempotency_keys 表)
+type IdempotencyRecord struct {
+	UserID       int
+	Key          string
+	RequestHash  string // SHA-256(方法 + 路径 + 请求体)，用于识别同一个键被用于不同请求
+	StatusCode   int    // 0 表示原请求仍在处理中
+	ResponseBody []byte
+	CreatedAt    time.Time
+}
+
+// RefreshToken 一个刷新令牌 (refresh_tokens 表)。数据库只保存令牌的哈希。
+// 每次刷新都会吊销旧令牌并签发新令牌，同一次登录轮换出的令牌共享 SessionID；
+// 已被轮换的令牌再次出现时视为泄露，整个会话会被吊销。
+type RefreshToken struct {
+	ID              int64
+	UserID          int
+	TokenHash       string     // SHA-256(令牌明文)
+	SessionID       string     // 登录会话 ID
+	AccessJTI       string     // 与该刷新令牌一起签发的访问令牌的 jti，吊销会话时一并加入吊销列表
+	AccessExpiresAt time.Time  // 该访问令牌的过期时间
+	ExpiresAt       time.Time
+	CreatedAt       time.Time
+	RevokedAt       *time.Time // 非 nil 表示已吊销 (登出、被轮换或会话被吊销)
+}
+
+// RefreshTokenRequest 刷新令牌 / 登出请求体
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse 登录和刷新令牌成功后返回的令牌对
+type TokenResponse struct {
+	Token            string    `json:"token"`         // 访问令牌 (JWT)
+	ExpiresAt        time.Time `json:"expires_at"`    // 访问令牌过期时间
+	RefreshToken     string    `json:"refresh_token"` // 刷新令牌，只在这里返回一次
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// APIKey 广告主的 API Key (api_keys 表)。只保存完整 Key 的哈希，Prefix 用于查找和在界面上展示。
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`     // 空表示不限制
+	ExpiresAt  *time.Time `json:"expires_at"` // nil 表示永不过期
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// CreateAPIKeyRequest 创建 API Key 的请求体
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`     // 可选: read / write
+	ExpiresAt *time.Time `json:"expires_at"` // 可选，RFC 3339
+}
+
+// CreateAPIKeyResponse 创建 API Key 的响应，Key 明文只在这里返回一次
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+// RoleInfo 角色及其权限 (GET /admin/roles)
+type RoleInfo struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// AssignRoleRequest 给用户分配角色的请求体
+type AssignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// UpdateInvoiceStatusRequest 处理发票请求的请求体
+type UpdateInvoiceStatusRequest struct {
+	Status        string  `json:"status"`         // Processing / Completed / Failed
+	InvoiceNumber *string `json:"invoice_number"` // 可选，不传时保留原值
+	Notes         *string `json:"notes"`          // 可选，不传时保留原值
+}
+
+// --- 组织 ---
+
+// Organization 组织 (organizations 表)。广告创意、广告活动、充值、发票和余额都归属组织。
+type Organization struct {
+	ID        int         `json:"id"`
+	Name      string      `json:"name"`
+	Balance   money.Money `json:"balance"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// OrganizationMember 组织成员 (organization_members 表)
+type OrganizationMember struct {
+	OrganizationID int       `json:"organization_id"`
+	UserID         int       `json:"user_id"`
+	Username       string    `json:"username"`
+	Role           string    `json:"role"` // owner / manager / viewer
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OrganizationMembership 当前用户所属的组织及其在组织中的角色 (GET /organizations)
+type OrganizationMembership struct {
+	Organization
+	Role      string `json:"role"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// --- 组织邀请状态 ---
+const (
+	InvitationStatusPending  = "Pending"
+	InvitationStatusAccepted = "Accepted"
+	InvitationStatusDeclined = "Declined"
+	InvitationStatusRevoked  = "Revoked"
+)
+
+// OrganizationInvitation 组织邀请 (organization_invitations 表)
+type OrganizationInvitation struct {
+	ID               int64      `json:"id"`
+	OrganizationID   int        `json:"organization_id"`
+	OrganizationName string     `json:"organization_name"`
+	UserID           int        `json:"user_id"` // 被邀请的用户
+	Username         string     `json:"username"`
+	Role             string     `json:"role"`
+	InvitedBy        int        `json:"invited_by"`
+	Status           string     `json:"status"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RespondedAt      *time.Time `json:"responded_at"`
+}
+
+// CreateOrganizationRequest 创建组织的请求体
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// InviteMemberRequest 邀请成员的请求体
+type InviteMemberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"` // manager (默认) / viewer / owner
+}
+
+// UpdateMemberRoleRequest 修改成员角色的请求体
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// --- 一次性令牌用途 (user_tokens.purpose) ---
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// UserToken 邮箱验证 / 重置密码的一次性令牌 (user_tokens 表)，数据库只保存哈希
+type UserToken struct {
+	ID        int64
+	UserID    int
+	Purpose   string     // verify_email / reset_password
+	TokenHash string     // SHA-256(令牌明文)
+	Email     string     // 令牌发往的邮箱，用户邮箱变更后令牌失效
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time // 非 nil 表示已使用或已被新令牌作废
+}
+
+// --- 发件箱邮件状态 ---
+const (
+	OutboxStatusPending = "Pending" // 等待发送 (包括失败后等待重试)
+	OutboxStatusSent    = "Sent"
+	OutboxStatusFailed  = "Failed" // 超过最大重试次数，不再发送
+)
+
+// OutboxMessage 发件箱中的邮件 (email_outbox 表)
+type OutboxMessage struct {
+	ID            int64
+	Recipient     string
+	Subject       string
+	Body          string // 纯文本正文
+	Status        string
+	Attempts      int    // 已尝试发送的次数
+	LastError     string // 最近一次发送失败的原因
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	SentAt        *time.Time
+}
+
+// VerifyEmailRequest 验证邮箱的请求体 (令牌来自邮件中的链接)
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailRequest 重发验证邮件 / 忘记密码的请求体
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest 重置密码的请求体
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// --- 两步验证 ---
+
+// LoginChallenge 密码校验通过后等待输入验证码的登录挑战 (login_challenges 表)，数据库只保存令牌哈希
+type LoginChallenge struct {
+	ID        int64
+	UserID    int
+	TokenHash string // SHA-256(mfa_token)
+	Attempts  int    // 输错验证码的次数，超过上限后挑战作废
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time // 非 nil 表示已完成或已作废
+}
+
+// TwoFactorLoginRequest 两步登录第二步的请求体，code 可以是验证码或恢复码
+type TwoFactorLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// TwoFactorCodeRequest 启用 / 关闭两步验证、重新生成恢复码的请求体
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorSettingRequest 设置账号是否必须使用两步验证
+type TwoFactorSettingRequest struct {
+	Required *bool `json:"required"`
+}
+
+// TwoFactorStatus 两步验证状态
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	Required               bool       `json:"required"`                 // 账号设置或角色要求必须启用
+	RequiredByRole         bool       `json:"required_by_role"`         // 后台角色强制启用，不能关闭
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"` // 未使用的恢复码数量
+}
+
+// --- 登录限流 ---
+
+// LoginAttempt 某个限流 key (用户名或客户端 IP) 的连续登录失败记录 (login_attempts 表)
+type LoginAttempt struct {
+	Key           string     `json:"key"` // "user:<用户名>" 或 "ip:<客户端 IP>"
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"` // 非 nil 且晚于当前时间表示已锁定
+	ExpiresAt     time.Time  `json:"expires_at"`   // 之后记录失效，失败次数重新计算
+}
+
+// --- 后台用户管理 ---
+
+// 用户账号状态 (GET /admin/users?status=)
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+)
+
+// AdminUserFilter 后台用户列表的过滤条件
+type AdminUserFilter struct {
+	Query  string  // 按用户名或邮箱模糊匹配 (不区分大小写)，为空表示不过滤
+	Role   *string // 按角色过滤
+	Status *string // active / suspended
+	Limit  int
+	Offset int
+}
+
+// AdminUserPage GET /admin/users 的分页响应
+type AdminUserPage struct {
+	Users    []User `json:"users"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+	HasMore  bool   `json:"has_more"`
+}
+
+// AdminUserDetails GET /admin/users/{id} 的响应：用户、所属组织 (含余额) 以及由该用户创建的资源
+type AdminUserDetails struct {
+	User
+	Organizations  []OrganizationMembership `json:"organizations"`
+	Advertisements []Advertisement          `json:"advertisements"`
+	Campaigns      []CampaignWithAdDetails  `json:"campaigns"`
+	Recharges      []RechargeTransaction    `json:"recharges"`
+}
+
+// SuspendUserRequest 停用账号的请求体
+type SuspendUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// BalanceAdjustmentRequest 管理员调整用户余额的请求体
+type BalanceAdjustmentRequest struct {
+	OrganizationID *int        `json:"organization_id"` // 可选，默认为用户的默认组织
+	Amount         money.Money `json:"amount"`          // 正数增加余额，负数扣减余额
+	Reason         string      `json:"reason"`
+}
+
+// --- 审计日志 ---
+
+// 审计操作 (audit_log.action)
+const (
+	AuditAdReview          = "ad.review"          // 审核广告创意
+	AuditCampaignReview    = "campaign.review"    // 审核广告活动
+	AuditCampaignStatus    = "campaign.status"    // 组织成员取消 / 暂停 / 恢复活动，或调度器激活 / 结束活动
+	AuditLedgerEntry       = "ledger.entry"       // 管理员手工记账
+	AuditBalanceAdjust     = "balance.adjust"     // 管理员调整用户余额
+	AuditRechargeSucceeded = "recharge.succeeded" // 支付回调确认充值并入账
+	AuditRechargeFailed    = "recharge.failed"    // 支付回调确认充值失败
+	AuditInvoiceStatus     = "invoice.status"     // 处理发票请求
+	AuditLogin             = "auth.login"         // 登录成功 (签发令牌)
+	AuditLoginFailed       = "auth.login_failed"  // 密码或两步验证码错误
+	AuditUserRole          = "user.role"          // 分配角色
+	AuditUserSuspend       = "user.suspend"       // 停用账号
+	AuditUserUnsuspend     = "user.unsuspend"     // 恢复账号
+	AuditUserTwoFactor     = "user.2fa"           // 要求或重置用户的两步验证
+	AuditUserUnlock        = "user.unlock"        // 解除登录锁定
+	AuditPlacementCreate   = "placement.create"   // 创建广告位
+	AuditPlacementUpdate   = "placement.update"   // 修改或停用广告位
+)
+
+// 审计对象类型 (audit_log.target_type)
+const (
+	AuditTargetAdvertisement = "advertisement"
+	AuditTargetCampaign      = "campaign"
+	AuditTargetOrganization  = "organization"
+	AuditTargetRecharge      = "recharge"
+	AuditTargetInvoice       = "invoice"
+	AuditTargetUser          = "user"
+	AuditTargetPlacement     = "placement"
+)
+
+// AuditEntry 一条审计记录 (audit_log 表)，写入后不会修改或删除
+type AuditEntry struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ActorID       *int            `json:"actor_id"`       // nil 表示系统 (调度器、支付回调) 或未登录 (登录失败)
+	ActorUsername string          `json:"actor_username"` // 登录失败时为尝试的用户名
+	ActorRole     string          `json:"actor_role"`
+	Action        string          `json:"action"`
+	TargetType    string          `json:"target_type"`
+	TargetID      string          `json:"target_id"`
+	Before        json.RawMessage `json:"before"` // 操作前的值 (JSON)，nil 表示无
+	After         json.RawMessage `json:"after"`  // 操作后的值 (JSON)，nil 表示无
+	IP            string          `json:"ip"`
+	RequestID     string          `json:"request_id"`
+}
+
+// AuditFilter GET /admin/audit 的过滤条件
+type AuditFilter struct {
+	ActorID    *int
+	Action     *string
+	TargetType *string
+	TargetID   *string
+	RequestID  *string
+	Since      *time.Time // created_at >= Since
+	Until      *time.Time // created_at < Until
+	Limit      int
+	Offset     int
+}
+
+// AuditPage GET /admin/audit 的分页响应
+type AuditPage struct {
+	Entries  []AuditEntry `json:"entries"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	HasMore  bool         `json:"has_more"`
+}
+
+// --- 广告投放索引 ---
+
+// ServableAd 投放索引中的一条记录：可投放的广告活动及其创意 (见 internal/serving)
+type ServableAd struct {
+	CampaignID      int         `json:"campaign_id"`
+	AdvertisementID int         `json:"advertisement_id"`
+	UserID          int         `json:"user_id"` // 活动创建者
+	OrganizationID  int         `json:"organization_id"`
+	StartDate       time.Time   `json:"start_date"`
+	EndDate         time.Time   `json:"end_date"`
+	PricingModel    string      `json:"pricing_model"`
+	BidAmount       money.Money `json:"bid_amount"`
+	Title           string      `json:"title"`
+	ImageURL        string      `json:"image_url"`
+	TargetURL       string      `json:"target_url"`
+	Width           int         `json:"width"`
+	Height          int         `json:"height"`
+	Format          string      `json:"format"`
+	Targeting       CampaignTargeting `json:"targeting"`
+	FrequencyCaps   FrequencyCaps     `json:"frequency_caps"`
+	Impressions     int64       `json:"impressions"` // 累计展示次数，用于预估 CPC 活动的点击率
+	Clicks          int64       `json:"clicks"`      // 累计点击次数
+}
+
+// --- 广告位 ---
+
+// 广告创意格式 (advertisements.format)
+const (
+	AdFormatImage = "image" // 静态或动态图片
+	AdFormatHTML5 = "html5" // HTML5 富媒体
+	AdFormatVideo = "video" // 视频
+)
+
+// AdFormats 返回所有创意格式
+func AdFormats() []string {
+	return []string{AdFormatImage, AdFormatHTML5, AdFormatVideo}
+}
+
+// ValidAdFormat 检查创意格式是否存在
+func ValidAdFormat(format string) bool {
+	return slices.Contains(AdFormats(), format)
+}
+
+// 广告位状态 (placements.status)
+const (
+	PlacementStatusActive   = "Active"
+	PlacementStatusDisabled = "Disabled" // 停用后 GET /get-ad 不再为它返回广告
+)
+
+// Placement 广告位 (placements 表)：限定尺寸、允许的创意格式和底价，GET /get-ad?placement= 只返回匹配的创意
+type Placement struct {
+	ID         int         `json:"id"`
+	Name       string      `json:"name"`
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Formats    []string    `json:"formats"`     // 允许的创意格式
+	FloorPrice money.Money `json:"floor_price"` // 每千次展示底价，0 表示不限
+	Status     string      `json:"status"`      // Active | Disabled
+	CreatedBy  *int        `json:"created_by"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// Accepts 检查广告位是否接受该尺寸和格式的创意
+func (p *Placement) Accepts(width, height int, format string) bool {
+	return width == p.Width && height == p.Height && slices.Contains(p.Formats, format)
+}
+
+// PlacementRequest 创建 (POST /admin/placements) 或修改 (PUT /admin/placements/{id}) 广告位的请求体
+type PlacementRequest struct {
+	Name       string      `json:"name"`
+	Width      int         `json:"width"`
+	Height     int         `json:"height"`
+	Formats    []string    `json:"formats"`
+	FloorPrice money.Money `json:"floor_price"`
+	Status     string      `json:"status"` // 可选，默认 Active
+}
+
+// --- 广告活动定向 ---
+
+// 设备类型 (由 User-Agent 判断)
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+)
+
+// 操作系统 (由 User-Agent 判断)
+const (
+	OSWindows = "windows"
+	OSMacOS   = "macos"
+	OSLinux   = "linux"
+	OSIOS     = "ios"
+	OSAndroid = "android"
+)
+
+// Daypart 投放时段：days 中的星期几 (0 = 周日 … 6 = 周六，为空表示每天) 的 [start_hour, end_hour) 小时。
+// 跨午夜的时段需要拆成两段
+type Daypart struct {
+	Days      []int `json:"days,omitempty"`
+	StartHour int   `json:"start_hour"` // 0-23
+	EndHour   int   `json:"end_hour"`   // 1-24，不含
+}
+
+// CampaignTargeting 广告活动的定向条件 (ad_campaigns.targeting，JSON)。
+// 各维度之间是 "且" 的关系，维度内的多个值是 "或" 的关系，未设置的维度不限；
+// 国家和地区属于同一维度，访问者的国家在 countries 中或地区在 regions 中即满足
+type CampaignTargeting struct {
+	Countries []string  `json:"countries,omitempty"` // ISO 3166-1 国家代码，如 CN、US
+	Regions   []string  `json:"regions,omitempty"`   // ISO 3166-2 地区代码，如 CN-GD、US-CA
+	Devices   []string  `json:"devices,omitempty"`   // desktop | mobile | tablet
+	OS        []string  `json:"os,omitempty"`        // windows | macos | linux | ios | android
+	Languages []string  `json:"languages,omitempty"` // 语言标签，如 zh、en-US (zh 同时匹配 zh-CN、zh-TW)
+	Dayparts  []Daypart `json:"dayparts,omitempty"`  // 投放时段 (按 targeting.timezone 配置的时区)
+}
+
+// IsZero 没有任何定向条件
+func (t CampaignTargeting) IsZero() bool {
+	return len(t.Countries) == 0 && len(t.Regions) == 0 && len(t.Devices) == 0 &&
+		len(t.OS) == 0 && len(t.Languages) == 0 && len(t.Dayparts) == 0
+}
+
+// Scan 实现 sql.Scanner，读取 JSON 格式的定向条件，NULL 表示没有定向条件
+func (t *CampaignTargeting) Scan(src interface{}) error {
+	*t = CampaignTargeting{}
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("models: cannot scan %T into CampaignTargeting", src)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, t)
+}
+
+// Value 实现 driver.Valuer，没有定向条件时写入 NULL
+func (t CampaignTargeting) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// --- 频次控制 ---
+
+// FrequencyCap 频次上限：同一个访问者在任意连续的 Window 时长内最多看到 Impressions 次该活动
+type FrequencyCap struct {
+	Impressions int           `json:"impressions"`
+	Window      time.Duration `json:"-"` // JSON 中为 "window": "24h" 这样的时长字符串
+}
+
+type frequencyCapJSON struct {
+	Impressions int    `json:"impressions"`
+	Window      string `json:"window"`
+}
+
+// MarshalJSON 把 Window 输出为时长字符串
+func (c FrequencyCap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(frequencyCapJSON{Impressions: c.Impressions, Window: c.Window.String()})
+}
+
+// UnmarshalJSON 解析 {"impressions": 3, "window": "24h"}
+func (c *FrequencyCap) UnmarshalJSON(data []byte) error {
+	var v frequencyCapJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	window, err := time.ParseDuration(v.Window)
+	if err != nil {
+		return fmt.Errorf("models: invalid frequency cap window %q: %w", v.Window, err)
+	}
+	*c = FrequencyCap{Impressions: v.Impressions, Window: window}
+	return nil
+}
+
+// FrequencyCaps 活动的频次上限 (ad_campaigns.frequency_caps，JSON)，需要同时满足每一条
+type FrequencyCaps []FrequencyCap
+
+// Scan 实现 sql.Scanner，NULL 表示不限
+func (f *FrequencyCaps) Scan(src interface{}) error {
+	*f = nil
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("models: cannot scan %T into FrequencyCaps", src)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, f)
+}
+
+// Value 实现 driver.Valuer，没有频次上限时写入 NULL
+func (f FrequencyCaps) Value() (driver.Value, error) {
+	if len(f) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
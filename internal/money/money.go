@@ -0,0 +1,269 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- 金额 ---
+// Money 用整数的最小货币单位 (分) 保存金额，避免 float64 的舍入误差。
+// 数据库中仍然是 BIGINT (分)，JSON 中统一序列化为 {"amount": "10.50", "currency": "CNY"}。
+
+// Scale 小数位数 (最小单位为 0.01)
+const Scale = 2
+
+const minorPerUnit = 100
+
+// maxIntegerDigits 整数部分最多的位数，保证乘以 100 后不会溢出 int64
+const maxIntegerDigits = 15
+
+var (
+	ErrInvalidFormat    = errors.New("money: invalid amount format")
+	ErrTooManyDecimals  = errors.New("money: amount has more than two decimal places")
+	ErrBelowMinimum     = errors.New("money: amount is below the minimum")
+	ErrAboveMaximum     = errors.New("money: amount is above the maximum")
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")
+)
+
+// 全局设置，由 Configure 在启动时设置
+var (
+	currency   = "CNY"
+	minAmount  = Money{Minor: 1}
+	maxAmount  = Money{Minor: 100000000} // 1,000,000.00
+	hasMaximum = true
+)
+
+// Configure 设置货币和用户输入金额的范围 (min / max 为 "0.01" 这样的字符串，max 为空表示不限制)
+func Configure(currencyCode, min, max string) error {
+	code := strings.ToUpper(strings.TrimSpace(currencyCode))
+	if len(code) != 3 {
+		return fmt.Errorf("money: invalid currency code %q", currencyCode)
+	}
+	lo, err := ParseAmount(min)
+	if err != nil {
+		return fmt.Errorf("money: invalid minimum %q: %w", min, err)
+	}
+	if lo <= 0 {
+		return fmt.Errorf("money: minimum must be positive, got %q", min)
+	}
+	var hi int64
+	if strings.TrimSpace(max) != "" {
+		if hi, err = ParseAmount(max); err != nil {
+			return fmt.Errorf("money: invalid maximum %q: %w", max, err)
+		}
+		if hi < lo {
+			return fmt.Errorf("money: maximum %q is below minimum %q", max, min)
+		}
+	}
+	currency = code
+	minAmount = Money{Minor: lo, Currency: code}
+	maxAmount = Money{Minor: hi, Currency: code}
+	hasMaximum = hi > 0
+	return nil
+}
+
+// Currency 返回当前使用的货币代码
+func Currency() string {
+	return currency
+}
+
+// Money 金额，Minor 为最小货币单位 (分)
+type Money struct {
+	Minor    int64
+	Currency string // 为空表示 Currency()
+}
+
+// New 用分构造当前货币的金额
+func New(minor int64) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// ParseAmount 精确解析 "10.50"、"-3"、"0.5" 这样的十进制字符串，返回分。
+// 不接受科学计数法，也不接受超过两位小数。
+func ParseAmount(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" || hasDot && fracPart == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidFormat, s)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidFormat, s)
+	}
+	if len(fracPart) > Scale {
+		return 0, fmt.Errorf("%w: %q", ErrTooManyDecimals, s)
+	}
+	if len(strings.TrimLeft(intPart, "0")) > maxIntegerDigits {
+		return 0, fmt.Errorf("%w: %q is too large", ErrInvalidFormat, s)
+	}
+
+	units, _ := strconv.ParseInt(intPart, 10, 64)
+	fracPart += strings.Repeat("0", Scale-len(fracPart))
+	cents, _ := strconv.ParseInt(fracPart, 10, 64)
+	minor := units*minorPerUnit + cents
+	if negative {
+		minor = -minor
+	}
+	return minor, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse 解析当前货币的金额
+func Parse(s string) (Money, error) {
+	minor, err := ParseAmount(s)
+	if err != nil {
+		return Money{}, err
+	}
+	return New(minor), nil
+}
+
+// String 返回 "10.50" 这样的十进制字符串 (不带货币)
+func (m Money) String() string {
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/minorPerUnit, minor%minorPerUnit)
+}
+
+// CurrencyCode 返回货币代码，未设置时为 Currency()
+func (m Money) CurrencyCode() string {
+	if m.Currency == "" {
+		return currency
+	}
+	return m.Currency
+}
+
+// IsZero 金额是否为 0
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// CheckRange 校验用户输入的金额 (绝对值) 在 Configure 设置的范围内
+func (m Money) CheckRange() error {
+	abs := m.Minor
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < minAmount.Minor {
+		return fmt.Errorf("%w (%s)", ErrBelowMinimum, minAmount)
+	}
+	if hasMaximum && abs > maxAmount.Minor {
+		return fmt.Errorf("%w (%s)", ErrAboveMaximum, maxAmount)
+	}
+	return nil
+}
+
+// Minimum 返回允许输入的最小金额
+func Minimum() Money {
+	return minAmount
+}
+
+// Maximum 返回允许输入的最大金额，ok 为 false 表示不限制
+func Maximum() (m Money, ok bool) {
+	return maxAmount, hasMaximum
+}
+
+type jsonMoney struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON 序列化为 {"amount": "10.50", "currency": "CNY"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMoney{Amount: m.String(), Currency: m.CurrencyCode()})
+}
+
+// UnmarshalJSON 接受 "10.50"、10.50 (按字面精确解析) 或 {"amount": "10.50", "currency": "CNY"}
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var raw string
+	switch {
+	case len(data) > 0 && data[0] == '"':
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+		}
+	case len(data) > 0 && data[0] == '{':
+		var obj struct {
+			Amount   json.RawMessage `json:"amount"`
+			Currency string          `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+		}
+		if obj.Currency != "" && !strings.EqualFold(obj.Currency, currency) {
+			return fmt.Errorf("%w: %s (expected %s)", ErrCurrencyMismatch, obj.Currency, currency)
+		}
+		if len(obj.Amount) == 0 || obj.Amount[0] == '{' {
+			return fmt.Errorf("%w: missing amount", ErrInvalidFormat)
+		}
+		return m.UnmarshalJSON(obj.Amount)
+	default:
+		raw = string(data) // JSON 数字，按原始文本解析，不经过 float64
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan 实现 sql.Scanner，数据库中的金额列为分 (BIGINT，SUM 的结果可能是 DECIMAL 字符串)
+func (m *Money) Scan(src interface{}) error {
+	var minor int64
+	switch v := src.(type) {
+	case nil:
+		minor = 0
+	case int64:
+		minor = v
+	case []byte:
+		n, err := strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("money: cannot scan %q: %w", v, err)
+		}
+		minor = n
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("money: cannot scan %q: %w", v, err)
+		}
+		minor = n
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	*m = New(minor)
+	return nil
+}
+
+// Value 实现 driver.Valuer，写入分
+func (m Money) Value() (driver.Value, error) {
+	return m.Minor, nil
+}
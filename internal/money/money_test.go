@@ -0,0 +1,238 @@
+package money_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"advertisement/internal/money"
+)
+
+// configure 设置货币和金额范围，测试结束后恢复默认值 (CNY, 0.01 ~ 1000000.00)
+func configure(t *testing.T, currency, min, max string) {
+	t.Helper()
+	if err := money.Configure(currency, min, max); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := money.Configure("CNY", "0.01", "1000000.00"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  error
+	}{
+		{"10.50", 1050, nil},
+		{"10.5", 1050, nil},
+		{"10", 1000, nil},
+		{"0", 0, nil},
+		{"0.01", 1, nil},
+		{".5", 50, nil},
+		{"007.10", 710, nil},
+		{" 3.20 ", 320, nil},
+		{"+3", 300, nil},
+		{"-3", -300, nil},
+		{"-0.99", -99, nil},
+		{"999999999999999.99", 99999999999999999, nil},
+		{"10.505", 0, money.ErrTooManyDecimals},
+		{"0.001", 0, money.ErrTooManyDecimals},
+		{"5.", 0, money.ErrInvalidFormat},
+		{".", 0, money.ErrInvalidFormat},
+		{"", 0, money.ErrInvalidFormat},
+		{"-", 0, money.ErrInvalidFormat},
+		{"--3", 0, money.ErrInvalidFormat},
+		{"+-3", 0, money.ErrInvalidFormat},
+		{"1e3", 0, money.ErrInvalidFormat},
+		{"1,000", 0, money.ErrInvalidFormat},
+		{"1.2.3", 0, money.ErrInvalidFormat},
+		{"abc", 0, money.ErrInvalidFormat},
+		{"NaN", 0, money.ErrInvalidFormat},
+		// 整数部分超过 15 位，乘以 100 后可能溢出 int64
+		{"1000000000000000", 0, money.ErrInvalidFormat},
+		{"92233720368547758.07", 0, money.ErrInvalidFormat},
+		{"99999999999999999999", 0, money.ErrInvalidFormat},
+		{"-99999999999999999999", 0, money.ErrInvalidFormat},
+		// 前导 0 不计入位数
+		{"0000000000000000001", 100, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := money.ParseAmount(tt.in)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("ParseAmount(%q) = %d, %v, want %d, %v", tt.in, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		minor int64
+		want  string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{50, "0.50"},
+		{1050, "10.50"},
+		{-1, "-0.01"},
+		{-1050, "-10.50"},
+		{99999999999999999, "999999999999999.99"},
+	}
+	for _, tt := range tests {
+		if got := money.New(tt.minor).String(); got != tt.want {
+			t.Errorf("New(%d).String() = %q, want %q", tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	configure(t, "CNY", "1.00", "100.00")
+	tests := []struct {
+		minor int64
+		want  error
+	}{
+		{100, nil},
+		{10000, nil},
+		{5000, nil},
+		{-5000, nil}, // 按绝对值校验
+		{99, money.ErrBelowMinimum},
+		{0, money.ErrBelowMinimum},
+		{10001, money.ErrAboveMaximum},
+		{-10001, money.ErrAboveMaximum},
+	}
+	for _, tt := range tests {
+		if err := money.New(tt.minor).CheckRange(); !errors.Is(err, tt.want) {
+			t.Errorf("New(%d).CheckRange() = %v, want %v", tt.minor, err, tt.want)
+		}
+	}
+
+	// max 为空表示不限制上限
+	configure(t, "CNY", "0.01", "")
+	if err := money.New(1 << 60).CheckRange(); err != nil {
+		t.Errorf("CheckRange() without maximum = %v, want nil", err)
+	}
+	if _, ok := money.Maximum(); ok {
+		t.Error("Maximum() reports a limit after configuring none")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name               string
+		currency, min, max string
+		ok                 bool
+	}{
+		{"defaults", "CNY", "0.01", "1000000.00", true},
+		{"lower case currency", "usd", "0.50", "", true},
+		{"min equals max", "CNY", "5", "5", true},
+		{"short currency", "CN", "0.01", "", false},
+		{"zero minimum", "CNY", "0", "", false},
+		{"negative minimum", "CNY", "-1", "", false},
+		{"max below min", "CNY", "10", "9.99", false},
+		{"too many decimals", "CNY", "0.001", "", false},
+		{"invalid maximum", "CNY", "0.01", "lots", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configure(t, "CNY", "0.01", "1000000.00")
+			err := money.Configure(tt.currency, tt.min, tt.max)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Configure(%q, %q, %q) = %v, want ok = %v", tt.currency, tt.min, tt.max, err, tt.ok)
+			}
+			if !tt.ok && money.Currency() != "CNY" {
+				t.Errorf("failed Configure changed the currency to %q", money.Currency())
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  error
+	}{
+		{`"10.50"`, 1050, nil},
+		{`10.50`, 1050, nil},
+		{`0.1`, 10, nil}, // 按字面解析，不经过 float64
+		{`-2`, -200, nil},
+		{`{"amount": "10.50", "currency": "CNY"}`, 1050, nil},
+		{`{"amount": "10.50", "currency": "cny"}`, 1050, nil},
+		{`{"amount": 10.5}`, 1050, nil},
+		{`{"amount": "10.50", "currency": "USD"}`, 0, money.ErrCurrencyMismatch},
+		{`{"currency": "CNY"}`, 0, money.ErrInvalidFormat},
+		{`{"amount": {"amount": "1"}}`, 0, money.ErrInvalidFormat},
+		{`"10.505"`, 0, money.ErrTooManyDecimals},
+		{`1e2`, 0, money.ErrInvalidFormat},
+		{`"5."`, 0, money.ErrInvalidFormat},
+		{`true`, 0, money.ErrInvalidFormat},
+		{`"99999999999999999999"`, 0, money.ErrInvalidFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var m money.Money
+			err := json.Unmarshal([]byte(tt.in), &m)
+			if !errors.Is(err, tt.err) || m.Minor != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, %v, want %d, %v", tt.in, m.Minor, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	// null 保持原值
+	m := money.New(7)
+	if err := json.Unmarshal([]byte("null"), &m); err != nil || m.Minor != 7 {
+		t.Errorf("Unmarshal(null) = %d, %v, want 7, nil", m.Minor, err)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	configure(t, "USD", "0.01", "")
+	for _, minor := range []int64{0, 1, 99, 1050, -1050, 99999999999999999} {
+		in := money.New(minor)
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out money.Money
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal(%s) = %v", data, err)
+		}
+		if out != in {
+			t.Errorf("round trip of %d via %s = %+v, want %+v", minor, data, out, in)
+		}
+	}
+
+	data, _ := json.Marshal(struct {
+		Balance money.Money `json:"balance"`
+	}{money.New(1050)})
+	if want := `{"balance":{"amount":"10.50","currency":"USD"}}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src     interface{}
+		want    int64
+		wantErr bool
+	}{
+		{int64(1050), 1050, false},
+		{nil, 0, false},
+		{[]byte("1050"), 1050, false},
+		{"-25", -25, false},
+		{"10.50", 0, true},
+		{3.5, 0, true},
+	}
+	for _, tt := range tests {
+		var m money.Money
+		err := m.Scan(tt.src)
+		if (err != nil) != tt.wantErr || m.Minor != tt.want {
+			t.Errorf("Scan(%#v) = %d, %v, want %d (error %v)", tt.src, m.Minor, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MockName mock 渠道的名称
+const MockName = "mock"
+
+// MockProvider 是本地开发用的确定性支付渠道：
+// 支付单号由充值记录 ID 推导 (mock_pi_<id>)，不会自动完成支付，
+// 需要通过 cmd/mockpay 发送签名回调来模拟支付成功或失败。
+type MockProvider struct {
+	secret    []byte
+	tolerance time.Duration
+
+	mu      sync.Mutex
+	intents map[string]Status
+}
+
+// NewMockProvider 创建 mock 渠道，secret 为回调签名密钥，tolerance 为允许的时间偏差
+func NewMockProvider(secret string, tolerance time.Duration) *MockProvider {
+	return &MockProvider{
+		secret:    []byte(secret),
+		tolerance: tolerance,
+		intents:   make(map[string]Status),
+	}
+}
+
+// MockProviderRef 返回充值记录对应的 mock 支付单号
+func MockProviderRef(rechargeID int64) string {
+	return fmt.Sprintf("mock_pi_%d", rechargeID)
+}
+
+func (m *MockProvider) Name() string {
+	return MockName
+}
+
+func (m *MockProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	if req.RechargeID <= 0 || req.Amount <= 0 {
+		return nil, fmt.Errorf("payment(mock): invalid intent request %+v", req)
+	}
+	ref := MockProviderRef(req.RechargeID)
+
+	m.mu.Lock()
+	m.intents[ref] = StatusPending
+	m.mu.Unlock()
+
+	return &Intent{
+		ProviderRef: ref,
+		PaymentURL:  "mock://pay/" + ref,
+		Status:      StatusPending,
+	}, nil
+}
+
+func (m *MockProvider) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := VerifySignature(m.secret, header.Get(SignatureHeader), payload, time.Now(), m.tolerance); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if event.RechargeID <= 0 || event.Amount <= 0 {
+		return nil, fmt.Errorf("%w: missing recharge_id or amount", ErrInvalidEvent)
+	}
+	if event.Status != StatusSucceeded && event.Status != StatusFailed {
+		return nil, fmt.Errorf("%w: unsupported status %q", ErrInvalidEvent, event.Status)
+	}
+	if event.ProviderRef == "" {
+		event.ProviderRef = MockProviderRef(event.RechargeID)
+	}
+	if event.ProviderRef != MockProviderRef(event.RechargeID) {
+		return nil, fmt.Errorf("%w: provider_ref does not match recharge_id", ErrInvalidEvent)
+	}
+	if event.TransactionID == "" {
+		event.TransactionID = "MOCK_TX_" + event.ProviderRef
+	}
+
+	m.mu.Lock()
+	m.intents[event.ProviderRef] = event.Status
+	m.mu.Unlock()
+	return &event, nil
+}
+
+func (m *MockProvider) QueryStatus(ctx context.Context, providerRef string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.intents[providerRef]
+	if !ok {
+		return "", ErrIntentNotFound
+	}
+	return status, nil
+}
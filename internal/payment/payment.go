@@ -0,0 +1,69 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// --- 支付渠道 ---
+// 充值流程:
+//  1. POST /recharge 创建 Pending 充值记录，并调用 Provider.CreateIntent 创建支付意图
+//  2. 用户在支付渠道完成支付
+//  3. 支付渠道回调 POST /payments/webhook，Provider.VerifyWebhook 校验签名并解析事件
+//  4. 事件为 succeeded 时通过 store.ProcessSuccessfulRecharge 入账，failed 时把记录标记为 Failed
+//
+// 回调丢失时，可以通过 Provider.QueryStatus 主动查询支付结果。
+
+// Status 支付意图的状态
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+var (
+	ErrInvalidSignature = errors.New("payment: invalid webhook signature")
+	ErrSignatureExpired = errors.New("payment: webhook signature expired")
+	ErrInvalidEvent     = errors.New("payment: invalid webhook event")
+	ErrIntentNotFound   = errors.New("payment: payment intent not found")
+)
+
+// IntentRequest 创建支付意图的参数
+type IntentRequest struct {
+	RechargeID int64 // 充值记录 ID，回调时原样带回
+	UserID     int
+	Amount     int64  // 单位：分
+	Currency   string // 例如 "CNY"
+}
+
+// Intent 支付渠道返回的支付意图
+type Intent struct {
+	ProviderRef string // 支付渠道侧的支付单号
+	PaymentURL  string // 引导用户完成支付的地址
+	Status      Status
+}
+
+// Event 经过签名校验的回调事件
+type Event struct {
+	ID            string `json:"id"`             // 事件 ID (用于日志)
+	ProviderRef   string `json:"provider_ref"`   // 支付单号
+	RechargeID    int64  `json:"recharge_id"`    // 对应的充值记录
+	Amount        int64  `json:"amount"`         // 实际支付金额 (分)
+	Status        Status `json:"status"`         // succeeded | failed
+	TransactionID string `json:"transaction_id"` // 支付渠道的交易流水号
+}
+
+// Provider 是支付渠道的抽象
+type Provider interface {
+	// Name 返回渠道名称，记录在 recharge_transactions.payment_method
+	Name() string
+	// CreateIntent 为一笔充值创建支付意图
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	// VerifyWebhook 校验回调签名并解析事件；签名无效时返回 ErrInvalidSignature / ErrSignatureExpired
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+	// QueryStatus 主动查询支付意图的状态
+	QueryStatus(ctx context.Context, providerRef string) (Status, error)
+}
@@ -0,0 +1,69 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader 回调签名所在的请求头，格式为 "t=<unix 秒>,v1=<hex>"
+const SignatureHeader = "X-Payment-Signature"
+
+// sign 计算 HMAC-SHA256("<timestamp>.<payload>")
+func sign(secret []byte, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPayload 生成 SignatureHeader 的值 (mock 渠道和 cmd/mockpay 使用)
+func SignPayload(secret []byte, timestamp time.Time, payload []byte) string {
+	ts := timestamp.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", ts, sign(secret, ts, payload))
+}
+
+// VerifySignature 校验签名头，并拒绝时间戳与 now 相差超过 tolerance 的请求 (防重放)
+func VerifySignature(secret []byte, header string, payload []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = ts
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	expected := sign(secret, timestamp, payload)
+	valid := false
+	for _, sig := range signatures { // 允许多个 v1，便于渠道轮换密钥
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	if age := now.Sub(time.Unix(timestamp, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return ErrSignatureExpired
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package payment_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"advertisement/internal/payment"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("webhook_secret")
+	payload := []byte(`{"id":"evt_1","recharge_id":1,"amount":1000,"status":"succeeded"}`)
+	now := time.Unix(1700000000, 0)
+	signed := payment.SignPayload(secret, now, payload)
+	// v1 为 signed 中的签名部分，用于拼出其他格式的签名头
+	_, v1, _ := strings.Cut(signed, ",v1=")
+
+	tests := []struct {
+		name      string
+		header    string
+		payload   []byte
+		now       time.Time
+		tolerance time.Duration
+		want      error
+	}{
+		{"valid", signed, payload, now, time.Minute, nil},
+		{"within tolerance", signed, payload, now.Add(59 * time.Second), time.Minute, nil},
+		{"stale", signed, payload, now.Add(2 * time.Minute), time.Minute, payment.ErrSignatureExpired},
+		{"from the future", signed, payload, now.Add(-2 * time.Minute), time.Minute, payment.ErrSignatureExpired},
+		{"no tolerance accepts any age", signed, payload, now.Add(24 * time.Hour), 0, nil},
+		{"wrong secret", payment.SignPayload([]byte("other_secret"), now, payload), payload, now, time.Minute, payment.ErrInvalidSignature},
+		{"tampered payload", signed, []byte(`{"id":"evt_1","recharge_id":1,"amount":100000,"status":"succeeded"}`), now, time.Minute, payment.ErrInvalidSignature},
+		{"tampered timestamp", fmt.Sprintf("t=%d,v1=%s", now.Unix()+1, v1), payload, now, time.Minute, payment.ErrInvalidSignature},
+		{"one of several signatures valid", fmt.Sprintf("t=%d,v1=bogus,v1=%s", now.Unix(), v1), payload, now, time.Minute, nil},
+		{"missing timestamp", "v1=" + v1, payload, now, time.Minute, payment.ErrInvalidSignature},
+		{"missing signature", fmt.Sprintf("t=%d", now.Unix()), payload, now, time.Minute, payment.ErrInvalidSignature},
+		{"non-numeric timestamp", "t=abc,v1=" + v1, payload, now, time.Minute, payment.ErrInvalidSignature},
+		{"empty header", "", payload, now, time.Minute, payment.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := payment.VerifySignature(secret, tt.header, tt.payload, tt.now, tt.tolerance); !errors.Is(err, tt.want) {
+				t.Errorf("VerifySignature() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+)
+
+// CampaignScheduler 定期推进广告活动的生命周期：
+//   - Approved 且到达 start_date 的活动 -> Active (开始投放)
+//   - 已过 end_date 的 Approved / Active / Paused 活动 -> Completed
+//
+// 同时顺带清理已过期的刷新令牌和访问令牌吊销记录。
+//
+// 状态转换本身由 store 校验并记录历史，这里只负责定时触发，并为每个转换的活动追加一条审计记录 (操作者为系统)。
+type CampaignScheduler struct {
+	store    store.Store
+	interval time.Duration
+
+	// OnChange 在有活动开始投放或结束后调用 (可选)，用于刷新投放索引
+	OnChange func()
+}
+
+// NewCampaignScheduler 创建调度器，interval 为检查间隔
+func NewCampaignScheduler(s store.Store, interval time.Duration) *CampaignScheduler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &CampaignScheduler{store: s, interval: interval}
+}
+
+// Run 启动时先执行一次，之后按间隔执行，直到 ctx 被取消。应在单独的 goroutine 中调用。
+func (c *CampaignScheduler) Run(ctx context.Context) {
+	log.Printf("scheduler: 广告活动调度器已启动，间隔 %s", c.interval)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("scheduler: 执行失败: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("scheduler: 广告活动调度器已停止")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce 以 now 为当前时间执行一轮检查。
+// 先完成过期活动再激活，避免同一轮里把已过期的活动先激活再完成。
+func (c *CampaignScheduler) RunOnce(ctx context.Context, now time.Time) error {
+	completed, err := c.store.CompleteExpiredCampaigns(ctx, now)
+	if len(completed) > 0 {
+		log.Printf("scheduler: %d 个广告活动已结束: %v", len(completed), completed)
+		c.audit(ctx, completed, "", models.CampaignStatusCompleted, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	activated, err := c.store.ActivateDueCampaigns(ctx, now)
+	if len(activated) > 0 {
+		log.Printf("scheduler: %d 个广告活动开始投放: %v", len(activated), activated)
+		c.audit(ctx, activated, models.CampaignStatusApproved, models.CampaignStatusActive, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.OnChange != nil && len(completed)+len(activated) > 0 {
+		c.OnChange()
+	}
+
+	purged, err := c.store.PurgeExpiredTokens(ctx, now)
+	if purged > 0 {
+		log.Printf("scheduler: 清理了 %d 条过期的令牌记录", purged)
+	}
+	return err
+}
+
+// audit 为调度器转换的每个活动追加一条审计记录。
+// before 为空表示转换前的状态不唯一 (结束的活动可能是 Approved / Active / Paused)，不记录变更前的值
+func (c *CampaignScheduler) audit(ctx context.Context, campaignIDs []int, before, after string, now time.Time) {
+	var beforeValue json.RawMessage
+	if before != "" {
+		beforeValue, _ = json.Marshal(map[string]string{"status": before})
+	}
+	afterValue, _ := json.Marshal(map[string]string{"status": after})
+	for _, id := range campaignIDs {
+		entry := &models.AuditEntry{
+			CreatedAt:  now,
+			Action:     models.AuditCampaignStatus,
+			TargetType: models.AuditTargetCampaign,
+			TargetID:   strconv.Itoa(id),
+			Before:     beforeValue,
+			After:      afterValue,
+		}
+		if err := c.store.AppendAuditEntry(ctx, entry); err != nil {
+			log.Printf("scheduler: 写入活动 %d 的审计日志失败: %v", id, err)
+		}
+	}
+}
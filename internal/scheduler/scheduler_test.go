@@ -0,0 +1,167 @@
+package scheduler_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"advertisement/internal/migrate"
+	"advertisement/internal/models"
+	"advertisement/internal/scheduler"
+	"advertisement/internal/store"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newSQLiteStore(t *testing.T) store.Store {
+	t.Helper()
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "scheduler.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return store.NewSQLiteStore(db)
+}
+
+func TestRunOnce(t *testing.T) {
+	for _, s := range []struct {
+		name string
+		open func(t *testing.T) store.Store
+	}{
+		{"SQLite", newSQLiteStore},
+		{"MemStore", func(*testing.T) store.Store { return store.NewMemStore() }},
+	} {
+		t.Run(s.name, func(t *testing.T) {
+			testRunOnce(t, s.open(t))
+		})
+	}
+}
+
+func testRunOnce(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if err := s.CreateUser(ctx, &models.User{Username: "alice", PasswordHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adID, err := s.CreateAdvertisement(ctx, &models.Advertisement{Title: "ad", ImageURL: "https://example.com/a.png",
+		TargetURL: "https://example.com", UserID: user.ID, OrganizationID: *user.DefaultOrganizationID, Status: "Approved"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	yesterday, tomorrow := today.AddDate(0, 0, -1), today.AddDate(0, 0, 1)
+
+	// 按 path 依次转换状态 (Pending 之后)，返回活动 ID
+	campaign := func(start, end time.Time, path ...string) int {
+		t.Helper()
+		id, err := s.CreateAdCampaign(ctx, &models.AdCampaign{AdvertisementID: int(adID), UserID: user.ID, OrganizationID: *user.DefaultOrganizationID,
+			StartDate: start, EndDate: end, Status: models.CampaignStatusPending})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range path {
+			if err := s.UpdateAdCampaignStatus(ctx, int(id), status, user.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return int(id)
+	}
+	const (
+		approved  = models.CampaignStatusApproved
+		active    = models.CampaignStatusActive
+		paused    = models.CampaignStatusPaused
+		completed = models.CampaignStatusCompleted
+		cancelled = models.CampaignStatusCancelled
+	)
+	tests := []struct {
+		name string
+		id   int
+		want string
+	}{
+		{"approved and started", campaign(yesterday, tomorrow, approved), active},
+		{"approved starting today", campaign(today, today, approved), active},
+		{"approved not started", campaign(tomorrow, tomorrow.AddDate(0, 0, 7), approved), approved},
+		{"approved and already ended", campaign(yesterday.AddDate(0, 0, -7), yesterday, approved), completed},
+		{"active ending today", campaign(yesterday, today, approved, active), active},
+		{"active and ended", campaign(yesterday.AddDate(0, 0, -7), yesterday, approved, active), completed},
+		{"paused and ended", campaign(yesterday.AddDate(0, 0, -7), yesterday, approved, active, paused), completed},
+		{"paused in range", campaign(yesterday, tomorrow, approved, active, paused), paused},
+		{"pending and ended", campaign(yesterday.AddDate(0, 0, -7), yesterday), models.CampaignStatusPending},
+		{"cancelled and ended", campaign(yesterday.AddDate(0, 0, -7), yesterday, cancelled), cancelled},
+	}
+
+	sched := scheduler.NewCampaignScheduler(s, time.Minute)
+	if err := sched.RunOnce(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got, err := s.GetAdCampaignByID(ctx, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.name, got.Status, tt.want)
+		}
+	}
+
+	// 调度器的转换记录在历史中，操作人为空 (系统)
+	history, err := s.GetCampaignStatusHistory(ctx, tests[0].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transitions []string
+	for _, h := range history {
+		transitions = append(transitions, h.ToStatus)
+	}
+	if !slices.Equal(transitions, []string{models.CampaignStatusPending, approved, active}) {
+		t.Errorf("history = %v", transitions)
+	}
+	if last := history[len(history)-1]; last.ChangedBy != nil || last.FromStatus == nil || *last.FromStatus != approved {
+		t.Errorf("scheduler change = %+v, want from Approved by the system", last)
+	}
+
+	// 再执行一次没有变化
+	before := len(history)
+	if err := sched.RunOnce(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := s.GetCampaignStatusHistory(ctx, tests[0].id); len(history) != before {
+		t.Errorf("second run changed history: %d -> %d entries", before, len(history))
+	}
+
+	// 第二天结束日期为今天的活动完成
+	if err := sched.RunOnce(ctx, tomorrow.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests[:2] {
+		got, _ := s.GetAdCampaignByID(ctx, tt.id)
+		want := active
+		if tt.name == "approved starting today" {
+			want = completed
+		}
+		if got.Status != want {
+			t.Errorf("%s next day: status = %s, want %s", tt.name, got.Status, want)
+		}
+	}
+}
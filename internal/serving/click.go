@@ -0,0 +1,107 @@
+package serving
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// --- 点击凭证 ---
+// GET /get-ad 每返回一个广告就签发一个点击凭证，放在点击跟踪链接的 token 参数中。
+// 凭证用 HMAC-SHA256 签名，绑定这次展示的活动、创意、广告位和访问者，过期后不再计费；
+// 每个凭证带一个随机的 Nonce，点击事件按它去重 (ad_events.click_token 唯一索引)，同一个凭证最多计费一次。
+// 没有凭证的点击无法伪造，枚举活动和创意 ID 也不能刷点击。
+
+var (
+	ErrInvalidClickToken = errors.New("serving: invalid click token")
+	ErrClickTokenExpired = errors.New("serving: click token expired")
+)
+
+// ClickClaims 点击凭证的内容
+type ClickClaims struct {
+	CampaignID      int    `json:"c"`
+	AdvertisementID int    `json:"a"`
+	PlacementID     int    `json:"p,omitempty"`  // 0 表示请求没有指定广告位
+	Viewer          string `json:"v,omitempty"`  // 访问者标识的摘要 (见 viewerDigest)，为空表示签发时无法识别访问者
+	ClickPrice      int64  `json:"cp,omitempty"` // CPC 活动这次展示的每次点击成交价 (分，见 Selection.ClickPrice)，0 表示按出价
+	ExpiresAt       int64  `json:"e"`            // Unix 秒
+	Nonce           string `json:"n"`            // 32 个十六进制字符
+}
+
+// MatchesViewer 点击请求的访问者是否与签发时一致
+func (c ClickClaims) MatchesViewer(viewerID string) bool {
+	if c.Viewer == "" {
+		return true
+	}
+	return viewerID != "" && hmac.Equal([]byte(c.Viewer), []byte(viewerDigest(viewerID)))
+}
+
+// viewerDigest 访问者标识 (frequency.Capper.Identify 的结果) 的摘要，避免把 Cookie 或设备 ID 原样放进链接
+func viewerDigest(viewerID string) string {
+	sum := sha256.Sum256([]byte(viewerID))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// ClickSigner 签发和校验点击凭证，可以安全地并发使用。多个实例必须使用相同的密钥
+type ClickSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewClickSigner 创建 ClickSigner。ttl 为凭证的有效期 (serving.click_token_ttl)
+func NewClickSigner(secret string, ttl time.Duration) *ClickSigner {
+	return &ClickSigner{key: []byte(secret), ttl: ttl}
+}
+
+// Issue 为一次展示签发点击凭证，claims 中填写活动、创意、广告位和点击成交价，有效期和 Nonce 由这里生成。
+// viewerID 为空时不绑定访问者
+func (s *ClickSigner) Issue(claims ClickClaims, viewerID string, now time.Time) (string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+	claims.ExpiresAt = now.Add(s.ttl).Unix()
+	claims.Nonce = hex.EncodeToString(nonce[:])
+	claims.Viewer = ""
+	if viewerID != "" {
+		claims.Viewer = viewerDigest(viewerID)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+// Verify 校验签名并解析凭证。签名有效但已过期时同时返回内容和 ErrClickTokenExpired
+func (s *ClickSigner) Verify(token string, now time.Time) (ClickClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(encoded))) {
+		return ClickClaims{}, ErrInvalidClickToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ClickClaims{}, ErrInvalidClickToken
+	}
+	var claims ClickClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Nonce == "" {
+		return ClickClaims{}, ErrInvalidClickToken
+	}
+	if now.Unix() > claims.ExpiresAt {
+		return claims, ErrClickTokenExpired
+	}
+	return claims, nil
+}
+
+func (s *ClickSigner) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,261 @@
+// Package serving 维护广告投放索引：可投放的广告活动及其创意在内存中的快照。
+//
+// GET /get-ad 直接从快照中选择广告，不再每次请求都执行 ORDER BY RAND() 并再查询一次创意。
+// 快照同时包含广告位，GET /get-ad?placement= 只在尺寸和格式匹配的创意中选择。
+// 有定向条件的活动只投放给满足条件的访问者 (见 targeting.Matches)，访问者已达到频次上限的活动不参与 (见 frequency.Allowed)。
+// 符合条件的广告交给 AdSelector 选择，默认按 eCPM 竞价、第二价格成交 (见 SecondPriceAuction)。
+// 快照定期整体刷新 (serving.refresh_interval)，活动状态、余额、广告位等变化时调用 Invalidate 立即触发刷新。
+//
+// 快照可能短暂过期 (例如其他实例上暂停了活动)，计费时 store.ChargeAdEvent 会再次确认活动可以投放、
+// 预算和余额充足；被拒绝的活动通过 Exclude 从当前快照中移除，直到下一次刷新。
+package serving
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"advertisement/internal/frequency"
+	"advertisement/internal/models"
+	"advertisement/internal/targeting"
+)
+
+// Source 是构建索引需要的存储接口 (store.Store 实现了它)
+type Source interface {
+	ListServableAds(ctx context.Context, now time.Time) ([]models.ServableAd, error)
+	ListPlacements(ctx context.Context) ([]models.Placement, error)
+}
+
+// snapshot 是一次刷新的结果，创建后不再修改 (Exclude 会复制出新的快照)
+type snapshot struct {
+	ads        []entry
+	bySize     map[size][]int // 创意尺寸 -> ads 中的下标
+	placements map[int]*models.Placement
+	loadedAt   time.Time
+}
+
+// size 创意或广告位的尺寸
+type size struct {
+	width, height int
+}
+
+// newSnapshot 按尺寸建立 ads 的索引
+func newSnapshot(ads []entry, placements map[int]*models.Placement, loadedAt time.Time) *snapshot {
+	s := &snapshot{ads: ads, bySize: make(map[size][]int), placements: placements, loadedAt: loadedAt}
+	for i := range ads {
+		k := size{ads[i].ad.Width, ads[i].ad.Height}
+		s.bySize[k] = append(s.bySize[k], i)
+	}
+	return s
+}
+
+// entry 是快照中的一条记录，预先计算结束日期和 eCPM
+type entry struct {
+	ad     models.ServableAd
+	endDay int   // YYYYMMDD，选择时按日期比较
+	ecpm   int64 // 见 CTRModel.ECPM
+}
+
+// dayNumber 把日期表示为 YYYYMMDD 整数 (按 t 自身的时区，与 Format("2006-01-02") 的比较结果一致)
+func dayNumber(t time.Time) int {
+	y, m, d := t.Date()
+	return y*10000 + int(m)*100 + d
+}
+
+// Options 投放索引的刷新间隔和选择策略
+type Options struct {
+	RefreshInterval time.Duration // 定期刷新的间隔，默认 30 秒
+	Selector        AdSelector    // 默认 SecondPriceAuction
+	CTR             CTRModel      // 预估 CPC 活动的点击率
+	ReservePrice    int64         // 最低成交价 (分 / 千次展示)，广告位底价低于它时以它为准
+}
+
+// Index 投放索引，可以安全地并发使用
+type Index struct {
+	source   Source
+	interval time.Duration
+	selector AdSelector
+	ctr      CTRModel
+	reserve  int64
+	pool     sync.Pool // *[]Candidate，避免每次选择都分配候选列表
+
+	current atomic.Pointer[snapshot]
+	mu      sync.Mutex    // 串行化快照的替换 (Refresh / Exclude)
+	refresh chan struct{} // Invalidate 发出的刷新请求，容量为 1，多次请求合并为一次
+}
+
+// NewIndex 创建投放索引。创建后需要先调用 Refresh 加载第一份快照
+func NewIndex(src Source, opts Options) *Index {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 30 * time.Second
+	}
+	if opts.Selector == nil {
+		opts.Selector = SecondPriceAuction{}
+	}
+	x := &Index{
+		source:   src,
+		interval: opts.RefreshInterval,
+		selector: opts.Selector,
+		ctr:      opts.CTR,
+		reserve:  opts.ReservePrice,
+		refresh:  make(chan struct{}, 1),
+	}
+	x.current.Store(newSnapshot(nil, nil, time.Time{}))
+	return x
+}
+
+// Refresh 从存储重新加载快照
+func (x *Index) Refresh(ctx context.Context) error {
+	now := time.Now()
+	ads, err := x.source.ListServableAds(ctx, now)
+	if err != nil {
+		return err
+	}
+	placements, err := x.source.ListPlacements(ctx)
+	if err != nil {
+		return err
+	}
+	entries := make([]entry, len(ads))
+	for i, ad := range ads {
+		entries[i] = entry{ad: ad, endDay: dayNumber(ad.EndDate), ecpm: x.ctr.ECPM(&ad)}
+	}
+	byID := make(map[int]*models.Placement, len(placements))
+	for i := range placements {
+		byID[placements[i].ID] = &placements[i]
+	}
+	next := newSnapshot(entries, byID, now)
+
+	x.mu.Lock()
+	x.current.Store(next)
+	x.mu.Unlock()
+	return nil
+}
+
+// Run 按间隔刷新快照，收到 Invalidate 的请求时立即刷新，直到 ctx 被取消。应在单独的 goroutine 中调用。
+func (x *Index) Run(ctx context.Context) {
+	log.Printf("serving: 投放索引已启动，刷新间隔 %s", x.interval)
+	ticker := time.NewTicker(x.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("serving: 投放索引已停止")
+			return
+		case <-ticker.C:
+		case <-x.refresh:
+		}
+		if err := x.Refresh(ctx); err != nil && ctx.Err() == nil {
+			// 刷新失败时继续使用旧快照
+			log.Printf("serving: 刷新投放索引失败: %v", err)
+		}
+	}
+}
+
+// Invalidate 请求尽快刷新快照 (不阻塞)。活动状态、账号状态、余额或广告位变化后调用
+func (x *Index) Invalidate() {
+	select {
+	case x.refresh <- struct{}{}:
+	default: // 已经有待处理的刷新请求
+	}
+}
+
+// Placement 返回快照中的广告位 (包括已停用的)，不存在返回 false。
+// 返回值与快照共享，调用方不能修改
+func (x *Index) Placement(id int) (*models.Placement, bool) {
+	p, ok := x.current.Load().placements[id]
+	return p, ok
+}
+
+// Request 选择广告的条件
+type Request struct {
+	Now       time.Time
+	Placement *models.Placement // 不为 nil 时只选择适合该广告位的创意 (见 Fits)
+	Viewer    *targeting.Viewer // 访问者，为 nil 时只选择没有定向条件的活动
+	Seen      frequency.History // 访问者最近的展示记录，用于检查活动的频次上限
+}
+
+// Pick 在快照中 req.Now 时可以投放、适合广告位、定向条件与访问者匹配且未达到频次上限的广告中，由 AdSelector 选出一个。
+// 底价为广告位底价和 ReservePrice 中较高者。快照中已过结束日期或尚未开始的活动 (等待下一次刷新移除) 会被跳过
+func (x *Index) Pick(req Request) (Selection, bool) {
+	cur := x.current.Load()
+	now, p := req.Now, req.Placement
+	n := len(cur.ads)
+	var indexes []int // 为 nil 时候选为全部 ads
+	floor := x.reserve
+	if p != nil {
+		indexes = cur.bySize[size{p.Width, p.Height}]
+		n = len(indexes)
+		floor = max(floor, p.FloorPrice.Minor)
+	}
+	if n == 0 {
+		return Selection{}, false
+	}
+
+	buf, _ := x.pool.Get().(*[]Candidate)
+	if buf == nil {
+		buf = new([]Candidate)
+	}
+	candidates := (*buf)[:0]
+	today := dayNumber(now)
+	for i := 0; i < n; i++ {
+		k := i
+		if indexes != nil {
+			k = indexes[i]
+		}
+		e := &cur.ads[k]
+		if e.ad.StartDate.After(now) || e.endDay < today {
+			continue
+		}
+		if p != nil && !Fits(&e.ad, p) {
+			continue
+		}
+		if !targeting.Matches(&e.ad.Targeting, req.Viewer) {
+			continue
+		}
+		if len(e.ad.FrequencyCaps) > 0 && !frequency.Allowed(e.ad.FrequencyCaps, req.Seen, e.ad.CampaignID, now) {
+			continue
+		}
+		candidates = append(candidates, Candidate{Ad: &e.ad, ECPM: e.ecpm})
+	}
+	sel, ok := x.selector.Select(candidates, floor)
+	if ok && sel.Ad.PricingModel == models.PricingCPC {
+		sel.ClickPrice = x.ctr.ClickPrice(&sel.Ad, sel.Price)
+	}
+
+	clear(candidates) // 不让池中的列表引用旧快照
+	*buf = candidates[:0]
+	x.pool.Put(buf)
+	return sel, ok
+}
+
+// Fits 检查创意能否投放到广告位：尺寸和格式匹配。底价在选择时按 eCPM 比较 (见 AdSelector)
+func Fits(ad *models.ServableAd, p *models.Placement) bool {
+	return p.Accepts(ad.Width, ad.Height, ad.Format)
+}
+
+// Exclude 从当前快照中移除活动，直到下一次刷新 (计费时发现活动已不能投放、预算或余额用完)
+func (x *Index) Exclude(campaignID int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	cur := x.current.Load()
+	for i := range cur.ads {
+		if cur.ads[i].ad.CampaignID != campaignID {
+			continue
+		}
+		ads := make([]entry, 0, len(cur.ads)-1)
+		ads = append(ads, cur.ads[:i]...)
+		ads = append(ads, cur.ads[i+1:]...)
+		x.current.Store(newSnapshot(ads, cur.placements, cur.loadedAt))
+		return
+	}
+}
+
+// Stats 返回快照中的广告数量和加载时间
+func (x *Index) Stats() (size int, loadedAt time.Time) {
+	cur := x.current.Load()
+	return len(cur.ads), cur.loadedAt
+}
@@ -0,0 +1,151 @@
+package serving_test
+
+// 比较 GET /get-ad 选择广告的两种方式：
+//   - Database: 原来的做法，每次请求 GetRandomActiveCampaign (ORDER BY RANDOM()) + GetAdvertisementByID
+//   - Index:    从投放索引的内存快照中选择
+//
+// 运行: go test ./internal/serving -run '^$' -bench . -benchmem
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"advertisement/internal/migrate"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+	"advertisement/internal/serving"
+	"advertisement/internal/store"
+)
+
+var benchSizes = []int{10, 100, 1000}
+
+// newBenchStore 创建一个迁移到最新版本的 SQLite 数据库，并写入 n 个可投放的广告活动
+func newBenchStore(b *testing.B, n int) store.Store {
+	b.Helper()
+	ctx := context.Background()
+	db, err := store.OpenSQLite(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db, "sqlite")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	now := time.Now()
+	res, err := db.ExecContext(ctx, "INSERT INTO users (username, password_hash, created_at) VALUES ('bench', 'x', ?)", now)
+	if err != nil {
+		b.Fatal(err)
+	}
+	userID, _ := res.LastInsertId()
+	res, err = db.ExecContext(ctx, "INSERT INTO organizations (name, balance, created_at) VALUES ('bench', 100000000, ?)", now)
+	if err != nil {
+		b.Fatal(err)
+	}
+	orgID, _ := res.LastInsertId()
+
+	start, end := now.AddDate(0, 0, -1), now.AddDate(1, 0, 0)
+	for i := 0; i < n; i++ {
+		res, err := db.ExecContext(ctx, `INSERT INTO advertisements (title, image_url, target_url, user_id, organization_id, status)
+            VALUES (?, 'https://example.com/ad.png', 'https://example.com', ?, ?, 'Approved')`, fmt.Sprintf("ad %d", i), userID, orgID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		adID, _ := res.LastInsertId()
+		if _, err := db.ExecContext(ctx, `INSERT INTO ad_campaigns (advertisement_id, user_id, organization_id, start_date, end_date, status, bid_amount)
+            VALUES (?, ?, ?, ?, ?, 'Active', 100)`, adID, userID, orgID, start, end); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return store.NewSQLiteStore(db)
+}
+
+func BenchmarkSelectAd(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		s := newBenchStore(b, n)
+
+		b.Run(fmt.Sprintf("Database/campaigns=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				campaign, err := s.GetRandomActiveCampaign(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := s.GetAdvertisementByID(ctx, campaign.AdvertisementID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		ix := serving.NewIndex(s, serving.Options{RefreshInterval: time.Minute})
+		if err := ix.Refresh(ctx); err != nil {
+			b.Fatal(err)
+		}
+		if size, _ := ix.Stats(); size != n {
+			b.Fatalf("index size = %d, want %d", size, n)
+		}
+		b.Run(fmt.Sprintf("Index/campaigns=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, ok := ix.Pick(serving.Request{Now: time.Now()}); !ok {
+					b.Fatal("no ad picked")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("IndexParallel/campaigns=%d", n), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, ok := ix.Pick(serving.Request{Now: time.Now()}); !ok {
+						b.Fatal("no ad picked")
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRefresh 重新加载快照的开销 (定期刷新和 Invalidate 时在后台执行)
+func BenchmarkRefresh(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		ix := serving.NewIndex(newBenchStore(b, n), serving.Options{RefreshInterval: time.Minute})
+		b.Run(fmt.Sprintf("campaigns=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := ix.Refresh(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSelector 各选择策略在 n 个候选中选择一次的开销 (不含日期、广告位和定向的过滤)
+func BenchmarkSelector(b *testing.B) {
+	selectors := map[string]serving.AdSelector{
+		serving.SelectorAuction: serving.SecondPriceAuction{},
+		serving.SelectorRandom:  serving.RandomSelector{},
+	}
+	for _, n := range benchSizes {
+		ads := make([]models.ServableAd, n)
+		candidates := make([]serving.Candidate, n)
+		for i := range ads {
+			ads[i] = models.ServableAd{CampaignID: i + 1, PricingModel: models.PricingCPM, BidAmount: money.New(int64(100 + i%50))}
+			candidates[i] = serving.Candidate{Ad: &ads[i], ECPM: ads[i].BidAmount.Minor}
+		}
+		for name, sel := range selectors {
+			b.Run(fmt.Sprintf("%s/candidates=%d", name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, ok := sel.Select(candidates, 105); !ok {
+						b.Fatal("no ad selected")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package serving
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+)
+
+// recordTimeout 后台记录一个展示事件 (写入事件、扣费) 的最长时间
+const recordTimeout = 10 * time.Second
+
+// Charger 记录广告事件并扣费 (store.Store 实现了它)
+type Charger interface {
+	ChargeAdEvent(ctx context.Context, event *models.AdEvent) error
+}
+
+// Recorder 在后台记录展示事件并扣费，GET /get-ad 选出广告后不再等待数据库。
+// 事件放入有界队列，由固定数量的 worker 依次调用 ChargeAdEvent，数据库上同时进行的扣费不超过 worker 数；
+// 队列已满时 Enqueue 返回 false，调用方应同步记录。
+//
+// 代价是展示先于扣费：活动的预算、余额或状态在快照生成后发生变化时，worker 收到拒绝后才把活动从投放索引中移除，
+// 在此之前已经返回的展示不会计费。为了限制这部分免费展示，每个活动在队列中等待记录的事件最多 pendingLimit 个，
+// 超过时 Enqueue 返回 false，调用方同步记录 (被拒绝的活动这次就不展示)，因此每个活动不计费的展示最多约 pendingLimit 次。
+// 可以安全地并发使用
+type Recorder struct {
+	charger      Charger
+	index        *Index
+	pendingLimit int
+
+	mu      sync.Mutex // 保护 closed 和 pending，保证 Close 之后不再向 queue 发送
+	closed  bool
+	pending map[int]int // 每个活动在队列中 (含正在记录) 的事件数
+	queue   chan models.AdEvent
+	wg      sync.WaitGroup
+}
+
+// NewRecorder 创建 Recorder 并启动 workers 个 worker，queueSize 为队列容量，
+// pendingLimit 为每个活动在队列中最多等待记录的事件数。计费时被拒绝的活动 (见 Rejected) 从 index 中移除
+func NewRecorder(c Charger, index *Index, workers, queueSize, pendingLimit int) *Recorder {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	if pendingLimit <= 0 {
+		pendingLimit = 1
+	}
+	r := &Recorder{charger: c, index: index, pendingLimit: pendingLimit,
+		pending: make(map[int]int), queue: make(chan models.AdEvent, queueSize)}
+	r.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// Enqueue 把事件放入队列 (不阻塞)，队列已满、活动等待记录的事件已达上限或 Recorder 已关闭时返回 false
+func (r *Recorder) Enqueue(event models.AdEvent) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.pending[event.CampaignID] >= r.pendingLimit {
+		return false
+	}
+	select {
+	case r.queue <- event:
+		r.pending[event.CampaignID]++
+		return true
+	default:
+		return false
+	}
+}
+
+// Pending 返回队列中等待记录的事件数
+func (r *Recorder) Pending() int {
+	return len(r.queue)
+}
+
+// Close 停止接收新事件，等待队列中的事件全部记录完再返回。服务停止时调用，之后 Enqueue 总是返回 false
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (r *Recorder) work() {
+	defer r.wg.Done()
+	for event := range r.queue {
+		r.record(&event)
+		r.done(event.CampaignID)
+	}
+}
+
+// done 活动的一个事件记录完成 (无论是否计费成功)
+func (r *Recorder) done(campaignID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[campaignID]--; r.pending[campaignID] <= 0 {
+		delete(r.pending, campaignID)
+	}
+}
+
+func (r *Recorder) record(event *models.AdEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	// 成功时不写日志：这是每次展示都会经过的路径
+	err := r.charger.ChargeAdEvent(ctx, event)
+	switch {
+	case Rejected(err):
+		// 快照生成之后活动的预算、余额或状态发生了变化，这次展示不计费，之后不再投放该活动
+		log.Printf("serving: 活动 %d 已不能投放，从投放索引中移除: %v", event.CampaignID, err)
+		r.index.Exclude(event.CampaignID)
+	case err != nil:
+		log.Printf("serving: !!! 记录 %s 事件失败 (广告已返回): campaign %d, ad %d: %v",
+			event.EventType, event.CampaignID, event.AdvertisementID, err)
+	}
+}
+
+// Rejected 判断 ChargeAdEvent 的错误是否表示活动已不能投放 (预算或余额用完、状态变化或已删除)，
+// 这时应把活动从投放索引中移除
+func Rejected(err error) bool {
+	return errors.Is(err, store.ErrBudgetExhausted) || errors.Is(err, store.ErrInsufficientBalance) ||
+		errors.Is(err, store.ErrCampaignNotServable) || errors.Is(err, store.ErrNotFound)
+}
@@ -0,0 +1,148 @@
+package serving_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/serving"
+	"advertisement/internal/store"
+)
+
+// fakeCharger 记录收到的事件，按活动返回预先设置的错误；block 不为 nil 时每次扣费先等待它关闭
+type fakeCharger struct {
+	mu      sync.Mutex
+	charged []int // 扣费成功的活动 ID
+	errs    map[int]error
+	block   chan struct{}
+}
+
+func (c *fakeCharger) ChargeAdEvent(ctx context.Context, event *models.AdEvent) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs[event.CampaignID]; err != nil {
+		return err
+	}
+	c.charged = append(c.charged, event.CampaignID)
+	return nil
+}
+
+func TestRecorderDrainsOnClose(t *testing.T) {
+	c := &fakeCharger{}
+	r := serving.NewRecorder(c, serving.NewIndex(fakeSource{}, serving.Options{RefreshInterval: time.Minute}), 4, 100, 100)
+	for i := 1; i <= 100; i++ {
+		if !r.Enqueue(models.AdEvent{EventType: "Impression", CampaignID: i}) {
+			t.Fatalf("Enqueue(%d) = false", i)
+		}
+	}
+	r.Close()
+	if len(c.charged) != 100 {
+		t.Errorf("charged %d events, want 100", len(c.charged))
+	}
+	if r.Enqueue(models.AdEvent{CampaignID: 1}) {
+		t.Error("Enqueue after Close = true, want false")
+	}
+	r.Close() // 重复调用无影响
+}
+
+func TestRecorderQueueFull(t *testing.T) {
+	c := &fakeCharger{block: make(chan struct{})}
+	r := serving.NewRecorder(c, serving.NewIndex(fakeSource{}, serving.Options{RefreshInterval: time.Minute}), 1, 2, 10)
+	// worker 取走第一个事件后阻塞，队列还能再放 2 个
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if r.Enqueue(models.AdEvent{CampaignID: 1}) {
+			accepted++
+		}
+	}
+	if accepted < 2 || accepted > 3 {
+		t.Errorf("accepted %d events, want 2 or 3", accepted)
+	}
+	close(c.block)
+	r.Close()
+	if len(c.charged) != accepted {
+		t.Errorf("charged %d events, want %d", len(c.charged), accepted)
+	}
+}
+
+func TestRecorderPendingLimit(t *testing.T) {
+	c := &fakeCharger{block: make(chan struct{})}
+	r := serving.NewRecorder(c, serving.NewIndex(fakeSource{}, serving.Options{RefreshInterval: time.Minute}), 1, 100, 3)
+	// 每个活动最多 3 个事件等待记录，超出时调用方改为同步记录；其他活动不受影响
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if r.Enqueue(models.AdEvent{CampaignID: 1}) {
+			accepted++
+		}
+	}
+	if accepted != 3 {
+		t.Errorf("accepted %d events of campaign 1, want 3", accepted)
+	}
+	if !r.Enqueue(models.AdEvent{CampaignID: 2}) {
+		t.Error("Enqueue(campaign 2) = false, want true")
+	}
+	close(c.block)
+	r.Close()
+	if len(c.charged) != 4 {
+		t.Errorf("charged %d events, want 4", len(c.charged))
+	}
+}
+
+func TestRecorderPendingLimitReleased(t *testing.T) {
+	c := &fakeCharger{}
+	r := serving.NewRecorder(c, serving.NewIndex(fakeSource{}, serving.Options{RefreshInterval: time.Minute}), 1, 100, 1)
+	defer r.Close()
+	// 记录完成后名额释放，同一个活动可以继续放入队列
+	for i := 0; i < 5; i++ {
+		deadline := time.Now().Add(5 * time.Second)
+		for !r.Enqueue(models.AdEvent{CampaignID: 1}) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Enqueue %d: pending slot never released", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestRecorderExcludesRejectedCampaigns(t *testing.T) {
+	now := time.Now()
+	var ads []models.ServableAd
+	for id := 1; id <= 5; id++ {
+		ads = append(ads, models.ServableAd{CampaignID: id, AdvertisementID: id, PricingModel: models.PricingCPM,
+			StartDate: now.AddDate(0, 0, -1), EndDate: now.AddDate(0, 0, 1)})
+	}
+	x := serving.NewIndex(fakeSource{ads: ads}, serving.Options{RefreshInterval: time.Minute})
+	if err := x.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeCharger{errs: map[int]error{
+		1: store.ErrBudgetExhausted,
+		2: store.ErrInsufficientBalance,
+		3: fmt.Errorf("wrapped: %w", store.ErrCampaignNotServable),
+		4: fmt.Errorf("store: connection refused"), // 其他错误不移除活动
+	}}
+	r := serving.NewRecorder(c, x, 2, 10, 10)
+	for id := 1; id <= 5; id++ {
+		r.Enqueue(models.AdEvent{EventType: "Impression", CampaignID: id})
+	}
+	r.Close()
+
+	if size, _ := x.Stats(); size != 2 {
+		t.Errorf("index size = %d, want 2", size)
+	}
+	for i := 0; i < 50; i++ {
+		sel, ok := x.Pick(serving.Request{Now: now})
+		if !ok {
+			t.Fatal("no ad selected")
+		}
+		if id := sel.Ad.CampaignID; id != 4 && id != 5 {
+			t.Fatalf("picked excluded campaign %d", id)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package serving
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"advertisement/internal/models"
+)
+
+// 选择策略 (serving.selector)
+const (
+	SelectorAuction = "auction"
+	SelectorRandom  = "random"
+)
+
+// Candidate 一个可以投放给本次请求的广告 (日期、广告位尺寸和格式、定向都已满足) 及其出价
+type Candidate struct {
+	Ad   *models.ServableAd // 与快照共享，不能修改
+	ECPM int64              // 每千次展示的预估收益 (分)：CPM 活动为出价，CPC 活动为 出价 × 预估点击率 × 1000
+}
+
+// Selection 选择结果
+type Selection struct {
+	Ad    models.ServableAd
+	ECPM  int64 // 胜出广告的 eCPM (分)
+	Price int64 // 成交价 (分 / 千次展示)，记录在 Impression 事件上；CPM 活动按它计费
+	// ClickPrice CPC 活动每次点击的成交价 (分)：Price 按选择时的预估点击率换算回每次点击的价格，不超过出价 (见 CTRModel.ClickPrice)。
+	// 由 Index.Pick 填写，CPM 活动为 0
+	ClickPrice int64
+}
+
+// AdSelector 从候选广告中选出一个并确定成交价。floor 是底价 (分 / 千次展示)，eCPM 低于底价的候选不能胜出。
+// candidates 在调用返回后会被复用，实现不能保留它。实现必须可以安全地并发使用
+type AdSelector interface {
+	Select(candidates []Candidate, floor int64) (Selection, bool)
+}
+
+// NewSelector 按名称 (serving.selector) 创建选择策略
+func NewSelector(name string) (AdSelector, error) {
+	switch name {
+	case SelectorAuction, "":
+		return SecondPriceAuction{}, nil
+	case SelectorRandom:
+		return RandomSelector{}, nil
+	}
+	return nil, fmt.Errorf("serving: unknown selector %q", name)
+}
+
+// SecondPriceAuction 第二价格竞价：eCPM 最高的候选胜出 (相同时随机)，
+// 成交价为第二高的 eCPM 与底价中较高者，不超过胜出者自己的 eCPM，最低 1 分
+type SecondPriceAuction struct{}
+
+func (SecondPriceAuction) Select(candidates []Candidate, floor int64) (Selection, bool) {
+	// 第一遍：最高和第二高的 eCPM，以及出价最高的候选个数
+	top, second := int64(math.MinInt64), int64(math.MinInt64)
+	ties := 0
+	for i := range candidates {
+		e := candidates[i].ECPM
+		switch {
+		case e < floor:
+		case e > top:
+			second, top, ties = top, e, 1
+		case e == top:
+			second = e // 出价相同时第二价格就是这个出价
+			ties++
+		case e > second:
+			second = e
+		}
+	}
+	if ties == 0 {
+		return Selection{}, false
+	}
+	// 第二遍：在出价最高的候选中均匀随机选择
+	k := rand.IntN(ties)
+	for i := range candidates {
+		c := &candidates[i]
+		if c.ECPM != top {
+			continue
+		}
+		if k > 0 {
+			k--
+			continue
+		}
+		price := min(max(second, floor, 1), top)
+		return Selection{Ad: *c.Ad, ECPM: top, Price: price}, true
+	}
+	return Selection{}, false // 不会到达
+}
+
+// RandomSelector 在不低于底价的候选中均匀随机选择，成交价为胜出者自己的 eCPM (即按出价计费，原来的投放方式)。
+// 与 SecondPriceAuction 一样成交价不低于底价，最低 1 分，eCPM 为 0 的活动也不会按 0 成交
+type RandomSelector struct{}
+
+func (RandomSelector) Select(candidates []Candidate, floor int64) (Selection, bool) {
+	n := 0
+	for i := range candidates {
+		if candidates[i].ECPM >= floor {
+			n++
+		}
+	}
+	if n == 0 {
+		return Selection{}, false
+	}
+	k := rand.IntN(n)
+	for i := range candidates {
+		c := &candidates[i]
+		if c.ECPM < floor {
+			continue
+		}
+		if k > 0 {
+			k--
+			continue
+		}
+		return Selection{Ad: *c.Ad, ECPM: c.ECPM, Price: max(c.ECPM, floor, 1)}, true
+	}
+	return Selection{}, false // 不会到达
+}
+
+// CTRModel 预估 CPC 活动的点击率：历史点击率向先验点击率平滑，展示次数少时接近先验值
+type CTRModel struct {
+	PriorCTR         float64 // 先验点击率 (serving.default_ctr)
+	PriorImpressions float64 // 先验相当于多少次展示 (serving.ctr_prior_impressions)
+}
+
+// Predict 返回 (点击 + 先验点击率 × 先验展示) / (展示 + 先验展示)
+func (m CTRModel) Predict(impressions, clicks int64) float64 {
+	denominator := float64(impressions) + m.PriorImpressions
+	if denominator <= 0 {
+		return m.PriorCTR
+	}
+	return (float64(clicks) + m.PriorCTR*m.PriorImpressions) / denominator
+}
+
+// ClickPrice 把 CPC 活动的成交价 (分 / 千次展示) 换算为每次点击的价格：成交价 / (预估点击率 × 1000)，
+// 四舍五入到分，最低 1 分，不超过出价。使用与 ECPM 相同的预估点击率，胜出者按第二价格而不是自己的出价计费
+func (m CTRModel) ClickPrice(ad *models.ServableAd, price int64) int64 {
+	ctr := m.Predict(ad.Impressions, ad.Clicks)
+	bid := ad.BidAmount.Minor
+	if ctr <= 0 || price <= 0 {
+		return bid
+	}
+	return min(max(int64(math.Round(float64(price)/(ctr*1000))), 1), bid)
+}
+
+// ECPM 计算广告每千次展示的预估收益 (分)
+func (m CTRModel) ECPM(ad *models.ServableAd) int64 {
+	if ad.PricingModel == models.PricingCPC {
+		return int64(math.Round(float64(ad.BidAmount.Minor) * m.Predict(ad.Impressions, ad.Clicks) * 1000))
+	}
+	return ad.BidAmount.Minor
+}
@@ -0,0 +1,258 @@
+package serving_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+	"advertisement/internal/serving"
+)
+
+// candidates 按 eCPM 构造候选，活动 ID 依次为 1, 2, 3...
+func candidates(ecpms ...int64) []serving.Candidate {
+	out := make([]serving.Candidate, len(ecpms))
+	for i, e := range ecpms {
+		out[i] = serving.Candidate{Ad: &models.ServableAd{CampaignID: i + 1}, ECPM: e}
+	}
+	return out
+}
+
+func TestSecondPriceAuction(t *testing.T) {
+	tests := []struct {
+		name       string
+		ecpms      []int64
+		floor      int64
+		wantOK     bool
+		wantWinner []int // 可能胜出的活动 ID (出价相同时任意一个)
+		wantECPM   int64
+		wantPrice  int64
+	}{
+		{name: "no candidates", wantOK: false},
+		{name: "single bidder pays minimum", ecpms: []int64{500}, wantOK: true, wantWinner: []int{1}, wantECPM: 500, wantPrice: 1},
+		{name: "single bidder pays floor", ecpms: []int64{500}, floor: 120, wantOK: true, wantWinner: []int{1}, wantECPM: 500, wantPrice: 120},
+		{name: "single bidder below floor", ecpms: []int64{100}, floor: 120, wantOK: false},
+		{name: "bid equal to floor wins at floor", ecpms: []int64{120}, floor: 120, wantOK: true, wantWinner: []int{1}, wantECPM: 120, wantPrice: 120},
+		{name: "highest eCPM wins at second price", ecpms: []int64{300, 500, 200}, wantOK: true, wantWinner: []int{2}, wantECPM: 500, wantPrice: 300},
+		{name: "second price below floor clears at floor", ecpms: []int64{500, 100}, floor: 200, wantOK: true, wantWinner: []int{1}, wantECPM: 500, wantPrice: 200},
+		{name: "candidates below floor do not set the price", ecpms: []int64{90, 500, 150}, floor: 100, wantOK: true, wantWinner: []int{2}, wantECPM: 500, wantPrice: 150},
+		{name: "all below floor", ecpms: []int64{50, 80}, floor: 100, wantOK: false},
+		{name: "tie clears at the tied bid", ecpms: []int64{400, 100, 400}, wantOK: true, wantWinner: []int{1, 3}, wantECPM: 400, wantPrice: 400},
+		{name: "free campaign clears at zero", ecpms: []int64{0}, wantOK: true, wantWinner: []int{1}, wantECPM: 0, wantPrice: 0},
+		{name: "price never exceeds winner", ecpms: []int64{1, 1}, wantOK: true, wantWinner: []int{1, 2}, wantECPM: 1, wantPrice: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, ok := serving.SecondPriceAuction{}.Select(candidates(tt.ecpms...), tt.floor)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if !containsInt(tt.wantWinner, sel.Ad.CampaignID) {
+				t.Errorf("winner = %d, want one of %v", sel.Ad.CampaignID, tt.wantWinner)
+			}
+			if sel.ECPM != tt.wantECPM || sel.Price != tt.wantPrice {
+				t.Errorf("eCPM, price = %d, %d, want %d, %d", sel.ECPM, sel.Price, tt.wantECPM, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestSecondPriceAuctionBreaksTiesAtRandom(t *testing.T) {
+	wins := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		sel, ok := serving.SecondPriceAuction{}.Select(candidates(400, 100, 400), 0)
+		if !ok {
+			t.Fatal("no winner")
+		}
+		wins[sel.Ad.CampaignID]++
+	}
+	if wins[1] == 0 || wins[3] == 0 || wins[2] != 0 {
+		t.Errorf("wins = %v, want both tied bidders to win and the lower bidder never", wins)
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	tests := []struct {
+		name       string
+		ecpms      []int64
+		floor      int64
+		wantOK     bool
+		wantWinner []int
+	}{
+		{name: "no candidates", wantOK: false},
+		{name: "all below floor", ecpms: []int64{50, 80}, floor: 100, wantOK: false},
+		{name: "only candidates at or above floor", ecpms: []int64{50, 100, 300}, floor: 100, wantOK: true, wantWinner: []int{2, 3}},
+		{name: "zero eCPM pays minimum", ecpms: []int64{0, 0}, wantOK: true, wantWinner: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				sel, ok := serving.RandomSelector{}.Select(candidates(tt.ecpms...), tt.floor)
+				if ok != tt.wantOK {
+					t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+				}
+				if !ok {
+					return
+				}
+				if !containsInt(tt.wantWinner, sel.Ad.CampaignID) {
+					t.Fatalf("winner = %d, want one of %v", sel.Ad.CampaignID, tt.wantWinner)
+				}
+				// 按出价计费：成交价就是胜出者自己的 eCPM，最低 1 分
+				want := tt.ecpms[sel.Ad.CampaignID-1]
+				if wantPrice := max(want, 1); sel.ECPM != want || sel.Price != wantPrice {
+					t.Fatalf("eCPM, price = %d, %d, want %d, %d", sel.ECPM, sel.Price, want, wantPrice)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    serving.AdSelector
+		wantErr bool
+	}{
+		{name: "", want: serving.SecondPriceAuction{}},
+		{name: serving.SelectorAuction, want: serving.SecondPriceAuction{}},
+		{name: serving.SelectorRandom, want: serving.RandomSelector{}},
+		{name: "first-price", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := serving.NewSelector(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewSelector(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NewSelector(%q) = %T, want %T", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCTRModelPredict(t *testing.T) {
+	tests := []struct {
+		name                string
+		model               serving.CTRModel
+		impressions, clicks int64
+		want                float64
+	}{
+		{name: "new campaign uses prior", model: serving.CTRModel{PriorCTR: 0.01, PriorImpressions: 1000}, want: 0.01},
+		{name: "no prior weight and no history", model: serving.CTRModel{PriorCTR: 0.02}, want: 0.02},
+		{name: "history without prior weight", model: serving.CTRModel{PriorCTR: 0.01}, impressions: 1000, clicks: 50, want: 0.05},
+		{name: "history smoothed toward prior", model: serving.CTRModel{PriorCTR: 0.01, PriorImpressions: 1000}, impressions: 1000, clicks: 50, want: 0.03},
+	}
+	for _, tt := range tests {
+		if got := tt.model.Predict(tt.impressions, tt.clicks); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: Predict(%d, %d) = %v, want %v", tt.name, tt.impressions, tt.clicks, got, tt.want)
+		}
+	}
+}
+
+func TestCTRModelECPMAndClickPrice(t *testing.T) {
+	model := serving.CTRModel{PriorCTR: 0.01, PriorImpressions: 1000}
+	tests := []struct {
+		name          string
+		pricing       string
+		bid           int64
+		impressions   int64
+		clicks        int64
+		price         int64 // 成交价 (分 / 千次展示)
+		wantECPM      int64
+		wantClickCost int64
+	}{
+		{name: "CPM eCPM is the bid", pricing: models.PricingCPM, bid: 350, price: 200, wantECPM: 350, wantClickCost: 350},
+		// 预估点击率 1%：eCPM = 100 × 0.01 × 1000
+		{name: "CPC converts bid to eCPM", pricing: models.PricingCPC, bid: 100, price: 1000, wantECPM: 1000, wantClickCost: 100},
+		{name: "CPC second price converts back per click", pricing: models.PricingCPC, bid: 100, price: 500, wantECPM: 1000, wantClickCost: 50},
+		{name: "CPC click price rounds to the cent", pricing: models.PricingCPC, bid: 100, price: 333, wantECPM: 1000, wantClickCost: 33},
+		{name: "CPC click price is at least one cent", pricing: models.PricingCPC, bid: 100, price: 1, wantECPM: 1000, wantClickCost: 1},
+		{name: "CPC click price never exceeds bid", pricing: models.PricingCPC, bid: 100, price: 5000, wantECPM: 1000, wantClickCost: 100},
+		// 历史点击率 5%，平滑后 3%：eCPM = 100 × 0.03 × 1000，成交价 1500 换算回 50 分
+		{name: "CPC uses smoothed CTR", pricing: models.PricingCPC, bid: 100, impressions: 1000, clicks: 50, price: 1500, wantECPM: 3000, wantClickCost: 50},
+	}
+	for _, tt := range tests {
+		ad := &models.ServableAd{PricingModel: tt.pricing, BidAmount: money.New(tt.bid), Impressions: tt.impressions, Clicks: tt.clicks}
+		if got := model.ECPM(ad); got != tt.wantECPM {
+			t.Errorf("%s: ECPM = %d, want %d", tt.name, got, tt.wantECPM)
+		}
+		if tt.pricing != models.PricingCPC {
+			continue
+		}
+		if got := model.ClickPrice(ad, tt.price); got != tt.wantClickCost {
+			t.Errorf("%s: ClickPrice(%d) = %d, want %d", tt.name, tt.price, got, tt.wantClickCost)
+		}
+	}
+}
+
+// fakeSource 固定返回给定广告的 serving.Source
+type fakeSource struct {
+	ads []models.ServableAd
+}
+
+func (s fakeSource) ListServableAds(ctx context.Context, now time.Time) ([]models.ServableAd, error) {
+	return s.ads, nil
+}
+
+func (s fakeSource) ListPlacements(ctx context.Context) ([]models.Placement, error) {
+	return nil, nil
+}
+
+func TestIndexPickAuction(t *testing.T) {
+	now := time.Now()
+	ad := func(id int, pricing string, bid int64) models.ServableAd {
+		return models.ServableAd{
+			CampaignID: id, AdvertisementID: id, PricingModel: pricing, BidAmount: money.New(bid),
+			StartDate: now.AddDate(0, 0, -1), EndDate: now.AddDate(0, 0, 1), Width: 300, Height: 250,
+		}
+	}
+	tests := []struct {
+		name           string
+		ads            []models.ServableAd
+		reserve        int64
+		wantCampaign   int
+		wantPrice      int64
+		wantClickPrice int64
+	}{
+		// CPC 100 分 × 1% × 1000 = eCPM 1000，高于 CPM 出价 600，按 600 成交，每次点击 60 分
+		{name: "CPC beats CPM", ads: []models.ServableAd{ad(1, models.PricingCPM, 600), ad(2, models.PricingCPC, 100)},
+			wantCampaign: 2, wantPrice: 600, wantClickPrice: 60},
+		{name: "CPM beats CPC", ads: []models.ServableAd{ad(1, models.PricingCPM, 1200), ad(2, models.PricingCPC, 100)},
+			wantCampaign: 1, wantPrice: 1000, wantClickPrice: 0},
+		{name: "single CPC bidder pays reserve per click", ads: []models.ServableAd{ad(1, models.PricingCPC, 100)}, reserve: 250,
+			wantCampaign: 1, wantPrice: 250, wantClickPrice: 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := serving.NewIndex(fakeSource{ads: tt.ads}, serving.Options{
+				CTR:          serving.CTRModel{PriorCTR: 0.01, PriorImpressions: 1000},
+				ReservePrice: tt.reserve,
+			})
+			if err := x.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			sel, ok := x.Pick(serving.Request{Now: now})
+			if !ok {
+				t.Fatal("no ad selected")
+			}
+			if sel.Ad.CampaignID != tt.wantCampaign || sel.Price != tt.wantPrice || sel.ClickPrice != tt.wantClickPrice {
+				t.Errorf("campaign, price, click price = %d, %d, %d, want %d, %d, %d",
+					sel.Ad.CampaignID, sel.Price, sel.ClickPrice, tt.wantCampaign, tt.wantPrice, tt.wantClickPrice)
+			}
+		})
+	}
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 邮箱验证 / 找回密码的一次性令牌 ---
+
+const userTokenColumns = "id, user_id, purpose, token_hash, email, created_at, expires_at, used_at"
+
+func scanUserToken(row interface{ Scan(...interface{}) error }) (*models.UserToken, error) {
+	var t models.UserToken
+	var usedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.Email, &t.CreatedAt, &t.ExpiresAt, &usedAt); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	return &t, nil
+}
+
+func (s *DBStore) CreateUserToken(ctx context.Context, t *models.UserToken, msg *models.OutboxMessage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for user token: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 同一用途只保留最新的令牌，之前发出的链接全部失效
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		t.CreatedAt, t.UserID, t.Purpose); err != nil {
+		return fmt.Errorf("store: failed to invalidate %s tokens of user %d: %w", t.Purpose, t.UserID, err)
+	}
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO user_tokens (user_id, purpose, token_hash, email, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, t.UserID, t.Purpose, t.TokenHash, t.Email, t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to create %s token for user %d: %w", t.Purpose, t.UserID, err)
+	}
+	t.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get user token id: %w", err)
+	}
+	if err := insertOutboxMessage(ctx, tx, msg); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit user token: %w", err)
+	}
+	return nil
+}
+
+// useUserToken 在事务中校验并消费一次性令牌 (加行锁，防止同一个令牌被并发使用两次)。
+// 令牌对应的邮箱必须仍是用户当前的邮箱
+func (s *DBStore) useUserToken(ctx context.Context, tx *sql.Tx, tokenHash, purpose string, now time.Time) (*models.UserToken, error) {
+	t, err := scanUserToken(tx.QueryRowContext(ctx,
+		"SELECT "+userTokenColumns+" FROM user_tokens WHERE token_hash = ? AND purpose = ?"+s.dialect.forUpdate, tokenHash, purpose))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get %s token: %w", purpose, err)
+	}
+	if t.UsedAt != nil {
+		return nil, ErrNotFound
+	}
+	if !now.Before(t.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	var email sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?"+s.dialect.forUpdate, t.UserID).Scan(&email); err != nil {
+		return nil, fmt.Errorf("store: failed to get email of user %d: %w", t.UserID, err)
+	}
+	if !email.Valid || email.String != t.Email {
+		return nil, ErrNotFound
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", now, t.ID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to use %s token %d: %w", purpose, t.ID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	t.UsedAt = &now
+	return t, nil
+}
+
+func (s *DBStore) VerifyEmail(ctx context.Context, tokenHash string, now time.Time) (*models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to begin transaction for email verification: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := s.useUserToken(ctx, tx, tokenHash, models.TokenPurposeVerifyEmail, now)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ?", now, t.UserID); err != nil {
+		return nil, fmt.Errorf("store: failed to verify email of user %d: %w", t.UserID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("store: failed to commit email verification: %w", err)
+	}
+	return s.GetUserByID(ctx, t.UserID)
+}
+
+func (s *DBStore) ResetPassword(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (*models.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to begin transaction for password reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := s.useUserToken(ctx, tx, tokenHash, models.TokenPurposeResetPassword, now)
+	if err != nil {
+		return nil, err
+	}
+	// 能收到重置邮件说明邮箱属于该用户，顺便完成验证
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET password_hash = ?, email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ?",
+		passwordHash, now, t.UserID); err != nil {
+		return nil, fmt.Errorf("store: failed to reset password of user %d: %w", t.UserID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("store: failed to commit password reset: %w", err)
+	}
+	return s.GetUserByID(ctx, t.UserID)
+}
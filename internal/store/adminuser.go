@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 后台用户管理 ---
+
+// likeEscaper 转义 LIKE 模式中的通配符，配合 ESCAPE '!' 使用 (MySQL 和 SQLite 都支持)
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// containsPattern 返回不区分大小写的 "包含 q" LIKE 模式 (列需要先 LOWER)
+func containsPattern(q string) string {
+	return "%" + likeEscaper.Replace(strings.ToLower(q)) + "%"
+}
+
+func (s *DBStore) ListUsers(ctx context.Context, filter models.AdminUserFilter) ([]models.User, error) {
+	var conditions []string
+	var args []interface{}
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		conditions = append(conditions, "(LOWER(username) LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!')")
+		args = append(args, containsPattern(q), containsPattern(q))
+	}
+	if filter.Role != nil {
+		conditions = append(conditions, "role = ?")
+		args = append(args, *filter.Role)
+	}
+	if filter.Status != nil {
+		if *filter.Status == models.UserStatusSuspended {
+			conditions = append(conditions, "suspended_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "suspended_at IS NULL")
+		}
+	}
+
+	query := "SELECT " + userColumns + " FROM users"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: failed to scan user row: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating user rows: %w", err)
+	}
+	return users, nil
+}
+
+func (s *DBStore) SetUserSuspension(ctx context.Context, userID int, suspendedAt *time.Time, reason *string) error {
+	var at sql.NullTime
+	var why sql.NullString
+	if suspendedAt != nil {
+		at = sql.NullTime{Time: *suspendedAt, Valid: true}
+		if reason != nil {
+			why = sql.NullString{String: *reason, Valid: true}
+		}
+	}
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET suspended_at = ?, suspension_reason = ? WHERE id = ?", at, why, userID)
+	if err != nil {
+		return fmt.Errorf("store: failed to update suspension of user %d: %w", userID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		// MySQL 在值未变化时也返回 0，确认用户是否存在
+		if _, err := s.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DBStore) GetAdvertisementsByUserID(ctx context.Context, userID int) ([]models.Advertisement, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+advertisementColumns+`
+		FROM advertisements
+		WHERE user_id = ?
+		ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query advertisements of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var ads []models.Advertisement
+	for rows.Next() {
+		var ad models.Advertisement
+		if err := rows.Scan(advertisementScanDest(&ad)...); err != nil {
+			log.Printf("store: failed to scan advertisement row: %v", err)
+			return nil, fmt.Errorf("store: failed to process advertisement list: %w", err)
+		}
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating advertisement rows: %w", err)
+	}
+	return ads, nil
+}
+
+func (s *DBStore) GetAdCampaignsByUserID(ctx context.Context, userID int) ([]models.CampaignWithAdDetails, error) {
+	rows, err := s.db.QueryContext(ctx, campaignWithAdQuery+" WHERE camp.user_id = ? ORDER BY camp.created_at DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query campaigns of user %d: %w", userID, err)
+	}
+	return scanCampaignsWithAd(rows)
+}
+
+func (s *DBStore) GetRechargesByUserID(ctx context.Context, userID int) ([]models.RechargeTransaction, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, organization_id, amount, status, transaction_id, payment_method, provider_ref, created_at, updated_at
+		FROM recharge_transactions
+		WHERE user_id = ?
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query recharge history of user %d: %w", userID, err)
+	}
+	return scanRechargeRows(rows)
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- API Key ---
+
+const apiKeyColumns = "id, user_id, name, prefix, secret_hash, scopes, expires_at, last_used_at, created_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.SecretHash, &scopes,
+		&expiresAt, &lastUsedAt, &k.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = splitScopes(scopes)
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+// splitScopes 把逗号分隔的 scopes 列转换为切片 (空字符串为空切片)
+func splitScopes(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func (s *DBStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO api_keys (user_id, name, prefix, secret_hash, scopes, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, key.UserID, key.Name, key.Prefix, key.SecretHash, strings.Join(key.Scopes, ","), key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		if s.dialect.isDuplicateEntry(err) {
+			return ErrDuplicateAPIKeyPrefix
+		}
+		return fmt.Errorf("store: failed to create api key for user %d: %w", key.UserID, err)
+	}
+	key.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get api key id: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) GetAPIKeysByUserID(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query api keys of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: failed to scan api key: %w", err)
+		}
+		keys = append(keys, *k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: failed to iterate api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *DBStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = ?", prefix))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get api key %s: %w", prefix, err)
+	}
+	return k, nil
+}
+
+func (s *DBStore) RevokeAPIKey(ctx context.Context, keyID int64, userID int, now time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		now, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("store: failed to revoke api key %d: %w", keyID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBStore) TouchAPIKey(ctx context.Context, keyID int64, now time.Time) error {
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, keyID); err != nil {
+		return fmt.Errorf("store: failed to update last used time of api key %d: %w", keyID, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 审计日志 (audit_log 表) ---
+// 只提供追加和查询，Store 上没有修改或删除审计记录的方法
+
+const auditColumns = "id, created_at, actor_id, actor_username, actor_role, action, target_type, target_id, " +
+	"before_value, after_value, ip, request_id"
+
+func scanAuditEntry(row interface{ Scan(...interface{}) error }) (*models.AuditEntry, error) {
+	var e models.AuditEntry
+	var actorID sql.NullInt64
+	var before, after sql.NullString
+	if err := row.Scan(&e.ID, &e.CreatedAt, &actorID, &e.ActorUsername, &e.ActorRole, &e.Action,
+		&e.TargetType, &e.TargetID, &before, &after, &e.IP, &e.RequestID); err != nil {
+		return nil, err
+	}
+	e.ActorID = nullableInt(actorID)
+	if before.Valid {
+		e.Before = []byte(before.String)
+	}
+	if after.Valid {
+		e.After = []byte(after.String)
+	}
+	return &e, nil
+}
+
+// nullableJSON 把空的 JSON 值转换为 NULL
+func nullableJSON(v []byte) sql.NullString {
+	if len(v) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(v), Valid: true}
+}
+
+func (s *DBStore) AppendAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	var actorID sql.NullInt64
+	if e.ActorID != nil {
+		actorID = sql.NullInt64{Int64: int64(*e.ActorID), Valid: true}
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_log (created_at, actor_id, actor_username, actor_role, action, target_type, target_id,
+			before_value, after_value, ip, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.CreatedAt, actorID, e.ActorUsername, e.ActorRole, e.Action, e.TargetType, e.TargetID,
+		nullableJSON(e.Before), nullableJSON(e.After), e.IP, e.RequestID)
+	if err != nil {
+		return fmt.Errorf("store: failed to append audit entry %s: %w", e.Action, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get last insert ID for audit entry: %w", err)
+	}
+	e.ID = id
+	return nil
+}
+
+func (s *DBStore) ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.ActorID != nil {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, *filter.ActorID)
+	}
+	if filter.Action != nil {
+		conditions = append(conditions, "action = ?")
+		args = append(args, *filter.Action)
+	}
+	if filter.TargetType != nil {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, *filter.TargetType)
+	}
+	if filter.TargetID != nil {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, *filter.TargetID)
+	}
+	if filter.RequestID != nil {
+		conditions = append(conditions, "request_id = ?")
+		args = append(args, *filter.RequestID)
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.Since)
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.Until)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating audit log rows: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"advertisement/internal/ledger"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+)
+
+var (
+	// ErrBudgetExhausted 表示活动的总预算或今日预算已用完
+	ErrBudgetExhausted = errors.New("store: campaign budget exhausted")
+	// ErrInsufficientBalance 表示组织余额不足以支付本次事件
+	ErrInsufficientBalance = errors.New("store: insufficient balance")
+	// ErrCampaignNotServable 表示活动已不能投放 (不是 Active、不在投放日期内或创建者已停用)，不能再计费展示或点击
+	ErrCampaignNotServable = errors.New("store: campaign is not servable")
+	// ErrDuplicateClick 表示这个点击凭证已经计费过
+	ErrDuplicateClick = errors.New("store: click token already used")
+)
+
+// budgetColumns 是读取活动时附带的出价 / 预算 / 消耗列 (表别名 camp)
+const budgetColumns = `camp.pricing_model, camp.bid_amount, camp.total_budget, camp.daily_budget,
+            camp.spent_total, camp.spent_today, camp.spent_today_date`
+
+// servableCondition 是可投放活动的预算条件 (表别名 camp 和 o，o 是活动所属的组织)：
+// 余额大于 0 (免费活动除外)、总预算和今日预算都未用完，且创建活动的用户没有被停用。参数为 today()。
+const servableCondition = `
+            AND NOT EXISTS (SELECT 1 FROM users su WHERE su.id = camp.user_id AND su.suspended_at IS NOT NULL)
+            AND (camp.bid_amount = 0 OR o.balance > 0)
+            AND (camp.total_budget = 0 OR camp.spent_total < camp.total_budget)
+            AND (camp.daily_budget = 0 OR camp.spent_today_date IS NULL OR camp.spent_today_date <> ?
+                 OR camp.spent_today < camp.daily_budget)`
+
+// budgetScanDest 返回与 budgetColumns 对应的 Scan 目标，扫描后需调用 normalizeSpentToday
+func budgetScanDest(b *models.CampaignBudget, spentDate *sql.NullTime) []interface{} {
+	return []interface{}{&b.PricingModel, &b.BidAmount, &b.TotalBudget, &b.DailyBudget, &b.SpentTotal, &b.SpentToday, spentDate}
+}
+
+// sameDate 按本地日期比较 (DATE 列读出来的时区可能和 today() 不同)
+func sameDate(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// normalizeSpentToday spent_today 只在 spent_today_date 为今天时有效，否则今日消耗为 0
+func normalizeSpentToday(b *models.CampaignBudget, spentDate sql.NullTime) {
+	if !spentDate.Valid || !sameDate(spentDate.Time, today()) {
+		b.SpentToday = money.New(0)
+	}
+}
+
+// eventCost 计算一次事件的扣费 (分)。
+// CPM 每次展示的价格是 bid/1000 分，不足 1 分的部分以千分之一分为单位累计在 remainder 中，
+// 满 1 分再扣，避免小额出价永远扣不到钱。返回扣费金额和新的 remainder。
+func eventCost(pricingModel string, bid int64, remainder int64, eventType string) (int64, int64) {
+	switch {
+	case pricingModel == models.PricingCPM && eventType == "Impression":
+		milli := remainder + bid
+		return milli / 1000, milli % 1000
+	case pricingModel == models.PricingCPC && eventType == "Click":
+		return bid, remainder
+	}
+	return 0, remainder
+}
+
+// chargeBid 返回本次事件的计费价格：事件带有竞价成交价时按成交价 (第二价格，不超过出价)，否则按出价。
+// CPM 活动看展示的成交价 (每千次展示)，CPC 活动看点击的成交价 (每次点击，由展示时的成交价按预估点击率换算)
+func chargeBid(b models.CampaignBudget, event *models.AdEvent) int64 {
+	charged := b.PricingModel == models.PricingCPM && event.EventType == "Impression" ||
+		b.PricingModel == models.PricingCPC && event.EventType == "Click"
+	if charged && event.ClearingPrice != nil && event.ClearingPrice.Minor >= 0 && event.ClearingPrice.Minor < b.BidAmount.Minor {
+		return event.ClearingPrice.Minor
+	}
+	return b.BidAmount.Minor
+}
+
+// eventCounts 返回本次事件对活动展示 / 点击计数的增量
+func eventCounts(eventType string) (impressions, clicks int64) {
+	switch eventType {
+	case "Impression":
+		return 1, 0
+	case "Click":
+		return 0, 1
+	}
+	return 0, 0
+}
+
+// campaignServable 与 GetRandomActiveCampaign 的条件一致：Active、创建者未被停用、start_date <= at、end_date 不早于 at 当天。
+// 展示和点击都要满足：已暂停、取消、结束或创建者被停用的活动不再计费
+func campaignServable(status string, startDate, endDate time.Time, ownerSuspended bool, at time.Time) bool {
+	if status != models.CampaignStatusActive || ownerSuspended {
+		return false
+	}
+	return !startDate.After(at) && endDate.Format("2006-01-02") >= at.Format("2006-01-02")
+}
+
+// checkBudget 校验本次扣费是否会超出预算或余额
+func checkBudget(b models.CampaignBudget, balance int64, cost int64) error {
+	total, spentTotal := b.TotalBudget.Minor, b.SpentTotal.Minor
+	if total > 0 && (spentTotal >= total || spentTotal+cost > total) {
+		return ErrBudgetExhausted
+	}
+	daily, spentToday := b.DailyBudget.Minor, b.SpentToday.Minor
+	if daily > 0 && (spentToday >= daily || spentToday+cost > daily) {
+		return ErrBudgetExhausted
+	}
+	if cost > balance || (b.BidAmount.Minor > 0 && balance <= 0) {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// ChargeAdEvent 在一个事务中：锁定活动和所属组织 -> 确认活动可以投放 -> 计算费用 -> 校验预算和余额 ->
+// 累加活动消耗 -> 记录事件 (event.Cost 为实际扣费) -> 记账并扣减余额。
+// 预算或余额不足时返回 ErrBudgetExhausted / ErrInsufficientBalance，且不记录事件。
+// 点击凭证已经用过时返回 ErrDuplicateClick。
+func (s *DBStore) ChargeAdEvent(ctx context.Context, event *models.AdEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for ad event charge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var b models.CampaignBudget
+	var spentDate sql.NullTime
+	var remainder int64
+	var ownerID, orgID int
+	var status string
+	var startDate, endDate time.Time
+	var ownerSuspended bool
+	query := `SELECT camp.user_id, camp.organization_id, camp.status, camp.start_date, camp.end_date, camp.charge_remainder,
+            EXISTS (SELECT 1 FROM users su WHERE su.id = camp.user_id AND su.suspended_at IS NOT NULL), ` + budgetColumns + `
+        FROM ad_campaigns camp WHERE camp.id = ?` + s.dialect.forUpdate
+	dest := append([]interface{}{&ownerID, &orgID, &status, &startDate, &endDate, &remainder, &ownerSuspended},
+		budgetScanDest(&b, &spentDate)...)
+	if err := tx.QueryRowContext(ctx, query, event.CampaignID).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("store: failed to read budget of campaign %d: %w", event.CampaignID, err)
+	}
+	normalizeSpentToday(&b, spentDate)
+	if event.ClickToken != nil {
+		// 唯一索引保证并发时也只有一次成功，这里提前返回，避免重复点击占用活动的行锁更久
+		var used int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM ad_events WHERE click_token = ?", *event.ClickToken).Scan(&used)
+		if err != nil {
+			return fmt.Errorf("store: failed to check click token: %w", err)
+		}
+		if used > 0 {
+			return ErrDuplicateClick
+		}
+	}
+	// 投放索引是定期刷新的快照，以这里的检查为准；点击同样检查，不能投放的活动的旧广告被点击时不扣费
+	if !campaignServable(status, startDate, endDate, ownerSuspended, event.EventTimestamp) {
+		return ErrCampaignNotServable
+	}
+
+	var balance int64
+	err = tx.QueryRowContext(ctx, "SELECT balance FROM organizations WHERE id = ?"+s.dialect.forUpdate, orgID).Scan(&balance)
+	if err != nil {
+		return fmt.Errorf("store: failed to read balance of organization %d: %w", orgID, err)
+	}
+
+	cost, newRemainder := eventCost(b.PricingModel, chargeBid(b, event), remainder, event.EventType)
+	if err := checkBudget(b, balance, cost); err != nil {
+		return err
+	}
+
+	impressions, clicks := eventCounts(event.EventType)
+	_, err = tx.ExecContext(ctx, `
+        UPDATE ad_campaigns
+        SET spent_total = ?, spent_today = ?, spent_today_date = ?, charge_remainder = ?,
+            impressions = impressions + ?, clicks = clicks + ?
+        WHERE id = ?
+    `, b.SpentTotal.Minor+cost, b.SpentToday.Minor+cost, today(), newRemainder, impressions, clicks, event.CampaignID)
+	if err != nil {
+		return fmt.Errorf("store: failed to update spend of campaign %d: %w", event.CampaignID, err)
+	}
+
+	event.Cost = cost
+	event.UserID = ownerID // 事件归属于活动的创建者和所属组织
+	event.OrganizationID = orgID
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO ad_events (event_type, advertisement_id, campaign_id, user_id, organization_id, event_timestamp, cost, placement_id,
+                               clearing_price, click_token)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, event.EventType, event.AdvertisementID, event.CampaignID, event.UserID, event.OrganizationID, event.EventTimestamp, event.Cost,
+		event.PlacementID, event.ClearingPrice, event.ClickToken)
+	if err != nil {
+		if event.ClickToken != nil && s.dialect.isDuplicateEntry(err) {
+			return ErrDuplicateClick
+		}
+		return fmt.Errorf("store: failed to log ad event: %w", err)
+	}
+	if event.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("store: failed to get ad event id: %w", err)
+	}
+
+	// 记账: 借 组织钱包，贷 platform:ad_revenue；postEntry 同步扣减 organizations.balance
+	if cost > 0 {
+		entry, err := ledger.AdSpend(orgID, cost, event.ID)
+		if err != nil {
+			return err
+		}
+		entry.Memo = fmt.Sprintf("campaign %d %s", event.CampaignID, event.EventType)
+		if _, err := s.postEntry(ctx, tx, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit ad event charge: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+)
+
+func TestEventCost(t *testing.T) {
+	tests := []struct {
+		name          string
+		pricing       string
+		bid           int64
+		remainder     int64
+		eventType     string
+		wantCost      int64
+		wantRemainder int64
+	}{
+		{"cpm impression whole cents", models.PricingCPM, 2000, 0, "Impression", 2, 0},
+		{"cpm impression below one cent", models.PricingCPM, 300, 0, "Impression", 0, 300},
+		{"cpm remainder carries over", models.PricingCPM, 300, 900, "Impression", 1, 200},
+		{"cpm impression with remainder", models.PricingCPM, 1500, 600, "Impression", 2, 100},
+		{"cpm click is free", models.PricingCPM, 2000, 400, "Click", 0, 400},
+		{"cpc click", models.PricingCPC, 50, 0, "Click", 50, 0},
+		{"cpc click keeps remainder", models.PricingCPC, 50, 700, "Click", 50, 700},
+		{"cpc impression is free", models.PricingCPC, 50, 0, "Impression", 0, 0},
+		{"zero bid", models.PricingCPM, 0, 0, "Impression", 0, 0},
+		{"unknown event", models.PricingCPC, 50, 0, "Conversion", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, remainder := eventCost(tt.pricing, tt.bid, tt.remainder, tt.eventType)
+			if cost != tt.wantCost || remainder != tt.wantRemainder {
+				t.Errorf("eventCost() = %d, %d, want %d, %d", cost, remainder, tt.wantCost, tt.wantRemainder)
+			}
+		})
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	budget := func(total, daily, spentTotal, spentToday int64) models.CampaignBudget {
+		return models.CampaignBudget{
+			PricingModel: models.PricingCPC, BidAmount: money.New(50),
+			TotalBudget: money.New(total), DailyBudget: money.New(daily), SpentTotal: money.New(spentTotal), SpentToday: money.New(spentToday),
+		}
+	}
+	tests := []struct {
+		name    string
+		budget  models.CampaignBudget
+		balance int64
+		cost    int64
+		want    error
+	}{
+		{"unlimited", budget(0, 0, 10000, 10000), 100, 50, nil},
+		{"within budgets", budget(1000, 200, 500, 100), 100, 50, nil},
+		{"exactly reaches total", budget(1000, 0, 950, 0), 100, 50, nil},
+		{"exactly reaches daily", budget(0, 200, 0, 150), 100, 50, nil},
+		{"total spent", budget(1000, 0, 1000, 0), 100, 0, ErrBudgetExhausted},
+		{"would exceed total", budget(1000, 0, 960, 0), 100, 50, ErrBudgetExhausted},
+		{"daily spent", budget(0, 200, 200, 200), 100, 0, ErrBudgetExhausted},
+		{"would exceed daily", budget(0, 200, 0, 160), 100, 50, ErrBudgetExhausted},
+		{"budget checked before balance", budget(1000, 0, 1000, 0), 0, 50, ErrBudgetExhausted},
+		{"balance below cost", budget(0, 0, 0, 0), 49, 50, ErrInsufficientBalance},
+		{"exactly the balance", budget(0, 0, 0, 0), 50, 50, nil},
+		// 出价大于 0 的活动余额为 0 时，即使这次不扣费 (如 CPM 不足 1 分的展示) 也不能投放
+		{"no charge with no balance", budget(0, 0, 0, 0), 0, 0, ErrInsufficientBalance},
+		{"free campaign with no balance", models.CampaignBudget{PricingModel: models.PricingCPM}, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkBudget(tt.budget, tt.balance, tt.cost); !errors.Is(err, tt.want) {
+				t.Errorf("checkBudget() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCampaignServable(t *testing.T) {
+	at := time.Date(2024, 5, 10, 15, 30, 0, 0, time.Local)
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.Local) }
+	tests := []struct {
+		name       string
+		status     string
+		start, end time.Time
+		suspended  bool
+		want       bool
+	}{
+		{"active in range", models.CampaignStatusActive, day(1), day(20), false, true},
+		{"starts today", models.CampaignStatusActive, day(10), day(20), false, true},
+		{"starts later today", models.CampaignStatusActive, at.Add(time.Hour), day(20), false, false},
+		{"ends today", models.CampaignStatusActive, day(1), day(10), false, true},
+		{"ended yesterday", models.CampaignStatusActive, day(1), day(9), false, false},
+		{"not started", models.CampaignStatusActive, day(11), day(20), false, false},
+		{"paused", models.CampaignStatusPaused, day(1), day(20), false, false},
+		{"approved", models.CampaignStatusApproved, day(1), day(20), false, false},
+		{"completed", models.CampaignStatusCompleted, day(1), day(20), false, false},
+		{"cancelled", models.CampaignStatusCancelled, day(1), day(20), false, false},
+		{"owner suspended", models.CampaignStatusActive, day(1), day(20), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := campaignServable(tt.status, tt.start, tt.end, tt.suspended, at); got != tt.want {
+				t.Errorf("campaignServable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"advertisement/internal/migrate"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+	"advertisement/internal/store"
+)
+
+// newSQLiteStore 创建一个迁移到最新版本的空 SQLite Store
+func newSQLiteStore(t *testing.T) store.Store {
+	return store.NewSQLiteStore(newSQLiteDB(t))
+}
+
+// newSQLiteDB 创建一个迁移到最新版本的空 SQLite 数据库
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// stores 每个用例都在这些 Store 实现上运行，两者的行为必须一致
+var stores = []struct {
+	name string
+	open func(t *testing.T) store.Store
+}{
+	{"SQLite", newSQLiteStore},
+	{"MemStore", func(*testing.T) store.Store { return store.NewMemStore() }},
+}
+
+// activeCampaign 创建一个广告主，给它的个人组织充值 balance，并创建属于该组织的 Active 活动
+func activeCampaign(t *testing.T, s store.Store, username string, balance int64, budget models.CampaignBudget) (orgID, campaignID, adID int) {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.CreateUser(ctx, &models.User{Username: username, PasswordHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.GetUserByUsername(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orgID = *user.DefaultOrganizationID
+	if balance > 0 {
+		rechargeID, err := s.CreateRechargeTransaction(ctx, orgID, user.ID, balance, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := s.GetRechargeTransactionByID(ctx, rechargeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ProcessSuccessfulRecharge(ctx, rec, "tx_"+username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ad, err := s.CreateAdvertisement(ctx, &models.Advertisement{UserID: user.ID, OrganizationID: orgID, Title: "ad", TargetURL: "https://example.com", Status: "Approved"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	id, err := s.CreateAdCampaign(ctx, &models.AdCampaign{
+		AdvertisementID: int(ad), UserID: user.ID, OrganizationID: orgID, Status: models.CampaignStatusPending,
+		StartDate: now.AddDate(0, 0, -1), EndDate: now.AddDate(0, 0, 7), CampaignBudget: budget,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{models.CampaignStatusApproved, models.CampaignStatusActive} {
+		if err := s.UpdateAdCampaignStatus(ctx, int(id), status, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return orgID, int(id), int(ad)
+}
+
+func TestChargeAdEvent(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			testChargeAdEvent(t, s.open(t))
+		})
+	}
+}
+
+func testChargeAdEvent(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	cpc := func(total int64) models.CampaignBudget {
+		return models.CampaignBudget{PricingModel: models.PricingCPC, BidAmount: money.New(50), TotalBudget: money.New(total)}
+	}
+	// charge 记录一次点击，token 为空表示不带点击凭证
+	charge := func(campaignID, adID int, token string) (int64, error) {
+		event := models.AdEvent{EventType: "Click", CampaignID: campaignID, AdvertisementID: adID, EventTimestamp: time.Now()}
+		if token != "" {
+			event.ClickToken = &token
+		}
+		err := s.ChargeAdEvent(ctx, &event)
+		return event.Cost, err
+	}
+	expectBalance := func(orgID int, want int64) {
+		t.Helper()
+		if got, err := s.GetOrganizationBalance(ctx, orgID); err != nil || got != want {
+			t.Errorf("balance = %d, %v, want %d", got, err, want)
+		}
+	}
+	expectClicks := func(orgID, campaignID int, want int64) {
+		t.Helper()
+		rows, err := s.GetAdPerformanceSummary(ctx, orgID, models.AdPerformanceFilter{CampaignID: &campaignID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var clicks int64
+		for _, row := range rows {
+			clicks += row.Clicks
+		}
+		if clicks != want {
+			t.Errorf("campaign %d has %d click events, want %d", campaignID, clicks, want)
+		}
+	}
+
+	t.Run("total budget", func(t *testing.T) {
+		orgID, campaignID, adID := activeCampaign(t, s, "budget", 1000, cpc(120))
+		for i := 0; i < 2; i++ {
+			if cost, err := charge(campaignID, adID, ""); err != nil || cost != 50 {
+				t.Fatalf("click %d: cost = %d, err = %v", i+1, cost, err)
+			}
+		}
+		// 第三次点击会让消耗超过总预算 (150 > 120)，不扣费也不记录
+		if _, err := charge(campaignID, adID, ""); !errors.Is(err, store.ErrBudgetExhausted) {
+			t.Fatalf("err = %v, want ErrBudgetExhausted", err)
+		}
+		expectBalance(orgID, 900)
+		expectClicks(orgID, campaignID, 2)
+		camp, err := s.GetAdCampaignByID(ctx, campaignID)
+		if err != nil || camp.SpentTotal.Minor != 100 || camp.SpentToday.Minor != 100 {
+			t.Errorf("spent = %+v, %v, want 100 total and today", camp, err)
+		}
+	})
+
+	t.Run("balance", func(t *testing.T) {
+		orgID, campaignID, adID := activeCampaign(t, s, "balance", 60, cpc(0))
+		if _, err := charge(campaignID, adID, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := charge(campaignID, adID, ""); !errors.Is(err, store.ErrInsufficientBalance) {
+			t.Fatalf("err = %v, want ErrInsufficientBalance", err)
+		}
+		expectBalance(orgID, 10)
+		expectClicks(orgID, campaignID, 1)
+	})
+
+	t.Run("duplicate click token", func(t *testing.T) {
+		orgID, campaignID, adID := activeCampaign(t, s, "dup", 1000, cpc(0))
+		const token = "0123456789abcdef0123456789abcdef"
+		if _, err := charge(campaignID, adID, token); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := charge(campaignID, adID, token); !errors.Is(err, store.ErrDuplicateClick) {
+			t.Fatalf("err = %v, want ErrDuplicateClick", err)
+		}
+		if _, err := charge(campaignID, adID, "fedcba9876543210fedcba9876543210"); err != nil {
+			t.Fatal(err)
+		}
+		expectBalance(orgID, 900)
+		expectClicks(orgID, campaignID, 2)
+	})
+
+	t.Run("not servable", func(t *testing.T) {
+		orgID, campaignID, adID := activeCampaign(t, s, "paused", 1000, cpc(0))
+		if err := s.UpdateAdCampaignStatus(ctx, campaignID, models.CampaignStatusPaused, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := charge(campaignID, adID, ""); !errors.Is(err, store.ErrCampaignNotServable) {
+			t.Fatalf("err = %v, want ErrCampaignNotServable", err)
+		}
+		expectBalance(orgID, 1000)
+		expectClicks(orgID, campaignID, 0)
+	})
+
+	t.Run("owner suspended", func(t *testing.T) {
+		orgID, campaignID, adID := activeCampaign(t, s, "suspended", 1000, cpc(0))
+		user, err := s.GetUserByUsername(ctx, "suspended")
+		if err != nil {
+			t.Fatal(err)
+		}
+		now, reason := time.Now(), "test"
+		if err := s.SetUserSuspension(ctx, user.ID, &now, &reason); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := charge(campaignID, adID, ""); !errors.Is(err, store.ErrCampaignNotServable) {
+			t.Fatalf("err = %v, want ErrCampaignNotServable", err)
+		}
+		expectBalance(orgID, 1000)
+		// 恢复后可以继续计费
+		if err := s.SetUserSuspension(ctx, user.ID, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := charge(campaignID, adID, ""); err != nil {
+			t.Fatal(err)
+		}
+		expectBalance(orgID, 950)
+	})
+
+	t.Run("missing campaign", func(t *testing.T) {
+		if _, err := charge(999999, 1, ""); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("err = %v, want ErrNotFound", err)
+		}
+	})
+}
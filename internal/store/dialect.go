@@ -0,0 +1,54 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// --- dialect 封装 DBStore 中与具体数据库相关的 SQL 差异 ---
+// DBStore 的大部分 SQL 是 MySQL 和 SQLite 通用的 (? 占位符、LIMIT、COALESCE、CASE WHEN 等)，
+// 只有少数地方需要区分，统一放在这里，避免在每个方法里写 if/else。
+type dialect struct {
+	name string
+
+	// randomOrder 用于 ORDER BY 的随机函数: MySQL 是 RAND()，SQLite 是 RANDOM()
+	randomOrder string
+
+	// forUpdate 追加在 SELECT 之后用于行锁: MySQL 是 " FOR UPDATE"；
+	// SQLite 不支持 (写事务本身就是串行的)，为空
+	forUpdate string
+
+	// isDuplicateEntry 判断错误是否为唯一约束冲突
+	isDuplicateEntry func(err error) bool
+}
+
+var mysqlDialect = dialect{
+	name:        "mysql",
+	randomOrder: "RAND()",
+	forUpdate:   " FOR UPDATE",
+	isDuplicateEntry: func(err error) bool {
+		// 针对 MySQL 的示例: Error 1062: Duplicate entry 'xxx' for key 'uk_users_username'
+		return strings.Contains(err.Error(), "Duplicate entry")
+	},
+}
+
+var sqliteDialect = dialect{
+	name:        "sqlite",
+	randomOrder: "RANDOM()",
+	isDuplicateEntry: func(err error) bool {
+		// SQLite: UNIQUE constraint failed: users.username
+		return strings.Contains(err.Error(), "UNIQUE constraint failed")
+	},
+}
+
+// today 返回本地时间当天零点，用来代替 MySQL 的 CURDATE()，
+// 这样日期比较在不同数据库上行为一致 (参数由 Go 传入，而不是依赖数据库函数)
+func today() time.Time {
+	return dateOf(time.Now())
+}
+
+// dateOf 返回 t 所在日期的本地零点
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
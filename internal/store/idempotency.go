@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// ReserveIdempotencyKey 为 (rec.UserID, rec.Key) 占位，占位成功返回 nil。
+// 如果该键已被使用 (且创建时间不早于 expiredBefore)，返回已有的记录；过期的记录会被删除后重新占位。
+func (s *DBStore) ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time) (*models.IdempotencyRecord, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := s.db.ExecContext(ctx, `
+            INSERT INTO idempotency_keys (user_id, idem_key, request_hash, created_at)
+            VALUES (?, ?, ?, ?)
+        `, rec.UserID, rec.Key, rec.RequestHash, rec.CreatedAt)
+		if err == nil {
+			return nil, nil
+		}
+		if !s.dialect.isDuplicateEntry(err) {
+			return nil, fmt.Errorf("store: failed to reserve idempotency key for user %d: %w", rec.UserID, err)
+		}
+
+		existing, err := s.getIdempotencyRecord(ctx, rec.UserID, rec.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue // 刚被删除 (过期清理或处理失败释放)，重新占位
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !existing.CreatedAt.Before(expiredBefore) {
+			return existing, nil
+		}
+		// 已过期：删除后重试一次
+		if _, err := s.db.ExecContext(ctx,
+			"DELETE FROM idempotency_keys WHERE user_id = ? AND idem_key = ? AND created_at < ?",
+			rec.UserID, rec.Key, expiredBefore); err != nil {
+			return nil, fmt.Errorf("store: failed to delete expired idempotency key for user %d: %w", rec.UserID, err)
+		}
+	}
+	return nil, fmt.Errorf("store: failed to reserve idempotency key for user %d: too much contention", rec.UserID)
+}
+
+func (s *DBStore) getIdempotencyRecord(ctx context.Context, userID int, key string) (*models.IdempotencyRecord, error) {
+	rec := models.IdempotencyRecord{UserID: userID, Key: key}
+	var statusCode sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+        SELECT request_hash, status_code, response_body, created_at
+        FROM idempotency_keys
+        WHERE user_id = ? AND idem_key = ?
+    `, userID, key).Scan(&rec.RequestHash, &statusCode, &rec.ResponseBody, &rec.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get idempotency key for user %d: %w", userID, err)
+	}
+	rec.StatusCode = int(statusCode.Int64)
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey 保存原请求的响应，之后使用同一个键的请求会重放该响应
+func (s *DBStore) CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, body []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE idempotency_keys
+        SET status_code = ?, response_body = ?, completed_at = ?
+        WHERE user_id = ? AND idem_key = ?
+    `, statusCode, body, time.Now(), userID, key)
+	if err != nil {
+		return fmt.Errorf("store: failed to complete idempotency key for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey 删除占位 (原请求失败，允许客户端用同一个键重试)
+func (s *DBStore) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = ? AND idem_key = ?", userID, key)
+	if err != nil {
+		return fmt.Errorf("store: failed to release idempotency key for user %d: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"advertisement/internal/ledger"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+)
+
+// ErrAlreadyPosted 表示同一业务引用 (reference) 的分录已经过账
+var ErrAlreadyPosted = errors.New("store: ledger entry already posted")
+
+// nullableString 把空字符串转成 NULL
+func nullableString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// ensureAccount 返回账户 ID，账户不存在时创建 (组织钱包账户在第一次过账时创建)
+func (s *DBStore) ensureAccount(ctx context.Context, tx *sql.Tx, code string, orgID int) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, "SELECT id FROM ledger_accounts WHERE code = ?", code).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("store: failed to look up ledger account %s: %w", code, err)
+	}
+
+	accountType, err := ledger.AccountType(code)
+	if err != nil {
+		return 0, err
+	}
+	var owner sql.NullInt64
+	if accountType == ledger.TypeLiability {
+		owner = nullableUserID(orgID)
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO ledger_accounts (code, type, organization_id, created_at) VALUES (?, ?, ?, ?)",
+		code, accountType, owner, time.Now())
+	if err != nil {
+		if s.dialect.isDuplicateEntry(err) {
+			// 并发创建：读取最新提交的数据 (MySQL 需要加锁读才能看到其他事务刚提交的行)
+			err = tx.QueryRowContext(ctx, "SELECT id FROM ledger_accounts WHERE code = ?"+s.dialect.forUpdate, code).Scan(&id)
+			if err == nil {
+				return id, nil
+			}
+		}
+		return 0, fmt.Errorf("store: failed to create ledger account %s: %w", code, err)
+	}
+	return result.LastInsertId()
+}
+
+// postEntry 在事务 tx 中写入一条分录及其过账，并同步更新 organizations.balance (账本的缓存值)。
+// 如果分录会让钱包余额变为负数，返回 ErrInsufficientBalance。
+func (s *DBStore) postEntry(ctx context.Context, tx *sql.Tx, e ledger.Entry) (int64, error) {
+	if err := e.Validate(); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO journal_entries (kind, organization_id, user_id, reference, memo, created_by, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, e.Kind, nullableUserID(e.OrganizationID), nullableUserID(e.UserID), nullableString(e.Reference), e.Memo, nullableUserID(e.CreatedBy), now)
+	if err != nil {
+		if s.dialect.isDuplicateEntry(err) {
+			return 0, fmt.Errorf("%w: %s", ErrAlreadyPosted, e.Reference)
+		}
+		return 0, fmt.Errorf("store: failed to insert journal entry: %w", err)
+	}
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("store: failed to get journal entry id: %w", err)
+	}
+
+	for _, p := range e.Postings {
+		accountID, err := s.ensureAccount(ctx, tx, p.Account, e.OrganizationID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO ledger_postings (entry_id, account_id, amount) VALUES (?, ?, ?)",
+			entryID, accountID, p.Amount)
+		if err != nil {
+			return 0, fmt.Errorf("store: failed to insert ledger posting: %w", err)
+		}
+	}
+
+	if delta := e.WalletDelta(); delta != 0 {
+		result, err := tx.ExecContext(ctx,
+			"UPDATE organizations SET balance = balance + ? WHERE id = ? AND balance + ? >= 0",
+			delta, e.OrganizationID, delta)
+		if err != nil {
+			return 0, fmt.Errorf("store: failed to update balance of organization %d: %w", e.OrganizationID, err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return 0, ErrInsufficientBalance
+		}
+	}
+	return entryID, nil
+}
+
+// PostLedgerEntry 单独过账一条分录 (管理员调账、赠送、退款)，返回分录 ID
+func (s *DBStore) PostLedgerEntry(ctx context.Context, e ledger.Entry) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("store: failed to begin transaction for ledger entry: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, "SELECT 1 FROM organizations WHERE id = ?", e.OrganizationID).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("store: failed to look up organization %d: %w", e.OrganizationID, err)
+	}
+
+	entryID, err := s.postEntry(ctx, tx, e)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store: failed to commit ledger entry: %w", err)
+	}
+	log.Printf("store: 账本分录 %d 已过账 (%s, 组织 %d, 余额变动 %d 分)", entryID, e.Kind, e.OrganizationID, e.WalletDelta())
+	return entryID, nil
+}
+
+// GetLedgerEntriesByOrganization 按 ID 倒序分页返回组织的分录 (包含全部过账行)
+func (s *DBStore) GetLedgerEntriesByOrganization(ctx context.Context, orgID int, filter models.LedgerFilter) ([]models.LedgerEntry, error) {
+	conditions := []string{"organization_id = ?"}
+	args := []interface{}{orgID}
+	if filter.Kind != nil {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, *filter.Kind)
+	}
+	query := `
+        SELECT id, kind, organization_id, user_id, reference, memo, created_by, created_at
+        FROM journal_entries
+        WHERE ` + strings.Join(conditions, " AND ") + `
+        ORDER BY id DESC
+        LIMIT ? OFFSET ?
+    `
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query ledger entries for organization %d: %w", orgID, err)
+	}
+	defer rows.Close()
+
+	entries := []models.LedgerEntry{}
+	index := make(map[int64]int)
+	for rows.Next() {
+		var entry models.LedgerEntry
+		var owner, member, createdBy sql.NullInt64
+		var reference sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Kind, &owner, &member, &reference, &entry.Memo, &createdBy, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("store: failed to scan ledger entry: %w", err)
+		}
+		entry.OrganizationID = nullableInt(owner)
+		entry.UserID = nullableInt(member)
+		if reference.Valid {
+			entry.Reference = &reference.String
+		}
+		entry.CreatedBy = nullableInt(createdBy)
+		entry.Amount = money.New(0)
+		entry.Postings = []models.LedgerPosting{}
+		index[entry.ID] = len(entries)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating ledger entries: %w", err)
+	}
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	placeholders := make([]string, len(entries))
+	ids := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		placeholders[i] = "?"
+		ids[i] = entry.ID
+	}
+	postingRows, err := s.db.QueryContext(ctx, `
+        SELECT p.entry_id, a.code, p.amount
+        FROM ledger_postings p
+        JOIN ledger_accounts a ON p.account_id = a.id
+        WHERE p.entry_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY p.id
+    `, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query ledger postings: %w", err)
+	}
+	defer postingRows.Close()
+
+	wallet := ledger.WalletAccount(orgID)
+	for postingRows.Next() {
+		var entryID int64
+		var posting models.LedgerPosting
+		if err := postingRows.Scan(&entryID, &posting.Account, &posting.Amount); err != nil {
+			return nil, fmt.Errorf("store: failed to scan ledger posting: %w", err)
+		}
+		entry := &entries[index[entryID]]
+		entry.Postings = append(entry.Postings, posting)
+		if posting.Account == wallet {
+			entry.Amount.Minor -= posting.Amount.Minor
+		}
+	}
+	if err := postingRows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating ledger postings: %w", err)
+	}
+	return entries, nil
+}
+
+// ReconcileBalances 对比每个组织的 organizations.balance 和钱包账户在账本中的余额，返回不一致的组织
+func (s *DBStore) ReconcileBalances(ctx context.Context) ([]models.BalanceDiscrepancy, error) {
+	query := `
+        SELECT o.id, o.balance, COALESCE(-SUM(p.amount), 0) AS ledger_balance
+        FROM organizations o
+        LEFT JOIN ledger_accounts a ON a.organization_id = o.id AND a.type = 'liability'
+        LEFT JOIN ledger_postings p ON p.account_id = a.id
+        GROUP BY o.id, o.balance
+        HAVING o.balance <> COALESCE(-SUM(p.amount), 0)
+        ORDER BY o.id
+    `
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to reconcile balances: %w", err)
+	}
+	defer rows.Close()
+
+	discrepancies := []models.BalanceDiscrepancy{}
+	for rows.Next() {
+		var d models.BalanceDiscrepancy
+		if err := rows.Scan(&d.OrganizationID, &d.CachedBalance, &d.LedgerBalance); err != nil {
+			return nil, fmt.Errorf("store: failed to scan reconciliation row: %w", err)
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating reconciliation rows: %w", err)
+	}
+	return discrepancies, nil
+}
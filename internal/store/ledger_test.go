@@ -0,0 +1,146 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"advertisement/internal/ledger"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+	"advertisement/internal/store"
+)
+
+func TestLedgerSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteDB(t)
+	s := store.NewSQLiteStore(db)
+
+	// 充值 1000 (activeCampaign)、两次点击各 50、赠送 200
+	orgID, campaignID, adID := activeCampaign(t, s, "alice", 1000, models.CampaignBudget{PricingModel: models.PricingCPC, BidAmount: money.New(50)})
+	for i := 0; i < 2; i++ {
+		event := models.AdEvent{EventType: "Click", CampaignID: campaignID, AdvertisementID: adID, EventTimestamp: time.Now()}
+		if err := s.ChargeAdEvent(ctx, &event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	promo, err := ledger.PromoCredit(orgID, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PostLedgerEntry(ctx, promo); err != nil {
+		t.Fatal(err)
+	}
+
+	expectBalance := func(want int64) {
+		t.Helper()
+		if got, err := s.GetOrganizationBalance(ctx, orgID); err != nil || got != want {
+			t.Fatalf("balance = %d, %v, want %d", got, err, want)
+		}
+	}
+	countEntries := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM journal_entries").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	expectBalance(1100)
+	if n := countEntries(); n != 4 {
+		t.Fatalf("%d journal entries, want 4", n)
+	}
+
+	t.Run("postings balance", func(t *testing.T) {
+		var unbalanced int
+		err := db.QueryRowContext(ctx, `
+            SELECT COUNT(*) FROM (
+                SELECT entry_id FROM ledger_postings GROUP BY entry_id HAVING SUM(amount) <> 0
+            ) t`).Scan(&unbalanced)
+		if err != nil || unbalanced != 0 {
+			t.Fatalf("%d unbalanced entries, %v", unbalanced, err)
+		}
+		entries, err := s.GetLedgerEntriesByOrganization(ctx, orgID, models.LedgerFilter{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum int64
+		for _, e := range entries {
+			sum += e.Amount.Minor
+		}
+		if len(entries) != 4 || sum != 1100 {
+			t.Fatalf("%d entries with wallet sum %d, want 4 and 1100: %+v", len(entries), sum, entries)
+		}
+	})
+
+	t.Run("rejected entries", func(t *testing.T) {
+		overdraft, err := ledger.Refund(orgID, 1101)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unbalanced := ledger.Entry{Kind: ledger.KindAdjustment, OrganizationID: orgID, Postings: []ledger.Posting{
+			{Account: ledger.AccountAdjustments, Amount: 100},
+			{Account: ledger.WalletAccount(orgID), Amount: -90},
+		}}
+		duplicate, err := ledger.Recharge(orgID, 0, 100, 1) // activeCampaign 的充值记录 ID 为 1
+		if err != nil {
+			t.Fatal(err)
+		}
+		missingOrg, err := ledger.PromoCredit(999999, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			name  string
+			entry ledger.Entry
+			want  error
+		}{
+			// balance + ? >= 0 条件不满足，整个分录回滚
+			{"overdraft", overdraft, store.ErrInsufficientBalance},
+			{"unbalanced", unbalanced, ledger.ErrUnbalanced},
+			{"duplicate reference", duplicate, store.ErrAlreadyPosted},
+			{"missing organization", missingOrg, store.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := s.PostLedgerEntry(ctx, tt.entry); !errors.Is(err, tt.want) {
+					t.Fatalf("PostLedgerEntry() = %v, want %v", err, tt.want)
+				}
+			})
+		}
+		expectBalance(1100)
+		if n := countEntries(); n != 4 {
+			t.Fatalf("%d journal entries after rejected posts, want 4", n)
+		}
+
+		// 恰好用完余额是允许的
+		refund, err := ledger.Refund(orgID, 1100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.PostLedgerEntry(ctx, refund); err != nil {
+			t.Fatal(err)
+		}
+		expectBalance(0)
+	})
+
+	t.Run("reconcile", func(t *testing.T) {
+		discrepancies, err := s.ReconcileBalances(ctx)
+		if err != nil || len(discrepancies) != 0 {
+			t.Fatalf("ReconcileBalances() = %+v, %v, want none", discrepancies, err)
+		}
+		// 绕过账本直接修改缓存的余额
+		if _, err := db.ExecContext(ctx, "UPDATE organizations SET balance = balance + 7 WHERE id = ?", orgID); err != nil {
+			t.Fatal(err)
+		}
+		discrepancies, err = s.ReconcileBalances(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := models.BalanceDiscrepancy{OrganizationID: orgID, CachedBalance: money.New(7), LedgerBalance: money.New(0)}
+		if len(discrepancies) != 1 || discrepancies[0] != want {
+			t.Fatalf("ReconcileBalances() = %+v, want [%+v]", discrepancies, want)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// ErrInvalidTransition 表示广告活动的状态转换不被允许 (例如 Completed -> Active)
+var ErrInvalidTransition = errors.New("store: invalid campaign status transition")
+
+// --- 广告活动生命周期 ---
+// Pending -> Approved -> Active -> Completed，另外可以 Paused / Cancelled / Rejected。
+// Rejected、Cancelled、Completed 是终态，不能再转换。
+var campaignTransitions = map[string][]string{
+	models.CampaignStatusPending:  {models.CampaignStatusApproved, models.CampaignStatusRejected, models.CampaignStatusCancelled},
+	models.CampaignStatusApproved: {models.CampaignStatusActive, models.CampaignStatusPaused, models.CampaignStatusCancelled, models.CampaignStatusCompleted},
+	models.CampaignStatusActive:   {models.CampaignStatusPaused, models.CampaignStatusCompleted, models.CampaignStatusCancelled},
+	// 暂停后恢复：在投放期内回到 Active，还没到开始日期则回到 Approved
+	models.CampaignStatusPaused: {models.CampaignStatusActive, models.CampaignStatusApproved, models.CampaignStatusCancelled, models.CampaignStatusCompleted},
+}
+
+// CanTransitionCampaign 判断状态转换 from -> to 是否合法 (不区分操作人)
+func CanTransitionCampaign(from, to string) bool {
+	for _, next := range campaignTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// canUserTransitionCampaign 判断组织成员可以做的状态转换：
+// 取消、暂停，以及把暂停的活动恢复 (Paused -> Active / Approved)。
+// 审核 (Approved / Rejected) 和到期完成只能由管理员或调度器触发。
+func canUserTransitionCampaign(from, to string) bool {
+	if !CanTransitionCampaign(from, to) {
+		return false
+	}
+	switch to {
+	case models.CampaignStatusCancelled, models.CampaignStatusPaused:
+		return true
+	case models.CampaignStatusActive, models.CampaignStatusApproved:
+		return from == models.CampaignStatusPaused
+	}
+	return false
+}
+
+// invalidTransition 包装 ErrInvalidTransition，附带具体的 from / to 状态
+func invalidTransition(campaignID int, from, to string) error {
+	return fmt.Errorf("%w: campaign %d %s -> %s", ErrInvalidTransition, campaignID, from, to)
+}
+
+// campaignTransition 描述一次状态转换请求
+type campaignTransition struct {
+	campaignID int
+	orgID      int    // > 0 时要求活动属于该组织，否则视为 ErrNotFound
+	to         string // 目标状态
+	changedBy  int    // 操作人用户 ID，0 表示系统 (调度器)
+	reason     string
+	allowed    func(from, to string) bool
+}
+
+// nullableUserID 把 0 (系统操作) 转成 NULL
+func nullableUserID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
+
+// transitionCampaign 在一个事务里完成：锁定并读取当前状态 -> 校验转换 -> 更新状态 -> 写历史记录
+func (s *DBStore) transitionCampaign(ctx context.Context, t campaignTransition) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for campaign %d status change: %w", t.campaignID, err)
+	}
+	defer tx.Rollback()
+
+	var from string
+	var orgID int
+	err = tx.QueryRowContext(ctx,
+		"SELECT status, organization_id FROM ad_campaigns WHERE id = ?"+s.dialect.forUpdate, t.campaignID,
+	).Scan(&from, &orgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("store: failed to read status of campaign %d: %w", t.campaignID, err)
+	}
+	if t.orgID > 0 && orgID != t.orgID {
+		return ErrNotFound // 不暴露活动是否存在
+	}
+	if !t.allowed(from, t.to) {
+		return invalidTransition(t.campaignID, from, t.to)
+	}
+
+	now := time.Now()
+	// WHERE 中再带上旧状态，防止并发修改 (SQLite 没有 FOR UPDATE)
+	result, err := tx.ExecContext(ctx,
+		"UPDATE ad_campaigns SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		t.to, now, t.campaignID, from)
+	if err != nil {
+		return fmt.Errorf("store: failed to update status for ad campaign %d: %w", t.campaignID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return invalidTransition(t.campaignID, from, t.to)
+	}
+
+	if err := insertCampaignHistory(ctx, tx, t.campaignID, &from, t.to, t.changedBy, t.reason, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit status change for campaign %d: %w", t.campaignID, err)
+	}
+	log.Printf("store: 广告活动 %d 状态 %s -> %s (操作人: %d, 原因: %s)", t.campaignID, from, t.to, t.changedBy, t.reason)
+	return nil
+}
+
+// execer 是 *sql.DB 和 *sql.Tx 的公共部分
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertCampaignHistory(ctx context.Context, db execer, campaignID int, from *string, to string, changedBy int, reason string, at time.Time) error {
+	var fromStatus sql.NullString
+	if from != nil {
+		fromStatus = sql.NullString{String: *from, Valid: true}
+	}
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO campaign_status_history (campaign_id, from_status, to_status, changed_by, reason, changed_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, campaignID, fromStatus, to, nullableUserID(changedBy), reason, at)
+	if err != nil {
+		return fmt.Errorf("store: failed to record status history for campaign %d: %w", campaignID, err)
+	}
+	return nil
+}
+
+// UpdateAdCampaignStatus 管理员 (或系统) 修改活动状态，转换规则见 campaignTransitions
+func (s *DBStore) UpdateAdCampaignStatus(ctx context.Context, campaignID int, status string, changedBy int) error {
+	return s.transitionCampaign(ctx, campaignTransition{
+		campaignID: campaignID,
+		to:         status,
+		changedBy:  changedBy,
+		reason:     "admin",
+		allowed:    CanTransitionCampaign,
+	})
+}
+
+// UpdateAdCampaignStatusByOrganization 组织成员修改组织活动的状态 (取消 / 暂停 / 恢复)
+// 活动不存在或不属于该组织返回 ErrNotFound，状态不允许返回 ErrInvalidTransition
+func (s *DBStore) UpdateAdCampaignStatusByOrganization(ctx context.Context, campaignID int, orgID int, changedBy int, newStatus string) error {
+	return s.transitionCampaign(ctx, campaignTransition{
+		campaignID: campaignID,
+		orgID:      orgID,
+		to:         newStatus,
+		changedBy:  changedBy,
+		reason:     "user",
+		allowed:    canUserTransitionCampaign,
+	})
+}
+
+// ActivateDueCampaigns 把已到开始日期、且尚未过结束日期的 Approved 活动转为 Active，返回被激活的活动 ID
+func (s *DBStore) ActivateDueCampaigns(ctx context.Context, now time.Time) ([]int, error) {
+	date := truncateToDate(now)
+	ids, err := s.campaignIDs(ctx,
+		"SELECT id FROM ad_campaigns WHERE status = ? AND start_date <= ? AND end_date >= ? ORDER BY id",
+		models.CampaignStatusApproved, date, date)
+	if err != nil {
+		return nil, err
+	}
+	return s.transitionAll(ctx, ids, models.CampaignStatusActive, "scheduler: start_date reached")
+}
+
+// CompleteExpiredCampaigns 把已过结束日期的 Approved / Active / Paused 活动转为 Completed，返回被完成的活动 ID
+func (s *DBStore) CompleteExpiredCampaigns(ctx context.Context, now time.Time) ([]int, error) {
+	ids, err := s.campaignIDs(ctx,
+		"SELECT id FROM ad_campaigns WHERE status IN (?, ?, ?) AND end_date < ? ORDER BY id",
+		models.CampaignStatusApproved, models.CampaignStatusActive, models.CampaignStatusPaused, truncateToDate(now))
+	if err != nil {
+		return nil, err
+	}
+	return s.transitionAll(ctx, ids, models.CampaignStatusCompleted, "scheduler: end_date passed")
+}
+
+func (s *DBStore) campaignIDs(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query campaigns for scheduler: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("store: failed to scan campaign id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating campaign ids: %w", err)
+	}
+	return ids, nil
+}
+
+// transitionAll 逐个转换活动状态。
+// 查询和转换之间活动可能已被用户修改 (例如取消)，这种 ErrInvalidTransition 直接跳过。
+func (s *DBStore) transitionAll(ctx context.Context, ids []int, to, reason string) ([]int, error) {
+	var changed []int
+	for _, id := range ids {
+		err := s.transitionCampaign(ctx, campaignTransition{
+			campaignID: id,
+			to:         to,
+			reason:     reason,
+			allowed:    CanTransitionCampaign,
+		})
+		if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed = append(changed, id)
+	}
+	return changed, nil
+}
+
+// GetCampaignStatusHistory 按时间顺序返回活动的全部状态变更记录
+func (s *DBStore) GetCampaignStatusHistory(ctx context.Context, campaignID int) ([]models.CampaignStatusChange, error) {
+	query := `
+        SELECT id, campaign_id, from_status, to_status, changed_by, reason, changed_at
+        FROM campaign_status_history
+        WHERE campaign_id = ?
+        ORDER BY changed_at, id
+    `
+	rows, err := s.db.QueryContext(ctx, query, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query status history for campaign %d: %w", campaignID, err)
+	}
+	defer rows.Close()
+
+	history := []models.CampaignStatusChange{}
+	for rows.Next() {
+		var change models.CampaignStatusChange
+		var from sql.NullString
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&change.ID, &change.CampaignID, &from, &change.ToStatus, &changedBy, &change.Reason, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("store: failed to scan status history row: %w", err)
+		}
+		if from.Valid {
+			change.FromStatus = &from.String
+		}
+		if changedBy.Valid {
+			id := int(changedBy.Int64)
+			change.ChangedBy = &id
+		}
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating status history rows: %w", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,58 @@
+package store_test
+
+import (
+	"testing"
+
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+)
+
+func TestCanTransitionCampaign(t *testing.T) {
+	const (
+		pending   = models.CampaignStatusPending
+		approved  = models.CampaignStatusApproved
+		active    = models.CampaignStatusActive
+		paused    = models.CampaignStatusPaused
+		completed = models.CampaignStatusCompleted
+		cancelled = models.CampaignStatusCancelled
+		rejected  = models.CampaignStatusRejected
+	)
+	statuses := []string{pending, approved, active, paused, completed, cancelled, rejected}
+
+	// 允许的转换，其余组合 (包括状态不变和未知状态) 都不允许
+	allowed := map[string][]string{
+		pending:  {approved, rejected, cancelled},
+		approved: {active, paused, cancelled, completed},
+		active:   {paused, completed, cancelled},
+		paused:   {active, approved, cancelled, completed},
+		// 终态
+		completed: nil,
+		cancelled: nil,
+		rejected:  nil,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := false
+			for _, next := range allowed[from] {
+				if next == to {
+					want = true
+				}
+			}
+			if got := store.CanTransitionCampaign(from, to); got != want {
+				t.Errorf("CanTransitionCampaign(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	for _, tt := range []struct{ from, to string }{
+		{"", pending},
+		{"Unknown", active},
+		{pending, "Unknown"},
+		{"active", "paused"}, // 区分大小写
+	} {
+		if store.CanTransitionCampaign(tt.from, tt.to) {
+			t.Errorf("CanTransitionCampaign(%q, %q) = true, want false", tt.from, tt.to)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 登录失败计数 (login_attempts 表) ---
+
+const loginAttemptColumns = "throttle_key, failures, last_failure_at, locked_until, expires_at"
+
+func scanLoginAttempt(row interface{ Scan(...interface{}) error }) (*models.LoginAttempt, error) {
+	var a models.LoginAttempt
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&a.Key, &a.Failures, &a.LastFailureAt, &lockedUntil, &a.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		a.LockedUntil = &lockedUntil.Time
+	}
+	return &a, nil
+}
+
+func (s *DBStore) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	a, err := scanLoginAttempt(s.db.QueryRowContext(ctx,
+		"SELECT "+loginAttemptColumns+" FROM login_attempts WHERE throttle_key = ?", key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get login attempts of %s: %w", key, err)
+	}
+	return a, nil
+}
+
+func (s *DBStore) RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	// 第一次失败时两个请求可能同时插入，唯一约束冲突后重试一次即可走更新分支
+	for retry := 0; ; retry++ {
+		a, err := s.recordLoginFailure(ctx, key, now, window)
+		if err != nil && retry == 0 && s.dialect.isDuplicateEntry(err) {
+			continue
+		}
+		return a, err
+	}
+}
+
+func (s *DBStore) recordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to begin transaction for login attempts: %w", err)
+	}
+	defer tx.Rollback()
+
+	a, err := scanLoginAttempt(tx.QueryRowContext(ctx,
+		"SELECT "+loginAttemptColumns+" FROM login_attempts WHERE throttle_key = ?"+s.dialect.forUpdate, key))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		a = &models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now, ExpiresAt: now.Add(window)}
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO login_attempts (throttle_key, failures, last_failure_at, expires_at)
+            VALUES (?, ?, ?, ?)
+        `, a.Key, a.Failures, a.LastFailureAt, a.ExpiresAt); err != nil {
+			if s.dialect.isDuplicateEntry(err) {
+				return nil, err // 由 RecordLoginFailure 重试
+			}
+			return nil, fmt.Errorf("store: failed to create login attempts of %s: %w", key, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("store: failed to get login attempts of %s: %w", key, err)
+	default:
+		if !now.Before(a.ExpiresAt) {
+			// 记录已过期 (包括锁定已结束且超过窗口)，重新计数
+			a.Failures = 0
+			a.LockedUntil = nil
+		}
+		a.Failures++
+		a.LastFailureAt = now
+		if expiresAt := now.Add(window); expiresAt.After(a.ExpiresAt) {
+			a.ExpiresAt = expiresAt
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE login_attempts SET failures = ?, last_failure_at = ?, locked_until = ?, expires_at = ? WHERE throttle_key = ?",
+			a.Failures, a.LastFailureAt, a.LockedUntil, a.ExpiresAt, key); err != nil {
+			return nil, fmt.Errorf("store: failed to update login attempts of %s: %w", key, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("store: failed to commit login attempts: %w", err)
+	}
+	return a, nil
+}
+
+func (s *DBStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE login_attempts
+        SET locked_until = ?, expires_at = CASE WHEN expires_at < ? THEN ? ELSE expires_at END
+        WHERE throttle_key = ?
+    `, until, until, until, key)
+	if err != nil {
+		return fmt.Errorf("store: failed to lock %s: %w", key, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE throttle_key = ?", key); err != nil {
+		return fmt.Errorf("store: failed to reset login attempts of %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,2427 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/ledger"
+	"advertisement/internal/models"
+	"advertisement/internal/money"
+)
+
+// --- MemStore 是 Store 接口的内存实现 ---
+// 用于测试 (配合 httptest) 和本地开发，不需要 MySQL。
+// 所有方法都由一把读写锁保护，可以安全地并发调用。
+// 语义尽量与 DBStore 保持一致：同样的错误类型、同样的过滤和排序规则。
+type MemStore struct {
+	mu sync.RWMutex
+
+	users     map[int]*models.User
+	usernames map[string]int // username -> user id，模拟唯一索引
+	emails    map[string]int // email -> user id，模拟唯一索引
+
+	orgs        map[int]*models.Organization
+	members     map[memMemberKey]*models.OrganizationMember // organization_members (Username 读取时从 users 填充)
+	invitations map[int64]*models.OrganizationInvitation
+
+	ads        map[int]*models.Advertisement
+	campaigns  map[int]*models.AdCampaign
+	recharges  map[int64]*models.RechargeTransaction
+	events     []models.AdEvent
+	invoices   map[int64]*models.InvoiceRequest
+	history    []models.CampaignStatusChange // campaign_status_history
+	spend      map[int]*memSpend             // ad_campaigns.spent_today_date / charge_remainder
+	clicks     map[string]bool               // ad_events.click_token 唯一索引
+	journal    []models.LedgerEntry          // journal_entries + ledger_postings
+	postedRef  map[string]bool               // journal_entries.reference 唯一索引
+	idemKeys   map[memIdemKey]*models.IdempotencyRecord
+	refresh    map[string]*models.RefreshToken // token_hash -> refresh_tokens
+	revoked    map[string]time.Time            // revoked_tokens: jti -> expires_at
+	apiKeys    map[int64]*models.APIKey
+	tokens     map[string]*models.UserToken // token_hash -> user_tokens
+	outbox     map[int64]*models.OutboxMessage
+	recovery   map[memRecoveryKey]*time.Time     // recovery_codes: (user_id, code_hash) -> used_at
+	mfa        map[string]*models.LoginChallenge // token_hash -> login_challenges
+	attempts   map[string]*models.LoginAttempt   // throttle_key -> login_attempts
+	audit      []models.AuditEntry               // audit_log，按 id 递增追加
+	placements map[int]*models.Placement
+
+	// 模拟 AUTO_INCREMENT
+	nextUserID       int
+	nextOrgID        int
+	nextInvitationID int64
+	nextAdID         int
+	nextCampaignID   int
+	nextRechargeID   int64
+	nextEventID      int64
+	nextInvoiceID    int64
+	nextHistoryID    int64
+	nextEntryID      int64
+	nextRefreshID    int64
+	nextAPIKeyID     int64
+	nextUserTokenID  int64
+	nextOutboxID     int64
+	nextChallengeID  int64
+	nextAuditID      int64
+	nextPlacementID  int
+}
+
+// memRecoveryKey 模拟 recovery_codes 的 (user_id, code_hash) 唯一索引
+type memRecoveryKey struct {
+	userID int
+	hash   string
+}
+
+// memSpend 保存 models.CampaignBudget 中没有的计费状态
+type memSpend struct {
+	date      time.Time // spent_today 对应的日期
+	remainder int64     // CPM 未满 1 分的累计消耗，单位千分之一分
+
+	impressions, clicks int64 // 对应 ad_campaigns.impressions / clicks
+}
+
+// NewMemStore 创建一个空的 MemStore 实例
+func NewMemStore() *MemStore {
+	return &MemStore{
+		users:       make(map[int]*models.User),
+		usernames:   make(map[string]int),
+		emails:      make(map[string]int),
+		orgs:        make(map[int]*models.Organization),
+		members:     make(map[memMemberKey]*models.OrganizationMember),
+		invitations: make(map[int64]*models.OrganizationInvitation),
+		ads:         make(map[int]*models.Advertisement),
+		campaigns:   make(map[int]*models.AdCampaign),
+		recharges:   make(map[int64]*models.RechargeTransaction),
+		invoices:    make(map[int64]*models.InvoiceRequest),
+		spend:       make(map[int]*memSpend),
+		clicks:      make(map[string]bool),
+		postedRef:   make(map[string]bool),
+		idemKeys:    make(map[memIdemKey]*models.IdempotencyRecord),
+		refresh:     make(map[string]*models.RefreshToken),
+		revoked:     make(map[string]time.Time),
+		apiKeys:     make(map[int64]*models.APIKey),
+		tokens:      make(map[string]*models.UserToken),
+		outbox:      make(map[int64]*models.OutboxMessage),
+		recovery:    make(map[memRecoveryKey]*time.Time),
+		mfa:         make(map[string]*models.LoginChallenge),
+		attempts:    make(map[string]*models.LoginAttempt),
+		placements:  make(map[int]*models.Placement),
+	}
+}
+
+// truncateToDate 去掉时间部分，模拟 MySQL DATE 列的比较
+func truncateToDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// endOfDay 返回当天最后一纳秒，和 DBStore 中 AddDate(0,0,1).Add(-1ns) 的写法一致
+func endOfDay(t time.Time) time.Time {
+	return t.AddDate(0, 0, 1).Add(-1 * time.Nanosecond)
+}
+
+// snapshot 复制活动，并像 DBStore 一样把非今天的 spent_today 视为 0
+func (s *MemStore) snapshot(camp *models.AdCampaign) models.AdCampaign {
+	result := *camp
+	if sp, ok := s.spend[camp.ID]; !ok || !sameDate(sp.date, today()) {
+		result.SpentToday = money.New(0)
+	}
+	return result
+}
+
+// servable 对应 DBStore 的 servableCondition：余额和预算都未用完，创建者没有被停用
+func (s *MemStore) servable(camp *models.AdCampaign) bool {
+	if u, ok := s.users[camp.UserID]; ok && u.SuspendedAt != nil {
+		return false
+	}
+	b := s.snapshot(camp).CampaignBudget
+	if org, ok := s.orgs[camp.OrganizationID]; !ok || (b.BidAmount.Minor > 0 && org.Balance.Minor <= 0) {
+		return false
+	}
+	if b.TotalBudget.Minor > 0 && b.SpentTotal.Minor >= b.TotalBudget.Minor {
+		return false
+	}
+	return b.DailyBudget.Minor == 0 || b.SpentToday.Minor < b.DailyBudget.Minor
+}
+
+// --- 用户相关 ---
+
+func (s *MemStore) CreateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.usernames[user.Username]; exists {
+		return ErrDuplicateUser
+	}
+	if user.Email != nil {
+		if _, exists := s.emails[*user.Email]; exists {
+			return ErrDuplicateEmail
+		}
+	}
+	s.nextUserID++
+	user.ID = s.nextUserID
+	user.Role = "user" // 与数据库列默认值一致
+	user.EmailVerifiedAt = nil
+	user.CreatedAt = time.Now()
+	stored := copyUser(user)
+	s.users[stored.ID] = stored
+	s.usernames[stored.Username] = stored.ID
+	if stored.Email != nil {
+		s.emails[*stored.Email] = stored.ID
+	}
+
+	// 与 DBStore 一致：同时创建个人组织并设为默认组织
+	org := s.insertOrganizationLocked(stored.Username, stored.ID)
+	stored.DefaultOrganizationID = &org.ID
+	user.DefaultOrganizationID = &org.ID
+	return nil
+}
+
+func (s *MemStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.usernames[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyUser(s.users[id]), nil
+}
+
+func (s *MemStore) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyUser(u), nil
+}
+
+func (s *MemStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.emails[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyUser(s.users[id]), nil
+}
+
+func (s *MemStore) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+// --- 广告相关 ---
+
+func (s *MemStore) CreateAdvertisement(ctx context.Context, ad *models.Advertisement) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextAdID++
+	stored := *ad
+	stored.ID = s.nextAdID
+	s.ads[stored.ID] = &stored
+	return int64(stored.ID), nil
+}
+
+func (s *MemStore) GetAdvertisementsByOrganization(ctx context.Context, orgID int) ([]models.Advertisement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ads []models.Advertisement
+	for _, ad := range s.ads {
+		if ad.OrganizationID == orgID {
+			ads = append(ads, *ad)
+		}
+	}
+	// ORDER BY id DESC
+	sort.Slice(ads, func(i, j int) bool { return ads[i].ID > ads[j].ID })
+	return ads, nil
+}
+
+func (s *MemStore) UpdateAdvertisementStatus(ctx context.Context, adID int, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ad, ok := s.ads[adID]
+	if !ok {
+		return ErrNotFound
+	}
+	ad.Status = status
+	log.Printf("store(mem): 广告 %d 状态更新为 %s", adID, status)
+	return nil
+}
+
+func (s *MemStore) GetAdvertisementByID(ctx context.Context, adID int) (*models.Advertisement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ad, ok := s.ads[adID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *ad
+	return &result, nil
+}
+
+func (s *MemStore) GetPendingAdvertisements(ctx context.Context) ([]models.Advertisement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ads []models.Advertisement
+	for _, ad := range s.ads {
+		if ad.Status == "Pending" {
+			ads = append(ads, *ad)
+		}
+	}
+	sort.Slice(ads, func(i, j int) bool { return ads[i].ID > ads[j].ID })
+	return ads, nil
+}
+
+// --- 广告活动相关 ---
+
+func (s *MemStore) CreateAdCampaign(ctx context.Context, campaign *models.AdCampaign) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.nextCampaignID++
+	stored := *campaign
+	stored.ID = s.nextCampaignID
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.campaigns[stored.ID] = &stored
+	s.recordHistoryLocked(stored.ID, nil, stored.Status, stored.UserID, "created", now)
+	log.Printf("store(mem): 创建广告活动成功, ID: %d", stored.ID)
+	return int64(stored.ID), nil
+}
+
+func (s *MemStore) UpdateAdCampaignStatus(ctx context.Context, campaignID int, status string, changedBy int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transitionLocked(campaignTransition{
+		campaignID: campaignID,
+		to:         status,
+		changedBy:  changedBy,
+		reason:     "admin",
+		allowed:    CanTransitionCampaign,
+	})
+}
+
+// transitionLocked 与 DBStore.transitionCampaign 一致：校验归属和转换规则，更新状态并写历史
+// 调用方必须持有写锁
+func (s *MemStore) transitionLocked(t campaignTransition) error {
+	camp, ok := s.campaigns[t.campaignID]
+	if !ok || (t.orgID > 0 && camp.OrganizationID != t.orgID) {
+		return ErrNotFound
+	}
+	from := camp.Status
+	if !t.allowed(from, t.to) {
+		return invalidTransition(t.campaignID, from, t.to)
+	}
+	now := time.Now()
+	camp.Status = t.to
+	camp.UpdatedAt = now
+	s.recordHistoryLocked(t.campaignID, &from, t.to, t.changedBy, t.reason, now)
+	log.Printf("store(mem): 广告活动 %d 状态 %s -> %s (操作人: %d, 原因: %s)", t.campaignID, from, t.to, t.changedBy, t.reason)
+	return nil
+}
+
+func (s *MemStore) recordHistoryLocked(campaignID int, from *string, to string, changedBy int, reason string, at time.Time) {
+	s.nextHistoryID++
+	change := models.CampaignStatusChange{
+		ID:         s.nextHistoryID,
+		CampaignID: campaignID,
+		ToStatus:   to,
+		Reason:     reason,
+		ChangedAt:  at,
+	}
+	if from != nil {
+		f := *from
+		change.FromStatus = &f
+	}
+	if changedBy > 0 {
+		id := changedBy
+		change.ChangedBy = &id
+	}
+	s.history = append(s.history, change)
+}
+
+// transitionMatchingLocked 对所有满足 match 的活动执行状态转换 (按 ID 升序)，返回转换成功的活动 ID
+func (s *MemStore) transitionMatchingLocked(match func(camp *models.AdCampaign) bool, to, reason string) []int {
+	var ids []int
+	for id, camp := range s.campaigns {
+		if match(camp) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	var changed []int
+	for _, id := range ids {
+		err := s.transitionLocked(campaignTransition{campaignID: id, to: to, reason: reason, allowed: CanTransitionCampaign})
+		if err == nil {
+			changed = append(changed, id)
+		}
+	}
+	return changed
+}
+
+func (s *MemStore) ActivateDueCampaigns(ctx context.Context, now time.Time) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	date := truncateToDate(now)
+	return s.transitionMatchingLocked(func(camp *models.AdCampaign) bool {
+		return camp.Status == models.CampaignStatusApproved &&
+			!truncateToDate(camp.StartDate).After(date) && !truncateToDate(camp.EndDate).Before(date)
+	}, models.CampaignStatusActive, "scheduler: start_date reached"), nil
+}
+
+func (s *MemStore) CompleteExpiredCampaigns(ctx context.Context, now time.Time) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	date := truncateToDate(now)
+	return s.transitionMatchingLocked(func(camp *models.AdCampaign) bool {
+		switch camp.Status {
+		case models.CampaignStatusApproved, models.CampaignStatusActive, models.CampaignStatusPaused:
+			return truncateToDate(camp.EndDate).Before(date)
+		}
+		return false
+	}, models.CampaignStatusCompleted, "scheduler: end_date passed"), nil
+}
+
+func (s *MemStore) GetCampaignStatusHistory(ctx context.Context, campaignID int) ([]models.CampaignStatusChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := []models.CampaignStatusChange{}
+	for _, change := range s.history { // 追加顺序即时间顺序
+		if change.CampaignID == campaignID {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+func (s *MemStore) GetAdCampaignByID(ctx context.Context, campaignID int) (*models.AdCampaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camp, ok := s.campaigns[campaignID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := s.snapshot(camp)
+	return &result, nil
+}
+
+func (s *MemStore) GetPendingCampaigns(ctx context.Context) ([]models.AdCampaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var campaigns []models.AdCampaign
+	for _, camp := range s.campaigns {
+		if camp.Status == "Pending" {
+			campaigns = append(campaigns, s.snapshot(camp))
+		}
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].ID > campaigns[j].ID })
+	return campaigns, nil
+}
+
+// GetRandomActiveCampaignAd 与 DBStore 一致：按日期 (CURDATE) 比较 'Active' 状态的活动
+func (s *MemStore) GetRandomActiveCampaignAd(ctx context.Context) (*models.Advertisement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	today := truncateToDate(time.Now())
+	var candidates []*models.Advertisement
+	for _, camp := range s.campaigns {
+		if camp.Status != models.CampaignStatusActive {
+			continue
+		}
+		if today.Before(truncateToDate(camp.StartDate)) || today.After(truncateToDate(camp.EndDate)) {
+			continue
+		}
+		if !s.servable(camp) {
+			continue
+		}
+		ad, ok := s.ads[camp.AdvertisementID] // JOIN advertisements
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, ad)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNotFound
+	}
+	result := *candidates[rand.Intn(len(candidates))] // ORDER BY RAND() LIMIT 1
+	return &result, nil
+}
+
+// GetRandomActiveCampaign 与 DBStore 一致：status = 'Active' 且 start_date <= NOW()、end_date >= CURDATE()
+func (s *MemStore) GetRandomActiveCampaign(ctx context.Context) (*models.AdCampaign, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	today := truncateToDate(now)
+	var candidates []*models.AdCampaign
+	for _, camp := range s.campaigns {
+		if camp.Status != models.CampaignStatusActive {
+			continue
+		}
+		if camp.StartDate.After(now) || truncateToDate(camp.EndDate).Before(today) {
+			continue
+		}
+		if !s.servable(camp) {
+			continue
+		}
+		candidates = append(candidates, camp)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNotFound
+	}
+	result := s.snapshot(candidates[rand.Intn(len(candidates))])
+	return &result, nil
+}
+
+// ListServableAds 条件与 GetRandomActiveCampaign 相同，结果按活动 ID 排序
+func (s *MemStore) ListServableAds(ctx context.Context, now time.Time) ([]models.ServableAd, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	today := truncateToDate(now)
+	ads := []models.ServableAd{}
+	for _, camp := range s.campaigns {
+		if camp.Status != models.CampaignStatusActive {
+			continue
+		}
+		if camp.StartDate.After(now) || truncateToDate(camp.EndDate).Before(today) {
+			continue
+		}
+		if !s.servable(camp) {
+			continue
+		}
+		ad, ok := s.ads[camp.AdvertisementID] // JOIN advertisements
+		if !ok {
+			continue
+		}
+		var sp memSpend
+		if p, ok := s.spend[camp.ID]; ok {
+			sp = *p
+		}
+		ads = append(ads, models.ServableAd{
+			CampaignID:      camp.ID,
+			AdvertisementID: ad.ID,
+			UserID:          camp.UserID,
+			OrganizationID:  camp.OrganizationID,
+			StartDate:       camp.StartDate,
+			EndDate:         camp.EndDate,
+			PricingModel:    camp.PricingModel,
+			BidAmount:       camp.BidAmount,
+			Title:           ad.Title,
+			ImageURL:        ad.ImageURL,
+			TargetURL:       ad.TargetURL,
+			Width:           ad.Width,
+			Height:          ad.Height,
+			Format:          ad.Format,
+			Targeting:       camp.Targeting, // 创建后不再修改，可以共享
+			FrequencyCaps:   camp.FrequencyCaps,
+			Impressions:     sp.impressions,
+			Clicks:          sp.clicks,
+		})
+	}
+	sort.Slice(ads, func(i, j int) bool { return ads[i].CampaignID < ads[j].CampaignID })
+	return ads, nil
+}
+
+// --- 充值和余额 ---
+
+func (s *MemStore) CreateRechargeTransaction(ctx context.Context, orgID int, userID int, amountInCents int64, paymentMethod string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.nextRechargeID++
+	s.recharges[s.nextRechargeID] = &models.RechargeTransaction{
+		ID:             s.nextRechargeID,
+		UserID:         userID,
+		OrganizationID: orgID,
+		Amount:         money.New(amountInCents),
+		Status:         "Pending",
+		PaymentMethod:  paymentMethod,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	log.Printf("store(mem): 创建充值记录成功, ID: %d, OrganizationID: %d, UserID: %d, Amount: %d分", s.nextRechargeID, orgID, userID, amountInCents)
+	return s.nextRechargeID, nil
+}
+
+// ProcessSuccessfulRecharge 在同一把写锁内完成余额增加和状态更新，
+// 相当于 DBStore 中的数据库事务：要么都生效，要么都不生效。
+func (s *MemStore) ProcessSuccessfulRecharge(ctx context.Context, pending *models.RechargeTransaction, simulatedTxID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rechargeRecordID, amountInCents := pending.ID, pending.Amount.Minor
+
+	// 先检查充值记录，失败时不修改余额 (对应事务回滚)
+	rec, ok := s.recharges[rechargeRecordID]
+	if !ok || rec.Status != "Pending" {
+		return fmt.Errorf("store: recharge transaction %d not found or not in Pending state during update: %w", rechargeRecordID, ErrRechargeNotPending)
+	}
+
+	// 记账并增加余额 (与 DBStore 一样在同一个 "事务" 中，失败时不修改充值记录)
+	entry, err := ledger.Recharge(pending.OrganizationID, pending.UserID, amountInCents, rechargeRecordID)
+	if err != nil {
+		return fmt.Errorf("store: invalid recharge amount %d: %w", amountInCents, err)
+	}
+	if _, err := s.postEntryLocked(entry); err != nil {
+		return fmt.Errorf("store: failed to post recharge %d to ledger: %w", rechargeRecordID, err)
+	}
+
+	txID := simulatedTxID
+	rec.Status = "Success"
+	rec.TransactionID = &txID
+	rec.UpdatedAt = time.Now()
+
+	log.Printf("store(mem): 充值事务成功提交 (OrganizationID: %d, Amount: %d分, RecordID: %d)", pending.OrganizationID, amountInCents, rechargeRecordID)
+	return nil
+}
+
+func (s *MemStore) UpdateRechargeTransactionStatus(ctx context.Context, rechargeRecordID int64, status string, simulatedTxID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.recharges[rechargeRecordID]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.Status = status
+	if simulatedTxID != "" {
+		txID := simulatedTxID
+		rec.TransactionID = &txID
+	} else {
+		rec.TransactionID = nil // 对应 NULL
+	}
+	rec.UpdatedAt = time.Now()
+	return nil
+}
+
+// copyRecharge 复制充值记录，避免调用方修改内部数据
+func copyRecharge(rec *models.RechargeTransaction) models.RechargeTransaction {
+	c := *rec
+	if rec.TransactionID != nil {
+		txID := *rec.TransactionID
+		c.TransactionID = &txID
+	}
+	if rec.ProviderRef != nil {
+		ref := *rec.ProviderRef
+		c.ProviderRef = &ref
+	}
+	return c
+}
+
+func (s *MemStore) SetRechargeProviderRef(ctx context.Context, rechargeRecordID int64, providerRef string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.recharges[rechargeRecordID]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.ProviderRef = &providerRef
+	rec.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemStore) GetRechargeTransactionByID(ctx context.Context, rechargeRecordID int64) (*models.RechargeTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.recharges[rechargeRecordID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := copyRecharge(rec)
+	return &c, nil
+}
+
+func (s *MemStore) FailPendingRecharge(ctx context.Context, rechargeRecordID int64, providerTxID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.recharges[rechargeRecordID]
+	if !ok || rec.Status != "Pending" {
+		return ErrRechargeNotPending
+	}
+	rec.Status = "Failed"
+	rec.TransactionID = nil
+	if providerTxID != "" {
+		rec.TransactionID = &providerTxID
+	}
+	rec.UpdatedAt = time.Now()
+	log.Printf("store(mem): 充值记录 %d 支付失败 (TxID: %s)", rechargeRecordID, providerTxID)
+	return nil
+}
+
+func (s *MemStore) GetOrganizationBalance(ctx context.Context, orgID int) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	org, ok := s.orgs[orgID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return org.Balance.Minor, nil
+}
+
+func (s *MemStore) GetRechargeHistoryByOrganization(ctx context.Context, orgID int, filters models.RechargeHistoryFilters) ([]models.RechargeTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var history []models.RechargeTransaction
+	for _, rec := range s.recharges {
+		if rec.OrganizationID != orgID {
+			continue
+		}
+		if filters.Status != nil && rec.Status != *filters.Status {
+			continue
+		}
+		if filters.StartDate != nil && rec.CreatedAt.Before(*filters.StartDate) {
+			continue
+		}
+		if filters.EndDate != nil && rec.CreatedAt.After(*filters.EndDate) {
+			continue
+		}
+		if filters.MinAmount != nil && rec.Amount.Minor < *filters.MinAmount {
+			continue
+		}
+		if filters.MaxAmount != nil && rec.Amount.Minor > *filters.MaxAmount {
+			continue
+		}
+		history = append(history, copyRecharge(rec))
+	}
+	// ORDER BY created_at DESC (相同时间按 ID 倒序，保证结果稳定)
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].CreatedAt.Equal(history[j].CreatedAt) {
+			return history[i].ID > history[j].ID
+		}
+		return history[i].CreatedAt.After(history[j].CreatedAt)
+	})
+	return history, nil
+}
+
+// --- 广告活动管理 ---
+
+// campaignWithAd 模拟 ad_campaigns JOIN advertisements，广告不存在时返回 false (INNER JOIN)
+func (s *MemStore) campaignWithAd(camp *models.AdCampaign) (models.CampaignWithAdDetails, bool) {
+	ad, ok := s.ads[camp.AdvertisementID]
+	if !ok {
+		return models.CampaignWithAdDetails{}, false
+	}
+	return models.CampaignWithAdDetails{
+		ID:              camp.ID,
+		AdvertisementID: camp.AdvertisementID,
+		UserID:          camp.UserID,
+		OrganizationID:  camp.OrganizationID,
+		StartDate:       camp.StartDate,
+		EndDate:         camp.EndDate,
+		Status:          camp.Status,
+		CreatedAt:       camp.CreatedAt,
+		UpdatedAt:       camp.UpdatedAt,
+		CampaignBudget:  s.snapshot(camp).CampaignBudget,
+		Targeting:       camp.Targeting,
+		FrequencyCaps:   camp.FrequencyCaps,
+		AdTitle:         ad.Title,
+		AdImageURL:      ad.ImageURL,
+	}, true
+}
+
+func (s *MemStore) GetAdCampaignsByOrganization(ctx context.Context, orgID int, filters models.CampaignFilters) ([]models.CampaignWithAdDetails, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var campaigns []models.CampaignWithAdDetails
+	for _, camp := range s.campaigns {
+		if camp.OrganizationID != orgID {
+			continue
+		}
+		if filters.Status != nil && camp.Status != *filters.Status {
+			continue
+		}
+		if filters.StartDate != nil && camp.StartDate.Before(*filters.StartDate) {
+			continue
+		}
+		if filters.EndDate != nil && camp.EndDate.After(*filters.EndDate) {
+			continue
+		}
+		details, ok := s.campaignWithAd(camp)
+		if !ok {
+			continue
+		}
+		campaigns = append(campaigns, details)
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		if campaigns[i].CreatedAt.Equal(campaigns[j].CreatedAt) {
+			return campaigns[i].ID > campaigns[j].ID
+		}
+		return campaigns[i].CreatedAt.After(campaigns[j].CreatedAt)
+	})
+	return campaigns, nil
+}
+
+func (s *MemStore) GetAdCampaignByIDAndOrganization(ctx context.Context, campaignID int, orgID int) (*models.CampaignWithAdDetails, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	camp, ok := s.campaigns[campaignID]
+	if !ok || camp.OrganizationID != orgID {
+		return nil, ErrNotFound
+	}
+	details, ok := s.campaignWithAd(camp)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &details, nil
+}
+
+func (s *MemStore) UpdateAdCampaignStatusByOrganization(ctx context.Context, campaignID int, orgID int, changedBy int, newStatus string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 与 DBStore 一致：不存在或不属于该组织都返回 ErrNotFound
+	return s.transitionLocked(campaignTransition{
+		campaignID: campaignID,
+		orgID:      orgID,
+		to:         newStatus,
+		changedBy:  changedBy,
+		reason:     "user",
+		allowed:    canUserTransitionCampaign,
+	})
+}
+
+// --- 广告事件与效果 ---
+
+func (s *MemStore) LogAdEvent(ctx context.Context, event models.AdEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextEventID++
+	event.ID = s.nextEventID
+	s.events = append(s.events, event)
+	return nil
+}
+
+// ChargeAdEvent 与 DBStore 一致：在写锁内完成计费、扣余额、累加消耗和记录事件
+func (s *MemStore) ChargeAdEvent(ctx context.Context, event *models.AdEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	camp, ok := s.campaigns[event.CampaignID]
+	if !ok {
+		return ErrNotFound
+	}
+	org, ok := s.orgs[camp.OrganizationID]
+	if !ok {
+		return fmt.Errorf("store(mem): organization %d of campaign %d not found", camp.OrganizationID, camp.ID)
+	}
+	if event.ClickToken != nil && s.clicks[*event.ClickToken] {
+		return ErrDuplicateClick
+	}
+	u, ok := s.users[camp.UserID]
+	if !campaignServable(camp.Status, camp.StartDate, camp.EndDate, ok && u.SuspendedAt != nil, event.EventTimestamp) {
+		return ErrCampaignNotServable
+	}
+	sp, ok := s.spend[camp.ID]
+	if !ok {
+		sp = &memSpend{}
+		s.spend[camp.ID] = sp
+	}
+
+	b := s.snapshot(camp).CampaignBudget
+	cost, newRemainder := eventCost(b.PricingModel, chargeBid(b, event), sp.remainder, event.EventType)
+	if err := checkBudget(b, org.Balance.Minor, cost); err != nil {
+		return err
+	}
+
+	camp.SpentTotal = money.New(b.SpentTotal.Minor + cost)
+	camp.SpentToday = money.New(b.SpentToday.Minor + cost)
+	sp.date = today()
+	sp.remainder = newRemainder
+	impressions, clicks := eventCounts(event.EventType)
+	sp.impressions += impressions
+	sp.clicks += clicks
+
+	event.Cost = cost
+	event.UserID = camp.UserID
+	event.OrganizationID = camp.OrganizationID
+	s.nextEventID++
+	event.ID = s.nextEventID
+	s.events = append(s.events, *event)
+	if event.ClickToken != nil {
+		s.clicks[*event.ClickToken] = true
+	}
+	if cost > 0 {
+		entry, err := ledger.AdSpend(camp.OrganizationID, cost, event.ID)
+		if err == nil {
+			entry.Memo = fmt.Sprintf("campaign %d %s", event.CampaignID, event.EventType)
+			_, err = s.postEntryLocked(entry) // checkBudget 已确认余额充足
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) GetAdPerformanceSummary(ctx context.Context, orgID int, filters models.AdPerformanceFilter) ([]models.AdPerformanceSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type groupKey struct {
+		campaignID int
+		adID       int
+	}
+	groups := make(map[groupKey]*models.AdPerformanceSummary)
+
+	for _, evt := range s.events {
+		if evt.OrganizationID != orgID {
+			continue
+		}
+		if filters.StartDate != nil && evt.EventTimestamp.Before(*filters.StartDate) {
+			continue
+		}
+		if filters.EndDate != nil && evt.EventTimestamp.After(endOfDay(*filters.EndDate)) {
+			continue
+		}
+		if filters.CampaignID != nil && evt.CampaignID != *filters.CampaignID {
+			continue
+		}
+		// JOIN ad_campaigns / advertisements
+		if _, ok := s.campaigns[evt.CampaignID]; !ok {
+			continue
+		}
+		ad, ok := s.ads[evt.AdvertisementID]
+		if !ok {
+			continue
+		}
+
+		key := groupKey{campaignID: evt.CampaignID, adID: evt.AdvertisementID}
+		summary, ok := groups[key]
+		if !ok {
+			summary = &models.AdPerformanceSummary{
+				CampaignID:      evt.CampaignID,
+				AdvertisementID: evt.AdvertisementID,
+				AdTitle:         ad.Title,
+			}
+			groups[key] = summary
+		}
+		switch evt.EventType {
+		case "Impression":
+			summary.Impressions++
+		case "Click":
+			summary.Clicks++
+		}
+		summary.Spend.Minor += evt.Cost
+	}
+
+	var results []models.AdPerformanceSummary
+	for _, summary := range groups {
+		results = append(results, *summary)
+	}
+	// ORDER BY evt.campaign_id, evt.advertisement_id
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].CampaignID != results[j].CampaignID {
+			return results[i].CampaignID < results[j].CampaignID
+		}
+		return results[i].AdvertisementID < results[j].AdvertisementID
+	})
+	return results, nil
+}
+
+// --- 发票相关 ---
+
+func (s *MemStore) GetSuccessfulRechargeTotalInRange(ctx context.Context, orgID int, startDate, endDate time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := endOfDay(endDate)
+	var total int64
+	for _, rec := range s.recharges {
+		if rec.OrganizationID != orgID || rec.Status != "Success" {
+			continue
+		}
+		if rec.CreatedAt.Before(startDate) || rec.CreatedAt.After(end) {
+			continue
+		}
+		total += rec.Amount.Minor
+	}
+	return total, nil
+}
+
+func (s *MemStore) CreateInvoiceRequest(ctx context.Context, req models.InvoiceRequest) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextInvoiceID++
+	stored := req
+	stored.ID = s.nextInvoiceID
+	// 与 DBStore 一致：空字符串的 TaxID 存为 NULL
+	if req.TaxID != nil && *req.TaxID != "" {
+		taxID := *req.TaxID
+		stored.TaxID = &taxID
+	} else {
+		stored.TaxID = nil
+	}
+	// 新建记录时这些字段在数据库中都是 NULL
+	stored.InvoiceNumber = nil
+	stored.Notes = nil
+	stored.ProcessedAt = nil
+	s.invoices[stored.ID] = &stored
+	log.Printf("Successfully created invoice request ID %d for user %d (mem)", stored.ID, req.UserID)
+	return stored.ID, nil
+}
+
+// copyInvoice 深拷贝发票记录，避免调用方通过指针字段修改存储中的数据
+func copyInvoice(inv *models.InvoiceRequest) models.InvoiceRequest {
+	c := *inv
+	if inv.TaxID != nil {
+		v := *inv.TaxID
+		c.TaxID = &v
+	}
+	if inv.InvoiceNumber != nil {
+		v := *inv.InvoiceNumber
+		c.InvoiceNumber = &v
+	}
+	if inv.Notes != nil {
+		v := *inv.Notes
+		c.Notes = &v
+	}
+	if inv.ProcessedAt != nil {
+		v := *inv.ProcessedAt
+		c.ProcessedAt = &v
+	}
+	return c
+}
+
+func (s *MemStore) GetInvoiceRequestsByOrganization(ctx context.Context, orgID int, filters models.InvoiceRequestFilter) ([]models.InvoiceRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var requests []models.InvoiceRequest
+	for _, inv := range s.invoices {
+		if inv.OrganizationID != orgID {
+			continue
+		}
+		if filters.Status != nil && inv.Status != *filters.Status {
+			continue
+		}
+		if filters.StartDate != nil && inv.RequestedAt.Before(*filters.StartDate) {
+			continue
+		}
+		if filters.EndDate != nil && inv.RequestedAt.After(endOfDay(*filters.EndDate)) {
+			continue
+		}
+		requests = append(requests, copyInvoice(inv))
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].RequestedAt.Equal(requests[j].RequestedAt) {
+			return requests[i].ID > requests[j].ID
+		}
+		return requests[i].RequestedAt.After(requests[j].RequestedAt)
+	})
+	return requests, nil
+}
+
+func (s *MemStore) GetInvoiceRequestByIDAndOrganization(ctx context.Context, invoiceID int64, orgID int) (*models.InvoiceRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inv, ok := s.invoices[invoiceID]
+	if !ok || inv.OrganizationID != orgID {
+		return nil, ErrNotFound
+	}
+	result := copyInvoice(inv)
+	return &result, nil
+}
+
+func (s *MemStore) GetInvoiceRequestByID(ctx context.Context, invoiceID int64) (*models.InvoiceRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inv, ok := s.invoices[invoiceID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := copyInvoice(inv)
+	return &result, nil
+}
+
+// --- 账本 ---
+
+// postEntryLocked 与 DBStore.postEntry 一致：校验分录、检查引用唯一、同步更新余额。调用方必须持有写锁
+func (s *MemStore) postEntryLocked(e ledger.Entry) (int64, error) {
+	if err := e.Validate(); err != nil {
+		return 0, err
+	}
+	if e.Reference != "" && s.postedRef[e.Reference] {
+		return 0, fmt.Errorf("%w: %s", ErrAlreadyPosted, e.Reference)
+	}
+	org, ok := s.orgs[e.OrganizationID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	delta := e.WalletDelta()
+	if org.Balance.Minor+delta < 0 {
+		return 0, ErrInsufficientBalance
+	}
+
+	s.nextEntryID++
+	entry := models.LedgerEntry{
+		ID:        s.nextEntryID,
+		Kind:      e.Kind,
+		Memo:      e.Memo,
+		CreatedAt: time.Now(),
+		Amount:    money.New(delta),
+	}
+	orgID := e.OrganizationID
+	entry.OrganizationID = &orgID
+	if e.UserID > 0 {
+		userID := e.UserID
+		entry.UserID = &userID
+	}
+	if e.Reference != "" {
+		ref := e.Reference
+		entry.Reference = &ref
+		s.postedRef[ref] = true
+	}
+	if e.CreatedBy > 0 {
+		createdBy := e.CreatedBy
+		entry.CreatedBy = &createdBy
+	}
+	for _, p := range e.Postings {
+		entry.Postings = append(entry.Postings, models.LedgerPosting{Account: p.Account, Amount: money.New(p.Amount)})
+	}
+	s.journal = append(s.journal, entry)
+	org.Balance.Minor += delta
+	return entry.ID, nil
+}
+
+func (s *MemStore) PostLedgerEntry(ctx context.Context, e ledger.Entry) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entryID, err := s.postEntryLocked(e)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("store(mem): 账本分录 %d 已过账 (%s, 组织 %d, 余额变动 %d 分)", entryID, e.Kind, e.OrganizationID, e.WalletDelta())
+	return entryID, nil
+}
+
+func (s *MemStore) GetLedgerEntriesByOrganization(ctx context.Context, orgID int, filter models.LedgerFilter) ([]models.LedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []models.LedgerEntry{}
+	skip := filter.Offset
+	for i := len(s.journal) - 1; i >= 0 && len(entries) < filter.Limit; i-- { // ORDER BY id DESC
+		entry := s.journal[i]
+		if entry.OrganizationID == nil || *entry.OrganizationID != orgID {
+			continue
+		}
+		if filter.Kind != nil && entry.Kind != *filter.Kind {
+			continue
+		}
+		if skip > 0 { // OFFSET
+			skip--
+			continue
+		}
+		entry.Postings = append([]models.LedgerPosting{}, entry.Postings...)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *MemStore) ReconcileBalances(ctx context.Context) ([]models.BalanceDiscrepancy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ledgerBalances := make(map[int]int64)
+	for _, entry := range s.journal {
+		for _, p := range entry.Postings {
+			for orgID := range s.orgs {
+				if p.Account == ledger.WalletAccount(orgID) {
+					ledgerBalances[orgID] -= p.Amount.Minor
+				}
+			}
+		}
+	}
+	discrepancies := []models.BalanceDiscrepancy{}
+	for orgID, org := range s.orgs {
+		if org.Balance.Minor != ledgerBalances[orgID] {
+			discrepancies = append(discrepancies, models.BalanceDiscrepancy{
+				OrganizationID: orgID,
+				CachedBalance:  org.Balance,
+				LedgerBalance:  money.New(ledgerBalances[orgID]),
+			})
+		}
+	}
+	sort.Slice(discrepancies, func(i, j int) bool { return discrepancies[i].OrganizationID < discrepancies[j].OrganizationID })
+	return discrepancies, nil
+}
+
+// --- 幂等键 ---
+
+// memIdemKey 对应 idempotency_keys 的主键 (user_id, idem_key)
+type memIdemKey struct {
+	userID int
+	key    string
+}
+
+func (s *MemStore) ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memIdemKey{rec.UserID, rec.Key}
+	if existing, ok := s.idemKeys[k]; ok && !existing.CreatedAt.Before(expiredBefore) {
+		c := *existing
+		c.ResponseBody = append([]byte(nil), existing.ResponseBody...)
+		return &c, nil
+	}
+	rec.StatusCode = 0
+	rec.ResponseBody = nil
+	s.idemKeys[k] = &rec
+	return nil, nil
+}
+
+func (s *MemStore) CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.idemKeys[memIdemKey{userID, key}]; ok {
+		rec.StatusCode = statusCode
+		rec.ResponseBody = append([]byte(nil), body...)
+	}
+	return nil
+}
+
+func (s *MemStore) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idemKeys, memIdemKey{userID, key})
+	return nil
+}
+
+// --- 刷新令牌和访问令牌吊销列表 ---
+
+func copyRefreshToken(t *models.RefreshToken) *models.RefreshToken {
+	c := *t
+	if t.RevokedAt != nil {
+		revokedAt := *t.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+	return &c
+}
+
+func (s *MemStore) insertRefreshTokenLocked(t *models.RefreshToken) error {
+	if _, ok := s.refresh[t.TokenHash]; ok {
+		return fmt.Errorf("store(mem): duplicate refresh token hash")
+	}
+	s.nextRefreshID++
+	t.ID = s.nextRefreshID
+	s.refresh[t.TokenHash] = copyRefreshToken(t)
+	return nil
+}
+
+func (s *MemStore) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertRefreshTokenLocked(t)
+}
+
+func (s *MemStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.refresh[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyRefreshToken(t), nil
+}
+
+func (s *MemStore) RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.refresh[oldHash]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.RevokedAt != nil {
+		sessionID := old.SessionID
+		n := s.revokeRefreshTokensLocked(func(t *models.RefreshToken) bool { return t.SessionID == sessionID }, now)
+		log.Printf("store(mem): 用户 %d 的刷新令牌 %d 被重复使用，会话 %s 已吊销 (%d 个令牌)", old.UserID, old.ID, sessionID, n)
+		return ErrRefreshTokenReused
+	}
+	if !old.ExpiresAt.After(now) {
+		return ErrRefreshTokenExpired
+	}
+	revokedAt := now
+	old.RevokedAt = &revokedAt
+	next.UserID = old.UserID
+	next.SessionID = old.SessionID
+	return s.insertRefreshTokenLocked(next)
+}
+
+// revokeRefreshTokensLocked 与 DBStore.revokeRefreshTokens 相同：吊销匹配的刷新令牌，并把尚未过期的访问令牌加入吊销列表
+func (s *MemStore) revokeRefreshTokensLocked(match func(t *models.RefreshToken) bool, now time.Time) int {
+	n := 0
+	for _, t := range s.refresh {
+		if !match(t) {
+			continue
+		}
+		if t.AccessExpiresAt.After(now) {
+			if _, ok := s.revoked[t.AccessJTI]; !ok {
+				s.revoked[t.AccessJTI] = t.AccessExpiresAt
+			}
+		}
+		if t.RevokedAt == nil {
+			revokedAt := now
+			t.RevokedAt = &revokedAt
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemStore) RevokeSessionByAccessJTI(ctx context.Context, userID int, jti string, accessExpiresAt time.Time, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revoked[jti]; !ok {
+		s.revoked[jti] = accessExpiresAt
+	}
+	for _, t := range s.refresh {
+		if t.AccessJTI == jti && t.UserID == userID {
+			sessionID := t.SessionID
+			s.revokeRefreshTokensLocked(func(t *models.RefreshToken) bool { return t.SessionID == sessionID }, now)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) RevokeAllSessions(ctx context.Context, userID int, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.revokeRefreshTokensLocked(func(t *models.RefreshToken) bool { return t.UserID == userID }, now)
+	log.Printf("store(mem): 用户 %d 的所有会话已吊销 (%d 个刷新令牌)", userID, n)
+	return n, nil
+}
+
+func (s *MemStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *MemStore) PurgeExpiredTokens(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for hash, t := range s.refresh {
+		if t.ExpiresAt.Before(now) {
+			delete(s.refresh, hash)
+			n++
+		}
+	}
+	for jti, expiresAt := range s.revoked {
+		if expiresAt.Before(now) {
+			delete(s.revoked, jti)
+			n++
+		}
+	}
+	for hash, t := range s.tokens {
+		if t.ExpiresAt.Before(now) {
+			delete(s.tokens, hash)
+			n++
+		}
+	}
+	for hash, c := range s.mfa {
+		if c.ExpiresAt.Before(now) {
+			delete(s.mfa, hash)
+			n++
+		}
+	}
+	for key, a := range s.attempts {
+		if a.ExpiresAt.Before(now) {
+			delete(s.attempts, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- API Key ---
+
+func copyAPIKey(k *models.APIKey) models.APIKey {
+	c := *k
+	c.Scopes = append([]string{}, k.Scopes...)
+	for _, p := range []**time.Time{&c.ExpiresAt, &c.LastUsedAt, &c.RevokedAt} {
+		if *p != nil {
+			t := **p
+			*p = &t
+		}
+	}
+	return c
+}
+
+func (s *MemStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == key.Prefix {
+			return ErrDuplicateAPIKeyPrefix
+		}
+	}
+	s.nextAPIKeyID++
+	key.ID = s.nextAPIKeyID
+	c := copyAPIKey(key)
+	s.apiKeys[key.ID] = &c
+	return nil
+}
+
+func (s *MemStore) GetAPIKeysByUserID(ctx context.Context, userID int) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []models.APIKey{}
+	for _, k := range s.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, copyAPIKey(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (s *MemStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			c := copyAPIKey(k)
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemStore) RevokeAPIKey(ctx context.Context, keyID int64, userID int, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[keyID]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return ErrNotFound
+	}
+	k.RevokedAt = &now
+	return nil
+}
+
+func (s *MemStore) TouchAPIKey(ctx context.Context, keyID int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.apiKeys[keyID]; ok {
+		k.LastUsedAt = &now
+	}
+	return nil
+}
+
+// --- 发票处理 ---
+
+func (s *MemStore) UpdateInvoiceRequestStatus(ctx context.Context, invoiceID int64, status string, invoiceNumber *string, notes *string, processedAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invoices[invoiceID]
+	if !ok {
+		return ErrNotFound
+	}
+	if inv.Status == "Completed" || inv.Status == "Failed" {
+		return ErrInvoiceAlreadyProcessed
+	}
+	inv.Status = status
+	if invoiceNumber != nil {
+		v := *invoiceNumber
+		inv.InvoiceNumber = &v
+	}
+	if notes != nil {
+		v := *notes
+		inv.Notes = &v
+	}
+	if processedAt != nil {
+		v := *processedAt
+		inv.ProcessedAt = &v
+	}
+	log.Printf("store(mem): invoice request %d status updated to %s", invoiceID, status)
+	return nil
+}
+
+// --- 组织 ---
+
+// memMemberKey 对应 organization_members 的主键 (organization_id, user_id)
+type memMemberKey struct {
+	orgID  int
+	userID int
+}
+
+// copyUser 复制用户，避免调用方通过指针字段修改存储中的数据
+func copyUser(u *models.User) *models.User {
+	user := *u
+	if u.DefaultOrganizationID != nil {
+		id := *u.DefaultOrganizationID
+		user.DefaultOrganizationID = &id
+	}
+	if u.Email != nil {
+		email := *u.Email
+		user.Email = &email
+	}
+	if u.EmailVerifiedAt != nil {
+		verifiedAt := *u.EmailVerifiedAt
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	if u.TOTPEnabledAt != nil {
+		enabledAt := *u.TOTPEnabledAt
+		user.TOTPEnabledAt = &enabledAt
+	}
+	if u.SuspendedAt != nil {
+		suspendedAt := *u.SuspendedAt
+		user.SuspendedAt = &suspendedAt
+	}
+	if u.SuspensionReason != nil {
+		reason := *u.SuspensionReason
+		user.SuspensionReason = &reason
+	}
+	return &user
+}
+
+// insertOrganizationLocked 创建组织并把 ownerID 加为 owner。调用方必须持有写锁
+func (s *MemStore) insertOrganizationLocked(name string, ownerID int) *models.Organization {
+	now := time.Now()
+	s.nextOrgID++
+	org := &models.Organization{ID: s.nextOrgID, Name: name, Balance: money.New(0), CreatedAt: now}
+	s.orgs[org.ID] = org
+	s.members[memMemberKey{org.ID, ownerID}] = &models.OrganizationMember{
+		OrganizationID: org.ID, UserID: ownerID, Role: auth.OrgRoleOwner, CreatedAt: now,
+	}
+	return org
+}
+
+// memberLocked 返回带用户名的成员副本 (JOIN users)
+func (s *MemStore) memberLocked(m *models.OrganizationMember) models.OrganizationMember {
+	result := *m
+	if u, ok := s.users[m.UserID]; ok {
+		result.Username = u.Username
+	}
+	return result
+}
+
+func (s *MemStore) CreateOrganization(ctx context.Context, org *models.Organization, ownerID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := s.insertOrganizationLocked(org.Name, ownerID)
+	*org = *created
+	log.Printf("store(mem): 用户 %d 创建组织 %d (%s)", ownerID, org.ID, org.Name)
+	return nil
+}
+
+func (s *MemStore) GetOrganizationsByUserID(ctx context.Context, userID int) ([]models.OrganizationMembership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	memberships := []models.OrganizationMembership{}
+	user, ok := s.users[userID]
+	if !ok {
+		return memberships, nil
+	}
+	for key, m := range s.members {
+		if key.userID != userID {
+			continue
+		}
+		org, ok := s.orgs[key.orgID]
+		if !ok {
+			continue
+		}
+		memberships = append(memberships, models.OrganizationMembership{
+			Organization: *org,
+			Role:         m.Role,
+			IsDefault:    user.DefaultOrganizationID != nil && *user.DefaultOrganizationID == org.ID,
+		})
+	}
+	sort.Slice(memberships, func(i, j int) bool { return memberships[i].ID < memberships[j].ID })
+	return memberships, nil
+}
+
+func (s *MemStore) GetOrganizationMember(ctx context.Context, orgID int, userID int) (*models.OrganizationMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.members[memMemberKey{orgID, userID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := s.memberLocked(m)
+	return &result, nil
+}
+
+func (s *MemStore) GetOrganizationMembers(ctx context.Context, orgID int) ([]models.OrganizationMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := []models.OrganizationMember{}
+	for key, m := range s.members {
+		if key.orgID == orgID {
+			members = append(members, s.memberLocked(m))
+		}
+	}
+	// ORDER BY created_at, user_id
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].CreatedAt.Equal(members[j].CreatedAt) {
+			return members[i].UserID < members[j].UserID
+		}
+		return members[i].CreatedAt.Before(members[j].CreatedAt)
+	})
+	return members, nil
+}
+
+// checkLastOwnerLocked 与 DBStore.lockMemberRole 一致：owner 操作后不再是 owner 时，组织必须还有其他 owner
+func (s *MemStore) checkLastOwnerLocked(orgID, userID int, keepsOwner bool) (*models.OrganizationMember, error) {
+	m, ok := s.members[memMemberKey{orgID, userID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if m.Role != auth.OrgRoleOwner || keepsOwner {
+		return m, nil
+	}
+	owners := 0
+	for key, other := range s.members {
+		if key.orgID == orgID && other.Role == auth.OrgRoleOwner {
+			owners++
+		}
+	}
+	if owners <= 1 {
+		return nil, ErrLastOwner
+	}
+	return m, nil
+}
+
+func (s *MemStore) UpdateOrganizationMemberRole(ctx context.Context, orgID int, userID int, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.checkLastOwnerLocked(orgID, userID, role == auth.OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+	m.Role = role
+	log.Printf("store(mem): 组织 %d 成员 %d 角色更新为 %s", orgID, userID, role)
+	return nil
+}
+
+func (s *MemStore) RemoveOrganizationMember(ctx context.Context, orgID int, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.checkLastOwnerLocked(orgID, userID, false); err != nil {
+		return err
+	}
+	delete(s.members, memMemberKey{orgID, userID})
+	if u, ok := s.users[userID]; ok && u.DefaultOrganizationID != nil && *u.DefaultOrganizationID == orgID {
+		u.DefaultOrganizationID = nil
+	}
+	log.Printf("store(mem): 用户 %d 已离开组织 %d", userID, orgID)
+	return nil
+}
+
+// invitationLocked 返回带组织名和用户名的邀请副本 (JOIN organizations / users)
+func (s *MemStore) invitationLocked(inv *models.OrganizationInvitation) models.OrganizationInvitation {
+	result := *inv
+	if org, ok := s.orgs[inv.OrganizationID]; ok {
+		result.OrganizationName = org.Name
+	}
+	if u, ok := s.users[inv.UserID]; ok {
+		result.Username = u.Username
+	}
+	if inv.RespondedAt != nil {
+		at := *inv.RespondedAt
+		result.RespondedAt = &at
+	}
+	return result
+}
+
+func (s *MemStore) queryInvitationsLocked(match func(inv *models.OrganizationInvitation) bool) []models.OrganizationInvitation {
+	invitations := []models.OrganizationInvitation{}
+	for _, inv := range s.invitations {
+		if match(inv) {
+			invitations = append(invitations, s.invitationLocked(inv))
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].ID > invitations[j].ID })
+	return invitations
+}
+
+func (s *MemStore) CreateOrganizationInvitation(ctx context.Context, inv *models.OrganizationInvitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[memMemberKey{inv.OrganizationID, inv.UserID}]; ok {
+		return ErrAlreadyMember
+	}
+	for _, old := range s.invitations {
+		if old.OrganizationID == inv.OrganizationID && old.UserID == inv.UserID && old.Status == models.InvitationStatusPending {
+			at := inv.CreatedAt
+			old.Status = models.InvitationStatusRevoked
+			old.RespondedAt = &at
+		}
+	}
+	s.nextInvitationID++
+	inv.ID = s.nextInvitationID
+	inv.Status = models.InvitationStatusPending
+	stored := *inv
+	s.invitations[stored.ID] = &stored
+	log.Printf("store(mem): 组织 %d 邀请用户 %d 成为 %s (邀请 %d)", inv.OrganizationID, inv.UserID, inv.Role, inv.ID)
+	return nil
+}
+
+func (s *MemStore) GetOrganizationInvitations(ctx context.Context, orgID int) ([]models.OrganizationInvitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.queryInvitationsLocked(func(inv *models.OrganizationInvitation) bool {
+		return inv.OrganizationID == orgID && inv.Status == models.InvitationStatusPending
+	}), nil
+}
+
+func (s *MemStore) GetInvitationsByUserID(ctx context.Context, userID int, now time.Time) ([]models.OrganizationInvitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.queryInvitationsLocked(func(inv *models.OrganizationInvitation) bool {
+		return inv.UserID == userID && inv.Status == models.InvitationStatusPending && inv.ExpiresAt.After(now)
+	}), nil
+}
+
+func (s *MemStore) RespondToInvitation(ctx context.Context, invitationID int64, userID int, accept bool, now time.Time) (*models.OrganizationInvitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invitations[invitationID]
+	if !ok || inv.UserID != userID || inv.Status != models.InvitationStatusPending {
+		return nil, ErrNotFound
+	}
+	if !now.Before(inv.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+	key := memMemberKey{inv.OrganizationID, userID}
+	if _, exists := s.members[key]; accept && exists {
+		return nil, ErrAlreadyMember
+	}
+
+	inv.Status = models.InvitationStatusDeclined
+	if accept {
+		inv.Status = models.InvitationStatusAccepted
+		s.members[key] = &models.OrganizationMember{
+			OrganizationID: inv.OrganizationID, UserID: userID, Role: inv.Role, CreatedAt: now,
+		}
+		if u, ok := s.users[userID]; ok && u.DefaultOrganizationID == nil {
+			orgID := inv.OrganizationID
+			u.DefaultOrganizationID = &orgID
+		}
+	}
+	at := now
+	inv.RespondedAt = &at
+	result := s.invitationLocked(inv)
+	log.Printf("store(mem): 用户 %d %s 组织 %d 的邀请 %d", userID, inv.Status, inv.OrganizationID, inv.ID)
+	return &result, nil
+}
+
+func (s *MemStore) RevokeInvitation(ctx context.Context, invitationID int64, orgID int, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invitations[invitationID]
+	if !ok || inv.OrganizationID != orgID || inv.Status != models.InvitationStatusPending {
+		return ErrNotFound
+	}
+	at := now
+	inv.Status = models.InvitationStatusRevoked
+	inv.RespondedAt = &at
+	return nil
+}
+
+// --- 邮箱验证 / 找回密码 ---
+
+func (s *MemStore) CreateUserToken(ctx context.Context, t *models.UserToken, msg *models.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, old := range s.tokens {
+		if old.UserID == t.UserID && old.Purpose == t.Purpose && old.UsedAt == nil {
+			usedAt := t.CreatedAt
+			old.UsedAt = &usedAt
+		}
+	}
+	s.nextUserTokenID++
+	t.ID = s.nextUserTokenID
+	stored := *t
+	s.tokens[stored.TokenHash] = &stored
+	s.insertOutboxMessageLocked(msg)
+	return nil
+}
+
+// useUserTokenLocked 对应 DBStore.useUserToken。调用方必须持有写锁
+func (s *MemStore) useUserTokenLocked(tokenHash, purpose string, now time.Time) (*models.User, error) {
+	t, ok := s.tokens[tokenHash]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil {
+		return nil, ErrNotFound
+	}
+	if !now.Before(t.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	u, ok := s.users[t.UserID]
+	if !ok || u.Email == nil || *u.Email != t.Email {
+		return nil, ErrNotFound
+	}
+	usedAt := now
+	t.UsedAt = &usedAt
+	if u.EmailVerifiedAt == nil {
+		verifiedAt := now
+		u.EmailVerifiedAt = &verifiedAt
+	}
+	return u, nil
+}
+
+func (s *MemStore) VerifyEmail(ctx context.Context, tokenHash string, now time.Time) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.useUserTokenLocked(tokenHash, models.TokenPurposeVerifyEmail, now)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(u), nil
+}
+
+func (s *MemStore) ResetPassword(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.useUserTokenLocked(tokenHash, models.TokenPurposeResetPassword, now)
+	if err != nil {
+		return nil, err
+	}
+	u.PasswordHash = passwordHash
+	return copyUser(u), nil
+}
+
+// --- 邮件发件箱 ---
+
+// insertOutboxMessageLocked 对应 DBStore 的 insertOutboxMessage。调用方必须持有写锁
+func (s *MemStore) insertOutboxMessageLocked(msg *models.OutboxMessage) {
+	s.nextOutboxID++
+	msg.ID = s.nextOutboxID
+	msg.Status = models.OutboxStatusPending
+	msg.NextAttemptAt = msg.CreatedAt
+	stored := *msg
+	s.outbox[stored.ID] = &stored
+}
+
+func (s *MemStore) ClaimOutboxMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*models.OutboxMessage
+	for _, m := range s.outbox {
+		if m.Status == models.OutboxStatusPending && !m.NextAttemptAt.After(now) {
+			due = append(due, m)
+		}
+	}
+	// 与 DBStore 一致：ORDER BY next_attempt_at, id
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var messages []models.OutboxMessage
+	for _, m := range due {
+		m.Attempts++
+		m.NextAttemptAt = now.Add(lease)
+		messages = append(messages, *m)
+	}
+	return messages, nil
+}
+
+func (s *MemStore) MarkOutboxMessageSent(ctx context.Context, id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.outbox[id]
+	if !ok || m.Status != models.OutboxStatusPending {
+		return ErrNotFound
+	}
+	sentAt := now
+	m.Status = models.OutboxStatusSent
+	m.SentAt = &sentAt
+	m.LastError = ""
+	return nil
+}
+
+func (s *MemStore) MarkOutboxMessageFailed(ctx context.Context, id int64, lastErr string, retryAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.outbox[id]
+	if !ok || m.Status != models.OutboxStatusPending {
+		return ErrNotFound
+	}
+	m.LastError = truncateError(lastErr)
+	if retryAt == nil {
+		m.Status = models.OutboxStatusFailed
+	} else {
+		m.NextAttemptAt = *retryAt
+	}
+	return nil
+}
+
+// --- 两步验证 ---
+
+func (s *MemStore) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	if u.TOTPEnabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+	u.TOTPSecret = secret
+	return nil
+}
+
+func (s *MemStore) EnableTOTP(ctx context.Context, userID int, step int64, recoveryHashes []string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	if u.TOTPEnabledAt != nil {
+		return ErrTOTPAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return ErrNotFound
+	}
+	enabledAt := now
+	u.TOTPEnabledAt = &enabledAt
+	u.TOTPLastStep = step
+	s.replaceRecoveryCodesLocked(userID, recoveryHashes)
+	return nil
+}
+
+func (s *MemStore) DisableTOTP(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.TOTPSecret = ""
+	u.TOTPEnabledAt = nil
+	u.TOTPLastStep = 0
+	s.replaceRecoveryCodesLocked(userID, nil)
+	return nil
+}
+
+func (s *MemStore) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.TOTPLastStep >= step {
+		return ErrOTPReused
+	}
+	u.TOTPLastStep = step
+	return nil
+}
+
+func (s *MemStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := memRecoveryKey{userID: userID, hash: codeHash}
+	usedAt, ok := s.recovery[key]
+	if !ok || usedAt != nil {
+		return ErrNotFound
+	}
+	t := now
+	s.recovery[key] = &t
+	return nil
+}
+
+func (s *MemStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodesLocked(userID, codeHashes)
+	return nil
+}
+
+// replaceRecoveryCodesLocked 删除用户的全部恢复码并保存新的恢复码。调用方必须持有写锁
+func (s *MemStore) replaceRecoveryCodesLocked(userID int, codeHashes []string) {
+	for key := range s.recovery {
+		if key.userID == userID {
+			delete(s.recovery, key)
+		}
+	}
+	for _, hash := range codeHashes {
+		s.recovery[memRecoveryKey{userID: userID, hash: hash}] = nil
+	}
+}
+
+func (s *MemStore) CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for key, usedAt := range s.recovery {
+		if key.userID == userID && usedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemStore) SetTwoFactorRequired(ctx context.Context, userID int, required bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.TwoFactorRequired = required
+	return nil
+}
+
+func (s *MemStore) CreateLoginChallenge(ctx context.Context, c *models.LoginChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextChallengeID++
+	c.ID = s.nextChallengeID
+	stored := *c
+	s.mfa[c.TokenHash] = &stored
+	return nil
+}
+
+func (s *MemStore) GetLoginChallenge(ctx context.Context, tokenHash string) (*models.LoginChallenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.mfa[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *c
+	if c.UsedAt != nil {
+		usedAt := *c.UsedAt
+		result.UsedAt = &usedAt
+	}
+	return &result, nil
+}
+
+// loginChallengeLocked 按 ID 查找登录挑战。调用方必须持有锁
+func (s *MemStore) loginChallengeLocked(id int64) *models.LoginChallenge {
+	for _, c := range s.mfa {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) FailLoginChallenge(ctx context.Context, id int64, maxAttempts int, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.loginChallengeLocked(id)
+	if c == nil {
+		return 0, ErrNotFound
+	}
+	c.Attempts++
+	if c.Attempts >= maxAttempts && c.UsedAt == nil {
+		usedAt := now
+		c.UsedAt = &usedAt
+	}
+	return c.Attempts, nil
+}
+
+func (s *MemStore) CompleteLoginChallenge(ctx context.Context, id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.loginChallengeLocked(id)
+	if c == nil || c.UsedAt != nil {
+		return ErrNotFound
+	}
+	usedAt := now
+	c.UsedAt = &usedAt
+	return nil
+}
+
+// --- 登录失败计数 ---
+
+// copyLoginAttempt 复制登录失败记录
+func copyLoginAttempt(a *models.LoginAttempt) *models.LoginAttempt {
+	result := *a
+	if a.LockedUntil != nil {
+		lockedUntil := *a.LockedUntil
+		result.LockedUntil = &lockedUntil
+	}
+	return &result
+}
+
+func (s *MemStore) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.attempts[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyLoginAttempt(a), nil
+}
+
+func (s *MemStore) RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok || !now.Before(a.ExpiresAt) {
+		a = &models.LoginAttempt{Key: key}
+		s.attempts[key] = a
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	if expiresAt := now.Add(window); expiresAt.After(a.ExpiresAt) {
+		a.ExpiresAt = expiresAt
+	}
+	return copyLoginAttempt(a), nil
+}
+
+func (s *MemStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok {
+		return ErrNotFound
+	}
+	lockedUntil := until
+	a.LockedUntil = &lockedUntil
+	if until.After(a.ExpiresAt) {
+		a.ExpiresAt = until
+	}
+	return nil
+}
+
+func (s *MemStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// --- 后台用户管理 ---
+
+func (s *MemStore) ListUsers(ctx context.Context, filter models.AdminUserFilter) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	q := strings.ToLower(strings.TrimSpace(filter.Query))
+	var matched []*models.User
+	for _, u := range s.users {
+		if q != "" && !strings.Contains(strings.ToLower(u.Username), q) &&
+			(u.Email == nil || !strings.Contains(*u.Email, q)) {
+			continue
+		}
+		if filter.Role != nil && u.Role != *filter.Role {
+			continue
+		}
+		if filter.Status != nil && (u.SuspendedAt != nil) != (*filter.Status == models.UserStatusSuspended) {
+			continue
+		}
+		matched = append(matched, u)
+	}
+	// ORDER BY id DESC LIMIT ? OFFSET ?
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID > matched[j].ID })
+	users := []models.User{}
+	for i := filter.Offset; i < len(matched) && len(users) < filter.Limit; i++ {
+		users = append(users, *copyUser(matched[i]))
+	}
+	return users, nil
+}
+
+func (s *MemStore) SetUserSuspension(ctx context.Context, userID int, suspendedAt *time.Time, reason *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	u.SuspendedAt, u.SuspensionReason = nil, nil
+	if suspendedAt != nil {
+		at := *suspendedAt
+		u.SuspendedAt = &at
+		if reason != nil {
+			why := *reason
+			u.SuspensionReason = &why
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) GetAdvertisementsByUserID(ctx context.Context, userID int) ([]models.Advertisement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ads []models.Advertisement
+	for _, ad := range s.ads {
+		if ad.UserID == userID {
+			ads = append(ads, *ad)
+		}
+	}
+	sort.Slice(ads, func(i, j int) bool { return ads[i].ID > ads[j].ID })
+	return ads, nil
+}
+
+func (s *MemStore) GetAdCampaignsByUserID(ctx context.Context, userID int) ([]models.CampaignWithAdDetails, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var campaigns []models.CampaignWithAdDetails
+	for _, camp := range s.campaigns {
+		if camp.UserID != userID {
+			continue
+		}
+		if details, ok := s.campaignWithAd(camp); ok {
+			campaigns = append(campaigns, details)
+		}
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		if campaigns[i].CreatedAt.Equal(campaigns[j].CreatedAt) {
+			return campaigns[i].ID > campaigns[j].ID
+		}
+		return campaigns[i].CreatedAt.After(campaigns[j].CreatedAt)
+	})
+	return campaigns, nil
+}
+
+func (s *MemStore) GetRechargesByUserID(ctx context.Context, userID int) ([]models.RechargeTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var history []models.RechargeTransaction
+	for _, rec := range s.recharges {
+		if rec.UserID == userID {
+			history = append(history, copyRecharge(rec))
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].CreatedAt.Equal(history[j].CreatedAt) {
+			return history[i].ID > history[j].ID
+		}
+		return history[i].CreatedAt.After(history[j].CreatedAt)
+	})
+	return history, nil
+}
+
+// --- 审计日志 ---
+
+// copyAuditEntry 复制 before/after，避免调用方修改内部状态
+func copyAuditEntry(e models.AuditEntry) models.AuditEntry {
+	if e.ActorID != nil {
+		id := *e.ActorID
+		e.ActorID = &id
+	}
+	if e.Before != nil {
+		e.Before = append([]byte(nil), e.Before...)
+	}
+	if e.After != nil {
+		e.After = append([]byte(nil), e.After...)
+	}
+	return e
+}
+
+func (s *MemStore) AppendAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	s.nextAuditID++
+	e.ID = s.nextAuditID
+	s.audit = append(s.audit, copyAuditEntry(*e))
+	return nil
+}
+
+func (s *MemStore) ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// ORDER BY id DESC LIMIT ? OFFSET ?：audit 按 id 递增，倒序遍历即可
+	entries := []models.AuditEntry{}
+	skipped := 0
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		e := s.audit[i]
+		if filter.ActorID != nil && (e.ActorID == nil || *e.ActorID != *filter.ActorID) {
+			continue
+		}
+		if filter.Action != nil && e.Action != *filter.Action {
+			continue
+		}
+		if filter.TargetType != nil && e.TargetType != *filter.TargetType {
+			continue
+		}
+		if filter.TargetID != nil && e.TargetID != *filter.TargetID {
+			continue
+		}
+		if filter.RequestID != nil && e.RequestID != *filter.RequestID {
+			continue
+		}
+		if filter.Since != nil && e.CreatedAt.Before(*filter.Since) {
+			continue
+		}
+		if filter.Until != nil && !e.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		entries = append(entries, copyAuditEntry(e))
+	}
+	return entries, nil
+}
+
+// --- 广告位 ---
+
+// copyPlacement 复制广告位，避免调用方修改 Formats 影响存储的数据
+func copyPlacement(p *models.Placement) models.Placement {
+	c := *p
+	c.Formats = slices.Clone(p.Formats)
+	return c
+}
+
+func (s *MemStore) CreatePlacement(ctx context.Context, p *models.Placement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.nextPlacementID++
+	p.ID = s.nextPlacementID
+	p.CreatedAt, p.UpdatedAt = now, now
+	stored := copyPlacement(p)
+	s.placements[p.ID] = &stored
+	return nil
+}
+
+func (s *MemStore) UpdatePlacement(ctx context.Context, p *models.Placement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.placements[p.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	p.CreatedBy, p.CreatedAt = stored.CreatedBy, stored.CreatedAt
+	p.UpdatedAt = time.Now()
+	*stored = copyPlacement(p)
+	return nil
+}
+
+func (s *MemStore) GetPlacementByID(ctx context.Context, id int) (*models.Placement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.placements[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := copyPlacement(p)
+	return &result, nil
+}
+
+func (s *MemStore) ListPlacements(ctx context.Context) ([]models.Placement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	placements := []models.Placement{}
+	for _, p := range s.placements {
+		placements = append(placements, copyPlacement(p))
+	}
+	sort.Slice(placements, func(i, j int) bool { return placements[i].ID < placements[j].ID })
+	return placements, nil
+}
+
+// --- Helper: Check if MemStore implements Store ---
+var _ Store = (*MemStore)(nil)
@@ -0,0 +1,387 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"advertisement/internal/auth"
+	"advertisement/internal/models"
+)
+
+// --- 组织、成员和邀请 ---
+
+// nullableInt 把可为 NULL 的整数列转换为指针
+func nullableInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	id := int(v.Int64)
+	return &id
+}
+
+// insertOrganization 在事务 tx 中创建组织并把 ownerID 加为 owner，成功后设置 org.ID
+func insertOrganization(ctx context.Context, tx *sql.Tx, org *models.Organization, ownerID int) error {
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO organizations (name, balance, created_at) VALUES (?, 0, ?)", org.Name, org.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to create organization: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get last insert ID for organization: %w", err)
+	}
+	org.ID = int(id)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO organization_members (organization_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		org.ID, ownerID, auth.OrgRoleOwner, org.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to add owner %d to organization %d: %w", ownerID, org.ID, err)
+	}
+	return nil
+}
+
+func (s *DBStore) CreateOrganization(ctx context.Context, org *models.Organization, ownerID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for organization: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertOrganization(ctx, tx, org, ownerID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit organization: %w", err)
+	}
+	log.Printf("store: 用户 %d 创建组织 %d (%s)", ownerID, org.ID, org.Name)
+	return nil
+}
+
+func (s *DBStore) GetOrganizationsByUserID(ctx context.Context, userID int) ([]models.OrganizationMembership, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT o.id, o.name, o.balance, o.created_at, m.role, u.default_organization_id
+        FROM organization_members m
+        JOIN organizations o ON m.organization_id = o.id
+        JOIN users u ON m.user_id = u.id
+        WHERE m.user_id = ?
+        ORDER BY o.id
+    `, userID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query organizations of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	memberships := []models.OrganizationMembership{}
+	for rows.Next() {
+		var m models.OrganizationMembership
+		var defaultOrg sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Name, &m.Balance, &m.CreatedAt, &m.Role, &defaultOrg); err != nil {
+			return nil, fmt.Errorf("store: failed to scan organization: %w", err)
+		}
+		m.IsDefault = defaultOrg.Valid && int(defaultOrg.Int64) == m.ID
+		memberships = append(memberships, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating organizations: %w", err)
+	}
+	return memberships, nil
+}
+
+const memberColumns = "m.organization_id, m.user_id, u.username, m.role, m.created_at"
+
+func scanMember(row interface{ Scan(...interface{}) error }) (*models.OrganizationMember, error) {
+	var m models.OrganizationMember
+	if err := row.Scan(&m.OrganizationID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *DBStore) GetOrganizationMember(ctx context.Context, orgID int, userID int) (*models.OrganizationMember, error) {
+	m, err := scanMember(s.db.QueryRowContext(ctx, `
+        SELECT `+memberColumns+`
+        FROM organization_members m
+        JOIN users u ON m.user_id = u.id
+        WHERE m.organization_id = ? AND m.user_id = ?
+    `, orgID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get member %d of organization %d: %w", userID, orgID, err)
+	}
+	return m, nil
+}
+
+func (s *DBStore) GetOrganizationMembers(ctx context.Context, orgID int) ([]models.OrganizationMember, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+memberColumns+`
+        FROM organization_members m
+        JOIN users u ON m.user_id = u.id
+        WHERE m.organization_id = ?
+        ORDER BY m.created_at, m.user_id
+    `, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query members of organization %d: %w", orgID, err)
+	}
+	defer rows.Close()
+
+	members := []models.OrganizationMember{}
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: failed to scan organization member: %w", err)
+		}
+		members = append(members, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating organization members: %w", err)
+	}
+	return members, nil
+}
+
+// lockMemberRole 在事务中锁定并读取成员角色；如果成员是 owner 且操作后不再是 owner，
+// 确认组织还有其他 owner，否则返回 ErrLastOwner
+func (s *DBStore) lockMemberRole(ctx context.Context, tx *sql.Tx, orgID, userID int, keepsOwner bool) error {
+	var role string
+	err := tx.QueryRowContext(ctx,
+		"SELECT role FROM organization_members WHERE organization_id = ? AND user_id = ?"+s.dialect.forUpdate,
+		orgID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("store: failed to read role of member %d in organization %d: %w", userID, orgID, err)
+	}
+	if role != auth.OrgRoleOwner || keepsOwner {
+		return nil
+	}
+	var owners int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM organization_members WHERE organization_id = ? AND role = ?"+s.dialect.forUpdate,
+		orgID, auth.OrgRoleOwner).Scan(&owners)
+	if err != nil {
+		return fmt.Errorf("store: failed to count owners of organization %d: %w", orgID, err)
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func (s *DBStore) UpdateOrganizationMemberRole(ctx context.Context, orgID int, userID int, role string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for member role: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.lockMemberRole(ctx, tx, orgID, userID, role == auth.OrgRoleOwner); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE organization_members SET role = ? WHERE organization_id = ? AND user_id = ?",
+		role, orgID, userID); err != nil {
+		return fmt.Errorf("store: failed to update role of member %d in organization %d: %w", userID, orgID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit member role: %w", err)
+	}
+	log.Printf("store: 组织 %d 成员 %d 角色更新为 %s", orgID, userID, role)
+	return nil
+}
+
+func (s *DBStore) RemoveOrganizationMember(ctx context.Context, orgID int, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for member removal: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.lockMemberRole(ctx, tx, orgID, userID, false); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM organization_members WHERE organization_id = ? AND user_id = ?", orgID, userID); err != nil {
+		return fmt.Errorf("store: failed to remove member %d from organization %d: %w", userID, orgID, err)
+	}
+	// 被移除的组织是默认组织时清空，之后需要通过 X-Organization-ID 指定组织
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET default_organization_id = NULL WHERE id = ? AND default_organization_id = ?",
+		userID, orgID); err != nil {
+		return fmt.Errorf("store: failed to clear default organization of user %d: %w", userID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit member removal: %w", err)
+	}
+	log.Printf("store: 用户 %d 已离开组织 %d", userID, orgID)
+	return nil
+}
+
+const invitationColumns = `i.id, i.organization_id, o.name, i.user_id, u.username, i.role, i.invited_by,
+            i.status, i.created_at, i.expires_at, i.responded_at`
+
+const invitationJoins = `
+        FROM organization_invitations i
+        JOIN organizations o ON i.organization_id = o.id
+        JOIN users u ON i.user_id = u.id`
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (*models.OrganizationInvitation, error) {
+	var inv models.OrganizationInvitation
+	var respondedAt sql.NullTime
+	if err := row.Scan(&inv.ID, &inv.OrganizationID, &inv.OrganizationName, &inv.UserID, &inv.Username,
+		&inv.Role, &inv.InvitedBy, &inv.Status, &inv.CreatedAt, &inv.ExpiresAt, &respondedAt); err != nil {
+		return nil, err
+	}
+	if respondedAt.Valid {
+		inv.RespondedAt = &respondedAt.Time
+	}
+	return &inv, nil
+}
+
+func (s *DBStore) queryInvitations(ctx context.Context, where string, args ...interface{}) ([]models.OrganizationInvitation, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+invitationColumns+invitationJoins+" WHERE "+where+" ORDER BY i.id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := []models.OrganizationInvitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: failed to scan invitation: %w", err)
+		}
+		invitations = append(invitations, *inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+func (s *DBStore) CreateOrganizationInvitation(ctx context.Context, inv *models.OrganizationInvitation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx,
+		"SELECT 1 FROM organization_members WHERE organization_id = ? AND user_id = ?",
+		inv.OrganizationID, inv.UserID).Scan(&exists)
+	if err == nil {
+		return ErrAlreadyMember
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("store: failed to check membership of user %d: %w", inv.UserID, err)
+	}
+
+	// 同一个用户只保留最新的一条待处理邀请
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE organization_invitations SET status = ?, responded_at = ?
+        WHERE organization_id = ? AND user_id = ? AND status = ?
+    `, models.InvitationStatusRevoked, inv.CreatedAt, inv.OrganizationID, inv.UserID, models.InvitationStatusPending); err != nil {
+		return fmt.Errorf("store: failed to revoke previous invitations: %w", err)
+	}
+
+	inv.Status = models.InvitationStatusPending
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO organization_invitations (organization_id, user_id, role, invited_by, status, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, inv.OrganizationID, inv.UserID, inv.Role, inv.InvitedBy, inv.Status, inv.CreatedAt, inv.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to create invitation: %w", err)
+	}
+	if inv.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("store: failed to get invitation id: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit invitation: %w", err)
+	}
+	log.Printf("store: 组织 %d 邀请用户 %d 成为 %s (邀请 %d)", inv.OrganizationID, inv.UserID, inv.Role, inv.ID)
+	return nil
+}
+
+func (s *DBStore) GetOrganizationInvitations(ctx context.Context, orgID int) ([]models.OrganizationInvitation, error) {
+	return s.queryInvitations(ctx, "i.organization_id = ? AND i.status = ?", orgID, models.InvitationStatusPending)
+}
+
+func (s *DBStore) GetInvitationsByUserID(ctx context.Context, userID int, now time.Time) ([]models.OrganizationInvitation, error) {
+	return s.queryInvitations(ctx, "i.user_id = ? AND i.status = ? AND i.expires_at > ?",
+		userID, models.InvitationStatusPending, now)
+}
+
+func (s *DBStore) RespondToInvitation(ctx context.Context, invitationID int64, userID int, accept bool, now time.Time) (*models.OrganizationInvitation, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to begin transaction for invitation %d: %w", invitationID, err)
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvitation(tx.QueryRowContext(ctx,
+		"SELECT "+invitationColumns+invitationJoins+" WHERE i.id = ? AND i.user_id = ? AND i.status = ?"+s.dialect.forUpdate,
+		invitationID, userID, models.InvitationStatusPending))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get invitation %d: %w", invitationID, err)
+	}
+	if !now.Before(inv.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+
+	inv.Status = models.InvitationStatusDeclined
+	if accept {
+		inv.Status = models.InvitationStatusAccepted
+	}
+	inv.RespondedAt = &now
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE organization_invitations SET status = ?, responded_at = ? WHERE id = ?",
+		inv.Status, now, inv.ID); err != nil {
+		return nil, fmt.Errorf("store: failed to update invitation %d: %w", inv.ID, err)
+	}
+
+	if accept {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO organization_members (organization_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+			inv.OrganizationID, userID, inv.Role, now)
+		if err != nil {
+			if s.dialect.isDuplicateEntry(err) {
+				return nil, ErrAlreadyMember
+			}
+			return nil, fmt.Errorf("store: failed to add member %d to organization %d: %w", userID, inv.OrganizationID, err)
+		}
+		// 没有默认组织 (离开了原来的默认组织) 时使用新加入的组织
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET default_organization_id = ? WHERE id = ? AND default_organization_id IS NULL",
+			inv.OrganizationID, userID); err != nil {
+			return nil, fmt.Errorf("store: failed to set default organization of user %d: %w", userID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("store: failed to commit invitation %d: %w", inv.ID, err)
+	}
+	log.Printf("store: 用户 %d %s 组织 %d 的邀请 %d", userID, inv.Status, inv.OrganizationID, inv.ID)
+	return inv, nil
+}
+
+func (s *DBStore) RevokeInvitation(ctx context.Context, invitationID int64, orgID int, now time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE organization_invitations SET status = ?, responded_at = ?
+        WHERE id = ? AND organization_id = ? AND status = ?
+    `, models.InvitationStatusRevoked, now, invitationID, orgID, models.InvitationStatusPending)
+	if err != nil {
+		return fmt.Errorf("store: failed to revoke invitation %d: %w", invitationID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
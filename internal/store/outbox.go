@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 邮件发件箱 ---
+// 业务操作在自己的事务中调用 insertOutboxMessage 写入邮件，后台投递器 (mail.Dispatcher)
+// 通过 ClaimOutboxMessages 领取到期的邮件并发送，这样邮件不会因为事务回滚而误发，也不会因为发送失败而丢失。
+
+const outboxColumns = "id, recipient, subject, body, status, attempts, last_error, created_at, next_attempt_at, sent_at"
+
+// maxOutboxErrorLength email_outbox.last_error 的长度 (按字符)
+const maxOutboxErrorLength = 1000
+
+func scanOutboxMessage(row interface{ Scan(...interface{}) error }) (*models.OutboxMessage, error) {
+	var m models.OutboxMessage
+	var lastError sql.NullString
+	var sentAt sql.NullTime
+	if err := row.Scan(&m.ID, &m.Recipient, &m.Subject, &m.Body, &m.Status, &m.Attempts, &lastError,
+		&m.CreatedAt, &m.NextAttemptAt, &sentAt); err != nil {
+		return nil, err
+	}
+	m.LastError = lastError.String
+	if sentAt.Valid {
+		m.SentAt = &sentAt.Time
+	}
+	return &m, nil
+}
+
+// insertOutboxMessage 在事务中写入一封待发送的邮件，立即可以发送
+func insertOutboxMessage(ctx context.Context, tx *sql.Tx, msg *models.OutboxMessage) error {
+	msg.Status = models.OutboxStatusPending
+	msg.NextAttemptAt = msg.CreatedAt
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO email_outbox (recipient, subject, body, status, created_at, next_attempt_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, msg.Recipient, msg.Subject, msg.Body, msg.Status, msg.CreatedAt, msg.NextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to enqueue email: %w", err)
+	}
+	msg.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get outbox message id: %w", err)
+	}
+	return nil
+}
+
+// truncateError 把错误信息截断到 maxOutboxErrorLength 个字符
+func truncateError(msg string) string {
+	runes := []rune(msg)
+	if len(runes) <= maxOutboxErrorLength {
+		return msg
+	}
+	return string(runes[:maxOutboxErrorLength])
+}
+
+func (s *DBStore) ClaimOutboxMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to begin transaction for outbox: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+outboxColumns+` FROM email_outbox
+        WHERE status = ? AND next_attempt_at <= ?
+        ORDER BY next_attempt_at, id LIMIT ?`+s.dialect.forUpdate,
+		models.OutboxStatusPending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query outbox: %w", err)
+	}
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		m, err := scanOutboxMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("store: failed to scan outbox message: %w", err)
+		}
+		messages = append(messages, *m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating outbox: %w", err)
+	}
+
+	leaseUntil := now.Add(lease)
+	for i := range messages {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE email_outbox SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ?",
+			leaseUntil, messages[i].ID); err != nil {
+			return nil, fmt.Errorf("store: failed to claim outbox message %d: %w", messages[i].ID, err)
+		}
+		messages[i].Attempts++
+		messages[i].NextAttemptAt = leaseUntil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("store: failed to commit outbox claim: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *DBStore) MarkOutboxMessageSent(ctx context.Context, id int64, now time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE email_outbox SET status = ?, sent_at = ?, last_error = NULL WHERE id = ? AND status = ?",
+		models.OutboxStatusSent, now, id, models.OutboxStatusPending)
+	if err != nil {
+		return fmt.Errorf("store: failed to mark outbox message %d as sent: %w", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBStore) MarkOutboxMessageFailed(ctx context.Context, id int64, lastErr string, retryAt *time.Time) error {
+	var result sql.Result
+	var err error
+	if retryAt == nil {
+		result, err = s.db.ExecContext(ctx,
+			"UPDATE email_outbox SET status = ?, last_error = ? WHERE id = ? AND status = ?",
+			models.OutboxStatusFailed, truncateError(lastErr), id, models.OutboxStatusPending)
+	} else {
+		result, err = s.db.ExecContext(ctx,
+			"UPDATE email_outbox SET next_attempt_at = ?, last_error = ? WHERE id = ? AND status = ?",
+			*retryAt, truncateError(lastErr), id, models.OutboxStatusPending)
+	}
+	if err != nil {
+		return fmt.Errorf("store: failed to record failure of outbox message %d: %w", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 支付渠道相关的充值记录操作 ---
+
+func (s *DBStore) SetRechargeProviderRef(ctx context.Context, rechargeRecordID int64, providerRef string) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE recharge_transactions SET provider_ref = ?, updated_at = ? WHERE id = ?",
+		providerRef, time.Now(), rechargeRecordID)
+	if err != nil {
+		return fmt.Errorf("store: failed to set provider ref of recharge transaction %d: %w", rechargeRecordID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBStore) GetRechargeTransactionByID(ctx context.Context, rechargeRecordID int64) (*models.RechargeTransaction, error) {
+	query := `
+        SELECT id, user_id, organization_id, amount, status, transaction_id, payment_method, provider_ref, created_at, updated_at
+        FROM recharge_transactions
+        WHERE id = ?
+    `
+	var rec models.RechargeTransaction
+	var txID, providerRef sql.NullString
+	err := s.db.QueryRowContext(ctx, query, rechargeRecordID).Scan(
+		&rec.ID, &rec.UserID, &rec.OrganizationID, &rec.Amount, &rec.Status, &txID, &rec.PaymentMethod, &providerRef, &rec.CreatedAt, &rec.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get recharge transaction %d: %w", rechargeRecordID, err)
+	}
+	if txID.Valid {
+		rec.TransactionID = &txID.String
+	}
+	if providerRef.Valid {
+		rec.ProviderRef = &providerRef.String
+	}
+	return &rec, nil
+}
+
+func (s *DBStore) FailPendingRecharge(ctx context.Context, rechargeRecordID int64, providerTxID string) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE recharge_transactions
+        SET status = 'Failed', transaction_id = ?, updated_at = ?
+        WHERE id = ? AND status = 'Pending'
+    `, nullableString(providerTxID), time.Now(), rechargeRecordID)
+	if err != nil {
+		return fmt.Errorf("store: failed to mark recharge transaction %d as failed: %w", rechargeRecordID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrRechargeNotPending
+	}
+	log.Printf("store: 充值记录 %d 支付失败 (TxID: %s)", rechargeRecordID, providerTxID)
+	return nil
+}
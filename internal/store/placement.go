@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 广告位 ---
+
+const placementColumns = "id, name, width, height, formats, floor_price, status, created_by, created_at, updated_at"
+
+func scanPlacement(row interface{ Scan(...interface{}) error }) (*models.Placement, error) {
+	var p models.Placement
+	var formats string
+	var createdBy sql.NullInt64
+	if err := row.Scan(&p.ID, &p.Name, &p.Width, &p.Height, &formats, &p.FloorPrice, &p.Status,
+		&createdBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.Formats = splitFormats(formats)
+	p.CreatedBy = nullableInt(createdBy)
+	return &p, nil
+}
+
+// splitFormats 把 placements.formats 列 (逗号分隔) 转换为切片
+func splitFormats(s string) []string {
+	formats := []string{}
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+func (s *DBStore) CreatePlacement(ctx context.Context, p *models.Placement) error {
+	now := time.Now()
+	var createdBy sql.NullInt64
+	if p.CreatedBy != nil {
+		createdBy = sql.NullInt64{Int64: int64(*p.CreatedBy), Valid: true}
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO placements (name, width, height, formats, floor_price, status, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Name, p.Width, p.Height, strings.Join(p.Formats, ","), p.FloorPrice, p.Status, createdBy, now, now)
+	if err != nil {
+		return fmt.Errorf("store: failed to create placement: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get last insert ID for placement: %w", err)
+	}
+	p.ID = int(id)
+	p.CreatedAt, p.UpdatedAt = now, now
+	return nil
+}
+
+func (s *DBStore) UpdatePlacement(ctx context.Context, p *models.Placement) error {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE placements SET name = ?, width = ?, height = ?, formats = ?, floor_price = ?, status = ?, updated_at = ?
+		WHERE id = ?`,
+		p.Name, p.Width, p.Height, strings.Join(p.Formats, ","), p.FloorPrice, p.Status, now, p.ID)
+	if err != nil {
+		return fmt.Errorf("store: failed to update placement %d: %w", p.ID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		// MySQL 在值未变化时也返回 0，确认广告位是否存在
+		if _, err := s.GetPlacementByID(ctx, p.ID); err != nil {
+			return err
+		}
+	}
+	p.UpdatedAt = now
+	return nil
+}
+
+func (s *DBStore) GetPlacementByID(ctx context.Context, id int) (*models.Placement, error) {
+	p, err := scanPlacement(s.db.QueryRowContext(ctx, "SELECT "+placementColumns+" FROM placements WHERE id = ?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get placement %d: %w", id, err)
+	}
+	return p, nil
+}
+
+func (s *DBStore) ListPlacements(ctx context.Context) ([]models.Placement, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+placementColumns+" FROM placements ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query placements: %w", err)
+	}
+	defer rows.Close()
+
+	placements := []models.Placement{}
+	for rows.Next() {
+		p, err := scanPlacement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: failed to scan placement: %w", err)
+		}
+		placements = append(placements, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating placements: %w", err)
+	}
+	return placements, nil
+}
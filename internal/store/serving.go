@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 广告投放索引 ---
+
+func (s *DBStore) ListServableAds(ctx context.Context, now time.Time) ([]models.ServableAd, error) {
+	// 条件与 GetRandomActiveCampaign 相同，只是返回全部结果且不排序
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT camp.id, camp.advertisement_id, camp.user_id, camp.organization_id, camp.start_date, camp.end_date,
+            camp.pricing_model, camp.bid_amount, adv.title, adv.image_url, adv.target_url, adv.width, adv.height, adv.format,
+            camp.targeting, camp.frequency_caps, camp.impressions, camp.clicks
+        FROM ad_campaigns camp
+        JOIN advertisements adv ON camp.advertisement_id = adv.id
+        JOIN organizations o ON camp.organization_id = o.id
+        WHERE camp.status = 'Active'
+          AND camp.start_date <= ?
+          AND camp.end_date >= ?`+servableCondition, now, dateOf(now), dateOf(now))
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to query servable ads: %w", err)
+	}
+	defer rows.Close()
+
+	ads := []models.ServableAd{}
+	for rows.Next() {
+		var ad models.ServableAd
+		if err := rows.Scan(&ad.CampaignID, &ad.AdvertisementID, &ad.UserID, &ad.OrganizationID, &ad.StartDate, &ad.EndDate,
+			&ad.PricingModel, &ad.BidAmount, &ad.Title, &ad.ImageURL, &ad.TargetURL, &ad.Width, &ad.Height, &ad.Format,
+			&ad.Targeting, &ad.FrequencyCaps, &ad.Impressions, &ad.Clicks); err != nil {
+			return nil, fmt.Errorf("store: failed to scan servable ad: %w", err)
+		}
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating servable ads: %w", err)
+	}
+	return ads, nil
+}
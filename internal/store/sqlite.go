@@ -0,0 +1,70 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite" // 纯 Go 实现的 SQLite 驱动，不需要 cgo
+)
+
+// --- SQLiteStore 是 Store 接口的 SQLite 实现 ---
+// 它复用 DBStore 的全部 SQL，只替换其中与 MySQL 相关的部分 (见 dialect.go)：
+//   - CURDATE() / NOW()   -> 由 Go 传入当前时间
+//   - ORDER BY RAND()     -> ORDER BY RANDOM()
+//   - "Duplicate entry"   -> "UNIQUE constraint failed"
+//
+// 适合开发、CI，以及不需要 MySQL 的单机部署。
+type SQLiteStore struct {
+	*DBStore
+}
+
+// NewSQLiteStore 用已打开的 SQLite 连接创建 SQLiteStore
+// 连接应通过 OpenSQLite 打开，以确保外键、时间格式等设置正确。
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{DBStore: &DBStore{db: db, dialect: sqliteDialect}}
+}
+
+// OpenSQLite 打开 (或创建) 一个 SQLite 数据库。
+// path 可以是文件路径，也可以是 ":memory:" (内存数据库，进程退出后数据丢失)。
+//
+// SQLite 以文本形式保存时间，比较时也是按文本比较，
+// 这里统一使用 _time_format=sqlite ("2006-01-02 15:04:05.999999999-07:00")，
+// 因此服务进程应使用固定的时区运行 (推荐 UTC)。
+func OpenSQLite(path string) (*sql.DB, error) {
+	memory := path == ":memory:"
+
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	if !memory {
+		params.Add("_pragma", "journal_mode(WAL)")
+	}
+	params.Set("_time_format", "sqlite")
+
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + params.Encode()
+	} else {
+		dsn += "?" + params.Encode()
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("store: failed to open sqlite database %s: %w", path, err)
+	}
+	// SQLite 同一时间只允许一个写入者；
+	// 对 :memory: 来说，每个连接都是一个独立的数据库，所以必须只保留一个连接。
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: failed to connect to sqlite database %s: %w", path, err)
+	}
+	log.Printf("store: SQLite 数据库已打开: %s", path)
+	return db, nil
+}
+
+// --- Helper: Check if SQLiteStore implements Store ---
+var _ Store = (*SQLiteStore)(nil)
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	// 需要导入 models 包
+	"advertisement/internal/ledger"
 	"advertisement/internal/models" // 替换 "your_module_name"
 	// 导入 mysql 驱动可能需要用于错误类型断言 (可选，如果需要特定错误处理)
 	// "github.com/go-sql-driver/mysql"
@@ -20,6 +21,30 @@ import (
 var (
 	ErrNotFound      = errors.New("store: resource not found")
 	ErrDuplicateUser = errors.New("store: username already exists")
+	// ErrDuplicateEmail 邮箱已被其他用户使用
+	ErrDuplicateEmail = errors.New("store: email already exists")
+	// ErrTokenExpired 邮箱验证 / 重置密码令牌已过期
+	ErrTokenExpired = errors.New("store: token has expired")
+	// ErrRechargeNotPending 充值记录不存在或已经不是 Pending (重复回调等情况)
+	ErrRechargeNotPending = errors.New("store: recharge transaction is not pending")
+	// ErrRefreshTokenReused 已被轮换或吊销的刷新令牌再次被使用 (可能已泄露)，所在会话已被整体吊销
+	ErrRefreshTokenReused = errors.New("store: refresh token has already been used or revoked")
+	// ErrRefreshTokenExpired 刷新令牌已过期
+	ErrRefreshTokenExpired = errors.New("store: refresh token has expired")
+	// ErrDuplicateAPIKeyPrefix API Key 前缀冲突 (概率极低，重新生成即可)
+	ErrDuplicateAPIKeyPrefix = errors.New("store: api key prefix already exists")
+	// ErrInvoiceAlreadyProcessed 发票请求已经是终态 (Completed / Failed)，不能再修改
+	ErrInvoiceAlreadyProcessed = errors.New("store: invoice request has already been processed")
+	// ErrLastOwner 操作会让组织没有 owner (降级或移除最后一个 owner)
+	ErrLastOwner = errors.New("store: organization must keep at least one owner")
+	// ErrAlreadyMember 被邀请的用户已经是组织成员
+	ErrAlreadyMember = errors.New("store: user is already a member of the organization")
+	// ErrInvitationExpired 邀请已过期
+	ErrInvitationExpired = errors.New("store: invitation has expired")
+	// ErrTOTPAlreadyEnabled 用户已经启用了两步验证 (需要先关闭才能重新绑定)
+	ErrTOTPAlreadyEnabled = errors.New("store: totp is already enabled")
+	// ErrOTPReused 验证码所在的时间步已经被使用过 (重放)
+	ErrOTPReused = errors.New("store: one-time password has already been used")
 	// 可以添加更多自定义错误...
 )
 
@@ -27,19 +52,168 @@ var (
 // --- Store 接口定义了所有数据库操作 ---
 type Store interface {
 	// 用户相关
-	CreateUser(ctx context.Context, username string, passwordHash string) error
+	// CreateUser 创建用户，同时创建以用户名命名的个人组织 (用户为 owner，并设为默认组织)。
+	// 成功后设置 user.ID / Role / DefaultOrganizationID；用户名重复返回 ErrDuplicateUser，邮箱重复返回 ErrDuplicateEmail
+	CreateUser(ctx context.Context, user *models.User) error
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
-
-	// 广告相关
-	CreateAdvertisement(ctx context.Context, ad *models.Advertisement) (int64, error)
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	// GetUserByEmail 按邮箱查找用户 (邮箱已统一为小写)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	// UpdateUserRole 修改用户角色，用户不存在返回 ErrNotFound
+	UpdateUserRole(ctx context.Context, userID int, role string) error
+
+	// --- 后台用户管理 ---
+	// ListUsers 按条件分页列出用户 (按 ID 倒序)
+	ListUsers(ctx context.Context, filter models.AdminUserFilter) ([]models.User, error)
+	// SetUserSuspension 停用 (suspendedAt 不为 nil) 或恢复 (suspendedAt 为 nil，同时清除原因) 账号，用户不存在返回 ErrNotFound
+	SetUserSuspension(ctx context.Context, userID int, suspendedAt *time.Time, reason *string) error
+	// GetAdvertisementsByUserID 获取用户创建的广告创意 (所有组织)，按 ID 倒序
 	GetAdvertisementsByUserID(ctx context.Context, userID int) ([]models.Advertisement, error)
+	// GetAdCampaignsByUserID 获取用户创建的广告活动 (所有组织)，包含广告创意信息
+	GetAdCampaignsByUserID(ctx context.Context, userID int) ([]models.CampaignWithAdDetails, error)
+	// GetRechargesByUserID 获取用户发起的充值记录 (所有组织)
+	GetRechargesByUserID(ctx context.Context, userID int) ([]models.RechargeTransaction, error)
+
+	// --- 刷新令牌和访问令牌吊销 ---
+	// CreateRefreshToken 保存登录时签发的刷新令牌，成功后设置 t.ID
+	CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	// GetRefreshTokenByHash 按哈希查找刷新令牌 (包括已吊销和已过期的)
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// RotateRefreshToken 在一个事务中吊销 oldHash 对应的令牌并保存 next (沿用旧令牌的用户和会话)。
+	// 旧令牌已被吊销时吊销整个会话并返回 ErrRefreshTokenReused；已过期返回 ErrRefreshTokenExpired
+	RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken, now time.Time) error
+	// RevokeSessionByAccessJTI 登出：吊销访问令牌 jti 以及与它一起签发的刷新令牌
+	RevokeSessionByAccessJTI(ctx context.Context, userID int, jti string, accessExpiresAt time.Time, now time.Time) error
+	// RevokeAllSessions 登出所有会话：吊销用户所有有效的刷新令牌及其访问令牌，返回吊销的会话数
+	RevokeAllSessions(ctx context.Context, userID int, now time.Time) (int, error)
+	// IsTokenRevoked 检查访问令牌 jti 是否在吊销列表中
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	// PurgeExpiredTokens 删除已过期的刷新令牌、吊销记录、一次性令牌、登录挑战和登录失败记录，返回删除的行数
+	PurgeExpiredTokens(ctx context.Context, now time.Time) (int64, error)
+
+	// --- 两步验证 ---
+	// SetTOTPSecret 保存待确认的 TOTP 密钥 (覆盖之前未确认的密钥)；已启用返回 ErrTOTPAlreadyEnabled
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	// EnableTOTP 用验证码确认绑定：启用两步验证，记录已使用的时间步，并用 recoveryHashes 替换全部恢复码。
+	// 已启用返回 ErrTOTPAlreadyEnabled；没有待确认的密钥返回 ErrNotFound
+	EnableTOTP(ctx context.Context, userID int, step int64, recoveryHashes []string, now time.Time) error
+	// DisableTOTP 关闭两步验证：清除密钥并删除全部恢复码，用户不存在返回 ErrNotFound
+	DisableTOTP(ctx context.Context, userID int) error
+	// UseTOTPStep 记录验证码使用的时间步；不大于上次使用的时间步返回 ErrOTPReused
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	// UseRecoveryCode 消费一个恢复码，不存在或已使用返回 ErrNotFound
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error
+	// ReplaceRecoveryCodes 删除用户的全部恢复码并保存新的恢复码
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, now time.Time) error
+	// CountUnusedRecoveryCodes 返回用户未使用的恢复码数量
+	CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error)
+	// SetTwoFactorRequired 修改账号的"必须使用两步验证"设置，用户不存在返回 ErrNotFound
+	SetTwoFactorRequired(ctx context.Context, userID int, required bool) error
+	// CreateLoginChallenge 保存两步登录的挑战，成功后设置 c.ID
+	CreateLoginChallenge(ctx context.Context, c *models.LoginChallenge) error
+	// GetLoginChallenge 按令牌哈希查找登录挑战 (包括已使用和已过期的)
+	GetLoginChallenge(ctx context.Context, tokenHash string) (*models.LoginChallenge, error)
+	// FailLoginChallenge 记录一次验证码错误，返回累计错误次数；达到 maxAttempts 时挑战作废
+	FailLoginChallenge(ctx context.Context, id int64, maxAttempts int, now time.Time) (int, error)
+	// CompleteLoginChallenge 把挑战标记为已使用；已被使用或作废返回 ErrNotFound (防止并发重复换取令牌)
+	CompleteLoginChallenge(ctx context.Context, id int64, now time.Time) error
+
+	// --- 登录限流 (login_throttle.backend=database 时使用，见 throttle.Counter) ---
+	// GetLoginAttempt 返回 key 的登录失败记录 (可能已过期)，没有记录返回 ErrNotFound
+	GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordLoginFailure 原子地把 key 的失败次数加 1 (记录已过期时从 1 重新计数)，
+	// 过期时间至少延长到 now + window，返回更新后的记录
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	// LockLogin 把 key 锁定到 until (过期时间随之延长)，没有记录返回 ErrNotFound
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	// ResetLoginAttempts 删除 key 的失败记录 (登录成功或管理员解锁)，没有记录时不报错
+	ResetLoginAttempts(ctx context.Context, key string) error
+
+	// --- 审计日志 (只追加，没有修改和删除的方法) ---
+	// AppendAuditEntry 写入一条审计记录，成功后设置 e.ID；e.CreatedAt 为零值时使用当前时间
+	AppendAuditEntry(ctx context.Context, e *models.AuditEntry) error
+	// ListAuditEntries 按条件分页查询审计记录 (按 ID 倒序)
+	ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+
+	// --- 广告位 ---
+	// CreatePlacement 创建广告位，成功后设置 p.ID 和时间
+	CreatePlacement(ctx context.Context, p *models.Placement) error
+	// UpdatePlacement 修改广告位的名称、尺寸、格式、底价和状态，不存在返回 ErrNotFound
+	UpdatePlacement(ctx context.Context, p *models.Placement) error
+	// GetPlacementByID 获取广告位，不存在返回 ErrNotFound
+	GetPlacementByID(ctx context.Context, id int) (*models.Placement, error)
+	// ListPlacements 返回所有广告位 (按 ID 排序)
+	ListPlacements(ctx context.Context) ([]models.Placement, error)
+
+	// --- 邮箱验证 / 找回密码 ---
+	// CreateUserToken 在一个事务中作废用户同用途的未使用令牌、保存新令牌 (成功后设置 t.ID)，
+	// 并把通知邮件写入发件箱 (成功后设置 msg.ID)
+	CreateUserToken(ctx context.Context, t *models.UserToken, msg *models.OutboxMessage) error
+	// VerifyEmail 使用邮箱验证令牌，把用户邮箱标记为已验证，返回更新后的用户。
+	// 令牌不存在、已使用或用户邮箱已变更返回 ErrNotFound，已过期返回 ErrTokenExpired
+	VerifyEmail(ctx context.Context, tokenHash string, now time.Time) (*models.User, error)
+	// ResetPassword 使用重置密码令牌修改密码 (同时视为验证了邮箱)，返回更新后的用户，错误同 VerifyEmail
+	ResetPassword(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (*models.User, error)
+
+	// --- 邮件发件箱 ---
+	// ClaimOutboxMessages 领取最多 limit 封到期的待发送邮件：attempts 加 1，并把下次尝试时间推迟 lease，
+	// 避免多个实例同时发送同一封邮件 (进程在发送中途退出时，租约到期后会重新发送)
+	ClaimOutboxMessages(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.OutboxMessage, error)
+	// MarkOutboxMessageSent 把邮件标记为已发送
+	MarkOutboxMessageSent(ctx context.Context, id int64, now time.Time) error
+	// MarkOutboxMessageFailed 记录发送失败的原因；retryAt 为 nil 表示不再重试 (状态变为 Failed)
+	MarkOutboxMessageFailed(ctx context.Context, id int64, lastErr string, retryAt *time.Time) error
+
+	// --- API Key ---
+	// CreateAPIKey 保存新的 API Key，成功后设置 key.ID；前缀冲突返回 ErrDuplicateAPIKeyPrefix
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// GetAPIKeysByUserID 返回用户的所有 API Key (包括已吊销和已过期的)，按创建时间倒序
+	GetAPIKeysByUserID(ctx context.Context, userID int) ([]models.APIKey, error)
+	// GetAPIKeyByPrefix 认证时按前缀查找 API Key
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// RevokeAPIKey 吊销用户自己的 API Key，不存在、不属于该用户或已吊销时返回 ErrNotFound
+	RevokeAPIKey(ctx context.Context, keyID int64, userID int, now time.Time) error
+	// TouchAPIKey 更新 API Key 的最后使用时间
+	TouchAPIKey(ctx context.Context, keyID int64, now time.Time) error
+
+	// --- 组织 ---
+	// CreateOrganization 创建组织，ownerID 成为第一个 owner，成功后设置 org.ID
+	CreateOrganization(ctx context.Context, org *models.Organization, ownerID int) error
+	// GetOrganizationsByUserID 返回用户所属的组织及其角色
+	GetOrganizationsByUserID(ctx context.Context, userID int) ([]models.OrganizationMembership, error)
+	// GetOrganizationMember 返回用户在组织中的成员信息，不是成员返回 ErrNotFound
+	GetOrganizationMember(ctx context.Context, orgID int, userID int) (*models.OrganizationMember, error)
+	// GetOrganizationMembers 返回组织的所有成员
+	GetOrganizationMembers(ctx context.Context, orgID int) ([]models.OrganizationMember, error)
+	// UpdateOrganizationMemberRole 修改成员角色，不是成员返回 ErrNotFound，降级最后一个 owner 返回 ErrLastOwner
+	UpdateOrganizationMemberRole(ctx context.Context, orgID int, userID int, role string) error
+	// RemoveOrganizationMember 移除成员，不是成员返回 ErrNotFound，移除最后一个 owner 返回 ErrLastOwner
+	RemoveOrganizationMember(ctx context.Context, orgID int, userID int) error
+	// CreateOrganizationInvitation 创建邀请，成功后设置 inv.ID；用户已是成员返回 ErrAlreadyMember
+	CreateOrganizationInvitation(ctx context.Context, inv *models.OrganizationInvitation) error
+	// GetOrganizationInvitations 返回组织待处理 (Pending) 的邀请
+	GetOrganizationInvitations(ctx context.Context, orgID int) ([]models.OrganizationInvitation, error)
+	// GetInvitationsByUserID 返回发给用户、待处理且未过期的邀请
+	GetInvitationsByUserID(ctx context.Context, userID int, now time.Time) ([]models.OrganizationInvitation, error)
+	// RespondToInvitation 接受或拒绝发给 userID 的邀请，接受时加入组织。
+	// 邀请不存在、不是发给该用户或已处理返回 ErrNotFound，已过期返回 ErrInvitationExpired
+	RespondToInvitation(ctx context.Context, invitationID int64, userID int, accept bool, now time.Time) (*models.OrganizationInvitation, error)
+	// RevokeInvitation 撤销组织的待处理邀请，不存在或已处理返回 ErrNotFound
+	RevokeInvitation(ctx context.Context, invitationID int64, orgID int, now time.Time) error
+
+	// 广告相关 (广告创意、广告活动、充值、发票和余额都归属组织，user_id 只记录创建者)
+	CreateAdvertisement(ctx context.Context, ad *models.Advertisement) (int64, error)
+	// GetAdvertisementsByOrganization 获取组织的所有广告创意
+	GetAdvertisementsByOrganization(ctx context.Context, orgID int) ([]models.Advertisement, error)
 	// GetRandomApprovedAd(ctx context.Context) (*models.Advertisement, error)
 	UpdateAdvertisementStatus(ctx context.Context, adID int, status string) error // <-- 新增接口方法
     GetAdvertisementByID(ctx context.Context, adID int) (*models.Advertisement, error) // <-- (可选但有用) 增加一个按ID获取广告的方法，供更新前检查
 
 	// --- 新增广告活动相关方法 ---
 	CreateAdCampaign(ctx context.Context, campaign *models.AdCampaign) (int64, error)
-	UpdateAdCampaignStatus(ctx context.Context, campaignID int, status string) error
+	// UpdateAdCampaignStatus 管理员 (或系统) 修改活动状态，changedBy 为操作人 ID (0 表示系统)
+	// 不允许的状态转换返回 ErrInvalidTransition
+	UpdateAdCampaignStatus(ctx context.Context, campaignID int, status string, changedBy int) error
 	GetAdCampaignByID(ctx context.Context, campaignID int) (*models.AdCampaign, error)
     GetPendingAdvertisements(ctx context.Context) ([]models.Advertisement, error)
     GetPendingCampaigns(ctx context.Context) ([]models.AdCampaign, error)
@@ -50,97 +224,210 @@ type Store interface {
 	// --- 新增充值和余额相关方法 ---
 	// CreateRechargeTransaction 在数据库中创建一条新的充值记录 (初始状态 Pending)
     // 返回新创建记录的 ID
-	CreateRechargeTransaction(ctx context.Context, userID int, amountInCents int64, paymentMethod string) (int64, error)
+	// userID 是发起充值的成员，余额记入组织 orgID
+	CreateRechargeTransaction(ctx context.Context, orgID int, userID int, amountInCents int64, paymentMethod string) (int64, error)
 
-    // ProcessSuccessfulRecharge 原子性地增加用户余额并更新充值记录状态为 Success
+    // ProcessSuccessfulRecharge 原子性地增加组织余额并更新充值记录状态为 Success
     // 这个方法必须在数据库事务中执行所需操作
-    ProcessSuccessfulRecharge(ctx context.Context, userID int, amountInCents int64, rechargeRecordID int64, simulatedTxID string) error
+    ProcessSuccessfulRecharge(ctx context.Context, rec *models.RechargeTransaction, simulatedTxID string) error
 
     // UpdateRechargeTransactionStatus 更新特定充值记录的状态和模拟交易ID (例如更新为 Failed)
     UpdateRechargeTransactionStatus(ctx context.Context, rechargeRecordID int64, status string, simulatedTxID string) error
 
-    // GetUserBalance 获取指定用户的余额 (单位：分)
-	GetUserBalance(ctx context.Context, userID int) (int64, error)
+    // SetRechargeProviderRef 记录充值对应的支付渠道支付单号
+    SetRechargeProviderRef(ctx context.Context, rechargeRecordID int64, providerRef string) error
+    // GetRechargeTransactionByID 获取单条充值记录 (支付回调使用，不校验用户)
+    GetRechargeTransactionByID(ctx context.Context, rechargeRecordID int64) (*models.RechargeTransaction, error)
+    // FailPendingRecharge 把 Pending 的充值记录标记为 Failed，记录已不是 Pending 时返回 ErrRechargeNotPending
+    FailPendingRecharge(ctx context.Context, rechargeRecordID int64, providerTxID string) error
+
+    // --- 幂等键 (Idempotency-Key) ---
+    ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time) (*models.IdempotencyRecord, error)
+    CompleteIdempotencyKey(ctx context.Context, userID int, key string, statusCode int, body []byte) error
+    ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+
+    // GetOrganizationBalance 获取指定组织的余额 (单位：分)
+	GetOrganizationBalance(ctx context.Context, orgID int) (int64, error)
 
-	GetUserRechargeHistory(ctx context.Context, userID int, filters models.RechargeHistoryFilters) ([]models.RechargeTransaction, error)
+	GetRechargeHistoryByOrganization(ctx context.Context, orgID int, filters models.RechargeHistoryFilters) ([]models.RechargeTransaction, error)
 
  	// --- 广告活动管理 ---
-    // GetAdCampaignsByUserID 获取指定用户的广告活动列表，支持过滤，并包含广告创意信息
-    GetAdCampaignsByUserID(ctx context.Context, userID int, filters models.CampaignFilters) ([]models.CampaignWithAdDetails, error)
+    // GetAdCampaignsByOrganization 获取组织的广告活动列表，支持过滤，并包含广告创意信息
+    GetAdCampaignsByOrganization(ctx context.Context, orgID int, filters models.CampaignFilters) ([]models.CampaignWithAdDetails, error)
 
-    // GetAdCampaignByIDAndUser 获取用户拥有的单个广告活动的详细信息 (包含广告创意信息)
-    GetAdCampaignByIDAndUser(ctx context.Context, campaignID int, userID int) (*models.CampaignWithAdDetails, error)
+    // GetAdCampaignByIDAndOrganization 获取组织拥有的单个广告活动的详细信息 (包含广告创意信息)
+    GetAdCampaignByIDAndOrganization(ctx context.Context, campaignID int, orgID int) (*models.CampaignWithAdDetails, error)
 
-    // UpdateAdCampaignStatusByUser 用户更新自己广告活动的状态 (例如取消)
-    // 返回错误如果活动不属于该用户，或状态不允许更改
-    UpdateAdCampaignStatusByUser(ctx context.Context, campaignID int, userID int, newStatus string) error
+    // UpdateAdCampaignStatusByOrganization 组织成员更新组织广告活动的状态 (取消 / 暂停 / 恢复)，changedBy 为操作的成员
+    // 活动不属于该组织返回 ErrNotFound，状态不允许更改返回 ErrInvalidTransition
+    UpdateAdCampaignStatusByOrganization(ctx context.Context, campaignID int, orgID int, changedBy int, newStatus string) error
 
-    // GetAdCampaignByID(ctx context.Context, campaignID int) (*models.AdCampaign, error) // 可能仍然需要给管理员用
-    // UpdateAdCampaignStatus(ctx context.Context, campaignID int, status string) error // 管理员更新状态的方法
+    // --- 广告活动生命周期 (调度器使用) ---
+    // ActivateDueCampaigns 把到达开始日期的 Approved 活动转为 Active，返回被激活的活动 ID
+    ActivateDueCampaigns(ctx context.Context, now time.Time) ([]int, error)
+    // CompleteExpiredCampaigns 把已过结束日期的活动转为 Completed，返回被完成的活动 ID
+    CompleteExpiredCampaigns(ctx context.Context, now time.Time) ([]int, error)
+    // GetCampaignStatusHistory 返回活动的状态变更历史 (按时间顺序)
+    GetCampaignStatusHistory(ctx context.Context, campaignID int) ([]models.CampaignStatusChange, error)
 
 
 	 // --- 广告事件与效果 ---
     // LogAdEvent 记录一个广告事件 (Impression 或 Click)
     LogAdEvent(ctx context.Context, event models.AdEvent) error
 
-    // GetAdPerformanceSummary 查询广告效果汇总数据
-    GetAdPerformanceSummary(ctx context.Context, userID int, filters models.AdPerformanceFilter) ([]models.AdPerformanceSummary, error)
+    // ChargeAdEvent 按活动的计费方式原子地扣减广告主余额、累加活动消耗和展示 / 点击计数并记录事件 (event.Cost 为实际扣费)。
+    // CPM 活动的展示、CPC 活动的点击带有 event.ClearingPrice (竞价成交价) 时按成交价计费，不超过出价。
+    // 预算用完返回 ErrBudgetExhausted，余额不足返回 ErrInsufficientBalance，此时不记录事件。
+    // 展示和点击都会确认活动仍可投放 (Active、在投放日期内、创建者未停用)，否则返回 ErrCampaignNotServable。
+    // 带 event.ClickToken 的点击每个凭证只记录一次，重复时返回 ErrDuplicateClick
+    ChargeAdEvent(ctx context.Context, event *models.AdEvent) error
+
+    // GetAdPerformanceSummary 查询组织的广告效果汇总数据
+    GetAdPerformanceSummary(ctx context.Context, orgID int, filters models.AdPerformanceFilter) ([]models.AdPerformanceSummary, error)
 	GetRandomActiveCampaign(ctx context.Context) (*models.AdCampaign, error)
+    // ListServableAds 返回 now 时所有可投放的活动及其创意 (条件与 GetRandomActiveCampaign 相同)，用于构建投放索引
+    ListServableAds(ctx context.Context, now time.Time) ([]models.ServableAd, error)
 
     // --- 发票相关方法 ---
-    // GetSuccessfulRechargeTotalInRange 计算指定用户在日期范围内成功充值的总额（分）
-    GetSuccessfulRechargeTotalInRange(ctx context.Context, userID int, startDate, endDate time.Time) (int64, error)
+    // GetSuccessfulRechargeTotalInRange 计算指定组织在日期范围内成功充值的总额（分）
+    GetSuccessfulRechargeTotalInRange(ctx context.Context, orgID int, startDate, endDate time.Time) (int64, error)
 
     // CreateInvoiceRequest 创建一个新的发票请求记录
     CreateInvoiceRequest(ctx context.Context, req models.InvoiceRequest) (int64, error)
 
-    // GetInvoiceRequestsByUserID 获取用户的发票请求历史，支持过滤
-    GetInvoiceRequestsByUserID(ctx context.Context, userID int, filters models.InvoiceRequestFilter) ([]models.InvoiceRequest, error)
-
-    // GetInvoiceRequestByIDAndUser 获取用户拥有的单个发票请求详情
-    GetInvoiceRequestByIDAndUser(ctx context.Context, invoiceID int64, userID int) (*models.InvoiceRequest, error)
-
-    // --- (管理员功能，可选) ---
-    // UpdateInvoiceRequestStatus 更新发票请求的状态和可选的发票号/备注 (需要权限控制)
-    // UpdateInvoiceRequestStatus(ctx context.Context, invoiceID int64, status string, invoiceNumber *string, notes *string, processedAt *time.Time) error
+    // GetInvoiceRequestsByOrganization 获取组织的发票请求历史，支持过滤
+    GetInvoiceRequestsByOrganization(ctx context.Context, orgID int, filters models.InvoiceRequestFilter) ([]models.InvoiceRequest, error)
+
+    // GetInvoiceRequestByIDAndOrganization 获取组织拥有的单个发票请求详情
+    GetInvoiceRequestByIDAndOrganization(ctx context.Context, invoiceID int64, orgID int) (*models.InvoiceRequest, error)
+
+    // --- 账本 (复式记账) ---
+    // 所有余额变动 (充值、广告消耗、退款、赠送、调账) 都以分录形式记录，organizations.balance 是账本的缓存值
+    // PostLedgerEntry 过账一条分录并同步更新余额，余额不足返回 ErrInsufficientBalance，组织不存在返回 ErrNotFound
+    PostLedgerEntry(ctx context.Context, entry ledger.Entry) (int64, error)
+    // GetLedgerEntriesByOrganization 分页获取组织的账本分录 (按 ID 倒序)
+    GetLedgerEntriesByOrganization(ctx context.Context, orgID int, filter models.LedgerFilter) ([]models.LedgerEntry, error)
+    // ReconcileBalances 返回 organizations.balance 与账本余额不一致的组织
+    ReconcileBalances(ctx context.Context) ([]models.BalanceDiscrepancy, error)
+
+    // --- 发票处理 (需要 invoices:process 权限) ---
+    // GetInvoiceRequestByID 获取任意组织的单个发票请求，不存在返回 ErrNotFound
+    GetInvoiceRequestByID(ctx context.Context, invoiceID int64) (*models.InvoiceRequest, error)
+    // UpdateInvoiceRequestStatus 更新发票请求的状态，invoiceNumber / notes 为 nil 时保留原值。
+    // 不存在返回 ErrNotFound，已是终态 (Completed / Failed) 返回 ErrInvoiceAlreadyProcessed
+    UpdateInvoiceRequestStatus(ctx context.Context, invoiceID int64, status string, invoiceNumber *string, notes *string, processedAt *time.Time) error
 }
 
 
 // --- DBStore 是 Store 接口的数据库实现 ---
 type DBStore struct {
-	db *sql.DB // 持有数据库连接池
+	db      *sql.DB // 持有数据库连接池
+	dialect dialect // SQL 方言差异 (MySQL / SQLite)
 }
 
-// NewDBStore 创建一个新的 DBStore 实例
+// NewDBStore 创建一个新的 DBStore 实例 (MySQL)
 func NewDBStore(db *sql.DB) *DBStore {
-	return &DBStore{db: db}
+	return &DBStore{db: db, dialect: mysqlDialect}
 }
 
 // --- 实现 Store 接口的方法 ---
 
-// CreateUser 在数据库中创建一个新用户
-func (s *DBStore) CreateUser(ctx context.Context, username string, passwordHash string) error {
-	query := "INSERT INTO users (username, password_hash) VALUES (?, ?)"
-	_, err := s.db.ExecContext(ctx, query, username, passwordHash)
+// userColumns 是 scanUser 读取的 users 表的列
+const userColumns = "id, username, password_hash, role, default_organization_id, email, email_verified_at, " +
+	"totp_secret, totp_enabled_at, totp_last_step, two_factor_required, suspended_at, suspension_reason, created_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	user := &models.User{}
+	var defaultOrg sql.NullInt64
+	var email sql.NullString
+	var verifiedAt sql.NullTime
+	var totpSecret sql.NullString
+	var totpEnabledAt sql.NullTime
+	var suspendedAt sql.NullTime
+	var suspensionReason sql.NullString
+	if err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &defaultOrg, &email, &verifiedAt,
+		&totpSecret, &totpEnabledAt, &user.TOTPLastStep, &user.TwoFactorRequired, &suspendedAt, &suspensionReason,
+		&user.CreatedAt); err != nil {
+		return nil, err
+	}
+	if suspendedAt.Valid {
+		user.SuspendedAt = &suspendedAt.Time
+	}
+	if suspensionReason.Valid {
+		user.SuspensionReason = &suspensionReason.String
+	}
+	user.TOTPSecret = totpSecret.String
+	if totpEnabledAt.Valid {
+		user.TOTPEnabledAt = &totpEnabledAt.Time
+	}
+	user.DefaultOrganizationID = nullableInt(defaultOrg)
+	if email.Valid {
+		user.Email = &email.String
+	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	return user, nil
+}
+
+// CreateUser 在数据库中创建一个新用户，以及他的个人组织
+func (s *DBStore) CreateUser(ctx context.Context, user *models.User) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		// 检查是否是唯一约束冲突错误 (用户名重复)
+		return fmt.Errorf("store: failed to begin transaction for user: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email sql.NullString
+	if user.Email != nil {
+		email = sql.NullString{String: *user.Email, Valid: true}
+	}
+	now := time.Now()
+	query := "INSERT INTO users (username, password_hash, email, created_at) VALUES (?, ?, ?, ?)"
+	result, err := tx.ExecContext(ctx, query, user.Username, user.PasswordHash, email, now)
+	if err != nil {
+		// 检查是否是唯一约束冲突错误 (用户名或邮箱重复)
 		// 注意：这种检查可能依赖于具体的数据库驱动错误实现
 		// 一个更通用的方法是检查 SQLState 或错误消息字符串
 		// 这里用字符串包含作为示例，实际中可能需要更健壮的方法
-		if strings.Contains(err.Error(), "Duplicate entry") { // 针对 MySQL 的示例
+		if s.dialect.isDuplicateEntry(err) { // MySQL: "Duplicate entry", SQLite: "UNIQUE constraint failed"
+			// 两个唯一索引的错误信息格式不同，直接查一下是哪一个冲突
+			var exists int
+			err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE username = ?", user.Username).Scan(&exists)
+			if err == nil && exists == 0 && email.Valid {
+				return ErrDuplicateEmail
+			}
 			return ErrDuplicateUser // 返回自定义错误
 		}
 		// 对于其他错误，包装一下以提供更多上下文
 		return fmt.Errorf("store: failed to create user: %w", err)
 	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get last insert ID for user: %w", err)
+	}
+
+	// 个人组织：用户是唯一的 owner，同时作为默认组织
+	org := &models.Organization{Name: user.Username, CreatedAt: now}
+	if err := insertOrganization(ctx, tx, org, int(userID)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET default_organization_id = ? WHERE id = ?", org.ID, userID); err != nil {
+		return fmt.Errorf("store: failed to set default organization of user %d: %w", userID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit user: %w", err)
+	}
+	user.ID = int(userID)
+	user.Role = "user" // 与数据库列默认值一致
+	user.DefaultOrganizationID = &org.ID
+	user.CreatedAt = now
 	return nil
 }
 
 // GetUserByUsername 从数据库中按用户名查找用户
 func (s *DBStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	user := &models.User{} // 创建一个 User 结构体指针用于接收数据
-	query := "SELECT id, username, password_hash, role FROM users WHERE username = ?"
-	err := s.db.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role)
+	user, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// 如果没有找到用户，返回我们自定义的 ErrNotFound
@@ -152,18 +439,70 @@ func (s *DBStore) GetUserByUsername(ctx context.Context, username string) (*mode
 	return user, nil
 }
 
+// GetUserByID 从数据库中按 ID 查找用户
+func (s *DBStore) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get user by id %d: %w", userID, err)
+	}
+	return user, nil
+}
+
+// GetUserByEmail 从数据库中按邮箱查找用户
+func (s *DBStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email = ?", email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get user by email: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateUserRole 修改用户角色
+func (s *DBStore) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("store: failed to update role of user %d: %w", userID, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		// MySQL 在值未变化时也返回 0，确认用户是否存在
+		if _, err := s.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advertisementColumns 是 advertisementScanDest 对应的 advertisements 表的列
+const advertisementColumns = "id, title, image_url, target_url, user_id, organization_id, status, width, height, format"
+
+// advertisementScanDest 返回按 advertisementColumns 顺序扫描广告的目标
+func advertisementScanDest(ad *models.Advertisement) []interface{} {
+	return []interface{}{&ad.ID, &ad.Title, &ad.ImageURL, &ad.TargetURL, &ad.UserID, &ad.OrganizationID, &ad.Status,
+		&ad.Width, &ad.Height, &ad.Format}
+}
+
 // CreateAdvertisement 在数据库中创建一个新广告
 func (s *DBStore) CreateAdvertisement(ctx context.Context, ad *models.Advertisement) (int64, error) {
 	query := `
-		INSERT INTO advertisements (title, image_url, target_url, user_id, status)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO advertisements (title, image_url, target_url, user_id, organization_id, status, width, height, format)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	result, err := s.db.ExecContext(ctx, query,
 		ad.Title,
 		ad.ImageURL,
 		ad.TargetURL,
 		ad.UserID, // 需要确保调用前 ad.UserID 已设置
+		ad.OrganizationID,
 		ad.Status, // 需要确保调用前 ad.Status 已设置 (例如 'Pending')
+		ad.Width,
+		ad.Height,
+		ad.Format,
 	)
 	if err != nil {
 		// 可以检查外键约束错误等
@@ -178,17 +517,17 @@ func (s *DBStore) CreateAdvertisement(ctx context.Context, ad *models.Advertisem
 	return id, nil
 }
 
-// GetAdvertisementsByUserID 获取指定用户的所有广告
-func (s *DBStore) GetAdvertisementsByUserID(ctx context.Context, userID int) ([]models.Advertisement, error) {
+// GetAdvertisementsByOrganization 获取指定组织的所有广告
+func (s *DBStore) GetAdvertisementsByOrganization(ctx context.Context, orgID int) ([]models.Advertisement, error) {
 	query := `
-		SELECT id, title, image_url, target_url, user_id, status
+		SELECT ` + advertisementColumns + `
 		FROM advertisements
-		WHERE user_id = ?
+		WHERE organization_id = ?
 		ORDER BY id DESC
 	`
-	rows, err := s.db.QueryContext(ctx, query, userID)
+	rows, err := s.db.QueryContext(ctx, query, orgID)
 	if err != nil {
-		return nil, fmt.Errorf("store: failed to query advertisements for user %d: %w", userID, err)
+		return nil, fmt.Errorf("store: failed to query advertisements for organization %d: %w", orgID, err)
 	}
 	defer rows.Close() // 非常重要！
 
@@ -196,7 +535,7 @@ func (s *DBStore) GetAdvertisementsByUserID(ctx context.Context, userID int) ([]
 	for rows.Next() {
 		var ad models.Advertisement
 		// 注意 Scan 的参数要和 SELECT 的列对应，包括 user_id
-		err := rows.Scan(&ad.ID, &ad.Title, &ad.ImageURL, &ad.TargetURL, &ad.UserID, &ad.Status)
+		err := rows.Scan(advertisementScanDest(&ad)...)
 		if err != nil {
 			// 单行扫描失败，记录日志并返回错误
 			log.Printf("store: failed to scan advertisement row: %v", err)
@@ -242,8 +581,8 @@ func (s *DBStore) GetAdvertisementsByUserID(ctx context.Context, userID int) ([]
 // GetAdvertisementByID 根据 ID 获取广告信息
 func (s *DBStore) GetAdvertisementByID(ctx context.Context, adID int) (*models.Advertisement, error) {
     ad := &models.Advertisement{}
-    query := `SELECT id, title, image_url, target_url, user_id, status FROM advertisements WHERE id = ?`
-    err := s.db.QueryRowContext(ctx, query, adID).Scan(&ad.ID, &ad.Title, &ad.ImageURL, &ad.TargetURL, &ad.UserID, &ad.Status)
+    query := `SELECT ` + advertisementColumns + ` FROM advertisements WHERE id = ?`
+    err := s.db.QueryRowContext(ctx, query, adID).Scan(advertisementScanDest(ad)...)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
             return nil, ErrNotFound // 使用自定义的未找到错误
@@ -255,7 +594,7 @@ func (s *DBStore) GetAdvertisementByID(ctx context.Context, adID int) (*models.A
 
 // GetPendingAdvertisements 获取所有状态为 "Pending" 的广告创意列表
 func (s *DBStore) GetPendingAdvertisements(ctx context.Context) ([]models.Advertisement, error) {
-	query := `SELECT id, title, image_url, target_url, user_id, status FROM advertisements WHERE status = ? ORDER BY id DESC`
+	query := `SELECT ` + advertisementColumns + ` FROM advertisements WHERE status = ? ORDER BY id DESC`
 	rows, err := s.db.QueryContext(ctx, query, "Pending") // 使用 QueryContext
 	if err != nil {
 		return nil, fmt.Errorf("store: failed to query pending advertisements: %w", err)
@@ -265,7 +604,7 @@ func (s *DBStore) GetPendingAdvertisements(ctx context.Context) ([]models.Advert
 	var ads []models.Advertisement
 	for rows.Next() {
 		var ad models.Advertisement
-		if err := rows.Scan(&ad.ID, &ad.Title, &ad.ImageURL, &ad.TargetURL, &ad.UserID, &ad.Status); err != nil {
+		if err := rows.Scan(advertisementScanDest(&ad)...); err != nil {
 			// 记录具体扫描错误可能有助于调试
 			log.Printf("store: failed to scan pending advertisement row: %v", err)
 			return nil, fmt.Errorf("store: error processing pending advertisements list: %w", err)
@@ -309,16 +648,32 @@ func (s *DBStore) UpdateAdvertisementStatus(ctx context.Context, adID int, statu
 }
 
 func (s *DBStore) CreateAdCampaign(ctx context.Context, campaign *models.AdCampaign) (int64, error) {
+    // 创建活动和写入第一条状态历史 (from_status 为 NULL) 放在同一个事务中
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("store: failed to begin transaction for ad campaign: %w", err)
+    }
+    defer tx.Rollback()
+
     query := `
-        INSERT INTO ad_campaigns (advertisement_id, user_id, start_date, end_date, status)
-        VALUES (?, ?, ?, ?, ?)
+        INSERT INTO ad_campaigns (advertisement_id, user_id, organization_id, start_date, end_date, status,
+                                  pricing_model, bid_amount, total_budget, daily_budget, targeting,
+                                  frequency_caps)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `
-    result, err := s.db.ExecContext(ctx, query,
+    result, err := tx.ExecContext(ctx, query,
         campaign.AdvertisementID,
         campaign.UserID,
+        campaign.OrganizationID,
         campaign.StartDate, // time.Time 会被驱动正确处理
         campaign.EndDate,
         campaign.Status,    // 应为 'Pending'
+        campaign.PricingModel,
+        campaign.BidAmount,
+        campaign.TotalBudget,
+        campaign.DailyBudget,
+        campaign.Targeting, // JSON，没有定向条件时为 NULL
+        campaign.FrequencyCaps,
     )
     if err != nil {
         // 检查外键错误等
@@ -328,43 +683,56 @@ func (s *DBStore) CreateAdCampaign(ctx context.Context, campaign *models.AdCampa
     if err != nil {
         return 0, fmt.Errorf("store: failed to get last insert ID for ad campaign: %w", err)
     }
+    if err := insertCampaignHistory(ctx, tx, int(id), nil, campaign.Status, campaign.UserID, "created", time.Now()); err != nil {
+        return 0, err
+    }
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("store: failed to commit ad campaign: %w", err)
+    }
     log.Printf("store: 创建广告活动成功, ID: %d", id)
     return id, nil
 }
 
 func (s *DBStore) GetAdCampaignByID(ctx context.Context, campaignID int) (*models.AdCampaign, error) {
     campaign := &models.AdCampaign{}
+    var spentDate sql.NullTime
     query := `
-        SELECT id, advertisement_id, user_id, start_date, end_date, status, created_at, updated_at
-        FROM ad_campaigns
-        WHERE id = ?
+        SELECT camp.id, camp.advertisement_id, camp.user_id, camp.organization_id, camp.start_date, camp.end_date, camp.status,
+            camp.created_at, camp.updated_at, camp.targeting, camp.frequency_caps, ` + budgetColumns + `
+        FROM ad_campaigns camp
+        WHERE camp.id = ?
     `
-    err := s.db.QueryRowContext(ctx, query, campaignID).Scan(
+    err := s.db.QueryRowContext(ctx, query, campaignID).Scan(append([]interface{}{
         &campaign.ID,
         &campaign.AdvertisementID,
         &campaign.UserID,
+        &campaign.OrganizationID,
         &campaign.StartDate,
         &campaign.EndDate,
         &campaign.Status,
         &campaign.CreatedAt,
         &campaign.UpdatedAt,
-    )
+        &campaign.Targeting,
+        &campaign.FrequencyCaps,
+    }, budgetScanDest(&campaign.CampaignBudget, &spentDate)...)...)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
             return nil, ErrNotFound
         }
         return nil, fmt.Errorf("store: failed to get ad campaign by id %d: %w", campaignID, err)
     }
+    normalizeSpentToday(&campaign.CampaignBudget, spentDate)
     return campaign, nil
 }
 
 // GetPendingCampaigns 获取所有状态为 "Pending" 的广告活动列表
 func (s *DBStore) GetPendingCampaigns(ctx context.Context) ([]models.AdCampaign, error) {
 	query := `
-		SELECT id, advertisement_id, user_id, start_date, end_date, status, created_at, updated_at
-		FROM ad_campaigns
-		WHERE status = ?
-		ORDER BY id DESC
+		SELECT camp.id, camp.advertisement_id, camp.user_id, camp.organization_id, camp.start_date, camp.end_date, camp.status,
+			camp.created_at, camp.updated_at, camp.targeting, camp.frequency_caps, ` + budgetColumns + `
+		FROM ad_campaigns camp
+		WHERE camp.status = ?
+		ORDER BY camp.id DESC
 	`
 	rows, err := s.db.QueryContext(ctx, query, "Pending") // 使用 QueryContext
 	if err != nil {
@@ -375,19 +743,24 @@ func (s *DBStore) GetPendingCampaigns(ctx context.Context) ([]models.AdCampaign,
 	var campaigns []models.AdCampaign
 	for rows.Next() {
 		var camp models.AdCampaign
-		if err := rows.Scan(
+		var spentDate sql.NullTime
+		if err := rows.Scan(append([]interface{}{
 			&camp.ID,
 			&camp.AdvertisementID,
 			&camp.UserID,
+			&camp.OrganizationID,
 			&camp.StartDate,
 			&camp.EndDate,
 			&camp.Status,
 			&camp.CreatedAt,
 			&camp.UpdatedAt,
-		); err != nil {
+			&camp.Targeting,
+			&camp.FrequencyCaps,
+		}, budgetScanDest(&camp.CampaignBudget, &spentDate)...)...); err != nil {
 			log.Printf("store: failed to scan pending campaign row: %v", err)
 			return nil, fmt.Errorf("store: error processing pending campaigns list: %w", err)
 		}
+		normalizeSpentToday(&camp.CampaignBudget, spentDate)
 		campaigns = append(campaigns, camp)
 	}
 
@@ -398,53 +771,40 @@ func (s *DBStore) GetPendingCampaigns(ctx context.Context) ([]models.AdCampaign,
 	return campaigns, nil
 }
 
-func (s *DBStore) UpdateAdCampaignStatus(ctx context.Context, campaignID int, status string) error {
-    query := "UPDATE ad_campaigns SET status = ? WHERE id = ?"
-    result, err := s.db.ExecContext(ctx, query, status, campaignID)
-    if err != nil {
-        return fmt.Errorf("store: failed to update status for ad campaign %d: %w", campaignID, err)
-    }
-    rowsAffected, err := result.RowsAffected()
-    if err != nil {
-        log.Printf("store: failed to get rows affected after updating campaign %d: %v", campaignID, err)
-        return nil // 通常不阻塞主逻辑
-    }
-    if rowsAffected == 0 {
-        return ErrNotFound // ID 不存在
-    }
-    log.Printf("store: 广告活动 %d 状态更新为 %s", campaignID, status)
-    return nil
-}
-
-
 // --- 实现新的广告获取逻辑 ---
 func (s *DBStore) GetRandomActiveCampaignAd(ctx context.Context) (*models.Advertisement, error) {
     ad := &models.Advertisement{}
-    // 查询状态为 'Approved' 且当前日期在活动有效期内的活动，
+    // 查询状态为 'Active' 且当前日期在活动有效期内的活动，
     // 并关联 advertisements 表获取广告创意信息。
-    // 使用 CURDATE() 或 NOW()::DATE (取决于数据库) 获取当前日期。
-    // 使用 ORDER BY RAND() LIMIT 1 获取随机一条。
+    // 当前日期由 Go 传入 (代替 MySQL 的 CURDATE())，随机函数由 dialect 决定 (RAND() / RANDOM())。
     query := `
         SELECT
-            adv.id, adv.title, adv.image_url, adv.target_url, adv.user_id, adv.status
+            adv.id, adv.title, adv.image_url, adv.target_url, adv.user_id, adv.organization_id, adv.status,
+            adv.width, adv.height, adv.format
         FROM ad_campaigns camp
         JOIN advertisements adv ON camp.advertisement_id = adv.id
+        JOIN organizations o ON camp.organization_id = o.id
         WHERE
-            camp.status = 'Approved'
-            AND CURDATE() >= camp.start_date  -- MySQL 使用 CURDATE()
-            AND CURDATE() <= camp.end_date
-        ORDER BY RAND()
+            camp.status = 'Active'
+            AND ? >= camp.start_date
+            AND ? <= camp.end_date` + servableCondition + `
+        ORDER BY ` + s.dialect.randomOrder + `
         LIMIT 1
     `
-    // 注意：如果你的 start_date/end_date 是 DATETIME/TIMESTAMP，比较时应使用 NOW()
+    // 注意：如果你的 start_date/end_date 是 DATETIME/TIMESTAMP，比较时应使用当前时间而不是当天零点
+    currentDate := today()
 
-    err := s.db.QueryRowContext(ctx, query).Scan(
+    err := s.db.QueryRowContext(ctx, query, currentDate, currentDate, currentDate).Scan(
         &ad.ID,
         &ad.Title,
         &ad.ImageURL,
         &ad.TargetURL,
         &ad.UserID, // 这将是广告创作者的 ID，不一定是活动请求者的 ID（虽然通常是同一个）
+        &ad.OrganizationID,
         &ad.Status, // 这将是广告创意的状态 ('Approved')
+        &ad.Width,
+        &ad.Height,
+        &ad.Format,
     )
 
     if err != nil {
@@ -460,12 +820,12 @@ func (s *DBStore) GetRandomActiveCampaignAd(ctx context.Context) (*models.Advert
 
 // --- 实现充值和余额方法 ---
 
-func (s *DBStore) CreateRechargeTransaction(ctx context.Context, userID int, amountInCents int64, paymentMethod string) (int64, error) {
+func (s *DBStore) CreateRechargeTransaction(ctx context.Context, orgID int, userID int, amountInCents int64, paymentMethod string) (int64, error) {
     query := `
-        INSERT INTO recharge_transactions (user_id, amount, status, payment_method)
-        VALUES (?, ?, 'Pending', ?)
+        INSERT INTO recharge_transactions (user_id, organization_id, amount, status, payment_method)
+        VALUES (?, ?, ?, 'Pending', ?)
     `
-    result, err := s.db.ExecContext(ctx, query, userID, amountInCents, paymentMethod)
+    result, err := s.db.ExecContext(ctx, query, userID, orgID, amountInCents, paymentMethod)
     if err != nil {
         // 考虑外键约束等错误
         return 0, fmt.Errorf("store: failed to create recharge transaction record: %w", err)
@@ -474,13 +834,14 @@ func (s *DBStore) CreateRechargeTransaction(ctx context.Context, userID int, amo
     if err != nil {
         return 0, fmt.Errorf("store: failed to get last insert ID for recharge transaction: %w", err)
     }
-    log.Printf("store: 创建充值记录成功, ID: %d, UserID: %d, Amount: %d分", id, userID, amountInCents)
+    log.Printf("store: 创建充值记录成功, ID: %d, OrganizationID: %d, UserID: %d, Amount: %d分", id, orgID, userID, amountInCents)
     return id, nil
 }
 
 
 // ProcessSuccessfulRecharge **原子性地**更新余额和交易状态
-func (s *DBStore) ProcessSuccessfulRecharge(ctx context.Context, userID int, amountInCents int64, rechargeRecordID int64, simulatedTxID string) error {
+func (s *DBStore) ProcessSuccessfulRecharge(ctx context.Context, rec *models.RechargeTransaction, simulatedTxID string) error {
+    rechargeRecordID, amountInCents := rec.ID, rec.Amount.Minor
     // 1. 开始数据库事务
     tx, err := s.db.BeginTx(ctx, nil) // 使用默认隔离级别
     if err != nil {
@@ -490,15 +851,7 @@ func (s *DBStore) ProcessSuccessfulRecharge(ctx context.Context, userID int, amo
     // 如果后面 Commit 成功，Rollback() 调用是无操作的 (no-op)
     defer tx.Rollback()
 
-    // 2. 更新用户余额 (在事务中)
-    updateBalanceQuery := "UPDATE users SET balance = balance + ? WHERE id = ?"
-    _, err = tx.ExecContext(ctx, updateBalanceQuery, amountInCents, userID)
-    if err != nil {
-        return fmt.Errorf("store: failed to update user %d balance in transaction: %w", userID, err)
-    }
-    log.Printf("store: [TX] 用户 %d 余额增加 %d 分", userID, amountInCents)
-
-    // 3. 更新充值记录状态为 Success (在事务中)
+    // 2. 更新充值记录状态为 Success (在事务中)
     updateTxQuery := `
         UPDATE recharge_transactions
         SET status = 'Success', transaction_id = ?, updated_at = ?
@@ -513,17 +866,27 @@ func (s *DBStore) ProcessSuccessfulRecharge(ctx context.Context, userID int, amo
     rowsAffected, _ := result.RowsAffected() // 忽略获取影响行数的错误，主要依赖前面的错误检查
     if rowsAffected == 0 {
          // 可能是记录不存在，或者状态不是 Pending
-        return fmt.Errorf("store: recharge transaction %d not found or not in Pending state during update", rechargeRecordID)
+        return fmt.Errorf("store: recharge transaction %d not found or not in Pending state during update: %w", rechargeRecordID, ErrRechargeNotPending)
     }
 
     log.Printf("store: [TX] 充值记录 %d 状态更新为 Success, TxID: %s", rechargeRecordID, simulatedTxID)
 
+    // 3. 记账: 借 platform:cash，贷 组织钱包；postEntry 会同步增加 organizations.balance (在事务中)
+    entry, err := ledger.Recharge(rec.OrganizationID, rec.UserID, amountInCents, rechargeRecordID)
+    if err != nil {
+        return fmt.Errorf("store: invalid recharge amount %d: %w", amountInCents, err)
+    }
+    if _, err := s.postEntry(ctx, tx, entry); err != nil {
+        return fmt.Errorf("store: failed to post recharge %d to ledger: %w", rechargeRecordID, err)
+    }
+    log.Printf("store: [TX] 组织 %d 余额增加 %d 分", rec.OrganizationID, amountInCents)
+
     // 4. 提交事务
     if err := tx.Commit(); err != nil {
         return fmt.Errorf("store: failed to commit recharge transaction: %w", err)
     }
 
-    log.Printf("store: 充值事务成功提交 (UserID: %d, Amount: %d分, RecordID: %d)", userID, amountInCents, rechargeRecordID)
+    log.Printf("store: 充值事务成功提交 (OrganizationID: %d, Amount: %d分, RecordID: %d)", rec.OrganizationID, amountInCents, rechargeRecordID)
     return nil // 事务成功
 }
 
@@ -555,29 +918,29 @@ func (s *DBStore) UpdateRechargeTransactionStatus(ctx context.Context, rechargeR
 }
 
 
-func (s *DBStore) GetUserBalance(ctx context.Context, userID int) (int64, error) {
+func (s *DBStore) GetOrganizationBalance(ctx context.Context, orgID int) (int64, error) {
     var balance int64
-    query := "SELECT balance FROM users WHERE id = ?"
-    err := s.db.QueryRowContext(ctx, query, userID).Scan(&balance)
+    query := "SELECT balance FROM organizations WHERE id = ?"
+    err := s.db.QueryRowContext(ctx, query, orgID).Scan(&balance)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
-            // 理论上用户应该存在，否则无法通过认证，返回 ErrNotFound 或 0 都可以
+            // 理论上组织应该存在，否则无法通过组织中间件，返回 ErrNotFound 或 0 都可以
             return 0, ErrNotFound // 或者 log.Printf 并返回 0, nil
         }
-        return 0, fmt.Errorf("store: failed to get balance for user %d: %w", userID, err)
+        return 0, fmt.Errorf("store: failed to get balance for organization %d: %w", orgID, err)
     }
     return balance, nil
 }
 
-func (s *DBStore) GetUserRechargeHistory(ctx context.Context, userID int, filters models.RechargeHistoryFilters) ([]models.RechargeTransaction, error) {
+func (s *DBStore) GetRechargeHistoryByOrganization(ctx context.Context, orgID int, filters models.RechargeHistoryFilters) ([]models.RechargeTransaction, error) {
 	// 基础查询语句
 	baseQuery := `
-        SELECT id, user_id, amount, status, transaction_id, payment_method, created_at, updated_at
+        SELECT id, user_id, organization_id, amount, status, transaction_id, payment_method, provider_ref, created_at, updated_at
         FROM recharge_transactions
     `
 	// 条件子句和参数列表
-	conditions := []string{"user_id = ?"} // 始终按用户 ID 过滤
-	args := []interface{}{userID}         // 参数列表，第一个总是 user_id
+	conditions := []string{"organization_id = ?"} // 始终按组织 ID 过滤
+	args := []interface{}{orgID}                  // 参数列表，第一个总是 organization_id
 
 	// 根据过滤器动态添加条件和参数
 	if filters.Status != nil {
@@ -611,29 +974,37 @@ func (s *DBStore) GetUserRechargeHistory(ctx context.Context, userID int, filter
 	// 执行查询
 	rows, err := s.db.QueryContext(ctx, finalQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("store: failed to query filtered recharge history for user %d: %w", userID, err)
+		return nil, fmt.Errorf("store: failed to query filtered recharge history for organization %d: %w", orgID, err)
 	}
+	return scanRechargeRows(rows)
+}
+
+// scanRechargeRows 扫描 id, user_id, organization_id, amount, status, transaction_id, payment_method,
+// provider_ref, created_at, updated_at 列的充值记录，并关闭 rows
+func scanRechargeRows(rows *sql.Rows) ([]models.RechargeTransaction, error) {
 	defer rows.Close()
 
-	// 处理结果
 	var history []models.RechargeTransaction
 	for rows.Next() {
 		var tx models.RechargeTransaction
 		var nullableTxID sql.NullString // 用于接收可能为 NULL 的 transaction_id
+		var providerRef sql.NullString
 		err := rows.Scan(
 			&tx.ID,
 			&tx.UserID,
+			&tx.OrganizationID,
 			&tx.Amount, // 读取的是分 (int64)
 			&tx.Status,
 			&nullableTxID, // Scan 到 nullable 类型
 			&tx.PaymentMethod,
+			&providerRef,
 			&tx.CreatedAt,
 			&tx.UpdatedAt,
 		)
 		if err != nil {
-			log.Printf("store: failed to scan filtered recharge transaction row: %v", err)
+			log.Printf("store: failed to scan recharge transaction row: %v", err)
 			// 在循环中遇到扫描错误，通常表明数据有问题或结构不匹配，最好返回错误
-			return nil, fmt.Errorf("store: error processing filtered recharge history row: %w", err)
+			return nil, fmt.Errorf("store: error processing recharge history row: %w", err)
 		}
 
 		// 处理 nullable transaction_id
@@ -642,32 +1013,28 @@ func (s *DBStore) GetUserRechargeHistory(ctx context.Context, userID int, filter
 		} else {
 			tx.TransactionID = nil // 否则确保模型中的指针为 nil
 		}
+		if providerRef.Valid {
+			tx.ProviderRef = &providerRef.String
+		}
 
 		history = append(history, tx) // 将成功扫描的记录添加到结果列表
 	} // 结束 rows.Next() 循环
 
 	// 检查循环结束后是否有错误发生（例如数据库连接中断）
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("store: error iterating over filtered recharge history rows: %w", err)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: error iterating over recharge history rows: %w", err)
 	}
 
 	// 如果没有错误，返回查询到的历史记录
 	return history, nil
 }
 
-func (s *DBStore) GetAdCampaignsByUserID(ctx context.Context, userID int, filters models.CampaignFilters) ([]models.CampaignWithAdDetails, error) {
+func (s *DBStore) GetAdCampaignsByOrganization(ctx context.Context, orgID int, filters models.CampaignFilters) ([]models.CampaignWithAdDetails, error) {
     // 基础查询语句，JOIN advertisements 表
-    baseQuery := `
-        SELECT
-            camp.id, camp.advertisement_id, camp.user_id, camp.start_date, camp.end_date,
-            camp.status, camp.created_at, camp.updated_at,
-            adv.title AS ad_title, adv.image_url AS ad_image_url
-        FROM ad_campaigns camp
-        JOIN advertisements adv ON camp.advertisement_id = adv.id
-    `
+    baseQuery := campaignWithAdQuery
     // 条件子句和参数列表
-    conditions := []string{"camp.user_id = ?"} // 始终按用户 ID 过滤
-    args := []interface{}{userID}
+    conditions := []string{"camp.organization_id = ?"} // 始终按组织 ID 过滤
+    args := []interface{}{orgID}
 
     // 根据过滤器动态添加条件和参数 (注意表别名 camp)
     if filters.Status != nil {
@@ -690,133 +1057,109 @@ func (s *DBStore) GetAdCampaignsByUserID(ctx context.Context, userID int, filter
     // 组合最终的查询语句
     finalQuery := baseQuery + " WHERE " + strings.Join(conditions, " AND ") + " ORDER BY camp.created_at DESC"
 
-    log.Printf("Executing filtered user campaigns query: %s with args: %v", finalQuery, args)
+    log.Printf("Executing filtered organization campaigns query: %s with args: %v", finalQuery, args)
 
     rows, err := s.db.QueryContext(ctx, finalQuery, args...)
     if err != nil {
-        return nil, fmt.Errorf("store: failed to query campaigns for user %d: %w", userID, err)
+        return nil, fmt.Errorf("store: failed to query campaigns for organization %d: %w", orgID, err)
     }
+    return scanCampaignsWithAd(rows)
+}
+
+// campaignWithAdQuery 查询活动及其广告创意信息 (表别名 camp 和 adv)，结果由 scanCampaignsWithAd 扫描
+const campaignWithAdQuery = `
+        SELECT
+            camp.id, camp.advertisement_id, camp.user_id, camp.organization_id, camp.start_date, camp.end_date,
+            camp.status, camp.created_at, camp.updated_at, camp.targeting, camp.frequency_caps, ` + budgetColumns + `,
+            adv.title AS ad_title, adv.image_url AS ad_image_url
+        FROM ad_campaigns camp
+        JOIN advertisements adv ON camp.advertisement_id = adv.id
+    `
+
+// scanCampaignsWithAd 扫描 campaignWithAdQuery 的结果，并关闭 rows
+func scanCampaignsWithAd(rows *sql.Rows) ([]models.CampaignWithAdDetails, error) {
     defer rows.Close()
 
     var campaigns []models.CampaignWithAdDetails
     for rows.Next() {
         var camp models.CampaignWithAdDetails
-        err := rows.Scan(
-            &camp.ID, &camp.AdvertisementID, &camp.UserID, &camp.StartDate, &camp.EndDate,
-            &camp.Status, &camp.CreatedAt, &camp.UpdatedAt,
-            &camp.AdTitle, &camp.AdImageURL, // Scan 广告信息
-        )
-        if err != nil {
-            log.Printf("store: failed to scan campaign row for user %d: %v", userID, err)
+        var spentDate sql.NullTime
+        dest := []interface{}{
+            &camp.ID, &camp.AdvertisementID, &camp.UserID, &camp.OrganizationID, &camp.StartDate, &camp.EndDate,
+            &camp.Status, &camp.CreatedAt, &camp.UpdatedAt, &camp.Targeting, &camp.FrequencyCaps,
+        }
+        dest = append(dest, budgetScanDest(&camp.CampaignBudget, &spentDate)...)
+        dest = append(dest, &camp.AdTitle, &camp.AdImageURL) // Scan 广告信息
+        if err := rows.Scan(dest...); err != nil {
+            log.Printf("store: failed to scan campaign row: %v", err)
             return nil, fmt.Errorf("store: error processing campaign row: %w", err)
         }
+        normalizeSpentToday(&camp.CampaignBudget, spentDate)
         campaigns = append(campaigns, camp)
     }
 
-    if err = rows.Err(); err != nil {
-        return nil, fmt.Errorf("store: error iterating over campaign rows for user %d: %w", userID, err)
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("store: error iterating over campaign rows: %w", err)
     }
 
     return campaigns, nil
 }
 
-func (s *DBStore) GetAdCampaignByIDAndUser(ctx context.Context, campaignID int, userID int) (*models.CampaignWithAdDetails, error) {
+func (s *DBStore) GetAdCampaignByIDAndOrganization(ctx context.Context, campaignID int, orgID int) (*models.CampaignWithAdDetails, error) {
 	query := `
         SELECT
-            camp.id, camp.advertisement_id, camp.user_id, camp.start_date, camp.end_date,
-            camp.status, camp.created_at, camp.updated_at,
+            camp.id, camp.advertisement_id, camp.user_id, camp.organization_id, camp.start_date, camp.end_date,
+            camp.status, camp.created_at, camp.updated_at, camp.targeting, camp.frequency_caps, ` + budgetColumns + `,
             adv.title AS ad_title, adv.image_url AS ad_image_url
         FROM ad_campaigns camp
         JOIN advertisements adv ON camp.advertisement_id = adv.id
-        WHERE camp.id = ? AND camp.user_id = ? -- Filter by both campaign ID and user ID
+        WHERE camp.id = ? AND camp.organization_id = ? -- Filter by both campaign ID and organization ID
     `
-	log.Printf("Executing get campaign by ID and user query: ID=%d, UserID=%d", campaignID, userID)
+	log.Printf("Executing get campaign by ID and organization query: ID=%d, OrganizationID=%d", campaignID, orgID)
 
 	var camp models.CampaignWithAdDetails
-	err := s.db.QueryRowContext(ctx, query, campaignID, userID).Scan(
-		&camp.ID, &camp.AdvertisementID, &camp.UserID, &camp.StartDate, &camp.EndDate,
-		&camp.Status, &camp.CreatedAt, &camp.UpdatedAt,
-		&camp.AdTitle, &camp.AdImageURL,
-	)
+	var spentDate sql.NullTime
+	dest := []interface{}{
+		&camp.ID, &camp.AdvertisementID, &camp.UserID, &camp.OrganizationID, &camp.StartDate, &camp.EndDate,
+		&camp.Status, &camp.CreatedAt, &camp.UpdatedAt, &camp.Targeting, &camp.FrequencyCaps,
+	}
+	dest = append(dest, budgetScanDest(&camp.CampaignBudget, &spentDate)...)
+	dest = append(dest, &camp.AdTitle, &camp.AdImageURL)
+	err := s.db.QueryRowContext(ctx, query, campaignID, orgID).Scan(dest...)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			// 没有找到匹配的记录，可能是 ID 不存在，或者不属于该用户
-			log.Printf("Campaign not found or access denied: ID=%d, UserID=%d", campaignID, userID)
+			// 没有找到匹配的记录，可能是 ID 不存在，或者不属于该组织
+			log.Printf("Campaign not found or access denied: ID=%d, OrganizationID=%d", campaignID, orgID)
 			return nil, ErrNotFound // 使用通用未找到错误
 		}
 		// 其他数据库错误
-		log.Printf("Error querying campaign by ID %d for user %d: %v", campaignID, userID, err)
-		return nil, fmt.Errorf("store: failed to get campaign %d for user %d: %w", campaignID, userID, err)
+		log.Printf("Error querying campaign by ID %d for organization %d: %v", campaignID, orgID, err)
+		return nil, fmt.Errorf("store: failed to get campaign %d for organization %d: %w", campaignID, orgID, err)
 	}
 
+	normalizeSpentToday(&camp.CampaignBudget, spentDate)
 	return &camp, nil
 }
 
-func (s *DBStore) UpdateAdCampaignStatusByUser(ctx context.Context, campaignID int, userID int, newStatus string) error {
-	// 1. (可选但推荐) 先检查活动是否存在且属于该用户，以及当前状态是否允许修改
-    // 这样可以提供更具体的错误信息，但会增加一次查询
-	/*
-	currentCamp, err := s.GetAdCampaignByIDAndUser(ctx, campaignID, userID)
-	if err != nil {
-		return err // 会返回 ErrNotFound 或其他数据库错误
-	}
-	// 在这里添加状态转换逻辑检查，例如只能从 Pending/Approved -> Cancelled
-	if currentCamp.Status != "Pending" && currentCamp.Status != "Approved" {
-        return fmt.Errorf("store: cannot change status from '%s' to '%s'", currentCamp.Status, newStatus) // 或者定义一个更具体的错误类型
-	}
-    // 如果是取消操作，还可以检查活动是否已经开始
-    // if newStatus == "Cancelled" && time.Now().After(currentCamp.StartDate) {
-    //    return fmt.Errorf("store: cannot cancel a campaign that has already started")
-    // }
-	*/
-
-	// 2. 执行更新，直接在 WHERE 子句中包含 userID 和 campaignID
-	query := "UPDATE ad_campaigns SET status = ?, updated_at = ? WHERE id = ? AND user_id = ?"
-    // (可选) 增加状态检查: "UPDATE ... WHERE id = ? AND user_id = ? AND status IN ('Pending', 'Approved')"
-    // 如果希望严格限制只能从特定状态更新，可以像上面这样在 WHERE 中加入状态检查
-
-	result, err := s.db.ExecContext(ctx, query, newStatus, time.Now(), campaignID, userID)
-	if err != nil {
-		log.Printf("Error updating campaign %d status by user %d: %v", campaignID, userID, err)
-		return fmt.Errorf("store: failed to update status for campaign %d by user %d: %w", campaignID, userID, err)
-	}
-
-	// 3. 检查是否真的更新了行
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("store: failed to get rows affected after updating campaign %d by user %d: %v", campaignID, userID, err)
-		// 即使获取影响行数失败，也可能已经更新成功，所以不一定返回错误
-		return nil
-	}
-
-	if rowsAffected == 0 {
-		// 没有行被更新，原因可能是：
-		// 1. 活动 ID 不存在
-		// 2. 活动不属于该用户
-        // 3. (如果加了状态检查) 活动当前状态不允许被更新
-        // 由于无法区分具体原因，返回 ErrNotFound 比较通用
-		log.Printf("No rows affected when user %d tried to update campaign %d to status %s", userID, campaignID, newStatus)
-		return ErrNotFound // 或者一个更具体的权限错误
-	}
-
-	log.Printf("User %d successfully updated campaign %d status to %s", userID, campaignID, newStatus)
-	return nil
-}
+// UpdateAdCampaignStatusByOrganization 的实现见 lifecycle.go (需要校验状态转换)
 
 // --- 实现广告事件与效果方法 ---
 
 func (s *DBStore) LogAdEvent(ctx context.Context, event models.AdEvent) error {
     query := `
-        INSERT INTO ad_events (event_type, advertisement_id, campaign_id, user_id, event_timestamp)
-        VALUES (?, ?, ?, ?, ?)
+        INSERT INTO ad_events (event_type, advertisement_id, campaign_id, user_id, organization_id, event_timestamp, cost, placement_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
     `
     _, err := s.db.ExecContext(ctx, query,
         event.EventType,
         event.AdvertisementID,
         event.CampaignID,
         event.UserID,
+        event.OrganizationID,
         event.EventTimestamp, // 应该设为 time.Now() 或从调用者传入
+        event.Cost,           // 不扣费的事件为 0，扣费请使用 ChargeAdEvent
+        event.PlacementID,
     )
     if err != nil {
         log.Printf("Error logging ad event (%s) for user %d, campaign %d, ad %d: %v",
@@ -829,7 +1172,7 @@ func (s *DBStore) LogAdEvent(ctx context.Context, event models.AdEvent) error {
 }
 
 
-func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, userID int, filters models.AdPerformanceFilter) ([]models.AdPerformanceSummary, error) {
+func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, orgID int, filters models.AdPerformanceFilter) ([]models.AdPerformanceSummary, error) {
     // 基础聚合查询，JOIN campaigns 和 advertisements 获取名称
     baseQuery := `
         SELECT
@@ -837,13 +1180,14 @@ func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, userID int, filte
             evt.advertisement_id,
             adv.title AS ad_title,
             SUM(CASE WHEN evt.event_type = 'Impression' THEN 1 ELSE 0 END) AS impressions,
-            SUM(CASE WHEN evt.event_type = 'Click' THEN 1 ELSE 0 END) AS clicks
+            SUM(CASE WHEN evt.event_type = 'Click' THEN 1 ELSE 0 END) AS clicks,
+            SUM(evt.cost) AS spend
         FROM ad_events evt
         JOIN ad_campaigns camp ON evt.campaign_id = camp.id
         JOIN advertisements adv ON evt.advertisement_id = adv.id
     `
-    conditions := []string{"evt.user_id = ?"} // 必须按用户过滤
-    args := []interface{}{userID}
+    conditions := []string{"evt.organization_id = ?"} // 必须按组织过滤
+    args := []interface{}{orgID}
 
     // 添加过滤条件
     if filters.StartDate != nil {
@@ -866,11 +1210,11 @@ func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, userID int, filte
                   " GROUP BY evt.campaign_id, evt.advertisement_id, adv.title" +
                   " ORDER BY evt.campaign_id, evt.advertisement_id" // 按活动和创意排序
 
-    log.Printf("Executing ad performance summary query for user %d: %s with args: %v", userID, finalQuery, args)
+    log.Printf("Executing ad performance summary query for organization %d: %s with args: %v", orgID, finalQuery, args)
 
     rows, err := s.db.QueryContext(ctx, finalQuery, args...)
     if err != nil {
-        return nil, fmt.Errorf("store: failed to query ad performance for user %d: %w", userID, err)
+        return nil, fmt.Errorf("store: failed to query ad performance for organization %d: %w", orgID, err)
     }
     defer rows.Close()
 
@@ -883,9 +1227,10 @@ func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, userID int, filte
             &summary.AdTitle,
             &summary.Impressions,
             &summary.Clicks,
+            &summary.Spend,
         )
         if err != nil {
-            log.Printf("store: failed to scan ad performance row for user %d: %v", userID, err)
+            log.Printf("store: failed to scan ad performance row for organization %d: %v", orgID, err)
             return nil, fmt.Errorf("store: error processing ad performance row: %w", err)
         }
         // CTR 计算将在 Handler 中进行
@@ -893,7 +1238,7 @@ func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, userID int, filte
     }
 
     if err = rows.Err(); err != nil {
-        return nil, fmt.Errorf("store: error iterating over ad performance rows for user %d: %w", userID, err)
+        return nil, fmt.Errorf("store: error iterating over ad performance rows for organization %d: %w", orgID, err)
     }
 
     return results, nil
@@ -901,19 +1246,25 @@ func (s *DBStore) GetAdPerformanceSummary(ctx context.Context, userID int, filte
 
 func (s *DBStore) GetRandomActiveCampaign(ctx context.Context) (*models.AdCampaign, error) {
     query := `
-        SELECT id, advertisement_id, user_id, start_date, end_date, status, created_at, updated_at
-        FROM ad_campaigns
-        WHERE status = 'Active' 
-          AND start_date <= NOW()
-          AND end_date >= NOW()
-        ORDER BY RAND() 
+        SELECT camp.id, camp.advertisement_id, camp.user_id, camp.organization_id, camp.start_date, camp.end_date, camp.status,
+            camp.created_at, camp.updated_at, camp.targeting, camp.frequency_caps, ` + budgetColumns + `
+        FROM ad_campaigns camp
+        JOIN organizations o ON camp.organization_id = o.id
+        WHERE camp.status = 'Active'
+          AND camp.start_date <= ?
+          AND camp.end_date >= ?` + servableCondition + `
+        ORDER BY ` + s.dialect.randomOrder + `
         LIMIT 1
     `
+    // end_date 是 DATE 类型，活动在结束日期当天仍然有效，所以和当天零点比较
+    // 预算或余额已用完的活动不参与投放 (servableCondition)
+    now := time.Now() // 代替 MySQL 的 NOW()
     var camp models.AdCampaign
-    err := s.db.QueryRowContext(ctx, query).Scan(
-         &camp.ID, &camp.AdvertisementID, &camp.UserID, &camp.StartDate, &camp.EndDate,
-         &camp.Status, &camp.CreatedAt, &camp.UpdatedAt,
-    )
+    var spentDate sql.NullTime
+    err := s.db.QueryRowContext(ctx, query, now, today(), today()).Scan(append([]interface{}{
+         &camp.ID, &camp.AdvertisementID, &camp.UserID, &camp.OrganizationID, &camp.StartDate, &camp.EndDate,
+         &camp.Status, &camp.CreatedAt, &camp.UpdatedAt, &camp.Targeting, &camp.FrequencyCaps,
+    }, budgetScanDest(&camp.CampaignBudget, &spentDate)...)...)
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) {
             return nil, ErrNotFound
@@ -921,17 +1272,18 @@ func (s *DBStore) GetRandomActiveCampaign(ctx context.Context) (*models.AdCampai
         log.Printf("Error getting random active campaign: %v", err)
         return nil, fmt.Errorf("store: failed to get active campaign: %w", err)
     }
+    normalizeSpentToday(&camp.CampaignBudget, spentDate)
     return &camp, nil
 }
 
 
 // --- 实现发票相关方法 ---
 
-func (s *DBStore) GetSuccessfulRechargeTotalInRange(ctx context.Context, userID int, startDate, endDate time.Time) (int64, error) {
+func (s *DBStore) GetSuccessfulRechargeTotalInRange(ctx context.Context, orgID int, startDate, endDate time.Time) (int64, error) {
     query := `
         SELECT COALESCE(SUM(amount), 0)
         FROM recharge_transactions
-        WHERE user_id = ?
+        WHERE organization_id = ?
           AND status = 'Success'
           AND created_at >= ?
           AND created_at <= ?
@@ -942,23 +1294,23 @@ func (s *DBStore) GetSuccessfulRechargeTotalInRange(ctx context.Context, userID
     endOfDay := endDate.AddDate(0, 0, 1).Add(-1 * time.Nanosecond)
 
     var totalAmount int64
-    err := s.db.QueryRowContext(ctx, query, userID, startDate, endOfDay).Scan(&totalAmount)
+    err := s.db.QueryRowContext(ctx, query, orgID, startDate, endOfDay).Scan(&totalAmount)
     if err != nil {
          // 如果 QueryRowContext 返回 sql.ErrNoRows, COALESCE 会返回 0，Scan 不会出错
          // 所以这里主要是处理其他数据库错误
-         log.Printf("Error calculating successful recharge total for user %d between %v and %v: %v", userID, startDate, endDate, err)
+         log.Printf("Error calculating successful recharge total for organization %d between %v and %v: %v", orgID, startDate, endDate, err)
          return 0, fmt.Errorf("store: failed to calculate recharge total: %w", err)
     }
-    log.Printf("Calculated total successful recharge for user %d (%v to %v): %d cents", userID, startDate, endDate, totalAmount)
+    log.Printf("Calculated total successful recharge for organization %d (%v to %v): %d cents", orgID, startDate, endDate, totalAmount)
     return totalAmount, nil
 }
 
 func (s *DBStore) CreateInvoiceRequest(ctx context.Context, req models.InvoiceRequest) (int64, error) {
 	query := `
         INSERT INTO invoice_requests (
-            user_id, status, invoice_period_start, invoice_period_end, total_amount,
+            user_id, organization_id, status, invoice_period_start, invoice_period_end, total_amount,
             billing_title, tax_id, billing_address, requested_at
-        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `
 	// 处理可选字段 tax_id
 	var taxIDArg sql.NullString
@@ -972,6 +1324,7 @@ func (s *DBStore) CreateInvoiceRequest(ctx context.Context, req models.InvoiceRe
 	// 执行插入
 	result, err := s.db.ExecContext(ctx, query,
 		req.UserID,
+		req.OrganizationID,
 		req.Status, // 应该是 "Pending"
 		req.InvoicePeriodStart,
 		req.InvoicePeriodEnd,
@@ -999,15 +1352,15 @@ func (s *DBStore) CreateInvoiceRequest(ctx context.Context, req models.InvoiceRe
 }
 
 
-func (s *DBStore) GetInvoiceRequestsByUserID(ctx context.Context, userID int, filters models.InvoiceRequestFilter) ([]models.InvoiceRequest, error) {
+func (s *DBStore) GetInvoiceRequestsByOrganization(ctx context.Context, orgID int, filters models.InvoiceRequestFilter) ([]models.InvoiceRequest, error) {
 	baseQuery := `
-        SELECT id, user_id, status, invoice_period_start, invoice_period_end, total_amount,
+        SELECT id, user_id, organization_id, status, invoice_period_start, invoice_period_end, total_amount,
                billing_title, tax_id, billing_address, invoice_number, notes,
                requested_at, processed_at
         FROM invoice_requests
     `
-	conditions := []string{"user_id = ?"}
-	args := []interface{}{userID}
+	conditions := []string{"organization_id = ?"}
+	args := []interface{}{orgID}
 
 	// 添加过滤条件
 	if filters.Status != nil {
@@ -1027,11 +1380,11 @@ func (s *DBStore) GetInvoiceRequestsByUserID(ctx context.Context, userID int, fi
 
 	finalQuery := baseQuery + " WHERE " + strings.Join(conditions, " AND ") + " ORDER BY requested_at DESC"
 
-	log.Printf("Executing filtered invoice requests query for user %d: %s with args: %v", userID, finalQuery, args)
+	log.Printf("Executing filtered invoice requests query for organization %d: %s with args: %v", orgID, finalQuery, args)
 
 	rows, err := s.db.QueryContext(ctx, finalQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("store: failed to query invoice requests for user %d: %w", userID, err)
+		return nil, fmt.Errorf("store: failed to query invoice requests for organization %d: %w", orgID, err)
 	}
 	defer rows.Close()
 
@@ -1045,12 +1398,12 @@ func (s *DBStore) GetInvoiceRequestsByUserID(ctx context.Context, userID int, fi
 		var nullableProcessedAt sql.NullTime // 使用 sql.NullTime 处理 nullable timestamp
 
 		err := rows.Scan(
-			&req.ID, &req.UserID, &req.Status, &req.InvoicePeriodStart, &req.InvoicePeriodEnd, &req.TotalAmount,
+			&req.ID, &req.UserID, &req.OrganizationID, &req.Status, &req.InvoicePeriodStart, &req.InvoicePeriodEnd, &req.TotalAmount,
 			&req.BillingTitle, &nullableTaxID, &req.BillingAddress, &nullableInvoiceNumber, &nullableNotes,
 			&req.RequestedAt, &nullableProcessedAt, // Scan 到 nullable 类型
 		)
 		if err != nil {
-			log.Printf("store: failed to scan invoice request row for user %d: %v", userID, err)
+			log.Printf("store: failed to scan invoice request row for organization %d: %v", orgID, err)
 			return nil, fmt.Errorf("store: error processing invoice request row: %w", err)
 		}
 
@@ -1064,42 +1417,49 @@ func (s *DBStore) GetInvoiceRequestsByUserID(ctx context.Context, userID int, fi
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("store: error iterating over invoice request rows for user %d: %w", userID, err)
+		return nil, fmt.Errorf("store: error iterating over invoice request rows for organization %d: %w", orgID, err)
 	}
 
 	return requests, nil
 }
 
 
-func (s *DBStore) GetInvoiceRequestByIDAndUser(ctx context.Context, invoiceID int64, userID int) (*models.InvoiceRequest, error) {
-	query := `
-        SELECT id, user_id, status, invoice_period_start, invoice_period_end, total_amount,
+// invoiceRequestQuery 查询单个发票请求，调用方追加 WHERE 条件后用 scanInvoiceRequest 读取
+const invoiceRequestQuery = `
+        SELECT id, user_id, organization_id, status, invoice_period_start, invoice_period_end, total_amount,
                billing_title, tax_id, billing_address, invoice_number, notes,
                requested_at, processed_at
-        FROM invoice_requests
-        WHERE id = ? AND user_id = ?
-    `
-	log.Printf("Executing get invoice request by ID %d and user %d query", invoiceID, userID)
+        FROM invoice_requests`
+
+func (s *DBStore) GetInvoiceRequestByIDAndOrganization(ctx context.Context, invoiceID int64, orgID int) (*models.InvoiceRequest, error) {
+	log.Printf("Executing get invoice request by ID %d and organization %d query", invoiceID, orgID)
+
+	req, err := scanInvoiceRequest(s.db.QueryRowContext(ctx, invoiceRequestQuery+" WHERE id = ? AND organization_id = ?", invoiceID, orgID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Invoice request not found or access denied: ID=%d, OrganizationID=%d", invoiceID, orgID)
+			return nil, ErrNotFound
+		}
+		log.Printf("Error querying invoice request ID %d for organization %d: %v", invoiceID, orgID, err)
+		return nil, fmt.Errorf("store: failed to get invoice request %d for organization %d: %w", invoiceID, orgID, err)
+	}
+	return req, nil
+}
 
+func scanInvoiceRequest(row *sql.Row) (*models.InvoiceRequest, error) {
 	var req models.InvoiceRequest
 	var nullableTaxID sql.NullString
 	var nullableInvoiceNumber sql.NullString
 	var nullableNotes sql.NullString
 	var nullableProcessedAt sql.NullTime
 
-	err := s.db.QueryRowContext(ctx, query, invoiceID, userID).Scan(
-		&req.ID, &req.UserID, &req.Status, &req.InvoicePeriodStart, &req.InvoicePeriodEnd, &req.TotalAmount,
+	err := row.Scan(
+		&req.ID, &req.UserID, &req.OrganizationID, &req.Status, &req.InvoicePeriodStart, &req.InvoicePeriodEnd, &req.TotalAmount,
 		&req.BillingTitle, &nullableTaxID, &req.BillingAddress, &nullableInvoiceNumber, &nullableNotes,
 		&req.RequestedAt, &nullableProcessedAt,
 	)
-
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("Invoice request not found or access denied: ID=%d, UserID=%d", invoiceID, userID)
-			return nil, ErrNotFound
-		}
-		log.Printf("Error querying invoice request ID %d for user %d: %v", invoiceID, userID, err)
-		return nil, fmt.Errorf("store: failed to get invoice request %d for user %d: %w", invoiceID, userID, err)
+		return nil, err
 	}
 
 	// 转换 nullable 字段
@@ -1112,13 +1472,24 @@ func (s *DBStore) GetInvoiceRequestByIDAndUser(ctx context.Context, invoiceID in
 }
 
 
-// --- (管理员功能，可选实现) ---
-/*
+// --- 发票处理 ---
+func (s *DBStore) GetInvoiceRequestByID(ctx context.Context, invoiceID int64) (*models.InvoiceRequest, error) {
+	req, err := scanInvoiceRequest(s.db.QueryRowContext(ctx, invoiceRequestQuery+" WHERE id = ?", invoiceID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get invoice request %d: %w", invoiceID, err)
+	}
+	return req, nil
+}
+
 func (s *DBStore) UpdateInvoiceRequestStatus(ctx context.Context, invoiceID int64, status string, invoiceNumber *string, notes *string, processedAt *time.Time) error {
     query := `
         UPDATE invoice_requests
-        SET status = ?, invoice_number = ?, notes = ?, processed_at = ?, updated_at = ?
-        WHERE id = ?
+        SET status = ?, invoice_number = COALESCE(?, invoice_number), notes = COALESCE(?, notes),
+            processed_at = COALESCE(?, processed_at), updated_at = ?
+        WHERE id = ? AND status NOT IN ('Completed', 'Failed')
     `
     // 处理 nullable 参数
     var invNumArg sql.NullString
@@ -1135,13 +1506,21 @@ func (s *DBStore) UpdateInvoiceRequestStatus(ctx context.Context, invoiceID int6
     }
     rowsAffected, _ := result.RowsAffected()
     if rowsAffected == 0 {
-        log.Printf("No rows affected when updating invoice request %d", invoiceID)
-        return ErrNotFound // Invoice ID 不存在
+        // 区分不存在和已处理
+        var current string
+        err := s.db.QueryRowContext(ctx, "SELECT status FROM invoice_requests WHERE id = ?", invoiceID).Scan(&current)
+        if errors.Is(err, sql.ErrNoRows) {
+            return ErrNotFound // Invoice ID 不存在
+        }
+        if err != nil {
+            return fmt.Errorf("store: failed to get status of invoice request %d: %w", invoiceID, err)
+        }
+        return ErrInvoiceAlreadyProcessed
     }
     log.Printf("Successfully updated invoice request %d status to %s", invoiceID, status)
     return nil
 }
-*/
+
 
 // --- Helper: Check if DBStore implements Store ---
 // 这个赋值语句如果编译不通过，说明 DBStore 没有完全实现 Store 接口
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 刷新令牌和访问令牌吊销列表 ---
+
+const refreshTokenColumns = "id, user_id, token_hash, session_id, access_jti, access_expires_at, expires_at, created_at, revoked_at"
+
+func scanRefreshToken(row interface{ Scan(...interface{}) error }) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	var revokedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.TokenHash, &t.SessionID, &t.AccessJTI,
+		&t.AccessExpiresAt, &t.ExpiresAt, &t.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+func insertRefreshToken(ctx context.Context, tx *sql.Tx, t *models.RefreshToken) error {
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO refresh_tokens (user_id, token_hash, session_id, access_jti, access_expires_at, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, t.UserID, t.TokenHash, t.SessionID, t.AccessJTI, t.AccessExpiresAt, t.ExpiresAt, t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to create refresh token for user %d: %w", t.UserID, err)
+	}
+	t.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get refresh token id: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for refresh token: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertRefreshToken(ctx, tx, t); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	t, err := scanRefreshToken(s.db.QueryRowContext(ctx,
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = ?", tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *DBStore) RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for refresh token rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := scanRefreshToken(tx.QueryRowContext(ctx,
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = ?"+s.dialect.forUpdate, oldHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("store: failed to lock refresh token: %w", err)
+	}
+
+	if old.RevokedAt != nil {
+		// 已轮换过的令牌被再次使用：令牌可能已泄露，吊销整个会话 (提交后再返回错误)
+		n, err := s.revokeRefreshTokens(ctx, tx, "session_id = ?", []interface{}{old.SessionID}, now)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("store: failed to commit session revocation: %w", err)
+		}
+		log.Printf("store: 用户 %d 的刷新令牌 %d 被重复使用，会话 %s 已吊销 (%d 个令牌)", old.UserID, old.ID, old.SessionID, n)
+		return ErrRefreshTokenReused
+	}
+	if !old.ExpiresAt.After(now) {
+		return ErrRefreshTokenExpired
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE id = ?", now, old.ID); err != nil {
+		return fmt.Errorf("store: failed to revoke refresh token %d: %w", old.ID, err)
+	}
+	next.UserID = old.UserID
+	next.SessionID = old.SessionID
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit refresh token rotation: %w", err)
+	}
+	return nil
+}
+
+// revokeRefreshTokens 吊销满足 cond 的刷新令牌，并把与它们一起签发、尚未过期的访问令牌加入吊销列表。
+// 返回本次吊销的刷新令牌数 (之前已吊销的不计)。
+func (s *DBStore) revokeRefreshTokens(ctx context.Context, tx *sql.Tx, cond string, args []interface{}, now time.Time) (int, error) {
+	type accessToken struct {
+		jti       string
+		userID    int
+		expiresAt time.Time
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT access_jti, user_id, access_expires_at FROM refresh_tokens WHERE "+cond+" AND access_expires_at > ?",
+		append(append([]interface{}{}, args...), now)...)
+	if err != nil {
+		return 0, fmt.Errorf("store: failed to query access tokens to revoke: %w", err)
+	}
+	var tokens []accessToken
+	for rows.Next() {
+		var t accessToken
+		if err := rows.Scan(&t.jti, &t.userID, &t.expiresAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("store: failed to scan access token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("store: failed to iterate access tokens: %w", err)
+	}
+
+	for _, t := range tokens {
+		if err := s.revokeJTI(ctx, tx, t.jti, t.userID, t.expiresAt, now); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE "+cond+" AND revoked_at IS NULL",
+		append([]interface{}{now}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("store: failed to revoke refresh tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// revokeJTI 把访问令牌加入吊销列表，已在列表中时忽略
+func (s *DBStore) revokeJTI(ctx context.Context, tx *sql.Tx, jti string, userID int, expiresAt, now time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at) VALUES (?, ?, ?, ?)",
+		jti, userID, expiresAt, now)
+	if err != nil && !s.dialect.isDuplicateEntry(err) {
+		return fmt.Errorf("store: failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) RevokeSessionByAccessJTI(ctx context.Context, userID int, jti string, accessExpiresAt time.Time, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for logout: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.revokeJTI(ctx, tx, jti, userID, accessExpiresAt, now); err != nil {
+		return err
+	}
+	var sessionID string
+	err = tx.QueryRowContext(ctx,
+		"SELECT session_id FROM refresh_tokens WHERE access_jti = ? AND user_id = ?", jti, userID).Scan(&sessionID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// 没有对应的刷新令牌 (已被清理)，只吊销访问令牌
+	case err != nil:
+		return fmt.Errorf("store: failed to find session of access token: %w", err)
+	default:
+		if _, err := s.revokeRefreshTokens(ctx, tx, "session_id = ?", []interface{}{sessionID}, now); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit logout: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) RevokeAllSessions(ctx context.Context, userID int, now time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("store: failed to begin transaction for logout of all sessions: %w", err)
+	}
+	defer tx.Rollback()
+
+	n, err := s.revokeRefreshTokens(ctx, tx, "user_id = ?", []interface{}{userID}, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store: failed to commit logout of all sessions: %w", err)
+	}
+	log.Printf("store: 用户 %d 的所有会话已吊销 (%d 个刷新令牌)", userID, n)
+	return n, nil
+}
+
+func (s *DBStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT 1 FROM revoked_tokens WHERE jti = ?", jti).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("store: failed to check revoked token: %w", err)
+	}
+	return true, nil
+}
+
+func (s *DBStore) PurgeExpiredTokens(ctx context.Context, now time.Time) (int64, error) {
+	var total int64
+	for _, table := range []string{"refresh_tokens", "revoked_tokens", "user_tokens", "login_challenges", "login_attempts"} {
+		result, err := s.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE expires_at < ?", now)
+		if err != nil {
+			return total, fmt.Errorf("store: failed to purge expired %s: %w", table, err)
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
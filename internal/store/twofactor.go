@@ -0,0 +1,241 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// --- 两步验证 (TOTP + 恢复码) ---
+
+func (s *DBStore) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled_at IS NULL", secret, userID)
+	if err != nil {
+		return fmt.Errorf("store: failed to set totp secret of user %d: %w", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := s.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+func (s *DBStore) EnableTOTP(ctx context.Context, userID int, step int64, recoveryHashes []string, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for totp enrollment: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	if err := tx.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_enabled_at FROM users WHERE id = ?"+s.dialect.forUpdate, userID).Scan(&secret, &enabledAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("store: failed to get totp state of user %d: %w", userID, err)
+	}
+	if enabledAt.Valid {
+		return ErrTOTPAlreadyEnabled
+	}
+	if !secret.Valid || secret.String == "" {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?", now, step, userID); err != nil {
+		return fmt.Errorf("store: failed to enable totp of user %d: %w", userID, err)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit totp enrollment: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for totp removal: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("store: failed to disable totp of user %d: %w", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("store: failed to delete recovery codes of user %d: %w", userID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit totp removal: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	// 条件更新保证同一个时间步 (以及更早的时间步) 只能成功一次
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return fmt.Errorf("store: failed to record totp step of user %d: %w", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrOTPReused
+	}
+	return nil
+}
+
+func (s *DBStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL", now, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("store: failed to use recovery code of user %d: %w", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DBStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("store: failed to begin transaction for recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: failed to commit recovery codes: %w", err)
+	}
+	return nil
+}
+
+// replaceRecoveryCodes 在事务中删除用户的全部恢复码并插入新的恢复码
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string, now time.Time) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("store: failed to delete recovery codes of user %d: %w", userID, err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)", userID, hash, now); err != nil {
+			return fmt.Errorf("store: failed to create recovery code for user %d: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+func (s *DBStore) CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("store: failed to count recovery codes of user %d: %w", userID, err)
+	}
+	return n, nil
+}
+
+func (s *DBStore) SetTwoFactorRequired(ctx context.Context, userID int, required bool) error {
+	if _, err := s.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE users SET two_factor_required = ? WHERE id = ?", required, userID); err != nil {
+		return fmt.Errorf("store: failed to set two_factor_required of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// --- 两步登录的挑战 ---
+
+func (s *DBStore) CreateLoginChallenge(ctx context.Context, c *models.LoginChallenge) error {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO login_challenges (user_id, token_hash, created_at, expires_at)
+        VALUES (?, ?, ?, ?)
+    `, c.UserID, c.TokenHash, c.CreatedAt, c.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("store: failed to create login challenge for user %d: %w", c.UserID, err)
+	}
+	c.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("store: failed to get login challenge id: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) GetLoginChallenge(ctx context.Context, tokenHash string) (*models.LoginChallenge, error) {
+	var c models.LoginChallenge
+	var usedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+        SELECT id, user_id, token_hash, attempts, created_at, expires_at, used_at
+        FROM login_challenges WHERE token_hash = ?
+    `, tokenHash).Scan(&c.ID, &c.UserID, &c.TokenHash, &c.Attempts, &c.CreatedAt, &c.ExpiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("store: failed to get login challenge: %w", err)
+	}
+	if usedAt.Valid {
+		c.UsedAt = &usedAt.Time
+	}
+	return &c, nil
+}
+
+func (s *DBStore) FailLoginChallenge(ctx context.Context, id int64, maxAttempts int, now time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("store: failed to begin transaction for login challenge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var attempts int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT attempts FROM login_challenges WHERE id = ?"+s.dialect.forUpdate, id).Scan(&attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("store: failed to get login challenge %d: %w", id, err)
+	}
+	attempts++
+	query := "UPDATE login_challenges SET attempts = ? WHERE id = ?"
+	args := []interface{}{attempts, id}
+	if attempts >= maxAttempts {
+		query = "UPDATE login_challenges SET attempts = ?, used_at = COALESCE(used_at, ?) WHERE id = ?"
+		args = []interface{}{attempts, now, id}
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return 0, fmt.Errorf("store: failed to update login challenge %d: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store: failed to commit login challenge: %w", err)
+	}
+	return attempts, nil
+}
+
+func (s *DBStore) CompleteLoginChallenge(ctx context.Context, id int64, now time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE login_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL", now, id)
+	if err != nil {
+		return fmt.Errorf("store: failed to complete login challenge %d: %w", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package targeting
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoResolver 根据 IP 查询国家和地区
+type GeoResolver interface {
+	// Lookup 返回 ISO 3166-1 国家代码和 ISO 3166-2 地区代码 (如 CN-GD)，查不到的部分为空字符串
+	Lookup(ip net.IP) (country, region string, err error)
+}
+
+// MMDB 基于本地 MaxMind 格式数据库 (GeoLite2-Country、GeoLite2-City、GeoIP2 等) 的 GeoResolver。
+// Country 数据库只能识别国家，City 数据库还能识别地区
+type MMDB struct {
+	reader *maxminddb.Reader
+}
+
+// geoRecord 只解码定向需要的字段
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// OpenMMDB 打开 .mmdb 文件
+func OpenMMDB(path string) (*MMDB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("targeting: failed to open GeoIP database %s: %w", path, err)
+	}
+	return &MMDB{reader: reader}, nil
+}
+
+func (m *MMDB) Lookup(ip net.IP) (string, string, error) {
+	var rec geoRecord
+	if err := m.reader.Lookup(ip, &rec); err != nil {
+		return "", "", err
+	}
+	country := rec.Country.ISOCode
+	region := ""
+	// 第一级行政区 (省、州)
+	if country != "" && len(rec.Subdivisions) > 0 && rec.Subdivisions[0].ISOCode != "" {
+		region = country + "-" + rec.Subdivisions[0].ISOCode
+	}
+	return country, region, nil
+}
+
+// DatabaseType 返回数据库类型，如 GeoLite2-Country
+func (m *MMDB) DatabaseType() string {
+	return m.reader.Metadata.DatabaseType
+}
+
+// Close 关闭数据库文件
+func (m *MMDB) Close() error {
+	return m.reader.Close()
+}
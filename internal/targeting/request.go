@@ -0,0 +1,120 @@
+package targeting
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"advertisement/internal/models"
+)
+
+// maxAcceptLanguages Accept-Language 中最多使用的语言个数
+const maxAcceptLanguages = 10
+
+// ParseUserAgent 根据 User-Agent 判断设备类型和操作系统，无法判断的返回空字符串
+func ParseUserAgent(ua string) (device, os string) {
+	ua = strings.ToLower(ua)
+	if ua == "" {
+		return "", ""
+	}
+
+	// iPhone / iPad 的 User-Agent 中也有 "like Mac OS X"，Android 的 User-Agent 中也有 "Linux"，先判断移动系统
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		os = models.OSIOS
+	case strings.Contains(ua, "android"):
+		os = models.OSAndroid
+	case strings.Contains(ua, "windows phone"):
+		// 不属于支持定向的操作系统
+	case strings.Contains(ua, "windows"):
+		os = models.OSWindows
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os x"):
+		os = models.OSMacOS
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "x11"):
+		os = models.OSLinux
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || strings.Contains(ua, "kindle") || strings.Contains(ua, "silk/"):
+		device = models.DeviceTablet
+	case os == models.OSAndroid && !strings.Contains(ua, "mobile"):
+		// Android 平板的 User-Agent 中没有 "Mobile"
+		device = models.DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod") || strings.Contains(ua, "windows phone"):
+		device = models.DeviceMobile
+	case os == models.OSWindows || os == models.OSMacOS || os == models.OSLinux:
+		device = models.DeviceDesktop
+	}
+	return device, os
+}
+
+// ParseAcceptLanguage 解析 Accept-Language，返回按 q 值从高到低排序的小写语言标签。
+// 忽略 "*"、q=0 和格式无效的项
+func ParseAcceptLanguage(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "*" || !validLanguageTag(tag) {
+			continue
+		}
+		q := 1.0
+		if p := strings.TrimSpace(params); p != "" {
+			name, value, ok := strings.Cut(p, "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if q == 0 {
+			continue
+		}
+		langs = append(langs, lang{tag, q})
+		if len(langs) == maxAcceptLanguages {
+			break
+		}
+	}
+	if len(langs) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(langs, func(a, b lang) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// validLanguageTag 小写的 BCP 47 语言标签：2-3 个字母的主标签，后面是 1-8 个字母或数字组成的子标签，如 zh、en-us、zh-hant-tw
+func validLanguageTag(tag string) bool {
+	parts := strings.Split(tag, "-")
+	if len(parts[0]) < 2 || len(parts[0]) > 3 {
+		return false
+	}
+	for i, p := range parts {
+		if len(p) < 1 || len(p) > 8 {
+			return false
+		}
+		for _, c := range p {
+			if !(c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+// Package targeting 实现广告活动的定向：从请求中识别访问者 (国家/地区、设备、操作系统、语言、当地时间)，
+// 并判断访问者是否满足活动的定向条件 (models.CampaignTargeting)。
+//
+// 国家和地区来自本地的 GeoIP 数据库 (MaxMind .mmdb 格式，见 targeting.geoip_db)，
+// 设备和操作系统由 User-Agent 判断，语言来自 Accept-Language，投放时段按 targeting.timezone 配置的时区判断。
+package targeting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"advertisement/internal/models"
+)
+
+// 每个维度最多允许的值的个数
+const (
+	maxGeoValues      = 250
+	maxLanguageValues = 50
+	maxDayparts       = 24
+)
+
+// ErrGeoUnavailable 没有配置 GeoIP 数据库，不能按国家或地区定向
+var ErrGeoUnavailable = errors.New("未配置 GeoIP 数据库，不能按国家或地区定向")
+
+// Viewer 一次广告请求的访问者属性，无法识别的属性为空
+type Viewer struct {
+	Country   string   // ISO 3166-1 国家代码 (大写)
+	Region    string   // ISO 3166-2 地区代码 (大写，如 CN-GD)
+	Device    string   // desktop | mobile | tablet
+	OS        string   // windows | macos | linux | ios | android
+	Languages []string // 小写的语言标签，按偏好排序
+	Weekday   time.Weekday
+	Hour      int // 0-23
+}
+
+// Targeter 识别访问者并校验定向条件，可以安全地并发使用
+type Targeter struct {
+	geo      GeoResolver // 为 nil 时不识别国家和地区
+	location *time.Location
+}
+
+// New 创建 Targeter。geo 为 nil 表示没有 GeoIP 数据库；loc 是投放时段使用的时区，为 nil 时使用 UTC
+func New(geo GeoResolver, loc *time.Location) *Targeter {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Targeter{geo: geo, location: loc}
+}
+
+// GeoEnabled 是否可以识别访问者的国家和地区
+func (t *Targeter) GeoEnabled() bool {
+	return t.geo != nil
+}
+
+// Viewer 识别发起请求 r 的访问者。clientIP 为客户端 IP (见 middleware.ClientIPFromRequest)，now 为请求时间
+func (t *Targeter) Viewer(r *http.Request, clientIP string, now time.Time) *Viewer {
+	v := &Viewer{Languages: ParseAcceptLanguage(r.Header.Get("Accept-Language"))}
+	v.Device, v.OS = ParseUserAgent(r.UserAgent())
+	local := now.In(t.location)
+	v.Weekday, v.Hour = local.Weekday(), local.Hour()
+
+	if t.geo != nil {
+		if ip := net.ParseIP(clientIP); ip != nil {
+			country, region, err := t.geo.Lookup(ip)
+			if err != nil {
+				// 查询失败时按未知处理，只投放不限国家和地区的活动
+				log.Printf("targeting: GeoIP 查询 %s 失败: %v", clientIP, err)
+			} else {
+				v.Country, v.Region = country, region
+			}
+		}
+	}
+	return v
+}
+
+// Normalize 校验定向条件并转换为规范形式 (代码大小写统一、去重、排序)。
+// 返回的错误信息可以直接展示给用户
+func (t *Targeter) Normalize(c *models.CampaignTargeting) error {
+	if (len(c.Countries) > 0 || len(c.Regions) > 0) && t.geo == nil {
+		return ErrGeoUnavailable
+	}
+
+	var err error
+	if c.Countries, err = normalizeCodes(c.Countries, "countries", maxGeoValues, strings.ToUpper, validCountry); err != nil {
+		return err
+	}
+	if c.Regions, err = normalizeCodes(c.Regions, "regions", maxGeoValues, strings.ToUpper, validRegion); err != nil {
+		return err
+	}
+	if c.Devices, err = normalizeCodes(c.Devices, "devices", len(devices), strings.ToLower, func(s string) bool {
+		return slices.Contains(devices, s)
+	}); err != nil {
+		return err
+	}
+	if c.OS, err = normalizeCodes(c.OS, "os", len(operatingSystems), strings.ToLower, func(s string) bool {
+		return slices.Contains(operatingSystems, s)
+	}); err != nil {
+		return err
+	}
+	if c.Languages, err = normalizeCodes(c.Languages, "languages", maxLanguageValues, strings.ToLower, validLanguageTag); err != nil {
+		return err
+	}
+
+	if len(c.Dayparts) > maxDayparts {
+		return fmt.Errorf("dayparts 最多 %d 个", maxDayparts)
+	}
+	for i := range c.Dayparts {
+		d := &c.Dayparts[i]
+		if d.StartHour < 0 || d.StartHour > 23 || d.EndHour < 1 || d.EndHour > 24 || d.StartHour >= d.EndHour {
+			return fmt.Errorf("dayparts[%d]: start_hour 必须在 0-23 之间、end_hour 必须在 1-24 之间，且 start_hour 小于 end_hour", i)
+		}
+		for _, day := range d.Days {
+			if day < 0 || day > 6 {
+				return fmt.Errorf("dayparts[%d]: days 必须在 0 (周日) 到 6 (周六) 之间", i)
+			}
+		}
+		slices.Sort(d.Days)
+		d.Days = slices.Compact(d.Days)
+		if len(d.Days) == 7 {
+			d.Days = nil // 每天
+		}
+	}
+	if len(c.Dayparts) == 0 {
+		c.Dayparts = nil
+	}
+	return nil
+}
+
+// normalizeCodes 转换大小写后校验每个值，去重并排序；空列表返回 nil
+func normalizeCodes(values []string, field string, limit int, fold func(string) string, valid func(string) bool) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		v = fold(strings.TrimSpace(v))
+		if !valid(v) {
+			return nil, fmt.Errorf("%s 中的 %q 无效", field, v)
+		}
+		out = append(out, v)
+	}
+	slices.Sort(out)
+	out = slices.Compact(out)
+	if len(out) > limit {
+		return nil, fmt.Errorf("%s 最多 %d 个", field, limit)
+	}
+	return out, nil
+}
+
+var (
+	devices          = []string{models.DeviceDesktop, models.DeviceMobile, models.DeviceTablet}
+	operatingSystems = []string{models.OSWindows, models.OSMacOS, models.OSLinux, models.OSIOS, models.OSAndroid}
+)
+
+// validCountry 两个大写字母
+func validCountry(s string) bool {
+	return len(s) == 2 && isUpperAlpha(s)
+}
+
+// validRegion 国家代码 + "-" + 1 到 3 个大写字母或数字，如 CN-GD、US-CA、FR-75
+func validRegion(s string) bool {
+	country, sub, ok := strings.Cut(s, "-")
+	if !ok || !validCountry(country) || len(sub) < 1 || len(sub) > 3 {
+		return false
+	}
+	for _, c := range sub {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isUpperAlpha(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches 检查访问者是否满足定向条件。没有定向条件的活动面向所有访问者；
+// v 为 nil (无法识别访问者) 时只匹配没有定向条件的活动
+func Matches(c *models.CampaignTargeting, v *Viewer) bool {
+	if c.IsZero() {
+		return true
+	}
+	if v == nil {
+		return false
+	}
+	if len(c.Countries) > 0 || len(c.Regions) > 0 {
+		if !(v.Country != "" && slices.Contains(c.Countries, v.Country)) &&
+			!(v.Region != "" && slices.Contains(c.Regions, v.Region)) {
+			return false
+		}
+	}
+	if len(c.Devices) > 0 && !slices.Contains(c.Devices, v.Device) {
+		return false
+	}
+	if len(c.OS) > 0 && !slices.Contains(c.OS, v.OS) {
+		return false
+	}
+	if len(c.Languages) > 0 && !matchLanguages(c.Languages, v.Languages) {
+		return false
+	}
+	if len(c.Dayparts) > 0 && !matchDayparts(c.Dayparts, v.Weekday, v.Hour) {
+		return false
+	}
+	return true
+}
+
+// matchLanguages 访问者接受的任一语言与定向语言相同，或是定向语言的子标签 (定向 zh 匹配 zh-cn)
+func matchLanguages(targets, accepted []string) bool {
+	for _, lang := range accepted {
+		for _, t := range targets {
+			if lang == t || strings.HasPrefix(lang, t) && lang[len(t)] == '-' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchDayparts(parts []models.Daypart, day time.Weekday, hour int) bool {
+	for _, p := range parts {
+		if hour < p.StartHour || hour >= p.EndHour {
+			continue
+		}
+		if len(p.Days) == 0 || slices.Contains(p.Days, int(day)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package targeting_test
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/targeting"
+)
+
+// fakeGeo 按 IP 返回固定的国家和地区
+type fakeGeo map[string][2]string
+
+func (g fakeGeo) Lookup(ip net.IP) (string, string, error) {
+	r, ok := g[ip.String()]
+	if !ok {
+		return "", "", errors.New("not found")
+	}
+	return r[0], r[1], nil
+}
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		ua         string
+		wantDevice string
+		wantOS     string
+	}{
+		{"empty", "", "", ""},
+		{"windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", models.DeviceDesktop, models.OSWindows},
+		{"macos", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15", models.DeviceDesktop, models.OSMacOS},
+		{"linux", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", models.DeviceDesktop, models.OSLinux},
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", models.DeviceMobile, models.OSIOS},
+		{"ipad", "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", models.DeviceTablet, models.OSIOS},
+		{"android phone", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", models.DeviceMobile, models.OSAndroid},
+		{"android tablet", "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", models.DeviceTablet, models.OSAndroid},
+		{"windows phone", "Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Lumia 950) Mobile Safari/537.36", models.DeviceMobile, models.OSAndroid},
+		{"unknown", "curl/8.4.0", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, os := targeting.ParseUserAgent(tt.ua)
+			if device != tt.wantDevice || os != tt.wantOS {
+				t.Errorf("ParseUserAgent() = %q, %q, want %q, %q", device, os, tt.wantDevice, tt.wantOS)
+			}
+		})
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", nil},
+		{"zh-CN", []string{"zh-cn"}},
+		{"en-US,en;q=0.9,zh-CN;q=0.8", []string{"en-us", "en", "zh-cn"}},
+		// 按 q 值排序，q 相同时保持原顺序
+		{"fr;q=0.5, de, ja;q=0.5", []string{"de", "fr", "ja"}},
+		// 忽略 "*"、q=0 和格式无效的项
+		{"*, en;q=0, x, zh;q=abc, toolonglanguage, ko;q=0.1", []string{"ko"}},
+	}
+	for _, tt := range tests {
+		if got := targeting.ParseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	withGeo := targeting.New(fakeGeo{}, time.UTC)
+	tests := []struct {
+		name    string
+		noGeo   bool
+		in      models.CampaignTargeting
+		want    models.CampaignTargeting
+		wantErr bool
+	}{
+		{name: "empty", in: models.CampaignTargeting{}, want: models.CampaignTargeting{}},
+		{
+			name: "case, duplicates and order",
+			in: models.CampaignTargeting{
+				Countries: []string{"us", " CN", "US"}, Regions: []string{"cn-gd"},
+				Devices: []string{"Mobile", "desktop"}, OS: []string{"iOS"}, Languages: []string{"zh-CN", "EN"},
+			},
+			want: models.CampaignTargeting{
+				Countries: []string{"CN", "US"}, Regions: []string{"CN-GD"},
+				Devices: []string{"desktop", "mobile"}, OS: []string{"ios"}, Languages: []string{"en", "zh-cn"},
+			},
+		},
+		{
+			name: "dayparts",
+			in:   models.CampaignTargeting{Dayparts: []models.Daypart{{Days: []int{5, 1, 1}, StartHour: 9, EndHour: 18}, {Days: []int{0, 1, 2, 3, 4, 5, 6}, StartHour: 20, EndHour: 24}}},
+			want: models.CampaignTargeting{Dayparts: []models.Daypart{{Days: []int{1, 5}, StartHour: 9, EndHour: 18}, {StartHour: 20, EndHour: 24}}},
+		},
+		{name: "country without geoip", noGeo: true, in: models.CampaignTargeting{Countries: []string{"CN"}}, wantErr: true},
+		{name: "devices without geoip", noGeo: true, in: models.CampaignTargeting{Devices: []string{"tablet"}}, want: models.CampaignTargeting{Devices: []string{"tablet"}}},
+		{name: "invalid country", in: models.CampaignTargeting{Countries: []string{"CHN"}}, wantErr: true},
+		{name: "invalid region", in: models.CampaignTargeting{Regions: []string{"CN-GUANGDONG"}}, wantErr: true},
+		{name: "unknown device", in: models.CampaignTargeting{Devices: []string{"tv"}}, wantErr: true},
+		{name: "unknown os", in: models.CampaignTargeting{OS: []string{"beos"}}, wantErr: true},
+		{name: "invalid language", in: models.CampaignTargeting{Languages: []string{"chinese"}}, wantErr: true},
+		{name: "empty hours", in: models.CampaignTargeting{Dayparts: []models.Daypart{{StartHour: 10, EndHour: 10}}}, wantErr: true},
+		{name: "hour out of range", in: models.CampaignTargeting{Dayparts: []models.Daypart{{StartHour: 0, EndHour: 25}}}, wantErr: true},
+		{name: "invalid day", in: models.CampaignTargeting{Dayparts: []models.Daypart{{Days: []int{7}, StartHour: 0, EndHour: 24}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := withGeo
+			if tt.noGeo {
+				tg = targeting.New(nil, time.UTC)
+			}
+			got := tt.in
+			err := tg.Normalize(&got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Normalize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestViewer(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	tg := targeting.New(fakeGeo{"203.0.113.7": {"CN", "CN-GD"}}, shanghai)
+	r := httptest.NewRequest("GET", "/get-ad", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148")
+	r.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
+	now := time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC) // 上海时间周日 7:30
+
+	v := tg.Viewer(r, "203.0.113.7", now)
+	want := &targeting.Viewer{Country: "CN", Region: "CN-GD", Device: models.DeviceMobile, OS: models.OSIOS,
+		Languages: []string{"zh-cn", "zh"}, Weekday: time.Sunday, Hour: 7}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Viewer() = %+v, want %+v", v, want)
+	}
+
+	// GeoIP 查不到时国家和地区为空，其他属性不受影响
+	if v := tg.Viewer(r, "198.51.100.1", now); v.Country != "" || v.Region != "" || v.OS != models.OSIOS {
+		t.Errorf("Viewer() for unknown ip = %+v", v)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	viewer := &targeting.Viewer{Country: "CN", Region: "CN-GD", Device: models.DeviceMobile, OS: models.OSAndroid,
+		Languages: []string{"zh-cn", "en"}, Weekday: time.Monday, Hour: 9}
+	tests := []struct {
+		name   string
+		c      models.CampaignTargeting
+		viewer *targeting.Viewer
+		want   bool
+	}{
+		{"no targeting", models.CampaignTargeting{}, viewer, true},
+		{"no targeting, unknown viewer", models.CampaignTargeting{}, nil, true},
+		{"targeting, unknown viewer", models.CampaignTargeting{Devices: []string{"mobile"}}, nil, false},
+		{"country", models.CampaignTargeting{Countries: []string{"US", "CN"}}, viewer, true},
+		{"other country", models.CampaignTargeting{Countries: []string{"US"}}, viewer, false},
+		// 国家和地区任一匹配即可
+		{"region", models.CampaignTargeting{Countries: []string{"US"}, Regions: []string{"CN-GD"}}, viewer, true},
+		{"other region", models.CampaignTargeting{Regions: []string{"CN-BJ"}}, viewer, false},
+		{"country of unknown viewer", models.CampaignTargeting{Countries: []string{"CN"}}, &targeting.Viewer{}, false},
+		{"device and os", models.CampaignTargeting{Devices: []string{"mobile", "tablet"}, OS: []string{"android"}}, viewer, true},
+		{"other device", models.CampaignTargeting{Devices: []string{"desktop"}}, viewer, false},
+		{"other os", models.CampaignTargeting{OS: []string{"ios"}}, viewer, false},
+		{"language prefix", models.CampaignTargeting{Languages: []string{"zh"}}, viewer, true},
+		{"secondary language", models.CampaignTargeting{Languages: []string{"en"}}, viewer, true},
+		{"not a language prefix", models.CampaignTargeting{Languages: []string{"z"}}, viewer, false},
+		{"other language", models.CampaignTargeting{Languages: []string{"ja"}}, viewer, false},
+		{"daypart", models.CampaignTargeting{Dayparts: []models.Daypart{{Days: []int{1, 2}, StartHour: 9, EndHour: 12}}}, viewer, true},
+		{"daypart every day", models.CampaignTargeting{Dayparts: []models.Daypart{{StartHour: 0, EndHour: 10}}}, viewer, true},
+		{"daypart end exclusive", models.CampaignTargeting{Dayparts: []models.Daypart{{StartHour: 0, EndHour: 9}}}, viewer, false},
+		{"daypart other day", models.CampaignTargeting{Dayparts: []models.Daypart{{Days: []int{0, 6}, StartHour: 0, EndHour: 24}}}, viewer, false},
+		{"all dimensions must match", models.CampaignTargeting{Countries: []string{"CN"}, Devices: []string{"desktop"}}, viewer, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targeting.Matches(&tt.c, tt.viewer); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+)
+
+// MemoryCounter 进程内的 Counter 实现 (login_throttle.backend=memory)。
+// 不写数据库，但计数不在多个实例之间共享，重启后清零
+type MemoryCounter struct {
+	mu        sync.Mutex
+	attempts  map[string]*models.LoginAttempt
+	lastSweep time.Time
+}
+
+// sweepInterval 清理过期记录的最小间隔
+const sweepInterval = time.Minute
+
+// NewMemoryCounter 创建空的 MemoryCounter
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{attempts: make(map[string]*models.LoginAttempt)}
+}
+
+func (c *MemoryCounter) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a, ok := c.attempts[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyAttempt(a), nil
+}
+
+func (c *MemoryCounter) RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweepLocked(now)
+	a, ok := c.attempts[key]
+	if !ok || !now.Before(a.ExpiresAt) {
+		a = &models.LoginAttempt{Key: key}
+		c.attempts[key] = a
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	if expiresAt := now.Add(window); expiresAt.After(a.ExpiresAt) {
+		a.ExpiresAt = expiresAt
+	}
+	return copyAttempt(a), nil
+}
+
+func (c *MemoryCounter) LockLogin(ctx context.Context, key string, until time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a, ok := c.attempts[key]
+	if !ok {
+		return store.ErrNotFound
+	}
+	lockedUntil := until
+	a.LockedUntil = &lockedUntil
+	if until.After(a.ExpiresAt) {
+		a.ExpiresAt = until
+	}
+	return nil
+}
+
+func (c *MemoryCounter) ResetLoginAttempts(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.attempts, key)
+	return nil
+}
+
+// sweepLocked 删除过期的记录，避免大量不同的用户名 / IP 占满内存。调用方必须持有锁
+func (c *MemoryCounter) sweepLocked(now time.Time) {
+	if now.Sub(c.lastSweep) < sweepInterval {
+		return
+	}
+	c.lastSweep = now
+	for key, a := range c.attempts {
+		if !now.Before(a.ExpiresAt) {
+			delete(c.attempts, key)
+		}
+	}
+}
+
+func copyAttempt(a *models.LoginAttempt) *models.LoginAttempt {
+	result := *a
+	if a.LockedUntil != nil {
+		lockedUntil := *a.LockedUntil
+		result.LockedUntil = &lockedUntil
+	}
+	return &result
+}
+
+// --- 确认实现了 Counter 接口 ---
+var (
+	_ Counter = (*MemoryCounter)(nil)
+	_ Counter = (store.Store)(nil)
+)
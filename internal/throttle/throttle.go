@@ -0,0 +1,211 @@
+// Package throttle 实现登录限流：按用户名和客户端 IP 分别统计连续失败次数，
+// 超过免费次数后按指数退避拒绝尝试，达到阈值后临时锁定。
+//
+// 计数器通过 Counter 接口存取，可以使用数据库 (store.Store 实现了它，多实例共享) 或
+// 进程内存 (MemoryCounter，单实例部署，不产生数据库写入)。
+package throttle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"advertisement/internal/config"
+	"advertisement/internal/models"
+	"advertisement/internal/store"
+)
+
+// Counter 登录失败计数器的存储，语义见 store.Store 中同名方法。
+// 没有记录时 GetLoginAttempt 和 LockLogin 返回 store.ErrNotFound
+type Counter interface {
+	GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// Policy 一个维度 (用户名或 IP) 的限流策略
+type Policy struct {
+	FreeAttempts     int           // 前 N 次失败不退避
+	LockoutThreshold int           // 连续失败达到该次数后锁定，0 表示不锁定
+	LockoutDuration  time.Duration // 锁定时长
+}
+
+// Decision 限流判断结果
+type Decision struct {
+	Allowed    bool
+	Locked     bool          // true 表示被锁定，false 表示处于退避期
+	RetryAfter time.Duration // 不允许时，多久之后可以重试
+}
+
+// Throttler 按用户名和客户端 IP 两个维度限流
+type Throttler struct {
+	counter   Counter
+	window    time.Duration
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	user      Policy
+	ip        Policy
+}
+
+// New 按 login_throttle 配置创建 Throttler
+func New(counter Counter, cfg config.LoginThrottleConfig) *Throttler {
+	return &Throttler{
+		counter:   counter,
+		window:    cfg.Window.Duration,
+		baseDelay: cfg.BaseDelay.Duration,
+		maxDelay:  cfg.MaxDelay.Duration,
+		user: Policy{
+			FreeAttempts:     cfg.UserFreeAttempts,
+			LockoutThreshold: cfg.UserLockoutThreshold,
+			LockoutDuration:  cfg.UserLockoutDuration.Duration,
+		},
+		ip: Policy{
+			FreeAttempts:     cfg.IPFreeAttempts,
+			LockoutThreshold: cfg.IPLockoutThreshold,
+			LockoutDuration:  cfg.IPLockoutDuration.Duration,
+		},
+	}
+}
+
+// UserKey 返回用户名维度的计数 key (不区分大小写，过长的用户名取哈希，保证不超过 login_attempts.throttle_key 的长度)
+func UserKey(username string) string {
+	return key("user:", strings.ToLower(strings.TrimSpace(username)))
+}
+
+// IPKey 返回客户端 IP 维度的计数 key
+func IPKey(ip string) string {
+	return key("ip:", ip)
+}
+
+func key(prefix, value string) string {
+	if len(prefix)+len(value) > 191 {
+		sum := sha256.Sum256([]byte(value))
+		return prefix + "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return prefix + value
+}
+
+// Check 判断 username / ip 现在是否允许尝试登录 (为空的维度跳过)，返回两个维度中更严格的结果
+func (t *Throttler) Check(ctx context.Context, username, ip string, now time.Time) (Decision, error) {
+	result := Decision{Allowed: true}
+	for _, d := range t.dimensions(username, ip) {
+		a, err := t.counter.GetLoginAttempt(ctx, d.key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return Decision{Allowed: true}, err
+		}
+		result = stricter(result, t.decide(a, d.policy, now))
+	}
+	return result, nil
+}
+
+// Fail 记录一次失败，达到锁定阈值时锁定并记录日志。返回下一次尝试的限流结果
+func (t *Throttler) Fail(ctx context.Context, username, ip string, now time.Time) (Decision, error) {
+	result := Decision{Allowed: true}
+	for _, d := range t.dimensions(username, ip) {
+		a, err := t.counter.RecordLoginFailure(ctx, d.key, now, t.window)
+		if err != nil {
+			return result, err
+		}
+		if d.policy.LockoutThreshold > 0 && a.Failures >= d.policy.LockoutThreshold &&
+			(a.LockedUntil == nil || !a.LockedUntil.After(now)) {
+			until := now.Add(d.policy.LockoutDuration)
+			if err := t.counter.LockLogin(ctx, d.key, until); err != nil {
+				return result, err
+			}
+			a.LockedUntil = &until
+			log.Printf("throttle: %s 连续登录失败 %d 次，锁定到 %s", d.key, a.Failures, until.Format(time.RFC3339))
+		}
+		result = stricter(result, t.decide(a, d.policy, now))
+	}
+	return result, nil
+}
+
+// Succeed 登录成功后清除用户名维度的失败记录 (IP 维度不清除，避免攻击者用自己的账号重置计数)
+func (t *Throttler) Succeed(ctx context.Context, username string) error {
+	return t.counter.ResetLoginAttempts(ctx, UserKey(username))
+}
+
+// Status 返回用户名维度的失败记录，没有记录或已过期时返回 nil
+func (t *Throttler) Status(ctx context.Context, username string, now time.Time) (*models.LoginAttempt, error) {
+	a, err := t.counter.GetLoginAttempt(ctx, UserKey(username))
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !now.Before(a.ExpiresAt)) {
+		return nil, nil
+	}
+	return a, err
+}
+
+// Unlock 管理员解锁账号：清除用户名维度的失败记录和锁定
+func (t *Throttler) Unlock(ctx context.Context, username string) error {
+	return t.counter.ResetLoginAttempts(ctx, UserKey(username))
+}
+
+type dimension struct {
+	key    string
+	policy Policy
+}
+
+func (t *Throttler) dimensions(username, ip string) []dimension {
+	var ds []dimension
+	if username != "" {
+		ds = append(ds, dimension{key: UserKey(username), policy: t.user})
+	}
+	if ip != "" {
+		ds = append(ds, dimension{key: IPKey(ip), policy: t.ip})
+	}
+	return ds
+}
+
+// decide 根据失败记录判断现在是否允许尝试
+func (t *Throttler) decide(a *models.LoginAttempt, p Policy, now time.Time) Decision {
+	if !now.Before(a.ExpiresAt) {
+		return Decision{Allowed: true}
+	}
+	if a.LockedUntil != nil && a.LockedUntil.After(now) {
+		return Decision{Locked: true, RetryAfter: a.LockedUntil.Sub(now)}
+	}
+	next := a.LastFailureAt.Add(t.delay(a.Failures, p))
+	if now.Before(next) {
+		return Decision{RetryAfter: next.Sub(now)}
+	}
+	return Decision{Allowed: true}
+}
+
+// delay 第 failures 次失败之后需要等待的时间：不超过免费次数时为 0，
+// 之后从 baseDelay 开始每次翻倍，最多 maxDelay
+func (t *Throttler) delay(failures int, p Policy) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	d := t.baseDelay
+	for i := p.FreeAttempts + 1; i < failures && d < t.maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, t.maxDelay)
+}
+
+// stricter 返回两个结果中更严格的一个
+func stricter(a, b Decision) Decision {
+	switch {
+	case a.Allowed:
+		return b
+	case b.Allowed:
+		return a
+	case a.Locked != b.Locked:
+		if a.Locked {
+			return a
+		}
+		return b
+	case b.RetryAfter > a.RetryAfter:
+		return b
+	default:
+		return a
+	}
+}
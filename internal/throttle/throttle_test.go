@@ -0,0 +1,230 @@
+package throttle_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"advertisement/internal/config"
+	"advertisement/internal/migrate"
+	"advertisement/internal/store"
+	"advertisement/internal/throttle"
+)
+
+// counters 每个用例都在这些 Counter 实现上运行，行为必须一致
+var counters = []struct {
+	name string
+	open func(t *testing.T) throttle.Counter
+}{
+	{"Memory", func(*testing.T) throttle.Counter { return throttle.NewMemoryCounter() }},
+	{"MemStore", func(*testing.T) throttle.Counter { return store.NewMemStore() }},
+	{"SQLite", func(t *testing.T) throttle.Counter {
+		db, err := store.OpenSQLite(filepath.Join(t.TempDir(), "throttle.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		migrator, err := migrate.New(db, "sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return store.NewSQLiteStore(db)
+	}},
+}
+
+// testConfig 前 3 次失败不退避，之后 1s 起翻倍 (最多 8s)，用户名连续失败 6 次锁定 15 分钟；
+// IP 前 5 次不退避，10 次锁定 1 小时
+func testConfig() config.LoginThrottleConfig {
+	return config.LoginThrottleConfig{
+		Window:               config.Duration{Duration: time.Hour},
+		BaseDelay:            config.Duration{Duration: time.Second},
+		MaxDelay:             config.Duration{Duration: 8 * time.Second},
+		UserFreeAttempts:     3,
+		UserLockoutThreshold: 6,
+		UserLockoutDuration:  config.Duration{Duration: 15 * time.Minute},
+		IPFreeAttempts:       5,
+		IPLockoutThreshold:   10,
+		IPLockoutDuration:    config.Duration{Duration: time.Hour},
+	}
+}
+
+func forEachCounter(t *testing.T, fn func(t *testing.T, th *throttle.Throttler)) {
+	for _, c := range counters {
+		t.Run(c.name, func(t *testing.T) {
+			fn(t, throttle.New(c.open(t), testConfig()))
+		})
+	}
+}
+
+// start 测试使用的起始时间 (数据库只保存到秒)
+var start = time.Now().Truncate(time.Second)
+
+func check(t *testing.T, th *throttle.Throttler, username, ip string, at time.Time, want throttle.Decision) {
+	t.Helper()
+	got, err := th.Check(context.Background(), username, ip, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("Check(%q, %q) at +%s = %+v, want %+v", username, ip, at.Sub(start), got, want)
+	}
+}
+
+func fail(t *testing.T, th *throttle.Throttler, username, ip string, at time.Time) throttle.Decision {
+	t.Helper()
+	d, err := th.Fail(context.Background(), username, ip, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestBackoff(t *testing.T) {
+	forEachCounter(t, func(t *testing.T, th *throttle.Throttler) {
+		// 每次失败后的等待时间：免费次数内为 0，之后指数退避，封顶 max_delay；第 6 次锁定
+		wantDelays := []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 15 * time.Minute}
+		at := start
+		for i, want := range wantDelays {
+			d := fail(t, th, "alice", "", at)
+			if want == 0 {
+				if !d.Allowed {
+					t.Fatalf("failure %d: %+v, want allowed", i+1, d)
+				}
+				continue
+			}
+			if d.Allowed || d.RetryAfter != want || d.Locked != (i+1 == 6) {
+				t.Fatalf("failure %d: %+v, want retry after %s", i+1, d, want)
+			}
+			check(t, th, "alice", "", at.Add(want-time.Second), throttle.Decision{Locked: d.Locked, RetryAfter: time.Second})
+			at = at.Add(want)
+			check(t, th, "alice", "", at, throttle.Decision{Allowed: true})
+		}
+		// 用户名不区分大小写，其他用户名不受影响
+		check(t, th, " ALICE", "", at.Add(-time.Minute), throttle.Decision{Locked: true, RetryAfter: time.Minute})
+		check(t, th, "bob", "", at.Add(-time.Minute), throttle.Decision{Allowed: true})
+	})
+}
+
+func TestMaxDelay(t *testing.T) {
+	cfg := testConfig()
+	cfg.UserLockoutThreshold = 0 // 不锁定
+	th := throttle.New(throttle.NewMemoryCounter(), cfg)
+	at := start
+	for i := 1; i <= 10; i++ {
+		d := fail(t, th, "alice", "", at)
+		want := map[int]time.Duration{4: time.Second, 5: 2 * time.Second, 6: 4 * time.Second}[i]
+		if i > 6 {
+			want = 8 * time.Second
+		}
+		if d.Locked || d.RetryAfter != want {
+			t.Fatalf("failure %d: %+v, want retry after %s", i, d, want)
+		}
+		at = at.Add(want)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	forEachCounter(t, func(t *testing.T, th *throttle.Throttler) {
+		ctx := context.Background()
+		for i := 0; i < 6; i++ {
+			fail(t, th, "alice", "", start)
+		}
+		locked := throttle.Decision{Locked: true, RetryAfter: 15 * time.Minute}
+		check(t, th, "alice", "", start, locked)
+		status, err := th.Status(ctx, "alice", start)
+		if err != nil || status == nil || status.Failures != 6 || status.LockedUntil == nil || !status.LockedUntil.Equal(start.Add(15*time.Minute)) {
+			t.Fatalf("Status() = %+v, %v", status, err)
+		}
+
+		// 锁定结束后再失败一次立即重新锁定
+		after := start.Add(15 * time.Minute)
+		check(t, th, "alice", "", after, throttle.Decision{Allowed: true})
+		if d := fail(t, th, "alice", "", after); d != locked {
+			t.Fatalf("failure after lockout = %+v, want %+v", d, locked)
+		}
+
+		// 管理员解锁清除失败记录
+		if err := th.Unlock(ctx, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, th, "alice", "", after, throttle.Decision{Allowed: true})
+		if status, err := th.Status(ctx, "alice", after); err != nil || status != nil {
+			t.Fatalf("Status() after unlock = %+v, %v, want nil", status, err)
+		}
+		if d := fail(t, th, "alice", "", after); !d.Allowed {
+			t.Fatalf("first failure after unlock = %+v, want allowed", d)
+		}
+	})
+}
+
+func TestWindow(t *testing.T) {
+	forEachCounter(t, func(t *testing.T, th *throttle.Throttler) {
+		for i := 0; i < 5; i++ {
+			fail(t, th, "alice", "", start)
+		}
+		// 最后一次失败一个窗口之后记录失效，重新计数
+		expired := start.Add(time.Hour)
+		check(t, th, "alice", "", expired, throttle.Decision{Allowed: true})
+		if status, err := th.Status(context.Background(), "alice", expired); err != nil || status != nil {
+			t.Fatalf("Status() = %+v, %v, want nil", status, err)
+		}
+		if d := fail(t, th, "alice", "", expired); !d.Allowed {
+			t.Fatalf("failure after window = %+v, want allowed", d)
+		}
+		status, err := th.Status(context.Background(), "alice", expired)
+		if err != nil || status == nil || status.Failures != 1 {
+			t.Fatalf("Status() = %+v, %v, want 1 failure", status, err)
+		}
+	})
+}
+
+func TestIPDimension(t *testing.T) {
+	forEachCounter(t, func(t *testing.T, th *throttle.Throttler) {
+		ctx := context.Background()
+		// 同一个 IP 每次尝试不同的用户名：用户名维度不会退避，IP 维度累计
+		for i := 0; i < 10; i++ {
+			fail(t, th, "user"+string(rune('a'+i)), "192.0.2.1", start)
+		}
+		check(t, th, "other", "192.0.2.1", start, throttle.Decision{Locked: true, RetryAfter: time.Hour})
+		check(t, th, "other", "192.0.2.2", start, throttle.Decision{Allowed: true})
+
+		// 登录成功只清除用户名维度，不能用来重置 IP 维度
+		if err := th.Succeed(ctx, "usera"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, th, "usera", "192.0.2.1", start, throttle.Decision{Locked: true, RetryAfter: time.Hour})
+		check(t, th, "usera", "", start, throttle.Decision{Allowed: true})
+	})
+}
+
+func TestStricter(t *testing.T) {
+	forEachCounter(t, func(t *testing.T, th *throttle.Throttler) {
+		// 用户名维度退避 2s，IP 维度锁定：返回锁定
+		for i := 0; i < 5; i++ {
+			fail(t, th, "alice", "192.0.2.1", start)
+		}
+		for i := 0; i < 5; i++ {
+			fail(t, th, "bob", "192.0.2.1", start)
+		}
+		check(t, th, "alice", "192.0.2.1", start, throttle.Decision{Locked: true, RetryAfter: time.Hour})
+		check(t, th, "alice", "192.0.2.9", start, throttle.Decision{RetryAfter: 2 * time.Second})
+	})
+}
+
+func TestKeys(t *testing.T) {
+	if throttle.UserKey(" Alice ") != throttle.UserKey("alice") {
+		t.Error("UserKey is case or space sensitive")
+	}
+	if throttle.UserKey("alice") == throttle.IPKey("alice") {
+		t.Error("user and ip keys collide")
+	}
+	long := strings.Repeat("a", 300)
+	if key := throttle.UserKey(long); len(key) > 191 || key == throttle.UserKey(long+"b") {
+		t.Errorf("UserKey(long) = %q", key)
+	}
+}
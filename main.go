@@ -1,26 +1,42 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // targeting.timezone 在没有系统时区数据库的环境 (如精简容器镜像) 中也能使用
 
 	"github.com/rs/cors"
 	_ "github.com/go-sql-driver/mysql"
 
 	// --- 导入内部包 ---
+	"advertisement/internal/auth"
+	"advertisement/internal/config"
+	"advertisement/internal/frequency"
 	"advertisement/internal/handlers"   // 替换 "your_module_name"
+	"advertisement/internal/mail"
 	"advertisement/internal/middleware" // 替换 "your_module_name"
+	"advertisement/internal/migrate"
+	"advertisement/internal/money"
+	"advertisement/internal/payment"
+	"advertisement/internal/scheduler"
+	"advertisement/internal/serving"
 	"advertisement/internal/store"
+	"advertisement/internal/targeting"
+	"advertisement/internal/throttle"
 )
 
-// initDB 函数保持不变
-func initDB() (*sql.DB, error){
-    // ... (和之前一样) ...
-    dsn := "root:123456@tcp(127.0.0.1:3306)/advertisement?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := sql.Open("mysql", dsn)
+// initDB 使用配置中的 DSN 和连接池参数打开 MySQL
+func initDB(cfg config.DatabaseConfig) (*sql.DB, error){
+	db, err := sql.Open("mysql", cfg.DSN)
 	if err != nil {
 		return nil, fmt.Errorf("数据库配置错误: %w", err)
 	}
@@ -30,44 +46,301 @@ func initDB() (*sql.DB, error){
 		return nil, fmt.Errorf("无法连接到数据库: %w", err)
 	}
 	log.Println("数据库连接成功!")
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
 	return db, nil // 返回 db 连接
 }
 
-func main() {
-	// --- 初始化数据库连接 ---
-	db, err := initDB()
+// openDatabase 按 database.driver 打开数据库连接 (mysql 或 sqlite)
+func openDatabase(cfg config.DatabaseConfig) (*sql.DB, error) {
+	switch cfg.Driver {
+	case "mysql":
+		return initDB(cfg)
+	case "sqlite":
+		return store.OpenSQLite(cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("store 类型 %s 不使用数据库", cfg.Driver)
+	}
+}
+
+// runMigrate 执行数据库迁移子命令，执行完毕后直接返回 (不启动 HTTP 服务)
+func runMigrate(cfg config.DatabaseConfig, args []string) {
+	db, err := openDatabase(cfg)
 	if err != nil {
 		log.Fatalf("数据库初始化失败: %v", err)
 	}
-	// 使用 defer db.Close() 确保在 main 退出时关闭数据库连接
-	// 注意：这只在程序正常或 panic 退出时有效，如果是 kill -9 则无效
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Printf("关闭数据库连接时出错: %v", err)
-		} else {
-			log.Println("数据库连接已关闭。")
+	defer db.Close()
+
+	migrator, err := migrate.New(db, cfg.Driver) // 迁移脚本按方言存放: migrations/mysql, migrations/sqlite
+	if err != nil {
+		log.Fatalf("加载迁移脚本失败: %v", err)
+	}
+	if err := migrate.RunCommand(context.Background(), migrator, args); err != nil {
+		log.Fatalf("迁移失败: %v", err)
+	}
+}
+
+// keysReloadInterval 是重新读取 JWT 密钥目录的间隔
+const keysReloadInterval = time.Minute
+
+// shutdownTimeout 收到停止信号后等待处理中的请求结束的最长时间
+const shutdownTimeout = 15 * time.Second
+
+// runKeys 执行密钥子命令: keys rotate 在 jwt.keys_dir 中生成新的签名密钥。
+// 未设置 jwt.active_kid 时，各实例在下一次重新读取密钥目录后改用新密钥签名，旧密钥在 jwt.rotation_overlap 内仍可校验。
+func runKeys(cfg config.JWTConfig, args []string) {
+	if len(args) != 1 || args[0] != "rotate" {
+		log.Fatalf("用法: keys rotate")
+	}
+	if cfg.Algorithm == auth.AlgHS256 {
+		log.Fatalf("jwt.algorithm=HS256 使用 jwt.secret，不需要生成密钥")
+	}
+	kid, err := auth.GenerateKeyFile(cfg.KeysDir, cfg.Algorithm)
+	if err != nil {
+		log.Fatalf("生成密钥失败: %v", err)
+	}
+	log.Printf("已生成新的 %s 签名密钥: %s/%s.pem", cfg.Algorithm, cfg.KeysDir, kid)
+}
+
+// reloadKeysPeriodically 按间隔重新加载 JWT 密钥环，直到 ctx 被取消
+func reloadKeysPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := auth.ReloadKeys(); err != nil {
+				log.Printf("重新加载 JWT 密钥失败 (继续使用原来的密钥): %v", err)
+			}
 		}
-	}()
+	}
+}
+
+// newMailer 按 mail.driver 创建邮件发送渠道
+func newMailer(cfg config.MailConfig) (mail.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		log.Printf("邮件通过 SMTP 服务器 %s:%d 发送", cfg.SMTPHost, cfg.SMTPPort)
+		return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	default:
+		log.Printf("邮件写入目录 %s (.eml 文件，不会真正发出)", cfg.Dir)
+		return mail.NewFileMailer(cfg.Dir, cfg.From)
+	}
+}
+
+// newLoginThrottler 按 login_throttle.backend 选择失败计数器：database 存在 login_attempts 表 (多实例共享)，
+// memory 保存在进程内存中
+func newLoginThrottler(cfg config.LoginThrottleConfig, s store.Store) *throttle.Throttler {
+	var counter throttle.Counter = s
+	if cfg.Backend == "memory" {
+		log.Println("登录失败计数保存在进程内存中 (login_throttle.backend=memory)")
+		counter = throttle.NewMemoryCounter()
+	}
+	return throttle.New(counter, cfg)
+}
+
+func main() {
+	// --- 命令行参数 ---
+	// 所有运行参数 (数据库、端口、JWT 密钥、CORS 等) 都来自配置文件和环境变量，见 config.example.yaml
+	// 配置文件路径也可以通过环境变量 ADV_CONFIG 指定
+	configPath := flag.String("config", os.Getenv("ADV_CONFIG"), "配置文件路径 (.yaml / .yml / .toml)，为空则只使用默认值和环境变量")
+	flag.Parse()
+
+	// --- 加载并校验配置 ---
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("配置无效，拒绝启动:\n%v", err)
+	}
+	log.Printf("配置加载完成 (env=%s, driver=%s)", cfg.Env, cfg.Database.Driver)
+	if err := money.Configure(cfg.Money.Currency, cfg.Money.MinAmount, cfg.Money.MaxAmount); err != nil {
+		log.Fatalf("金额配置无效: %v", err)
+	}
+
+	// --- 子命令: migrate up | down [n] | status；keys rotate ---
+	// 例如: go run . migrate up
+	//       ADV_DB_DRIVER=sqlite go run . migrate status
+	//       go run . keys rotate
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			runMigrate(cfg.Database, args[1:])
+		case "keys":
+			runKeys(cfg.JWT, args[1:])
+		default:
+			log.Fatalf("未知的子命令: %s (可用: migrate, keys)", args[0])
+		}
+		return
+	}
+
+	// --- 加载访问令牌签名密钥 ---
+	// 开发环境下密钥目录为空时自动生成一个密钥；production 必须预先用 "keys rotate" 生成
+	err = auth.Configure(cfg.JWT)
+	if errors.Is(err, auth.ErrNoSigningKey) && !cfg.IsProduction() {
+		kid, genErr := auth.GenerateKeyFile(cfg.JWT.KeysDir, cfg.JWT.Algorithm)
+		if genErr != nil {
+			log.Fatalf("生成 JWT 签名密钥失败: %v", genErr)
+		}
+		log.Printf("开发环境：已在 %s 中生成 JWT 签名密钥 %s", cfg.JWT.KeysDir, kid)
+		err = auth.Configure(cfg.JWT)
+	}
+	if err != nil {
+		log.Fatalf("JWT 密钥配置无效: %v", err)
+	}
 
-	
 	// --- 创建 Store 实例 ---
-	dataStore := store.NewDBStore(db) // 使用 db 创建具体的 DBStore
+	// database.driver=mysql  (默认) 使用 MySQL
+	// database.driver=sqlite 使用 SQLite 文件 (或 :memory:)，启动时自动执行迁移，适合单机部署和 CI
+	// database.driver=memory 使用内存存储，不需要数据库，适合本地开发和演示，重启后数据丢失
+	var dataStore store.Store
+	switch cfg.Database.Driver {
+	case "mysql", "sqlite":
+		// --- 初始化数据库连接 ---
+		db, err := openDatabase(cfg.Database)
+		if err != nil {
+			log.Fatalf("数据库初始化失败: %v", err)
+		}
+		// 使用 defer db.Close() 确保在 main 退出时关闭数据库连接
+		// 注意：这只在程序正常或 panic 退出时有效，如果是 kill -9 则无效
+		defer func() {
+			if err := db.Close(); err != nil {
+				log.Printf("关闭数据库连接时出错: %v", err)
+			} else {
+				log.Println("数据库连接已关闭。")
+			}
+		}()
+		if cfg.Database.Driver == "mysql" {
+			dataStore = store.NewDBStore(db) // 使用 db 创建具体的 DBStore
+		} else {
+			// SQLite 是单机嵌入式数据库，启动时直接把表结构迁移到最新版本
+			migrator, err := migrate.New(db, "sqlite")
+			if err != nil {
+				log.Fatalf("加载迁移脚本失败: %v", err)
+			}
+			if _, err := migrator.Up(context.Background()); err != nil {
+				log.Fatalf("SQLite 迁移失败: %v", err)
+			}
+			dataStore = store.NewSQLiteStore(db)
+		}
+	case "memory":
+		log.Println("使用内存存储 (MemStore)，数据不会持久化")
+		dataStore = store.NewMemStore()
+	}
+
+	// 后台任务 (投放索引、调度器、邮件投递) 共用的 context，main 退出时停止
+	schedCtx, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+
+	// --- 加载广告投放索引 (可投放活动及其创意的内存快照，GET /get-ad 从中选择广告) ---
+	selector, err := serving.NewSelector(cfg.Serving.Selector)
+	if err != nil {
+		log.Fatalf("serving.selector 无效: %v", err)
+	}
+	reservePrice, _ := money.ParseAmount(cfg.Serving.ReservePrice) // 已在 config.Validate 中校验
+	servingIndex := serving.NewIndex(dataStore, serving.Options{
+		RefreshInterval: cfg.Serving.RefreshInterval.Duration,
+		Selector:        selector,
+		CTR:             serving.CTRModel{PriorCTR: cfg.Serving.DefaultCTR, PriorImpressions: float64(cfg.Serving.CTRPriorImpressions)},
+		ReservePrice:    reservePrice,
+	})
+	if err := servingIndex.Refresh(context.Background()); err != nil {
+		log.Fatalf("加载投放索引失败: %v", err)
+	}
+	size, _ := servingIndex.Stats()
+	log.Printf("投放索引已加载，当前可投放的广告活动: %d 个 (选择策略: %s)", size, cfg.Serving.Selector)
+	go servingIndex.Run(schedCtx)
+
+	// --- 广告活动定向：GeoIP 数据库 (国家/地区) 和投放时段使用的时区 ---
+	targetingLocation, err := time.LoadLocation(cfg.Targeting.Timezone)
+	if err != nil {
+		log.Fatalf("targeting.timezone 无效: %v", err)
+	}
+	var geo targeting.GeoResolver
+	if cfg.Targeting.GeoIPDB != "" {
+		geoDB, err := targeting.OpenMMDB(cfg.Targeting.GeoIPDB)
+		if err != nil {
+			log.Fatalf("加载 GeoIP 数据库失败: %v", err)
+		}
+		defer geoDB.Close()
+		log.Printf("GeoIP 数据库已加载: %s (%s)", cfg.Targeting.GeoIPDB, geoDB.DatabaseType())
+		geo = geoDB
+	} else {
+		log.Println("未配置 GeoIP 数据库 (targeting.geoip_db)，广告活动不能按国家或地区定向")
+	}
+	targeter := targeting.New(geo, targetingLocation)
+
+	// --- 频次控制：按访问者 (Cookie 或 device_id) 记录展示，展示记录保存在进程内存中 ---
+	viewerStore := frequency.NewMemoryStore(cfg.Frequency.MaxWindow.Duration, cfg.Frequency.MaxViewers)
+	go viewerStore.Run(schedCtx) // 定期清理过期的访问者
+	capper := frequency.New(
+		viewerStore,
+		cfg.Frequency.MaxWindow.Duration,
+		frequency.CookieOptions{Name: cfg.Frequency.CookieName, MaxAge: cfg.Frequency.CookieMaxAge.Duration, Secure: cfg.Frequency.CookieSecure},
+	)
+
+	// --- 启动广告活动调度器 (Approved -> Active -> Completed) ---
+	campaignScheduler := scheduler.NewCampaignScheduler(dataStore, cfg.Scheduler.Interval.Duration)
+	campaignScheduler.OnChange = servingIndex.Invalidate // 活动开始投放或结束后刷新投放索引
+	go campaignScheduler.Run(schedCtx)
+	// 定期重新读取密钥目录，轮换密钥 (keys rotate) 后不需要重启服务
+	go reloadKeysPeriodically(schedCtx, keysReloadInterval)
+
+	// --- 启动邮件投递器 (发件箱 email_outbox -> mail.driver) ---
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("邮件配置无效: %v", err)
+	}
+	go mail.NewDispatcher(dataStore, mailer, cfg.Mail.Interval.Duration, cfg.Mail.MaxAttempts).Run(schedCtx)
 
 	// --- 创建 Handler 实例，注入 Store ---
-	h := handlers.NewHandler(dataStore) // 将 Store 实例传递给 Handler
+	// 点击凭证 (serving.click_token_secret)：GET /get-ad 签发，点击时校验并按凭证去重
+	clickSigner := serving.NewClickSigner(cfg.Serving.ClickTokenSecret, cfg.Serving.ClickTokenTTL.Duration)
+	// 支付渠道 (payment.provider)，目前只有本地 mock 渠道，可以用 cmd/mockpay 发送回调
+	payments := payment.NewMockProvider(cfg.Payment.WebhookSecret, cfg.Payment.WebhookTolerance.Duration)
+	if cfg.IsProduction() {
+		log.Printf("警告: production 模式下使用 mock 支付渠道 (payment.allow_mock_in_production)，充值只能通过签名回调手工入账")
+	}
+	// 展示事件在后台记录并扣费 (serving.event_workers)，GET /get-ad 不等待数据库；服务停止时先记录完队列中的事件
+	var eventRecorder *serving.Recorder
+	if cfg.Serving.EventWorkers > 0 {
+		eventRecorder = serving.NewRecorder(dataStore, servingIndex, cfg.Serving.EventWorkers, cfg.Serving.EventQueueSize, cfg.Serving.EventPendingLimit)
+		log.Printf("展示事件在后台记录: %d 个 worker，队列容量 %d，每个活动最多 %d 个等待记录",
+			cfg.Serving.EventWorkers, cfg.Serving.EventQueueSize, cfg.Serving.EventPendingLimit)
+	} else {
+		log.Println("展示事件同步记录 (serving.event_workers = 0)")
+	}
+	h := handlers.NewHandler(dataStore, payments, cfg.Mail, cfg.TwoFactor, newLoginThrottler(cfg.LoginThrottle, dataStore), servingIndex, targeter, capper, clickSigner, eventRecorder) // 将 Store 实例传递给 Handler
 
 // --- 定义需要认证和授权的 Handler ---
 	// 基础认证
-	authHandler := middleware.AuthMiddleware
+	authHandler := middleware.AuthMiddleware(dataStore) // 校验签名、有效期和吊销列表
+	// 必须启用两步验证但尚未绑定的账号只能拿到受限令牌，只能访问绑定两步验证的接口和登出
+	enrollmentHandler := middleware.EnrollmentAuthMiddleware(dataStore)
 
-	// 管理员认证 (先认证 Auth，再检查 Admin)
-    // 更正：应该是先 Auth 再 Admin！ Auth 负责解析 token 放入 context，Admin 负责从 context 取出并检查 Role
-    adminRequiredHandler := func(next http.Handler) http.Handler {
-        return authHandler(middleware.AdminMiddleware(next)) // 正确顺序: Auth -> Admin -> Handler
+	// 后台接口按权限控制 (先认证 Auth，再检查权限)
+    // Auth 负责解析 token 放入 context，RequirePermission 负责从 context 取出 Role 并检查是否拥有该权限
+    requirePermission := func(perm string) func(http.Handler) http.Handler {
+        check := middleware.RequirePermission(perm)
+        return func(next http.Handler) http.Handler {
+            return authHandler(check(next)) // 正确顺序: Auth -> Permission -> Handler
+        }
     }
 
+	// 广告、活动、充值、发票等资源属于组织 (Auth -> Organization -> Handler)
+	// Organization 按 X-Organization-ID (默认组织) 确定当前组织，检查成员身份，viewer 只能读
+	organization := middleware.OrganizationMiddleware(dataStore)
+	orgHandler := func(next http.Handler) http.Handler {
+		return authHandler(organization(next))
+	}
+
+	// 涉及资金的 POST 接口支持 Idempotency-Key (Auth -> Organization -> Idempotency -> Handler，幂等键按用户隔离)
+	idempotent := middleware.Idempotency(dataStore)
+	idempotentOrgHandler := func(next http.Handler) http.Handler {
+		return orgHandler(idempotent(next))
+	}
+
 
 	// --- 注册路由 (使用 Go 1.22+ Mux) ---
 	mux := http.NewServeMux()
@@ -75,67 +348,148 @@ func main() {
 	// 公开接口
 	mux.HandleFunc("POST /register", h.RegisterHandler)
 	mux.HandleFunc("POST /login", h.LoginHandler)  
+	mux.HandleFunc("POST /login/2fa", h.TwoFactorLoginHandler)   // 两步登录：用 mfa_token + 验证码换取令牌
+	mux.HandleFunc("POST /token/refresh", h.RefreshTokenHandler) // 用刷新令牌换取新的令牌对
+	mux.HandleFunc("POST /email/verify", h.VerifyEmailHandler)                // 使用验证邮件中的令牌验证邮箱
+	mux.HandleFunc("POST /email/verify/resend", h.ResendVerificationHandler) // 重新发送验证邮件
+	mux.HandleFunc("POST /password/forgot", h.ForgotPasswordHandler)         // 发送重置密码邮件
+	mux.HandleFunc("POST /password/reset", h.ResetPasswordHandler)           // 使用重置密码邮件中的令牌设置新密码
+	mux.HandleFunc("GET /.well-known/jwks.json", h.JWKSHandler)  // 访问令牌的校验公钥
 	mux.HandleFunc("GET /get-ad", h.GetAdHandler) // 广告位获取广告（会记录Impression）
 	// --- (可选/模拟) 广告点击处理 ---
 	// 注意：这个接口通常不需要用户认证
     mux.HandleFunc("GET /ads/click/{campaign_id}/{advertisement_id}", h.AdClickHandler)
+	mux.HandleFunc("POST /payments/webhook", h.PaymentWebhookHandler) // 支付渠道回调 (签名校验，不需要 JWT)
 
 
 	// 需要普通认证的接口
 	//mux.Handle("GET /get-ad", authHandler(http.HandlerFunc(h.GetAdHandler)))
-	mux.Handle("POST /ads", authHandler(http.HandlerFunc(h.SubmitAdHandler)))
-	mux.Handle("GET /my-ads", authHandler(http.HandlerFunc(h.GetUserAdsHandler)))
-	mux.Handle("POST /campaigns", authHandler(http.HandlerFunc(h.RequestCampaignHandler)))
+	mux.Handle("POST /logout", enrollmentHandler(http.HandlerFunc(h.LogoutHandler)))
+	mux.Handle("GET /2fa", enrollmentHandler(http.HandlerFunc(h.GetTwoFactorStatusHandler)))
+	mux.Handle("POST /2fa/totp/setup", enrollmentHandler(http.HandlerFunc(h.SetupTOTPHandler)))
+	mux.Handle("POST /2fa/totp/enable", enrollmentHandler(http.HandlerFunc(h.EnableTOTPHandler)))
+	mux.Handle("POST /2fa/totp/disable", authHandler(http.HandlerFunc(h.DisableTOTPHandler)))
+	mux.Handle("POST /2fa/recovery-codes", authHandler(http.HandlerFunc(h.RegenerateRecoveryCodesHandler)))
+	mux.Handle("PUT /2fa/settings", authHandler(http.HandlerFunc(h.UpdateTwoFactorSettingsHandler)))
+	mux.Handle("POST /logout/all", authHandler(http.HandlerFunc(h.LogoutAllHandler)))
+	mux.Handle("POST /api-keys", authHandler(http.HandlerFunc(h.CreateAPIKeyHandler)))
+	mux.Handle("GET /api-keys", authHandler(http.HandlerFunc(h.ListAPIKeysHandler)))
+	mux.Handle("DELETE /api-keys/{id}", authHandler(http.HandlerFunc(h.RevokeAPIKeyHandler)))
+	// 组织、成员和邀请 (路径中的组织 ID 由 handler 检查成员身份和角色)
+	mux.Handle("POST /organizations", authHandler(http.HandlerFunc(h.CreateOrganizationHandler)))
+	mux.Handle("GET /organizations", authHandler(http.HandlerFunc(h.ListOrganizationsHandler)))
+	mux.Handle("GET /organizations/{id}/members", authHandler(http.HandlerFunc(h.ListOrganizationMembersHandler)))
+	mux.Handle("PATCH /organizations/{id}/members/{user_id}", authHandler(http.HandlerFunc(h.UpdateOrganizationMemberHandler)))
+	mux.Handle("DELETE /organizations/{id}/members/{user_id}", authHandler(http.HandlerFunc(h.RemoveOrganizationMemberHandler)))
+	mux.Handle("POST /organizations/{id}/invitations", authHandler(http.HandlerFunc(h.InviteOrganizationMemberHandler)))
+	mux.Handle("GET /organizations/{id}/invitations", authHandler(http.HandlerFunc(h.ListOrganizationInvitationsHandler)))
+	mux.Handle("DELETE /organizations/{id}/invitations/{invitation_id}", authHandler(http.HandlerFunc(h.RevokeOrganizationInvitationHandler)))
+	mux.Handle("GET /invitations", authHandler(http.HandlerFunc(h.ListMyInvitationsHandler)))
+	mux.Handle("POST /invitations/{id}/accept", authHandler(http.HandlerFunc(h.AcceptInvitationHandler)))
+	mux.Handle("POST /invitations/{id}/decline", authHandler(http.HandlerFunc(h.DeclineInvitationHandler)))
+	mux.Handle("GET /placements", authHandler(http.HandlerFunc(h.ListPlacementsHandler)))
+	mux.Handle("POST /ads", orgHandler(http.HandlerFunc(h.SubmitAdHandler)))
+	mux.Handle("GET /my-ads", orgHandler(http.HandlerFunc(h.GetUserAdsHandler)))
+	mux.Handle("POST /campaigns", idempotentOrgHandler(http.HandlerFunc(h.RequestCampaignHandler)))
     // --- 新增：充值和余额接口 ---
-    mux.Handle("POST /recharge", authHandler(http.HandlerFunc(h.RechargeHandler)))
-    mux.Handle("GET /balance", authHandler(http.HandlerFunc(h.GetBalanceHandler)))
-    mux.Handle("GET /recharges", authHandler(http.HandlerFunc(h.GetRechargeHistoryHandler)))
+    mux.Handle("POST /recharge", idempotentOrgHandler(http.HandlerFunc(h.RechargeHandler)))
+    mux.Handle("GET /balance", orgHandler(http.HandlerFunc(h.GetBalanceHandler)))
+    mux.Handle("GET /recharges", orgHandler(http.HandlerFunc(h.GetRechargeHistoryHandler)))
+	mux.Handle("GET /recharges/{id}", orgHandler(http.HandlerFunc(h.GetRechargeDetailsHandler)))
+	mux.Handle("GET /ledger", orgHandler(http.HandlerFunc(h.GetLedgerHandler)))
 	// --- 新增：用户管理自己的广告活动 ---
-	mux.Handle("GET /my-campaigns", authHandler(http.HandlerFunc(h.GetUserCampaignsHandler)))
-	mux.Handle("GET /my-campaigns/{id}", authHandler(http.HandlerFunc(h.GetUserCampaignDetailsHandler)))
-	mux.Handle("PATCH /my-campaigns/{id}/cancel", authHandler(http.HandlerFunc(h.CancelCampaignHandler)))
+	mux.Handle("GET /my-campaigns", orgHandler(http.HandlerFunc(h.GetUserCampaignsHandler)))
+	mux.Handle("GET /my-campaigns/{id}", orgHandler(http.HandlerFunc(h.GetUserCampaignDetailsHandler)))
+	mux.Handle("PATCH /my-campaigns/{id}/cancel", orgHandler(http.HandlerFunc(h.CancelCampaignHandler)))
+	mux.Handle("PATCH /my-campaigns/{id}/pause", orgHandler(http.HandlerFunc(h.PauseCampaignHandler)))
+	mux.Handle("PATCH /my-campaigns/{id}/resume", orgHandler(http.HandlerFunc(h.ResumeCampaignHandler)))
+	mux.Handle("GET /my-campaigns/{id}/history", orgHandler(http.HandlerFunc(h.GetUserCampaignHistoryHandler)))
 	// --- 新增：用户查看广告效果 ---
-	mux.Handle("GET /my-performance", authHandler(http.HandlerFunc(h.GetAdPerformanceHandler)))
+	mux.Handle("GET /my-performance", orgHandler(http.HandlerFunc(h.GetAdPerformanceHandler)))
 	// --- 新增：发票相关接口 ---
-	mux.Handle("POST /invoices/request", authHandler(http.HandlerFunc(h.RequestInvoiceHandler)))
-	mux.Handle("GET /invoices", authHandler(http.HandlerFunc(h.GetUserInvoicesHandler)))
-	mux.Handle("GET /invoices/{id}", authHandler(http.HandlerFunc(h.GetUserInvoiceDetailsHandler)))
+	mux.Handle("POST /invoices/request", idempotentOrgHandler(http.HandlerFunc(h.RequestInvoiceHandler)))
+	mux.Handle("GET /invoices", orgHandler(http.HandlerFunc(h.GetUserInvoicesHandler)))
+	mux.Handle("GET /invoices/{id}", orgHandler(http.HandlerFunc(h.GetUserInvoiceDetailsHandler)))
  
     // --- 新增：管理员获取待审核列表的接口 ---
-    mux.Handle("GET /admin/ads/pending", adminRequiredHandler(http.HandlerFunc(h.AdminGetPendingAdsHandler)))
-    mux.Handle("GET /admin/campaigns/pending", adminRequiredHandler(http.HandlerFunc(h.AdminGetPendingCampaignsHandler)))
-	// 需要管理员认证的接口
-	mux.Handle("PATCH /ads/{id}/status", adminRequiredHandler(http.HandlerFunc(h.ReviewAdHandler)))
-	mux.Handle("PATCH /campaigns/{id}/status", adminRequiredHandler(http.HandlerFunc(h.ReviewCampaignHandler)))
-    // --- (可选) 管理员处理发票接口 ---
-    // mux.Handle("PATCH /admin/invoices/{id}/status", adminRequiredHandler(http.HandlerFunc(h.AdminUpdateInvoiceStatusHandler))) // 需要实现 AdminUpdateInvoiceStatusHandler
+    mux.Handle("GET /admin/ads/pending", requirePermission(auth.PermAdsReview)(http.HandlerFunc(h.AdminGetPendingAdsHandler)))
+    mux.Handle("GET /admin/campaigns/pending", requirePermission(auth.PermCampaignsReview)(http.HandlerFunc(h.AdminGetPendingCampaignsHandler)))
+	// 需要后台权限的接口
+	mux.Handle("PATCH /ads/{id}/status", requirePermission(auth.PermAdsReview)(http.HandlerFunc(h.ReviewAdHandler)))
+	mux.Handle("PATCH /campaigns/{id}/status", requirePermission(auth.PermCampaignsReview)(http.HandlerFunc(h.ReviewCampaignHandler)))
+	mux.Handle("GET /admin/campaigns/{id}/history", requirePermission(auth.PermCampaignsRead)(http.HandlerFunc(h.AdminGetCampaignHistoryHandler)))
+	mux.Handle("POST /admin/ledger/entries", requirePermission(auth.PermLedgerWrite)(http.HandlerFunc(h.AdminPostLedgerEntryHandler)))
+	mux.Handle("GET /admin/ledger/reconcile", requirePermission(auth.PermLedgerRead)(http.HandlerFunc(h.AdminReconcileBalancesHandler)))
+    mux.Handle("PATCH /admin/invoices/{id}/status", requirePermission(auth.PermInvoicesProcess)(http.HandlerFunc(h.AdminUpdateInvoiceStatusHandler)))
+	mux.Handle("GET /admin/roles", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminListRolesHandler)))
+	mux.Handle("GET /admin/users", requirePermission(auth.PermUsersRead)(http.HandlerFunc(h.AdminListUsersHandler)))
+	mux.Handle("GET /admin/users/{id}", requirePermission(auth.PermUsersRead)(http.HandlerFunc(h.AdminGetUserHandler)))
+	mux.Handle("PUT /admin/users/{id}/role", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminAssignRoleHandler)))
+	mux.Handle("POST /admin/users/{id}/suspend", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminSuspendUserHandler)))
+	mux.Handle("POST /admin/users/{id}/unsuspend", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminUnsuspendUserHandler)))
+	mux.Handle("POST /admin/users/{id}/balance-adjustments", requirePermission(auth.PermLedgerWrite)(http.HandlerFunc(h.AdminAdjustUserBalanceHandler)))
+	mux.Handle("PUT /admin/users/{id}/2fa", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminSetTwoFactorRequiredHandler)))
+	mux.Handle("DELETE /admin/users/{id}/2fa", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminResetTwoFactorHandler)))
+	mux.Handle("POST /admin/users/{id}/unlock", requirePermission(auth.PermUsersManage)(http.HandlerFunc(h.AdminUnlockUserHandler)))
+	mux.Handle("GET /admin/audit", requirePermission(auth.PermAuditRead)(http.HandlerFunc(h.AdminListAuditHandler)))
+	mux.Handle("GET /admin/placements", requirePermission(auth.PermPlacementsManage)(http.HandlerFunc(h.AdminListPlacementsHandler)))
+	mux.Handle("POST /admin/placements", requirePermission(auth.PermPlacementsManage)(http.HandlerFunc(h.AdminCreatePlacementHandler)))
+	mux.Handle("GET /admin/placements/{id}", requirePermission(auth.PermPlacementsManage)(http.HandlerFunc(h.AdminGetPlacementHandler)))
+	mux.Handle("PUT /admin/placements/{id}", requirePermission(auth.PermPlacementsManage)(http.HandlerFunc(h.AdminUpdatePlacementHandler)))
 	
 	c := cors.New(cors.Options{
-        AllowedOrigins: []string{"http://localhost:5173"}, // 允许来自前端的地址
+        AllowedOrigins: cfg.CORS.AllowedOrigins, // 允许来自前端的地址 (cors.allowed_origins)
         AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, // 允许的 HTTP 方法
-        AllowedHeaders: []string{"Authorization", "Content-Type"}, // 允许的请求头
+        AllowedHeaders: []string{"Authorization", "Content-Type", middleware.OrganizationHeader, middleware.IdempotencyKeyHeader, middleware.RequestIDHeader}, // 允许的请求头
+        ExposedHeaders: []string{middleware.IdempotentReplayedHeader, middleware.RequestIDHeader}, // 前端可以读取响应是否为幂等重放和请求 ID (便于排查问题)
         AllowCredentials: true, // 允许携带认证信息
-        Debug: true, // 开启 Debug 模式，可以在后端终端看到 CORS 相关的日志
+        Debug: cfg.CORS.Debug, // 开启 Debug 模式，可以在后端终端看到 CORS 相关的日志 (cors.debug)
     })
 
     // 使用 CORS 中间件包裹您的 Mux
     handler := c.Handler(mux)
+	// 确定客户端 IP (登录限流按 IP 统计)；server.trust_proxy_headers 开启时信任反向代理的 X-Forwarded-For
+	handler = middleware.ClientIP(cfg.Server.TrustProxyHeaders)(handler)
+	// 为每个请求分配请求 ID (X-Request-ID)，审计日志据此关联请求；同样只在信任反向代理时沿用请求中的 ID
+	handler = middleware.RequestID(cfg.Server.TrustProxyHeaders)(handler)
 	
 	// 启动服务器
-	port := ":8080"
+	port := cfg.Server.Addr // server.addr
 	log.Printf("服务器启动，监听端口 %s", port)
 	log.Println("可用接口:")
 	log.Printf("  POST http://localhost%s/register (公开)", port)
 	log.Printf("  POST http://localhost%s/login    (公开)", port)
-	log.Printf("  GET  http://localhost%s/get-ad   (公开, 广告位获取广告, 记录 Impression)", port)
-	log.Printf("  GET  http://localhost%s/ads/click/{cid}/{aid} (公开, 模拟广告点击, 记录 Click)", port)
+	log.Printf("  POST http://localhost%s/login/2fa (公开, 两步登录: mfa_token + 验证码)", port)
+	log.Printf("  POST http://localhost%s/token/refresh (公开, 用刷新令牌换取新的令牌对)", port)
+	log.Printf("  POST http://localhost%s/email/verify (公开, 验证邮箱)", port)
+	log.Printf("  POST http://localhost%s/email/verify/resend (公开, 重新发送验证邮件)", port)
+	log.Printf("  POST http://localhost%s/password/forgot (公开, 发送重置密码邮件)", port)
+	log.Printf("  POST http://localhost%s/password/reset (公开, 重置密码)", port)
+	log.Printf("  GET  http://localhost%s/.well-known/jwks.json (公开, 访问令牌校验公钥)", port)
+	log.Printf("  GET  http://localhost%s/get-ad   (公开, 广告位获取广告, 可选 ?placement=ID, 记录 Impression)", port)
+	log.Printf("  GET  http://localhost%s/ads/click/{cid}/{aid} (公开, 广告点击跟踪, 使用 GET /get-ad 返回的 click_url, 凭证有效时记录 Click)", port)
+	log.Printf("  POST http://localhost%s/payments/webhook (支付渠道回调, HMAC 签名)", port)
 	//log.Printf("  GET  http://localhost%s/get-ad  (需要认证)", port)
+	log.Printf("  POST http://localhost%s/logout   (需要认证, 登出当前会话)", port)
+	log.Printf("  GET  http://localhost%s/2fa      (需要认证, 两步验证状态)", port)
+	log.Printf("  POST http://localhost%s/2fa/totp/setup (需要认证, 生成 TOTP 密钥和二维码地址)", port)
+	log.Printf("  POST http://localhost%s/2fa/totp/enable (需要认证, 确认绑定并获取恢复码)", port)
+	log.Printf("  POST http://localhost%s/2fa/totp/disable (需要认证, 关闭两步验证)", port)
+	log.Printf("  POST http://localhost%s/2fa/recovery-codes (需要认证, 重新生成恢复码)", port)
+	log.Printf("  PUT  http://localhost%s/2fa/settings (需要认证, 设置账号是否必须使用两步验证)", port)
+	log.Printf("  POST http://localhost%s/logout/all (需要认证, 登出所有会话)", port)
+	log.Printf("  POST http://localhost%s/api-keys (需要认证, 创建 API Key)", port)
+	log.Printf("  GET  http://localhost%s/api-keys (需要认证, 查看 API Key)", port)
+	log.Printf("  DELETE http://localhost%s/api-keys/{id} (需要认证, 吊销 API Key)", port)
+	log.Printf("  GET  http://localhost%s/placements (需要认证, 查看启用的广告位及尺寸和格式)", port)
 	log.Printf("  POST http://localhost%s/ads      (需要认证)", port)
 	log.Printf("  GET  http://localhost%s/my-ads  (需要认证)", port)
 	log.Printf("  POST http://localhost%s/campaigns (需要认证)", port)
     log.Printf("  POST http://localhost%s/recharge (需要认证)", port) // <-- 更新日志
     log.Printf("  GET  http://localhost%s/balance  (需要认证)", port) // <-- 更新日志
 	log.Printf("  GET  http://localhost%s/recharges (需要认证)", port) // <-- 更新日志
+	log.Printf("  GET  http://localhost%s/recharges/{id} (需要认证, 充值详情及支付渠道状态)", port)
+	log.Printf("  GET  http://localhost%s/ledger   (需要认证, 分页查看账本分录)", port)
 	log.Printf("  GET  http://localhost%s/my-performance   (需要认证, 用户查看广告效果)", port) // <-- 更新日志
 	log.Printf("  POST http://localhost%s/invoices/request (需要认证, 用户请求开票)", port) // <-- 更新日志
     log.Printf("  GET  http://localhost%s/invoices        (需要认证, 用户查看发票历史)", port) // <-- 更新日志
@@ -143,14 +497,56 @@ func main() {
 	log.Printf("  GET  http://localhost%s/my-campaigns (需要认证, 用户查看自己的活动列表)", port) // <-- 更新日志
     log.Printf("  GET  http://localhost%s/my-campaigns/{id} (需要认证, 用户查看活动详情)", port) // <-- 更新日志
     log.Printf("  PATCH http://localhost%s/my-campaigns/{id}/cancel (需要认证, 用户取消活动)", port) // <-- 更新日志
-	log.Printf("  PATCH http://localhost%s/ads/{id}/status (需要管理员认证)", port)
-	log.Printf("  PATCH http://localhost%s/campaigns/{id}/status (需要管理员认证)", port)
-	log.Printf("  GET  http://localhost%s/admin/ads/pending (需要管理员认证, 获取待审核广告)", port)
-    log.Printf("  GET  http://localhost%s/admin/campaigns/pending (需要管理员认证, 获取待审核活动)", port)
+	log.Printf("  PATCH http://localhost%s/my-campaigns/{id}/pause  (需要认证, 用户暂停活动)", port)
+	log.Printf("  PATCH http://localhost%s/my-campaigns/{id}/resume (需要认证, 用户恢复已暂停的活动)", port)
+	log.Printf("  GET  http://localhost%s/my-campaigns/{id}/history (需要认证, 活动状态变更历史)", port)
+	log.Printf("  PATCH http://localhost%s/ads/{id}/status (需要 ads:review 权限)", port)
+	log.Printf("  PATCH http://localhost%s/campaigns/{id}/status (需要 campaigns:review 权限)", port)
+	log.Printf("  GET  http://localhost%s/admin/ads/pending (需要 ads:review 权限, 获取待审核广告)", port)
+    log.Printf("  GET  http://localhost%s/admin/campaigns/pending (需要 campaigns:review 权限, 获取待审核活动)", port)
+	log.Printf("  GET  http://localhost%s/admin/campaigns/{id}/history (需要 campaigns:read 权限, 活动状态变更历史)", port)
+	log.Printf("  POST http://localhost%s/admin/ledger/entries (需要 ledger:write 权限, 调账 / 赠送额度 / 退款)", port)
+	log.Printf("  GET  http://localhost%s/admin/ledger/reconcile (需要 ledger:read 权限, 核对余额与账本)", port)
+	log.Printf("  PATCH http://localhost%s/admin/invoices/{id}/status (需要 invoices:process 权限, 处理发票请求)", port)
+	log.Printf("  GET  http://localhost%s/admin/roles (需要 users:manage 权限, 角色及权限列表)", port)
+	log.Printf("  GET  http://localhost%s/admin/users (需要 users:read 权限, 按用户名 / 邮箱、角色和状态查询用户)", port)
+	log.Printf("  GET  http://localhost%s/admin/users/{id} (需要 users:read 权限, 用户详情及其广告、活动和充值记录)", port)
+	log.Printf("  PUT  http://localhost%s/admin/users/{id}/role (需要 users:manage 权限, 分配角色)", port)
+	log.Printf("  POST http://localhost%s/admin/users/{id}/suspend (需要 users:manage 权限, 停用账号)", port)
+	log.Printf("  POST http://localhost%s/admin/users/{id}/unsuspend (需要 users:manage 权限, 恢复账号)", port)
+	log.Printf("  POST http://localhost%s/admin/users/{id}/balance-adjustments (需要 ledger:write 权限, 调整用户余额)", port)
+	log.Printf("  PUT  http://localhost%s/admin/users/{id}/2fa (需要 users:manage 权限, 要求用户使用两步验证)", port)
+	log.Printf("  DELETE http://localhost%s/admin/users/{id}/2fa (需要 users:manage 权限, 重置用户的两步验证)", port)
+	log.Printf("  POST http://localhost%s/admin/users/{id}/unlock (需要 users:manage 权限, 解锁因登录失败被锁定的账号)", port)
+	log.Printf("  GET  http://localhost%s/admin/audit (需要 audit:read 权限, 按操作者、操作类型、对象和时间查询审计日志)", port)
+	log.Printf("  GET  http://localhost%s/admin/placements (需要 placements:manage 权限, 广告位列表)", port)
+	log.Printf("  POST http://localhost%s/admin/placements (需要 placements:manage 权限, 创建广告位)", port)
+	log.Printf("  GET  http://localhost%s/admin/placements/{id} (需要 placements:manage 权限, 广告位详情)", port)
+	log.Printf("  PUT  http://localhost%s/admin/placements/{id} (需要 placements:manage 权限, 修改或停用广告位)", port)
 
-	serveErr := http.ListenAndServe(port, handler) // <-- 修改为使用包裹后的 handler
+	// 收到 SIGINT / SIGTERM 时停止接收新请求，等待处理中的请求结束，再记录完后台队列中的展示事件
+	server := &http.Server{Addr: port, Handler: handler} // <-- 修改为使用包裹后的 handler
+	stopped := make(chan struct{}) // Shutdown 返回 (处理中的请求已结束) 后关闭
+	go func() {
+		defer close(stopped)
+		sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-sigCtx.Done()
+		log.Println("收到停止信号，正在停止服务器...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("停止服务器时出错: %v", err)
+		}
+	}()
+	serveErr := server.ListenAndServe()
 	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
 		log.Fatalf("服务器启动失败: %v", serveErr)
 	}
+	<-stopped
+	if eventRecorder != nil {
+		log.Printf("正在记录队列中的 %d 个展示事件...", eventRecorder.Pending())
+		eventRecorder.Close()
+	}
 	log.Println("服务器已停止。")
 }